@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+)
+
+// alertmanagerOutput posts an alert to Prometheus Alertmanager for each node
+// group (and, optionally, the whole cluster) with less schedulable resource
+// than the minimum, and for each priority tier missing its standby. Firing
+// alerts are re-posted every run with an end time ttl in the future, so they
+// resolve on their own if kubecap stops, and are explicitly resolved once
+// capacity recovers.
+type alertmanagerOutput struct {
+	url        string
+	min        int64
+	minCluster int64
+	ttl        time.Duration
+	client     *http.Client
+	firing     map[string]bool
+	md         *kubecap.Metadata
+	nodes      []*kubecap.NodeReport
+}
+
+func newAlertmanagerOutput(url string, min, minCluster int64, ttl time.Duration) *alertmanagerOutput {
+	return &alertmanagerOutput{
+		url:        strings.TrimSuffix(url, "/"),
+		min:        min,
+		minCluster: minCluster,
+		ttl:        ttl,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (a *alertmanagerOutput) Metadata(m *kubecap.Metadata) error {
+	a.md = m
+	a.nodes = nil
+
+	return nil
+}
+
+func (a *alertmanagerOutput) Node(n *kubecap.NodeReport) error {
+	a.nodes = append(a.nodes, n)
+
+	return nil
+}
+
+func (a *alertmanagerOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+// alertmanagerAlert is an alert in the Alertmanager v2 API.
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+func (a *alertmanagerOutput) Flush() error {
+	now := a.md.Timestamp
+
+	first := a.firing == nil
+	if first {
+		a.firing = map[string]bool{}
+	}
+
+	alerts := []alertmanagerAlert{}
+
+	for _, c := range kubecap.CapacityChecks(a.nodes, a.min, a.minCluster) {
+		labels := map[string]string{
+			"alertname": "KubecapNodeGroupLowSchedulableMemory",
+			"severity":  "critical",
+			"context":   a.md.Context,
+			"cluster":   a.md.Cluster,
+			"resource":  string(a.md.ResourceName()),
+		}
+
+		if c.Key == "cluster" {
+			labels["alertname"] = "KubecapClusterLowSchedulableMemory"
+		} else {
+			labels["node_group"] = strings.TrimPrefix(c.Key, "group/")
+		}
+
+		if !c.Breached() {
+			// Like PagerDuty, resolve anything left by a previous process
+			// on the first run.
+			if first || a.firing[c.Key] {
+				alerts = append(alerts, alertmanagerAlert{
+					Labels: labels,
+					EndsAt: now,
+				})
+
+				delete(a.firing, c.Key)
+			}
+
+			continue
+		}
+
+		alerts = append(alerts, alertmanagerAlert{
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf(
+					"%s in %s has %s schedulable %s (minimum %s)",
+					c.What, a.md.Context,
+					humanAmount(a.md, c.Sum.Schedulable), a.md.ResourceName(),
+					humanAmount(a.md, c.Min),
+				),
+				"description": fmt.Sprintf(
+					"%d nodes (%d with room for %s): allocatable %s, requests %s, used %s.",
+					c.Sum.Nodes, c.Sum.OkNodes, a.md.AdditionalInput,
+					humanAmount(a.md, c.Sum.Allocatable),
+					humanAmount(a.md, c.Sum.Requests),
+					humanAmount(a.md, c.Sum.Used),
+				),
+			},
+			EndsAt: now.Add(a.ttl),
+		})
+
+		a.firing[c.Key] = true
+	}
+
+	for _, sb := range a.md.StandbyStatus {
+		key := "standby/" + sb.PriorityClass
+
+		labels := map[string]string{
+			"alertname":      "KubecapStandbyCapacityMissing",
+			"severity":       "critical",
+			"context":        a.md.Context,
+			"cluster":        a.md.Cluster,
+			"priority_class": sb.PriorityClass,
+		}
+
+		if sb.Ok {
+			if first || a.firing[key] {
+				alerts = append(alerts, alertmanagerAlert{
+					Labels: labels,
+					EndsAt: now,
+				})
+
+				delete(a.firing, key)
+			}
+
+			continue
+		}
+
+		alerts = append(alerts, alertmanagerAlert{
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf(
+					"%s has room for %d of the %d standby %s pods of %s %s",
+					a.md.Context, sb.Available, sb.Replicas, sb.PriorityClass,
+					humanize.Comma(sb.Amount), a.md.Unit(),
+				),
+			},
+			EndsAt: now.Add(a.ttl),
+		})
+
+		a.firing[key] = true
+	}
+
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url+"/api/v2/alerts", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("posting alerts to alertmanager: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
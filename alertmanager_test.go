@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestAlertmanagerOutput(t *testing.T) {
+	var posted [][]alertmanagerAlert
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v2/alerts" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s with %q", r.Method, r.URL, r.Header.Get("Content-Type"))
+		}
+
+		alerts := []alertmanagerAlert{}
+
+		err := json.NewDecoder(r.Body).Decode(&alerts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		posted = append(posted, alerts)
+	}))
+	defer srv.Close()
+
+	// The trailing slash of the URL is dropped.
+	a := newAlertmanagerOutput(srv.URL+"/", 2<<30, 4<<30, 5*time.Minute)
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	flush := func(md *kubecap.Metadata, nodes ...*kubecap.NodeReport) {
+		t.Helper()
+
+		err := a.Metadata(md)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, n := range nodes {
+			err = a.Node(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = a.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flush(&kubecap.Metadata{
+		Context:         "prod",
+		Cluster:         "prod-eu",
+		Timestamp:       ts,
+		AdditionalInput: "1GiB",
+		StandbyStatus: []*kubecap.StandbyReport{
+			{PriorityClass: "critical", Amount: 4 << 30, Replicas: 2, Available: 1},
+		},
+	},
+		&kubecap.NodeReport{Name: "web-1", Group: "web", Allocatable: 8 << 30, Requests: 7 << 30, Used: 6 << 30, Schedulable: 1 << 30},
+		&kubecap.NodeReport{Name: "db-1", Group: "db", Allocatable: 8 << 30, Schedulable: 4 << 30, Ok: true},
+	)
+
+	if len(posted) != 1 {
+		t.Fatalf("posts = %d, want 1", len(posted))
+	}
+
+	// The first run resolves what a previous process may have left firing
+	// (db and the cluster, with 5GiB schedulable) with an end time of now,
+	// and fires web and the standby until the ttl is up.
+	want := []alertmanagerAlert{
+		{
+			Labels: map[string]string{
+				"alertname":  "KubecapNodeGroupLowSchedulableMemory",
+				"severity":   "critical",
+				"context":    "prod",
+				"cluster":    "prod-eu",
+				"resource":   "memory",
+				"node_group": "db",
+			},
+			EndsAt: ts,
+		},
+		{
+			Labels: map[string]string{
+				"alertname":  "KubecapNodeGroupLowSchedulableMemory",
+				"severity":   "critical",
+				"context":    "prod",
+				"cluster":    "prod-eu",
+				"resource":   "memory",
+				"node_group": "web",
+			},
+			Annotations: map[string]string{
+				"summary":     "Node group web in prod has 1.0 GiB schedulable memory (minimum 2.0 GiB)",
+				"description": "1 nodes (0 with room for 1GiB): allocatable 8.0 GiB, requests 7.0 GiB, used 6.0 GiB.",
+			},
+			EndsAt: ts.Add(5 * time.Minute),
+		},
+		{
+			Labels: map[string]string{
+				"alertname": "KubecapClusterLowSchedulableMemory",
+				"severity":  "critical",
+				"context":   "prod",
+				"cluster":   "prod-eu",
+				"resource":  "memory",
+			},
+			EndsAt: ts,
+		},
+		{
+			Labels: map[string]string{
+				"alertname":      "KubecapStandbyCapacityMissing",
+				"severity":       "critical",
+				"context":        "prod",
+				"cluster":        "prod-eu",
+				"priority_class": "critical",
+			},
+			Annotations: map[string]string{
+				"summary": "prod has room for 1 of the 2 standby critical pods of 4,294,967,296 bytes",
+			},
+			EndsAt: ts.Add(5 * time.Minute),
+		},
+	}
+
+	if !reflect.DeepEqual(posted[0], want) {
+		t.Errorf("first run =\n%+v\nwant\n%+v", posted[0], want)
+	}
+
+	// Once web recovers only it is resolved; the standby keeps firing with
+	// its end time moved on.
+	flush(&kubecap.Metadata{
+		Context:   "prod",
+		Cluster:   "prod-eu",
+		Timestamp: ts.Add(time.Minute),
+		StandbyStatus: []*kubecap.StandbyReport{
+			{PriorityClass: "critical", Amount: 4 << 30, Replicas: 2, Available: 1},
+		},
+	},
+		&kubecap.NodeReport{Name: "web-1", Group: "web", Allocatable: 8 << 30, Schedulable: 4 << 30, Ok: true},
+		&kubecap.NodeReport{Name: "db-1", Group: "db", Allocatable: 8 << 30, Schedulable: 4 << 30, Ok: true},
+	)
+
+	if len(posted) != 2 || len(posted[1]) != 2 {
+		t.Fatalf("second run = %+v", posted[1:])
+	}
+
+	if web := posted[1][0]; web.Labels["node_group"] != "web" || web.Annotations != nil || !web.EndsAt.Equal(ts.Add(time.Minute)) {
+		t.Errorf("web = %+v, want resolved", web)
+	}
+
+	if sb := posted[1][1]; sb.Labels["priority_class"] != "critical" || !sb.EndsAt.Equal(ts.Add(6*time.Minute)) {
+		t.Errorf("standby = %+v, want firing until %s", sb, ts.Add(6*time.Minute))
+	}
+
+	// A standby no longer required isn't posted again, so it expires with
+	// the ttl, and with nothing else firing or to resolve nothing is posted.
+	flush(&kubecap.Metadata{Context: "prod", Cluster: "prod-eu", Timestamp: ts.Add(2 * time.Minute)},
+		&kubecap.NodeReport{Name: "web-1", Group: "web", Allocatable: 8 << 30, Schedulable: 8 << 30, Ok: true},
+	)
+
+	if len(posted) != 2 {
+		t.Errorf("posted without alerts: %+v", posted[2:])
+	}
+}
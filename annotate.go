@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// annotationPrefix prefixes the annotations kubecap writes on the nodes.
+const annotationPrefix = "kubecap.io/"
+
+// annotateOutput writes each node's schedulable amount and verdict back onto
+// the Node as annotations, e.g. kubecap.io/schedulable-memory and
+// kubecap.io/ok, for other controllers and dashboards to read. Nodes are only
+// patched when their annotations change.
+type annotateOutput struct {
+	ctx context.Context
+	kcs kubernetes.Interface
+
+	md    *kubecap.Metadata
+	nodes []*kubecap.NodeReport
+
+	// written are the annotations last written on each node.
+	written map[string]map[string]string
+}
+
+func newAnnotateOutput(ctx context.Context, kcs kubernetes.Interface) *annotateOutput {
+	return &annotateOutput{
+		ctx:     ctx,
+		kcs:     kcs,
+		written: map[string]map[string]string{},
+	}
+}
+
+func (o *annotateOutput) Metadata(m *kubecap.Metadata) error {
+	o.md = m
+	o.nodes = nil
+
+	return nil
+}
+
+func (o *annotateOutput) Node(n *kubecap.NodeReport) error {
+	o.nodes = append(o.nodes, n)
+
+	return nil
+}
+
+func (o *annotateOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+// nodeAnnotations returns the annotations written on the node: its
+// schedulable amount of the report's resource, raw (bytes, or millicores for
+// CPU), and whether the additional amount fits.
+func nodeAnnotations(md *kubecap.Metadata, n *kubecap.NodeReport) map[string]string {
+	// Extended resources' names have a prefix of their own.
+	resource := strings.ReplaceAll(string(md.ResourceName()), "/", "-")
+
+	return map[string]string{
+		annotationPrefix + "schedulable-" + resource: fmt.Sprintf("%d", n.Schedulable),
+		annotationPrefix + "ok":                      fmt.Sprintf("%t", n.Ok),
+	}
+}
+
+func (o *annotateOutput) Flush() error {
+	for _, n := range o.nodes {
+		annotations := nodeAnnotations(o.md, n)
+		if reflect.DeepEqual(o.written[n.Name], annotations) {
+			continue
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": annotations,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = o.kcs.CoreV1().Nodes().Patch(o.ctx, n.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: "kubecap"})
+		if err != nil {
+			return fmt.Errorf("annotate node %s: %w", n.Name, err)
+		}
+
+		o.written[n.Name] = annotations
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestAnnotateOutput(t *testing.T) {
+	patches := map[string]map[string]string{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.Header.Get("Content-Type") != "application/merge-patch+json" {
+			t.Errorf("%s %s: not a merge patch", r.Method, r.URL)
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var patch struct {
+			Metadata struct {
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		}
+
+		err = json.Unmarshal(body, &patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		patches[r.URL.Path] = patch.Metadata.Annotations
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	kcs, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := newAnnotateOutput(context.Background(), kcs)
+
+	report := func(nodes ...*kubecap.NodeReport) {
+		err := out.Metadata(&kubecap.Metadata{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, n := range nodes {
+			err = out.Node(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = out.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report(
+		&kubecap.NodeReport{Name: "node-a", Schedulable: 4 << 30, Ok: true},
+		&kubecap.NodeReport{Name: "node-b", Schedulable: 1 << 30},
+	)
+
+	want := map[string]map[string]string{
+		"/api/v1/nodes/node-a": {"kubecap.io/schedulable-memory": "4294967296", "kubecap.io/ok": "true"},
+		"/api/v1/nodes/node-b": {"kubecap.io/schedulable-memory": "1073741824", "kubecap.io/ok": "false"},
+	}
+
+	if !reflect.DeepEqual(patches, want) {
+		t.Errorf("patches = %v, want %v", patches, want)
+	}
+
+	// Only the node whose annotations changed is patched again.
+	patches = map[string]map[string]string{}
+
+	report(
+		&kubecap.NodeReport{Name: "node-a", Schedulable: 4 << 30, Ok: true},
+		&kubecap.NodeReport{Name: "node-b", Schedulable: 2 << 30},
+	)
+
+	want = map[string]map[string]string{
+		"/api/v1/nodes/node-b": {"kubecap.io/schedulable-memory": "2147483648", "kubecap.io/ok": "false"},
+	}
+
+	if !reflect.DeepEqual(patches, want) {
+		t.Errorf("second report: patches = %v, want %v", patches, want)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// atomicFile is written to a temporary file alongside the destination and
+// only renamed into place on Commit, so readers never see a partial file.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+func createAtomic(path string) (*atomicFile, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &atomicFile{File: f, path: path}, nil
+}
+
+// Commit syncs and closes the temporary file and renames it over the
+// destination.
+func (a *atomicFile) Commit() (err error) {
+	defer func() {
+		if err != nil {
+			os.Remove(a.Name())
+		}
+	}()
+
+	err = a.Sync()
+	if err != nil {
+		a.Close()
+		return err
+	}
+
+	err = a.Close()
+	if err != nil {
+		return err
+	}
+
+	// TempFile creates the file 0600; reports are meant to be shared.
+	err = os.Chmod(a.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(a.Name(), a.path)
+}
+
+// Abort closes and removes the temporary file, leaving any existing
+// destination untouched.
+func (a *atomicFile) Abort() {
+	a.Close()
+	os.Remove(a.Name())
+}
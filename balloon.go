@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// balloonPriorityClass is the PriorityClass of the balloon pods. Its
+// negative priority lets any other pod preempt them.
+const balloonPriorityClass = "kubecap-overprovisioning"
+
+// balloonMain implements the balloon subcommand, which prints the
+// overprovisioning placeholder ("balloon") Deployments that keep headroom
+// schedulable with the cluster-autoscaler.
+func balloonMain(args []string) {
+	fs := flag.NewFlagSet("balloon", flag.ExitOnError)
+	print := fs.Bool("print", false, "print the manifests (pipe them to kubectl apply -f -)")
+	headroomStr := fs.String("headroom", "", "headroom to keep schedulable as COUNTxSIZE, e.g. 2x8GiB")
+	per := fs.String("per", "zone", "keep the headroom per zone or for the whole cluster")
+	namespace := fs.String("namespace", "kubecap", "namespace to run the balloon pods in")
+	image := fs.String("image", "registry.k8s.io/pause:3.9", "balloon container image")
+	kubeconfig, kcontext := clusterFlags(fs)
+	fs.Parse(args)
+
+	if !*print {
+		panic("balloon only prints the manifests: use --print")
+	}
+
+	count, size, err := parseHeadroom(*headroomStr)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if *per != "zone" && *per != "cluster" {
+		panic(fmt.Sprintf("unknown balloon scope: %q", *per))
+	}
+
+	c, err := newCluster(*kubeconfig, *kcontext)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	plan, err := planBalloons(context.TODO(), c.kcs, count, size, *per == "zone")
+	if err != nil {
+		panic(err.Error())
+	}
+
+	plan.Namespace = *namespace
+	plan.Image = *image
+
+	err = plan.write(os.Stdout)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// parseHeadroom parses COUNTxSIZE.
+func parseHeadroom(s string) (count int, size int64, err error) {
+	parts := strings.SplitN(s, "x", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid headroom %q: expected COUNTxSIZE, e.g. 2x8GiB", s)
+	}
+
+	count, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("invalid headroom count in %q", s)
+	}
+
+	bytes, err := humanize.ParseBytes(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid headroom size in %q: %w", s, err)
+	}
+
+	return count, int64(bytes), nil
+}
+
+// balloonPlan is the balloon Deployments to keep the headroom schedulable.
+type balloonPlan struct {
+	Namespace string
+	Image     string
+	Size      int64
+	Balloons  []*balloonDeployment
+}
+
+// balloonDeployment holds the headroom of a zone (or the cluster when Zone
+// is empty).
+type balloonDeployment struct {
+	Zone     string
+	Replicas int
+
+	// Fit is how many balloons fit in the nodes' schedulable memory now.
+	// Those that don't will have the cluster-autoscaler add nodes.
+	Fit int
+
+	// Largest is the most allocatable memory of any node. Balloons larger
+	// than that can never be scheduled.
+	Largest int64
+}
+
+// Name is the Deployment's name.
+func (b *balloonDeployment) Name() string {
+	if b.Zone == "" {
+		return "kubecap-balloon"
+	}
+
+	return "kubecap-balloon-" + strings.ToLower(filenameUnsafe.ReplaceAllString(b.Zone, "-"))
+}
+
+// planBalloons sizes the balloons from the nodes' schedulable memory. Memory
+// requested by existing balloon pods is counted as schedulable since other
+// pods preempt them.
+func planBalloons(ctx context.Context, kcs kubernetes.Interface, count int, size int64, perZone bool) (*balloonPlan, error) {
+	nodeList, err := kcs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	podList, podLevel, err := kubecap.ListPods(ctx, kcs)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := &corev1.PodList{}
+	for _, pod := range podList.Items {
+		if pod.Spec.PriorityClassName != balloonPriorityClass {
+			pods.Items = append(pods.Items, pod)
+		}
+	}
+
+	nps := kubecap.NewNodePods(pods)
+
+	plan := &balloonPlan{Size: size}
+	byZone := map[string]*balloonDeployment{}
+
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if node.Spec.Unschedulable {
+			continue
+		}
+
+		zone := ""
+		if perZone {
+			zone = node.Labels[kubecap.ZoneLabel]
+			if zone == "" {
+				continue
+			}
+		}
+
+		b, ok := byZone[zone]
+		if !ok {
+			b = &balloonDeployment{Zone: zone, Replicas: count}
+			byZone[zone] = b
+			plan.Balloons = append(plan.Balloons, b)
+		}
+
+		allocatable := node.Status.Allocatable.Memory().Value()
+		if allocatable > b.Largest {
+			b.Largest = allocatable
+		}
+
+		schedulable := allocatable - nps.MemoryRequests(node.Name, podLevel).Value()
+		if schedulable > 0 {
+			b.Fit += int(schedulable / size)
+		}
+	}
+
+	if len(plan.Balloons) == 0 {
+		return nil, fmt.Errorf("no schedulable nodes (with a %s label)", kubecap.ZoneLabel)
+	}
+
+	sort.Slice(plan.Balloons, func(i, j int) bool {
+		return plan.Balloons[i].Zone < plan.Balloons[j].Zone
+	})
+
+	return plan, nil
+}
+
+func (p *balloonPlan) write(w io.Writer) error {
+	return balloonTemplate.Execute(w, p)
+}
+
+// balloonTemplate renders the manifests. Strings are quoted as JSON, which is
+// also valid YAML.
+var balloonTemplate = template.Must(template.New("balloon").Funcs(template.FuncMap{
+	"quote":  strconv.Quote,
+	"ibytes": func(v int64) string { return humanize.IBytes(nonNegative(v)) },
+	"min": func(a, b int) int {
+		if a < b {
+			return a
+		}
+
+		return b
+	},
+}).Parse(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ quote .Namespace }}
+---
+apiVersion: scheduling.k8s.io/v1
+kind: PriorityClass
+metadata:
+  name: ` + balloonPriorityClass + `
+value: -10
+globalDefault: false
+description: "kubecap overprovisioning balloon pods, preempted by any other pod."
+{{- range .Balloons }}
+---
+# {{ if .Zone }}Zone {{ .Zone }}{{ else }}Cluster{{ end }}: {{ min .Fit .Replicas }} of {{ .Replicas }} balloons of {{ ibytes $.Size }} fit in the schedulable memory now
+{{- if gt .Replicas .Fit }}; the cluster-autoscaler will add nodes for the rest{{ end }}.
+{{- if gt $.Size .Largest }}
+# WARNING: balloons are larger than the largest node ({{ ibytes .Largest }} allocatable) and will never be scheduled.
+{{- end }}
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ quote .Name }}
+  namespace: {{ quote $.Namespace }}
+spec:
+  replicas: {{ .Replicas }}
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: {{ quote .Name }}
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: {{ quote .Name }}
+    spec:
+      priorityClassName: ` + balloonPriorityClass + `
+      terminationGracePeriodSeconds: 0
+      {{- if .Zone }}
+      nodeSelector:
+        ` + kubecap.ZoneLabel + `: {{ quote .Zone }}
+      {{- end }}
+      containers:
+      - name: balloon
+        image: {{ quote $.Image }}
+        resources:
+          requests:
+            memory: {{ quote (printf "%d" $.Size) }}
+          limits:
+            memory: {{ quote (printf "%d" $.Size) }}
+{{- end }}
+`))
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// balloonCluster has two zones: a has 10GiB schedulable over two nodes and an
+// existing balloon pod counted as schedulable, b a node with 3GiB and a
+// cordoned one left out.
+const balloonCluster = `
+apiVersion: v1
+kind: Node
+metadata: {name: a-1, labels: {topology.kubernetes.io/zone: us-east-1a}}
+status: {allocatable: {memory: 16Gi}}
+---
+apiVersion: v1
+kind: Node
+metadata: {name: a-2, labels: {topology.kubernetes.io/zone: us-east-1a}}
+status: {allocatable: {memory: 8Gi}}
+---
+apiVersion: v1
+kind: Node
+metadata: {name: b-1, labels: {topology.kubernetes.io/zone: us-east-1b}}
+status: {allocatable: {memory: 7Gi}}
+---
+apiVersion: v1
+kind: Node
+metadata: {name: b-2, labels: {topology.kubernetes.io/zone: us-east-1b}}
+spec: {unschedulable: true}
+status: {allocatable: {memory: 64Gi}}
+---
+apiVersion: v1
+kind: Pod
+metadata: {name: web, namespace: shop}
+spec:
+  nodeName: a-1
+  containers: [{name: web, resources: {requests: {memory: 12Gi}}}]
+status: {phase: Running}
+---
+apiVersion: v1
+kind: Pod
+metadata: {name: balloon, namespace: kubecap}
+spec:
+  nodeName: a-2
+  priorityClassName: kubecap-overprovisioning
+  containers: [{name: balloon, resources: {requests: {memory: 4Gi}}}]
+status: {phase: Running}
+---
+apiVersion: v1
+kind: Pod
+metadata: {name: db, namespace: shop}
+spec:
+  nodeName: b-1
+  containers: [{name: db, resources: {requests: {memory: 4Gi}}}]
+status: {phase: Running}
+`
+
+func TestPlanBalloons(t *testing.T) {
+	s, err := loadSimulation(strings.NewReader(balloonCluster))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, closeSimulation, err := newSimulatedCluster(s, "balloons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSimulation()
+
+	plan, err := planBalloons(context.Background(), c.kcs, 2, 4<<30, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Balloons) != 2 {
+		t.Fatalf("balloons = %+v", plan.Balloons)
+	}
+
+	if a := plan.Balloons[0]; a.Zone != "us-east-1a" || a.Replicas != 2 || a.Fit != 3 || a.Largest != 16<<30 {
+		t.Errorf("a = %+v", a)
+	}
+
+	if b := plan.Balloons[1]; b.Zone != "us-east-1b" || b.Replicas != 2 || b.Fit != 0 || b.Largest != 7<<30 {
+		t.Errorf("b = %+v", b)
+	}
+
+	plan, err = planBalloons(context.Background(), c.kcs, 3, 4<<30, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Balloons) != 1 || plan.Balloons[0].Zone != "" || plan.Balloons[0].Fit != 3 {
+		t.Errorf("cluster balloons = %+v", plan.Balloons)
+	}
+}
+
+func TestBalloonManifests(t *testing.T) {
+	plan := &balloonPlan{
+		Namespace: "capacity",
+		Image:     "registry.k8s.io/pause:3.9",
+		Size:      8 << 30,
+		Balloons: []*balloonDeployment{
+			{Zone: "us-east-1a", Replicas: 2, Fit: 2, Largest: 16 << 30},
+			{Zone: "us-east-1b", Replicas: 2, Fit: 0, Largest: 4 << 30},
+			{Replicas: 1, Fit: 1, Largest: 16 << 30},
+		},
+	}
+
+	b := &bytes.Buffer{}
+
+	err := plan.write(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := strings.Split(b.String(), "\n---\n")
+	if len(docs) != 5 {
+		t.Fatalf("documents = %d, want a Namespace, a PriorityClass and 3 Deployments:\n%s", len(docs), b)
+	}
+
+	decode := func(doc string, into interface{}) {
+		t.Helper()
+
+		err := utilyaml.NewYAMLOrJSONDecoder(strings.NewReader(doc), 4096).Decode(into)
+		if err != nil && err != io.EOF {
+			t.Fatalf("%v:\n%s", err, doc)
+		}
+	}
+
+	ns := &corev1.Namespace{}
+	decode(docs[0], ns)
+
+	if ns.Kind != "Namespace" || ns.Name != "capacity" {
+		t.Errorf("namespace = %+v", ns)
+	}
+
+	pc := &schedulingv1.PriorityClass{}
+	decode(docs[1], pc)
+
+	if pc.Kind != "PriorityClass" || pc.Name != balloonPriorityClass || pc.Value >= 0 || pc.GlobalDefault {
+		t.Errorf("priority class = %+v", pc)
+	}
+
+	names := []string{"kubecap-balloon-us-east-1a", "kubecap-balloon-us-east-1b", "kubecap-balloon"}
+
+	for i, doc := range docs[2:] {
+		balloon := plan.Balloons[i]
+
+		d := &appsv1.Deployment{}
+		decode(doc, d)
+
+		if d.Kind != "Deployment" || d.Name != names[i] || d.Namespace != "capacity" {
+			t.Errorf("%d: deployment %s/%s of kind %s", i, d.Namespace, d.Name, d.Kind)
+		}
+
+		if d.Spec.Replicas == nil || int(*d.Spec.Replicas) != balloon.Replicas {
+			t.Errorf("%s: replicas = %v, want %d", d.Name, d.Spec.Replicas, balloon.Replicas)
+		}
+
+		labels := d.Spec.Template.Labels
+		for k, v := range d.Spec.Selector.MatchLabels {
+			if labels[k] != v {
+				t.Errorf("%s: selector %s=%s doesn't match the pod labels %v", d.Name, k, v, labels)
+			}
+		}
+
+		spec := d.Spec.Template.Spec
+
+		if spec.PriorityClassName != balloonPriorityClass {
+			t.Errorf("%s: priority class = %q", d.Name, spec.PriorityClassName)
+		}
+
+		if zone := spec.NodeSelector[kubecap.ZoneLabel]; zone != balloon.Zone || (balloon.Zone == "" && spec.NodeSelector != nil) {
+			t.Errorf("%s: node selector = %v, want zone %q", d.Name, spec.NodeSelector, balloon.Zone)
+		}
+
+		if len(spec.Containers) != 1 || spec.Containers[0].Image != "registry.k8s.io/pause:3.9" {
+			t.Fatalf("%s: containers = %+v", d.Name, spec.Containers)
+		}
+
+		want := resource.NewQuantity(8<<30, resource.BinarySI)
+		res := spec.Containers[0].Resources
+
+		if !res.Requests.Memory().Equal(*want) || !res.Limits.Memory().Equal(*want) {
+			t.Errorf("%s: resources = %+v, want requests and limits of %s", d.Name, res, want)
+		}
+	}
+
+	if !strings.Contains(docs[3], "# Zone us-east-1b: 0 of 2 balloons of 8.0 GiB fit in the schedulable memory now; the cluster-autoscaler will add nodes for the rest.") ||
+		!strings.Contains(docs[3], "# WARNING: balloons are larger than the largest node (4.0 GiB allocatable)") {
+		t.Errorf("us-east-1b comments:\n%s", docs[3])
+	}
+
+	if strings.Contains(docs[2], "WARNING") || strings.Contains(docs[2], "cluster-autoscaler") {
+		t.Errorf("us-east-1a comments:\n%s", docs[2])
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// chartMain implements the chart subcommand, which renders a bar chart of
+// each node's allocatable, requested and used memory or, with --period, a
+// line chart of each cluster's over time from the history stored with
+// --postgres-dsn.
+func chartMain(args []string) {
+	fs := flag.NewFlagSet("chart", flag.ExitOnError)
+	format := fs.String("format", "", "chart format: svg or png (default from the --output-file extension, otherwise svg)")
+	outputFile := fs.String("output-file", "", "write the chart to this path (atomically) instead of stdout")
+	dsn := fs.String("postgres-dsn", os.Getenv("KUBECAP_POSTGRES_DSN"), "PostgreSQL/TimescaleDB database the history was stored in, for --period")
+	period := fs.Duration("period", 0, "chart the clusters' allocatable, requests and usage over this period of the history, ending with the latest run, instead of each node's now")
+	cluster := fs.String("cluster", "", "with --period, only chart this cluster (kubeconfig context)")
+	resource := fs.String("resource", "memory", "with --period, resource the history was reported on: memory, cpu or ephemeral-storage")
+	kubeconfig, kcontext := clusterFlags(fs)
+	fs.Parse(args)
+
+	additionalAmountStr := "0 MiB"
+
+	if fs.NArg() >= 1 {
+		additionalAmountStr = fs.Arg(0)
+	}
+
+	if *format == "" {
+		*format = "svg"
+
+		if strings.EqualFold(filepath.Ext(*outputFile), ".png") {
+			*format = "png"
+		}
+	}
+
+	if *period > 0 {
+		err := chartTrend(*dsn, *period, *cluster, *resource, *format, *outputFile)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		return
+	}
+
+	c, err := newCluster(*kubeconfig, *kcontext)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	err = report(context.TODO(), c, *outputFile, kubecap.Metadata{AdditionalInput: additionalAmountStr}, func(w io.Writer) (kubecap.Output, error) {
+		return newChartOutput(*format, w)
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// chartOutput draws a horizontal bar chart with a group of bars per node.
+type chartOutput struct {
+	format string
+	w      io.Writer
+	md     *kubecap.Metadata
+	nodes  []*kubecap.NodeReport
+}
+
+func newChartOutput(format string, w io.Writer) (*chartOutput, error) {
+	switch format {
+	case "svg", "png":
+	default:
+		return nil, fmt.Errorf("unknown chart format: %q", format)
+	}
+
+	return &chartOutput{format: format, w: w}, nil
+}
+
+func (c *chartOutput) Metadata(m *kubecap.Metadata) error {
+	c.md = m
+
+	return nil
+}
+
+func (c *chartOutput) Node(n *kubecap.NodeReport) error {
+	c.nodes = append(c.nodes, n)
+
+	return nil
+}
+
+func (c *chartOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+// Chart layout in pixels.
+const (
+	chartWidth      = 1000
+	chartLabelWidth = 250
+	chartTitle      = 40
+	chartBar        = 13
+	chartGroup      = 3*chartBar + 10
+	chartLegend     = 30
+)
+
+type chartSeries struct {
+	name  string
+	color color.RGBA
+	value func(n *kubecap.NodeReport) int64
+}
+
+var chartSeriesList = []chartSeries{
+	{"allocatable", color.RGBA{0x9e, 0x9e, 0x9e, 0xff}, func(n *kubecap.NodeReport) int64 { return n.Allocatable }},
+	{"requests", color.RGBA{0x42, 0x85, 0xf4, 0xff}, func(n *kubecap.NodeReport) int64 { return n.Requests }},
+	{"used", color.RGBA{0xf4, 0x8f, 0x42, 0xff}, func(n *kubecap.NodeReport) int64 { return n.Used }},
+}
+
+// chartElement is a single bar, label or line positioned in the chart.
+type chartElement struct {
+	x, y, w, h int
+	color      color.RGBA
+	text       string
+	points     []image.Point
+}
+
+// layout positions the title, labels, bars and legend.
+func (c *chartOutput) layout() (width, height int, elements []chartElement) {
+	var max int64
+	for _, n := range c.nodes {
+		for _, s := range chartSeriesList {
+			if v := s.value(n); v > max {
+				max = v
+			}
+		}
+	}
+
+	black := color.RGBA{0, 0, 0, 0xff}
+
+	title := "Node Memory"
+	if c.md != nil {
+		title = fmt.Sprintf(
+			"Node Memory: %s (cluster %s, %s), additional %s",
+			c.md.Context, c.md.Cluster, c.md.Timestamp.Format("2006-01-02 15:04:05 MST"), c.md.AdditionalInput,
+		)
+	}
+
+	elements = append(elements, chartElement{x: 10, y: 25, color: black, text: title})
+
+	barSpace := chartWidth - chartLabelWidth - 100
+
+	for i, n := range c.nodes {
+		y := chartTitle + i*chartGroup
+
+		elements = append(elements, chartElement{x: 10, y: y + 2*chartBar, color: black, text: n.Name})
+
+		for j, s := range chartSeriesList {
+			v := s.value(n)
+
+			w := 0
+			if max > 0 && v > 0 {
+				w = int(float64(barSpace) * float64(v) / float64(max))
+			}
+
+			by := y + j*chartBar
+
+			elements = append(elements,
+				chartElement{x: chartLabelWidth, y: by, w: w, h: chartBar - 1, color: s.color},
+				chartElement{x: chartLabelWidth + w + 5, y: by + chartBar - 1, color: black, text: humanize.IBytes(nonNegative(v))},
+			)
+		}
+	}
+
+	y := chartTitle + len(c.nodes)*chartGroup + 10
+	for j, s := range chartSeriesList {
+		x := chartLabelWidth + j*120
+
+		elements = append(elements,
+			chartElement{x: x, y: y, w: 12, h: 12, color: s.color},
+			chartElement{x: x + 18, y: y + 11, color: black, text: s.name},
+		)
+	}
+
+	return chartWidth, y + chartLegend, elements
+}
+
+func (c *chartOutput) Flush() error {
+	width, height, elements := c.layout()
+
+	return writeChart(c.w, c.format, width, height, elements)
+}
+
+// writeChart renders the elements as an SVG or PNG image.
+func writeChart(w io.Writer, format string, width, height int, elements []chartElement) error {
+	if format == "png" {
+		return chartPNG(w, width, height, elements)
+	}
+
+	return chartSVG(w, width, height, elements)
+}
+
+func chartSVG(w io.Writer, width, height int, elements []chartElement) (err error) {
+	p := func(format string, args ...interface{}) {
+		if err != nil {
+			return
+		}
+
+		_, err = fmt.Fprintf(w, format, args...)
+	}
+
+	p("<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"11\">\n", width, height)
+	p("<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+
+	for _, e := range elements {
+		fill := fmt.Sprintf("#%02x%02x%02x", e.color.R, e.color.G, e.color.B)
+
+		switch {
+		case len(e.points) > 0:
+			points := make([]string, len(e.points))
+			for i, pt := range e.points {
+				points[i] = fmt.Sprintf("%d,%d", pt.X, pt.Y)
+			}
+
+			p("<polyline points=\"%s\" fill=\"none\" stroke=\"%s\" stroke-width=\"2\"/>\n", strings.Join(points, " "), fill)
+		case e.text != "":
+			p("<text x=\"%d\" y=\"%d\" fill=\"%s\">%s</text>\n", e.x, e.y, fill, html.EscapeString(e.text))
+		default:
+			p("<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n", e.x, e.y, e.w, e.h, fill)
+		}
+	}
+
+	p("</svg>\n")
+
+	return err
+}
+
+func chartPNG(w io.Writer, width, height int, elements []chartElement) error {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for _, e := range elements {
+		switch {
+		case len(e.points) > 0:
+			for i := 1; i < len(e.points); i++ {
+				drawLine(img, e.points[i-1], e.points[i], e.color)
+			}
+		case e.text != "":
+			d := &font.Drawer{
+				Dst:  img,
+				Src:  image.NewUniform(e.color),
+				Face: basicfont.Face7x13,
+				Dot:  fixed.P(e.x, e.y),
+			}
+			d.DrawString(e.text)
+		default:
+			draw.Draw(img, image.Rect(e.x, e.y, e.x+e.w, e.y+e.h), image.NewUniform(e.color), image.Point{}, draw.Src)
+		}
+	}
+
+	return png.Encode(w, img)
+}
+
+// drawLine draws a line two pixels thick from a to b.
+func drawLine(img *image.RGBA, a, b image.Point, c color.RGBA) {
+	dx, dy := b.X-a.X, b.Y-a.Y
+
+	steps := 1
+	for _, d := range []int{dx, -dx, dy, -dy} {
+		if d > steps {
+			steps = d
+		}
+	}
+
+	for i := 0; i <= steps; i++ {
+		x, y := a.X+dx*i/steps, a.Y+dy*i/steps
+
+		img.SetRGBA(x, y, c)
+		img.SetRGBA(x, y+1, c)
+	}
+}
+
+// trendSample is a cluster's totals in a run (or an hour, once rolled up).
+type trendSample struct {
+	Cluster string
+	Time    time.Time
+
+	// Totals are the sums of the cluster's nodes' amounts, in the fields
+	// the chart's series read.
+	Totals kubecap.NodeReport
+}
+
+// trendSamples reads each cluster's totals of the resource of every run (or
+// hour, once rolled up) within the period before its latest run, oldest
+// first.
+func trendSamples(ctx context.Context, db *sql.DB, period time.Duration, cluster, resource string) ([]*trendSample, error) {
+	rows, err := db.QueryContext(ctx, `
+		WITH history AS (
+			SELECT time, cluster, allocatable, requests, used FROM kubecap_nodes WHERE resource = $3
+			UNION ALL
+			SELECT time, cluster, allocatable, requests, used FROM kubecap_nodes_hourly WHERE resource = $3
+		), latest AS (
+			SELECT cluster, max(time) AS last
+			FROM history
+			WHERE $2 = '' OR cluster = $2
+			GROUP BY cluster
+		)
+		SELECT h.cluster, h.time, sum(h.allocatable), sum(h.requests), sum(h.used)
+		FROM history h JOIN latest l ON h.cluster = l.cluster
+		WHERE h.time > l.last - make_interval(secs => $1)
+		GROUP BY 1, 2
+		ORDER BY 1, 2`,
+		period.Seconds(), cluster, resource,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []*trendSample{}
+
+	for rows.Next() {
+		s := &trendSample{}
+
+		err = rows.Scan(&s.Cluster, &s.Time, &s.Totals.Allocatable, &s.Totals.Requests, &s.Totals.Used)
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, s)
+	}
+
+	return samples, rows.Err()
+}
+
+// chartTrend renders the trend chart of the history's clusters to the output
+// file, or stdout.
+func chartTrend(dsn string, period time.Duration, cluster, resource, format, outputFile string) error {
+	if dsn == "" {
+		return fmt.Errorf("chart --period requires --postgres-dsn")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	samples, err := trendSamples(context.TODO(), db, period, cluster, resource)
+	if err != nil {
+		return err
+	}
+
+	if len(samples) == 0 {
+		return fmt.Errorf("no %s history stored for the period", resource)
+	}
+
+	var w io.Writer = os.Stdout
+
+	var af *atomicFile
+	if outputFile != "" {
+		af, err = createAtomic(outputFile)
+		if err != nil {
+			return err
+		}
+		defer af.Abort()
+
+		w = af
+	}
+
+	width, height, elements := trendLayout(samples, resource, period)
+
+	err = writeChart(w, format, width, height, elements)
+	if err != nil {
+		return err
+	}
+
+	if af != nil {
+		return af.Commit()
+	}
+
+	return nil
+}
+
+// Trend chart layout in pixels.
+const (
+	trendAxisWidth = 90
+	trendPlot      = 160
+	trendPanel     = trendPlot + 55
+)
+
+// chartAmount formats an amount of the resource for the chart's labels.
+func chartAmount(resource string, v int64) string {
+	if resource == "cpu" {
+		return humanize.Comma(v) + "m"
+	}
+
+	return humanize.IBytes(nonNegative(v))
+}
+
+// trendLayout positions the title, a panel with a line per series for each
+// cluster and the legend. The samples are ordered by cluster and time.
+func trendLayout(samples []*trendSample, resource string, period time.Duration) (width, height int, elements []chartElement) {
+	black := color.RGBA{0, 0, 0, 0xff}
+	grey := color.RGBA{0xbd, 0xbd, 0xbd, 0xff}
+
+	elements = append(elements, chartElement{x: 10, y: 25, color: black, text: fmt.Sprintf("Cluster %s over %s", resource, period)})
+
+	plotWidth := chartWidth - trendAxisWidth - 20
+
+	y := chartTitle
+
+	for start := 0; start < len(samples); {
+		end := start
+		for end < len(samples) && samples[end].Cluster == samples[start].Cluster {
+			end++
+		}
+
+		panel := samples[start:end]
+		start = end
+
+		first, last := panel[0].Time, panel[len(panel)-1].Time
+
+		var max int64
+		for _, sample := range panel {
+			for _, s := range chartSeriesList {
+				if v := s.value(&sample.Totals); v > max {
+					max = v
+				}
+			}
+		}
+
+		top := y + 25
+		bottom := top + trendPlot
+
+		elements = append(elements,
+			chartElement{x: 10, y: y + 15, color: black, text: panel[0].Cluster},
+			chartElement{x: 10, y: top + 10, color: black, text: chartAmount(resource, max)},
+			chartElement{x: 10, y: bottom, color: black, text: chartAmount(resource, 0)},
+			chartElement{x: trendAxisWidth, y: top, w: 1, h: trendPlot, color: grey},
+			chartElement{x: trendAxisWidth, y: bottom, w: plotWidth, h: 1, color: grey},
+			chartElement{x: trendAxisWidth, y: bottom + 15, color: black, text: first.Format("2006-01-02 15:04")},
+			chartElement{x: trendAxisWidth + plotWidth - 100, y: bottom + 15, color: black, text: last.Format("2006-01-02 15:04")},
+		)
+
+		for _, s := range chartSeriesList {
+			points := make([]image.Point, len(panel))
+
+			for i, sample := range panel {
+				x := trendAxisWidth
+				if span := last.Sub(first); span > 0 {
+					x += int(float64(plotWidth) * float64(sample.Time.Sub(first)) / float64(span))
+				}
+
+				h := 0
+				if v := s.value(&sample.Totals); max > 0 && v > 0 {
+					h = int(float64(trendPlot) * float64(v) / float64(max))
+				}
+
+				points[i] = image.Point{X: x, Y: bottom - h}
+			}
+
+			elements = append(elements, chartElement{color: s.color, points: points})
+		}
+
+		y += trendPanel
+	}
+
+	for j, s := range chartSeriesList {
+		x := trendAxisWidth + j*120
+
+		elements = append(elements,
+			chartElement{x: x, y: y, w: 12, h: 12, color: s.color},
+			chartElement{x: x + 18, y: y + 11, color: black, text: s.name},
+		)
+	}
+
+	return chartWidth, y + chartLegend, elements
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestTrendLayout(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	sample := func(cluster string, hours int, allocatable, requests, used int64) *trendSample {
+		return &trendSample{
+			Cluster: cluster,
+			Time:    start.Add(time.Duration(hours) * time.Hour),
+			Totals:  kubecap.NodeReport{Allocatable: allocatable, Requests: requests, Used: used},
+		}
+	}
+
+	samples := []*trendSample{
+		sample("a", 0, 100, 40, 20),
+		sample("a", 1, 100, 60, 30),
+		sample("a", 2, 100, 80, 50),
+		sample("b", 0, 50, 10, 10),
+	}
+
+	_, _, elements := trendLayout(samples, "memory", 2*time.Hour)
+
+	lines := [][]image.Point{}
+	for _, e := range elements {
+		if len(e.points) > 0 {
+			lines = append(lines, e.points)
+		}
+	}
+
+	// A line per series for each cluster.
+	if len(lines) != 2*len(chartSeriesList) {
+		t.Fatalf("%d lines", len(lines))
+	}
+
+	plotWidth := chartWidth - trendAxisWidth - 20
+	bottom := chartTitle + 25 + trendPlot
+
+	// Cluster a's allocatable is the top of its panel throughout and its
+	// requests rise across it.
+	want := []image.Point{{trendAxisWidth, bottom - trendPlot}, {trendAxisWidth + plotWidth/2, bottom - trendPlot}, {trendAxisWidth + plotWidth, bottom - trendPlot}}
+	if !reflect.DeepEqual(lines[0], want) {
+		t.Errorf("allocatable = %v, want %v", lines[0], want)
+	}
+
+	want = []image.Point{{trendAxisWidth, bottom - 64}, {trendAxisWidth + plotWidth/2, bottom - 96}, {trendAxisWidth + plotWidth, bottom - 128}}
+	if !reflect.DeepEqual(lines[1], want) {
+		t.Errorf("requests = %v, want %v", lines[1], want)
+	}
+
+	// Cluster b has a panel of its own below.
+	if p := lines[3][0]; p.X != trendAxisWidth || p.Y != bottom+trendPanel-trendPlot {
+		t.Errorf("cluster b allocatable starts at %v", p)
+	}
+
+	buf := &bytes.Buffer{}
+
+	err := writeChart(buf, "svg", chartWidth, 100, elements)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(buf.String(), "<polyline "); n != len(lines) {
+		t.Errorf("%d polylines:\n%s", n, buf)
+	}
+
+	buf.Reset()
+
+	err = writeChart(buf, "png", chartWidth, 2*trendPanel+chartTitle+chartLegend, elements)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = image.Decode(buf); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+)
+
+// Exit codes of the check subcommand.
+const (
+	checkExitPass  = 0
+	checkExitFail  = 1
+	checkExitError = 2
+)
+
+// checkMain implements the check subcommand, which evaluates a capacity
+// policy for CI pipelines: it exits 0 when the policy holds, 1 when it
+// doesn't and 2 when it couldn't be evaluated.
+func checkMain(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	need := fs.String("need", "0", "additional amount of the resource that must fit on a node, or a percentage of each node's allocatable (e.g. 10%)")
+	minNodes := fs.Int("min-nodes", 1, "minimum number of nodes with enough free and schedulable room for the needed amount")
+	maxOvercommit := fs.Float64("max-overcommit", 0, "maximum ratio of the cluster's requests to its allocatable amount (0 disables the check)")
+	minSchedulable := fs.String("min-schedulable", "0", "minimum schedulable amount of the resource across the cluster")
+	resourceStr := fs.String("resource", "memory", "resource to check: memory, cpu (amounts in millicores) or ephemeral-storage")
+	verdictRules := fs.String("verdict-rules", "", "YAML rules (node, cluster) replacing the built-in verdict of each node, with the cluster rule checked as cluster-rule")
+	var standby stringList
+	fs.Var(&standby, "standby", "warm standby capacity required for a priority tier as CLASS:REPLICASxAMOUNT, checked as standby/CLASS (repeatable)")
+	quiet := fs.Bool("quiet", false, "only print the failed checks")
+	kubeconfig, kcontext := clusterFlags(fs)
+	fs.Parse(args)
+
+	os.Exit(runCheck(os.Stdout, os.Stderr, func() (*cluster, error) {
+		return newCluster(*kubeconfig, *kcontext)
+	}, kubecap.Metadata{
+		Resource:        *resourceStr,
+		AdditionalInput: *need,
+	}, checkFlags{
+		minNodes:       *minNodes,
+		maxOvercommit:  *maxOvercommit,
+		minSchedulable: *minSchedulable,
+		verdictRules:   *verdictRules,
+		standby:        standby,
+	}, *quiet))
+}
+
+// checkFlags are the thresholds of a policy as given.
+type checkFlags struct {
+	minNodes       int
+	maxOvercommit  float64
+	minSchedulable string
+	verdictRules   string
+	standby        []string
+}
+
+// checkPolicy is the thresholds the cluster must meet.
+type checkPolicy struct {
+	minNodes       int
+	maxOvercommit  float64
+	minSchedulable int64
+}
+
+// checkResult is the outcome of one of the policy's thresholds.
+type checkResult struct {
+	name   string
+	ok     bool
+	detail string
+}
+
+// evaluate checks the report against the policy's thresholds.
+func (p *checkPolicy) evaluate(r *kubecap.ClusterReport) []*checkResult {
+	s := r.Summary()
+	unit := r.Metadata.Unit()
+
+	results := []*checkResult{{
+		name:   "min-nodes",
+		ok:     s.OkNodes >= p.minNodes,
+		detail: fmt.Sprintf("%d of %d nodes fit %s, need %d", s.OkNodes, s.Nodes, r.Metadata.AdditionalInput, p.minNodes),
+	}}
+
+	if p.maxOvercommit > 0 {
+		ratio := 0.0
+		if s.Allocatable > 0 {
+			ratio = float64(s.Requests) / float64(s.Allocatable)
+		}
+
+		results = append(results, &checkResult{
+			name:   "max-overcommit",
+			ok:     s.Allocatable > 0 && ratio <= p.maxOvercommit,
+			detail: fmt.Sprintf("requests are %.2f of allocatable, max %.2f", ratio, p.maxOvercommit),
+		})
+	}
+
+	if p.minSchedulable > 0 {
+		results = append(results, &checkResult{
+			name:   "min-schedulable",
+			ok:     s.Schedulable >= p.minSchedulable,
+			detail: fmt.Sprintf("%s %s schedulable, need %s", humanize.Comma(s.Schedulable), unit, humanize.Comma(p.minSchedulable)),
+		})
+	}
+
+	if ok := r.Metadata.ClusterOk; ok != nil {
+		results = append(results, &checkResult{
+			name:   "cluster-rule",
+			ok:     *ok,
+			detail: r.Metadata.Verdicts.Cluster,
+		})
+	}
+
+	for _, sb := range r.Metadata.StandbyStatus {
+		results = append(results, &checkResult{
+			name:   "standby/" + sb.PriorityClass,
+			ok:     sb.Ok,
+			detail: fmt.Sprintf("room for %d of %d pods of %s %s", sb.Available, sb.Replicas, humanize.Comma(sb.Amount), unit),
+		})
+	}
+
+	return results
+}
+
+// runCheck collects a report from the cluster returned by newCluster and
+// writes the policy's results to w, or only the failed ones when quiet, and
+// returns the exit code. Errors are written to errW.
+func runCheck(w, errW io.Writer, newCluster func() (*cluster, error), md kubecap.Metadata, flags checkFlags, quiet bool) int {
+	fail := func(err error) int {
+		fmt.Fprintf(errW, "check: %v\n", err)
+
+		return checkExitError
+	}
+
+	minSchedulable, err := kubecap.ParseAmount(md.ResourceName(), flags.minSchedulable)
+	if err != nil {
+		return fail(fmt.Errorf("--min-schedulable: %w", err))
+	}
+
+	if flags.verdictRules != "" {
+		md.Verdicts, err = kubecap.LoadVerdictRules(flags.verdictRules)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if len(flags.standby) > 0 {
+		md.Standby, err = kubecap.ParseStandbys(md.ResourceName(), flags.standby)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	policy := &checkPolicy{
+		minNodes:       flags.minNodes,
+		maxOvercommit:  flags.maxOvercommit,
+		minSchedulable: minSchedulable,
+	}
+
+	c, err := newCluster()
+	if err != nil {
+		return fail(err)
+	}
+
+	r := &kubecap.ClusterReport{}
+
+	err = collectReport(context.TODO(), c, md, &recordOutput{r})
+	if err != nil {
+		return fail(err)
+	}
+
+	code := checkExitPass
+
+	for _, res := range policy.evaluate(r) {
+		status := "PASS"
+		if !res.ok {
+			status = "FAIL"
+			code = checkExitFail
+		}
+
+		if !quiet || !res.ok {
+			fmt.Fprintf(w, "%s %s: %s\n", status, res.name, res.detail)
+		}
+	}
+
+	return code
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestRunCheck(t *testing.T) {
+	// The simulated cluster requests half its 32Gi and only node-b has
+	// room for another 4Gi.
+	check := func(need string, flags checkFlags, quiet bool) (int, string, string) {
+		c, closeSimulation, err := loadSimulatedCluster("testdata/simulation.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeSimulation()
+
+		var w, errW bytes.Buffer
+
+		code := runCheck(&w, &errW, func() (*cluster, error) { return c, nil }, kubecap.Metadata{AdditionalInput: need}, flags, quiet)
+
+		return code, w.String(), errW.String()
+	}
+
+	code, out, _ := check("4Gi", checkFlags{minNodes: 1, maxOvercommit: 0.6, minSchedulable: "8Gi"}, false)
+	if code != checkExitPass || strings.Count(out, "PASS ") != 3 {
+		t.Errorf("passing policy: code %d:\n%s", code, out)
+	}
+
+	code, out, _ = check("4Gi", checkFlags{minNodes: 1, minSchedulable: "0"}, true)
+	if code != checkExitPass || out != "" {
+		t.Errorf("quiet passing policy: code %d:\n%s", code, out)
+	}
+
+	code, out, _ = check("4Gi", checkFlags{minNodes: 2, maxOvercommit: 0.4, minSchedulable: "1Gi"}, true)
+	want := "FAIL min-nodes: 1 of 2 nodes fit 4Gi, need 2\nFAIL max-overcommit: requests are 0.50 of allocatable, max 0.40\n"
+	if code != checkExitFail || out != want {
+		t.Errorf("failing policy: code %d:\n%s", code, out)
+	}
+
+	code, _, errOut := check("lots", checkFlags{minNodes: 1, minSchedulable: "0"}, false)
+	if code != checkExitError || !strings.HasPrefix(errOut, "check: ") {
+		t.Errorf("invalid amount: code %d: %s", code, errOut)
+	}
+
+	f, err := ioutil.TempFile("", "kubecap-verdicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString("node: ok && requests < allocatable * 0.1\ncluster: schedulable > allocatable * 0.4\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// The node rule fails every node, the cluster rule passes.
+	code, out, _ = check("4Gi", checkFlags{minNodes: 1, minSchedulable: "0", verdictRules: f.Name()}, false)
+	if code != checkExitFail || !strings.Contains(out, "FAIL min-nodes: 0 of") || !strings.Contains(out, "PASS cluster-rule: schedulable > allocatable * 0.4") {
+		t.Errorf("verdict rules: code %d:\n%s", code, out)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// configMain implements the config subcommand. Its only command, check,
+// validates configuration before a daemon is started with it.
+func configMain(args []string) {
+	if len(args) < 1 || args[0] != "check" {
+		panic("usage: kubecap config check [flags]")
+	}
+
+	os.Exit(configCheckMain(os.Stdout, args[1:]))
+}
+
+// configFiles are the configuration files and flag values checked, named as
+// kubecap's own flags.
+type configFiles struct {
+	resource          string
+	thresholdProfiles string
+	verdictRules      string
+	reservations      string
+	evictionPolicy    string
+	costCenters       string
+	scenarios         []string
+	reports           []string
+	columns           []string
+	slos              []string
+	standby           []string
+}
+
+// configCheckMain checks the configuration given by the flags, writes each
+// error to w as file: field: problem and returns the exit code: 0 when all of
+// it is valid and 1 otherwise.
+func configCheckMain(w io.Writer, args []string) int {
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	cf := configFiles{}
+	fs.StringVar(&cf.resource, "resource", "memory", "resource the amounts are of: memory, cpu (amounts in millicores) or ephemeral-storage")
+	fs.StringVar(&cf.thresholdProfiles, "threshold-profiles", "", "YAML file of threshold profiles")
+	fs.StringVar(&cf.verdictRules, "verdict-rules", "", "YAML verdict rules (node, cluster)")
+	fs.StringVar(&cf.reservations, "reservations", "", "YAML file of capacity reservations")
+	fs.StringVar(&cf.evictionPolicy, "eviction-policy", "", "YAML eviction policy")
+	fs.StringVar(&cf.costCenters, "cost-centers", "", "YAML file mapping pods to cost centers")
+	fs.Var((*stringList)(&cf.scenarios), "scenario", "what-if scenario file (repeatable)")
+	fs.Var((*stringList)(&cf.reports), "report", "YAML file of custom reports (repeatable)")
+	fs.Var((*stringList)(&cf.columns), "column", "custom column as NAME=EXPR (repeatable)")
+	fs.Var((*stringList)(&cf.slos), "slo", "capacity SLO as [BY:]MIN%@TARGET% (repeatable)")
+	fs.Var((*stringList)(&cf.standby), "standby", "warm standby as CLASS:REPLICASxAMOUNT (repeatable)")
+	fs.Parse(args)
+
+	errs := cf.check()
+
+	for _, err := range errs {
+		fmt.Fprintln(w, err)
+	}
+
+	if len(errs) > 0 {
+		return 1
+	}
+
+	fmt.Fprintln(w, "ok")
+
+	return 0
+}
+
+// check runs each configuration through the loader kubecap uses for it and
+// returns all of their errors. Values of flags rather than files are
+// reported under the flag's name.
+func (cf *configFiles) check() []error {
+	errs := []error{}
+
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	flagged := func(name string, err error) {
+		if err != nil {
+			add(fmt.Errorf("--%s: %w", name, err))
+		}
+	}
+
+	md := &kubecap.Metadata{Resource: cf.resource}
+
+	var rn corev1.ResourceName
+
+	switch cf.resource {
+	case "memory", "cpu", "ephemeral-storage":
+		rn = md.ResourceName()
+	default:
+		flagged("resource", fmt.Errorf("unknown resource %q (one of memory, cpu or ephemeral-storage)", cf.resource))
+	}
+
+	if cf.thresholdProfiles != "" {
+		_, err := kubecap.LoadThresholdProfiles(cf.thresholdProfiles)
+		add(err)
+	}
+
+	if cf.verdictRules != "" {
+		_, err := kubecap.LoadVerdictRules(cf.verdictRules)
+		add(err)
+	}
+
+	if cf.reservations != "" {
+		_, err := kubecap.LoadReservations(cf.reservations)
+		add(err)
+	}
+
+	if cf.evictionPolicy != "" {
+		_, err := kubecap.LoadEvictionPolicy(cf.evictionPolicy)
+		add(err)
+	}
+
+	if cf.costCenters != "" {
+		_, err := kubecap.LoadCostCenters(cf.costCenters)
+		add(err)
+	}
+
+	for _, path := range cf.scenarios {
+		_, err := loadScenario(path)
+		add(err)
+	}
+
+	for _, path := range cf.reports {
+		_, err := kubecap.LoadCustomReports(path)
+		add(err)
+	}
+
+	for _, col := range cf.columns {
+		_, err := kubecap.ParseColumn(col)
+		flagged("column", err)
+	}
+
+	for _, spec := range cf.slos {
+		_, err := kubecap.ParseSLO(spec)
+		flagged("slo", err)
+	}
+
+	if rn != "" && len(cf.standby) > 0 {
+		_, err := kubecap.ParseStandbys(rn, cf.standby)
+		flagged("standby", err)
+	}
+
+	return errs
+}
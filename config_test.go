@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+
+		err := os.WriteFile(path, []byte(data), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	good := []string{
+		"--threshold-profiles", write("profiles.yaml", "profiles:\n- name: db\n  nodeSelector: pool=db\n  minFreePercent: 20\n"),
+		"--verdict-rules", write("rules.yaml", "node: ok && pressure < 80\n"),
+		"--reservations", write("reservations.yaml", "- name: launch\n  requests:\n    memory: 8GiB\n"),
+		"--eviction-policy", write("policy.yaml", "namespaces: [kube-system]\n"),
+		"--cost-centers", write("costcenters.yaml", "labels:\n- selector: team=web\n  costCenter: web\n"),
+		"--scenario", write("scenario.yaml", "removeNodes: [node-a]\n"),
+		"--report", write("reports.yaml", "- name: pools\n  columns:\n  - name: nodes\n    agg: count\n"),
+		"--column", "buffer=free-requests*0.1",
+		"--slo", "zone:15%@99%",
+		"--standby", "critical:10x2Gi",
+	}
+
+	out := &bytes.Buffer{}
+	if code := configCheckMain(out, good); code != 0 || out.String() != "ok\n" {
+		t.Fatalf("exit %d: %s", code, out)
+	}
+
+	bad := []string{
+		"--threshold-profiles", write("bad-profiles.yaml", "profiles:\n- name: db\n  minFreePercent: 120\n"),
+		"--verdict-rules", write("bad-rules.yaml", "node: ok &&\n"),
+		"--reservations", write("bad-reservations.yaml", "- name: launch\n"),
+		"--eviction-policy", write("bad-policy.yaml", "selectors: ['']\n"),
+		"--cost-centers", write("bad-costcenters.yaml", "labels:\n- selector: 'team in'\n"),
+		"--scenario", write("bad-scenario.yaml", "addNodes:\n- count: 2\n"),
+		"--report", write("bad-reports.yaml", "- name: pools\n  colums: []\n"),
+		"--column", "buffer",
+		"--slo", "zone:15%",
+		"--standby", "critical:1x1Gi",
+		"--standby", "critical:2x1Gi",
+	}
+
+	out.Reset()
+	if code := configCheckMain(out, bad); code != 1 {
+		t.Fatalf("exit %d: %s", code, out)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("%d errors:\n%s", len(lines), out)
+	}
+
+	for _, want := range []string{
+		"bad-profiles.yaml: profile db: minFreePercent",
+		"bad-rules.yaml: node rule: ",
+		"bad-reservations.yaml: reservation launch: no requests",
+		"bad-policy.yaml: selectors: empty selector",
+		"bad-costcenters.yaml: labels: selector ",
+		"bad-scenario.yaml: addNodes: ",
+		"bad-reports.yaml: ",
+		"--column: ",
+		"--slo: ",
+		"--standby: ",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("no %q in:\n%s", want, out)
+		}
+	}
+}
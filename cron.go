@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a standard five field cron schedule: minute, hour, day of
+// month, month and day of week. Each field is a set of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+
+	// domAny and dowAny record unrestricted day fields (starting with *,
+	// including steps like */2): when both day fields are restricted a time
+	// matching either is due, as in cron.
+	domAny, dowAny bool
+}
+
+// parseCronField parses a cron field of comma separated *, values, ranges
+// (a-b) and steps (*/n, a-b/n) within [min, max].
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// parseCron parses a five field cron schedule. Day of week 7 is Sunday like
+// 0.
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron schedule %q: expected 5 fields", spec)
+	}
+
+	s := &cronSchedule{
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}
+
+	var err error
+
+	for _, f := range []struct {
+		field    string
+		min, max int
+		values   *map[int]bool
+	}{
+		{fields[0], 0, 59, &s.minute},
+		{fields[1], 0, 23, &s.hour},
+		{fields[2], 1, 31, &s.dom},
+		{fields[3], 1, 12, &s.month},
+		{fields[4], 0, 7, &s.dow},
+	} {
+		*f.values, err = parseCronField(f.field, f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron schedule %q: %w", spec, err)
+		}
+	}
+
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+
+	return s, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+
+	dom := s.dom[t.Day()]
+	dow := s.dow[int(t.Weekday())]
+
+	if !s.domAny && !s.dowAny {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+// next returns the first time after t the schedule is due, searching up to
+// five years ahead (enough for 29 February) before giving up.
+func (s *cronSchedule) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+
+	for ; t.Before(end); t = t.Add(time.Minute) {
+		if s.matches(t) {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		_, err := parseCron(spec)
+		if err == nil {
+			t.Errorf("parseCron(%q) accepted", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// A Friday.
+	from := time.Date(2026, 10, 16, 12, 30, 45, 0, time.UTC)
+
+	for _, tc := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 16, 12, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 16, 12, 45, 0, 0, time.UTC)},
+		{"0 8 * * 1", time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * 7", time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 9-17/4 * * *", time.Date(2026, 10, 16, 13, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+
+		// With both day fields restricted either matches...
+		{"0 0 20 * 1", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+
+		// ...but a stepped * is unrestricted, so both must: the odd day
+		// Saturday 17th is skipped for Monday 19th.
+		{"0 8 */2 * 1", time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)},
+	} {
+		s, err := parseCron(tc.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tc.spec, err)
+		}
+
+		got, ok := s.next(from)
+		if !ok || !got.Equal(tc.want) {
+			t.Errorf("%q: next = %v, %t, want %v", tc.spec, got, ok, tc.want)
+		}
+	}
+}
+
+func TestCronNextSkipsEvenMondays(t *testing.T) {
+	s, err := parseCron("0 8 */2 * 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The 26th and 2nd are even days.
+	got, ok := s.next(time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC))
+	want := time.Date(2026, 11, 9, 8, 0, 0, 0, time.UTC)
+
+	if !ok || !got.Equal(want) {
+		t.Errorf("next = %v, %t, want %v", got, ok, want)
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	s, err := parseCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := s.next(time.Now()); ok {
+		t.Errorf("next = %v, want never", got)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// diffMain implements the diff subcommand, which compares the cluster's
+// schedulable capacity with a baseline report for drift checks: with
+// --fail-on-regression it exits 1 when capacity dropped more than allowed and
+// 2 when the reports couldn't be compared.
+func diffMain(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	against := fs.String("against", "", "baseline report (kubecap -o json or -o yaml) to compare with")
+	current := fs.String("current", "", "report to compare instead of collecting one from the cluster")
+	failOnRegression := fs.Bool("fail-on-regression", false, "exit 1 when the cluster's schedulable capacity dropped by more than --max-drop")
+	maxDrop := fs.String("max-drop", "10%", "largest drop in schedulable capacity allowed: an amount of the baseline's resource or a percentage of its schedulable capacity")
+	top := fs.Int("top", 10, "when schedulable capacity dropped, list this many of the changes contributing the most to the drop")
+	kubeconfig, kcontext := clusterFlags(fs)
+	fs.Parse(args)
+
+	os.Exit(runDiff(os.Stdout, os.Stderr, *against, *current, func() (*cluster, error) {
+		return newCluster(*kubeconfig, *kcontext)
+	}, *maxDrop, *failOnRegression, *top))
+}
+
+// diffReport is the part of a report compared: its metadata and nodes.
+type diffReport struct {
+	Metadata struct {
+		Timestamp       time.Time `json:"timestamp"`
+		Context         string    `json:"context"`
+		Resource        string    `json:"resource"`
+		AdditionalInput string    `json:"additionalInput"`
+	} `json:"metadata"`
+	Nodes []*kubecap.NodeReport `json:"nodes"`
+
+	// Workloads are nil for reports saved before they included them.
+	Workloads []*kubecap.EfficiencyEntry `json:"workloads"`
+}
+
+// resourceName returns the resource the report is about.
+func (r *diffReport) resourceName() corev1.ResourceName {
+	md := kubecap.Metadata{Resource: r.Metadata.Resource}
+
+	return md.ResourceName()
+}
+
+// loadDiffReport reads a report written with -o json or -o yaml.
+func loadDiffReport(path string) (*diffReport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &diffReport{}
+
+	err = yaml.Unmarshal(data, r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return r, nil
+}
+
+// runDiff compares the report at current, or one collected from the cluster
+// returned by newCluster, with the baseline at against and writes the changes
+// in each node's schedulable capacity to w. It returns the exit code: with
+// failOnRegression, checkExitFail when the cluster's schedulable capacity
+// dropped more than maxDrop. When it dropped, the top changes contributing
+// to the drop follow. Errors are written to errW.
+func runDiff(w, errW io.Writer, against, current string, newCluster func() (*cluster, error), maxDrop string, failOnRegression bool, top int) int {
+	fail := func(err error) int {
+		fmt.Fprintf(errW, "diff: %v\n", err)
+
+		return checkExitError
+	}
+
+	if against == "" {
+		return fail(fmt.Errorf("--against is required"))
+	}
+
+	before, err := loadDiffReport(against)
+	if err != nil {
+		return fail(err)
+	}
+
+	md := kubecap.Metadata{
+		Resource:        before.Metadata.Resource,
+		AdditionalInput: before.Metadata.AdditionalInput,
+	}
+
+	dropAmount, dropPercent, err := kubecap.ParseAdditional(md.ResourceName(), maxDrop)
+	if err != nil {
+		return fail(fmt.Errorf("--max-drop: %w", err))
+	}
+
+	var after *diffReport
+
+	if current != "" {
+		after, err = loadDiffReport(current)
+		if err != nil {
+			return fail(err)
+		}
+	} else {
+		c, err := newCluster()
+		if err != nil {
+			return fail(err)
+		}
+
+		r := &kubecap.ClusterReport{}
+
+		err = collectReport(context.TODO(), c, md, &recordOutput{r})
+		if err != nil {
+			return fail(err)
+		}
+
+		after = &diffReport{Nodes: r.Nodes, Workloads: kubecap.Leaderboard(r.Nodes, true, 0)}
+		after.Metadata.Timestamp = r.Metadata.Timestamp
+		after.Metadata.Context = r.Metadata.Context
+		after.Metadata.Resource = r.Metadata.Resource
+	}
+
+	if b, a := before.resourceName(), after.resourceName(); b != a {
+		return fail(fmt.Errorf("reports are on different resources: %s and %s", b, a))
+	}
+
+	writeDiff(w, before, after)
+
+	if contributors := dropContributors(before, after); len(contributors) > 0 {
+		fmt.Fprintln(w)
+		writeDropContributors(w, contributors, top, before.Workloads != nil && after.Workloads != nil)
+	}
+
+	b := kubecap.Summarize(before.Nodes).Schedulable
+	a := kubecap.Summarize(after.Nodes).Schedulable
+	drop := b - a
+
+	allowed := dropAmount
+	if dropPercent != 0 {
+		allowed = int64(float64(b) * dropPercent / 100)
+	}
+
+	if failOnRegression && drop > allowed {
+		fmt.Fprintf(errW, "diff: schedulable capacity dropped %s %s since %s, more than %s\n", humanize.Comma(drop), md.Unit(), before.Metadata.Timestamp.Format(time.RFC3339), maxDrop)
+
+		return checkExitFail
+	}
+
+	return checkExitPass
+}
+
+// writeDiff writes each node's schedulable capacity before and after,
+// including the nodes added and removed, and the cluster's in total.
+func writeDiff(w io.Writer, before, after *diffReport) {
+	schedulable := func(r *diffReport) map[string]*kubecap.NodeReport {
+		nodes := map[string]*kubecap.NodeReport{}
+		for _, n := range r.Nodes {
+			nodes[n.Name] = n
+		}
+
+		return nodes
+	}
+
+	b := schedulable(before)
+	a := schedulable(after)
+
+	names := []string{}
+	for name := range b {
+		names = append(names, name)
+	}
+
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	// Excluded nodes don't count towards the cluster's schedulable
+	// capacity and show as such.
+	amount := func(n *kubecap.NodeReport) (string, int64) {
+		switch {
+		case n == nil:
+			return "-", 0
+		case n.Excluded:
+			return "excluded", 0
+		}
+
+		return humanize.Comma(n.Schedulable), n.Schedulable
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Node", "Schedulable Before", "Schedulable After", "Change"})
+
+	for _, name := range names {
+		bs, bv := amount(b[name])
+		as, av := amount(a[name])
+
+		change := humanize.Comma(av - bv)
+		switch {
+		case b[name] == nil:
+			change = "added"
+		case a[name] == nil:
+			change = "removed"
+		}
+
+		table.Append([]string{name, bs, as, change})
+	}
+
+	bt := kubecap.Summarize(before.Nodes).Schedulable
+	at := kubecap.Summarize(after.Nodes).Schedulable
+
+	percent := "-"
+	if bt != 0 {
+		percent = fmt.Sprintf("%.1f%%", float64(at-bt)/float64(bt)*100)
+	}
+
+	table.Append([]string{"TOTAL", humanize.Comma(bt), humanize.Comma(at), fmt.Sprintf("%s (%s)", humanize.Comma(at-bt), percent)})
+
+	fmt.Fprintf(w, "Baseline: %s (%s)\n", before.Metadata.Timestamp.Format(time.RFC3339), before.Metadata.Context)
+	fmt.Fprintf(w, "Current: %s (%s)\n", after.Metadata.Timestamp.Format(time.RFC3339), after.Metadata.Context)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Diff Report")
+	table.Render()
+}
+
+// dropContributor is a change between two reports lowering the cluster's
+// schedulable capacity: a node leaving or excluded, a node's allocatable
+// shrinking or a workload's requests growing. Impact is the change in
+// schedulable capacity it accounts for, negative.
+type dropContributor struct {
+	Change string
+	Name   string
+	Impact int64
+}
+
+// dropContributors correlates a drop in the cluster's schedulable capacity
+// between the reports with the changes behind it, largest first. It returns
+// nil when the capacity didn't drop. Workloads are only compared when both
+// reports include them.
+func dropContributors(before, after *diffReport) []*dropContributor {
+	if kubecap.Summarize(after.Nodes).Schedulable >= kubecap.Summarize(before.Nodes).Schedulable {
+		return nil
+	}
+
+	contributors := []*dropContributor{}
+
+	a := map[string]*kubecap.NodeReport{}
+	for _, n := range after.Nodes {
+		a[n.Name] = n
+	}
+
+	for _, bn := range before.Nodes {
+		if bn.Excluded {
+			continue
+		}
+
+		an := a[bn.Name]
+
+		switch {
+		case an == nil:
+			contributors = append(contributors, &dropContributor{"node left", bn.Name, -bn.Schedulable})
+		case an.Excluded:
+			contributors = append(contributors, &dropContributor{"node excluded", bn.Name, -bn.Schedulable})
+		case an.Allocatable < bn.Allocatable:
+			contributors = append(contributors, &dropContributor{"allocatable shrank", bn.Name, an.Allocatable - bn.Allocatable})
+		}
+	}
+
+	if before.Workloads != nil && after.Workloads != nil {
+		key := func(e *kubecap.EfficiencyEntry) string {
+			return e.Namespace + "/" + e.Kind + "/" + e.Name
+		}
+
+		b := map[string]int64{}
+		for _, e := range before.Workloads {
+			b[key(e)] += e.Requests
+		}
+
+		grown := map[string]int64{}
+		for _, e := range after.Workloads {
+			grown[key(e)] += e.Requests
+		}
+
+		for k, requests := range grown {
+			requested, ok := b[k]
+			if requests <= requested {
+				continue
+			}
+
+			change := "requests grew"
+			if !ok {
+				change = "new workload"
+			}
+
+			contributors = append(contributors, &dropContributor{change, k, requested - requests})
+		}
+	}
+
+	sort.Slice(contributors, func(i, j int) bool {
+		if contributors[i].Impact != contributors[j].Impact {
+			return contributors[i].Impact < contributors[j].Impact
+		}
+
+		return contributors[i].Name < contributors[j].Name
+	})
+
+	return contributors
+}
+
+// writeDropContributors writes the top (all when 0) contributors to the
+// drop.
+func writeDropContributors(w io.Writer, contributors []*dropContributor, top int, workloads bool) {
+	if top > 0 && len(contributors) > top {
+		contributors = contributors[:top]
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Change", "Name", "Impact"})
+
+	for _, c := range contributors {
+		table.Append([]string{c.Change, c.Name, humanize.Comma(c.Impact)})
+	}
+
+	fmt.Fprintln(w, "What Changed")
+	table.Render()
+
+	if !workloads {
+		fmt.Fprintln(w, "Workload requests aren't compared: a report doesn't include them.")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestRunDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubecap-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, schedulable map[string]int64) string {
+		r := &diffReport{}
+		r.Metadata.Resource = "memory"
+
+		for _, node := range []string{"node-a", "node-b", "node-c"} {
+			if s, ok := schedulable[node]; ok {
+				r.Nodes = append(r.Nodes, &kubecap.NodeReport{Name: node, Schedulable: s})
+			}
+		}
+
+		data, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, name)
+
+		err = ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	baseline := write("baseline.json", map[string]int64{"node-a": 100, "node-b": 100})
+	smaller := write("smaller.json", map[string]int64{"node-a": 95, "node-c": 10})
+	slightly := write("slightly.json", map[string]int64{"node-a": 95, "node-b": 100})
+
+	diff := func(current, maxDrop string, failOnRegression bool) (int, string, string) {
+		var w, errW bytes.Buffer
+
+		code := runDiff(&w, &errW, baseline, current, func() (*cluster, error) {
+			t.Fatal("unexpected cluster")
+
+			return nil, nil
+		}, maxDrop, failOnRegression, 10)
+
+		return code, w.String(), errW.String()
+	}
+
+	code, out, _ := diff(smaller, "10%", false)
+	if code != checkExitPass {
+		t.Errorf("without --fail-on-regression: code %d", code)
+	}
+
+	for _, want := range []string{"removed", "added", "-95 (-47.5%)", "node left"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q:\n%s", want, out)
+		}
+	}
+
+	code, _, errOut := diff(smaller, "10%", true)
+	if code != checkExitFail || !strings.HasPrefix(errOut, "diff: schedulable capacity dropped 95 bytes") {
+		t.Errorf("regression: code %d: %s", code, errOut)
+	}
+
+	code, _, errOut = diff(slightly, "10%", true)
+	if code != checkExitPass {
+		t.Errorf("drop within percentage: code %d: %s", code, errOut)
+	}
+
+	code, _, errOut = diff(slightly, "4", true)
+	if code != checkExitFail {
+		t.Errorf("drop beyond amount: code %d: %s", code, errOut)
+	}
+
+	code, _, errOut = diff(filepath.Join(dir, "missing.json"), "10%", true)
+	if code != checkExitError || !strings.HasPrefix(errOut, "diff: ") {
+		t.Errorf("missing report: code %d: %s", code, errOut)
+	}
+}
+
+func TestRunDiffCluster(t *testing.T) {
+	c, closeSimulation, err := loadSimulatedCluster("testdata/simulation.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSimulation()
+
+	newCluster := func() (*cluster, error) { return c, nil }
+
+	// A baseline of the cluster as it is shows no change.
+	r := &kubecap.ClusterReport{}
+
+	err = collectReport(context.TODO(), c, kubecap.Metadata{}, &recordOutput{r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "kubecap-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	err = json.NewEncoder(f).Encode(&diffReport{Nodes: r.Nodes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var w, errW bytes.Buffer
+
+	code := runDiff(&w, &errW, f.Name(), "", newCluster, "0", true, 10)
+	if code != checkExitPass {
+		t.Errorf("unchanged cluster: code %d: %s", code, errW.String())
+	}
+
+	if !strings.Contains(w.String(), "node-a") || !strings.Contains(w.String(), "0 (0.0%)") {
+		t.Errorf("unexpected diff:\n%s", w.String())
+	}
+}
+
+func TestDropContributors(t *testing.T) {
+	before := &diffReport{
+		Nodes: []*kubecap.NodeReport{
+			{Name: "node-a", Allocatable: 100, Schedulable: 60},
+			{Name: "node-b", Allocatable: 100, Schedulable: 50},
+			{Name: "node-c", Allocatable: 100, Schedulable: 40},
+		},
+		Workloads: []*kubecap.EfficiencyEntry{
+			{Namespace: "shop", Kind: "Deployment", Name: "web", Requests: 40},
+			{Namespace: "shop", Kind: "Deployment", Name: "cart", Requests: 50},
+		},
+	}
+
+	after := &diffReport{
+		Nodes: []*kubecap.NodeReport{
+			{Name: "node-a", Allocatable: 90, Schedulable: 20},
+			{Name: "node-b", Allocatable: 100, Schedulable: 10},
+		},
+		Workloads: []*kubecap.EfficiencyEntry{
+			{Namespace: "shop", Kind: "Deployment", Name: "web", Requests: 70},
+			{Namespace: "shop", Kind: "Deployment", Name: "cart", Requests: 45},
+			{Namespace: "batch", Kind: "Job", Name: "etl", Requests: 15},
+		},
+	}
+
+	got := []string{}
+	for _, c := range dropContributors(before, after) {
+		got = append(got, fmt.Sprintf("%s %s %d", c.Change, c.Name, c.Impact))
+	}
+
+	want := []string{
+		"node left node-c -40",
+		"requests grew shop/Deployment/web -30",
+		"new workload batch/Job/etl -15",
+		"allocatable shrank node-a -10",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("contributors = %q, want %q", got, want)
+	}
+
+	// Without workloads in the baseline only the nodes are compared.
+	before.Workloads = nil
+
+	if c := dropContributors(before, after); len(c) != 2 {
+		t.Errorf("without baseline workloads: %d contributors", len(c))
+	}
+
+	if c := dropContributors(after, before); c != nil {
+		t.Errorf("capacity grew: %d contributors", len(c))
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// emailDispatcher mails the report as an attachment.
+type emailDispatcher struct {
+	addr     string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+func newEmailDispatcher(addr, from, to, username, password string) *emailDispatcher {
+	d := &emailDispatcher{
+		addr:     addr,
+		from:     from,
+		username: username,
+		password: password,
+	}
+
+	for _, rcpt := range strings.Split(to, ",") {
+		d.to = append(d.to, strings.TrimSpace(rcpt))
+	}
+
+	return d
+}
+
+func (d *emailDispatcher) dispatch(ctx context.Context, r *scheduledReport) error {
+	msg := &bytes.Buffer{}
+	mw := multipart.NewWriter(msg)
+
+	fmt.Fprintf(msg, "From: %s\r\n", d.from)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(d.to, ", "))
+	fmt.Fprintf(msg, "Subject: kubecap report for %s\r\n", r.md.Context)
+	fmt.Fprintf(msg, "Date: %s\r\n", r.md.Timestamp.Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	text, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(text, "%s\r\n", r.summary())
+
+	attachment, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {r.contentType()},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", r.filename())},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Base64 lines must be at most 76 characters.
+	encoded := base64.StdEncoding.EncodeToString(r.body)
+	for len(encoded) > 76 {
+		fmt.Fprintf(attachment, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(attachment, "%s\r\n", encoded)
+
+	err = mw.Close()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if d.username != "" {
+		host, _, err := net.SplitHostPort(d.addr)
+		if err != nil {
+			return err
+		}
+
+		auth = smtp.PlainAuth("", d.username, d.password, host)
+	}
+
+	err = smtp.SendMail(d.addr, auth, d.from, d.to, msg.Bytes())
+	if err != nil {
+		return fmt.Errorf("email report: %w", err)
+	}
+
+	return nil
+}
+
+// slackMaxText keeps posted messages well under Slack's 40,000 character
+// limit.
+const slackMaxText = 38000
+
+// slackDispatcher posts the report's summary to a Slack incoming webhook,
+// followed by the report itself for table output.
+type slackDispatcher struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func newSlackDispatcher(webhookURL string) *slackDispatcher {
+	return &slackDispatcher{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (d *slackDispatcher) dispatch(ctx context.Context, r *scheduledReport) error {
+	text := r.summary()
+
+	if r.format == "table" {
+		table := string(r.body)
+		if len(text)+len(table) > slackMaxText {
+			table = table[:slackMaxText-len(text)] + "\n(truncated)"
+		}
+
+		text += "\n```\n" + table + "```"
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("slack report: %s: %s", resp.Status, msg)
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// humanAmount formats an amount of the report's resource for people: a CPU
+// quantity (e.g. 1500m) or a number of bytes (e.g. 1.5 GiB).
+func humanAmount(md *kubecap.Metadata, v int64) string {
+	if md != nil && md.Unit() == "millicores" {
+		return resource.NewMilliQuantity(v, resource.DecimalSI).String()
+	}
+
+	if v < 0 {
+		return "-" + humanize.IBytes(uint64(-v))
+	}
+
+	return humanize.IBytes(uint64(v))
+}
+
+// HumanNode are a node's amounts formatted for people.
+type HumanNode struct {
+	Allocatable               string `json:"allocatable"`
+	Used                      string `json:"used"`
+	Free                      string `json:"free"`
+	Requests                  string `json:"requests"`
+	Schedulable               string `json:"schedulable"`
+	FreeWithAdditional        string `json:"freeWithAdditional"`
+	SchedulableWithAdditional string `json:"schedulableWithAdditional"`
+	Additional                string `json:"additional"`
+}
+
+func humanNode(md *kubecap.Metadata, n *kubecap.NodeReport) *HumanNode {
+	return &HumanNode{
+		Allocatable:               humanAmount(md, n.Allocatable),
+		Used:                      humanAmount(md, n.Used),
+		Free:                      humanAmount(md, n.Free),
+		Requests:                  humanAmount(md, n.Requests),
+		Schedulable:               humanAmount(md, n.Schedulable),
+		FreeWithAdditional:        humanAmount(md, n.FreeWithAdditional),
+		SchedulableWithAdditional: humanAmount(md, n.SchedulableWithAdditional),
+		Additional:                humanAmount(md, n.Additional),
+	}
+}
+
+// HumanEvictable are an evictable container's amounts formatted for people.
+type HumanEvictable struct {
+	Requests string `json:"requests"`
+	Used     string `json:"used"`
+	Limits   string `json:"limits"`
+}
+
+func humanEvictable(md *kubecap.Metadata, e *kubecap.EvictableContainer) *HumanEvictable {
+	return &HumanEvictable{
+		Requests: humanAmount(md, e.Requests),
+		Used:     humanAmount(md, e.Used),
+		Limits:   humanAmount(md, e.Limits),
+	}
+}
+
+type documentNode struct {
+	*kubecap.NodeReport
+	Human *HumanNode `json:"human"`
+}
+
+type documentEvictable struct {
+	*kubecap.EvictableContainer
+	Human *HumanEvictable `json:"human"`
+}
+
+// document is the whole report as a single JSON or YAML document.
+type document struct {
+	Metadata  *kubecap.Metadata    `json:"metadata"`
+	Nodes     []*documentNode      `json:"nodes"`
+	Evictable []*documentEvictable `json:"evictable"`
+
+	// Workloads are the requests by workload, so that diff can tell which
+	// grew between saved reports.
+	Workloads []*kubecap.EfficiencyEntry `json:"workloads"`
+}
+
+// documentOutput writes the whole report as a single JSON or YAML document
+// once it is complete, with each amount both raw and formatted for people.
+type documentOutput struct {
+	w    io.Writer
+	yaml bool
+	md   *kubecap.Metadata
+
+	nodes     []*kubecap.NodeReport
+	evictable []*kubecap.EvictableContainer
+}
+
+func newDocumentOutput(w io.Writer, asYAML bool) *documentOutput {
+	return &documentOutput{w: w, yaml: asYAML}
+}
+
+func (d *documentOutput) Metadata(m *kubecap.Metadata) error {
+	d.md = m
+
+	return nil
+}
+
+func (d *documentOutput) Node(n *kubecap.NodeReport) error {
+	d.nodes = append(d.nodes, n)
+
+	return nil
+}
+
+func (d *documentOutput) Evictable(e *kubecap.EvictableContainer) error {
+	d.evictable = append(d.evictable, e)
+
+	return nil
+}
+
+func (d *documentOutput) Flush() error {
+	doc := document{
+		Metadata:  d.md,
+		Nodes:     []*documentNode{},
+		Evictable: []*documentEvictable{},
+		Workloads: kubecap.Leaderboard(d.nodes, true, 0),
+	}
+
+	for _, n := range d.nodes {
+		doc.Nodes = append(doc.Nodes, &documentNode{n, humanNode(d.md, n)})
+	}
+
+	for _, e := range d.evictable {
+		doc.Evictable = append(doc.Evictable, &documentEvictable{e, humanEvictable(d.md, e)})
+	}
+
+	if d.yaml {
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+
+		_, err = d.w.Write(data)
+
+		return err
+	}
+
+	enc := json.NewEncoder(d.w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(doc)
+}
+
+// csvOutput writes the node report and, after a blank line, the evictable
+// containers report as CSV, each with a header row. Amounts are raw with a
+// column formatted for people alongside.
+type csvOutput struct {
+	w  io.Writer
+	md *kubecap.Metadata
+
+	nodes     []*kubecap.NodeReport
+	evictable []*kubecap.EvictableContainer
+}
+
+func newCSVOutput(w io.Writer) *csvOutput {
+	return &csvOutput{w: w}
+}
+
+func (c *csvOutput) Metadata(m *kubecap.Metadata) error {
+	c.md = m
+
+	return nil
+}
+
+func (c *csvOutput) Node(n *kubecap.NodeReport) error {
+	c.nodes = append(c.nodes, n)
+
+	return nil
+}
+
+func (c *csvOutput) Evictable(e *kubecap.EvictableContainer) error {
+	c.evictable = append(c.evictable, e)
+
+	return nil
+}
+
+// amountColumns returns the raw and human columns of the amounts.
+func (c *csvOutput) amountColumns(amounts ...int64) []string {
+	cols := []string{}
+	for _, v := range amounts {
+		cols = append(cols, strconv.FormatInt(v, 10), humanAmount(c.md, v))
+	}
+
+	return cols
+}
+
+func (c *csvOutput) Flush() error {
+	cw := csv.NewWriter(c.w)
+
+	header := []string{
+		"cluster", "node", "group",
+		"allocatable", "allocatable_human",
+		"used", "used_human",
+		"free", "free_human",
+		"requests", "requests_human",
+		"schedulable", "schedulable_human",
+		"free_with_additional", "free_with_additional_human",
+		"schedulable_with_additional", "schedulable_with_additional_human",
+		"efficiency", "pressure", "ok",
+	}
+
+	if c.md != nil {
+		for _, col := range c.md.Columns {
+			header = append(header, col.Name)
+		}
+	}
+
+	err := cw.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range c.nodes {
+		row := []string{n.Cluster, n.Name, n.Group}
+		row = append(row, c.amountColumns(n.Allocatable, n.Used, n.Free, n.Requests, n.Schedulable, n.FreeWithAdditional, n.SchedulableWithAdditional)...)
+		row = append(row,
+			strconv.FormatFloat(n.Efficiency, 'f', 2, 64),
+			strconv.FormatFloat(n.Pressure, 'f', 0, 64),
+			strconv.FormatBool(n.Ok),
+		)
+
+		if c.md != nil {
+			for _, col := range c.md.Columns {
+				row = append(row, strconv.FormatFloat(n.Columns[col.Name], 'f', -1, 64))
+			}
+		}
+
+		err = cw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	err = cw.Error()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(c.w, "\n")
+	if err != nil {
+		return err
+	}
+
+	breakdown := c.md != nil && c.md.MemoryBreakdown()
+
+	header = []string{
+		"cluster", "node", "namespace", "pod", "container", "qos_class", "priority",
+		"requests", "requests_human",
+		"used", "used_human",
+		"limits", "limits_human",
+	}
+
+	if breakdown {
+		header = append(header, "rss", "rss_human", "cache", "cache_human", "swap", "swap_human")
+	}
+
+	cpu := c.md != nil && c.md.ContainerCPU()
+
+	if cpu {
+		header = append(header, "cpu_requests", "cpu_used", "cpu_limits", "cpu_over_request")
+	}
+
+	err = cw.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range c.evictable {
+		row := []string{e.Cluster, e.Node, e.Namespace, e.Pod, e.Container, e.QOSClass, strconv.Itoa(int(e.Priority))}
+		row = append(row, c.amountColumns(e.Requests, e.Used, e.Limits)...)
+
+		if breakdown {
+			if m := e.Memory; m != nil {
+				row = append(row, c.amountColumns(m.RSS, m.Cache, m.Swap)...)
+			} else {
+				row = append(row, "", "", "", "", "", "")
+			}
+		}
+
+		if cpu {
+			if e.CPU != nil {
+				row = append(row, strconv.FormatInt(e.CPU.Requests, 10), strconv.FormatInt(e.CPU.Used, 10), strconv.FormatInt(e.CPU.Limits, 10), strconv.FormatBool(e.CPU.OverRequest))
+			} else {
+				row = append(row, "", "", "", "")
+			}
+		}
+
+		err = cw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
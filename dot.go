@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+)
+
+// dotOutput writes a Graphviz graph with a cluster per node containing its
+// pods. Pod boxes are sized so their area is proportional to their memory
+// requests, which makes the current packing easy to see.
+type dotOutput struct {
+	w     io.Writer
+	md    *kubecap.Metadata
+	nodes []*kubecap.NodeReport
+}
+
+func newDotOutput(w io.Writer) *dotOutput {
+	return &dotOutput{w: w}
+}
+
+func (d *dotOutput) Metadata(m *kubecap.Metadata) error {
+	d.md = m
+
+	return nil
+}
+
+func (d *dotOutput) Node(n *kubecap.NodeReport) error {
+	d.nodes = append(d.nodes, n)
+
+	return nil
+}
+
+func (d *dotOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+// dotInchesPerGiB is the area (in square inches) a pod requesting 1GiB of
+// memory is drawn with.
+const dotInchesPerGiB = 0.25
+
+func (d *dotOutput) Flush() (err error) {
+	p := func(format string, args ...interface{}) {
+		if err != nil {
+			return
+		}
+
+		_, err = fmt.Fprintf(d.w, format, args...)
+	}
+
+	p("digraph kubecap {\n")
+	p("\tgraph [rankdir=LR, fontname=\"sans-serif\"];\n")
+	p("\tnode [shape=box, fixedsize=true, fontname=\"sans-serif\", fontsize=8];\n")
+
+	if d.md != nil {
+		label := fmt.Sprintf(
+			"%s (cluster %s, %s) collected %s\nadditional %s",
+			d.md.Context, d.md.Cluster, d.md.ServerVersion, d.md.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
+			d.md.AdditionalInput,
+		)
+		if q := d.md.Quota; q != nil {
+			label += fmt.Sprintf("\nnamespace %s blocked by: %s", q.Namespace, q.Blocker)
+		}
+
+		if c := d.md.Coverage; c != nil {
+			label += fmt.Sprintf("\nrequests coverage: memory %s, CPU %s", coveragePercent(c.Memory()), coveragePercent(c.CPU()))
+		}
+
+		if f := d.md.Scheduler; f != nil {
+			label += "\nscheduler: " + f.String()
+		}
+
+		if k := d.md.Kueue; k != nil {
+			for _, cq := range k.ClusterQueues {
+				label += fmt.Sprintf("\nClusterQueue %s pending %s blocked by: %s", cq.Name, humanize.IBytes(uint64(cq.Pending)), cq.Blocker)
+			}
+		}
+
+		if a := d.md.Autoscaler; a != nil {
+			for _, ng := range a.NodeGroups {
+				if ng.Full {
+					label += fmt.Sprintf("\nnode group %s full at max size %d", ng.Name, ng.Max)
+				}
+			}
+		}
+
+		p("\tlabel=%q;\n", label)
+	}
+
+	for i, n := range d.nodes {
+		p("\tsubgraph cluster_%d {\n", i)
+		p("\t\tlabel=%q;\n", fmt.Sprintf(
+			"%s (%s)\nrequests %s / allocatable %s",
+			n.Name,
+			n.Cluster,
+			humanize.IBytes(nonNegative(n.Requests)),
+			humanize.IBytes(nonNegative(n.Allocatable)),
+		))
+
+		if !n.Ok {
+			p("\t\tcolor=red;\n")
+		}
+
+		// Graphviz drops empty clusters so always include a placeholder.
+		if len(n.Pods) == 0 {
+			p("\t\tn%d [label=\"\", style=invis, width=0.1, height=0.1];\n", i)
+		}
+
+		for j, pod := range n.Pods {
+			side := math.Sqrt(float64(pod.Requests) / (1 << 30) * dotInchesPerGiB)
+			if side < 0.1 {
+				side = 0.1
+			}
+
+			p("\t\tn%dp%d [label=%q, width=%.2f, height=%.2f, tooltip=%q];\n",
+				i, j,
+				pod.Name,
+				side, side,
+				fmt.Sprintf("%s %s/%s %s", n.Cluster, pod.Namespace, pod.Name, humanize.IBytes(nonNegative(pod.Requests))),
+			)
+		}
+
+		p("\t}\n")
+	}
+
+	p("}\n")
+
+	return err
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+// Capacity event types.
+const (
+	// eventVerdict is a node's verdict flipping.
+	eventVerdict = "verdict"
+
+	// eventHeadroom is the cluster's schedulable amount crossing a
+	// threshold.
+	eventHeadroom = "headroom"
+
+	// eventPending is a pod newly waiting for capacity: bound to a node but
+	// not running yet, or nominated for one by preemption.
+	eventPending = "pending"
+)
+
+// eventsKeep is how many of the latest events are kept for clients resuming
+// their stream.
+const eventsKeep = 1000
+
+// capacityEvent is a change in capacity between two reports of the watch,
+// streamed to the clients subscribed to /events (or WatchEvents over gRPC)
+// so they can react to it rather than poll the reports.
+type capacityEvent struct {
+	// ID increases with each event, so a client resumes from the last it
+	// got.
+	ID   int64     `json:"id"`
+	Type string    `json:"type"`
+	At   time.Time `json:"at"`
+
+	// Node and Ok are the node and its new verdict, for verdict events.
+	Node string `json:"node,omitempty"`
+	Ok   *bool  `json:"ok,omitempty"`
+
+	// Threshold is the amount crossed, Direction whether the schedulable
+	// amount fell below or rose above it, and Headroom and Previous the
+	// amount after and before, for headroom events.
+	Threshold int64  `json:"threshold,omitempty"`
+	Direction string `json:"direction,omitempty"`
+	Headroom  *int64 `json:"headroom,omitempty"`
+	Previous  *int64 `json:"previous,omitempty"`
+
+	// Namespace, Pod, State and Requests are the pod, its state and its
+	// requests, for pending events. Node is the node it is bound or
+	// nominated to.
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	State     string `json:"state,omitempty"`
+	Requests  int64  `json:"requests,omitempty"`
+}
+
+// diffReports returns the events from the previous report to the current
+// one, without IDs: the nodes in both whose verdict flipped, the thresholds
+// the schedulable amount crossed and the pods pending now that weren't
+// before. There are none without a previous report.
+func diffReports(prev, cur *kubecap.ClusterReport, thresholds []int64) []*capacityEvent {
+	if prev == nil || cur == nil {
+		return nil
+	}
+
+	at := time.Now()
+	if cur.Metadata != nil {
+		at = cur.Metadata.Timestamp
+	}
+
+	events := []*capacityEvent{}
+
+	was := map[string]bool{}
+	for _, n := range prev.Nodes {
+		was[n.Name] = n.Ok
+	}
+
+	for _, n := range cur.Nodes {
+		ok, seen := was[n.Name]
+		if seen && ok != n.Ok {
+			ok := n.Ok
+			events = append(events, &capacityEvent{Type: eventVerdict, At: at, Node: n.Name, Ok: &ok})
+		}
+	}
+
+	before, after := prev.Summary().Schedulable, cur.Summary().Schedulable
+
+	sorted := append([]int64{}, thresholds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	for _, t := range sorted {
+		direction := ""
+
+		switch {
+		case before >= t && after < t:
+			direction = "below"
+		case before < t && after >= t:
+			direction = "above"
+		default:
+			continue
+		}
+
+		events = append(events, &capacityEvent{Type: eventHeadroom, At: at, Threshold: t, Direction: direction, Headroom: &after, Previous: &before})
+	}
+
+	pending := func(p *kubecap.PodReport) bool {
+		return p.State == kubecap.PodStatePending || p.State == kubecap.PodStateNominated
+	}
+
+	waiting := map[string]bool{}
+	for _, n := range prev.Nodes {
+		for _, p := range n.Pods {
+			if pending(p) {
+				waiting[p.Namespace+"/"+p.Name] = true
+			}
+		}
+	}
+
+	for _, n := range cur.Nodes {
+		for _, p := range n.Pods {
+			if pending(p) && !waiting[p.Namespace+"/"+p.Name] {
+				events = append(events, &capacityEvent{Type: eventPending, At: at, Node: n.Name, Namespace: p.Namespace, Pod: p.Name, State: p.State, Requests: p.Requests})
+			}
+		}
+	}
+
+	return events
+}
+
+// record numbers the events and keeps them, forgetting the oldest past
+// eventsKeep. It is called with the server's lock held.
+func (s *reportServer) record(events []*capacityEvent) {
+	for _, e := range events {
+		s.lastEventID++
+		e.ID = s.lastEventID
+
+		s.events = append(s.events, e)
+	}
+
+	if len(s.events) > eventsKeep {
+		s.events = append([]*capacityEvent{}, s.events[len(s.events)-eventsKeep:]...)
+	}
+}
+
+// eventsAfter returns the events kept after the ID and a channel closed once
+// a new report is served.
+func (s *reportServer) eventsAfter(id int64) ([]*capacityEvent, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.updated == nil {
+		s.updated = make(chan struct{})
+	}
+
+	i := sort.Search(len(s.events), func(i int) bool { return s.events[i].ID > id })
+
+	return append([]*capacityEvent{}, s.events[i:]...), s.updated
+}
+
+// handleEvents streams the events as server-sent events until the client
+// goes away: those after Last-Event-ID (or ?after=) when resuming, else only
+// those to come.
+func (s *reportServer) handleEvents(w http.ResponseWriter, req *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+
+		return
+	}
+
+	last := req.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = req.URL.Query().Get("after")
+	}
+
+	s.mu.Lock()
+	after := s.lastEventID
+	s.mu.Unlock()
+
+	if last != "" {
+		id, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("event ID %q: %v", last, err), http.StatusBadRequest)
+
+			return
+		}
+
+		after = id
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	for {
+		events, updated := s.eventsAfter(after)
+
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			if err != nil {
+				return
+			}
+
+			after = e.ID
+		}
+
+		f.Flush()
+
+		select {
+		case <-updated:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestDiffReports(t *testing.T) {
+	prev := &kubecap.ClusterReport{
+		Metadata: &kubecap.Metadata{},
+		Nodes: []*kubecap.NodeReport{
+			{Name: "node-a", Ok: true, Schedulable: 40, Pods: []*kubecap.PodReport{
+				{Namespace: "ns", Name: "old", State: kubecap.PodStatePending},
+			}},
+			{Name: "node-b", Ok: true, Schedulable: 30},
+		},
+	}
+
+	cur := &kubecap.ClusterReport{
+		Metadata: &kubecap.Metadata{Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		Nodes: []*kubecap.NodeReport{
+			{Name: "node-a", Ok: false, Schedulable: 5, Pods: []*kubecap.PodReport{
+				{Namespace: "ns", Name: "old", State: kubecap.PodStatePending},
+				{Namespace: "ns", Name: "new", State: kubecap.PodStatePending, Requests: 10},
+				{Namespace: "ns", Name: "running", State: "Running"},
+			}},
+			{Name: "node-b", Ok: true, Schedulable: 30},
+			// New nodes have no verdict to flip.
+			{Name: "node-c", Ok: false, Schedulable: 0},
+		},
+	}
+
+	events := diffReports(prev, cur, []int64{50, 60, 80})
+
+	got := []string{}
+	for _, e := range events {
+		switch e.Type {
+		case eventVerdict:
+			got = append(got, e.Type+" "+e.Node)
+		case eventHeadroom:
+			got = append(got, e.Type+" "+e.Direction+" "+strconv.FormatInt(e.Threshold, 10))
+		case eventPending:
+			got = append(got, e.Type+" "+e.Namespace+"/"+e.Pod)
+		}
+
+		if !e.At.Equal(cur.Metadata.Timestamp) {
+			t.Errorf("%s event at %v, want %v", e.Type, e.At, cur.Metadata.Timestamp)
+		}
+	}
+
+	// The schedulable amount fell from 70 to 35.
+	want := []string{"verdict node-a", "headroom below 60", "headroom below 50", "pending ns/new"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	if events := diffReports(cur, prev, []int64{50}); len(events) != 2 || events[1].Direction != "above" {
+		t.Errorf("back: events = %v, want a verdict and headroom above 50", events)
+	}
+
+	if events := diffReports(nil, cur, []int64{50}); len(events) != 0 {
+		t.Errorf("first report: events = %v, want none", events)
+	}
+}
+
+func TestReportServerEvents(t *testing.T) {
+	s := &reportServer{thresholds: []int64{50}}
+
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	flush := func(schedulable int64) {
+		out := s.output()
+		out.Metadata(&kubecap.Metadata{})
+		out.Node(&kubecap.NodeReport{Name: "node-a", Schedulable: schedulable, Ok: schedulable > 0})
+		out.Flush()
+	}
+
+	flush(100)
+	flush(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Resuming from the start gets the events kept.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Last-Event-ID", "0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type = %q, want text/event-stream", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+
+	next := func() string {
+		t.Helper()
+
+		for lines.Scan() {
+			if strings.HasPrefix(lines.Text(), "event: ") {
+				event := strings.TrimPrefix(lines.Text(), "event: ")
+
+				lines.Scan()
+				return event + " " + lines.Text()
+			}
+		}
+
+		t.Fatalf("stream ended: %v", lines.Err())
+
+		return ""
+	}
+
+	if got := next(); !strings.HasPrefix(got, `verdict data: {"id":1,`) {
+		t.Errorf("first event = %s, want the verdict", got)
+	}
+
+	if got := next(); !strings.Contains(got, `"direction":"below"`) {
+		t.Errorf("second event = %s, want headroom below", got)
+	}
+
+	// Events to come are streamed as the reports are served.
+	flush(100)
+
+	if got := next(); !strings.HasPrefix(got, `verdict data: {"id":3,`) {
+		t.Errorf("third event = %s, want the verdict", got)
+	}
+}
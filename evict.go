@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// evictPlan is the pods to evict from a node to make room there for the
+// additional amount.
+type evictPlan struct {
+	node    string
+	victims []*kubecap.EvictionCandidate
+
+	// fits is set when the additional amount already fits on node, so
+	// nothing needs evicting.
+	fits bool
+}
+
+// disruptionBudgets is how many more disruptions each PodDisruptionBudget
+// (by namespace/name) allows, the budgets covering each pod and how many
+// pods each pod's workload runs. protected are the pods the eviction policy
+// forbids evicting, as they are now, and gone those deleted since the report.
+type disruptionBudgets struct {
+	allowed   map[string]int32
+	covers    map[string][]string
+	replicas  map[string]int32
+	protected map[string]bool
+	gone      map[string]bool
+}
+
+// listDisruptionBudgets lists the PodDisruptionBudgets covering the evictable
+// containers' pods and counts the running pods of their workloads. The pods
+// are checked against the eviction policy again since their labels may have
+// changed since the report.
+func listDisruptionBudgets(ctx context.Context, kcs kubernetes.Interface, evictable []*kubecap.EvictableContainer, policy *kubecap.EvictionPolicy) (*disruptionBudgets, error) {
+	pdbList, err := kcs.PolicyV1().PodDisruptionBudgets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	b := &disruptionBudgets{
+		allowed:   map[string]int32{},
+		covers:    map[string][]string{},
+		replicas:  map[string]int32{},
+		protected: map[string]bool{},
+		gone:      map[string]bool{},
+	}
+
+	// workloads are the running pods of each workload by namespace/kind/name,
+	// counted once per namespace.
+	workloads := map[string]int32{}
+	counted := map[string]bool{}
+
+	for _, e := range evictable {
+		key := e.Namespace + "/" + e.Pod
+		if _, ok := b.covers[key]; ok {
+			continue
+		}
+
+		b.covers[key] = []string{}
+
+		pod, err := kcs.CoreV1().Pods(e.Namespace).Get(ctx, e.Pod, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			// Deleted since the report (e.g. between watch runs): there
+			// is nothing left to evict.
+			b.gone[key] = true
+
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !counted[pod.Namespace] {
+			counted[pod.Namespace] = true
+
+			podList, err := kcs.CoreV1().Pods(pod.Namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+
+			for i := range podList.Items {
+				p := &podList.Items[i]
+				if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+					continue
+				}
+
+				if kind, name := kubecap.PodWorkload(p); kind != "" {
+					workloads[p.Namespace+"/"+kind+"/"+name]++
+				}
+			}
+		}
+
+		b.protected[key] = policy.Protects(pod)
+
+		b.replicas[key] = 1
+		if kind, name := kubecap.PodWorkload(pod); kind != "" && workloads[pod.Namespace+"/"+kind+"/"+name] > 0 {
+			b.replicas[key] = workloads[pod.Namespace+"/"+kind+"/"+name]
+		}
+
+		for i := range pdbList.Items {
+			pdb := &pdbList.Items[i]
+			if pdb.Namespace != pod.Namespace {
+				continue
+			}
+
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("pdb %s/%s: %w", pdb.Namespace, pdb.Name, err)
+			}
+
+			if selector.Matches(labels.Set(pod.Labels)) {
+				pdbKey := pdb.Namespace + "/" + pdb.Name
+				b.allowed[pdbKey] = pdb.Status.DisruptionsAllowed
+				b.covers[key] = append(b.covers[key], pdbKey)
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// planEvictions plans evicting the fewest pods over their requests from a
+// single node to make room there for the additional amount, or returns nil
+// if no node can be made room on. Candidates are taken in the order of the
+// metadata's eviction strategy (by default lowest priority first, then
+// largest over their requests first). kube-system pods, pods the eviction
+// policy protects, pods deleted since the report and pods whose disruption
+// budgets allow no more disruptions are left alone; DaemonSet pods are never
+// evictable.
+func planEvictions(md *kubecap.Metadata, nodes []*kubecap.NodeReport, evictable []*kubecap.EvictableContainer, budgets *disruptionBudgets) (*evictPlan, error) {
+	strategy, err := kubecap.EvictionStrategyNamed(md.EvictionStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *evictPlan
+
+	for _, n := range nodes {
+		if n.Ok {
+			return &evictPlan{node: n.Name, fits: true}, nil
+		}
+
+		if n.Excluded || n.OutOfScope {
+			continue
+		}
+
+		// The additional amount fits once both free and schedulable are
+		// above it and the node's margin.
+		needUsed := n.Additional + n.Margin + 1 - n.Free
+		needRequests := n.Additional + n.Margin + 1 - n.Schedulable
+
+		// Evicting can't help nodes failing for other reasons (NUMA, DRA,
+		// the scheduler, ...).
+		if needUsed <= 0 && needRequests <= 0 {
+			continue
+		}
+
+		victims := evictCandidates(n, evictable, budgets, strategy)
+		allowed := map[string]int32{}
+		for k, v := range budgets.allowed {
+			allowed[k] = v
+		}
+
+		plan := &evictPlan{node: n.Name}
+
+		for _, v := range victims {
+			if needUsed <= 0 && needRequests <= 0 {
+				break
+			}
+
+			if v.Namespace == "kube-system" || budgets.protected[v.Namespace+"/"+v.Pod] || budgets.gone[v.Namespace+"/"+v.Pod] {
+				continue
+			}
+
+			pdbs := budgets.covers[v.Namespace+"/"+v.Pod]
+
+			blocked := false
+			for _, pdb := range pdbs {
+				if allowed[pdb] <= 0 {
+					blocked = true
+				}
+			}
+
+			if blocked {
+				continue
+			}
+
+			for _, pdb := range pdbs {
+				allowed[pdb]--
+			}
+
+			plan.victims = append(plan.victims, v)
+			needUsed -= v.Used
+			needRequests -= v.Requests
+		}
+
+		if needUsed > 0 || needRequests > 0 {
+			continue
+		}
+
+		if best == nil || len(plan.victims) < len(best.victims) {
+			best = plan
+		}
+	}
+
+	return best, nil
+}
+
+// evictCandidates returns the pods on the node with evictable containers in
+// the order the strategy evicts them.
+func evictCandidates(n *kubecap.NodeReport, evictable []*kubecap.EvictableContainer, budgets *disruptionBudgets, strategy kubecap.EvictionStrategy) []*kubecap.EvictionCandidate {
+	victims := []*kubecap.EvictionCandidate{}
+	byPod := map[string]*kubecap.EvictionCandidate{}
+
+	for _, e := range evictable {
+		if e.Node != n.Name {
+			continue
+		}
+
+		key := e.Namespace + "/" + e.Pod
+
+		v, ok := byPod[key]
+		if !ok {
+			v = &kubecap.EvictionCandidate{
+				Namespace:          e.Namespace,
+				Pod:                e.Pod,
+				Priority:           e.Priority,
+				Created:            e.PodCreated,
+				DisruptionsAllowed: -1,
+				Replicas:           1,
+			}
+
+			if replicas, ok := budgets.replicas[key]; ok {
+				v.Replicas = replicas
+			}
+
+			for _, p := range n.Pods {
+				if p.Namespace == e.Namespace && p.Name == e.Pod {
+					v.Requests = p.Requests
+					v.Used = p.Used
+				}
+			}
+
+			for _, pdb := range budgets.covers[key] {
+				if allowed := budgets.allowed[pdb]; v.DisruptionsAllowed < 0 || allowed < v.DisruptionsAllowed {
+					v.DisruptionsAllowed = allowed
+				}
+			}
+
+			byPod[key] = v
+			victims = append(victims, v)
+		}
+
+		v.OverRequests += e.Used - e.Requests
+		v.Restarts += e.Restarts
+	}
+
+	kubecap.RankEvictionCandidates(victims, strategy)
+
+	return victims
+}
+
+// evictOutput evicts the pods over their requests needed to make room for
+// the additional amount once the report is flushed. With dryRun the
+// evictions are only submitted as server-side dry runs.
+type evictOutput struct {
+	ctx    context.Context
+	kcs    kubernetes.Interface
+	w      io.Writer
+	dryRun bool
+
+	md        *kubecap.Metadata
+	nodes     []*kubecap.NodeReport
+	evictable []*kubecap.EvictableContainer
+}
+
+func (o *evictOutput) Metadata(m *kubecap.Metadata) error {
+	o.md = m
+
+	return nil
+}
+
+func (o *evictOutput) Node(n *kubecap.NodeReport) error {
+	o.nodes = append(o.nodes, n)
+
+	return nil
+}
+
+func (o *evictOutput) Evictable(e *kubecap.EvictableContainer) error {
+	o.evictable = append(o.evictable, e)
+
+	return nil
+}
+
+func (o *evictOutput) Flush() error {
+	budgets, err := listDisruptionBudgets(o.ctx, o.kcs, o.evictable, o.md.EvictionPolicy)
+	if err != nil {
+		return err
+	}
+
+	plan, err := planEvictions(o.md, o.nodes, o.evictable, budgets)
+	if err != nil {
+		return err
+	}
+
+	if plan == nil {
+		fmt.Fprintln(o.w, "Evict: no node can make room for the additional amount by evicting pods over their requests")
+
+		return nil
+	}
+
+	if plan.fits {
+		fmt.Fprintf(o.w, "Evict: nothing to evict, the additional amount fits on %s\n", plan.node)
+
+		return nil
+	}
+
+	var opts metav1.DeleteOptions
+	heading := "Evicted from %s to make room for the additional amount:\n"
+
+	if o.dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+		heading = "Would evict from %s to make room for the additional amount (dry run):\n"
+	}
+
+	fmt.Fprintf(o.w, heading, plan.node)
+
+	table := tablewriter.NewWriter(o.w)
+	table.SetHeader([]string{"Namespace", "Pod", "Priority", "Over Requests", "Requests", "Used", "Restarts", "Disruptions Allowed", "Replicas", "Disruption Score"})
+
+	defer table.Render()
+
+	for _, v := range plan.victims {
+		err := o.kcs.CoreV1().Pods(v.Namespace).Evict(o.ctx, &policyv1beta1.Eviction{
+			ObjectMeta:    metav1.ObjectMeta{Namespace: v.Namespace, Name: v.Pod},
+			DeleteOptions: &opts,
+		})
+		if err != nil {
+			return fmt.Errorf("evict %s/%s: %w", v.Namespace, v.Pod, err)
+		}
+
+		allowed := "-"
+		if v.DisruptionsAllowed >= 0 {
+			allowed = fmt.Sprintf("%d", v.DisruptionsAllowed)
+		}
+
+		table.Append([]string{
+			v.Namespace,
+			v.Pod,
+			fmt.Sprintf("%d", v.Priority),
+			humanize.Comma(v.OverRequests),
+			humanize.Comma(v.Requests),
+			humanize.Comma(v.Used),
+			fmt.Sprintf("%d", v.Restarts),
+			allowed,
+			fmt.Sprintf("%d", v.Replicas),
+			fmt.Sprintf("%.2f", v.DisruptionScore()),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestPlanEvictions(t *testing.T) {
+	// Each pod requests half what it uses.
+	pods := func(used map[string]int64) []*kubecap.PodReport {
+		prs := []*kubecap.PodReport{}
+		for name, u := range used {
+			prs = append(prs, &kubecap.PodReport{Namespace: "shop", Name: name, Requests: u / 2, Used: u})
+		}
+
+		return prs
+	}
+
+	// node-a needs 3Gi more free and 1Gi more schedulable, node-b 1Gi more
+	// free.
+	nodes := []*kubecap.NodeReport{
+		{Name: "node-a", Additional: 4 << 30, Free: 1 << 30, Schedulable: 3 << 30, Pods: pods(map[string]int64{"a": 2 << 30, "b": 4 << 30, "c": 2 << 30})},
+		{Name: "node-b", Additional: 4 << 30, Free: 3 << 30, Schedulable: 5 << 30, Pods: pods(map[string]int64{"d": 2 << 30, "e": 2 << 30})},
+		{Name: "node-c", Additional: 4 << 30, Excluded: true, Pods: pods(map[string]int64{"f": 16 << 30})},
+	}
+
+	evictable := []*kubecap.EvictableContainer{
+		{Node: "node-a", Namespace: "shop", Pod: "a", Container: "c", Priority: 0, Requests: 1 << 30, Used: 2 << 30},
+		{Node: "node-a", Namespace: "shop", Pod: "b", Container: "c", Priority: 0, Requests: 2 << 30, Used: 4 << 30},
+		{Node: "node-a", Namespace: "shop", Pod: "c", Container: "c", Priority: 100, Requests: 1 << 30, Used: 2 << 30},
+		{Node: "node-b", Namespace: "kube-system", Pod: "d", Container: "c", Requests: 1 << 30, Used: 2 << 30},
+		{Node: "node-b", Namespace: "shop", Pod: "e", Container: "c", Requests: 1 << 30, Used: 2 << 30},
+		{Node: "node-c", Namespace: "shop", Pod: "f", Container: "c", Requests: 8 << 30, Used: 16 << 30},
+	}
+
+	md := &kubecap.Metadata{Additional: 4 << 30}
+
+	budgets := &disruptionBudgets{
+		allowed: map[string]int32{"shop/web": 0},
+		covers:  map[string][]string{},
+	}
+
+	// Both nodes need a single eviction: the largest over its requests on
+	// node-a, and the shop pod on node-b as kube-system is left alone.
+	// node-a comes first.
+	plan, err := planEvictions(md, nodes, evictable, budgets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan == nil || plan.node != "node-a" || len(plan.victims) != 1 || plan.victims[0].Pod != "b" {
+		t.Fatalf("plan = %+v", plan)
+	}
+
+	// With b's budget exhausted node-a needs two evictions, taking a before
+	// the higher priority c, so node-b is chosen.
+	budgets.covers["shop/b"] = []string{"shop/web"}
+
+	plan, _ = planEvictions(md, nodes, evictable, budgets)
+	if plan == nil || plan.node != "node-b" || len(plan.victims) != 1 || plan.victims[0].Pod != "e" {
+		t.Fatalf("budget exhausted: plan = %+v", plan)
+	}
+
+	plan, _ = planEvictions(md, nodes[:1], evictable, budgets)
+	if plan == nil || len(plan.victims) != 2 || plan.victims[0].Pod != "a" || plan.victims[1].Pod != "c" {
+		t.Fatalf("node-a only: plan = %+v", plan)
+	}
+
+	// Excluded nodes are never made room on.
+	if plan, _ := planEvictions(md, nodes[2:], evictable, budgets); plan != nil {
+		t.Errorf("excluded node: plan = %+v", plan)
+	}
+
+	nodes[1].Ok = true
+
+	if plan, _ := planEvictions(md, nodes, evictable, budgets); plan == nil || !plan.fits || plan.node != "node-b" {
+		t.Errorf("fitting node: plan = %+v", plan)
+	}
+}
+
+func TestPlanEvictionsStrategy(t *testing.T) {
+	nodes := []*kubecap.NodeReport{{Name: "node-a", Schedulable: 4 << 30, Free: 0, Pods: []*kubecap.PodReport{
+		{Namespace: "shop", Name: "a", Requests: 1 << 30, Used: 2 << 30},
+		{Namespace: "shop", Name: "b", Requests: 1 << 30, Used: 4 << 30},
+	}}}
+
+	evictable := []*kubecap.EvictableContainer{
+		{Node: "node-a", Namespace: "shop", Pod: "a", Container: "c", Requests: 1 << 30, Used: 2 << 30, Restarts: 5},
+		{Node: "node-a", Namespace: "shop", Pod: "b", Container: "c", Requests: 1 << 30, Used: 4 << 30},
+	}
+
+	budgets := &disruptionBudgets{allowed: map[string]int32{}, covers: map[string][]string{}}
+
+	// 1Gi more free takes a single eviction: the restarting pod first with
+	// most-restarts, the largest over its requests by default.
+	for strategy, want := range map[string]string{"": "b", "most-restarts": "a"} {
+		md := &kubecap.Metadata{Additional: 1 << 30, EvictionStrategy: strategy}
+
+		plan, err := planEvictions(md, nodes, evictable, budgets)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if plan == nil || len(plan.victims) != 1 || plan.victims[0].Pod != want {
+			t.Errorf("%q: plan = %+v", strategy, plan)
+		}
+	}
+
+	// A pod the eviction policy protects is never planned.
+	budgets.protected = map[string]bool{"shop/b": true}
+
+	plan, err := planEvictions(&kubecap.Metadata{Additional: 1 << 30}, nodes, evictable, budgets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan == nil || len(plan.victims) != 1 || plan.victims[0].Pod != "a" {
+		t.Errorf("protected b: plan = %+v", plan)
+	}
+
+	if _, err := planEvictions(&kubecap.Metadata{EvictionStrategy: "random"}, nodes, evictable, budgets); err == nil {
+		t.Error("unknown strategy: no error")
+	}
+}
+
+func TestListDisruptionBudgetsGonePod(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/apis/policy/v1/poddisruptionbudgets":
+			rw.Write([]byte(`{"items": [{"metadata": {"namespace": "shop", "name": "web"}, "spec": {"selector": {"matchLabels": {"app": "web"}}}, "status": {"disruptionsAllowed": 1}}]}`))
+		case "/api/v1/namespaces/shop/pods":
+			rw.Write([]byte(`{"items": [{"metadata": {"namespace": "shop", "name": "web-0", "labels": {"app": "web"}}}]}`))
+		case "/api/v1/namespaces/shop/pods/web-0":
+			rw.Write([]byte(`{"metadata": {"namespace": "shop", "name": "web-0", "labels": {"app": "web"}}}`))
+		default:
+			// web-1 was deleted since the report.
+			rw.WriteHeader(http.StatusNotFound)
+			rw.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`))
+		}
+	}))
+	defer srv.Close()
+
+	kcs, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evictable := []*kubecap.EvictableContainer{
+		{Node: "node-a", Namespace: "shop", Pod: "web-1", Container: "c", Requests: 1 << 30, Used: 4 << 30},
+		{Node: "node-a", Namespace: "shop", Pod: "web-0", Container: "c", Requests: 1 << 30, Used: 2 << 30},
+	}
+
+	budgets, err := listDisruptionBudgets(context.Background(), kcs, evictable, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !budgets.gone["shop/web-1"] || budgets.gone["shop/web-0"] || len(budgets.covers["shop/web-0"]) != 1 {
+		t.Fatalf("budgets = %+v", budgets)
+	}
+
+	// The gone pod is passed over even though it is the largest over its
+	// requests.
+	nodes := []*kubecap.NodeReport{
+		{Name: "node-a", Additional: 2 << 30, Free: 1 << 30, Schedulable: 3 << 29, Pods: []*kubecap.PodReport{
+			{Namespace: "shop", Name: "web-0", Requests: 1 << 30, Used: 2 << 30},
+			{Namespace: "shop", Name: "web-1", Requests: 1 << 30, Used: 4 << 30},
+		}},
+	}
+
+	plan, err := planEvictions(&kubecap.Metadata{Additional: 2 << 30}, nodes, evictable, budgets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan == nil || len(plan.victims) != 1 || plan.victims[0].Pod != "web-0" {
+		t.Errorf("plan = %+v", plan)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+// execOutput is a sink run as an external program: once the report is
+// complete it is given the report as a JSON document (as -o json writes it)
+// on stdin. What the program writes goes to w; it failing fails the report.
+type execOutput struct {
+	command []string
+	w       io.Writer
+
+	// doc renders each report into buf.
+	buf *bytes.Buffer
+	doc *documentOutput
+}
+
+func newExecOutput(command []string, w io.Writer) *execOutput {
+	return &execOutput{command: command, w: w, buf: &bytes.Buffer{}}
+}
+
+func (o *execOutput) Metadata(m *kubecap.Metadata) error {
+	// Each report in watch mode starts afresh.
+	o.buf.Reset()
+	o.doc = newDocumentOutput(o.buf, false)
+
+	return o.doc.Metadata(m)
+}
+
+func (o *execOutput) Node(n *kubecap.NodeReport) error {
+	return o.doc.Node(n)
+}
+
+func (o *execOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return o.doc.Evictable(e)
+}
+
+func (o *execOutput) Flush() error {
+	err := o.doc.Flush()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(o.command[0], o.command[1:]...)
+	cmd.Stdin = o.buf
+	cmd.Stdout = o.w
+	cmd.Stderr = o.w
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("exec sink %s: %w", strings.Join(o.command, " "), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestExecOutput(t *testing.T) {
+	report := func(out *execOutput, node string) error {
+		err := out.Metadata(&kubecap.Metadata{})
+		if err != nil {
+			return err
+		}
+
+		err = out.Node(&kubecap.NodeReport{Name: node})
+		if err != nil {
+			return err
+		}
+
+		return out.Flush()
+	}
+
+	// The program counts the nodes it is given: each report is sent on
+	// its own.
+	buf := &bytes.Buffer{}
+	out := newExecOutput([]string{"sh", "-c", `grep -c '"name": "node-'`}, buf)
+
+	for _, node := range []string{"node-a", "node-b"} {
+		err := report(out, node)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := buf.String(); got != "1\n1\n" {
+		t.Errorf("output = %q, want one node per report", got)
+	}
+
+	err := report(newExecOutput([]string{"false"}, buf), "node-a")
+	if err == nil || !strings.Contains(err.Error(), "exec sink false") {
+		t.Errorf("err = %v, want the sink failing", err)
+	}
+}
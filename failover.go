@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// failoverMain implements the failover subcommand, which checks that the
+// cluster survives N node failures (N+1, N+2, ...) or the interruption of its
+// spot nodes.
+func failoverMain(args []string) {
+	fs := flag.NewFlagSet("failover", flag.ExitOnError)
+	failures := fs.Int("failures", 1, "number of nodes failing at once")
+	every := fs.Bool("any", false, "check every combination of failing nodes rather than only the most loaded ones")
+	spot := fs.Bool("spot", false, "check the on-demand nodes absorb the pods of all the spot nodes interrupted at once, by zone")
+	kubeconfig, kcontext := clusterFlags(fs)
+	fs.Parse(args)
+
+	c, err := newCluster(*kubeconfig, *kcontext)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	ds, err := listDrainState(context.TODO(), c.kcs)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if *spot {
+		sr, err := ds.spotInterruption()
+		if err != nil {
+			panic(err.Error())
+		}
+
+		sr.write(os.Stdout)
+
+		return
+	}
+
+	fr, err := ds.failover(*failures, *every)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	fr.write(os.Stdout)
+}
+
+// failoverCheck is the outcome of a set of nodes failing at once.
+type failoverCheck struct {
+	sim *drainSimulation
+
+	// replacements is how many nodes like the failed ones have to be added
+	// for their pods to fit, or -1 if even replacing all of them isn't
+	// enough.
+	replacements int
+}
+
+// unplaced returns the number and requests of the displaced pods that fit
+// nowhere.
+func (c *failoverCheck) unplaced() (pods int, requests int64) {
+	for _, dp := range c.sim.displaced {
+		if dp.node == "" {
+			pods++
+			requests += dp.requests
+		}
+	}
+
+	return pods, requests
+}
+
+// failoverReport is the outcome of the failure sets checked.
+type failoverReport struct {
+	failures int
+	every    bool
+	nodes    int
+
+	checked int
+
+	// failing are the failure sets whose pods don't fit on the remaining
+	// nodes, most unplaced requests first. Unless every combination is
+	// checked, the single check is kept even if it passes.
+	failing []*failoverCheck
+}
+
+// replacements is the most replacement nodes any failure set needs, or -1 if
+// any needs more than it failed.
+func (r *failoverReport) replacements() int {
+	most := 0
+	for _, c := range r.failing {
+		if c.replacements < 0 {
+			return -1
+		}
+
+		if c.replacements > most {
+			most = c.replacements
+		}
+	}
+
+	return most
+}
+
+// failoverSets returns the sets of n nodes checked: every combination with
+// every and otherwise only the n nodes displacing the most requests.
+func (ds *drainState) failoverSets(n int, every bool) [][]string {
+	names := []string{}
+	load := map[string]int64{}
+
+	for i := range ds.nodes {
+		name := ds.nodes[i].Name
+		names = append(names, name)
+
+		displaced, _ := ds.displaced(name)
+		for _, dp := range displaced {
+			load[name] += dp.requests
+		}
+	}
+
+	if n > len(names) {
+		n = len(names)
+	}
+
+	if !every {
+		sort.SliceStable(names, func(i, j int) bool {
+			if load[names[i]] != load[names[j]] {
+				return load[names[i]] > load[names[j]]
+			}
+
+			return names[i] < names[j]
+		})
+
+		return [][]string{names[:n]}
+	}
+
+	sort.Strings(names)
+
+	sets := [][]string{}
+
+	var combine func(start int, set []string)
+	combine = func(start int, set []string) {
+		if len(set) == n {
+			sets = append(sets, append([]string{}, set...))
+			return
+		}
+
+		for i := start; i < len(names); i++ {
+			combine(i+1, append(set, names[i]))
+		}
+	}
+
+	combine(0, nil)
+
+	return sets
+}
+
+// failoverReplacements returns how many empty nodes like the failed ones
+// (largest first) have to be added for their pods to fit, or -1 if replacing
+// all of them isn't enough.
+func (ds *drainState) failoverReplacements(failed []string) (int, error) {
+	candidates := []*corev1.Node{}
+
+	for _, name := range failed {
+		for i := range ds.nodes {
+			if ds.nodes[i].Name != name {
+				continue
+			}
+
+			node := ds.nodes[i].DeepCopy()
+			node.Name += "-replacement"
+			node.Spec.Unschedulable = false
+
+			candidates = append(candidates, node)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Status.Allocatable.Memory().Cmp(*candidates[j].Status.Allocatable.Memory()) > 0
+	})
+
+	for k := 1; k <= len(candidates); k++ {
+		sim, err := ds.drain(failed, candidates[:k])
+		if err != nil {
+			return 0, err
+		}
+
+		if pods, _ := (&failoverCheck{sim: sim}).unplaced(); pods == 0 {
+			return k, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// failover checks whether the pods of each set of n failing nodes fit on the
+// remaining nodes and, where they don't, how many replacement nodes would be
+// needed.
+func (ds *drainState) failover(n int, every bool) (*failoverReport, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("failures must be at least 1: %d", n)
+	}
+
+	r := &failoverReport{
+		failures: n,
+		every:    every,
+		nodes:    len(ds.nodes),
+	}
+
+	for _, failed := range ds.failoverSets(n, every) {
+		sim, err := ds.drain(failed, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		r.checked++
+
+		c := &failoverCheck{sim: sim}
+
+		if pods, _ := c.unplaced(); pods > 0 {
+			c.replacements, err = ds.failoverReplacements(failed)
+			if err != nil {
+				return nil, err
+			}
+		} else if every {
+			continue
+		}
+
+		r.failing = append(r.failing, c)
+	}
+
+	sort.SliceStable(r.failing, func(i, j int) bool {
+		_, ri := r.failing[i].unplaced()
+		_, rj := r.failing[j].unplaced()
+
+		return ri > rj
+	})
+
+	return r, nil
+}
+
+func (r *failoverReport) write(w io.Writer) {
+	mode := "most loaded"
+	if r.every {
+		mode = "any"
+	}
+
+	survives := true
+	for _, c := range r.failing {
+		if pods, _ := c.unplaced(); pods > 0 {
+			survives = false
+		}
+	}
+
+	fmt.Fprintf(w, "Failures: %d (%s nodes, N+%d)\n", r.failures, mode, r.failures)
+	fmt.Fprintf(w, "Nodes: %d\n", r.nodes)
+	fmt.Fprintf(w, "Checked: %d failure sets\n", r.checked)
+	fmt.Fprintf(w, "Survives: %t\n", survives)
+
+	switch replacements := r.replacements(); {
+	case replacements < 0:
+		fmt.Fprintf(w, "Nodes Needed: more than %d\n", r.nodes+r.failures)
+	default:
+		fmt.Fprintf(w, "Nodes Needed: %d (%d more like the failed ones)\n", r.nodes+replacements, replacements)
+	}
+
+	fmt.Fprintln(w)
+
+	if len(r.failing) == 0 {
+		return
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{
+		"Failed Nodes",
+		"Displaced Pods",
+		"Displaced Requests",
+		"Unplaced Pods",
+		"Unplaced Requests",
+		"Replacements Needed",
+	})
+
+	for _, c := range r.failing {
+		var displaced int64
+		for _, dp := range c.sim.displaced {
+			displaced += dp.requests
+		}
+
+		pods, requests := c.unplaced()
+
+		replacements := fmt.Sprintf("%d", c.replacements)
+		if c.replacements < 0 {
+			replacements = fmt.Sprintf("more than %d", len(c.sim.drained))
+		}
+
+		table.Append([]string{
+			strings.Join(c.sim.drained, ", "),
+			fmt.Sprintf("%d", len(c.sim.displaced)),
+			humanize.Comma(displaced),
+			fmt.Sprintf("%d", pods),
+			humanize.Comma(requests),
+			replacements,
+		})
+	}
+
+	if r.every {
+		fmt.Fprintln(w, "Failing Node Sets")
+	} else {
+		fmt.Fprintln(w, "Failure Set")
+	}
+	table.Render()
+}
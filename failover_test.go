@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// failoverState returns a drain state of 8Gi nodes running a ReplicaSet's
+// pods of the given sizes (in Gi) each.
+func failoverState(nodes map[string][]int64) *drainState {
+	ds := &drainState{nps: kubecap.NodePods{}, podLevel: kubecap.PodResources{}}
+
+	controller := true
+
+	for name, pods := range nodes {
+		ds.nodes = append(ds.nodes, corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")},
+			},
+		})
+
+		for i, gi := range pods {
+			ds.nps.Add(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Name:      name + "-" + string(rune('a'+i)),
+					OwnerReferences: []metav1.OwnerReference{
+						{Kind: "ReplicaSet", Name: "web", Controller: &controller},
+					},
+				},
+				Spec: corev1.PodSpec{
+					NodeName: name,
+					Containers: []corev1.Container{{
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceMemory: *resource.NewQuantity(gi<<30, resource.BinarySI),
+							},
+						},
+					}},
+				},
+				Status: corev1.PodStatus{Phase: corev1.PodRunning},
+			})
+		}
+	}
+
+	return ds
+}
+
+func TestFailover(t *testing.T) {
+	ds := failoverState(map[string][]int64{
+		"a": {4, 2},
+		"b": {2},
+		"c": {1},
+	})
+
+	sets := ds.failoverSets(1, false)
+	if len(sets) != 1 || len(sets[0]) != 1 || sets[0][0] != "a" {
+		t.Fatalf("most loaded = %v, want [[a]]", sets)
+	}
+
+	if sets := ds.failoverSets(2, true); len(sets) != 3 {
+		t.Errorf("every pair = %v", sets)
+	}
+
+	// a's 6Gi fits in b's and c's 13Gi free.
+	r, err := ds.failover(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.failing) != 1 || r.replacements() != 0 {
+		t.Fatalf("N+1 = %+v", r)
+	}
+
+	if pods, _ := r.failing[0].unplaced(); pods != 0 {
+		t.Errorf("N+1 unplaced %d pods", pods)
+	}
+
+	// Every pair fails, worst losing a and b: 8Gi of pods for c's 7Gi.
+	r, err = ds.failover(2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.checked != 3 || len(r.failing) != 3 || r.failing[0].sim.drained[0] != "a" || r.failing[0].sim.drained[1] != "b" {
+		t.Fatalf("N+2 failing = %+v", r.failing)
+	}
+
+	if pods, requests := r.failing[0].unplaced(); pods != 1 || requests != 2<<30 {
+		t.Errorf("N+2 unplaced = %d, %d", pods, requests)
+	}
+
+	if r.replacements() != 1 {
+		t.Errorf("N+2 replacements = %d, want 1", r.replacements())
+	}
+}
+
+func TestSpotInterruption(t *testing.T) {
+	ds := failoverState(map[string][]int64{
+		"spot-a":   {4, 2},
+		"spot-b":   {6},
+		"demand-a": {1},
+		"demand-b": {4},
+	})
+
+	for i := range ds.nodes {
+		node := &ds.nodes[i]
+
+		zone := node.Name[len(node.Name)-1:]
+		node.Labels = map[string]string{kubecap.ZoneLabel: zone}
+
+		if node.Name[:4] == "spot" {
+			node.Labels["karpenter.sh/capacity-type"] = "spot"
+		}
+	}
+
+	// The displaced pods are placed largest first on the first node with
+	// room: spot-b's 6Gi takes most of demand-a, so spot-a's 4Gi goes to
+	// demand-b and its 2Gi fits nowhere.
+	r, err := ds.spotInterruption()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.sim.ok() || len(r.sim.drained) != 2 || len(r.zones) != 2 {
+		t.Fatalf("spot = %+v, want two spot nodes failing in two zones", r)
+	}
+
+	a, b := r.zones[0], r.zones[1]
+
+	if a.spotNodes != 1 || a.onDemandNodes != 1 || a.schedulable != 7<<30 {
+		t.Errorf("zone a nodes = %d spot, %d on-demand with %d left", a.spotNodes, a.onDemandNodes, a.schedulable)
+	}
+
+	if a.displaced != 6<<30 || a.absorbedOther != 4<<30 || a.unplaced != 2<<30 || a.unplacedPods != 1 {
+		t.Errorf("zone a = %+v", a)
+	}
+
+	if b.displaced != 6<<30 || b.absorbedOther != 6<<30 || b.absorbed != 0 || b.unplaced != 0 {
+		t.Errorf("zone b = %+v", b)
+	}
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/olekukonko/tablewriter"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// fitMain implements the fit subcommand, which checks where the replicas of
+// a workload could be scheduled.
+func fitMain(args []string) {
+	fs := flag.NewFlagSet("fit", flag.ExitOnError)
+	file := fs.String("f", "", "manifest of the Pod or workload (Deployment, StatefulSet, ReplicaSet or Job) to fit, - for stdin")
+	replicas := fs.Int("replicas", 0, "number of replicas to fit (default: the workload's replicas or parallelism, 1 for a Pod)")
+	serverDryRun := fs.Bool("server-dry-run", false, "also create the workload, and one of its pods, in a server-side dry run so that admission webhooks and quotas rejecting it fail the verdict")
+	output := fs.String("o", "table", "output format: table or json (with reason codes)")
+	kubeconfig, kcontext := clusterFlags(fs)
+	fs.Parse(args)
+
+	if *file == "" {
+		panic("fit requires -f")
+	}
+
+	if *output != "table" && *output != "json" {
+		panic(fmt.Sprintf("unknown output format: %q", *output))
+	}
+
+	var r io.Reader = os.Stdin
+
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			panic(err.Error())
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	w, err := loadFitWorkload(r)
+	if err != nil {
+		panic(fmt.Sprintf("%s: %v", *file, err))
+	}
+
+	if *replicas > 0 {
+		w.replicas = int64(*replicas)
+	}
+
+	c, err := newCluster(*kubeconfig, *kcontext)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	report, err := fitCluster(context.TODO(), c.kcs, w)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if *serverDryRun {
+		report.dryRun, err = dryRunFit(context.TODO(), c.kcs, w)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if *output == "json" {
+		err = report.writeJSON(os.Stdout)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		return
+	}
+
+	report.write(os.Stdout)
+}
+
+// fitCluster lists the cluster's nodes, pods, storage and the workload
+// namespace's LimitRanges, and checks where the workload's replicas fit.
+func fitCluster(ctx context.Context, kcs kubernetes.Interface, w *fitWorkload) (*fitReport, error) {
+	ds, err := listDrainState(ctx, kcs)
+	if err != nil {
+		return nil, err
+	}
+
+	ds.volumes, err = listFitVolumes(ctx, kcs)
+	if err != nil {
+		return nil, err
+	}
+
+	ds.limitRanges, err = listFitLimitRanges(ctx, kcs, w.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return ds.fit(w), nil
+}
+
+// fitWorkload is the pod template of a workload and its number of replicas.
+type fitWorkload struct {
+	kind      string
+	namespace string
+	name      string
+	replicas  int64
+	labels    map[string]string
+	spec      corev1.PodSpec
+
+	// claimTemplates are a StatefulSet's volume claim templates, claimed
+	// for each replica.
+	claimTemplates []corev1.PersistentVolumeClaim
+
+	// manifest is the manifest as JSON.
+	manifest []byte
+}
+
+// loadFitWorkload reads a Pod or a workload with a pod template from the
+// manifest.
+func loadFitWorkload(r io.Reader) (*fitWorkload, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest struct {
+		Kind     string            `json:"kind"`
+		Metadata metav1.ObjectMeta `json:"metadata"`
+		Spec     json.RawMessage   `json:"spec"`
+	}
+
+	err = yaml.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestJSON, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &fitWorkload{
+		manifest:  manifestJSON,
+		kind:      manifest.Kind,
+		namespace: manifest.Metadata.Namespace,
+		name:      manifest.Metadata.Name,
+		replicas:  1,
+		labels:    manifest.Metadata.Labels,
+	}
+
+	switch manifest.Kind {
+	case "Pod":
+		err = json.Unmarshal(manifest.Spec, &w.spec)
+		if err != nil {
+			return nil, err
+		}
+	case "Deployment", "StatefulSet", "ReplicaSet", "Job":
+		var spec struct {
+			Replicas    *int32                 `json:"replicas"`
+			Parallelism *int32                 `json:"parallelism"`
+			Template    corev1.PodTemplateSpec `json:"template"`
+
+			VolumeClaimTemplates []corev1.PersistentVolumeClaim `json:"volumeClaimTemplates"`
+		}
+
+		err = json.Unmarshal(manifest.Spec, &spec)
+		if err != nil {
+			return nil, err
+		}
+
+		w.labels = spec.Template.Labels
+		w.spec = spec.Template.Spec
+		w.claimTemplates = spec.VolumeClaimTemplates
+
+		switch {
+		case spec.Replicas != nil:
+			w.replicas = int64(*spec.Replicas)
+		case spec.Parallelism != nil:
+			w.replicas = int64(*spec.Parallelism)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported kind: %q", manifest.Kind)
+	}
+
+	return w, nil
+}
+
+// requests returns what a replica requests of each resource: the sum of its
+// containers' requests or its largest init container's, if more. Containers
+// without a request of a resource they are limited in request their limit, as
+// the API server defaults them.
+func (w *fitWorkload) requests() map[corev1.ResourceName]int64 {
+	container := func(c *corev1.Container) corev1.ResourceList {
+		rl := corev1.ResourceList{}
+		for name, q := range c.Resources.Limits {
+			rl[name] = q
+		}
+
+		for name, q := range c.Resources.Requests {
+			rl[name] = q
+		}
+
+		return rl
+	}
+
+	requests := map[corev1.ResourceName]int64{}
+
+	for i := range w.spec.Containers {
+		for name, q := range container(&w.spec.Containers[i]) {
+			requests[name] += kubecap.ResourceValue(name, q)
+		}
+	}
+
+	for i := range w.spec.InitContainers {
+		for name, q := range container(&w.spec.InitContainers[i]) {
+			if v := kubecap.ResourceValue(name, q); v > requests[name] {
+				requests[name] = v
+			}
+		}
+	}
+
+	return requests
+}
+
+// fitNodeReport is how many replicas of the workload fit on a node and why
+// none do, if so.
+type fitNodeReport struct {
+	name     string
+	replicas int64
+	reasons  []string
+
+	// cached is how many of the workload's images the node has pulled.
+	cached int
+}
+
+// fitReport is where the replicas of a workload fit.
+type fitReport struct {
+	workload *fitWorkload
+	requests map[corev1.ResourceName]int64
+	images   []string
+	nodes    []*fitNodeReport
+
+	// violations are why the namespace's LimitRanges would reject the
+	// workload's pods at admission, wherever they fit.
+	violations []string
+
+	// dryRun is how the API server answered creating the workload in a
+	// server-side dry run, if it was tried.
+	dryRun *fitDryRun
+}
+
+// fitting is how many replicas fit across the cluster.
+func (r *fitReport) fitting() int64 {
+	var total int64
+	for _, n := range r.nodes {
+		total += n.replicas
+	}
+
+	return total
+}
+
+// ok is whether all the replicas fit and would be admitted.
+func (r *fitReport) ok() bool {
+	return r.fitting() >= r.workload.replicas && len(r.violations) == 0 && (r.dryRun == nil || len(r.dryRun.rejections) == 0)
+}
+
+// nodeAffinityMatches reports whether the node satisfies the affinity's
+// required node affinity: any of its terms, each with all its expressions.
+func nodeAffinityMatches(affinity *corev1.Affinity, node *corev1.Node) bool {
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+
+	operators := map[corev1.NodeSelectorOperator]selection.Operator{
+		corev1.NodeSelectorOpIn:           selection.In,
+		corev1.NodeSelectorOpNotIn:        selection.NotIn,
+		corev1.NodeSelectorOpExists:       selection.Exists,
+		corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+		corev1.NodeSelectorOpGt:           selection.GreaterThan,
+		corev1.NodeSelectorOpLt:           selection.LessThan,
+	}
+
+	matches := func(reqs []corev1.NodeSelectorRequirement, set labels.Set) bool {
+		for _, req := range reqs {
+			r, err := labels.NewRequirement(req.Key, operators[req.Operator], req.Values)
+			if err != nil || !r.Matches(set) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+
+		if matches(term.MatchExpressions, labels.Set(node.Labels)) && matches(term.MatchFields, labels.Set{"metadata.name": node.Name}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fitNode returns how many replicas of the workload fit on the node given
+// its pods, and why none do.
+func (ds *drainState) fitNode(w *fitWorkload, requests map[corev1.ResourceName]int64, node *corev1.Node) *fitNodeReport {
+	nr := &fitNodeReport{name: node.Name}
+
+	if node.Spec.Unschedulable {
+		nr.reasons = append(nr.reasons, "unschedulable")
+	}
+
+	if !kubecap.NodeReady(node) {
+		nr.reasons = append(nr.reasons, "NotReady")
+	}
+
+	if !labels.SelectorFromSet(w.spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		nr.reasons = append(nr.reasons, "node selector")
+	}
+
+	if !nodeAffinityMatches(w.spec.Affinity, node) {
+		nr.reasons = append(nr.reasons, "node affinity")
+	}
+
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+
+		tolerated := false
+		for j := range w.spec.Tolerations {
+			if w.spec.Tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+
+		if !tolerated {
+			nr.reasons = append(nr.reasons, "taint "+taint.ToString())
+		}
+	}
+
+	nr.reasons = append(nr.reasons, ds.podAffinityReasons(w, node)...)
+
+	// Replicas are limited by the node's pod capacity and by each resource
+	// they request.
+	pods := ds.nps[node.Name]
+	used := map[corev1.ResourceName]int64{}
+	running := int64(0)
+
+	for _, pod := range pods {
+		if kubecap.PodState(pod) == kubecap.PodStateFinished {
+			continue
+		}
+
+		running++
+
+		for name := range requests {
+			used[name] += ds.podLevel.Requests(pod, name)
+		}
+	}
+
+	// Without a pod capacity or any requests as many replicas as wanted
+	// fit.
+	replicas := w.replicas
+	if q, ok := node.Status.Allocatable[corev1.ResourcePods]; ok {
+		replicas = q.Value() - running
+		if replicas <= 0 {
+			nr.reasons = append(nr.reasons, "too many pods")
+		}
+	}
+
+	names := make([]string, 0, len(requests))
+	for name := range requests {
+		names = append(names, string(name))
+	}
+
+	sort.Strings(names)
+
+	for _, n := range names {
+		name := corev1.ResourceName(n)
+
+		req := requests[name]
+		if req <= 0 {
+			continue
+		}
+
+		free := kubecap.ResourceValue(name, node.Status.Allocatable[name]) - used[name]
+		if fit := free / req; fit < replicas {
+			replicas = fit
+		}
+
+		if free < req {
+			nr.reasons = append(nr.reasons, "insufficient "+n)
+		}
+	}
+
+	// The replicas' volumes must be usable on the node and within its
+	// attach limits.
+	if ds.volumes != nil {
+		reasons, fit := ds.volumes.fitNode(w, pods, node)
+		nr.reasons = append(nr.reasons, reasons...)
+
+		if fit >= 0 && fit < replicas {
+			replicas = fit
+		}
+	}
+
+	if len(nr.reasons) == 0 && replicas > 0 {
+		nr.replicas = replicas
+	}
+
+	return nr
+}
+
+// images returns the normalized images of the workload's containers.
+func (w *fitWorkload) images() []string {
+	images := []string{}
+	seen := map[string]bool{}
+
+	for _, containers := range [][]corev1.Container{w.spec.InitContainers, w.spec.Containers} {
+		for i := range containers {
+			image := normalizeImage(containers[i].Image)
+			if image == "" || seen[image] {
+				continue
+			}
+
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+
+	return images
+}
+
+// normalizeImage returns the image reference as nodes list the images they
+// have pulled: with the registry (docker.io, with library/ for official
+// images) and a tag (latest) unless pinned by digest.
+func normalizeImage(image string) string {
+	if image == "" {
+		return ""
+	}
+
+	name := image
+	if i := strings.IndexByte(name, '/'); i < 0 {
+		name = "docker.io/library/" + name
+	} else if domain := name[:i]; !strings.ContainsAny(domain, ".:") && domain != "localhost" {
+		name = "docker.io/" + name
+	}
+
+	if strings.Contains(name, "@") {
+		return name
+	}
+
+	if i := strings.LastIndexByte(name, ':'); i < 0 || strings.Contains(name[i:], "/") {
+		name += ":latest"
+	}
+
+	return name
+}
+
+// cachedImages returns how many of the images the node has pulled.
+func cachedImages(node *corev1.Node, images []string) int {
+	pulled := map[string]bool{}
+	for _, ci := range node.Status.Images {
+		for _, name := range ci.Names {
+			pulled[normalizeImage(name)] = true
+		}
+	}
+
+	cached := 0
+	for _, image := range images {
+		if pulled[image] {
+			cached++
+		}
+	}
+
+	return cached
+}
+
+// fit checks each node for how many replicas of the workload it fits. Nodes
+// fitting as many replicas are listed with those with more of the workload's
+// images cached, where replicas start fastest, first.
+func (ds *drainState) fit(w *fitWorkload) *fitReport {
+	r := &fitReport{
+		workload: w,
+		requests: w.requests(),
+		images:   w.images(),
+
+		violations: w.limitRangeViolations(ds.limitRanges),
+	}
+
+	nodes := map[string]*corev1.Node{}
+
+	for i := range ds.nodes {
+		nodes[ds.nodes[i].Name] = &ds.nodes[i]
+
+		nr := ds.fitNode(w, r.requests, &ds.nodes[i])
+		nr.cached = cachedImages(&ds.nodes[i], r.images)
+
+		r.nodes = append(r.nodes, nr)
+	}
+
+	byReplicas := func(i, j int) bool {
+		if r.nodes[i].replicas != r.nodes[j].replicas {
+			return r.nodes[i].replicas > r.nodes[j].replicas
+		}
+
+		return r.nodes[i].cached > r.nodes[j].cached
+	}
+
+	sort.SliceStable(r.nodes, byReplicas)
+	r.spreadSelfAntiAffinity(nodes)
+	sort.SliceStable(r.nodes, byReplicas)
+
+	return r
+}
+
+func (r *fitReport) write(w io.Writer) {
+	requests := []string{}
+	for name, v := range r.requests {
+		q := resource.NewQuantity(v, resource.BinarySI)
+		if name == corev1.ResourceCPU {
+			q = resource.NewMilliQuantity(v, resource.DecimalSI)
+		}
+
+		requests = append(requests, fmt.Sprintf("%s %s", name, q))
+	}
+
+	sort.Strings(requests)
+
+	name := r.workload.name
+	if r.workload.namespace != "" {
+		name = r.workload.namespace + "/" + name
+	}
+
+	fitting := r.fitting()
+
+	fmt.Fprintf(w, "Workload: %s %s (%d replicas requesting %s each)\n", r.workload.kind, name, r.workload.replicas, strings.Join(requests, ", "))
+	fmt.Fprintf(w, "Fitting: %d replicas cluster-wide\n", fitting)
+	fmt.Fprintln(w)
+
+	nodeTable := tablewriter.NewWriter(w)
+	nodeTable.SetHeader([]string{"Node", "Replicas", "Cached Images", "Reasons"})
+	for _, n := range r.nodes {
+		nodeTable.Append([]string{n.name, fmt.Sprintf("%d", n.replicas), fmt.Sprintf("%d/%d", n.cached, len(r.images)), strings.Join(n.reasons, ", ")})
+	}
+
+	fmt.Fprintln(w, "Nodes")
+	nodeTable.Render()
+
+	if len(r.violations) > 0 {
+		fmt.Fprintln(w, "LimitRange Violations (rejected at admission)")
+
+		for _, v := range r.violations {
+			fmt.Fprintf(w, "  %s\n", v)
+		}
+	}
+
+	if d := r.dryRun; d != nil {
+		if len(d.rejections) == 0 {
+			fmt.Fprintf(w, "Server-Side Dry Run: accepted in namespace %s\n", d.namespace)
+		} else {
+			fmt.Fprintf(w, "Server-Side Dry Run Rejections (namespace %s)\n", d.namespace)
+
+			for _, rejection := range d.rejections {
+				fmt.Fprintf(w, "  %s\n", rejection)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "Ok? %t\n", r.ok())
+}
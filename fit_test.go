@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestFit(t *testing.T) {
+	// a has 8Gi free, b 6Gi and c 7Gi.
+	ds := failoverState(map[string][]int64{
+		"a": {},
+		"b": {2},
+		"c": {1},
+	})
+
+	for i := range ds.nodes {
+		node := &ds.nodes[i]
+		node.Labels = map[string]string{"zone": "z1"}
+
+		switch node.Name {
+		case "b":
+			node.Labels["zone"] = "z2"
+		case "c":
+			node.Spec.Taints = []corev1.Taint{{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule}}
+		}
+	}
+
+	w, err := loadFitWorkload(strings.NewReader(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+spec:
+  replicas: 5
+  template:
+    spec:
+      affinity:
+        nodeAffinity:
+          requiredDuringSchedulingIgnoredDuringExecution:
+            nodeSelectorTerms:
+            - matchExpressions:
+              - key: zone
+                operator: In
+                values: [z1, z2]
+      containers:
+      - name: web
+        resources:
+          limits:
+            memory: 3Gi
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.kind != "Deployment" || w.replicas != 5 {
+		t.Fatalf("kind, replicas = %s, %d", w.kind, w.replicas)
+	}
+
+	r := ds.fit(w)
+
+	got := map[string]int64{}
+	for _, n := range r.nodes {
+		got[n.name] = n.replicas
+
+		if n.name == "c" && !reflect.DeepEqual(n.reasons, []string{"taint dedicated=db:NoSchedule"}) {
+			t.Errorf("c: reasons = %v", n.reasons)
+		}
+	}
+
+	if !reflect.DeepEqual(got, map[string]int64{"a": 2, "b": 2, "c": 0}) || r.fitting() != 4 {
+		t.Errorf("replicas = %v, fitting %d", got, r.fitting())
+	}
+
+	w.spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0].Values = []string{"z2"}
+
+	for i := range ds.nodes {
+		if ds.nodes[i].Name != "a" {
+			continue
+		}
+
+		if n := ds.fitNode(w, w.requests(), &ds.nodes[i]); n.replicas != 0 || !reflect.DeepEqual(n.reasons, []string{"node affinity"}) {
+			t.Errorf("a: replicas, reasons = %d, %v", n.replicas, n.reasons)
+		}
+	}
+}
+
+func TestFitPodAffinity(t *testing.T) {
+	// a and b are in z1, c and d in z2 and e in z3. b runs a database and
+	// c a pod refusing web pods in its zone.
+	ds := failoverState(map[string][]int64{
+		"a": {},
+		"b": {1},
+		"c": {1},
+		"d": {},
+		"e": {},
+	})
+
+	zones := map[string]string{"a": "z1", "b": "z1", "c": "z2", "d": "z2", "e": "z3"}
+	for i := range ds.nodes {
+		node := &ds.nodes[i]
+		node.Labels = map[string]string{
+			"kubernetes.io/hostname":      node.Name,
+			"topology.kubernetes.io/zone": zones[node.Name],
+		}
+	}
+
+	ds.nps["b"][0].Labels = map[string]string{"app": "db"}
+	ds.nps["c"][0].Spec.Affinity = &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				TopologyKey:   "topology.kubernetes.io/zone",
+			}},
+		},
+	}
+
+	w, err := loadFitWorkload(strings.NewReader(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  replicas: 5
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      affinity:
+        podAntiAffinity:
+          requiredDuringSchedulingIgnoredDuringExecution:
+          - labelSelector:
+              matchLabels:
+                app: web
+            topologyKey: kubernetes.io/hostname
+          - labelSelector:
+              matchLabels:
+                app: db
+            topologyKey: kubernetes.io/hostname
+      containers:
+      - name: web
+        resources:
+          requests:
+            memory: 1Gi
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fit := func() map[string]*fitNodeReport {
+		got := map[string]*fitNodeReport{}
+		for _, n := range ds.fit(w).nodes {
+			got[n.name] = n
+		}
+
+		return got
+	}
+
+	// The replicas spread one per node, avoiding the database's node and
+	// the zone refusing them.
+	got := fit()
+
+	for name, want := range map[string]int64{"a": 1, "b": 0, "c": 0, "d": 0, "e": 1} {
+		if got[name].replicas != want {
+			t.Errorf("%s: replicas = %d, want %d", name, got[name].replicas, want)
+		}
+	}
+
+	if !reflect.DeepEqual(got["b"].reasons, []string{"pod anti-affinity with default/b-a"}) {
+		t.Errorf("b: reasons = %v", got["b"].reasons)
+	}
+
+	if !reflect.DeepEqual(got["d"].reasons, []string{"anti-affinity of default/c-a"}) {
+		t.Errorf("d: reasons = %v", got["d"].reasons)
+	}
+
+	// Requiring the database's zone instead leaves a and b.
+	w.spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = w.spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution[:1]
+	w.spec.Affinity.PodAffinity = &corev1.PodAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			TopologyKey:   "topology.kubernetes.io/zone",
+		}},
+	}
+
+	got = fit()
+
+	for name, want := range map[string]int64{"a": 1, "b": 1, "c": 0, "d": 0, "e": 0} {
+		if got[name].replicas != want {
+			t.Errorf("affinity: %s: replicas = %d, want %d", name, got[name].replicas, want)
+		}
+	}
+
+	if !reflect.DeepEqual(got["e"].reasons, []string{"pod affinity topology.kubernetes.io/zone"}) {
+		t.Errorf("affinity: e: reasons = %v", got["e"].reasons)
+	}
+}
+
+func TestFitVolumes(t *testing.T) {
+	// a and b are in z1 and c in z2. b already attaches a volume for its
+	// pod.
+	ds := failoverState(map[string][]int64{
+		"a": {},
+		"b": {1},
+		"c": {},
+	})
+
+	zones := map[string]string{"a": "z1", "b": "z1", "c": "z2"}
+	for i := range ds.nodes {
+		ds.nodes[i].Labels = map[string]string{"topology.kubernetes.io/zone": zones[ds.nodes[i].Name]}
+	}
+
+	ds.nps["b"][0].Spec.Volumes = []corev1.Volume{{
+		Name:         "data",
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "busy"}},
+	}}
+
+	driver := "ebs.csi.aws.com"
+	csiNode := func(count int32) *storagev1.CSINode {
+		return &storagev1.CSINode{Spec: storagev1.CSINodeSpec{Drivers: []storagev1.CSINodeDriver{{
+			Name:        driver,
+			Allocatable: &storagev1.VolumeNodeResources{Count: &count},
+		}}}}
+	}
+
+	ebs := func(name string, zones string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"topology.kubernetes.io/zone": zones}},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{Driver: driver}},
+			},
+		}
+	}
+
+	claim := func(volume string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{Spec: corev1.PersistentVolumeClaimSpec{VolumeName: volume}}
+	}
+
+	ds.volumes = &fitVolumes{
+		claims: map[string]*corev1.PersistentVolumeClaim{
+			"default/config": claim("pv-config"),
+			"default/busy":   claim("pv-busy"),
+		},
+		volumes: map[string]*corev1.PersistentVolume{
+			"pv-config": ebs("pv-config", "z1__z3"),
+			"pv-busy":   ebs("pv-busy", "z1"),
+		},
+		classes: map[string]*storagev1.StorageClass{
+			"gp3": {
+				Provisioner: driver,
+				AllowedTopologies: []corev1.TopologySelectorTerm{{
+					MatchLabelExpressions: []corev1.TopologySelectorLabelRequirement{{Key: "topology.kubernetes.io/zone", Values: []string{"z1", "z2"}}},
+				}},
+			},
+		},
+		csiNodes: map[string]*storagev1.CSINode{
+			"a": csiNode(3),
+			"b": csiNode(2),
+		},
+	}
+
+	w, err := loadFitWorkload(strings.NewReader(`
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: default
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: db
+        resources:
+          requests:
+            memory: 1Gi
+      volumes:
+      - name: config
+        persistentVolumeClaim:
+          claimName: config
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      storageClassName: gp3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fit := func() map[string]*fitNodeReport {
+		got := map[string]*fitNodeReport{}
+		for _, n := range ds.fit(w).nodes {
+			got[n.name] = n
+		}
+
+		return got
+	}
+
+	// a attaches the shared config volume and a data volume per replica
+	// within its limit of 3, b has no room left for them and c is outside
+	// the config volume's zones.
+	got := fit()
+
+	for name, want := range map[string]int64{"a": 2, "b": 0, "c": 0} {
+		if got[name].replicas != want {
+			t.Errorf("%s: replicas = %d, want %d", name, got[name].replicas, want)
+		}
+	}
+
+	if !reflect.DeepEqual(got["b"].reasons, []string{"volume attach limit " + driver}) {
+		t.Errorf("b: reasons = %v", got["b"].reasons)
+	}
+
+	if !reflect.DeepEqual(got["c"].reasons, []string{"volume config zone"}) {
+		t.Errorf("c: reasons = %v", got["c"].reasons)
+	}
+
+	// New data volumes only provisioned in z2 leave no node.
+	ds.volumes.classes["gp3"].AllowedTopologies[0].MatchLabelExpressions[0].Values = []string{"z2"}
+
+	got = fit()
+
+	if got["a"].replicas != 0 || !reflect.DeepEqual(got["a"].reasons, []string{"volume data topology"}) {
+		t.Errorf("a: replicas = %d, reasons = %v", got["a"].replicas, got["a"].reasons)
+	}
+}
+
+func TestNormalizeImage(t *testing.T) {
+	for image, want := range map[string]string{
+		"nginx":                          "docker.io/library/nginx:latest",
+		"nginx:1.25":                     "docker.io/library/nginx:1.25",
+		"bitnami/redis:7":                "docker.io/bitnami/redis:7",
+		"registry.k8s.io/pause:3.9":      "registry.k8s.io/pause:3.9",
+		"localhost:5000/app":             "localhost:5000/app:latest",
+		"ghcr.io/org/app@sha256:abcdef0": "ghcr.io/org/app@sha256:abcdef0",
+	} {
+		if got := normalizeImage(image); got != want {
+			t.Errorf("normalizeImage(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestFitCachedImages(t *testing.T) {
+	ds := failoverState(map[string][]int64{
+		"a": {},
+		"b": {},
+		"c": {2},
+	})
+
+	images := map[string][]string{
+		"a": {"docker.io/library/nginx:1.25"},
+		"b": {"docker.io/library/nginx@sha256:0123", "docker.io/library/nginx:1.25", "docker.io/bitnami/redis:7"},
+		"c": {"docker.io/library/nginx:1.25", "docker.io/bitnami/redis:7"},
+	}
+
+	for i := range ds.nodes {
+		ds.nodes[i].Status.Images = []corev1.ContainerImage{{Names: images[ds.nodes[i].Name]}}
+	}
+
+	w, err := loadFitWorkload(strings.NewReader(`
+kind: Pod
+metadata:
+  name: web
+spec:
+  initContainers:
+  - name: cache
+    image: bitnami/redis:7
+  containers:
+  - name: web
+    image: nginx:1.25
+    resources:
+      requests:
+        memory: 4Gi
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b and a fit as many replicas, b with both images cached first; c with
+	// both has room for fewer.
+	w.replicas = 2
+	r := ds.fit(w)
+
+	got := []string{}
+	for _, n := range r.nodes {
+		got = append(got, fmt.Sprintf("%s %d %d/%d", n.name, n.replicas, n.cached, len(r.images)))
+	}
+
+	if want := []string{"b 2 2/2", "a 2 1/2", "c 1 2/2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nodes = %v, want %v", got, want)
+	}
+}
+
+func TestFitLimitRanges(t *testing.T) {
+	w, err := loadFitWorkload(strings.NewReader(`
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: db
+        resources:
+          requests:
+            memory: 64Mi
+          limits:
+            memory: 4Gi
+      - name: sidecar
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      resources:
+        requests:
+          storage: 200Gi
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := []corev1.LimitRange{{
+		ObjectMeta: metav1.ObjectMeta{Name: "limits"},
+		Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+			Type:                 corev1.LimitTypeContainer,
+			Min:                  corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+			Max:                  corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+			Default:              corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+			DefaultRequest:       corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+			MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4")},
+		}, {
+			Type: corev1.LimitTypePersistentVolumeClaim,
+			Max:  corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("100Gi")},
+		}}},
+	}}
+
+	// The sidecar takes the defaults and passes.
+	want := []string{
+		"container db memory request 64Mi is below LimitRange limits min 128Mi",
+		"container db memory limit 4Gi is above LimitRange limits max 2Gi",
+		"container db memory limit/request ratio 64.00 is above LimitRange limits max 4",
+		"claim data storage request 200Gi is above LimitRange limits max 100Gi",
+	}
+
+	if got := w.limitRangeViolations(ranges); !reflect.DeepEqual(got, want) {
+		t.Errorf("violations = %q, want %q", got, want)
+	}
+
+	if got := w.limitRangeViolations(nil); len(got) != 0 {
+		t.Errorf("without LimitRanges: violations = %q", got)
+	}
+}
+
+func TestDryRunFit(t *testing.T) {
+	w, err := loadFitWorkload(strings.NewReader(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: team
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: web:1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The API server accepts the Deployment but its quota rejects the pod.
+	paths := []string{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Query().Get("dryRun") != metav1.DryRunAll {
+			t.Errorf("%s %s: not a dry run", r.Method, r.URL)
+		}
+
+		paths = append(paths, r.URL.Path)
+
+		rw.Header().Set("Content-Type", "application/json")
+
+		if strings.HasSuffix(r.URL.Path, "/pods") {
+			rw.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(rw, `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"exceeded quota: mem","reason":"Forbidden","code":403}`)
+
+			return
+		}
+
+		fmt.Fprint(rw, `{}`)
+	}))
+	defer srv.Close()
+
+	kcs, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := dryRunFit(context.Background(), kcs, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"/apis/apps/v1/namespaces/team/deployments", "/api/v1/namespaces/team/pods"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %q, want %q", paths, want)
+	}
+
+	if want := []string{"its Pod: exceeded quota: mem"}; !reflect.DeepEqual(d.rejections, want) {
+		t.Errorf("rejections = %q, want %q", d.rejections, want)
+	}
+
+	r := &fitReport{workload: w, nodes: []*fitNodeReport{{name: "node-a", replicas: 2}}, dryRun: d}
+	if r.ok() {
+		t.Error("ok with the pod rejected")
+	}
+}
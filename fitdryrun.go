@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// fitDryRunResources are the API resources of the kinds fit takes.
+var fitDryRunResources = map[string]string{
+	"Pod":         "pods",
+	"Deployment":  "deployments",
+	"StatefulSet": "statefulsets",
+	"ReplicaSet":  "replicasets",
+	"Job":         "jobs",
+}
+
+// fitDryRun is how the API server answered creating the workload in a
+// server-side dry run, which runs the admission plugins and webhooks (e.g.
+// ResourceQuota, PodSecurity or a policy engine) without persisting
+// anything.
+type fitDryRun struct {
+	namespace string
+
+	// rejections are why the API server rejected the objects created, none
+	// when it accepted them all.
+	rejections []string
+}
+
+// dryRunFit creates the workload, and for a workload with a pod template one
+// of its pods, in a server-side dry run. A pod is tried too since the pods'
+// admission (webhooks, quotas on pods) only runs once the controller creates
+// them. Rejections are recorded; failing to reach the API server is an
+// error.
+func dryRunFit(ctx context.Context, kcs kubernetes.Interface, w *fitWorkload) (*fitDryRun, error) {
+	namespace := w.namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	d := &fitDryRun{namespace: namespace}
+
+	var manifest struct {
+		APIVersion string `json:"apiVersion"`
+	}
+
+	err := json.Unmarshal(w.manifest, &manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// object is an object created, described by name in its rejection.
+	type object struct {
+		apiVersion, kind, name string
+		body                   []byte
+	}
+
+	objects := []object{{manifest.APIVersion, w.kind, w.kind + " " + w.name, w.manifest}}
+
+	if w.kind != "Pod" {
+		pod := &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: w.name + "-",
+				Namespace:    namespace,
+				Labels:       w.labels,
+			},
+			Spec: w.spec,
+		}
+
+		body, err := json.Marshal(pod)
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, object{"v1", "Pod", "its Pod", body})
+	}
+
+	for _, o := range objects {
+		api := "/apis"
+		if !strings.Contains(o.apiVersion, "/") {
+			api = "/api"
+		}
+
+		err := kcs.CoreV1().RESTClient().Post().
+			AbsPath(api, o.apiVersion, "namespaces", namespace, fitDryRunResources[o.kind]).
+			Param("dryRun", metav1.DryRunAll).
+			SetHeader("Content-Type", "application/json").
+			Body(o.body).
+			Do(ctx).
+			Error()
+
+		var status apierrors.APIStatus
+
+		switch {
+		case err == nil:
+		case errors.As(err, &status):
+			d.rejections = append(d.rejections, fmt.Sprintf("%s: %s", o.name, status.Status().Message))
+		default:
+			return nil, fmt.Errorf("dry run %s: %w", o.name, err)
+		}
+	}
+
+	return d, nil
+}
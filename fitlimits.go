@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// listFitLimitRanges lists the LimitRanges of the namespace the workload is
+// admitted into.
+func listFitLimitRanges(ctx context.Context, kcs kubernetes.Interface, namespace string) ([]corev1.LimitRange, error) {
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	limitRangeList, err := kcs.CoreV1().LimitRanges(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return limitRangeList.Items, nil
+}
+
+// defaultedResources returns the container's requests and limits as they are
+// admitted: requests missing but limited default to the limit, then the
+// LimitRanges' defaults fill in the rest.
+func defaultedResources(c *corev1.Container, ranges []corev1.LimitRange) (requests, limits corev1.ResourceList) {
+	requests, limits = corev1.ResourceList{}, corev1.ResourceList{}
+
+	for name, q := range c.Resources.Limits {
+		limits[name] = q
+		requests[name] = q
+	}
+
+	for name, q := range c.Resources.Requests {
+		requests[name] = q
+	}
+
+	for _, lr := range ranges {
+		for _, item := range lr.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+
+			for name, q := range item.Default {
+				if _, ok := limits[name]; !ok {
+					limits[name] = q
+				}
+			}
+
+			for name, q := range item.DefaultRequest {
+				if _, ok := requests[name]; !ok {
+					requests[name] = q
+				}
+			}
+		}
+	}
+
+	return requests, limits
+}
+
+// sortedResources returns the names of the resources, sorted.
+func sortedResources(rl corev1.ResourceList) []corev1.ResourceName {
+	names := make([]corev1.ResourceName, 0, len(rl))
+	for name := range rl {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	return names
+}
+
+// limitRangeItemViolations checks what's requests and limits against the
+// LimitRange's item. Claims are only limited in their requests, so maxima
+// apply to them when requestsOnly.
+func limitRangeItemViolations(lr, what string, item *corev1.LimitRangeItem, requests, limits corev1.ResourceList, requestsOnly bool) []string {
+	violations := []string{}
+
+	for _, name := range sortedResources(item.Min) {
+		min := item.Min[name]
+
+		req, ok := requests[name]
+		switch {
+		case !ok:
+			violations = append(violations, fmt.Sprintf("%s has no %s request, LimitRange %s min %s", what, name, lr, min.String()))
+		case req.Cmp(min) < 0:
+			violations = append(violations, fmt.Sprintf("%s %s request %s is below LimitRange %s min %s", what, name, req.String(), lr, min.String()))
+		}
+
+		if lim, ok := limits[name]; ok && lim.Cmp(min) < 0 {
+			violations = append(violations, fmt.Sprintf("%s %s limit %s is below LimitRange %s min %s", what, name, lim.String(), lr, min.String()))
+		}
+	}
+
+	for _, name := range sortedResources(item.Max) {
+		max := item.Max[name]
+
+		if requestsOnly {
+			if req, ok := requests[name]; ok && req.Cmp(max) > 0 {
+				violations = append(violations, fmt.Sprintf("%s %s request %s is above LimitRange %s max %s", what, name, req.String(), lr, max.String()))
+			}
+
+			continue
+		}
+
+		lim, ok := limits[name]
+		switch {
+		case !ok:
+			violations = append(violations, fmt.Sprintf("%s has no %s limit, LimitRange %s max %s", what, name, lr, max.String()))
+		case lim.Cmp(max) > 0:
+			violations = append(violations, fmt.Sprintf("%s %s limit %s is above LimitRange %s max %s", what, name, lim.String(), lr, max.String()))
+		}
+	}
+
+	for _, name := range sortedResources(item.MaxLimitRequestRatio) {
+		ratio := item.MaxLimitRequestRatio[name]
+
+		req, reqOk := requests[name]
+		lim, limOk := limits[name]
+		if !reqOk || !limOk || req.IsZero() {
+			violations = append(violations, fmt.Sprintf("%s needs a %s request and limit, LimitRange %s max limit/request ratio %s", what, name, lr, ratio.String()))
+
+			continue
+		}
+
+		if actual := float64(lim.MilliValue()) / float64(req.MilliValue()); actual > float64(ratio.MilliValue())/1000 {
+			violations = append(violations, fmt.Sprintf("%s %s limit/request ratio %.2f is above LimitRange %s max %s", what, name, actual, lr, ratio.String()))
+		}
+	}
+
+	return violations
+}
+
+// limitRangeViolations returns why admission would reject the workload's pods
+// (and a StatefulSet's claims) under the LimitRanges of its namespace, even
+// though they may fit on the nodes. Containers are checked with the ranges'
+// defaults applied, as the LimitRanger admission plugin does.
+func (w *fitWorkload) limitRangeViolations(ranges []corev1.LimitRange) []string {
+	violations := []string{}
+
+	type defaulted struct {
+		name             string
+		init             bool
+		requests, limits corev1.ResourceList
+	}
+
+	containers := []defaulted{}
+	for _, set := range []struct {
+		containers []corev1.Container
+		init       bool
+	}{{w.spec.InitContainers, true}, {w.spec.Containers, false}} {
+		for i := range set.containers {
+			requests, limits := defaultedResources(&set.containers[i], ranges)
+			containers = append(containers, defaulted{set.containers[i].Name, set.init, requests, limits})
+		}
+	}
+
+	for _, lr := range ranges {
+		for i := range lr.Spec.Limits {
+			item := &lr.Spec.Limits[i]
+
+			switch item.Type {
+			case corev1.LimitTypeContainer:
+				for _, c := range containers {
+					violations = append(violations, limitRangeItemViolations(lr.Name, "container "+c.name, item, c.requests, c.limits, false)...)
+				}
+			case corev1.LimitTypePod:
+				// The pod's amounts are its (app) containers' together.
+				requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+
+				for _, c := range containers {
+					if c.init {
+						continue
+					}
+
+					for _, sum := range []struct{ from, to corev1.ResourceList }{{c.requests, requests}, {c.limits, limits}} {
+						for name, q := range sum.from {
+							total := sum.to[name]
+							total.Add(q)
+							sum.to[name] = total
+						}
+					}
+				}
+
+				violations = append(violations, limitRangeItemViolations(lr.Name, "pod", item, requests, limits, false)...)
+			case corev1.LimitTypePersistentVolumeClaim:
+				for j := range w.claimTemplates {
+					pvc := &w.claimTemplates[j]
+					violations = append(violations, limitRangeItemViolations(lr.Name, "claim "+pvc.Name, item, pvc.Spec.Resources.Requests, nil, true)...)
+				}
+			}
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// fitOddsMain implements the fit-odds subcommand, which replays the
+// schedulable headroom in the history stored with --postgres-dsn to estimate
+// how likely a workload that doesn't fit now is to fit within a while. The
+// headroom freed by completing pods shows in the history as it rises; the
+// pods' churn and completions aren't modelled on their own.
+func fitOddsMain(args []string) {
+	fs := flag.NewFlagSet("fit-odds", flag.ExitOnError)
+	dsn := fs.String("postgres-dsn", os.Getenv("KUBECAP_POSTGRES_DSN"), "PostgreSQL/TimescaleDB database the history was stored in")
+	period := fs.Duration("period", 28*24*time.Hour, "period of history to estimate from, ending with the latest run")
+	cluster := fs.String("cluster", "", "only report on this cluster (kubeconfig context)")
+	resource := fs.String("resource", "memory", "resource the history was reported on: memory, cpu or ephemeral-storage")
+	sizeStr := fs.String("size", "", "amount of the resource each replica of the workload requests, e.g. 8GiB")
+	replicas := fs.Int64("replicas", 1, "number of replicas of the workload, each fitting on a node")
+	within := fs.Duration("within", 15*time.Minute, "how long the workload may wait for room")
+	output := fs.String("o", "table", "output format: table or jsonl")
+	fs.Parse(args)
+
+	if *dsn == "" {
+		panic("fit-odds requires --postgres-dsn")
+	}
+
+	if *sizeStr == "" {
+		panic("fit-odds requires --size")
+	}
+
+	size, err := kubecap.ParseAmount(corev1.ResourceName(*resource), *sizeStr)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if size <= 0 || *replicas < 1 || *within <= 0 {
+		panic("--size, --replicas and --within must be positive")
+	}
+
+	db, err := sql.Open("postgres", *dsn)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer db.Close()
+
+	samples, err := fitOddsSamples(context.TODO(), db, *period, *cluster, *resource)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	odds := fitOdds(samples, size, *replicas, *within)
+
+	switch *output {
+	case "table":
+		writeFitOdds(os.Stdout, odds)
+	case "jsonl":
+		enc := json.NewEncoder(os.Stdout)
+
+		for _, o := range odds {
+			err = enc.Encode(o)
+			if err != nil {
+				panic(err.Error())
+			}
+		}
+	default:
+		panic(fmt.Sprintf("unknown output format: %q", *output))
+	}
+}
+
+// fitOddsSample is a node's schedulable amount in a run (or its least in an
+// hour, once rolled up).
+type fitOddsSample struct {
+	Cluster     string
+	Time        time.Time
+	Node        string
+	Schedulable int64
+}
+
+// fitOddsSamples reads every node's schedulable amount of the resource of the
+// runs (or hours, once rolled up) within the period before each cluster's
+// latest run.
+func fitOddsSamples(ctx context.Context, db *sql.DB, period time.Duration, cluster, resource string) ([]*fitOddsSample, error) {
+	rows, err := db.QueryContext(ctx, `
+		WITH history AS (
+			SELECT time, cluster, node, schedulable FROM kubecap_nodes WHERE resource = $3
+			UNION ALL
+			SELECT time, cluster, node, schedulable FROM kubecap_nodes_hourly WHERE resource = $3
+		), latest AS (
+			SELECT cluster, max(time) AS last
+			FROM history
+			WHERE $2 = '' OR cluster = $2
+			GROUP BY cluster
+		)
+		SELECT h.cluster, h.time, h.node, h.schedulable
+		FROM history h JOIN latest l ON h.cluster = l.cluster
+		WHERE h.time > l.last - make_interval(secs => $1)
+		ORDER BY h.cluster, h.time`,
+		period.Seconds(), cluster, resource,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []*fitOddsSample{}
+
+	for rows.Next() {
+		s := &fitOddsSample{}
+
+		err = rows.Scan(&s.Cluster, &s.Time, &s.Node, &s.Schedulable)
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, s)
+	}
+
+	return samples, rows.Err()
+}
+
+// FitOdds is how likely a workload is to fit in a cluster within a while,
+// estimated by replaying its history of headroom: of the runs the workload
+// wouldn't have fit in, the share after which it did fit within the while.
+type FitOdds struct {
+	Cluster  string        `json:"cluster"`
+	Size     int64         `json:"size"`
+	Replicas int64         `json:"replicas"`
+	Within   time.Duration `json:"within"`
+
+	// Runs are the runs in the period and FitShare the share of them the
+	// workload fit in outright. FitsNow is whether it fits in the latest.
+	Runs     int     `json:"runs"`
+	FitShare float64 `json:"fitShare"`
+	FitsNow  bool    `json:"fitsNow"`
+
+	// Waits are the runs it didn't fit in with the while after them in the
+	// history, and Probability the share of them after which it fit within
+	// the while, unknown (nil) without any. MedianWait is how long those it
+	// fit after waited.
+	Waits       int           `json:"waits"`
+	Probability *float64      `json:"probability,omitempty"`
+	MedianWait  time.Duration `json:"medianWait"`
+}
+
+// fitOdds estimates the odds of the workload fitting within the while in
+// each cluster of the samples, in cluster order. Each replica fits on a node
+// with room for it.
+func fitOdds(samples []*fitOddsSample, size, replicas int64, within time.Duration) []*FitOdds {
+	type run struct {
+		time time.Time
+		fits bool
+	}
+
+	// Replicas fitting in each run, by cluster.
+	slots := map[string]map[time.Time]int64{}
+
+	for _, s := range samples {
+		if slots[s.Cluster] == nil {
+			slots[s.Cluster] = map[time.Time]int64{}
+		}
+
+		// A node short of room (or overcommitted) fits no replicas, but
+		// still marks the run.
+		fitting := s.Schedulable / size
+		if fitting < 0 {
+			fitting = 0
+		}
+
+		slots[s.Cluster][s.Time] += fitting
+	}
+
+	clusters := []string{}
+	for c := range slots {
+		clusters = append(clusters, c)
+	}
+
+	sort.Strings(clusters)
+
+	odds := []*FitOdds{}
+
+	for _, c := range clusters {
+		runs := []run{}
+		for t, n := range slots[c] {
+			runs = append(runs, run{t, n >= replicas})
+		}
+
+		sort.Slice(runs, func(i, j int) bool {
+			return runs[i].time.Before(runs[j].time)
+		})
+
+		o := &FitOdds{
+			Cluster:  c,
+			Size:     size,
+			Replicas: replicas,
+			Within:   within,
+			Runs:     len(runs),
+			FitsNow:  runs[len(runs)-1].fits,
+		}
+
+		fit := 0
+		waited := []time.Duration{}
+		last := runs[len(runs)-1].time
+
+		for i, r := range runs {
+			if r.fits {
+				fit++
+
+				continue
+			}
+
+			// Only runs with the whole while after them tell how
+			// long the workload would have waited.
+			if r.time.Add(within).After(last) {
+				continue
+			}
+
+			o.Waits++
+
+			for _, next := range runs[i+1:] {
+				if next.time.Sub(r.time) > within {
+					break
+				}
+
+				if next.fits {
+					waited = append(waited, next.time.Sub(r.time))
+
+					break
+				}
+			}
+		}
+
+		o.FitShare = float64(fit) / float64(len(runs))
+
+		if o.Waits > 0 {
+			p := float64(len(waited)) / float64(o.Waits)
+			o.Probability = &p
+		}
+
+		if len(waited) > 0 {
+			sort.Slice(waited, func(i, j int) bool { return waited[i] < waited[j] })
+			o.MedianWait = waited[len(waited)/2]
+		}
+
+		odds = append(odds, o)
+	}
+
+	return odds
+}
+
+func writeFitOdds(w io.Writer, odds []*FitOdds) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{
+		"Cluster",
+		"Size",
+		"Replicas",
+		"Runs",
+		"Fit Share",
+		"Fits Now?",
+		"Waits",
+		"Fit Probability",
+		"Median Wait",
+	})
+
+	for _, o := range odds {
+		probability := "n/a"
+
+		switch {
+		case o.FitsNow:
+			probability = "100.0% (fits now)"
+		case o.Probability != nil:
+			probability = fmt.Sprintf("%.1f%% within %s", *o.Probability*100, o.Within)
+		}
+
+		table.Append([]string{
+			o.Cluster,
+			humanize.Comma(o.Size),
+			fmt.Sprintf("%d", o.Replicas),
+			fmt.Sprintf("%d", o.Runs),
+			fmt.Sprintf("%.1f%%", o.FitShare*100),
+			fmt.Sprintf("%t", o.FitsNow),
+			fmt.Sprintf("%d", o.Waits),
+			probability,
+			o.MedianWait.String(),
+		})
+	}
+
+	fmt.Fprintln(w, "Fit Odds")
+	table.Render()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFitOdds(t *testing.T) {
+	samples := []*fitOddsSample{}
+
+	// An hour of runs 5 minutes apart of two nodes, one overcommitted,
+	// with room for two 10 byte replicas at 10 and 40 minutes.
+	start := time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 12; i++ {
+		at := start.Add(time.Duration(i) * 5 * time.Minute)
+
+		schedulable := int64(15)
+		if i == 2 || i == 8 {
+			schedulable = 25
+		}
+
+		samples = append(samples,
+			&fitOddsSample{Cluster: "c", Time: at, Node: "a", Schedulable: schedulable},
+			&fitOddsSample{Cluster: "c", Time: at, Node: "b", Schedulable: -15},
+		)
+	}
+
+	// A cluster it fits in now.
+	samples = append(samples, &fitOddsSample{Cluster: "d", Time: start, Node: "a", Schedulable: 20})
+
+	odds := fitOdds(samples, 10, 2, 10*time.Minute)
+	if len(odds) != 2 {
+		t.Fatalf("%d clusters", len(odds))
+	}
+
+	// Of the runs it didn't fit in with 10 minutes after them (0 to 45
+	// minutes), those at 0, 5, 25 and 30 minutes are followed by one it
+	// fits in.
+	c := odds[0]
+	if c.Cluster != "c" || c.Runs != 12 || c.FitsNow {
+		t.Errorf("c = %+v", c)
+	}
+
+	if c.Waits != 8 || c.Probability == nil || *c.Probability != 0.5 || c.MedianWait != 10*time.Minute {
+		t.Errorf("c = %+v", c)
+	}
+
+	if got, want := c.FitShare, 2.0/12; got != want {
+		t.Errorf("fit share = %v, want %v", got, want)
+	}
+
+	d := odds[1]
+	// Without a run with the while after it, the odds are unknown.
+	if d.Cluster != "d" || !d.FitsNow || d.Waits != 0 || d.Probability != nil {
+		t.Errorf("d = %+v", d)
+	}
+
+	out := &bytes.Buffer{}
+	writeFitOdds(out, []*FitOdds{{Cluster: "e", Size: 10, Replicas: 1, Within: time.Minute, Runs: 1}})
+
+	if !strings.Contains(out.String(), "n/a") {
+		t.Errorf("unknown odds not n/a:\n%s", out)
+	}
+}
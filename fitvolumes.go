@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// fitVolumes is the cluster's storage the workload's volumes are checked
+// against: the claims (by namespace/name), persistent volumes, storage
+// classes and each node's CSI drivers.
+type fitVolumes struct {
+	claims   map[string]*corev1.PersistentVolumeClaim
+	volumes  map[string]*corev1.PersistentVolume
+	classes  map[string]*storagev1.StorageClass
+	csiNodes map[string]*storagev1.CSINode
+}
+
+// listFitVolumes lists the cluster's storage.
+func listFitVolumes(ctx context.Context, kcs kubernetes.Interface) (*fitVolumes, error) {
+	fv := &fitVolumes{
+		claims:   map[string]*corev1.PersistentVolumeClaim{},
+		volumes:  map[string]*corev1.PersistentVolume{},
+		classes:  map[string]*storagev1.StorageClass{},
+		csiNodes: map[string]*storagev1.CSINode{},
+	}
+
+	claimList, err := kcs.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range claimList.Items {
+		pvc := &claimList.Items[i]
+		fv.claims[pvc.Namespace+"/"+pvc.Name] = pvc
+	}
+
+	volumeList, err := kcs.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range volumeList.Items {
+		fv.volumes[volumeList.Items[i].Name] = &volumeList.Items[i]
+	}
+
+	classList, err := kcs.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range classList.Items {
+		fv.classes[classList.Items[i].Name] = &classList.Items[i]
+	}
+
+	csiNodeList, err := kcs.StorageV1().CSINodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range csiNodeList.Items {
+		fv.csiNodes[csiNodeList.Items[i].Name] = &csiNodeList.Items[i]
+	}
+
+	return fv, nil
+}
+
+// fitVolume is a persistent volume of the workload's replicas: an existing
+// claim shared by them, bound to a volume or not yet, or a new claim from a
+// StatefulSet's template for each replica.
+type fitVolume struct {
+	claim   string
+	shared  bool
+	missing bool
+	pv      *corev1.PersistentVolume
+	class   *storagev1.StorageClass
+
+	// driver is the CSI driver attaching the volume, if known.
+	driver string
+}
+
+// class returns the storage class named or, without a name, the default
+// class.
+func (fv *fitVolumes) class(name *string) *storagev1.StorageClass {
+	if name != nil {
+		return fv.classes[*name]
+	}
+
+	for _, sc := range fv.classes {
+		if sc.Annotations["storageclass.kubernetes.io/is-default-class"] == "true" {
+			return sc
+		}
+	}
+
+	return nil
+}
+
+// workloadVolumes returns the workload's persistent volumes.
+func (fv *fitVolumes) workloadVolumes(w *fitWorkload) []*fitVolume {
+	vols := []*fitVolume{}
+
+	for i := range w.spec.Volumes {
+		src := w.spec.Volumes[i].PersistentVolumeClaim
+		if src == nil {
+			continue
+		}
+
+		v := &fitVolume{claim: src.ClaimName, shared: true}
+
+		pvc, ok := fv.claims[w.namespace+"/"+src.ClaimName]
+		if !ok {
+			v.missing = true
+			vols = append(vols, v)
+
+			continue
+		}
+
+		v.class = fv.class(pvc.Spec.StorageClassName)
+
+		if pvc.Spec.VolumeName != "" {
+			v.pv = fv.volumes[pvc.Spec.VolumeName]
+		}
+
+		switch {
+		case v.pv != nil && v.pv.Spec.CSI != nil:
+			v.driver = v.pv.Spec.CSI.Driver
+		case v.pv == nil && v.class != nil:
+			v.driver = v.class.Provisioner
+		}
+
+		vols = append(vols, v)
+	}
+
+	for i := range w.claimTemplates {
+		tmpl := &w.claimTemplates[i]
+
+		v := &fitVolume{claim: tmpl.Name, class: fv.class(tmpl.Spec.StorageClassName)}
+		if v.class != nil {
+			v.driver = v.class.Provisioner
+		}
+
+		vols = append(vols, v)
+	}
+
+	return vols
+}
+
+// zoneLabels are the labels of persistent volumes limiting them to zones, as
+// the scheduler's VolumeZone plugin checks them: their values are zones
+// separated by __.
+var zoneLabels = []string{
+	"topology.kubernetes.io/zone",
+	"topology.kubernetes.io/region",
+	"failure-domain.beta.kubernetes.io/zone",
+	"failure-domain.beta.kubernetes.io/region",
+}
+
+// topologyReason returns why the volume can't be used on the node, if it
+// can't: a bound volume's node affinity or zone, or an unbound claim's
+// storage class's allowed topologies.
+func (v *fitVolume) topologyReason(node *corev1.Node) string {
+	if v.missing {
+		return "volume " + v.claim + " missing"
+	}
+
+	if v.pv != nil {
+		if na := v.pv.Spec.NodeAffinity; na != nil && na.Required != nil {
+			affinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: na.Required}}
+			if !nodeAffinityMatches(affinity, node) {
+				return "volume " + v.claim + " node affinity"
+			}
+		}
+
+		for _, key := range zoneLabels {
+			zones, ok := v.pv.Labels[key]
+			if !ok {
+				continue
+			}
+
+			found := false
+			for _, zone := range strings.Split(zones, "__") {
+				if zone == node.Labels[key] {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return "volume " + v.claim + " zone"
+			}
+		}
+
+		return ""
+	}
+
+	if v.class == nil || len(v.class.AllowedTopologies) == 0 {
+		return ""
+	}
+
+	for _, term := range v.class.AllowedTopologies {
+		matches := true
+
+		for _, expr := range term.MatchLabelExpressions {
+			value, ok := node.Labels[expr.Key]
+
+			found := false
+			for _, v := range expr.Values {
+				if ok && v == value {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return ""
+		}
+	}
+
+	return "volume " + v.claim + " topology"
+}
+
+// attached returns the persistent volumes attached to the node by each CSI
+// driver: those of the claims of its pods.
+func (fv *fitVolumes) attached(pods []*corev1.Pod) map[string]map[string]bool {
+	attached := map[string]map[string]bool{}
+
+	for _, pod := range pods {
+		if kubecap.PodState(pod) == kubecap.PodStateFinished {
+			continue
+		}
+
+		for i := range pod.Spec.Volumes {
+			src := pod.Spec.Volumes[i].PersistentVolumeClaim
+			if src == nil {
+				continue
+			}
+
+			pvc, ok := fv.claims[pod.Namespace+"/"+src.ClaimName]
+			if !ok || pvc.Spec.VolumeName == "" {
+				continue
+			}
+
+			pv, ok := fv.volumes[pvc.Spec.VolumeName]
+			if !ok || pv.Spec.CSI == nil {
+				continue
+			}
+
+			if attached[pv.Spec.CSI.Driver] == nil {
+				attached[pv.Spec.CSI.Driver] = map[string]bool{}
+			}
+
+			attached[pv.Spec.CSI.Driver][pv.Name] = true
+		}
+	}
+
+	return attached
+}
+
+// fitNode returns why none of the workload's replicas can use their volumes
+// on the node and how many its CSI drivers' attach limits leave room for
+// (-1 without a limit).
+func (fv *fitVolumes) fitNode(w *fitWorkload, pods []*corev1.Pod, node *corev1.Node) (reasons []string, replicas int64) {
+	vols := fv.workloadVolumes(w)
+
+	for _, v := range vols {
+		if reason := v.topologyReason(node); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+
+	csiNode, ok := fv.csiNodes[node.Name]
+	if !ok {
+		return reasons, -1
+	}
+
+	limits := map[string]int64{}
+	for _, d := range csiNode.Spec.Drivers {
+		if d.Allocatable != nil && d.Allocatable.Count != nil {
+			limits[d.Name] = int64(*d.Allocatable.Count)
+		}
+	}
+
+	// Shared volumes are attached once, unless they already are, and
+	// template volumes once per replica.
+	attached := fv.attached(pods)
+	shared := map[string]int64{}
+	perReplica := map[string]int64{}
+
+	for _, v := range vols {
+		if _, ok := limits[v.driver]; !ok {
+			continue
+		}
+
+		switch {
+		case !v.shared:
+			perReplica[v.driver]++
+		case v.pv == nil || !attached[v.driver][v.pv.Name]:
+			shared[v.driver]++
+		}
+	}
+
+	drivers := make([]string, 0, len(limits))
+	for d := range limits {
+		drivers = append(drivers, d)
+	}
+
+	sort.Strings(drivers)
+
+	replicas = -1
+
+	for _, d := range drivers {
+		if shared[d] == 0 && perReplica[d] == 0 {
+			continue
+		}
+
+		free := limits[d] - int64(len(attached[d])) - shared[d]
+		if free < 0 || free < perReplica[d] {
+			reasons = append(reasons, "volume attach limit "+d)
+			replicas = 0
+
+			continue
+		}
+
+		if perReplica[d] > 0 {
+			if fit := free / perReplica[d]; replicas < 0 || fit < replicas {
+				replicas = fit
+			}
+		}
+	}
+
+	return reasons, replicas
+}
@@ -4,9 +4,19 @@ go 1.16
 
 require (
 	github.com/dustin/go-humanize v1.0.0
+	github.com/golang/snappy v0.0.3
+	github.com/lib/pq v1.10.0
 	github.com/olekukonko/tablewriter v0.0.5
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	golang.org/x/image v0.0.0-20210220032944-ac19c3e999fb
+	golang.org/x/net v0.0.0-20210224082022-3d97a244fca7
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
+	google.golang.org/protobuf v1.25.0
 	k8s.io/api v0.21.0
 	k8s.io/apimachinery v0.21.0
 	k8s.io/client-go v0.21.0
 	k8s.io/metrics v0.21.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+)
+
+// growthMain implements the growth subcommand, which ranks namespaces by the
+// growth of their requests over a period from the history stored with
+// --postgres-dsn.
+func growthMain(args []string) {
+	fs := flag.NewFlagSet("growth", flag.ExitOnError)
+	dsn := fs.String("postgres-dsn", os.Getenv("KUBECAP_POSTGRES_DSN"), "PostgreSQL/TimescaleDB database the history was stored in")
+	period := fs.Duration("period", 30*24*time.Hour, "period to compare the namespaces' requests over, ending with the latest run")
+	cluster := fs.String("cluster", "", "only report on this cluster (kubeconfig context)")
+	resource := fs.String("resource", "memory", "resource the history was reported on: memory, cpu or ephemeral-storage")
+	top := fs.Int("top", 0, "only report this many of the fastest growing namespaces (default all)")
+	output := fs.String("o", "table", "output format: table or jsonl")
+	fs.Parse(args)
+
+	if *dsn == "" {
+		panic("growth requires --postgres-dsn")
+	}
+
+	db, err := sql.Open("postgres", *dsn)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer db.Close()
+
+	growth, err := namespaceGrowth(context.TODO(), db, *period, *cluster, *resource)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	growth = rankGrowth(growth, *top)
+
+	switch *output {
+	case "table":
+		writeGrowth(os.Stdout, growth)
+	case "jsonl":
+		enc := json.NewEncoder(os.Stdout)
+
+		for _, g := range growth {
+			err = enc.Encode(g)
+			if err != nil {
+				panic(err.Error())
+			}
+		}
+	default:
+		panic(fmt.Sprintf("unknown output format: %q", *output))
+	}
+}
+
+// NamespaceGrowth is the change of a namespace's requests between the
+// first and the last run of a period. Namespaces without pods at either end
+// count as requesting nothing.
+type NamespaceGrowth struct {
+	Cluster   string    `json:"cluster"`
+	Namespace string    `json:"namespace"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Start     int64     `json:"start"`
+	End       int64     `json:"end"`
+	Growth    int64     `json:"growth"`
+
+	// Percent is Growth relative to Start. It is left out for namespaces
+	// new in the period.
+	Percent *float64 `json:"percent,omitempty"`
+}
+
+// namespaceGrowth reads each namespace's requests of the resource at the
+// first and the last run (or hour, once rolled up) within the period before
+// each cluster's latest run.
+func namespaceGrowth(ctx context.Context, db *sql.DB, period time.Duration, cluster, resource string) ([]*NamespaceGrowth, error) {
+	rows, err := db.QueryContext(ctx, `
+		WITH latest AS (
+			SELECT cluster, max(time) AS last
+			FROM kubecap_namespaces_history
+			WHERE ($2 = '' OR cluster = $2) AND resource = $3
+			GROUP BY cluster
+		), runs AS (
+			SELECT n.cluster, min(n.time) AS first, l.last
+			FROM kubecap_namespaces_history n JOIN latest l ON n.cluster = l.cluster
+			WHERE n.time >= l.last - make_interval(secs => $1) AND n.resource = $3
+			GROUP BY n.cluster, l.last
+		)
+		SELECT n.cluster, n.namespace, r.first, r.last,
+			coalesce(sum(n.requests) FILTER (WHERE n.time = r.first), 0),
+			coalesce(sum(n.requests) FILTER (WHERE n.time = r.last), 0)
+		FROM kubecap_namespaces_history n JOIN runs r ON n.cluster = r.cluster AND n.time IN (r.first, r.last)
+		WHERE n.resource = $3
+		GROUP BY n.cluster, n.namespace, r.first, r.last`,
+		period.Seconds(), cluster, resource,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	growth := []*NamespaceGrowth{}
+
+	for rows.Next() {
+		g := &NamespaceGrowth{}
+
+		err = rows.Scan(&g.Cluster, &g.Namespace, &g.From, &g.To, &g.Start, &g.End)
+		if err != nil {
+			return nil, err
+		}
+
+		growth = append(growth, g)
+	}
+
+	return growth, rows.Err()
+}
+
+// rankGrowth computes the growth of each namespace and returns the top (all
+// when 0) fastest growing, by absolute growth.
+func rankGrowth(growth []*NamespaceGrowth, top int) []*NamespaceGrowth {
+	for _, g := range growth {
+		g.Growth = g.End - g.Start
+		g.Percent = nil
+
+		if g.Start > 0 {
+			percent := float64(g.Growth) / float64(g.Start) * 100
+			g.Percent = &percent
+		}
+	}
+
+	sort.SliceStable(growth, func(i, j int) bool {
+		if growth[i].Growth != growth[j].Growth {
+			return growth[i].Growth > growth[j].Growth
+		}
+
+		if growth[i].Cluster != growth[j].Cluster {
+			return growth[i].Cluster < growth[j].Cluster
+		}
+
+		return growth[i].Namespace < growth[j].Namespace
+	})
+
+	if top > 0 && len(growth) > top {
+		growth = growth[:top]
+	}
+
+	return growth
+}
+
+func writeGrowth(w io.Writer, growth []*NamespaceGrowth) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{
+		"Cluster",
+		"Namespace",
+		"From",
+		"To",
+		"Start",
+		"End",
+		"Growth",
+		"Growth %",
+	})
+
+	for _, g := range growth {
+		percent := "new"
+		if g.Percent != nil {
+			percent = humanize.FormatFloat("#.#", *g.Percent) + "%"
+		}
+
+		table.Append([]string{
+			g.Cluster,
+			g.Namespace,
+			g.From.Format(time.RFC3339),
+			g.To.Format(time.RFC3339),
+			humanize.Comma(g.Start),
+			humanize.Comma(g.End),
+			humanize.Comma(g.Growth),
+			percent,
+		})
+	}
+
+	fmt.Fprintln(w, "Namespace Growth Report")
+	table.Render()
+}
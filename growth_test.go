@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRankGrowth(t *testing.T) {
+	growth := rankGrowth([]*NamespaceGrowth{
+		{Cluster: "c", Namespace: "shrinking", Start: 4 << 30, End: 2 << 30},
+		{Cluster: "c", Namespace: "new", Start: 0, End: 1 << 30},
+		{Cluster: "c", Namespace: "doubling", Start: 2 << 30, End: 4 << 30},
+	}, 2)
+
+	if len(growth) != 2 || growth[0].Namespace != "doubling" || growth[1].Namespace != "new" {
+		t.Fatalf("ranked = %+v, %+v", growth[0], growth[1])
+	}
+
+	if p := growth[0].Percent; p == nil || *p != 100 {
+		t.Errorf("doubling percent = %v", p)
+	}
+
+	if growth[1].Percent != nil || growth[1].Growth != 1<<30 {
+		t.Errorf("new = %+v", growth[1])
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"google.golang.org/protobuf/encoding/protowire"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The gRPC API (api/kubecap/v1/capacity.proto) is served by hand, as the
+// OTLP and remote write requests are encoded, rather than with generated
+// code: it is only a few small messages.
+const grpcService = "/kubecap.v1.Capacity/"
+
+// grpcMaxMessage is the largest request message accepted, gRPC's default.
+const grpcMaxMessage = 4 << 20
+
+// gRPC status codes.
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcUnimplemented   = 12
+	grpcInternal        = 13
+	grpcUnavailable     = 14
+)
+
+// grpcError is an error with its gRPC status code.
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string {
+	return e.msg
+}
+
+// isGRPC reports whether the request is a gRPC call.
+func isGRPC(req *http.Request) bool {
+	return req.ProtoMajor == 2 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// readGRPCMessage reads the request's length-prefixed message.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+
+	_, err := io.ReadFull(r, prefix[:])
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("read message: %v", err)}
+	}
+
+	if prefix[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > grpcMaxMessage {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("message of %d bytes is larger than %d", n, grpcMaxMessage)}
+	}
+
+	msg := make([]byte, n)
+
+	_, err = io.ReadFull(r, msg)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("read message: %v", err)}
+	}
+
+	return msg, nil
+}
+
+// writeGRPCMessage writes a length-prefixed message and flushes it.
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+
+	_, err := w.Write(append(prefix[:], msg...))
+	if err != nil {
+		return err
+	}
+
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// grpcEscape percent-encodes a status message as gRPC requires.
+func grpcEscape(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// handleGRPC serves a gRPC call, ending it with its status in the trailers.
+func (s *reportServer) handleGRPC(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	// Streams start with the headers, before the first report.
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	err := s.callGRPC(w, req)
+
+	code, msg := grpcOK, ""
+
+	var gerr *grpcError
+
+	switch {
+	case errors.As(err, &gerr):
+		code, msg = gerr.code, gerr.msg
+	case err != nil:
+		code, msg = grpcInternal, err.Error()
+	}
+
+	w.Header().Set("Grpc-Status", fmt.Sprintf("%d", code))
+
+	if msg != "" {
+		w.Header().Set("Grpc-Message", grpcEscape(msg))
+	}
+}
+
+// callGRPC dispatches the call to its method.
+func (s *reportServer) callGRPC(w http.ResponseWriter, req *http.Request) error {
+	msg, err := readGRPCMessage(req.Body)
+	if err != nil {
+		return err
+	}
+
+	switch req.URL.Path {
+	case grpcService + "GetReport":
+		r := s.report()
+		if r == nil {
+			return &grpcError{grpcUnavailable, "no report collected yet"}
+		}
+
+		b, err := encodeReport(r)
+		if err != nil {
+			return err
+		}
+
+		return writeGRPCMessage(w, b)
+	case grpcService + "WatchReports":
+		return s.watchReports(req.Context(), w)
+	case grpcService + "Fit":
+		if s.fit == nil {
+			return &grpcError{grpcUnimplemented, "fit is not served"}
+		}
+
+		fr, err := decodeFitRequest(msg)
+		if err != nil {
+			return &grpcError{grpcInvalidArgument, err.Error()}
+		}
+
+		wl, err := loadFitWorkload(bytes.NewReader(fr.manifest))
+		if err != nil {
+			return &grpcError{grpcInvalidArgument, fmt.Sprintf("manifest: %v", err)}
+		}
+
+		if fr.replicas > 0 {
+			wl.replicas = fr.replicas
+		}
+
+		r, err := s.fit(req.Context(), wl)
+		if err != nil {
+			return &grpcError{grpcUnavailable, err.Error()}
+		}
+
+		return writeGRPCMessage(w, encodeFitResponse(r))
+	case grpcService + "WatchEvents":
+		after, err := decodeWatchEventsRequest(msg)
+		if err != nil {
+			return &grpcError{grpcInvalidArgument, err.Error()}
+		}
+
+		return s.watchEvents(req.Context(), w, after)
+	}
+
+	return &grpcError{grpcUnimplemented, fmt.Sprintf("unknown method %s", req.URL.Path)}
+}
+
+// watchReports streams the latest report, if any, and each new one until the
+// call ends.
+func (s *reportServer) watchReports(ctx context.Context, w http.ResponseWriter) error {
+	var sent *kubecap.ClusterReport
+
+	for {
+		r, updated := s.next()
+
+		if r != nil && r != sent {
+			b, err := encodeReport(r)
+			if err != nil {
+				return err
+			}
+
+			err = writeGRPCMessage(w, b)
+			if err != nil {
+				return err
+			}
+
+			sent = r
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// watchEvents streams the events after the ID, or only those to come when it
+// is zero, until the call ends.
+func (s *reportServer) watchEvents(ctx context.Context, w http.ResponseWriter, after int64) error {
+	if after == 0 {
+		s.mu.Lock()
+		after = s.lastEventID
+		s.mu.Unlock()
+	}
+
+	for {
+		events, updated := s.eventsAfter(after)
+
+		for _, e := range events {
+			err := writeGRPCMessage(w, encodeEvent(e))
+			if err != nil {
+				return err
+			}
+
+			after = e.ID
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// appendInt64 appends an int64 field, leaving it out when zero.
+func appendInt64(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// appendBool appends a bool field, leaving it out when false.
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+// appendDouble appends a double field, leaving it out when zero.
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	return appendFixed64(b, num, math.Float64bits(v))
+}
+
+// appendBytes appends a bytes field, leaving it out when empty.
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+
+	return appendMessage(b, num, v)
+}
+
+// encodeReport encodes the report as a kubecap.v1.Report.
+func encodeReport(r *kubecap.ClusterReport) ([]byte, error) {
+	var b []byte
+
+	if md := r.Metadata; md != nil {
+		var m []byte
+		m = appendInt64(m, 1, md.Timestamp.UnixNano())
+		m = appendString(m, 2, md.Context)
+		m = appendString(m, 3, md.Cluster)
+		m = appendString(m, 4, md.ServerVersion)
+		m = appendString(m, 5, string(md.ResourceName()))
+		m = appendInt64(m, 6, md.Additional)
+
+		b = appendMessage(b, 1, m)
+	}
+
+	for _, n := range r.Nodes {
+		b = appendMessage(b, 2, encodeNode(n))
+	}
+
+	j, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendBytes(b, 3, j), nil
+}
+
+// encodeNode encodes the node as a kubecap.v1.Node.
+func encodeNode(n *kubecap.NodeReport) []byte {
+	var b []byte
+	b = appendString(b, 1, n.Cluster)
+	b = appendString(b, 2, n.Name)
+	b = appendString(b, 3, n.Group)
+	b = appendString(b, 4, n.Zone)
+	b = appendString(b, 5, n.InstanceType)
+	b = appendInt64(b, 6, n.Allocatable)
+	b = appendInt64(b, 7, n.Used)
+	b = appendInt64(b, 8, n.Free)
+	b = appendInt64(b, 9, n.Requests)
+	b = appendInt64(b, 10, n.Limits)
+	b = appendInt64(b, 11, n.Schedulable)
+	b = appendDouble(b, 12, n.Efficiency)
+	b = appendBool(b, 13, n.Ok)
+	b = appendDouble(b, 14, n.Pressure)
+	b = appendInt64(b, 15, int64(n.PodCount))
+	b = appendInt64(b, 16, n.PodCapacity)
+
+	for _, c := range n.Conditions {
+		b = appendString(b, 17, c)
+	}
+
+	return b
+}
+
+// encodeEvent encodes the event as a kubecap.v1.Event.
+func encodeEvent(e *capacityEvent) []byte {
+	var b []byte
+	b = appendInt64(b, 1, e.ID)
+	b = appendString(b, 2, e.Type)
+	b = appendInt64(b, 3, e.At.UnixNano())
+	b = appendString(b, 4, e.Node)
+	b = appendBool(b, 5, e.Ok != nil && *e.Ok)
+	b = appendInt64(b, 6, e.Threshold)
+	b = appendString(b, 7, e.Direction)
+
+	if e.Headroom != nil {
+		b = appendInt64(b, 8, *e.Headroom)
+	}
+
+	if e.Previous != nil {
+		b = appendInt64(b, 9, *e.Previous)
+	}
+
+	b = appendString(b, 10, e.Namespace)
+	b = appendString(b, 11, e.Pod)
+	b = appendString(b, 12, e.State)
+
+	return appendInt64(b, 13, e.Requests)
+}
+
+// decodeWatchEventsRequest decodes a kubecap.v1.WatchEventsRequest into the
+// ID to resume after, skipping unknown fields.
+func decodeWatchEventsRequest(b []byte) (int64, error) {
+	var after int64
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		if num == 1 && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return 0, protowire.ParseError(n)
+			}
+
+			after = int64(v)
+			b = b[n:]
+
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+
+		b = b[n:]
+	}
+
+	return after, nil
+}
+
+// fitRequest is a decoded kubecap.v1.FitRequest.
+type fitRequest struct {
+	manifest []byte
+	replicas int64
+}
+
+// decodeFitRequest decodes a kubecap.v1.FitRequest, skipping unknown fields.
+func decodeFitRequest(b []byte) (*fitRequest, error) {
+	r := &fitRequest{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+
+			r.manifest = append([]byte{}, v...)
+			b = b[n:]
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+
+			r.replicas = int64(v)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+
+			b = b[n:]
+		}
+	}
+
+	if len(r.manifest) == 0 {
+		return nil, fmt.Errorf("no manifest")
+	}
+
+	return r, nil
+}
+
+// encodeFitResponse encodes the fit report as a kubecap.v1.FitResponse.
+func encodeFitResponse(r *fitReport) []byte {
+	var b []byte
+	b = appendString(b, 1, r.workload.kind)
+	b = appendString(b, 2, r.workload.namespace)
+	b = appendString(b, 3, r.workload.name)
+	b = appendInt64(b, 4, r.workload.replicas)
+
+	names := []string{}
+	for name := range r.requests {
+		names = append(names, string(name))
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		var entry []byte
+		entry = appendString(entry, 1, name)
+		entry = appendInt64(entry, 2, r.requests[corev1.ResourceName(name)])
+
+		b = appendMessage(b, 5, entry)
+	}
+
+	b = appendInt64(b, 6, r.fitting())
+
+	for _, n := range r.nodes {
+		var node []byte
+		node = appendString(node, 1, n.name)
+		node = appendInt64(node, 2, n.replicas)
+
+		for _, reason := range n.reasons {
+			node = appendString(node, 3, reason)
+		}
+
+		node = appendInt64(node, 4, int64(n.cached))
+		node = appendInt64(node, 5, int64(len(r.images)))
+
+		b = appendMessage(b, 7, node)
+	}
+
+	for _, v := range r.violations {
+		b = appendString(b, 8, v)
+	}
+
+	return appendBool(b, 9, r.ok())
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestReportServerGRPC(t *testing.T) {
+	s := &reportServer{}
+
+	srv := httptest.NewUnstartedServer(s.handler())
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	call := func(ctx context.Context, method string, msg []byte) (*http.Response, error) {
+		t.Helper()
+
+		body := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+grpcService+method, bytes.NewReader(append(body, msg...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Content-Type", "application/grpc")
+
+		return srv.Client().Do(req)
+	}
+
+	// unary calls the method and returns its status and response message.
+	unary := func(method string, msg []byte) (string, []byte) {
+		t.Helper()
+
+		resp, err := call(context.Background(), method, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body) >= 5 {
+			body = body[5:]
+		}
+
+		return resp.Trailer.Get("Grpc-Status"), body
+	}
+
+	if status, _ := unary("GetReport", nil); status != "14" {
+		t.Errorf("before the first report: status = %s, want 14 (unavailable)", status)
+	}
+
+	if status, _ := unary("Fit", nil); status != "12" {
+		t.Errorf("fit not served: status = %s, want 12 (unimplemented)", status)
+	}
+
+	// A stream started before the first report gets it once collected.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := call(ctx, "WatchReports", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+
+	out := s.output()
+	out.Metadata(&kubecap.Metadata{Context: "prod"})
+	out.Node(&kubecap.NodeReport{Name: "node-a", Allocatable: 100, Ok: true})
+	out.Flush()
+
+	status, body := unary("GetReport", nil)
+	if status != "0" {
+		t.Fatalf("status = %s, want 0", status)
+	}
+
+	// The second field is the node, whose second field is its name.
+	var name string
+
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+
+		body = body[n:]
+
+		if num == 2 {
+			node, _ := protowire.ConsumeBytes(body)
+
+			_, _, m := protowire.ConsumeTag(node)
+			name, _ = protowire.ConsumeString(node[m:])
+		}
+
+		body = body[protowire.ConsumeFieldValue(num, typ, body):]
+	}
+
+	if name != "node-a" {
+		t.Errorf("node name = %q, want node-a", name)
+	}
+
+	var prefix [5]byte
+
+	_, err = io.ReadFull(stream.Body, prefix[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := binary.BigEndian.Uint32(prefix[1:]); n == 0 {
+		t.Errorf("streamed report is empty")
+	}
+}
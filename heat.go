@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+)
+
+// heatMain implements the heat subcommand, which reports each node group's
+// average and peak requests and usage by hour of day and day of week from
+// the history stored with --postgres-dsn, and the quiet windows maintenance
+// or batch work can run in.
+func heatMain(args []string) {
+	fs := flag.NewFlagSet("heat", flag.ExitOnError)
+	dsn := fs.String("postgres-dsn", os.Getenv("KUBECAP_POSTGRES_DSN"), "PostgreSQL/TimescaleDB database the history was stored in")
+	period := fs.Duration("period", 28*24*time.Hour, "period of history to report on, ending with the latest run")
+	cluster := fs.String("cluster", "", "only report on this cluster (kubeconfig context)")
+	resource := fs.String("resource", "memory", "resource the history was reported on: memory, cpu or ephemeral-storage")
+	location := fs.String("location", "Local", "time zone the hours and days are in, e.g. UTC or Europe/Berlin")
+	maxQuiet := fs.Float64("quiet", 50, "percentage of a node group's allocatable amount its peak requests and usage stay below in a quiet hour")
+	output := fs.String("o", "table", "output format: table or jsonl")
+	fs.Parse(args)
+
+	if *dsn == "" {
+		panic("heat requires --postgres-dsn")
+	}
+
+	loc, err := time.LoadLocation(*location)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	db, err := sql.Open("postgres", *dsn)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer db.Close()
+
+	samples, err := heatSamples(context.TODO(), db, *period, *cluster, *resource)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	buckets := heatBuckets(samples, loc, *maxQuiet/100)
+
+	switch *output {
+	case "table":
+		writeHeat(os.Stdout, buckets)
+	case "jsonl":
+		enc := json.NewEncoder(os.Stdout)
+
+		for _, b := range buckets {
+			err = enc.Encode(b)
+			if err != nil {
+				panic(err.Error())
+			}
+		}
+	default:
+		panic(fmt.Sprintf("unknown output format: %q", *output))
+	}
+}
+
+// heatSample is a node group's totals in a run (or an hour, once rolled up).
+type heatSample struct {
+	Cluster     string
+	Group       string
+	Time        time.Time
+	Allocatable int64
+	Requests    int64
+	Used        int64
+
+	// UsedMax is the peak usage, Used's for runs not rolled up.
+	UsedMax int64
+}
+
+// heatSamples reads each node group's totals of the resource of every run (or
+// hour, once rolled up) within the period before each cluster's latest run.
+func heatSamples(ctx context.Context, db *sql.DB, period time.Duration, cluster, resource string) ([]*heatSample, error) {
+	rows, err := db.QueryContext(ctx, `
+		WITH history AS (
+			SELECT time, cluster, node_group, allocatable, requests, used, used AS used_max FROM kubecap_nodes WHERE resource = $3
+			UNION ALL
+			SELECT time, cluster, node_group, allocatable, requests, used, used_max FROM kubecap_nodes_hourly WHERE resource = $3
+		), latest AS (
+			SELECT cluster, max(time) AS last
+			FROM history
+			WHERE $2 = '' OR cluster = $2
+			GROUP BY cluster
+		)
+		SELECT h.cluster, coalesce(nullif(h.node_group, ''), 'ungrouped'), h.time,
+			sum(h.allocatable), sum(h.requests), sum(h.used), sum(h.used_max)
+		FROM history h JOIN latest l ON h.cluster = l.cluster
+		WHERE h.time > l.last - make_interval(secs => $1)
+		GROUP BY 1, 2, 3`,
+		period.Seconds(), cluster, resource,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []*heatSample{}
+
+	for rows.Next() {
+		s := &heatSample{}
+
+		err = rows.Scan(&s.Cluster, &s.Group, &s.Time, &s.Allocatable, &s.Requests, &s.Used, &s.UsedMax)
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, s)
+	}
+
+	return samples, rows.Err()
+}
+
+// HeatBucket is a node group's requests and usage over the runs in an hour of
+// the day or on a day of the week. It is quiet when the peak requests and
+// usage stay below the quiet share of the average allocatable amount.
+type HeatBucket struct {
+	Cluster string `json:"cluster"`
+	Group   string `json:"group"`
+
+	// By is hour or weekday, and Bucket the hour (0-23) or the day (0 for
+	// Sunday to 6).
+	By     string `json:"by"`
+	Bucket int    `json:"bucket"`
+
+	Samples      int   `json:"samples"`
+	Allocatable  int64 `json:"allocatable"`
+	Requests     int64 `json:"requests"`
+	PeakRequests int64 `json:"peakRequests"`
+	Used         int64 `json:"used"`
+	PeakUsed     int64 `json:"peakUsed"`
+	Quiet        bool  `json:"quiet"`
+}
+
+// label returns the bucket's hour (e.g. 13:00) or day (e.g. Monday).
+func (b *HeatBucket) label() string {
+	if b.By == "weekday" {
+		return time.Weekday(b.Bucket).String()
+	}
+
+	return fmt.Sprintf("%02d:00", b.Bucket)
+}
+
+// heatBuckets averages the samples by cluster, node group and hour of day,
+// then day of week, in loc.
+func heatBuckets(samples []*heatSample, loc *time.Location, quiet float64) []*HeatBucket {
+	type key struct {
+		cluster, group, by string
+		bucket             int
+	}
+
+	type totals struct {
+		samples                     int
+		allocatable, requests, used int64
+		peakRequests, peakUsed      int64
+	}
+
+	sums := map[key]*totals{}
+
+	for _, s := range samples {
+		t := s.Time.In(loc)
+
+		for _, k := range []key{
+			{s.Cluster, s.Group, "hour", t.Hour()},
+			{s.Cluster, s.Group, "weekday", int(t.Weekday())},
+		} {
+			sum, ok := sums[k]
+			if !ok {
+				sum = &totals{}
+				sums[k] = sum
+			}
+
+			sum.samples++
+			sum.allocatable += s.Allocatable
+			sum.requests += s.Requests
+			sum.used += s.Used
+
+			if s.Requests > sum.peakRequests {
+				sum.peakRequests = s.Requests
+			}
+
+			if s.UsedMax > sum.peakUsed {
+				sum.peakUsed = s.UsedMax
+			}
+		}
+	}
+
+	buckets := []*HeatBucket{}
+
+	for k, sum := range sums {
+		n := int64(sum.samples)
+
+		b := &HeatBucket{
+			Cluster:      k.cluster,
+			Group:        k.group,
+			By:           k.by,
+			Bucket:       k.bucket,
+			Samples:      sum.samples,
+			Allocatable:  sum.allocatable / n,
+			Requests:     sum.requests / n,
+			PeakRequests: sum.peakRequests,
+			Used:         sum.used / n,
+			PeakUsed:     sum.peakUsed,
+		}
+
+		limit := int64(float64(b.Allocatable) * quiet)
+		b.Quiet = b.PeakRequests < limit && b.PeakUsed < limit
+
+		buckets = append(buckets, b)
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		a, b := buckets[i], buckets[j]
+
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+
+		if a.By != b.By {
+			return a.By == "hour"
+		}
+
+		return a.Bucket < b.Bucket
+	})
+
+	return buckets
+}
+
+// quietWindows returns the runs of consecutive quiet hours of the day in the
+// buckets of a node group, wrapping around midnight, e.g. 01:00-05:00 for
+// the quiet hours 1 to 4, in order of their start.
+func quietWindows(buckets []*HeatBucket) []string {
+	quiet := [24]bool{}
+	all := true
+
+	for _, b := range buckets {
+		if b.By == "hour" && b.Quiet {
+			quiet[b.Bucket] = true
+		}
+	}
+
+	for _, q := range quiet {
+		all = all && q
+	}
+
+	if all {
+		return []string{"00:00-24:00"}
+	}
+
+	// Start after a busy hour so a window spanning midnight isn't split.
+	start := 0
+	for quiet[start] {
+		start++
+	}
+
+	windows := []string{}
+
+	for i := 1; i <= 24; i++ {
+		h := (start + i) % 24
+		if !quiet[h] {
+			continue
+		}
+
+		end := h
+		for quiet[(end+1)%24] {
+			end++
+			i++
+		}
+
+		windows = append(windows, fmt.Sprintf("%02d:00-%02d:00", h, (end+1)%24))
+	}
+
+	sort.Strings(windows)
+
+	return windows
+}
+
+func writeHeat(w io.Writer, buckets []*HeatBucket) {
+	groups := [][]*HeatBucket{}
+
+	for i, b := range buckets {
+		if i == 0 || b.Cluster != buckets[i-1].Cluster || b.Group != buckets[i-1].Group {
+			groups = append(groups, nil)
+		}
+
+		groups[len(groups)-1] = append(groups[len(groups)-1], b)
+	}
+
+	for i, group := range groups {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		fmt.Fprintf(w, "Cluster: %s\n", group[0].Cluster)
+		fmt.Fprintf(w, "Node Group: %s\n", group[0].Group)
+
+		windows := quietWindows(group)
+		if len(windows) == 0 {
+			windows = []string{"none"}
+		}
+
+		fmt.Fprintf(w, "Quiet Windows: %s\n", strings.Join(windows, ", "))
+		fmt.Fprintln(w)
+
+		for _, by := range []string{"hour", "weekday"} {
+			table := tablewriter.NewWriter(w)
+			table.SetHeader([]string{
+				"When",
+				"Samples",
+				"Allocatable",
+				"Requests",
+				"Peak Requests",
+				"Used",
+				"Peak Used",
+				"Quiet?",
+			})
+
+			for _, b := range group {
+				if b.By != by {
+					continue
+				}
+
+				table.Append([]string{
+					b.label(),
+					fmt.Sprintf("%d", b.Samples),
+					humanize.Comma(b.Allocatable),
+					humanize.Comma(b.Requests),
+					humanize.Comma(b.PeakRequests),
+					humanize.Comma(b.Used),
+					humanize.Comma(b.PeakUsed),
+					fmt.Sprintf("%t", b.Quiet),
+				})
+			}
+
+			if by == "hour" {
+				fmt.Fprintln(w, "Heat by Hour of Day")
+			} else {
+				fmt.Fprintln(w, "Heat by Day of Week")
+			}
+			table.Render()
+		}
+	}
+}
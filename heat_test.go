@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHeatBuckets(t *testing.T) {
+	samples := []*heatSample{}
+
+	// A week of hourly samples of a 100 byte group, busy from 08:00 to
+	// 18:00 and on Wednesdays.
+	start := time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC)
+
+	for h := 0; h < 7*24; h++ {
+		at := start.Add(time.Duration(h) * time.Hour)
+
+		s := &heatSample{Cluster: "c", Group: "pool", Time: at, Allocatable: 100, Requests: 30, Used: 20, UsedMax: 25}
+		if at.Hour() >= 8 && at.Hour() < 18 {
+			s.Requests, s.Used, s.UsedMax = 70, 50, 60
+		}
+
+		if at.Weekday() == time.Wednesday && at.Hour() == 23 {
+			s.UsedMax = 90
+		}
+
+		samples = append(samples, s)
+	}
+
+	buckets := heatBuckets(samples, time.UTC, 0.5)
+	if len(buckets) != 24+7 {
+		t.Fatalf("%d buckets", len(buckets))
+	}
+
+	if b := buckets[0]; b.By != "hour" || b.Bucket != 0 || b.Samples != 7 || !b.Quiet {
+		t.Errorf("00:00 = %+v", b)
+	}
+
+	if b := buckets[8]; b.Requests != 70 || b.PeakUsed != 60 || b.Quiet {
+		t.Errorf("08:00 = %+v", b)
+	}
+
+	if b := buckets[23]; b.PeakUsed != 90 || b.Quiet {
+		t.Errorf("23:00 = %+v", b)
+	}
+
+	if b := buckets[24+3]; b.By != "weekday" || b.label() != "Wednesday" || b.PeakUsed != 90 {
+		t.Errorf("Wednesday = %+v", b)
+	}
+
+	if got, want := quietWindows(buckets), []string{"00:00-08:00", "18:00-23:00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("quiet windows = %q, want %q", got, want)
+	}
+
+	// Quiet hours across midnight make a single window.
+	buckets[23].Quiet = true
+
+	if got, want := quietWindows(buckets), []string{"18:00-08:00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("quiet windows = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+)
+
+// htmlOutput renders the nodes as a treemap with each node's area
+// proportional to its allocatable memory and colored by either utilization
+// (used / allocatable) or headroom (schedulable / allocatable).
+type htmlOutput struct {
+	w         io.Writer
+	md        *kubecap.Metadata
+	nodes     []*kubecap.NodeReport
+	evictable []*kubecap.EvictableContainer
+}
+
+func newHTMLOutput(w io.Writer) *htmlOutput {
+	return &htmlOutput{w: w}
+}
+
+func (h *htmlOutput) Metadata(m *kubecap.Metadata) error {
+	h.md = m
+
+	return nil
+}
+
+func (h *htmlOutput) Node(n *kubecap.NodeReport) error {
+	h.nodes = append(h.nodes, n)
+
+	return nil
+}
+
+func (h *htmlOutput) Evictable(e *kubecap.EvictableContainer) error {
+	h.evictable = append(h.evictable, e)
+
+	return nil
+}
+
+// Treemap dimensions in pixels.
+const (
+	treemapWidth  = 1200.0
+	treemapHeight = 800.0
+)
+
+type htmlTile struct {
+	X, Y, W, H float64
+
+	Node             *kubecap.NodeReport
+	UtilizationColor template.CSS
+	HeadroomColor    template.CSS
+	Title            string
+}
+
+func (h *htmlOutput) Flush() error {
+	sizes := make([]float64, len(h.nodes))
+	for i, n := range h.nodes {
+		sizes[i] = float64(n.Allocatable)
+	}
+
+	rects := squarify(sizes, rect{0, 0, treemapWidth, treemapHeight})
+
+	tiles := make([]htmlTile, len(h.nodes))
+	for i, n := range h.nodes {
+		var utilization, headroom float64
+		if n.Allocatable > 0 {
+			utilization = float64(n.Used) / float64(n.Allocatable)
+			headroom = float64(n.Schedulable) / float64(n.Allocatable)
+		}
+
+		tiles[i] = htmlTile{
+			X:    rects[i].x,
+			Y:    rects[i].y,
+			W:    rects[i].w,
+			H:    rects[i].h,
+			Node: n,
+
+			// Hot spots are red in both views: high utilization or little
+			// headroom.
+			UtilizationColor: heatColor(utilization),
+			HeadroomColor:    heatColor(1 - headroom),
+
+			Title: fmt.Sprintf(
+				"%s\ncluster %s\nallocatable %s\nused %s (%.0f%%)\nrequests %s\nschedulable %s (%.0f%%)\nok %t",
+				n.Name,
+				n.Cluster,
+				humanize.IBytes(uint64(n.Allocatable)),
+				humanize.IBytes(nonNegative(n.Used)), utilization*100,
+				humanize.IBytes(nonNegative(n.Requests)),
+				humanize.IBytes(nonNegative(n.Schedulable)), headroom*100,
+				n.Ok,
+			),
+		}
+	}
+
+	return htmlTemplate.Execute(h.w, map[string]interface{}{
+		"Metadata":  h.md,
+		"Width":     treemapWidth,
+		"Height":    treemapHeight,
+		"Tiles":     tiles,
+		"Evictable": h.evictable,
+	})
+}
+
+// nonNegative clamps negative amounts (e.g. overcommitted schedulable
+// memory) to zero for display as byte sizes.
+func nonNegative(v int64) uint64 {
+	if v < 0 {
+		return 0
+	}
+
+	return uint64(v)
+}
+
+// heatColor maps v in [0, 1] onto a green (0) to red (1) hue.
+func heatColor(v float64) template.CSS {
+	v = math.Max(0, math.Min(1, v))
+
+	return template.CSS(fmt.Sprintf("hsl(%.0f, 70%%, 50%%)", 120*(1-v)))
+}
+
+type rect struct {
+	x, y, w, h float64
+}
+
+// squarify lays out sizes within bounds using the squarified treemap
+// algorithm (Bruls, Huizing & van Wijk), returning a rect for each size in the
+// original order.
+func squarify(sizes []float64, bounds rect) []rect {
+	rects := make([]rect, len(sizes))
+
+	var total float64
+	for _, s := range sizes {
+		total += s
+	}
+
+	if total <= 0 {
+		return rects
+	}
+
+	// Lay out the largest first, scaling sizes to areas.
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return sizes[order[i]] > sizes[order[j]]
+	})
+
+	scale := bounds.w * bounds.h / total
+
+	area := func(i int) float64 {
+		return sizes[i] * scale
+	}
+
+	// worst returns the worst aspect ratio of row when laid along side.
+	worst := func(row []int, side float64) float64 {
+		var sum, max float64
+		min := math.Inf(1)
+
+		for _, i := range row {
+			a := area(i)
+			sum += a
+			max = math.Max(max, a)
+			min = math.Min(min, a)
+		}
+
+		if sum == 0 || min == 0 {
+			return math.Inf(1)
+		}
+
+		return math.Max(side*side*max/(sum*sum), sum*sum/(side*side*min))
+	}
+
+	free := bounds
+	row := []int{}
+
+	layout := func() {
+		var sum float64
+		for _, i := range row {
+			sum += area(i)
+		}
+
+		if free.w >= free.h {
+			// Lay the row out as a column on the left.
+			w := sum / free.h
+			y := free.y
+
+			for _, i := range row {
+				h := area(i) / w
+				rects[i] = rect{free.x, y, w, h}
+				y += h
+			}
+
+			free.x += w
+			free.w -= w
+		} else {
+			// Lay the row out along the top.
+			h := sum / free.w
+			x := free.x
+
+			for _, i := range row {
+				w := area(i) / h
+				rects[i] = rect{x, free.y, w, h}
+				x += w
+			}
+
+			free.y += h
+			free.h -= h
+		}
+
+		row = []int{}
+	}
+
+	for _, i := range order {
+		side := math.Min(free.w, free.h)
+
+		if len(row) > 0 && worst(append(row[:len(row):len(row)], i), side) > worst(row, side) {
+			layout()
+		}
+
+		row = append(row, i)
+	}
+
+	if len(row) > 0 {
+		layout()
+	}
+
+	return rects
+}
+
+var htmlTemplate = template.Must(template.New("html").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+	"comma":   humanize.Comma,
+	"percent": coveragePercent,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kubecap{{with .Metadata}} {{.Context}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+.treemap { position: relative; border: 1px solid #333; }
+.tile { position: absolute; box-sizing: border-box; border: 1px solid #fff; overflow: hidden; font-size: 11px; padding: 2px; color: #000; }
+.headroom .tile { background: var(--headroom) !important; }
+table { border-collapse: collapse; margin-top: 1em; }
+td, th { border: 1px solid #ccc; padding: 2px 6px; text-align: right; }
+td:first-child, th:first-child { text-align: left; }
+</style>
+</head>
+<body>
+{{with .Metadata}}
+<h1>Node Report</h1>
+<p>
+Collected {{time .Timestamp}} from context {{.Context}} (cluster {{.Cluster}}, server {{.ServerVersion}}).
+Additional: {{.AdditionalInput}} ({{if .AdditionalPercent}}of each node's allocatable{{else}}{{comma .Additional}} bytes{{end}}).
+{{with .Quota}}Namespace {{.Namespace}} quota headroom: {{if lt .Headroom 0}}unlimited{{else}}{{comma .Headroom}} bytes{{end}}; blocked by: {{.Blocker}}.{{end}}
+{{with .Coverage}}Requests coverage: memory {{percent .Memory}}, CPU {{percent .CPU}} of {{.Containers}} containers.
+{{end}}{{with .Scheduler}}Scheduler: {{.}}.
+{{end}}{{with .Autoscaler}}{{range .NodeGroups}}{{if .Full}}Autoscaler node group {{.Name}} is full at its maximum size of {{.Max}} nodes.
+{{end}}{{end}}{{end}}{{with .Kueue}}{{range .ClusterQueues}}Kueue ClusterQueue {{.Name}}: {{.PendingWorkloads}} pending Workloads requesting {{comma .Pending}} bytes; blocked by: {{.Blocker}}.
+{{end}}{{end}}
+</p>
+{{end}}
+<p>
+Tiles are sized by allocatable memory. Color by:
+<label><input type="radio" name="color" checked onclick="document.getElementById('treemap').classList.remove('headroom')"> utilization</label>
+<label><input type="radio" name="color" onclick="document.getElementById('treemap').classList.add('headroom')"> headroom</label>
+</p>
+<div id="treemap" class="treemap" style="width: {{.Width}}px; height: {{.Height}}px;">
+{{range .Tiles}}<div class="tile" title="{{.Title}}" style="left: {{printf "%.1f" .X}}px; top: {{printf "%.1f" .Y}}px; width: {{printf "%.1f" .W}}px; height: {{printf "%.1f" .H}}px; background: {{.UtilizationColor}}; --headroom: {{.HeadroomColor}};">{{.Node.Name}}</div>
+{{end}}</div>
+{{if .Evictable}}
+<h1>Evictable Pods Report</h1>
+<table>
+<tr><th>Cluster</th><th>Node</th><th>Namespace</th><th>Pod</th><th>Container</th><th>Requests</th><th>Used</th><th>Limits</th></tr>
+{{range .Evictable}}<tr><td>{{.Cluster}}</td><td>{{.Node}}</td><td>{{.Namespace}}</td><td>{{.Pod}}</td><td>{{.Container}}</td><td>{{comma .Requests}}</td><td>{{comma .Used}}</td><td>{{comma .Limits}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSquarify(t *testing.T) {
+	bounds := rect{10, 20, 600, 400}
+	sizes := []float64{1, 6, 0, 2, 3, 2, 4, 6}
+
+	rects := squarify(sizes, bounds)
+	if len(rects) != len(sizes) {
+		t.Fatalf("rects = %d, want %d", len(rects), len(sizes))
+	}
+
+	const eps = 1e-6
+
+	var total, area float64
+	for _, s := range sizes {
+		total += s
+	}
+
+	for i, r := range rects {
+		a := r.w * r.h
+		area += a
+
+		// Areas are proportional to sizes.
+		if want := sizes[i] / total * bounds.w * bounds.h; math.Abs(a-want) > eps {
+			t.Errorf("rect %d area = %v, want %v", i, a, want)
+		}
+
+		if sizes[i] == 0 {
+			continue
+		}
+
+		if r.x < bounds.x-eps || r.y < bounds.y-eps ||
+			r.x+r.w > bounds.x+bounds.w+eps || r.y+r.h > bounds.y+bounds.h+eps {
+			t.Errorf("rect %d %+v outside bounds", i, r)
+		}
+
+		for j := i + 1; j < len(rects); j++ {
+			o := rects[j]
+			if sizes[j] == 0 {
+				continue
+			}
+
+			if r.x+eps < o.x+o.w && o.x+eps < r.x+r.w && r.y+eps < o.y+o.h && o.y+eps < r.y+r.h {
+				t.Errorf("rects %d %+v and %d %+v overlap", i, r, j, o)
+			}
+		}
+	}
+
+	// Together they tile the bounds.
+	if math.Abs(area-bounds.w*bounds.h) > eps {
+		t.Errorf("total area = %v, want %v", area, bounds.w*bounds.h)
+	}
+}
+
+func TestSquarifyEmpty(t *testing.T) {
+	for _, r := range squarify([]float64{0, 0}, rect{0, 0, 10, 10}) {
+		if r != (rect{}) {
+			t.Errorf("rect = %+v, want zero", r)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+// influxOutput writes the report as InfluxDB line protocol, either to the
+// InfluxDB v2 write API or appended to a file.
+type influxOutput struct {
+	url    string
+	org    string
+	bucket string
+	token  string
+	file   string
+	client *http.Client
+	md     *kubecap.Metadata
+	nodes  []*kubecap.NodeReport
+}
+
+func newInfluxOutput(url, org, bucket, token, file string) *influxOutput {
+	return &influxOutput{
+		url:    strings.TrimSuffix(url, "/"),
+		org:    org,
+		bucket: bucket,
+		token:  token,
+		file:   file,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (i *influxOutput) Metadata(m *kubecap.Metadata) error {
+	i.md = m
+	i.nodes = nil
+
+	return nil
+}
+
+func (i *influxOutput) Node(n *kubecap.NodeReport) error {
+	i.nodes = append(i.nodes, n)
+
+	return nil
+}
+
+func (i *influxOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+// influxTagEscaper escapes tag keys and values in line protocol.
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxLine formats a line protocol point. Tags are given as key, value
+// pairs and empty values are left out.
+func influxLine(b *bytes.Buffer, measurement string, tags []string, fields string, ts time.Time) {
+	b.WriteString(measurement)
+
+	for t := 0; t+1 < len(tags); t += 2 {
+		if tags[t+1] == "" {
+			continue
+		}
+
+		fmt.Fprintf(b, ",%s=%s", influxTagEscaper.Replace(tags[t]), influxTagEscaper.Replace(tags[t+1]))
+	}
+
+	fmt.Fprintf(b, " %s %d\n", fields, ts.UnixNano())
+}
+
+func (i *influxOutput) lines() []byte {
+	b := &bytes.Buffer{}
+	ts := i.md.Timestamp
+
+	for _, n := range i.nodes {
+		influxLine(b, "kubecap_node", []string{
+			"cluster", i.md.Context,
+			"node", n.Name,
+			"node_group", n.Group,
+		}, fmt.Sprintf(
+			"allocatable=%di,used=%di,free=%di,requests=%di,schedulable=%di,efficiency=%g,ok=%t,pressure=%g",
+			n.Allocatable, n.Used, n.Free, n.Requests, n.Schedulable, n.Efficiency, n.Ok, n.Pressure,
+		), ts)
+	}
+
+	sum := kubecap.Summarize(i.nodes)
+
+	influxLine(b, "kubecap_cluster", []string{
+		"cluster", i.md.Context,
+	}, fmt.Sprintf(
+		"nodes=%di,ok_nodes=%di,allocatable=%di,used=%di,requests=%di,schedulable=%di,additional=%di",
+		sum.Nodes, sum.OkNodes, sum.Allocatable, sum.Used, sum.Requests, sum.Schedulable, i.md.Additional,
+	), ts)
+
+	if i.md.CostCenters != nil {
+		for _, c := range kubecap.CostCenterReports(i.nodes) {
+			influxLine(b, "kubecap_cost_center", []string{
+				"cluster", i.md.Context,
+				"cost_center", c.CostCenter,
+			}, fmt.Sprintf("pods=%di,requests=%di,used=%di", c.Pods, c.Requests, c.Used), ts)
+		}
+	}
+
+	return b.Bytes()
+}
+
+func (i *influxOutput) Flush() error {
+	lines := i.lines()
+
+	if i.file != "" {
+		f, err := os.OpenFile(i.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(lines)
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if i.url == "" {
+		return nil
+	}
+
+	q := url.Values{}
+	q.Set("org", i.org)
+	q.Set("bucket", i.bucket)
+	q.Set("precision", "ns")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.url+"/api/v2/write?"+q.Encode(), bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if i.token != "" {
+		req.Header.Set("Authorization", "Token "+i.token)
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("influxdb write: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestInfluxLine(t *testing.T) {
+	ts := time.Unix(1700000000, 123456789)
+
+	b := &bytes.Buffer{}
+	influxLine(b, "kubecap_node", []string{
+		"cluster", "prod eu",
+		"node", "ip-10-0-0-1",
+		"node_group", "",
+		"label,key", "a=b",
+	}, "free=1i,ok=true", ts)
+
+	// Commas, equals signs and spaces in tags are escaped, tags without a
+	// value are left out and the timestamp is in nanoseconds.
+	want := `kubecap_node,cluster=prod\ eu,node=ip-10-0-0-1,label\,key=a\=b free=1i,ok=true 1700000000123456789` + "\n"
+	if b.String() != want {
+		t.Errorf("line = %q, want %q", b, want)
+	}
+}
+
+func TestInfluxOutput(t *testing.T) {
+	var body []byte
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v2/write" ||
+			q.Get("org") != "ops" || q.Get("bucket") != "capacity" || q.Get("precision") != "ns" {
+			t.Errorf("%s %s", r.Method, r.URL)
+		}
+
+		if r.Header.Get("Authorization") != "Token secret" || r.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+			t.Errorf("headers = %v", r.Header)
+		}
+
+		var err error
+
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "kubecap.lp")
+
+	out := newInfluxOutput(srv.URL+"/", "ops", "capacity", "secret", file)
+
+	md := &kubecap.Metadata{Context: "prod", Timestamp: time.Unix(1700000000, 0), Additional: 1 << 30}
+
+	for i := 0; i < 2; i++ {
+		err := out.Metadata(md)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = out.Node(&kubecap.NodeReport{Name: "node-a", Group: "web pool", Allocatable: 8 << 30, Used: 2 << 30, Free: 6 << 30, Requests: 4 << 30, Schedulable: 4 << 30, Efficiency: 0.5, Ok: true, Pressure: 25})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = out.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := `kubecap_node,cluster=prod,node=node-a,node_group=web\ pool allocatable=8589934592i,used=2147483648i,free=6442450944i,requests=4294967296i,schedulable=4294967296i,efficiency=0.5,ok=true,pressure=25 1700000000000000000
+kubecap_cluster,cluster=prod nodes=1i,ok_nodes=1i,allocatable=8589934592i,used=2147483648i,requests=4294967296i,schedulable=4294967296i,additional=1073741824i 1700000000000000000
+`
+	if string(body) != want {
+		t.Errorf("written =\n%s\nwant\n%s", body, want)
+	}
+
+	// The file is appended to on every flush.
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != strings.Repeat(want, 2) {
+		t.Errorf("file =\n%s", data)
+	}
+}
+
+func TestInfluxOutputError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, `{"code":"unauthorized"}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	out := newInfluxOutput(srv.URL, "ops", "capacity", "wrong", "")
+	out.Metadata(&kubecap.Metadata{Context: "prod"})
+
+	err := out.Flush()
+	if err == nil || err.Error() != `influxdb write: 401 Unauthorized: {"code":"unauthorized"}` {
+		t.Errorf("err = %v", err)
+	}
+}
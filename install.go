@@ -0,0 +1,366 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// installMain implements the install subcommand, which prints the manifests
+// for running kubecap inside the cluster.
+func installMain(args []string) {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	print := fs.Bool("print", false, "print the manifests (pipe them to kubectl apply -f -)")
+	mode := fs.String("mode", "watch", "how kubecap runs: watch (a Deployment reporting every --interval in watch mode), exporter (a Deployment serving the latest report's metrics, updated every --interval, behind a Service), scheduled-report (a Deployment sending reports on --schedule) or cronjob (a CronJob running a single report on --schedule)")
+	namespace := fs.String("namespace", "kubecap", "namespace to run kubecap in")
+	image := fs.String("image", "", "kubecap container image")
+	interval := fs.String("interval", "1m", "with --mode watch or exporter, how often to report")
+	serviceMonitor := fs.Bool("service-monitor", false, "with --mode exporter, also print a Prometheus Operator ServiceMonitor scraping the metrics every --interval")
+	schedule := fs.String("schedule", "0 8 * * 1", "with --mode scheduled-report or cronjob, the cron schedule")
+	publishReports := fs.Bool("publish-reports", false, "pass --publish-reports to kubecap, installing the capacity report CustomResourceDefinitions and letting it manage the reports")
+	annotateNodes := fs.Bool("annotate-nodes", false, "pass --annotate-nodes to kubecap and let it patch the nodes' annotations")
+	envSecret := fs.String("env-secret", "", "Secret whose keys are passed to kubecap as environment variables (tokens, passwords, ...)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s install --print --image IMAGE [flags] [-- kubecap flags and additional amount]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if !*print {
+		panic("install only prints the manifests: use --print")
+	}
+
+	if *image == "" {
+		panic("install requires --image")
+	}
+
+	im := &installManifests{
+		Namespace: *namespace,
+		Image:     *image,
+		EnvSecret: *envSecret,
+		Args:      fs.Args(),
+
+		AnnotateNodes:  *annotateNodes,
+		PublishReports: *publishReports,
+	}
+
+	if *annotateNodes {
+		im.Args = append([]string{"--annotate-nodes"}, im.Args...)
+	}
+
+	if *publishReports {
+		im.Args = append([]string{"--publish-reports"}, im.Args...)
+	}
+
+	switch *mode {
+	case "watch":
+		im.Args = append([]string{"--watch", *interval}, im.Args...)
+	case "exporter":
+		im.Args = append([]string{"serve", "--listen", fmt.Sprintf(":%d", installMetricsPort), "--watch", *interval}, im.Args...)
+		im.Exporter = true
+		im.ServiceMonitor = *serviceMonitor
+		im.Interval = *interval
+	case "scheduled-report":
+		im.Args = append([]string{"--report-schedule", *schedule}, im.Args...)
+	case "cronjob":
+		im.CronJob = true
+		im.Schedule = *schedule
+	default:
+		panic(fmt.Sprintf("unknown install mode: %q", *mode))
+	}
+
+	if *serviceMonitor && !im.Exporter {
+		panic("--service-monitor requires --mode exporter")
+	}
+
+	err := im.write(os.Stdout)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// installMetricsPort is the port kubecap serves its metrics on with --mode
+// exporter.
+const installMetricsPort = 9090
+
+// installManifests parameterizes the in-cluster manifests.
+type installManifests struct {
+	Namespace string
+	Image     string
+	EnvSecret string
+
+	// Args are passed to kubecap.
+	Args []string
+
+	// CronJob runs a single report on the Schedule rather than a
+	// long-running Deployment.
+	CronJob  bool
+	Schedule string
+
+	// Exporter serves the metrics on installMetricsPort behind a Service
+	// and, with ServiceMonitor, has the Prometheus Operator scrape them
+	// every Interval.
+	Exporter       bool
+	ServiceMonitor bool
+	Interval       string
+
+	// AnnotateNodes grants patching the nodes, the only write kubecap
+	// needs.
+	AnnotateNodes bool
+
+	// PublishReports installs the NodeCapacityReport and
+	// ClusterCapacityReport CustomResourceDefinitions and grants managing
+	// them.
+	PublishReports bool
+
+	// PodSpec is the rendered pod spec, shared by both.
+	PodSpec string
+}
+
+func (im *installManifests) write(w io.Writer) error {
+	pod := &strings.Builder{}
+
+	err := installTemplate.ExecuteTemplate(pod, "pod", im)
+	if err != nil {
+		return err
+	}
+
+	im.PodSpec = strings.TrimRight(pod.String(), "\n")
+
+	return installTemplate.Execute(w, im)
+}
+
+// installTemplate renders the manifests. Strings are quoted as JSON, which is
+// also valid YAML.
+var installTemplate = template.Must(template.New("install").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+	"port":  func() int { return installMetricsPort },
+	// crd gives the crd template a kind, its plural, singular and short
+	// names and its printer columns, each name:type:jsonPath.
+	"crd": func(kind, plural, singular, short string, columns ...string) map[string]interface{} {
+		cs := [][]string{}
+		for _, c := range columns {
+			cs = append(cs, strings.SplitN(c, ":", 3))
+		}
+
+		return map[string]interface{}{"kind": kind, "plural": plural, "singular": singular, "short": short, "columns": cs}
+	},
+	"indent": func(n int, s string) string {
+		pad := strings.Repeat(" ", n)
+
+		return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+	},
+}).Parse(`{{ define "pod" -}}
+serviceAccountName: kubecap
+{{- if .CronJob }}
+restartPolicy: OnFailure
+{{- end }}
+containers:
+- name: kubecap
+  image: {{ quote .Image }}
+  {{- if .Args }}
+  args:
+  {{- range .Args }}
+  - {{ quote . }}
+  {{- end }}
+  {{- end }}
+  {{- if .Exporter }}
+  ports:
+  - name: metrics
+    containerPort: {{ port }}
+  {{- end }}
+  {{- if .EnvSecret }}
+  envFrom:
+  - secretRef:
+      name: {{ quote .EnvSecret }}
+  {{- end }}
+  securityContext:
+    allowPrivilegeEscalation: false
+    readOnlyRootFilesystem: true
+    runAsNonRoot: true
+    runAsUser: 65534
+{{ end -}}
+{{ define "crd" -}}
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: {{ .plural }}.kubecap.io
+spec:
+  group: kubecap.io
+  scope: Cluster
+  names:
+    kind: {{ .kind }}
+    listKind: {{ .kind }}List
+    plural: {{ .plural }}
+    singular: {{ .singular }}
+    shortNames: [{{ .short }}]
+  versions:
+  - name: v1alpha1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          status:
+            type: object
+            # The report's fields, see the README.
+            x-kubernetes-preserve-unknown-fields: true
+    additionalPrinterColumns:
+{{- range .columns }}
+    - name: {{ index . 0 }}
+      type: {{ index . 1 }}
+      jsonPath: {{ index . 2 }}
+{{- end }}
+{{ end -}}
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ quote .Namespace }}
+---
+{{ if .PublishReports -}}
+{{ template "crd" (crd "NodeCapacityReport" "nodecapacityreports" "nodecapacityreport" "ncr" "Group:string:.status.group" "Schedulable:integer:.status.schedulable" "Ok:boolean:.status.ok" "Updated:date:.status.updated") -}}
+---
+{{ template "crd" (crd "ClusterCapacityReport" "clustercapacityreports" "clustercapacityreport" "ccr" "Nodes:integer:.status.nodes" "Ok Nodes:integer:.status.okNodes" "Schedulable:integer:.status.schedulable" "Fits:boolean:.status.fits" "Updated:date:.status.updated") -}}
+---
+{{ end -}}
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: kubecap
+  namespace: {{ quote .Namespace }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: kubecap
+rules:
+- apiGroups: [""]
+  resources: ["nodes", "pods", "resourcequotas", "events"]
+  verbs: ["get", "list"]
+- apiGroups: [""]
+  # The kubelet's configz, stats summary and cAdvisor metrics.
+  resources: ["nodes/proxy"]
+  verbs: ["get"]
+- apiGroups: ["metrics.k8s.io"]
+  resources: ["nodes", "pods"]
+  verbs: ["get", "list"]
+- apiGroups: ["storage.k8s.io"]
+  resources: ["csinodes", "volumeattachments"]
+  verbs: ["list"]
+- apiGroups: ["resource.k8s.io"]
+  resources: ["resourceslices", "resourceclaims", "deviceclasses"]
+  verbs: ["get", "list"]
+- apiGroups: ["topology.node.k8s.io"]
+  resources: ["noderesourcetopologies"]
+  verbs: ["get"]
+- apiGroups: ["batch"]
+  resources: ["jobs"]
+  verbs: ["list"]
+- apiGroups: ["kueue.x-k8s.io"]
+  resources: ["clusterqueues", "localqueues", "workloads"]
+  verbs: ["list"]
+- apiGroups: ["scheduling.k8s.io"]
+  # --standby reads the priority of PriorityClasses no pod uses yet.
+  resources: ["priorityclasses"]
+  verbs: ["get"]
+- apiGroups: [""]
+  # The Cluster Autoscaler's status.
+  resources: ["configmaps"]
+  resourceNames: ["cluster-autoscaler-status"]
+  verbs: ["get"]
+{{- if .AnnotateNodes }}
+- apiGroups: [""]
+  # --annotate-nodes writes the report back onto the nodes.
+  resources: ["nodes"]
+  verbs: ["patch"]
+{{- end }}
+{{- if .PublishReports }}
+- apiGroups: ["kubecap.io"]
+  resources: ["nodecapacityreports", "clustercapacityreports"]
+  verbs: ["get", "list", "create", "patch", "delete"]
+{{- end }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: kubecap
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: kubecap
+subjects:
+- kind: ServiceAccount
+  name: kubecap
+  namespace: {{ quote .Namespace }}
+---
+{{ if .CronJob -}}
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: kubecap
+  namespace: {{ quote .Namespace }}
+spec:
+  schedule: {{ quote .Schedule }}
+  concurrencyPolicy: Forbid
+  jobTemplate:
+    spec:
+      template:
+        spec:
+{{ indent 10 .PodSpec }}
+{{- else -}}
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: kubecap
+  namespace: {{ quote .Namespace }}
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: kubecap
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: kubecap
+    spec:
+{{ indent 6 .PodSpec }}
+{{- end }}
+{{- if .Exporter }}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: kubecap
+  namespace: {{ quote .Namespace }}
+  labels:
+    app.kubernetes.io/name: kubecap
+spec:
+  selector:
+    app.kubernetes.io/name: kubecap
+  ports:
+  - name: metrics
+    port: {{ port }}
+    targetPort: metrics
+{{- end }}
+{{- if .ServiceMonitor }}
+---
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: kubecap
+  namespace: {{ quote .Namespace }}
+spec:
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: kubecap
+  endpoints:
+  - port: metrics
+    path: /metrics
+    interval: {{ quote .Interval }}
+{{- end }}
+`))
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// installDocuments renders the manifests and returns their documents by
+// kind.
+func installDocuments(t *testing.T, im *installManifests) map[string]string {
+	t.Helper()
+
+	b := &bytes.Buffer{}
+
+	err := im.write(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := map[string]string{}
+	for _, doc := range strings.Split(b.String(), "\n---\n") {
+		for _, line := range strings.Split(doc, "\n") {
+			if strings.HasPrefix(line, "kind: ") {
+				docs[strings.TrimPrefix(line, "kind: ")] = doc
+			}
+		}
+	}
+
+	return docs
+}
+
+func decodeInstall(t *testing.T, doc string, into interface{}) {
+	t.Helper()
+
+	err := utilyaml.NewYAMLOrJSONDecoder(strings.NewReader(doc), 4096).Decode(into)
+	if err != nil && err != io.EOF {
+		t.Fatalf("%v:\n%s", err, doc)
+	}
+}
+
+func TestInstallExporter(t *testing.T) {
+	docs := installDocuments(t, &installManifests{
+		Namespace:      "capacity",
+		Image:          "registry.example.com/kubecap:v1",
+		Args:           []string{"serve", "--listen", ":9090", "--watch", "30s", "2GiB"},
+		Exporter:       true,
+		ServiceMonitor: true,
+		Interval:       "30s",
+	})
+
+	d := &appsv1.Deployment{}
+	decodeInstall(t, docs["Deployment"], d)
+
+	c := d.Spec.Template.Spec.Containers[0]
+	if !reflect.DeepEqual(c.Args, []string{"serve", "--listen", ":9090", "--watch", "30s", "2GiB"}) {
+		t.Errorf("args = %q", c.Args)
+	}
+
+	if len(c.Ports) != 1 || c.Ports[0].Name != "metrics" || c.Ports[0].ContainerPort != 9090 {
+		t.Errorf("ports = %+v", c.Ports)
+	}
+
+	svc := &corev1.Service{}
+	decodeInstall(t, docs["Service"], svc)
+
+	if svc.Namespace != "capacity" || !reflect.DeepEqual(svc.Spec.Selector, d.Spec.Template.Labels) {
+		t.Errorf("service %s/%s selects %v, want the pods' labels %v", svc.Namespace, svc.Name, svc.Spec.Selector, d.Spec.Template.Labels)
+	}
+
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 9090 || svc.Spec.Ports[0].TargetPort.String() != "metrics" {
+		t.Errorf("service ports = %+v", svc.Spec.Ports)
+	}
+
+	// The ServiceMonitor isn't in the vendored API types.
+	sm := struct {
+		APIVersion string `json:"apiVersion"`
+		Metadata   struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			Selector struct {
+				MatchLabels map[string]string `json:"matchLabels"`
+			} `json:"selector"`
+			Endpoints []struct {
+				Port     string `json:"port"`
+				Path     string `json:"path"`
+				Interval string `json:"interval"`
+			} `json:"endpoints"`
+		} `json:"spec"`
+	}{}
+	decodeInstall(t, docs["ServiceMonitor"], &sm)
+
+	if sm.APIVersion != "monitoring.coreos.com/v1" || sm.Metadata.Namespace != "capacity" || !reflect.DeepEqual(sm.Spec.Selector.MatchLabels, svc.Labels) {
+		t.Errorf("service monitor = %+v, want selecting the service labelled %v", sm, svc.Labels)
+	}
+
+	if len(sm.Spec.Endpoints) != 1 || sm.Spec.Endpoints[0].Port != "metrics" || sm.Spec.Endpoints[0].Path != "/metrics" || sm.Spec.Endpoints[0].Interval != "30s" {
+		t.Errorf("endpoints = %+v", sm.Spec.Endpoints)
+	}
+
+	// Without the exporter nothing is served.
+	docs = installDocuments(t, &installManifests{Namespace: "capacity", Image: "kubecap", Args: []string{"--watch", "1m"}})
+
+	if _, ok := docs["Service"]; ok {
+		t.Errorf("watch mode has a Service")
+	}
+
+	if _, ok := docs["ServiceMonitor"]; ok {
+		t.Errorf("watch mode has a ServiceMonitor")
+	}
+}
+
+func TestInstallClusterRole(t *testing.T) {
+	docs := installDocuments(t, &installManifests{Namespace: "kubecap", Image: "kubecap"})
+
+	role := &rbacv1.ClusterRole{}
+	decodeInstall(t, docs["ClusterRole"], role)
+
+	// --standby gets the PriorityClasses no pod uses yet.
+	for _, rule := range role.Rules {
+		if reflect.DeepEqual(rule.APIGroups, []string{"scheduling.k8s.io"}) && reflect.DeepEqual(rule.Resources, []string{"priorityclasses"}) && reflect.DeepEqual(rule.Verbs, []string{"get"}) {
+			return
+		}
+	}
+
+	t.Errorf("no rule getting priorityclasses: %+v", role.Rules)
+}
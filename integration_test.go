@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	update   = flag.Bool("update", false, "rewrite the integration fixtures' golden files with the output")
+	fixtures = flag.String("fixtures", "testdata/integration", "directory of integration fixtures, each a directory with cluster.yaml and cases.yaml")
+)
+
+// TestMain runs kubecap itself when the integration tests execute the test
+// binary as it.
+func TestMain(m *testing.M) {
+	if os.Getenv("KUBECAP_INTEGRATION_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// integrationCases are the cases of an integration fixture. Each serves
+// cluster.yaml from an API server, runs kubecap with its args and a
+// kubeconfig for the server in the fixture's directory, so that files the
+// args name are relative to it, and compares its standard output with
+// golden/NAME.txt.
+type integrationCases struct {
+	Cases []struct {
+		Name     string   `json:"name"`
+		Args     []string `json:"args"`
+		ExitCode int      `json:"exitCode"`
+
+		// PageSize is how many nodes or pods the API server returns per
+		// list request, defaultPageSize when 0.
+		PageSize int `json:"pageSize"`
+
+		// Forbidden are the resources (e.g. pods or nodes.metrics.k8s.io)
+		// the API server denies access to, as RBAC would.
+		Forbidden []string `json:"forbidden"`
+
+		// Stderr is expected in the standard error, if set.
+		Stderr string `json:"stderr"`
+	} `json:"cases"`
+}
+
+// defaultPageSize is how many objects the integration API server returns
+// per list request unless a case says otherwise, small enough for the
+// fixtures' lists to span several pages.
+const defaultPageSize = 2
+
+// integrationServer serves a simulation as an API server would: lists of
+// nodes and pods page by page with continue tokens, whatever limit the
+// client asks for, and forbidden resources denied.
+type integrationServer struct {
+	sim       *simulation
+	pageSize  int
+	forbidden map[string]bool
+
+	mu sync.Mutex
+	// unpaged are the lists requested without a limit.
+	unpaged []string
+}
+
+// integrationResource returns the resource of an API path, qualified by its
+// group outside the core group, e.g. pods or nodes.metrics.k8s.io.
+func integrationResource(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	group := ""
+
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		group = "." + parts[1]
+		parts = parts[3:]
+	default:
+		return ""
+	}
+
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		return parts[2] + group
+	}
+
+	return parts[0] + group
+}
+
+func (is *integrationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resource := integrationResource(r.URL.Path)
+
+	if is.forbidden[resource] {
+		name, group := resource, ""
+		if i := strings.Index(resource, "."); i >= 0 {
+			name, group = resource[:i], resource[i+1:]
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+
+		json.NewEncoder(w).Encode(metav1.Status{
+			TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metav1.StatusFailure,
+			Message:  fmt.Sprintf("%s is forbidden: User \"kubecap\" cannot list resource %q in API group %q at the cluster scope", resource, name, group),
+			Reason:   metav1.StatusReasonForbidden,
+			Code:     http.StatusForbidden,
+		})
+
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path != "/api/v1/nodes" && path != "/api/v1/pods" {
+		is.sim.ServeHTTP(w, r)
+
+		return
+	}
+
+	if r.URL.Query().Get("limit") == "" {
+		is.mu.Lock()
+		is.unpaged = append(is.unpaged, path)
+		is.mu.Unlock()
+	}
+
+	rec := httptest.NewRecorder()
+	is.sim.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusOK {
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+
+		return
+	}
+
+	// Both lists are decoded generically so that fields the typed lists
+	// don't know (e.g. the pods' resources) are kept.
+	list := struct {
+		APIVersion string            `json:"apiVersion"`
+		Kind       string            `json:"kind"`
+		Metadata   metav1.ListMeta   `json:"metadata"`
+		Items      []json.RawMessage `json:"items"`
+	}{}
+
+	err := json.Unmarshal(rec.Body.Bytes(), &list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	start := 0
+
+	if cont := r.URL.Query().Get("continue"); cont != "" {
+		start, err = strconv.Atoi(cont)
+		if err != nil || start < 0 || start > len(list.Items) {
+			http.Error(w, "invalid continue token "+cont, http.StatusGone)
+
+			return
+		}
+	}
+
+	end := start + is.pageSize
+	if end < len(list.Items) {
+		list.Metadata.Continue = strconv.Itoa(end)
+	} else {
+		end = len(list.Items)
+	}
+
+	list.Items = list.Items[start:end]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// integrationKubeconfig is the kubeconfig of the integration API server at
+// the URL it's formatted with.
+const integrationKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: integration
+  cluster:
+    server: %s
+users:
+- name: kubecap
+  user:
+    token: integration
+contexts:
+- name: integration
+  context:
+    cluster: integration
+    user: kubecap
+current-context: integration
+`
+
+// collectedTime matches the time a report was collected, which differs
+// between runs.
+var collectedTime = regexp.MustCompile(`(Collected: |"timestamp":")[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9:.]+Z`)
+
+func TestIntegration(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join(*fixtures, "*", "cases.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dirs) == 0 {
+		t.Fatalf("no fixtures in %s", *fixtures)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range dirs {
+		dir := filepath.Dir(path)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ic := &integrationCases{}
+
+		err = yaml.UnmarshalStrict(data, ic)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		f, err := os.Open(filepath.Join(dir, "cluster.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sim, err := loadSimulation(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", dir, err)
+		}
+
+		for _, c := range ic.Cases {
+			c := c
+
+			t.Run(filepath.Base(dir)+"/"+c.Name, func(t *testing.T) {
+				is := &integrationServer{
+					sim:       sim,
+					pageSize:  c.PageSize,
+					forbidden: map[string]bool{},
+				}
+
+				if is.pageSize == 0 {
+					is.pageSize = defaultPageSize
+				}
+
+				for _, resource := range c.Forbidden {
+					is.forbidden[resource] = true
+				}
+
+				srv := httptest.NewServer(is)
+				defer srv.Close()
+
+				kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+
+				err := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(integrationKubeconfig, srv.URL)), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				stdout := &bytes.Buffer{}
+				stderr := &bytes.Buffer{}
+
+				cmd := exec.Command(self, append([]string{"--kubeconfig", kubeconfig}, c.Args...)...)
+				cmd.Dir = dir
+				cmd.Env = append(os.Environ(), "KUBECAP_INTEGRATION_MAIN=1")
+				cmd.Stdout = stdout
+				cmd.Stderr = stderr
+
+				code := 0
+
+				err = cmd.Run()
+				if ee := (&exec.ExitError{}); errors.As(err, &ee) {
+					code = ee.ExitCode()
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				if code != c.ExitCode {
+					t.Fatalf("exit code = %d, want %d\n%s", code, c.ExitCode, stderr)
+				}
+
+				if !strings.Contains(stderr.String(), c.Stderr) {
+					t.Errorf("standard error doesn't contain %q:\n%s", c.Stderr, stderr)
+				}
+
+				if len(is.unpaged) > 0 {
+					t.Errorf("listed without a limit: %v", is.unpaged)
+				}
+
+				got := collectedTime.ReplaceAll(stdout.Bytes(), []byte("${1}TIMESTAMP"))
+				golden := filepath.Join(dir, "golden", c.Name+".txt")
+
+				if *update {
+					err = os.MkdirAll(filepath.Dir(golden), 0755)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					err = os.WriteFile(golden, got, 0644)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					return
+				}
+
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v (run with -update to create it)", err)
+				}
+
+				if !bytes.Equal(got, want) {
+					t.Errorf("output differs from %s (run with -update to accept it):\n%s", golden, got)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+// jiraOutput opens a Jira issue for each node group that stays in breach for
+// longer than after, keeps its description up to date while the breach
+// continues and closes it once the group recovers. It is meant to be reused
+// across runs in watch mode.
+type jiraOutput struct {
+	url       string
+	user      string
+	token     string
+	project   string
+	issueType string
+	after     time.Duration
+
+	client  *http.Client
+	tracker *kubecap.BreachTracker
+
+	// issues maps node group labels to their open issue keys. It is loaded
+	// from Jira on the first run so restarts pick up existing issues.
+	issues map[string]string
+
+	md    *kubecap.Metadata
+	nodes []*kubecap.NodeReport
+}
+
+func newJIRAOutput(url, user, token, project, issueType string, min int64, after time.Duration) *jiraOutput {
+	return &jiraOutput{
+		url:       strings.TrimSuffix(url, "/"),
+		user:      user,
+		token:     token,
+		project:   project,
+		issueType: issueType,
+		after:     after,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		tracker:   kubecap.NewBreachTracker(min),
+	}
+}
+
+func (j *jiraOutput) Metadata(m *kubecap.Metadata) error {
+	j.md = m
+	j.nodes = nil
+
+	return nil
+}
+
+func (j *jiraOutput) Node(n *kubecap.NodeReport) error {
+	j.nodes = append(j.nodes, n)
+
+	return nil
+}
+
+func (j *jiraOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+// jiraGroupLabel is the label marking the issue for a node group.
+func jiraGroupLabel(group string) string {
+	return "kubecap-group-" + strings.Join(strings.Fields(group), "_")
+}
+
+func (j *jiraOutput) Flush() error {
+	ctx := context.Background()
+
+	if j.issues == nil {
+		err := j.loadIssues(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := j.md.Timestamp
+	groups := kubecap.SummarizeGroups(j.nodes)
+	breaches := j.tracker.Update(groups, now)
+
+	for _, g := range groups {
+		since := breaches[g.Group]
+		label := jiraGroupLabel(g.Group)
+		key, open := j.issues[label]
+
+		if since.IsZero() {
+			if open {
+				err := j.resolve(ctx, key, fmt.Sprintf(
+					"Node group %s recovered at %s with %s schedulable %s.",
+					g.Group, j.md.Timestamp.Format(time.RFC3339), humanAmount(j.md, g.Schedulable), j.md.ResourceName(),
+				))
+				if err != nil {
+					return err
+				}
+
+				delete(j.issues, label)
+			}
+
+			continue
+		}
+
+		if now.Sub(since) < j.after {
+			continue
+		}
+
+		description := j.description(g, since)
+
+		if open {
+			err := j.do(ctx, http.MethodPut, "/rest/api/2/issue/"+key, map[string]interface{}{
+				"fields": map[string]interface{}{
+					"description": description,
+				},
+			}, nil)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		created := struct {
+			Key string `json:"key"`
+		}{}
+
+		err := j.do(ctx, http.MethodPost, "/rest/api/2/issue", map[string]interface{}{
+			"fields": map[string]interface{}{
+				"project":     map[string]string{"key": j.project},
+				"issuetype":   map[string]string{"name": j.issueType},
+				"summary":     fmt.Sprintf("Node group %s in %s is low on schedulable %s", g.Group, j.md.Context, j.md.ResourceName()),
+				"description": description,
+				"labels":      []string{"kubecap", label},
+			},
+		}, &created)
+		if err != nil {
+			return err
+		}
+
+		j.issues[label] = created.Key
+	}
+
+	// Node groups that no longer exist (deleted or renamed pools) can't
+	// recover so their issues are resolved.
+	current := map[string]bool{}
+	for _, g := range groups {
+		current[jiraGroupLabel(g.Group)] = true
+	}
+
+	for label, key := range j.issues {
+		if current[label] {
+			continue
+		}
+
+		err := j.resolve(ctx, key, fmt.Sprintf(
+			"Node group %s no longer exists as of %s.",
+			strings.TrimPrefix(label, "kubecap-group-"), j.md.Timestamp.Format(time.RFC3339),
+		))
+		if err != nil {
+			return err
+		}
+
+		delete(j.issues, label)
+	}
+
+	return nil
+}
+
+func (j *jiraOutput) description(g kubecap.GroupReport, since time.Time) string {
+	return fmt.Sprintf(
+		"Node group %s in %s has had less than %s schedulable %s since %s.\n\n"+
+			"Nodes: %d (%d with room for %s)\n"+
+			"Allocatable: %s\n"+
+			"Requests: %s\n"+
+			"Used: %s\n"+
+			"Schedulable: %s\n\n"+
+			"Last updated %s by kubecap.",
+		g.Group, j.md.Context, humanAmount(j.md, j.tracker.Min), j.md.ResourceName(), since.Format(time.RFC3339),
+		g.Nodes, g.OkNodes, j.md.AdditionalInput,
+		humanAmount(j.md, g.Allocatable),
+		humanAmount(j.md, g.Requests),
+		humanAmount(j.md, g.Used),
+		humanAmount(j.md, g.Schedulable),
+		j.md.Timestamp.Format(time.RFC3339),
+	)
+}
+
+// loadIssues finds the open kubecap issues in the project.
+func (j *jiraOutput) loadIssues(ctx context.Context) error {
+	jql := fmt.Sprintf("project = %q AND labels = kubecap AND statusCategory != Done", j.project)
+
+	result := struct {
+		Issues []struct {
+			Key    string `json:"key"`
+			Fields struct {
+				Labels []string `json:"labels"`
+			} `json:"fields"`
+		} `json:"issues"`
+	}{}
+
+	err := j.do(ctx, http.MethodGet, "/rest/api/2/search?fields=labels&maxResults=1000&jql="+url.QueryEscape(jql), nil, &result)
+	if err != nil {
+		return err
+	}
+
+	j.issues = map[string]string{}
+
+	for _, issue := range result.Issues {
+		for _, label := range issue.Fields.Labels {
+			if strings.HasPrefix(label, "kubecap-group-") {
+				j.issues[label] = issue.Key
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolve comments on the issue and moves it to a done status.
+func (j *jiraOutput) resolve(ctx context.Context, key, comment string) error {
+	err := j.do(ctx, http.MethodPost, "/rest/api/2/issue/"+key+"/comment", map[string]string{
+		"body": comment,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	transitions := struct {
+		Transitions []struct {
+			ID string `json:"id"`
+			To struct {
+				StatusCategory struct {
+					Key string `json:"key"`
+				} `json:"statusCategory"`
+			} `json:"to"`
+		} `json:"transitions"`
+	}{}
+
+	err = j.do(ctx, http.MethodGet, "/rest/api/2/issue/"+key+"/transitions", nil, &transitions)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range transitions.Transitions {
+		if t.To.StatusCategory.Key != "done" {
+			continue
+		}
+
+		return j.do(ctx, http.MethodPost, "/rest/api/2/issue/"+key+"/transitions", map[string]interface{}{
+			"transition": map[string]string{"id": t.ID},
+		}, nil)
+	}
+
+	return fmt.Errorf("jira issue %s has no transition to a done status", key)
+}
+
+// do calls the Jira REST API, decoding the response into result if given.
+func (j *jiraOutput) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, j.url+path, r)
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(j.user, j.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("jira %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
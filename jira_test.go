@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestJIRAOutput(t *testing.T) {
+	// requests are the method and path of each request and bodies the
+	// JSON body of each by them.
+	requests := []string{}
+	bodies := map[string]map[string]interface{}{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		user, token, ok := r.BasicAuth()
+		if !ok || user != "kubecap@example.com" || token != "secret" {
+			t.Errorf("%s %s: basic auth = %q, %q", r.Method, r.URL, user, token)
+		}
+
+		call := r.Method + " " + r.URL.Path
+		requests = append(requests, call)
+
+		if r.Body != nil && r.Method != http.MethodGet {
+			body := map[string]interface{}{}
+
+			err := json.NewDecoder(r.Body).Decode(&body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bodies[call] = body
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+
+		switch call {
+		case "GET /rest/api/2/search":
+			if jql := r.URL.Query().Get("jql"); jql != `project = "OPS" AND labels = kubecap AND statusCategory != Done` {
+				t.Errorf("jql = %q", jql)
+			}
+
+			// db has since recovered and the old pool is gone.
+			rw.Write([]byte(`{"issues": [
+				{"key": "OPS-1", "fields": {"labels": ["kubecap", "kubecap-group-db"]}},
+				{"key": "OPS-2", "fields": {"labels": ["kubecap", "kubecap-group-old_pool"]}}
+			]}`))
+		case "POST /rest/api/2/issue":
+			rw.Write([]byte(`{"key": "OPS-3"}`))
+		case "GET /rest/api/2/issue/OPS-1/transitions", "GET /rest/api/2/issue/OPS-2/transitions", "GET /rest/api/2/issue/OPS-3/transitions":
+			rw.Write([]byte(`{"transitions": [
+				{"id": "11", "to": {"statusCategory": {"key": "indeterminate"}}},
+				{"id": "31", "to": {"statusCategory": {"key": "done"}}}
+			]}`))
+		default:
+			rw.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	j := newJIRAOutput(srv.URL+"/", "kubecap@example.com", "secret", "OPS", "Task", 2<<30, 10*time.Minute)
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	flush := func(at time.Time, webSchedulable int64) []string {
+		t.Helper()
+
+		requests = requests[:0]
+
+		err := j.Metadata(&kubecap.Metadata{Context: "prod", Timestamp: at, AdditionalInput: "1GiB"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, n := range []*kubecap.NodeReport{
+			{Name: "web-1", Group: "web", Allocatable: 8 << 30, Schedulable: webSchedulable},
+			{Name: "db-1", Group: "db", Allocatable: 8 << 30, Schedulable: 4 << 30, Ok: true},
+		} {
+			err = j.Node(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = j.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return append([]string{}, requests...)
+	}
+
+	// The first run loads the open issues and resolves those of groups
+	// that recovered or no longer exist. web's breach is too recent for an
+	// issue.
+	got := flush(ts, 1<<30)
+	want := []string{
+		"GET /rest/api/2/search",
+		"POST /rest/api/2/issue/OPS-1/comment",
+		"GET /rest/api/2/issue/OPS-1/transitions",
+		"POST /rest/api/2/issue/OPS-1/transitions",
+		"POST /rest/api/2/issue/OPS-2/comment",
+		"GET /rest/api/2/issue/OPS-2/transitions",
+		"POST /rest/api/2/issue/OPS-2/transitions",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("first run = %q, want %q", got, want)
+	}
+
+	if c := bodies["POST /rest/api/2/issue/OPS-2/comment"]["body"]; c != "Node group old_pool no longer exists as of 2024-05-01T12:00:00Z." {
+		t.Errorf("old pool comment = %q", c)
+	}
+
+	if tr := bodies["POST /rest/api/2/issue/OPS-2/transitions"]["transition"]; !reflect.DeepEqual(tr, map[string]interface{}{"id": "31"}) {
+		t.Errorf("transition = %v, want to done (31)", tr)
+	}
+
+	// Past the wait, web's issue is created.
+	got = flush(ts.Add(15*time.Minute), 1<<30)
+	if want := []string{"POST /rest/api/2/issue"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("second run = %q, want %q", got, want)
+	}
+
+	fields := bodies["POST /rest/api/2/issue"]["fields"].(map[string]interface{})
+
+	if fields["summary"] != "Node group web in prod is low on schedulable memory" ||
+		!reflect.DeepEqual(fields["project"], map[string]interface{}{"key": "OPS"}) ||
+		!reflect.DeepEqual(fields["issuetype"], map[string]interface{}{"name": "Task"}) ||
+		!reflect.DeepEqual(fields["labels"], []interface{}{"kubecap", "kubecap-group-web"}) {
+		t.Errorf("created fields = %v", fields)
+	}
+
+	if d := fields["description"].(string); !strings.HasPrefix(d, "Node group web in prod has had less than 2.0 GiB schedulable memory since 2024-05-01T12:00:00Z.") {
+		t.Errorf("description = %q", d)
+	}
+
+	// While the breach lasts the description is kept up to date.
+	got = flush(ts.Add(20*time.Minute), 1<<30)
+	if want := []string{"PUT /rest/api/2/issue/OPS-3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("third run = %q, want %q", got, want)
+	}
+
+	if d := bodies["PUT /rest/api/2/issue/OPS-3"]["fields"].(map[string]interface{})["description"].(string); !strings.HasSuffix(d, "Last updated 2024-05-01T12:20:00Z by kubecap.") {
+		t.Errorf("updated description = %q", d)
+	}
+
+	// Once web recovers its issue is resolved.
+	got = flush(ts.Add(25*time.Minute), 4<<30)
+	want = []string{
+		"POST /rest/api/2/issue/OPS-3/comment",
+		"GET /rest/api/2/issue/OPS-3/transitions",
+		"POST /rest/api/2/issue/OPS-3/transitions",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("fourth run = %q, want %q", got, want)
+	}
+
+	if c := bodies["POST /rest/api/2/issue/OPS-3/comment"]["body"]; c != "Node group web recovered at 2024-05-01T12:25:00Z with 4.0 GiB schedulable memory." {
+		t.Errorf("recovery comment = %q", c)
+	}
+}
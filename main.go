@@ -2,237 +2,1106 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
-	"path/filepath"
-	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/dustin/go-humanize"
-	"github.com/olekukonko/tablewriter"
+	"github.com/calebcase/kubecap/pkg/kubecap"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
 
 	// Ensure the OIDC provider is loaded.
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 )
 
-type NodePods map[string][]*corev1.Pod
+func main() {
+	// simulationPath is the cluster description reported on instead of a
+	// real cluster by the simulate subcommand, which otherwise takes the
+	// report's flags.
+	simulationPath := ""
+
+	// serve is set by the serve subcommand, which is watch mode serving the
+	// latest report (on :9090 and every minute unless given).
+	serve := false
 
-func NewNodePods(podList *corev1.PodList) NodePods {
-	nps := NodePods{}
+	if len(os.Args) >= 2 {
+		switch os.Args[1] {
+		case "simulate":
+			if len(os.Args) < 3 {
+				panic("usage: kubecap simulate FILE [flags] [amount]")
+			}
 
-	for _, pod := range podList.Items {
-		nps.add(&pod)
+			simulationPath = os.Args[2]
+			os.Args = append(os.Args[:1:1], os.Args[3:]...)
+		case "serve":
+			serve = true
+			os.Args = append(os.Args[:1:1], os.Args[2:]...)
+		case "chart":
+			chartMain(os.Args[2:])
+			return
+		case "what-if":
+			whatIfMain(os.Args[2:])
+			return
+		case "fit":
+			fitMain(os.Args[2:])
+			return
+		case "check":
+			checkMain(os.Args[2:])
+			return
+		case "config":
+			configMain(os.Args[2:])
+			return
+		case "diff":
+			diffMain(os.Args[2:])
+			return
+		case "upgrade":
+			upgradeMain(os.Args[2:])
+			return
+		case "failover":
+			failoverMain(os.Args[2:])
+			return
+		case "growth":
+			growthMain(os.Args[2:])
+			return
+		case "heat":
+			heatMain(os.Args[2:])
+			return
+		case "fit-odds":
+			fitOddsMain(os.Args[2:])
+			return
+		case "slo":
+			sloMain(os.Args[2:])
+			return
+		case "install":
+			installMain(os.Args[2:])
+			return
+		case "balloon":
+			balloonMain(os.Args[2:])
+			return
+		case "namespace":
+			namespaceMain(os.Args[2:])
+			return
+		}
 	}
 
-	return nps
-}
+	output := flag.String("o", "table", "output format: table, wide (table with the nodes' kubelet version, age and instance type), json, yaml, jsonl, csv, html, dot or xlsx")
+	flag.StringVar(output, "output", *output, "alias of -o")
+	annotateNodes := flag.Bool("annotate-nodes", false, "write each node's schedulable amount and verdict back onto it as the kubecap.io/schedulable-<resource> and kubecap.io/ok annotations (on every report with --watch)")
+	var execSinks stringList
+	flag.Var(&execSinks, "exec-sink", "sink run as an external command (split on spaces) after each report, given the report as a JSON document (as -o json) on stdin (repeatable)")
+	publishReports := flag.Bool("publish-reports", false, "publish the report as a NodeCapacityReport per node and a ClusterCapacityReport (kubecap.io/v1alpha1 custom resources, see kubecap install --publish-reports)")
+	maxAPICalls := flag.Int64("max-api-calls", 0, "API calls a report may make before skipping its optional analyses (enrichers, GPU usage, cordons, pod churn, kubelet configuration and stats, evictable containers) rather than exceed them (0 for no limit)")
+	maxRuntime := flag.Duration("max-runtime", 0, "how long a report may run before skipping its optional analyses rather than run longer (0 for no limit)")
+	pageSize := flag.Int("page-size", 0, "with table output to a terminal, show the node report this many nodes at a time, prompting for the next page (0 shows them all)")
+	outputFile := flag.String("output-file", "", "write the report to this path (atomically) instead of stdout")
+	clusterCol := flag.Bool("cluster-column", false, "include the cluster (kubeconfig context) as a column in table output")
+	nodeGroupLabel := flag.String("node-group-label", "", "node label identifying node groups (default: well-known cloud provider node pool labels)")
+	var taintPools stringList
+	flag.Var(&taintPools, "taint-pool", "taint key marking dedicated pools (e.g. dedicated), whose nodes are totalled per key=value:effect since only tolerating workloads can use their capacity (repeatable)")
+	var columns stringList
+	verdictRulesFile := flag.String("verdict-rules", "", "YAML rules (node, cluster) replacing the built-in Ok? verdict of each node and deciding whether the cluster has sufficient headroom, as expressions over their fields, e.g. 'node: ok && pressure < 80'")
+	flag.Var(&columns, "column", "add a column computed for each node as NAME=EXPR, an arithmetic expression over the node's fields, e.g. 'buffer=free-requests*0.1' (repeatable)")
+	sortBy := flag.String("sort", "name", "order nodes by: name or pressure (highest first)")
+	resourceStr := flag.String("resource", "memory", "resource to report on: memory, cpu (amounts in millicores) or ephemeral-storage (usage from each kubelet's stats summary)")
+	usageSource := flag.String("usage-source", "metrics-api", "where to read usage from: metrics-api or cadvisor (scrape each kubelet's /metrics/cadvisor through the API server for RSS, cache and mapped file too)")
+	numa := flag.Bool("numa", false, "report memory per NUMA node for nodes using the kubelet Memory Manager's Static policy (reads each kubelet's configz and NodeResourceTopology)")
+	cpuManager := flag.Bool("cpu-manager", false, "report exclusive CPUs pinned by Guaranteed pods and the shared pool left on nodes using the static CPU Manager policy (reads each kubelet's configz)")
+	psi := flag.Bool("psi", false, "add memory and CPU pressure stall (PSI) columns from each kubelet's stats summary, where exposed")
+	burstableOnly := flag.Bool("burstable-only", false, "only consider Burstable pods as eviction candidates, in kubelet eviction order")
+	evict := flag.Bool("evict", false, "evict, through the Eviction API, the pods over their requests needed to make room for the additional amount on a node: lowest priority and largest over their requests first, skipping kube-system and DaemonSet pods and respecting PodDisruptionBudgets")
+	dryRun := flag.Bool("dry-run", true, "with --evict, only submit the evictions as server-side dry runs; set --dry-run=false to evict")
+	evictionPolicyFile := flag.String("eviction-policy", "", "YAML eviction policy (namespaces, selectors, ownerKinds) of pods never to suggest or, with --evict, evict")
+	strategy := flag.String("strategy", kubecap.DefaultEvictionStrategy, "with --evict, how pods are ranked for eviction: "+strings.Join(kubecap.EvictionStrategies(), ", "))
+	includeBestEffort := flag.Bool("include-besteffort", false, "with --burstable-only, also consider BestEffort pods")
+	checkReserved := flag.Bool("check-reserved", false, "compare each node's kube-reserved and system-reserved memory with the system's actual usage (reads each kubelet's configz and stats summary)")
+	dra := flag.Bool("dra", false, "report Dynamic Resource Allocation (resource.k8s.io) devices per node")
+	additionalDevicesStr := flag.String("additional-devices", "", "with --dra, also check each node has these free devices: DeviceClass=count[,...]")
+	volumeAttach := flag.Bool("volume-attach", false, "report each node's CSI volume attachments against its attach limit per driver (e.g. EBS's per-instance limit)")
+	var execEnrichers stringList
+	flag.Var(&execEnrichers, "enrich-exec", "node enricher run as an external command (split on spaces), given the nodes as a JSON array on stdin and writing a JSON object of attributes by node name on stdout (repeatable)")
+	enrich := flag.String("enrich", "", "comma separated node enrichers adding provider attributes (lifecycle, region, instance and pod ENI limit) to the nodes: "+strings.Join(kubecap.NodeEnrichers(), ", "))
+	priceFile := flag.String("price-file", "", "YAML prices by instance type (onDemand and spot per hour, and the number of nodes commitments cover), adding each node's hourlyPrice and committed attributes")
+	var sloSpecs stringList
+	flag.Var(&sloSpecs, "slo", "capacity SLO as [BY:]MIN%@TARGET%, e.g. zone:15%@99% for at least 15% schedulable in every zone 99% of the time; BY is cluster (default), zone or nodegroup (repeatable)")
+	sloWindow := flag.Duration("slo-window", 30*24*time.Hour, "with --watch, track each --slo's compliance and error budget over this window")
+	allocatableChanges := flag.Duration("allocatable-changes", 24*time.Hour, "with --watch, log the changes of the nodes' allocatable and capacity amounts (e.g. kubelet reconfiguration) and report those within this long (0 disables)")
+	cordons := flag.Bool("cordons", false, "report the cordoned nodes, how long they have been cordoned (from node events) and the capacity they hold")
+	gpuMetricsURL := flag.String("gpu-metrics-url", "", "join GPU utilization (DCGM_FI_DEV_GPU_UTIL) with GPU requests per node and pod, read from a DCGM exporter when the URL ends in /metrics and by querying the Prometheus at the URL otherwise; with --leaderboard also rank the workloads wasting the most GPUs")
+	gpuResource := flag.String("gpu-resource", kubecap.DefaultGPUResource, "extended resource GPUs are requested as, with --gpu-metrics-url")
+	kubeconfig, kcontext := clusterFlags(flag.CommandLine)
+	contexts := flag.String("contexts", "", "report on each of these comma separated kubeconfig contexts, collected concurrently, followed by a summary of each cluster and of them combined")
+	allContexts := flag.Bool("all-contexts", false, "report on every kubeconfig context, as with --contexts")
+	var namespaces stringList
+	flag.Var(&namespaces, "namespace", "only consider pods in this namespace as eviction candidates (repeatable); with a single namespace and --resource memory, also check the additional amount against its ResourceQuotas and report whether node capacity, quota or both block it")
+	selector := flag.String("selector", "", "only consider pods matching this label selector as eviction candidates")
+	nodeSelector := flag.String("node-selector", "", "only report on nodes matching this label selector")
+	nodeOlderThan := flag.String("node-older-than", "", "only report on nodes created longer ago than this, e.g. 90d or 36h")
+	kubeletVersion := flag.String("kubelet-version", "", "only report on nodes whose kubelet version satisfies these comma separated constraints, e.g. '<1.29' or '>=1.27,<1.29'")
+	in := flag.String("in", "", "only check the additional amount fits on nodes matching KEY=VALUE[,KEY=VALUE...], where zone and nodegroup are shorthands for the zone label and node group (e.g. zone=us-east-1a)")
+	priorityClasses := flag.Bool("priority-classes", false, "break each node's and the cluster's requests down by PriorityClass")
+	autoscalerStatus := flag.Bool("autoscaler-status", false, "show each Cluster Autoscaler node group's min, max and current size alongside its headroom, flagging groups at their maximum with no room left")
+	autoscalerStatusConfigMap := flag.String("autoscaler-status-configmap", "kube-system/cluster-autoscaler-status", "Cluster Autoscaler status ConfigMap (namespace/name) read with --autoscaler-status")
+	schedulerSimulator := flag.String("scheduler-simulator-kubeconfig", "", "delegate whether each node fits the additional amount to the kube-scheduler of the kube-scheduler-simulator this kubeconfig points at (synced with the cluster)")
+	schedulerPriorityClass := flag.String("scheduler-priority-class", "", "PriorityClass of the pod probing the kube-scheduler-simulator, e.g. to see whether it would preempt")
+	schedulerTimeout := flag.Duration("scheduler-timeout", 30*time.Second, "how long to wait for the kube-scheduler-simulator to schedule the probe pod")
+	leaderboard := flag.Int("leaderboard", 0, "also report this many of the most over-provisioned pods and workloads (most memory requested but not used) with their efficiency")
+	includeNotReady := flag.Bool("include-not-ready", false, "count NotReady nodes towards the schedulable totals and let them pass the headroom check")
+	listUnmatched := flag.Bool("list-unmatched", false, "list the running pods without pod metrics (e.g. right after restarting) rather than only count them per node")
+	costCenterFile := flag.String("cost-centers", "", "YAML file mapping pods to cost centers by label selector and namespace, adding a cost center dimension to the aggregated outputs")
+	thresholdProfilesFile := flag.String("threshold-profiles", "", "YAML file of threshold profiles requiring the nodes matching their node selector to keep a share of their allocatable amount free, applied to the Ok verdict and node group alerts instead of the global minimum")
+	rightSize := flag.String("right-size", "", "project each node group's pods onto nodes of these comma separated sizes (allocatable amounts, e.g. 16Gi,32Gi,64Gi), reporting the node count, waste and packing of each")
+	var standbySpecs stringList
+	flag.Var(&standbySpecs, "standby", "warm standby capacity required for a priority tier as CLASS:REPLICASxAMOUNT, e.g. critical:10x2Gi for room for 10 pods of the critical PriorityClass requesting 2Gi each at all times, preempting lower priority pods as the scheduler would (repeatable)")
+	reservationsFile := flag.String("reservations", "", "YAML file of capacity reservations (name, group, zone, requests per replica, replicas, until) for workloads planned but not deployed yet, taken from the nodes' schedulable amounts")
+	var reportFiles stringList
+	flag.Var(&reportFiles, "report", "add the custom reports defined in a YAML file: rows of the nodes or pods filtered, grouped and summarized into columns by Go templates (repeatable)")
+	shapes := flag.Bool("shapes", false, "report a histogram of pods by memory requests per node group, to help choose instance sizes and spot pod shapes causing fragmentation")
+	pendingWeight := flag.Float64("pending-weight", 1, "fraction of the requests of pods bound to a node but not yet running counted towards its requests")
+	terminatingWeight := flag.Float64("terminating-weight", 1, "fraction of the requests of terminating pods counted towards their node's requests")
+	podChurn := flag.Duration("pod-churn", 0, "count the containers each node's kubelet started and stopped over this window (e.g. 15m) from its events, as the node's pod churn")
+	stuckAfter := flag.Duration("stuck-terminating-after", 5*time.Minute, "flag pods still terminating this long past their grace period")
+	kueue := flag.Bool("kueue", false, "report the memory requested by each Kueue ClusterQueue's pending Workloads against its nominal quota and the cluster's schedulable memory")
+	jobBacklog := flag.String("job-backlog", "", "report the requests of the queued (suspended or not yet started) Jobs matching this label selector and estimate how long the cluster's schedulable headroom takes to run them from the average runtime of those completed")
+	runs := flag.Int("runs", 1, "collect this many reports --run-interval apart and render the last, e.g. to see with --spread how usage varies")
+	runInterval := flag.Duration("run-interval", 2*time.Minute, "time between the reports collected with --runs")
+	spread := flag.Bool("spread", false, "with --runs, follow table output with each node's minimum, mean, maximum and standard deviation of used and free across the runs")
+	watch := flag.Duration("watch", 0, "keep running, reporting at this interval (daemon mode)")
+	watchJitter := flag.Float64("watch-jitter", 0, "with --watch, wait a random delay of up to this fraction of the interval before the first report and vary each interval by as much (e.g. 0.2), so that many instances don't load the API servers at once")
+	podInterval := flag.Duration("pod-interval", 0, "with --watch, list the pods and their metrics only this often, reusing them for the reports in between (e.g. --watch 30s --pod-interval 2m)")
+	listen := flag.String("listen", "", "with --watch, serve the latest report's metrics in the Prometheus text format at /metrics and the report as JSON at /report on this address, e.g. :9090")
+	whatifWorkers := flag.Int("whatif-workers", 1, "with --listen, run the what-if scenarios POSTed to /whatif on this many workers (0 disables /whatif)")
+	whatifQueueDepth := flag.Int("whatif-queue", 16, "with --listen, how many what-if scenarios may wait for a worker before /whatif refuses more")
+	eventHeadroom := flag.String("event-headroom", "", "with --listen, comma separated amounts of the resource (e.g. 64GiB,32GiB) for which an event is streamed at /events whenever the cluster's schedulable amount crosses one")
+	usageSmoothing := flag.Float64("usage-smoothing", 0, "with --watch, smooth each node's usage across reports with an exponential moving average giving the newest sample this weight (0 < weight < 1) before alerting")
+	minGroupSchedulableStr := flag.String("min-group-schedulable", "0", "a node group is in breach when its schedulable amount of the resource is below this")
+	sheetsID := flag.String("sheets-spreadsheet", "", "append a summary row for the run to this Google Sheet")
+	sheetsRange := flag.String("sheets-range", "Sheet1", "sheet (range) in the Google Sheet to append to")
+	sheetsCredentials := flag.String("sheets-credentials", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), "service account key file for the Google Sheet")
+	jiraURL := flag.String("jira-url", "", "open Jira issues in this Jira instance for node groups in sustained breach (watch mode)")
+	jiraProject := flag.String("jira-project", "", "Jira project key to open issues in")
+	jiraIssueType := flag.String("jira-issue-type", "Task", "Jira issue type to open")
+	jiraUser := flag.String("jira-user", os.Getenv("JIRA_USER"), "Jira user (the API token is read from $JIRA_API_TOKEN)")
+	jiraAfter := flag.Duration("jira-after", 15*time.Minute, "how long a node group must stay in breach before an issue is opened")
+	pagerDutyRoutingKey := flag.String("pagerduty-routing-key", os.Getenv("PAGERDUTY_ROUTING_KEY"), "trigger PagerDuty incidents for node groups in breach using this Events API v2 routing key")
+	minClusterSchedulableStr := flag.String("min-cluster-schedulable", "0", "also alert when the cluster's total schedulable amount of the resource is below this")
+	alertmanagerURL := flag.String("alertmanager-url", "", "post alerts for node groups in breach to this Alertmanager")
+	alertmanagerTTL := flag.Duration("alertmanager-ttl", 15*time.Minute, "how long posted alerts stay firing unless re-posted; keep it above the watch interval")
+	remoteWriteURL := flag.String("remote-write-url", "", "send the report's metrics to this Prometheus remote-write endpoint")
+	roundStr := flag.String("round", "0", "round the reported and exported amounts to the nearest multiple of this (e.g. 256Mi) and drop pod-level detail (pods, evictable containers, leaderboards), for reports shared outside the platform team")
+	metricLabels := flag.String("metric-labels", "", "comma separated labels to keep on the exported metrics (--listen and --remote-write-url), e.g. cluster,node_group to bound their cardinality; series left the same are combined, amounts and counts summed and other gauges averaged (default all)")
+	remoteWriteBearerTokenFile := flag.String("remote-write-bearer-token-file", "", "file containing a bearer token for the remote-write endpoint")
+	influxURL := flag.String("influx-url", "", "write the report's metrics to this InfluxDB (v2 write API; the token is read from $INFLUX_TOKEN)")
+	influxOrg := flag.String("influx-org", "", "InfluxDB organization to write to")
+	influxBucket := flag.String("influx-bucket", "kubecap", "InfluxDB bucket to write to")
+	influxFile := flag.String("influx-file", "", "append the report's metrics as InfluxDB line protocol to this file")
+	postgresDSN := flag.String("postgres-dsn", os.Getenv("KUBECAP_POSTGRES_DSN"), "insert per-node and per-namespace rows for each run into this PostgreSQL/TimescaleDB database")
+	postgresRawRetention := flag.Duration("postgres-raw-retention", 7*24*time.Hour, "roll rows older than this up into hourly rows (0 keeps them forever)")
+	postgresHourlyRetention := flag.Duration("postgres-hourly-retention", 90*24*time.Hour, "delete hourly rows older than this (0 keeps them forever)")
+	reportSchedule := flag.String("report-schedule", "", "also generate the full report on this cron schedule (e.g. \"0 8 * * 1\") and send it to the report sinks below")
+	reportEmailTo := flag.String("report-email-to", "", "mail scheduled reports to these comma separated addresses")
+	reportEmailFrom := flag.String("report-email-from", "kubecap@localhost", "sender of scheduled report mail")
+	smtpAddr := flag.String("smtp-addr", "localhost:25", "SMTP server (host:port) to send scheduled report mail through ($SMTP_USERNAME and $SMTP_PASSWORD authenticate)")
+	reportSlackWebhook := flag.String("report-slack-webhook", os.Getenv("SLACK_WEBHOOK_URL"), "post scheduled reports to this Slack incoming webhook")
+	reportS3URL := flag.String("report-s3-url", "", "upload scheduled reports to s3://bucket/prefix (credentials from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN)")
+	reportS3Endpoint := flag.String("report-s3-endpoint", "", "S3 compatible endpoint to upload to instead of AWS, e.g. https://minio.example.com")
+	otlpURL := flag.String("otlp-endpoint", "", "export traces of each run to this OTLP/HTTP endpoint, e.g. http://localhost:4318 (default: $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flag.Parse()
 
-func (nps NodePods) add(p *corev1.Pod) {
-	if p.Spec.NodeName == "" {
-		return
+	if serve {
+		if *listen == "" {
+			*listen = ":9090"
+		}
+
+		if *watch == 0 {
+			*watch = time.Minute
+		}
+	}
+
+	shutdownTracing := setupTracing(*otlpURL)
+	defer shutdownTracing(context.Background())
+
+	rn := corev1.ResourceName(*resourceStr)
+
+	err := kubecap.CheckResource(rn)
+	if err != nil {
+		panic(err.Error())
 	}
 
-	var pods []*corev1.Pod
-	var ok bool
+	if rn != corev1.ResourceMemory {
+		switch {
+		case *kueue:
+			panic("--kueue requires --resource memory")
+		case *shapes:
+			panic("--shapes requires --resource memory")
+		case *schedulerSimulator != "":
+			panic("--scheduler-simulator-kubeconfig requires --resource memory")
+		case *usageSource == "cadvisor":
+			panic("--usage-source cadvisor requires --resource memory")
+		}
+	}
 
-	if pods, ok = nps[p.Spec.NodeName]; !ok {
-		pods = []*corev1.Pod{}
+	for _, sel := range []string{*selector, *nodeSelector} {
+		_, err = labels.Parse(sel)
+		if err != nil {
+			panic(err.Error())
+		}
 	}
 
-	pods = append(pods, p.DeepCopy())
-	nps[p.Spec.NodeName] = pods
-}
+	enrichers := []string{}
+	for _, name := range strings.Split(*enrich, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			enrichers = append(enrichers, name)
+		}
+	}
 
-func (nps NodePods) MemoryRequests(nodeName string) (total *resource.Quantity) {
-	total = resource.NewQuantity(0, resource.BinarySI)
+	if *priceFile != "" {
+		prices, err := kubecap.LoadPrices(*priceFile)
+		if err != nil {
+			panic(err.Error())
+		}
 
-	if _, ok := nps[nodeName]; !ok {
-		return total
+		kubecap.RegisterNodeEnricher("prices", kubecap.NewPriceEnricher(prices))
+		enrichers = append(enrichers, "prices")
 	}
 
-	for _, pod := range nps[nodeName] {
-		for _, container := range pod.Spec.Containers {
-			mem := container.Resources.Requests.Memory()
+	for _, spec := range execEnrichers {
+		command := strings.Fields(spec)
+		if len(command) == 0 {
+			panic("--enrich-exec requires a command")
+		}
 
-			if mem != nil {
-				total.Add(*mem)
-			}
+		name := "exec:" + command[0]
+		kubecap.RegisterNodeEnricher(name, kubecap.NewExecEnricher(command))
+		enrichers = append(enrichers, name)
+	}
+
+	for _, name := range enrichers {
+		if _, err := kubecap.NodeEnricherNamed(name); err != nil {
+			panic(err.Error())
 		}
 	}
 
-	return total
-}
+	slos := []*kubecap.SLO{}
+	for _, spec := range sloSpecs {
+		slo, err := kubecap.ParseSLO(spec)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		slos = append(slos, slo)
+	}
+
+	if *in != "" {
+		_, err = kubecap.ParseScope(*in)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
 
-func main() {
 	additionalAmountStr := "0 MiB"
+	if rn == corev1.ResourceCPU {
+		additionalAmountStr = "0"
+	}
 
-	if len(os.Args) >= 2 {
-		additionalAmountStr = os.Args[1]
+	if flag.NArg() >= 1 {
+		additionalAmountStr = flag.Arg(0)
 	}
 
-	additional, err := humanize.ParseBytes(additionalAmountStr)
+	additionalDevices, err := kubecap.ParseAdditionalDevices(*additionalDevicesStr)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
-
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	minGroupSchedulable, err := kubecap.ParseAmount(rn, *minGroupSchedulableStr)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	kcs, err := kubernetes.NewForConfig(config)
+	minClusterSchedulable, err := kubecap.ParseAmount(rn, *minClusterSchedulableStr)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	mcs, err := metricsv.NewForConfig(config)
+	round, err := kubecap.ParseAmount(rn, *roundStr)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	nodeMetricsList, err := mcs.MetricsV1beta1().NodeMetricses().List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
+	if round > 0 && *evict {
+		panic("--round drops the evictable containers --evict needs")
+	}
+
+	eventThresholds := []int64{}
+
+	for _, amount := range strings.Split(*eventHeadroom, ",") {
+		amount = strings.TrimSpace(amount)
+		if amount == "" {
+			continue
+		}
+
+		threshold, err := kubecap.ParseAmount(rn, amount)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		eventThresholds = append(eventThresholds, threshold)
+	}
+
+	var c *cluster
+
+	// clusters are the clusters reported on together with --contexts or
+	// --all-contexts.
+	var clusters []*cluster
+
+	if *contexts != "" || *allContexts {
+		switch {
+		case simulationPath != "":
+			panic("simulate reports on a single cluster")
+		case *kcontext != "":
+			panic("--context can't be combined with --contexts or --all-contexts")
+		case *schedulerSimulator != "", *evict, *annotateNodes, *publishReports, *listen != "":
+			panic("--scheduler-simulator-kubeconfig, --evict, --annotate-nodes, --publish-reports and --listen require a single context")
+		}
+
+		names := strings.Split(*contexts, ",")
+		if *allContexts {
+			names, err = listContexts(*kubeconfig)
+			if err != nil {
+				panic(err.Error())
+			}
+		}
+
+		for _, name := range names {
+			cl, err := newCluster(*kubeconfig, strings.TrimSpace(name))
+			if err != nil {
+				panic(err.Error())
+			}
+
+			clusters = append(clusters, cl)
+		}
+
+		// Rows are told apart by their cluster.
+		*clusterCol = true
+	} else if simulationPath != "" {
+		var closeSimulation func()
+
+		c, closeSimulation, err = loadSimulatedCluster(simulationPath)
+		if err != nil {
+			panic(err.Error())
+		}
+		defer closeSimulation()
+	} else {
+		c, err = newCluster(*kubeconfig, *kcontext)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if *schedulerSimulator != "" {
+		config, err := clientcmd.BuildConfigFromFlags("", *schedulerSimulator)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		c.scheduler, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		c.schedulerPriorityClass = *schedulerPriorityClass
+		c.schedulerTimeout = *schedulerTimeout
+	}
+
+	var jira *jiraOutput
+	if *jiraURL != "" {
+		if *watch == 0 {
+			panic("--jira-url requires --watch")
+		}
+
+		jira = newJIRAOutput(*jiraURL, *jiraUser, os.Getenv("JIRA_API_TOKEN"), *jiraProject, *jiraIssueType, int64(minGroupSchedulable), *jiraAfter)
+	}
+
+	var pagerDuty *pagerDutyOutput
+	if *pagerDutyRoutingKey != "" {
+		pagerDuty = newPagerDutyOutput(*pagerDutyRoutingKey, int64(minGroupSchedulable), int64(minClusterSchedulable))
+	}
+
+	var alertmanager *alertmanagerOutput
+	if *alertmanagerURL != "" {
+		alertmanager = newAlertmanagerOutput(*alertmanagerURL, int64(minGroupSchedulable), int64(minClusterSchedulable), *alertmanagerTTL)
+	}
+
+	var postgres *postgresOutput
+	if *postgresDSN != "" {
+		postgres, err = newPostgresOutput(*postgresDSN, postgresRetention{raw: *postgresRawRetention, hourly: *postgresHourlyRetention})
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if *evict && *watch != 0 {
+		panic("--evict can't be used with --watch")
+	}
+
+	if _, err := kubecap.EvictionStrategyNamed(*strategy); err != nil {
 		panic(err.Error())
 	}
 
-	podMetricsList, err := mcs.MetricsV1beta1().PodMetricses("").List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
+	if err := kubecap.CheckSortBy(*sortBy); err != nil {
 		panic(err.Error())
 	}
 
-	podList, err := kcs.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	if *runs > 1 && (*watch != 0 || clusters != nil || *reportSchedule != "") {
+		panic("--runs can't be used with --watch, --report-schedule, --contexts or --all-contexts")
+	}
+
+	if *spread && *runs < 2 {
+		panic("--spread requires --runs 2 or more")
+	}
+
+	exportLabels := []string{}
+	for _, l := range strings.Split(*metricLabels, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			exportLabels = append(exportLabels, l)
+		}
+	}
+
+	// The series remote written are remembered across reports to mark those
+	// gone since stale.
+	remoteWriteSeries := newExportedSeries()
+
+	var server *reportServer
+
+	if *listen != "" {
+		if *watch == 0 {
+			panic("--listen requires --watch")
+		}
+
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		// Three missed reports in a row make the metrics stale.
+		server = &reportServer{labels: exportLabels, maxAge: 3 * *watch, thresholds: eventThresholds}
+
+		if *whatifQueueDepth < 0 {
+			panic("--whatif-queue must not be negative")
+		}
+
+		if *whatifWorkers > 0 {
+			server.whatif = newWhatifQueue(context.Background(), *whatifWorkers, *whatifQueueDepth, whatifKeep, func(ctx context.Context, s *scenario) (*scenarioReport, error) {
+				return runScenario(ctx, c.kcs, s)
+			})
+		}
+
+		server.fit = func(ctx context.Context, w *fitWorkload) (*fitReport, error) {
+			return fitCluster(ctx, c.kcs, w)
+		}
+
+		go func() {
+			panic(http.Serve(l, server.handler()).Error())
+		}()
+	}
+
+	// The annotations written are remembered across reports so unchanged
+	// nodes aren't patched again.
+	if (*annotateNodes || *publishReports) && simulationPath != "" {
+		panic("--annotate-nodes and --publish-reports write to a live cluster, not a simulation")
+	}
+
+	var annotations *annotateOutput
+	if *annotateNodes {
+		annotations = newAnnotateOutput(context.TODO(), c.kcs)
+	}
+
+	newOut := func(w io.Writer) (kubecap.Output, error) {
+		out, err := newOutput(*output, w, additionalAmountStr, *clusterCol)
+		if err != nil {
+			return nil, err
+		}
+
+		// Paging needs someone at the terminal: output piped or to a
+		// file, and watch mode's repeated reports, are left unpaged.
+		if t, ok := out.(*tableOutput); ok && *pageSize > 0 && *watch == 0 && w == io.Writer(os.Stdout) && isTerminal(os.Stdout) && isTerminal(os.Stdin) {
+			t.pager = newPager(os.Stdin, *pageSize)
+		}
+
+		outs := multiOutput{out}
+
+		// Alerting sinks go first so alerts are sent before the slower
+		// history sinks are written.
+		if pagerDuty != nil {
+			outs = append(outs, pagerDuty)
+		}
+
+		if alertmanager != nil {
+			outs = append(outs, alertmanager)
+		}
+
+		if jira != nil {
+			outs = append(outs, jira)
+		}
+
+		if *sheetsID != "" {
+			sheets, err := newSheetsOutput(*sheetsCredentials, *sheetsID, *sheetsRange)
+			if err != nil {
+				return nil, err
+			}
+
+			outs = append(outs, sheets)
+		}
+
+		if *remoteWriteURL != "" {
+			rw, err := newRemoteWriteOutput(*remoteWriteURL, *remoteWriteBearerTokenFile, exportLabels, remoteWriteSeries)
+			if err != nil {
+				return nil, err
+			}
+
+			outs = append(outs, rw)
+		}
+
+		if *influxURL != "" || *influxFile != "" {
+			outs = append(outs, newInfluxOutput(*influxURL, *influxOrg, *influxBucket, os.Getenv("INFLUX_TOKEN"), *influxFile))
+		}
+
+		if postgres != nil {
+			outs = append(outs, postgres)
+		}
+
+		if server != nil {
+			outs = append(outs, server.output())
+		}
+
+		if annotations != nil {
+			outs = append(outs, annotations)
+		}
+
+		if *publishReports {
+			outs = append(outs, &publishOutput{ctx: context.TODO(), kcs: c.kcs})
+		}
+
+		for _, spec := range execSinks {
+			command := strings.Fields(spec)
+			if len(command) == 0 {
+				return nil, fmt.Errorf("--exec-sink requires a command")
+			}
+
+			// The sinks' output goes to stderr, keeping it out of the
+			// report.
+			outs = append(outs, newExecOutput(command, os.Stderr))
+		}
+
+		if *evict {
+			outs = append(outs, &evictOutput{
+				ctx:    context.TODO(),
+				kcs:    c.kcs,
+				w:      os.Stderr,
+				dryRun: *dryRun,
+			})
+		}
+
+		return outs, nil
+	}
+
+	opts := kubecap.Metadata{
+		Resource:               *resourceStr,
+		AdditionalInput:        additionalAmountStr,
+		NodeGroupLabel:         *nodeGroupLabel,
+		TaintPoolKeys:          taintPools,
+		SLOs:                   slos,
+		Enrichers:              enrichers,
+		EvictionStrategy:       *strategy,
+		UsageSource:            *usageSource,
+		SortBy:                 *sortBy,
+		NUMA:                   *numa,
+		CPUManager:             *cpuManager,
+		PSI:                    *psi,
+		EvictBurstableOnly:     *burstableOnly,
+		EvictIncludeBestEffort: *includeBestEffort,
+		CheckReserved:          *checkReserved,
+		DRA:                    *dra || additionalDevices != nil,
+		AdditionalDevices:      additionalDevices,
+		VolumeAttach:           *volumeAttach,
+		Cordons:                *cordons,
+		GPUMetricsURL:          *gpuMetricsURL,
+		GPUResource:            *gpuResource,
+		Namespaces:             namespaces,
+		Selector:               *selector,
+		NodeSelector:           *nodeSelector,
+		In:                     *in,
+		PriorityClasses:        *priorityClasses,
+		KueueBacklog:           *kueue,
+		JobBacklogSelector:     *jobBacklog,
+		Leaderboard:            *leaderboard,
+		Shapes:                 *shapes,
+		ListUnmatched:          *listUnmatched,
+		IncludeNotReady:        *includeNotReady,
+		StuckAfter:             *stuckAfter,
+		PodChurnWindow:         *podChurn,
+		Round:                  round,
+	}
+
+	for state, w := range map[string]float64{kubecap.PodStatePending: *pendingWeight, kubecap.PodStateTerminating: *terminatingWeight} {
+		if w < 0 || w > 1 {
+			panic(fmt.Sprintf("%s weight must be between 0 and 1: %g", state, w))
+		}
+
+		if w != 1 {
+			if opts.StateWeights == nil {
+				opts.StateWeights = map[string]float64{}
+			}
+
+			opts.StateWeights[state] = w
+		}
+	}
+
+	// The quota check is about a single namespace and memory only.
+	if len(namespaces) == 1 && rn == corev1.ResourceMemory {
+		opts.Namespace = namespaces[0]
+	}
+
+	for _, col := range columns {
+		c, err := kubecap.ParseColumn(col)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		opts.Columns = append(opts.Columns, c)
+	}
+
+	opts.NodeFilter, err = kubecap.ParseNodeFilter(*nodeOlderThan, *kubeletVersion)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	nps := NewNodePods(podList)
-
-	nodeTable := tablewriter.NewWriter(os.Stdout)
-	nodeTable.SetHeader([]string{
-		"Name",
-		"Allocatable",
-		"Used",
-		"Free",
-		"Requsts",
-		"Efficiency",
-		"Schedulable",
-		fmt.Sprintf("Free - %s", additionalAmountStr),
-		fmt.Sprintf("Schedulable - %s", additionalAmountStr),
-		"Ok?",
-	})
-
-	evictableTable := tablewriter.NewWriter(os.Stdout)
-	evictableTable.SetHeader([]string{
-		"Node",
-		"Namespace",
-		"Pod",
-		"Container",
-		"Requests",
-		"Used",
-		"Limits",
-	})
-
-	sort.Slice(nodeMetricsList.Items, func(i, j int) bool {
-		return nodeMetricsList.Items[i].Name < nodeMetricsList.Items[j].Name
-	})
-
-	for _, nodeMetric := range nodeMetricsList.Items {
-		name := nodeMetric.Name
-		used := nodeMetric.Usage.Memory().Value()
-
-		node, err := kcs.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
-		if err != nil {
-			panic(err.Error())
-		}
-
-		allocatable := node.Status.Allocatable.Memory().Value()
-		free := allocatable - used
-
-		requests := nps.MemoryRequests(node.Name).Value()
-		efficiency := float64(used) / float64(requests)
-		schedulable := allocatable - requests
-
-		fwa := free - int64(additional)
-		swa := schedulable - int64(additional)
-
-		enough := fwa > 0 && swa > 0
-
-		if !enough {
-			// Find the containers that are over their requests...
-			for _, pod := range nps[node.Name] {
-				for _, container := range pod.Spec.Containers {
-					memReq := container.Resources.Requests.Memory()
-					memLim := container.Resources.Limits.Memory()
-
-					if memReq != nil && !memReq.IsZero() {
-						// Don't worry about containers that have requests equal to limits.
-						if memLim != nil && memReq.Cmp(*memLim) >= 0 {
-							continue
-						}
-
-						// NOTE: This could be more efficient if the pod metrics list was first
-						// pre-processed into a shape that made it easy to select exactly the
-						// container we want. But this is good enough for now.
-						for _, pm := range podMetricsList.Items {
-							if pm.Namespace != pod.Namespace {
-								continue
-							}
-
-							if pm.Name != pod.Name {
-								continue
-							}
-
-							for _, pmc := range pm.Containers {
-								if pmc.Name != container.Name {
-									continue
-								}
-
-								// We have a match!
-								if memUsed, ok := pmc.Usage[corev1.ResourceMemory]; ok {
-									if memReq.Cmp(memUsed) < 0 {
-										evictableTable.Append([]string{
-											node.Name,
-											pod.Namespace,
-											pod.Name,
-											container.Name,
-											humanize.Comma(memReq.Value()),
-											humanize.Comma(memUsed.Value()),
-											humanize.Comma(memLim.Value()),
-										})
-									}
-								}
-							}
-						}
-					}
-				}
+	if *verdictRulesFile != "" {
+		opts.Verdicts, err = kubecap.LoadVerdictRules(*verdictRulesFile)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if *costCenterFile != "" {
+		opts.CostCenters, err = kubecap.LoadCostCenters(*costCenterFile)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if *evictionPolicyFile != "" {
+		opts.EvictionPolicy, err = kubecap.LoadEvictionPolicy(*evictionPolicyFile)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if *maxAPICalls < 0 || *maxRuntime < 0 {
+		panic("--max-api-calls and --max-runtime must not be negative")
+	}
+
+	if *maxAPICalls > 0 || *maxRuntime > 0 {
+		opts.Budget = &kubecap.Budget{MaxAPICalls: *maxAPICalls, MaxRuntime: *maxRuntime}
+	}
+
+	if *reservationsFile != "" {
+		opts.Reservations, err = kubecap.LoadReservations(*reservationsFile)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if len(standbySpecs) > 0 {
+		opts.Standby, err = kubecap.ParseStandbys(opts.ResourceName(), standbySpecs)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	for _, path := range reportFiles {
+		reports, err := kubecap.LoadCustomReports(path)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		opts.CustomReports = append(opts.CustomReports, reports...)
+	}
+
+	if *thresholdProfilesFile != "" {
+		opts.ThresholdProfiles, err = kubecap.LoadThresholdProfiles(*thresholdProfilesFile)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if *autoscalerStatus {
+		opts.AutoscalerStatus = *autoscalerStatusConfigMap
+	}
+
+	if *rightSize != "" {
+		for _, size := range strings.Split(*rightSize, ",") {
+			amount, err := kubecap.ParseAmount(rn, strings.TrimSpace(size))
+			if err != nil {
+				panic(err.Error())
+			}
+
+			if amount <= 0 {
+				panic(fmt.Sprintf("right-size candidate must be positive: %s", size))
+			}
+
+			opts.RightSizes = append(opts.RightSizes, amount)
+		}
+	}
+
+	// run reports on the cluster or, with summary for table output, the
+	// clusters together.
+	run := func(outputFile string, md kubecap.Metadata, newOut func(w io.Writer) (kubecap.Output, error), summary bool) error {
+		if clusters != nil {
+			return reportClusters(context.TODO(), clusters, outputFile, md, newOut, summary && (*output == "table" || *output == "wide"))
+		}
+
+		return report(context.TODO(), c, outputFile, md, newOut)
+	}
+
+	if *reportSchedule != "" {
+		sched, err := parseCron(*reportSchedule)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		dispatchers := []reportDispatcher{}
+
+		if *reportEmailTo != "" {
+			dispatchers = append(dispatchers, newEmailDispatcher(*smtpAddr, *reportEmailFrom, *reportEmailTo, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")))
+		}
+
+		if *reportSlackWebhook != "" {
+			dispatchers = append(dispatchers, newSlackDispatcher(*reportSlackWebhook))
+		}
+
+		if *reportS3URL != "" {
+			s3, err := newS3Dispatcher(*reportS3URL, *reportS3Endpoint, os.Getenv("AWS_REGION"), os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN"))
+			if err != nil {
+				panic(err.Error())
+			}
+
+			dispatchers = append(dispatchers, s3)
+		}
+
+		if len(dispatchers) == 0 {
+			panic("--report-schedule requires --report-email-to, --report-slack-webhook or --report-s3-url")
+		}
+
+		scheduledReport := func() error {
+			return run("", opts, func(w io.Writer) (kubecap.Output, error) {
+				return newScheduledOutput(*output, additionalAmountStr, *clusterCol, dispatchers)
+			}, false)
+		}
+
+		if *watch == 0 {
+			runSchedule(sched, scheduledReport)
+
+			return
+		}
+
+		go runSchedule(sched, scheduledReport)
+	}
+
+	if *runs > 1 {
+		err = reportRuns(context.TODO(), c, *outputFile, opts, newOut, *runs, *runInterval, *spread && (*output == "table" || *output == "wide"))
+		if err != nil {
+			panic(err.Error())
+		}
+
+		return
+	}
+
+	if *watch == 0 {
+		err = run(*outputFile, opts, newOut, true)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		return
+	}
+
+	// Only the watch's own reports are smoothed and tracked for churn:
+	// scheduled reports run concurrently.
+	watchOpts := opts
+	watchOpts.ChurnTracker = kubecap.NewNodeChurn()
+
+	if *cordons {
+		watchOpts.CordonTracker = kubecap.NewNodeCordons()
+	}
+
+	if *allocatableChanges > 0 {
+		watchOpts.AllocatableChanges = *allocatableChanges
+		watchOpts.AllocatableTracker = kubecap.NewNodeAllocatable(*allocatableChanges)
+	}
+
+	if len(slos) > 0 {
+		watchOpts.SLOTracker = kubecap.NewSLOTracker(*sloWindow)
+
+		if postgres != nil {
+			err = postgres.seedSLOs(context.TODO(), watchOpts.SLOTracker, time.Now().Add(-*sloWindow), string(watchOpts.ResourceName()))
+			if err != nil {
+				panic(err.Error())
 			}
 		}
+	}
+
+	if *usageSmoothing != 0 {
+		if *usageSmoothing < 0 || *usageSmoothing >= 1 {
+			panic(fmt.Sprintf("usage smoothing must be between 0 and 1: %g", *usageSmoothing))
+		}
+
+		watchOpts.UsageSmoothing = *usageSmoothing
+		watchOpts.Smoother = kubecap.NewUsageSmoother(*usageSmoothing)
+	}
 
-		nodeTable.Append([]string{
-			name,
-			humanize.Comma(allocatable),
-			humanize.Comma(used),
-			humanize.Comma(free),
-			humanize.Comma(requests),
-			humanize.FormatFloat("#.##", efficiency),
-			humanize.Comma(schedulable),
-			humanize.Comma(fwa),
-			humanize.Comma(swa),
-			fmt.Sprintf("%t", enough),
-		})
+	if *podInterval > 0 {
+		watchOpts.PodInterval = *podInterval
+		watchOpts.ListCache = kubecap.NewListCache(*podInterval)
 	}
 
-	fmt.Println("Node Report")
-	nodeTable.Render()
+	if *watchJitter < 0 || *watchJitter >= 1 {
+		panic(fmt.Sprintf("watch jitter must be at least 0 and below 1: %g", *watchJitter))
+	}
+
+	time.Sleep(stagger(*watch, *watchJitter))
+
+	for {
+		err = run(*outputFile, watchOpts, newOut, true)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		time.Sleep(jitter(*watch, *watchJitter))
+	}
+}
+
+// cluster holds the clients for the current kubeconfig context.
+type cluster struct {
+	context string
+	name    string
+
+	// namespace is the context's namespace, default when not set.
+	namespace string
+
+	kcs kubernetes.Interface
+	mcs metricsv.Interface
+
+	// calls counts the requests kcs and mcs made, accessed atomically.
+	calls *int64
+
+	// scheduler is the kube-scheduler-simulator fit is delegated to, if
+	// any, and schedulerPriorityClass and schedulerTimeout the probe pod's
+	// PriorityClass and how long to wait for it to be scheduled.
+	scheduler              kubernetes.Interface
+	schedulerPriorityClass string
+	schedulerTimeout       time.Duration
+
+	// churn, cordons, allocatable and smoother track the cluster's nodes,
+	// cordons and allocatable changes and smooth their usage on their own in
+	// a watch of several clusters, and lists keeps its pod lists.
+	churn       *kubecap.NodeChurn
+	cordons     *kubecap.NodeCordons
+	allocatable *kubecap.NodeAllocatable
+	smoother    *kubecap.UsageSmoother
+	lists       *kubecap.ListCache
+}
+
+// clusterFlags adds the flags choosing the kubeconfig and context to fs.
+func clusterFlags(fs *flag.FlagSet) (kubeconfig, kcontext *string) {
+	kubeconfig = fs.String("kubeconfig", "", "path to the kubeconfig (default: $KUBECONFIG or ~/.kube/config, falling back to the in-cluster configuration)")
+	kcontext = fs.String("context", "", "kubeconfig context to use (default: the current context)")
+
+	return kubeconfig, kcontext
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+
+	return nil
+}
+
+// newCluster returns the clients for the kubeconfig context kcontext (the
+// current context when empty) of the kubeconfig at path kubeconfig. Without a
+// path the usual $KUBECONFIG and ~/.kube/config are loaded and, when neither
+// exists (e.g. running in the cluster), the in-cluster configuration is used.
+func newCluster(kubeconfig, kcontext string) (*cluster, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules,
+		&clientcmd.ConfigOverrides{CurrentContext: kcontext},
+	)
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	rawConfig, err := clientConfig.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if kcontext == "" {
+		kcontext = rawConfig.CurrentContext
+	}
+
+	c := &cluster{
+		context: kcontext,
+		calls:   new(int64),
+	}
+
+	if kctx, ok := rawConfig.Contexts[kcontext]; ok {
+		c.name = kctx.Cluster
+	}
+
+	c.namespace, _, err = clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+
+		return &countingTransport{rt: rt, calls: c.calls}
+	}
+
+	c.kcs, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mcs, err = metricsv.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// countingTransport counts the requests made through it.
+type countingTransport struct {
+	rt    http.RoundTripper
+	calls *int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(t.calls, 1)
+
+	return t.rt.RoundTrip(req)
+}
+
+// report collects the report for the cluster and renders it with the Output
+// from newOut, writing to outputFile if given and stdout otherwise. The
+// report's options (additional amount as input, node group label, ...) are
+// given as md.
+func report(ctx context.Context, c *cluster, outputFile string, md kubecap.Metadata, newOut func(w io.Writer) (kubecap.Output, error)) error {
+	var w io.Writer = os.Stdout
+
+	var af *atomicFile
+	if outputFile != "" {
+		var err error
+
+		af, err = createAtomic(outputFile)
+		if err != nil {
+			return err
+		}
+		defer af.Abort()
+
+		w = af
+	}
+
+	out, err := newOut(w)
+	if err != nil {
+		return err
+	}
+
+	err = collectReport(ctx, c, md, out)
+	if err != nil {
+		return err
+	}
+
+	if af != nil {
+		return af.Commit()
+	}
+
+	return nil
+}
+
+// collectReport collects the report for the cluster into out.
+func collectReport(ctx context.Context, c *cluster, md kubecap.Metadata, out kubecap.Output) error {
+	// No additional amount given checks for none.
+	var (
+		additional int64
+		percent    float64
+		err        error
+	)
+
+	if md.AdditionalInput != "" {
+		additional, percent, err = kubecap.ParseAdditional(md.ResourceName(), md.AdditionalInput)
+		if err != nil {
+			return err
+		}
+	}
+
+	if percent != 0 && c.scheduler != nil {
+		return fmt.Errorf("the kube-scheduler-simulator can't probe for a percentage of allocatable: %s", md.AdditionalInput)
+	}
+
+	md.Context = c.context
+	md.Cluster = c.name
+	md.Additional = additional
+	md.AdditionalPercent = percent
+
+	if c.scheduler != nil {
+		md.Scheduler, err = kubecap.ProbeSchedulerFit(ctx, c.scheduler, md.Additional, c.schedulerPriorityClass, c.schedulerTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Each cluster's report gets a budget of its own, counting its
+	// client's calls.
+	if b := md.Budget; b != nil {
+		md.Budget = &kubecap.Budget{MaxAPICalls: b.MaxAPICalls, MaxRuntime: b.MaxRuntime}
+
+		if calls := c.calls; calls != nil {
+			md.Budget.Calls = func() int64 {
+				return atomic.LoadInt64(calls)
+			}
+		}
+	}
+
+	err = kubecap.Collect(ctx, c.kcs, c.mcs, &md, out)
+
+	for _, w := range md.Warnings {
+		fmt.Fprintln(os.Stderr, w)
+	}
 
-	fmt.Println("Evictable Pods Report")
-	evictableTable.Render()
+	return err
 }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+// sample is a single metric value derived from a report.
+type sample struct {
+	name   string
+	help   string
+	labels map[string]string
+	value  float64
+}
+
+// sortedLabelNames returns the sample's label names in order.
+func (s sample) sortedLabelNames() []string {
+	names := make([]string, 0, len(s.labels))
+	for name := range s.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// series identifies the sample's series: its name and non-empty labels.
+func (s sample) series() string {
+	parts := []string{s.name}
+	for _, name := range s.sortedLabelNames() {
+		if s.labels[name] != "" {
+			parts = append(parts, name+"="+s.labels[name])
+		}
+	}
+
+	return strings.Join(parts, "\xff")
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// reportSamples converts the report into metric samples: a set per node, a
+// set of cluster-wide totals and a set per cost center, if mapped. Amounts
+// are named by the report's unit (bytes or millicores) and labelled with its
+// resource.
+func reportSamples(md *kubecap.Metadata, nodes []*kubecap.NodeReport) []sample {
+	samples := []sample{}
+
+	unit := md.Unit()
+	resource := string(md.ResourceName())
+	title := strings.ToUpper(resource[:1]) + resource[1:]
+
+	for _, n := range nodes {
+		labels := map[string]string{
+			"cluster":    md.Context,
+			"node":       n.Name,
+			"node_group": n.Group,
+			"resource":   resource,
+		}
+
+		add := func(name, help string, value float64) {
+			samples = append(samples, sample{name, help, labels, value})
+		}
+
+		add("kubecap_node_allocatable_"+unit, "Allocatable "+resource+" on the node.", float64(n.Allocatable))
+		add("kubecap_node_used_"+unit, title+" used on the node.", float64(n.Used))
+		add("kubecap_node_free_"+unit, "Allocatable "+resource+" not used on the node.", float64(n.Free))
+		add("kubecap_node_requests_"+unit, title+" requested by pods on the node.", float64(n.Requests))
+		add("kubecap_node_schedulable_"+unit, "Allocatable "+resource+" not requested on the node.", float64(n.Schedulable))
+		add("kubecap_node_efficiency_ratio", title+" used over "+resource+" requested on the node.", n.Efficiency)
+		add("kubecap_node_ok", "Whether the node has room for the additional amount.", boolValue(n.Ok))
+		add("kubecap_node_pressure_score", "Composite 0-100 memory pressure score of the node.", n.Pressure)
+	}
+
+	sum := kubecap.Summarize(nodes)
+	labels := map[string]string{
+		"cluster":  md.Context,
+		"resource": resource,
+	}
+
+	add := func(name, help string, value float64) {
+		samples = append(samples, sample{name, help, labels, value})
+	}
+
+	add("kubecap_cluster_nodes", "Nodes in the cluster.", float64(sum.Nodes))
+	add("kubecap_cluster_ok_nodes", "Nodes in the cluster with room for the additional amount.", float64(sum.OkNodes))
+	add("kubecap_cluster_allocatable_"+unit, "Allocatable "+resource+" in the cluster.", float64(sum.Allocatable))
+	add("kubecap_cluster_used_"+unit, title+" used in the cluster.", float64(sum.Used))
+	add("kubecap_cluster_requests_"+unit, title+" requested by pods in the cluster.", float64(sum.Requests))
+	add("kubecap_cluster_schedulable_"+unit, "Allocatable "+resource+" not requested in the cluster.", float64(sum.Schedulable))
+	add("kubecap_additional_"+unit, "The additional amount checked for on each node.", float64(md.Additional))
+
+	if md.CostCenters != nil {
+		for _, c := range kubecap.CostCenterReports(nodes) {
+			labels := map[string]string{
+				"cluster":     md.Context,
+				"cost_center": c.CostCenter,
+				"resource":    resource,
+			}
+
+			samples = append(samples,
+				sample{"kubecap_cost_center_pods", "Pods of the cost center.", labels, float64(c.Pods)},
+				sample{"kubecap_cost_center_requests_" + unit, title + " requested by the cost center's pods.", labels, float64(c.Requests)},
+				sample{"kubecap_cost_center_used_" + unit, title + " used by the cost center's pods.", labels, float64(c.Used)},
+			)
+		}
+	}
+
+	for _, sb := range md.StandbyStatus {
+		labels := map[string]string{
+			"cluster":        md.Context,
+			"priority_class": sb.PriorityClass,
+		}
+
+		samples = append(samples,
+			sample{"kubecap_standby_required_pods", "Pods of the priority tier the standby requires room for.", labels, float64(sb.Replicas)},
+			sample{"kubecap_standby_available_pods", "Pods of the priority tier the nodes have room for, after the higher tiers' standby.", labels, float64(sb.Available)},
+			sample{"kubecap_standby_ok", "Whether the priority tier's standby exists.", labels, boolValue(sb.Ok)},
+		)
+	}
+
+	for _, s := range md.SLOStatus {
+		labels := map[string]string{
+			"cluster": md.Context,
+			"slo":     s.SLO,
+			"scope":   s.Scope,
+		}
+
+		samples = append(samples,
+			sample{"kubecap_slo_schedulable_ratio", "Schedulable over allocatable amount in the SLO's scope.", labels, s.Ratio},
+			sample{"kubecap_slo_met", "Whether the SLO is met in its scope.", labels, boolValue(s.Met)},
+		)
+
+		if s.Samples > 0 {
+			samples = append(samples,
+				sample{"kubecap_slo_compliance_ratio", "Fraction of the reports over the SLO window meeting the SLO in its scope.", labels, s.Compliance},
+				sample{"kubecap_slo_error_budget_remaining_ratio", "Fraction of the SLO's error budget left over its window, negative once overspent.", labels, s.ErrorBudget},
+			)
+		}
+	}
+
+	return samples
+}
+
+// evictableSamples converts the evictable containers into a sample each of
+// how much memory they use above their requests.
+func evictableSamples(md *kubecap.Metadata, evictable []*kubecap.EvictableContainer) []sample {
+	samples := []sample{}
+
+	for _, e := range evictable {
+		labels := map[string]string{
+			"cluster":   md.Context,
+			"node":      e.Node,
+			"namespace": e.Namespace,
+			"pod":       e.Pod,
+			"container": e.Container,
+		}
+
+		samples = append(samples, sample{"kubecap_container_over_request_bytes", "Memory used above its requests by an evictable container.", labels, float64(e.Used - e.Requests)})
+	}
+
+	return samples
+}
+
+// summedMetric reports whether the metric's samples are added up when
+// combined: amounts and counts are, other gauges (ratios, scores and flags)
+// are averaged.
+func summedMetric(name string) bool {
+	for _, suffix := range []string{"_bytes", "_millicores", "_nodes", "_pods"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// limitLabels drops the labels not in allow (keeping all when it is empty)
+// to bound the series' cardinality, e.g. to leave out the pod and container
+// labels. The samples left in the same series are combined.
+func limitLabels(samples []sample, allow []string) []sample {
+	if len(allow) == 0 {
+		return samples
+	}
+
+	allowed := map[string]bool{}
+	for _, l := range allow {
+		allowed[l] = true
+	}
+
+	limited := []sample{}
+	index := map[string]int{}
+	counts := []int{}
+
+	for _, s := range samples {
+		labels := map[string]string{}
+		for name, value := range s.labels {
+			if allowed[name] {
+				labels[name] = value
+			}
+		}
+
+		s.labels = labels
+
+		key := s.series()
+
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(limited)
+			limited = append(limited, s)
+			counts = append(counts, 1)
+
+			continue
+		}
+
+		limited[i].value += s.value
+		counts[i]++
+	}
+
+	for i := range limited {
+		if !summedMetric(limited[i].name) {
+			limited[i].value /= float64(counts[i])
+		}
+	}
+
+	return limited
+}
+
+// staleNaN is the value Prometheus marks a series stale with.
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+// exportedSeries remembers the series last exported for each cluster, so
+// that those gone since (deleted nodes, evicted pods) are expired right away
+// with staleness markers rather than lingering until Prometheus' lookback
+// runs out.
+type exportedSeries struct {
+	mu        sync.Mutex
+	byCluster map[string]map[string]sample
+}
+
+func newExportedSeries() *exportedSeries {
+	return &exportedSeries{byCluster: map[string]map[string]sample{}}
+}
+
+// stale returns a staleness marker for each of the cluster's series
+// exported last but missing from samples.
+func (e *exportedSeries) stale(cluster string, samples []sample) []sample {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := map[string]bool{}
+	for _, s := range samples {
+		current[s.series()] = true
+	}
+
+	keys := []string{}
+	for key := range e.byCluster[cluster] {
+		if !current[key] {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	markers := []sample{}
+	for _, key := range keys {
+		s := e.byCluster[cluster][key]
+		s.value = staleNaN
+		markers = append(markers, s)
+	}
+
+	return markers
+}
+
+// exported remembers the samples as the cluster's series once exported.
+func (e *exportedSeries) exported(cluster string, samples []sample) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	series := map[string]sample{}
+	for _, s := range samples {
+		series[s.series()] = s
+	}
+
+	e.byCluster[cluster] = series
+}
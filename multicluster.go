@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// listContexts returns the names of every context in the kubeconfig at path
+// kubeconfig (the usual $KUBECONFIG and ~/.kube/config without a path).
+func listContexts(kubeconfig string) ([]string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+
+	rawConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).RawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range rawConfig.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no contexts in the kubeconfig")
+	}
+
+	return names, nil
+}
+
+// recordOutput records the report into r.
+type recordOutput struct {
+	r *kubecap.ClusterReport
+}
+
+func (o *recordOutput) Metadata(m *kubecap.Metadata) error {
+	o.r.Metadata = m
+
+	return nil
+}
+
+func (o *recordOutput) Node(n *kubecap.NodeReport) error {
+	o.r.Nodes = append(o.r.Nodes, n)
+
+	return nil
+}
+
+func (o *recordOutput) Evictable(e *kubecap.EvictableContainer) error {
+	o.r.Evictable = append(o.r.Evictable, e)
+
+	return nil
+}
+
+func (o *recordOutput) Flush() error {
+	return nil
+}
+
+// replay reports the recorded report to out as it was collected: each node
+// after its evictable containers.
+func replay(r *kubecap.ClusterReport, out kubecap.Output) error {
+	err := out.Metadata(r.Metadata)
+	if err != nil {
+		return err
+	}
+
+	evictable := map[string][]*kubecap.EvictableContainer{}
+	for _, e := range r.Evictable {
+		evictable[e.Node] = append(evictable[e.Node], e)
+	}
+
+	for _, n := range r.Nodes {
+		for _, e := range evictable[n.Name] {
+			err := out.Evictable(e)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := out.Node(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return out.Flush()
+}
+
+// clusterResult is a cluster's collected report or the error collecting it.
+type clusterResult struct {
+	c   *cluster
+	r   *kubecap.ClusterReport
+	err error
+}
+
+// reportClusters collects the reports for the clusters concurrently and
+// renders each in turn with its own Output from newOut, writing to
+// outputFile if given and stdout otherwise. With summary a table of each
+// cluster's totals and their combined totals follows. A cluster failing to
+// report doesn't stop the others; the first error is returned once the rest
+// are written.
+func reportClusters(ctx context.Context, clusters []*cluster, outputFile string, md kubecap.Metadata, newOut func(w io.Writer) (kubecap.Output, error), summary bool) error {
+	results := make([]*clusterResult, len(clusters))
+
+	var wg sync.WaitGroup
+
+	for i, c := range clusters {
+		// Each cluster's nodes are tracked and smoothed on their own
+		// across a watch.
+		cmd := md
+
+		if md.ChurnTracker != nil {
+			if c.churn == nil {
+				c.churn = kubecap.NewNodeChurn()
+			}
+
+			cmd.ChurnTracker = c.churn
+		}
+
+		if md.CordonTracker != nil {
+			if c.cordons == nil {
+				c.cordons = kubecap.NewNodeCordons()
+			}
+
+			cmd.CordonTracker = c.cordons
+		}
+
+		if md.AllocatableTracker != nil {
+			if c.allocatable == nil {
+				c.allocatable = kubecap.NewNodeAllocatable(md.AllocatableChanges)
+			}
+
+			cmd.AllocatableTracker = c.allocatable
+		}
+
+		if md.Smoother != nil {
+			if c.smoother == nil {
+				c.smoother = kubecap.NewUsageSmoother(md.UsageSmoothing)
+			}
+
+			cmd.Smoother = c.smoother
+		}
+
+		if md.ListCache != nil {
+			if c.lists == nil {
+				c.lists = kubecap.NewListCache(md.PodInterval)
+			}
+
+			cmd.ListCache = c.lists
+		}
+
+		wg.Add(1)
+
+		go func(i int, c *cluster) {
+			defer wg.Done()
+
+			r := &kubecap.ClusterReport{}
+			results[i] = &clusterResult{
+				c:   c,
+				r:   r,
+				err: collectReport(ctx, c, cmd, &recordOutput{r}),
+			}
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	var w io.Writer = os.Stdout
+
+	var af *atomicFile
+	if outputFile != "" {
+		var err error
+
+		af, err = createAtomic(outputFile)
+		if err != nil {
+			return err
+		}
+		defer af.Abort()
+
+		w = af
+	}
+
+	var first error
+
+	for _, res := range results {
+		if res.err != nil {
+			res.err = fmt.Errorf("context %s: %w", res.c.context, res.err)
+			fmt.Fprintln(os.Stderr, res.err)
+
+			if first == nil {
+				first = res.err
+			}
+
+			continue
+		}
+
+		out, err := newOut(w)
+		if err != nil {
+			return err
+		}
+
+		err = replay(res.r, out)
+		if err != nil {
+			return err
+		}
+	}
+
+	if summary {
+		fmt.Fprintln(w)
+		writeClustersSummary(w, results)
+	}
+
+	if af != nil {
+		err := af.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return first
+}
+
+// writeClustersSummary writes each cluster's totals, whether the additional
+// amount fits in it and the totals of the clusters combined.
+func writeClustersSummary(w io.Writer, results []*clusterResult) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Cluster", "Nodes", "Allocatable", "Used", "Requests", "Schedulable", "Ok Nodes", "Fits"})
+
+	var total kubecap.Summary
+	fits := 0
+
+	for _, res := range results {
+		if res.err != nil {
+			table.Append([]string{res.c.context, "-", "-", "-", "-", "-", "-", "error"})
+
+			continue
+		}
+
+		s := res.r.Summary()
+
+		total.Nodes += s.Nodes
+		total.OkNodes += s.OkNodes
+		total.Allocatable += s.Allocatable
+		total.Used += s.Used
+		total.Requests += s.Requests
+		total.Schedulable += s.Schedulable
+
+		fit := "no"
+		if s.OkNodes > 0 {
+			fit = "yes"
+			fits++
+		}
+
+		table.Append([]string{
+			res.c.context,
+			fmt.Sprintf("%d", s.Nodes),
+			humanize.Comma(s.Allocatable),
+			humanize.Comma(s.Used),
+			humanize.Comma(s.Requests),
+			humanize.Comma(s.Schedulable),
+			fmt.Sprintf("%d", s.OkNodes),
+			fit,
+		})
+	}
+
+	table.Append([]string{
+		"Total",
+		fmt.Sprintf("%d", total.Nodes),
+		humanize.Comma(total.Allocatable),
+		humanize.Comma(total.Used),
+		humanize.Comma(total.Requests),
+		humanize.Comma(total.Schedulable),
+		fmt.Sprintf("%d", total.OkNodes),
+		fmt.Sprintf("%d/%d", fits, len(results)),
+	})
+
+	fmt.Fprintln(w, "Clusters Report")
+	table.Render()
+}
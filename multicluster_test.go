@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestReportClusters(t *testing.T) {
+	clusters := []*cluster{}
+
+	for _, name := range []string{"east", "west"} {
+		c, closeSimulation, err := loadSimulatedCluster("testdata/simulation.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeSimulation()
+
+		c.context = name
+		clusters = append(clusters, c)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.txt")
+
+	newOut := func(w io.Writer) (kubecap.Output, error) {
+		return newOutput("table", w, "4Gi", true)
+	}
+
+	// Clusters failing to report are summarized as such.
+	err := reportClusters(context.Background(), clusters, path, kubecap.Metadata{AdditionalInput: "lots"}, newOut, true)
+	if err == nil {
+		t.Fatal("invalid additional amount accepted")
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !regexp.MustCompile(`(?m)^\| west +\| +- .*\| error +\|$`).Match(b) {
+		t.Errorf("failed summary:\n%s", b)
+	}
+
+	err = reportClusters(context.Background(), clusters, path, kubecap.Metadata{AdditionalInput: "4Gi"}, newOut, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := string(b)
+
+	// Each cluster's report is written in turn with its rows prefixed by
+	// the cluster.
+	if strings.Index(out, "Context: east") > strings.Index(out, "Context: west") || !strings.Contains(out, "| west ") {
+		t.Errorf("reports:\n%s", out)
+	}
+
+	for _, want := range []string{
+		`(?m)^\| east +\| +2 +\| +34,359,738,368 .*\| +1 +\| yes +\|$`,
+		`(?m)^\| Total +\| +4 +\| +68,719,476,736 .*\| +2 +\| 2/2 +\|$`,
+	} {
+		if !regexp.MustCompile(want).MatchString(out) {
+			t.Errorf("summary missing %s:\n%s", want, out)
+		}
+	}
+}
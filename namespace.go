@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/olekukonko/tablewriter"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// namespaceMain implements the namespace subcommand, which reports a
+// namespace's use of a resource against its quotas and the cluster's headroom
+// as advertised by a kubecap server, needing only read access to the
+// namespace's pods, pod metrics and ResourceQuotas.
+func namespaceMain(args []string) {
+	fs := flag.NewFlagSet("namespace", flag.ExitOnError)
+	namespace := fs.String("n", "", "namespace to report on (default: the context's namespace)")
+	resourceStr := fs.String("resource", "memory", "resource to report on: memory, cpu (amounts in millicores) or ephemeral-storage")
+	headroomURL := fs.String("headroom-url", os.Getenv("KUBECAP_HEADROOM_URL"), "the cluster's headroom as advertised by the cluster admins' kubecap server, e.g. http://kubecap.kubecap:9090/headroom")
+	output := fs.String("o", "table", "output format: table or json")
+	kubeconfig, kcontext := clusterFlags(fs)
+	fs.Parse(args)
+
+	rn := corev1.ResourceName(*resourceStr)
+
+	err := kubecap.CheckResource(rn)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	var additional int64
+
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		additional, err = kubecap.ParseAmount(rn, fs.Arg(0))
+		if err != nil {
+			panic(err.Error())
+		}
+	default:
+		panic("usage: kubecap namespace [flags] [amount]")
+	}
+
+	c, err := newCluster(*kubeconfig, *kcontext)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if *namespace == "" {
+		*namespace = c.namespace
+	}
+
+	ctx := context.TODO()
+
+	var headroom *kubecap.ClusterHeadroom
+
+	if *headroomURL != "" {
+		headroom, err = fetchHeadroom(ctx, *headroomURL)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		if headroom.Resource != string(rn) {
+			panic(fmt.Sprintf("the headroom advertised is of %s, not %s", headroom.Resource, rn))
+		}
+	}
+
+	r, err := kubecap.CollectNamespace(ctx, c.kcs, c.mcs, *namespace, rn, additional, headroom)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	switch *output {
+	case "table":
+		writeNamespace(os.Stdout, r)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		err = enc.Encode(r)
+		if err != nil {
+			panic(err.Error())
+		}
+	default:
+		panic(fmt.Sprintf("unknown output format: %q", *output))
+	}
+
+	if additional > 0 && !r.Fits {
+		os.Exit(1)
+	}
+}
+
+// fetchHeadroom gets the cluster's headroom from a kubecap server's
+// /headroom.
+func fetchHeadroom(ctx context.Context, url string) (*kubecap.ClusterHeadroom, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("headroom: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return nil, fmt.Errorf("headroom: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	h := &kubecap.ClusterHeadroom{}
+
+	err = json.NewDecoder(resp.Body).Decode(h)
+	if err != nil {
+		return nil, fmt.Errorf("headroom: %w", err)
+	}
+
+	return h, nil
+}
+
+// writeNamespace renders the namespace report as tables.
+func writeNamespace(w io.Writer, r *kubecap.NamespaceReport) {
+	md := &kubecap.Metadata{Resource: r.Resource}
+
+	amount := func(v int64) string {
+		return humanAmount(md, v)
+	}
+
+	used := "unknown (" + r.UsageError + ")"
+	if r.Used != nil {
+		used = amount(*r.Used)
+	}
+
+	fmt.Fprintf(w, "Namespace: %s (%s)\n", r.Namespace, r.Resource)
+	fmt.Fprintf(w, "Pods: %d requesting %s, limited to %s, using %s\n", r.Pods, amount(r.Requests), amount(r.Limits), used)
+	fmt.Fprintln(w)
+
+	workloadTable := tablewriter.NewWriter(w)
+	workloadTable.SetHeader([]string{"Kind", "Workload", "Pods", "Requests", "Used"})
+	for _, wl := range r.Workloads {
+		workloadTable.Append([]string{wl.Kind, wl.Name, fmt.Sprintf("%d", wl.Pods), amount(wl.Requests), amount(wl.Used)})
+	}
+
+	fmt.Fprintln(w, "Workloads")
+	workloadTable.Render()
+
+	fmt.Fprintln(w, "Quotas")
+
+	if len(r.Quota.Quotas) == 0 {
+		fmt.Fprintf(w, "  no ResourceQuota limits %s\n", r.Resource)
+	} else {
+		quotaTable := tablewriter.NewWriter(w)
+		quotaTable.SetHeader([]string{"Quota", "Resource", "Hard", "Used", "Left"})
+		for _, q := range r.Quota.Quotas {
+			quotaTable.Append([]string{q.Name, q.Resource, amount(q.Hard), amount(q.Used), amount(q.Hard - q.Used)})
+		}
+
+		quotaTable.Render()
+	}
+
+	if h := r.Cluster; h != nil {
+		fmt.Fprintf(w, "Cluster Headroom (as of %s)\n", h.Timestamp.Format(time.RFC3339))
+
+		headroomTable := tablewriter.NewWriter(w)
+		headroomTable.SetHeader([]string{"Group", "Nodes", "Schedulable", "Largest Pod"})
+		for _, g := range h.Groups {
+			headroomTable.Append([]string{g.Group, fmt.Sprintf("%d", g.Nodes), amount(g.Schedulable), amount(g.Largest)})
+		}
+
+		headroomTable.SetFooter([]string{"Total", fmt.Sprintf("%d", h.Nodes), amount(h.Schedulable), amount(h.Largest)})
+		headroomTable.Render()
+	}
+
+	if r.Additional > 0 {
+		fmt.Fprintf(w, "Additional %s fits: %t (blocked by: %s)\n", amount(r.Additional), r.Fits, r.Blocker)
+	}
+}
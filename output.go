@@ -0,0 +1,1819 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// newOutput returns the Output for the named format writing to w. The
+// additional amount is used as given by the user in column headings. Formats
+// with fixed columns only include the cluster when showCluster is set.
+func newOutput(format string, w io.Writer, additionalAmountStr string, showCluster bool) (kubecap.Output, error) {
+	switch format {
+	case "table":
+		return newTableOutput(w, additionalAmountStr, showCluster), nil
+	case "wide":
+		t := newTableOutput(w, additionalAmountStr, showCluster)
+		t.wide = true
+
+		return t, nil
+	case "jsonl":
+		return newJSONLOutput(w), nil
+	case "json":
+		return newDocumentOutput(w, false), nil
+	case "yaml":
+		return newDocumentOutput(w, true), nil
+	case "csv":
+		return newCSVOutput(w), nil
+	case "html":
+		return newHTMLOutput(w), nil
+	case "dot":
+		return newDotOutput(w), nil
+	case "xlsx":
+		return newXLSXOutput(w, additionalAmountStr), nil
+	}
+
+	return nil, fmt.Errorf("unknown output format: %q", format)
+}
+
+// multiOutput reports to each of its outputs in turn.
+type multiOutput []kubecap.Output
+
+func (m multiOutput) Metadata(md *kubecap.Metadata) error {
+	for _, out := range m {
+		err := out.Metadata(md)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m multiOutput) Node(n *kubecap.NodeReport) error {
+	for _, out := range m {
+		err := out.Node(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m multiOutput) Evictable(e *kubecap.EvictableContainer) error {
+	for _, out := range m {
+		err := out.Evictable(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush flushes every output even if one fails, so an outage of one sink
+// doesn't hold back the others (e.g. pages), and returns the first error.
+func (m multiOutput) Flush() error {
+	var first error
+	for _, out := range m {
+		err := out.Flush()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// tableOutput renders the node and evictable pods reports as tables once all
+// the nodes have been reported.
+type tableOutput struct {
+	w                   io.Writer
+	md                  *kubecap.Metadata
+	additionalAmountStr string
+	showCluster         bool
+
+	// wide adds the nodes' kubelet version, age and instance type.
+	wide bool
+
+	nodeTable      *tablewriter.Table
+	evictableTable *tablewriter.Table
+
+	// nodeRows are the node table's rows, rendered when flushed.
+	nodeRows [][]string
+
+	// pager, if set, shows the node table a page at a time.
+	pager *pager
+
+	// limitRisks are the nodes with containers near their memory limit.
+	limitRisks []*kubecap.NodeReport
+
+	// anomalies are the nodes whose allocatable memory deviates from their
+	// peers'.
+	anomalies []*kubecap.NodeReport
+
+	// priorityNodes are the nodes with requests broken down by
+	// PriorityClass.
+	priorityNodes []*kubecap.NodeReport
+
+	// unmatched are the nodes with running pods without pod metrics.
+	unmatched []*kubecap.NodeReport
+
+	// stuck are the nodes with pods terminating long past their grace
+	// period.
+	stuck []*kubecap.NodeReport
+
+	// overcommitted are the nodes requesting more than their allocatable
+	// amount.
+	overcommitted []*kubecap.NodeReport
+
+	// cordoned are the cordoned nodes, when reported.
+	cordoned []*kubecap.NodeReport
+
+	// reallocated are the nodes whose allocatable or capacity amounts
+	// changed recently.
+	reallocated []*kubecap.NodeReport
+
+	// nodes are kept for the leaderboard, pod shapes and cost centers.
+	nodes []*kubecap.NodeReport
+}
+
+func newTableOutput(w io.Writer, additionalAmountStr string, showCluster bool) *tableOutput {
+	t := &tableOutput{
+		w:                   w,
+		additionalAmountStr: additionalAmountStr,
+		showCluster:         showCluster,
+		nodeTable:           tablewriter.NewWriter(w),
+		evictableTable:      tablewriter.NewWriter(w),
+	}
+
+	return t
+}
+
+// memoryColumns returns the RSS, cache and swap columns of the memory stats,
+// or dashes for containers cAdvisor didn't report on.
+func memoryColumns(m *kubecap.MemoryStats) []string {
+	if m == nil {
+		return []string{"-", "-", "-"}
+	}
+
+	return []string{humanize.Comma(m.RSS), humanize.Comma(m.Cache), humanize.Comma(m.Swap)}
+}
+
+// cpuColumns returns the CPU requests, usage and whether it is over its
+// requests of a container, or dashes for containers without CPU usage.
+func cpuColumns(c *kubecap.ContainerCPU) []string {
+	if c == nil {
+		return []string{"-", "-", "-"}
+	}
+
+	return []string{humanize.Comma(c.Requests) + "m", humanize.Comma(c.Used) + "m", fmt.Sprintf("%t", c.OverRequest)}
+}
+
+// evictableHeader returns the evictable table's header, with the memory
+// breakdown and CPU columns when collected for md.
+func (t *tableOutput) evictableHeader(md *kubecap.Metadata) []string {
+	header := []string{
+		"Node",
+		"Namespace",
+		"Pod",
+		"Container",
+		"Requests",
+		"Used",
+		"Limits",
+	}
+
+	if md.MemoryBreakdown() {
+		header = append(header, "RSS", "Cache", "Swap")
+	}
+
+	if md.ContainerCPU() {
+		header = append(header, "CPU Requests", "CPU Used", "CPU Over?")
+	}
+
+	return clusterColumn(t.showCluster, "Cluster", header)
+}
+
+// nodeHeader returns the node table's header, including the optional columns
+// collected for md.
+func (t *tableOutput) nodeHeader(md *kubecap.Metadata) []string {
+	header := []string{
+		"Name",
+		"Allocatable",
+		"Used",
+		"Free",
+		"Requsts",
+		"Efficiency",
+		"Schedulable",
+		fmt.Sprintf("Free - %s", t.additionalAmountStr),
+		fmt.Sprintf("Schedulable - %s", t.additionalAmountStr),
+		"Ok?",
+		"Pressure",
+	}
+
+	if t.wide {
+		header = append(header, "Kubelet", "Age", "Instance Type", "Pods")
+	}
+
+	if md.PodChurnWindow > 0 {
+		header = append(header, fmt.Sprintf("Pod Churn %s (Started/Stopped)", md.PodChurnWindow))
+	}
+
+	if md.CPUManager {
+		header = append(header, "Exclusive CPUs (Pinned/Available)", "Shared CPUs")
+	}
+
+	if md.NUMA {
+		header = append(header, "NUMA Largest Available")
+	}
+
+	if md.PSI {
+		header = append(header, "Memory PSI Some", "Memory PSI Full", "CPU PSI Some")
+	}
+
+	if md.CheckReserved {
+		header = append(header, "System Used/Reserved")
+	}
+
+	if md.DRA {
+		header = append(header, "Devices (Free/Total)")
+	}
+
+	if md.VolumeAttach {
+		header = append(header, "Volumes (Attached/Limit)")
+	}
+
+	if md.GPUMetricsURL != "" {
+		header = append(header, "GPUs (Requested/Allocatable)", "GPU Util")
+	}
+
+	if len(md.Enrichers) > 0 {
+		header = append(header, "Attributes")
+	}
+
+	if len(md.Reservations) > 0 {
+		header = append(header, "Reserved")
+	}
+
+	for _, c := range md.Columns {
+		header = append(header, c.Name)
+	}
+
+	return clusterColumn(t.showCluster, "Cluster", header)
+}
+
+func (t *tableOutput) Metadata(m *kubecap.Metadata) error {
+	t.md = m
+
+	return nil
+}
+
+// dashIfEmpty returns s, or - when it is empty.
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// nodeAge formats the node's age at the time of the report as kubectl does.
+func nodeAge(created time.Time, md *kubecap.Metadata) string {
+	if created.IsZero() {
+		return "-"
+	}
+
+	now := time.Now()
+	if md != nil && !md.Timestamp.IsZero() {
+		now = md.Timestamp
+	}
+
+	return duration.HumanDuration(now.Sub(created))
+}
+
+// psiColumn formats the 10s pressure stall average as a percentage.
+func psiColumn(stats *kubecap.PSIStats, full bool) string {
+	if stats == nil {
+		return "-"
+	}
+
+	if full {
+		return humanize.FormatFloat("#.##", stats.Full.Avg10) + "%"
+	}
+
+	return humanize.FormatFloat("#.##", stats.Some.Avg10) + "%"
+}
+
+// churnNodes lists the nodes added and removed, if any.
+func churnNodes(c *kubecap.ChurnReport) string {
+	s := ""
+	if len(c.Added) > 0 {
+		s += " (+" + strings.Join(c.Added, ", +")
+	}
+
+	if len(c.Removed) > 0 {
+		if s == "" {
+			s += " ("
+		} else {
+			s += ", "
+		}
+
+		s += "-" + strings.Join(c.Removed, ", -")
+	}
+
+	if s != "" {
+		s += ")"
+	}
+
+	return s
+}
+
+// okColumn is whether the node has enough headroom, or NotReady, or - when
+// it is out of the additional amount's scope.
+func okColumn(n *kubecap.NodeReport) string {
+	if n.NotReady {
+		return "NotReady"
+	}
+
+	if n.OutOfScope {
+		return "-"
+	}
+
+	return fmt.Sprintf("%t", n.Ok)
+}
+
+// coveragePercent formats a coverage fraction as a percentage.
+func coveragePercent(f float64) string {
+	return humanize.FormatFloat("#.#", f*100) + "%"
+}
+
+// numaColumn formats the most memory available on one of the node's NUMA
+// nodes, which is unknown without NodeResourceTopology.
+func numaColumn(r *kubecap.NUMAReport) string {
+	if r == nil || r.LargestAvailable < 0 {
+		return "-"
+	}
+
+	return humanize.IBytes(uint64(r.LargestAvailable))
+}
+
+// reservedColumn formats the system usage against the reservation.
+func reservedColumn(r *kubecap.ReservedReport) string {
+	if r == nil {
+		return "-"
+	}
+
+	return fmt.Sprintf("%s/%s %s", humanize.IBytes(nonNegative(r.SystemUsed)), humanize.IBytes(nonNegative(r.Reserved)), r.Verdict)
+}
+
+// devicesColumn formats the node's devices as driver free/total.
+func devicesColumn(devices []*kubecap.DeviceReport) string {
+	if len(devices) == 0 {
+		return "-"
+	}
+
+	free := map[string]int64{}
+	total := map[string]int64{}
+	drivers := []string{}
+
+	for _, d := range devices {
+		if _, ok := total[d.Driver]; !ok {
+			drivers = append(drivers, d.Driver)
+		}
+
+		free[d.Driver] += d.Free()
+		total[d.Driver] += d.Total
+	}
+
+	cols := []string{}
+	for _, driver := range drivers {
+		cols = append(cols, fmt.Sprintf("%s %d/%d", driver, free[driver], total[driver]))
+	}
+
+	return strings.Join(cols, ", ")
+}
+
+// gpuColumns formats the node's GPUs as requested/allocatable and their mean
+// utilization, - without samples.
+func gpuColumns(gpu *kubecap.GPUReport) []string {
+	if gpu == nil {
+		return []string{"-", "-"}
+	}
+
+	util := "-"
+	if gpu.Sampled > 0 {
+		util = fmt.Sprintf("%.0f%%", gpu.Utilization)
+	}
+
+	return []string{fmt.Sprintf("%d/%d", gpu.Requested, gpu.Allocatable), util}
+}
+
+// reservingColumn is the amount the node's capacity reservations take and
+// their names.
+func reservingColumn(n *kubecap.NodeReport) string {
+	if len(n.Reservations) == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%s (%s)", humanize.Comma(n.Reserving), strings.Join(n.Reservations, ", "))
+}
+
+// attributesColumn formats the node's enriched attributes as sorted
+// name=value pairs.
+func attributesColumn(attributes map[string]string) string {
+	if len(attributes) == 0 {
+		return "-"
+	}
+
+	cols := make([]string, 0, len(attributes))
+	for name, value := range attributes {
+		cols = append(cols, name+"="+value)
+	}
+
+	sort.Strings(cols)
+
+	return strings.Join(cols, ", ")
+}
+
+// volumesColumn formats the node's volume attachments as driver
+// attached/limit, flagging drivers without room for another volume.
+func volumesColumn(volumes []*kubecap.VolumeAttachReport) string {
+	if len(volumes) == 0 {
+		return "-"
+	}
+
+	cols := []string{}
+	for _, v := range volumes {
+		limit := "-"
+		if v.Limit > 0 {
+			limit = fmt.Sprintf("%d", v.Limit)
+		}
+
+		col := fmt.Sprintf("%s %d/%s", v.Driver, v.Attached, limit)
+		if v.Full() {
+			col += " (full)"
+		}
+
+		cols = append(cols, col)
+	}
+
+	return strings.Join(cols, ", ")
+}
+
+// jobBacklogSummary describes the backlog and its estimate in a sentence.
+func jobBacklogSummary(r *kubecap.JobBacklogReport) string {
+	s := fmt.Sprintf("%d queued Jobs matching %q request %s (cluster schedulable %s); ", r.Jobs, r.Selector, humanize.Comma(r.Requests), humanize.Comma(r.Schedulable))
+
+	switch {
+	case r.Estimate >= 0:
+		return s + fmt.Sprintf("about %s in %d waves at the %s average runtime of %d completed Jobs", r.Estimate, r.Waves, r.AverageRuntime.Round(time.Second), r.Completed)
+	case r.Completed == 0:
+		return s + "no completed Jobs to estimate the runtime from"
+	default:
+		return s + "no headroom to run them in"
+	}
+}
+
+func (t *tableOutput) Node(n *kubecap.NodeReport) error {
+	row := []string{
+		n.Name,
+		humanize.Comma(n.Allocatable),
+		humanize.Comma(n.Used),
+		humanize.Comma(n.Free),
+		humanize.Comma(n.Requests),
+		humanize.FormatFloat("#.##", n.Efficiency),
+		humanize.Comma(n.Schedulable),
+		humanize.Comma(n.FreeWithAdditional),
+		humanize.Comma(n.SchedulableWithAdditional),
+		okColumn(n),
+		humanize.FormatFloat("#.", n.Pressure),
+	}
+
+	if t.wide {
+		row = append(row, dashIfEmpty(n.KubeletVersion), nodeAge(n.Created, t.md), dashIfEmpty(n.InstanceType), fmt.Sprintf("%d/%d", n.PodCount, n.PodCapacity))
+	}
+
+	if t.md != nil && t.md.PodChurnWindow > 0 {
+		if c := n.PodChurn; c != nil {
+			row = append(row, fmt.Sprintf("%d/%d", c.Starts, c.Stops))
+		} else {
+			row = append(row, "-")
+		}
+	}
+
+	if t.md != nil && t.md.CPUManager {
+		if c := n.CPUManager; c != nil {
+			row = append(row, fmt.Sprintf("%d/%d", c.Exclusive, c.AvailableExclusive), fmt.Sprintf("%d", c.SharedPool))
+		} else {
+			row = append(row, "-", "-")
+		}
+	}
+
+	if t.md != nil && t.md.NUMA {
+		row = append(row, numaColumn(n.NUMA))
+	}
+
+	if t.md != nil && t.md.PSI {
+		psi := n.PSI
+		if psi == nil {
+			psi = &kubecap.PSIReport{}
+		}
+
+		row = append(row, psiColumn(psi.Memory, false), psiColumn(psi.Memory, true), psiColumn(psi.CPU, false))
+	}
+
+	if t.md != nil && t.md.CheckReserved {
+		row = append(row, reservedColumn(n.Reserved))
+	}
+
+	if t.md != nil && t.md.DRA {
+		row = append(row, devicesColumn(n.Devices))
+	}
+
+	if t.md != nil && t.md.VolumeAttach {
+		row = append(row, volumesColumn(n.Volumes))
+	}
+
+	if t.md != nil && t.md.GPUMetricsURL != "" {
+		row = append(row, gpuColumns(n.GPU)...)
+	}
+
+	if t.md != nil && len(t.md.Enrichers) > 0 {
+		row = append(row, attributesColumn(n.Attributes))
+	}
+
+	if t.md != nil && len(t.md.Reservations) > 0 {
+		row = append(row, reservingColumn(n))
+	}
+
+	if t.md != nil {
+		for _, c := range t.md.Columns {
+			row = append(row, humanize.CommafWithDigits(n.Columns[c.Name], 2))
+		}
+	}
+
+	t.nodeRows = append(t.nodeRows, clusterColumn(t.showCluster, n.Cluster, row))
+
+	if len(n.LimitRisks) > 0 {
+		t.limitRisks = append(t.limitRisks, n)
+	}
+
+	if len(n.StuckTerminating) > 0 {
+		t.stuck = append(t.stuck, n)
+	}
+
+	if n.Overcommitted != nil {
+		t.overcommitted = append(t.overcommitted, n)
+	}
+
+	if n.Cordon != nil {
+		t.cordoned = append(t.cordoned, n)
+	}
+
+	if len(n.AllocatableChanges) > 0 {
+		t.reallocated = append(t.reallocated, n)
+	}
+
+	if n.Unmatched > 0 {
+		t.unmatched = append(t.unmatched, n)
+	}
+
+	if n.AllocatableAnomaly != nil {
+		t.anomalies = append(t.anomalies, n)
+	}
+
+	if len(n.PriorityClasses) > 0 {
+		t.priorityNodes = append(t.priorityNodes, n)
+	}
+
+	if t.md != nil && (t.md.Leaderboard > 0 || t.md.Shapes || t.md.CostCenters != nil || t.md.RightSizes != nil || len(t.md.TaintPoolKeys) > 0 || len(t.md.CustomReports) > 0) {
+		t.nodes = append(t.nodes, n)
+	}
+
+	return nil
+}
+
+func (t *tableOutput) Evictable(e *kubecap.EvictableContainer) error {
+	row := []string{
+		e.Node,
+		e.Namespace,
+		e.Pod,
+		e.Container,
+		humanize.Comma(e.Requests),
+		humanize.Comma(e.Used),
+		humanize.Comma(e.Limits),
+	}
+
+	if t.md != nil && t.md.MemoryBreakdown() {
+		row = append(row, memoryColumns(e.Memory)...)
+	}
+
+	if t.md != nil && t.md.ContainerCPU() {
+		row = append(row, cpuColumns(e.CPU)...)
+	}
+
+	t.evictableTable.Append(clusterColumn(t.showCluster, e.Cluster, row))
+
+	return nil
+}
+
+func (t *tableOutput) Flush() error {
+	if t.md != nil {
+		fmt.Fprintf(t.w, "Collected: %s\n", t.md.Timestamp.Format(time.RFC3339))
+		fmt.Fprintf(t.w, "Context: %s (cluster %s)\n", t.md.Context, t.md.Cluster)
+		fmt.Fprintf(t.w, "Server Version: %s\n", t.md.ServerVersion)
+		if t.md.AdditionalPercent != 0 {
+			fmt.Fprintf(t.w, "Additional: %s of each node's allocatable\n", t.md.AdditionalInput)
+		} else {
+			fmt.Fprintf(t.w, "Additional: %s (%s %s)\n", t.md.AdditionalInput, humanize.Comma(t.md.Additional), t.md.Unit())
+		}
+
+		if t.md.In != "" {
+			fmt.Fprintf(t.w, "In: %s\n", t.md.In)
+		}
+
+		if c := t.md.Churn; c != nil {
+			fmt.Fprintf(t.w, "Churn: %d added, %d removed since the last report%s\n", len(c.Added), len(c.Removed), churnNodes(c))
+		}
+
+		if len(t.md.StateWeights) > 0 {
+			fmt.Fprintf(t.w, "Requests Counted: %s pending, %s terminating\n", coveragePercent(t.md.StateWeight(kubecap.PodStatePending)), coveragePercent(t.md.StateWeight(kubecap.PodStateTerminating)))
+		}
+
+		if q := t.md.Quota; q != nil {
+			headroom := "unlimited"
+			if q.Headroom >= 0 {
+				headroom = humanize.Comma(q.Headroom)
+			}
+
+			fmt.Fprintf(t.w, "Namespace: %s (quota headroom %s)\n", q.Namespace, headroom)
+			fmt.Fprintf(t.w, "Blocked By: %s\n", q.Blocker)
+		}
+
+		if f := t.md.Scheduler; f != nil {
+			fmt.Fprintf(t.w, "Scheduler: %s\n", f.String())
+		}
+
+		if c := t.md.Coverage; c != nil {
+			fmt.Fprintf(t.w, "Requests Coverage: memory %s, CPU %s of %d containers\n", coveragePercent(c.Memory()), coveragePercent(c.CPU()), c.Containers)
+		}
+
+		if t.md.ClusterOk != nil {
+			fmt.Fprintf(t.w, "Cluster Ok: %t (%s)\n", *t.md.ClusterOk, t.md.Verdicts.Cluster)
+		}
+
+		if len(t.md.Degraded) > 0 {
+			fmt.Fprintf(t.w, "Degraded: skipped %s to stay within the budget\n", strings.Join(t.md.Degraded, ", "))
+		}
+
+		fmt.Fprintln(t.w)
+	}
+
+	// The header depends on the optional columns collected and is only set
+	// once: tablewriter appends to it.
+	md := t.md
+	if md == nil {
+		md = &kubecap.Metadata{}
+	}
+
+	fmt.Fprintln(t.w, "Node Report")
+	if t.pager != nil {
+		t.pager.render(t.w, t.nodeHeader(md), t.nodeRows)
+	} else {
+		t.nodeTable.SetHeader(t.nodeHeader(md))
+		t.nodeTable.AppendBulk(t.nodeRows)
+		t.nodeTable.Render()
+	}
+
+	fmt.Fprintln(t.w, "Evictable Pods Report")
+	t.evictableTable.SetHeader(t.evictableHeader(md))
+	t.evictableTable.Render()
+
+	if t.md != nil && t.md.Quota != nil && len(t.md.Quota.Quotas) > 0 {
+		quotaTable := tablewriter.NewWriter(t.w)
+		quotaTable.SetHeader([]string{
+			"ResourceQuota",
+			"Resource",
+			"Hard",
+			"Used",
+			"Headroom",
+		})
+
+		for _, qm := range t.md.Quota.Quotas {
+			quotaTable.Append([]string{
+				qm.Name,
+				qm.Resource,
+				humanize.Comma(qm.Hard),
+				humanize.Comma(qm.Used),
+				humanize.Comma(qm.Hard - qm.Used),
+			})
+		}
+
+		fmt.Fprintln(t.w, "Namespace Quota Report")
+		quotaTable.Render()
+	}
+
+	if t.md != nil && t.md.Kueue != nil && len(t.md.Kueue.ClusterQueues) > 0 {
+		kueueTable := tablewriter.NewWriter(t.w)
+		kueueTable.SetHeader([]string{
+			"ClusterQueue",
+			"Nominal",
+			"Reserved",
+			"Pending Workloads",
+			"Pending",
+			"Blocked By",
+		})
+
+		for _, cq := range t.md.Kueue.ClusterQueues {
+			kueueTable.Append([]string{
+				cq.Name,
+				humanize.Comma(cq.Nominal),
+				humanize.Comma(cq.Reserved),
+				fmt.Sprintf("%d", cq.PendingWorkloads),
+				humanize.Comma(cq.Pending),
+				cq.Blocker,
+			})
+		}
+
+		fmt.Fprintf(t.w, "Kueue Backlog Report (cluster schedulable %s)\n", humanize.Comma(t.md.Kueue.Schedulable))
+		kueueTable.Render()
+	}
+
+	if t.md != nil && t.md.JobBacklog != nil {
+		fmt.Fprintln(t.w, "Job Backlog Report")
+		fmt.Fprintln(t.w, jobBacklogSummary(t.md.JobBacklog))
+	}
+
+	if t.md != nil && t.md.Autoscaler != nil && len(t.md.Autoscaler.NodeGroups) > 0 {
+		autoscalerTable := tablewriter.NewWriter(t.w)
+		autoscalerTable.SetHeader([]string{
+			"Autoscaler Node Group",
+			"Group",
+			"Health",
+			"Ready",
+			"Target",
+			"Min",
+			"Max",
+			"Schedulable",
+			"Full",
+		})
+
+		for _, ng := range t.md.Autoscaler.NodeGroups {
+			group, schedulable, full := "-", "-", "-"
+			if ng.Group != "" {
+				group = ng.Group
+				schedulable = humanize.Comma(ng.Schedulable)
+				full = fmt.Sprintf("%t", ng.Full)
+			}
+
+			autoscalerTable.Append([]string{
+				ng.Name,
+				group,
+				ng.Health,
+				fmt.Sprintf("%d", ng.Ready),
+				fmt.Sprintf("%d", ng.Target),
+				fmt.Sprintf("%d", ng.Min),
+				fmt.Sprintf("%d", ng.Max),
+				schedulable,
+				full,
+			})
+		}
+
+		fmt.Fprintln(t.w, "Autoscaler Report")
+		autoscalerTable.Render()
+	}
+
+	if c := t.md.CoverageReport(); c != nil && !c.Complete() {
+		coverageTable := tablewriter.NewWriter(t.w)
+		coverageTable.SetHeader([]string{
+			"Namespace",
+			"Pods",
+			"Containers",
+			"Memory Requests",
+			"CPU Requests",
+		})
+
+		row := func(namespace string, c *kubecap.Coverage) {
+			coverageTable.Append([]string{
+				namespace,
+				fmt.Sprintf("%d", c.Pods),
+				fmt.Sprintf("%d", c.Containers),
+				coveragePercent(c.Memory()),
+				coveragePercent(c.CPU()),
+			})
+		}
+
+		// Only the namespaces lacking requests are listed.
+		for _, nc := range c.Namespaces {
+			if !nc.Complete() {
+				row(nc.Namespace, &nc.Coverage)
+			}
+		}
+
+		row("TOTAL", &c.Coverage)
+
+		fmt.Fprintln(t.w, "Requests Coverage Report")
+		coverageTable.Render()
+	}
+
+	if t.md != nil && t.md.Leaderboard > 0 {
+		for _, byWorkload := range []bool{false, true} {
+			board := kubecap.Leaderboard(t.nodes, byWorkload, t.md.Leaderboard)
+			if len(board) == 0 {
+				continue
+			}
+
+			boardTable := tablewriter.NewWriter(t.w)
+			boardTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+				"Namespace",
+				"Kind",
+				"Name",
+				"Pods",
+				"Requests",
+				"Used",
+				"Efficiency",
+				"Idle",
+			}))
+
+			for _, e := range board {
+				boardTable.Append(clusterColumn(t.showCluster, e.Cluster, []string{
+					e.Namespace,
+					e.Kind,
+					e.Name,
+					fmt.Sprintf("%d", e.Pods),
+					humanize.Comma(e.Requests),
+					humanize.Comma(e.Used),
+					humanize.FormatFloat("#.##", e.Efficiency),
+					humanize.Comma(e.Idle),
+				}))
+			}
+
+			if byWorkload {
+				fmt.Fprintln(t.w, "Workload Efficiency Leaderboard")
+			} else {
+				fmt.Fprintln(t.w, "Pod Efficiency Leaderboard")
+			}
+			boardTable.Render()
+		}
+
+		if board := kubecap.GPULeaderboard(t.nodes, t.md.Leaderboard); len(board) > 0 {
+			gpuTable := tablewriter.NewWriter(t.w)
+			gpuTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+				"Namespace",
+				"Kind",
+				"Name",
+				"Pods",
+				"GPUs",
+				"Utilization",
+				"Idle GPUs",
+			}))
+
+			for _, e := range board {
+				gpuTable.Append(clusterColumn(t.showCluster, e.Cluster, []string{
+					e.Namespace,
+					e.Kind,
+					e.Name,
+					fmt.Sprintf("%d", e.Pods),
+					fmt.Sprintf("%d", e.Requested),
+					fmt.Sprintf("%.0f%%", e.Utilization),
+					humanize.FormatFloat("#.##", e.Idle),
+				}))
+			}
+
+			fmt.Fprintln(t.w, "GPU Efficiency Leaderboard")
+			gpuTable.Render()
+		}
+	}
+
+	if t.md != nil && t.md.CostCenters != nil {
+		costTable := tablewriter.NewWriter(t.w)
+		costTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Cost Center",
+			"Pods",
+			"Requests",
+			"Used",
+		}))
+
+		for _, c := range kubecap.CostCenterReports(t.nodes) {
+			costTable.Append(clusterColumn(t.showCluster, c.Cluster, []string{
+				dashIfEmpty(c.CostCenter),
+				fmt.Sprintf("%d", c.Pods),
+				humanize.Comma(c.Requests),
+				humanize.Comma(c.Used),
+			}))
+		}
+
+		fmt.Fprintln(t.w, "Cost Center Report")
+		costTable.Render()
+	}
+
+	if t.md != nil && t.md.Shapes {
+		shapeTable := tablewriter.NewWriter(t.w)
+		shapeTable.SetHeader(clusterColumn(t.showCluster, "Cluster", append(append([]string{
+			"Node Group",
+			"Nodes",
+		}, kubecap.ShapeBuckets...), "Largest")))
+
+		for _, s := range kubecap.PodShapes(t.nodes) {
+			row := []string{dashIfEmpty(s.Group), fmt.Sprintf("%d", s.Nodes)}
+			for _, pods := range s.Pods {
+				row = append(row, fmt.Sprintf("%d", pods))
+			}
+
+			shapeTable.Append(clusterColumn(t.showCluster, s.Cluster, append(row, humanize.Comma(s.Largest))))
+		}
+
+		fmt.Fprintln(t.w, "Pod Shape Report")
+		shapeTable.Render()
+	}
+
+	if t.md != nil && t.md.RightSizes != nil {
+		sizeTable := tablewriter.NewWriter(t.w)
+		sizeTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Node Group",
+			"Node Size",
+			"Nodes",
+			"Waste",
+			"Packing",
+			"Tighter",
+		}))
+
+		percent := func(f float64) string {
+			return humanize.FormatFloat("#.#", f*100) + "%"
+		}
+
+		for _, r := range kubecap.RightSize(t.nodes, t.md.RightSizes) {
+			size := "-"
+			if r.Nodes > 0 {
+				size = humanize.Comma(r.Allocatable / r.Nodes)
+			}
+
+			sizeTable.Append(clusterColumn(t.showCluster, r.Cluster, []string{
+				dashIfEmpty(r.Group),
+				size + " (current)",
+				fmt.Sprintf("%d", r.Nodes),
+				humanize.Comma(r.Waste),
+				percent(r.Packing()),
+				"-",
+			}))
+
+			for _, c := range r.Candidates {
+				if !c.Fits {
+					sizeTable.Append(clusterColumn(t.showCluster, r.Cluster, []string{dashIfEmpty(r.Group), humanize.Comma(c.Size), "too small", "-", "-", "-"}))
+
+					continue
+				}
+
+				sizeTable.Append(clusterColumn(t.showCluster, r.Cluster, []string{
+					dashIfEmpty(r.Group),
+					humanize.Comma(c.Size),
+					fmt.Sprintf("%d", c.Nodes),
+					humanize.Comma(c.Waste),
+					percent(c.Packing),
+					fmt.Sprintf("%+.1f%%", c.Tighter),
+				}))
+			}
+		}
+
+		fmt.Fprintln(t.w, "Right-Sizing Report")
+		sizeTable.Render()
+	}
+
+	if t.md != nil && len(t.md.TaintPoolKeys) > 0 {
+		poolTable := tablewriter.NewWriter(t.w)
+		poolTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Taint Pool",
+			"Nodes",
+			"Allocatable",
+			"Requests",
+			"Schedulable",
+			"Ok Nodes",
+			"Ok?",
+		}))
+
+		for _, p := range kubecap.SummarizeTaintPools(t.nodes) {
+			poolTable.Append(clusterColumn(t.showCluster, p.Cluster, []string{
+				p.Pool,
+				fmt.Sprintf("%d", p.Nodes),
+				humanize.Comma(p.Allocatable),
+				humanize.Comma(p.Requests),
+				humanize.Comma(p.Schedulable),
+				fmt.Sprintf("%d", p.OkNodes),
+				fmt.Sprintf("%t", p.Ok),
+			}))
+		}
+
+		fmt.Fprintln(t.w, "Taint Pool Report")
+		poolTable.Render()
+	}
+
+	if t.md != nil {
+		for _, r := range t.md.CustomReports {
+			result, err := r.Evaluate(t.nodes)
+			if err != nil {
+				return err
+			}
+
+			customTable := tablewriter.NewWriter(t.w)
+			customTable.SetHeader(result.Columns)
+			customTable.AppendBulk(result.Rows)
+
+			fmt.Fprintln(t.w, result.Name)
+			customTable.Render()
+		}
+	}
+
+	if t.md != nil && len(t.md.ReservationStatus) > 0 {
+		reservationTable := tablewriter.NewWriter(t.w)
+		reservationTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Reservation",
+			"Amount",
+			"Replicas",
+			"Placed",
+			"Nodes",
+		}))
+
+		unplaced := 0
+
+		for _, r := range t.md.ReservationStatus {
+			nodes := []string{}
+			for name, replicas := range r.Nodes {
+				nodes = append(nodes, fmt.Sprintf("%s (%d)", name, replicas))
+			}
+
+			sort.Strings(nodes)
+
+			placed := fmt.Sprintf("%d", r.Placed)
+
+			switch {
+			case r.Expired:
+				placed = "expired"
+			case r.Amount > 0 && r.Placed < r.Replicas:
+				unplaced++
+			}
+
+			reservationTable.Append(clusterColumn(t.showCluster, r.Cluster, []string{
+				r.Name,
+				humanize.Comma(r.Amount),
+				fmt.Sprintf("%d", r.Replicas),
+				placed,
+				dashIfEmpty(strings.Join(nodes, ", ")),
+			}))
+		}
+
+		fmt.Fprintln(t.w, "Reservations Report")
+		fmt.Fprintf(t.w, "%d of %d reservations aren't fully placed\n", unplaced, len(t.md.ReservationStatus))
+		reservationTable.Render()
+	}
+
+	if t.md != nil && len(t.md.StandbyStatus) > 0 {
+		standbyTable := tablewriter.NewWriter(t.w)
+		standbyTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Priority Class",
+			"Priority",
+			"Amount",
+			"Replicas",
+			"Available",
+			"Preempted",
+			"Ok?",
+			"Nodes",
+		}))
+
+		missing := 0
+
+		for _, r := range t.md.StandbyStatus {
+			nodes := []string{}
+			for name, replicas := range r.Nodes {
+				nodes = append(nodes, fmt.Sprintf("%s (%d)", name, replicas))
+			}
+
+			sort.Strings(nodes)
+
+			priority := "-"
+			if r.Priority != nil {
+				priority = fmt.Sprintf("%d", *r.Priority)
+			}
+
+			if !r.Ok {
+				missing++
+			}
+
+			standbyTable.Append(clusterColumn(t.showCluster, r.Cluster, []string{
+				r.PriorityClass,
+				priority,
+				humanize.Comma(r.Amount),
+				fmt.Sprintf("%d", r.Replicas),
+				fmt.Sprintf("%d", r.Available),
+				humanize.Comma(r.Preempted),
+				fmt.Sprintf("%t", r.Ok),
+				dashIfEmpty(strings.Join(nodes, ", ")),
+			}))
+		}
+
+		fmt.Fprintln(t.w, "Standby Report")
+		fmt.Fprintf(t.w, "%d of %d standbys are missing\n", missing, len(t.md.StandbyStatus))
+		standbyTable.Render()
+	}
+
+	if t.md != nil && len(t.md.SLOStatus) > 0 {
+		sloTable := tablewriter.NewWriter(t.w)
+		sloTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"SLO",
+			"Scope",
+			"Schedulable",
+			"Schedulable %",
+			"Met?",
+			"Compliance",
+			"Error Budget",
+		}))
+
+		percent := func(f float64) string {
+			return humanize.FormatFloat("#.##", f*100) + "%"
+		}
+
+		for _, s := range t.md.SLOStatus {
+			compliance, budget := "-", "-"
+			if s.Samples > 0 {
+				compliance = fmt.Sprintf("%s (%d/%d)", percent(s.Compliance), s.Good, s.Samples)
+				budget = percent(s.ErrorBudget)
+			}
+
+			sloTable.Append(clusterColumn(t.showCluster, s.Cluster, []string{
+				s.SLO,
+				s.Scope,
+				humanize.Comma(s.Schedulable),
+				percent(s.Ratio),
+				fmt.Sprintf("%t", s.Met),
+				compliance,
+				budget,
+			}))
+		}
+
+		fmt.Fprintln(t.w, "SLO Report")
+		sloTable.Render()
+	}
+
+	if len(t.priorityNodes) > 0 {
+		priorityTable := tablewriter.NewWriter(t.w)
+		priorityTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Node",
+			"Priority Class",
+			"Priority",
+			"Pods",
+			"Requests",
+			"Share of Requests",
+		}))
+
+		row := func(cluster, node string, pc *kubecap.PriorityClassRequests, requests int64) {
+			share := "-"
+			if requests > 0 {
+				share = humanize.FormatFloat("#.##", float64(pc.Requests)/float64(requests)*100) + "%"
+			}
+
+			priorityTable.Append(clusterColumn(t.showCluster, cluster, []string{
+				node,
+				pc.Name,
+				fmt.Sprintf("%d", pc.Priority),
+				fmt.Sprintf("%d", pc.Pods),
+				humanize.Comma(pc.Requests),
+				share,
+			}))
+		}
+
+		for _, n := range t.priorityNodes {
+			for _, pc := range n.PriorityClasses {
+				row(n.Cluster, n.Name, pc, n.Requests)
+			}
+		}
+
+		total := kubecap.Summarize(t.priorityNodes).Requests
+		for _, pc := range kubecap.SumPriorityClasses(t.priorityNodes) {
+			row("", "TOTAL", pc, total)
+		}
+
+		fmt.Fprintln(t.w, "Priority Class Report")
+		priorityTable.Render()
+	}
+
+	if len(t.limitRisks) > 0 {
+		header := []string{
+			"Node",
+			"Namespace",
+			"Pod",
+			"Container",
+			"Used",
+			"Limits",
+			"Used/Limits",
+		}
+
+		if md.MemoryBreakdown() {
+			header = append(header, "RSS", "Cache", "Swap")
+		}
+
+		if md.ContainerCPU() {
+			header = append(header, "CPU Requests", "CPU Used", "CPU Over?")
+		}
+
+		limitTable := tablewriter.NewWriter(t.w)
+		limitTable.SetHeader(clusterColumn(t.showCluster, "Cluster", header))
+
+		for _, n := range t.limitRisks {
+			for _, r := range n.LimitRisks {
+				container := r.Container
+				if container == "" {
+					container = "-"
+				}
+
+				row := []string{
+					n.Name,
+					r.Namespace,
+					r.Pod,
+					container,
+					humanize.Comma(r.Used),
+					humanize.Comma(r.Limits),
+					humanize.FormatFloat("#.##", r.Ratio*100) + "%",
+				}
+
+				if md.MemoryBreakdown() {
+					row = append(row, memoryColumns(r.Memory)...)
+				}
+
+				if md.ContainerCPU() {
+					row = append(row, cpuColumns(r.CPU)...)
+				}
+
+				limitTable.Append(clusterColumn(t.showCluster, n.Cluster, row))
+			}
+		}
+
+		fmt.Fprintln(t.w, "Near Memory Limit (OOM Kill Risk) Report")
+		limitTable.Render()
+	}
+
+	if len(t.unmatched) > 0 {
+		unmatchedTable := tablewriter.NewWriter(t.w)
+		unmatchedTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Node",
+			"Pods Without Metrics",
+			"Pods",
+		}))
+
+		for _, n := range t.unmatched {
+			pods := "-"
+			if len(n.UnmatchedPods) > 0 {
+				pods = strings.Join(n.UnmatchedPods, ", ")
+			}
+
+			unmatchedTable.Append(clusterColumn(t.showCluster, n.Cluster, []string{
+				n.Name,
+				fmt.Sprintf("%d", n.Unmatched),
+				pods,
+			}))
+		}
+
+		fmt.Fprintln(t.w, "Unmatched Pod Metrics Report")
+		fmt.Fprintln(t.w, "Usage and efficiency leave these pods out.")
+		unmatchedTable.Render()
+	}
+
+	if len(t.stuck) > 0 {
+		stuckTable := tablewriter.NewWriter(t.w)
+		stuckTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Node",
+			"Namespace",
+			"Pod",
+			"Requests",
+			"Overdue",
+			"Finalizers",
+		}))
+
+		var pinned int64
+		pods := 0
+
+		for _, n := range t.stuck {
+			for _, p := range n.StuckTerminating {
+				finalizers := "-"
+				if len(p.Finalizers) > 0 {
+					finalizers = strings.Join(p.Finalizers, ", ")
+				}
+
+				stuckTable.Append(clusterColumn(t.showCluster, n.Cluster, []string{
+					n.Name,
+					p.Namespace,
+					p.Name,
+					humanize.Comma(p.Requests),
+					p.Overdue.Round(time.Second).String(),
+					finalizers,
+				}))
+			}
+
+			// Each node's total is what cleaning its stuck pods up
+			// would free.
+			if len(n.StuckTerminating) > 1 {
+				stuckTable.Append(clusterColumn(t.showCluster, n.Cluster, []string{
+					n.Name,
+					"",
+					"TOTAL",
+					humanize.Comma(n.StuckRequests),
+					"",
+					"",
+				}))
+			}
+
+			pinned += n.StuckRequests
+			pods += len(n.StuckTerminating)
+		}
+
+		fmt.Fprintln(t.w, "Stuck Terminating Pods Report")
+		fmt.Fprintf(t.w, "%d pods pin %s %s on %d nodes\n", pods, humanize.Comma(pinned), t.md.Unit(), len(t.stuck))
+		stuckTable.Render()
+	}
+
+	if len(t.overcommitted) > 0 {
+		overcommitTable := tablewriter.NewWriter(t.w)
+		overcommitTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Node",
+			"Namespace",
+			"Pod",
+			"Requests",
+			"Cause",
+		}))
+
+		for _, n := range t.overcommitted {
+			o := n.Overcommitted
+
+			for _, p := range o.Pods {
+				cause := "-"
+				switch {
+				case p.Scheduler != "":
+					cause = p.Cause + " " + p.Scheduler
+				case p.Cause != "":
+					cause = p.Cause
+				}
+
+				overcommitTable.Append(clusterColumn(t.showCluster, n.Cluster, []string{
+					n.Name,
+					p.Namespace,
+					p.Name,
+					humanize.Comma(p.Requests),
+					cause,
+				}))
+			}
+
+			overcommitTable.Append(clusterColumn(t.showCluster, n.Cluster, []string{
+				n.Name,
+				"",
+				"EXCESS",
+				fmt.Sprintf("%s of %s", humanize.Comma(o.Excess), humanize.Comma(o.Allocatable)),
+				"",
+			}))
+		}
+
+		fmt.Fprintln(t.w, "Overcommitted Nodes Report")
+		fmt.Fprintf(t.w, "%d nodes request more than their allocatable amount: their schedulable amounts are meaningless\n", len(t.overcommitted))
+		overcommitTable.Render()
+	}
+
+	if len(t.cordoned) > 0 {
+		// The longest cordoned nodes are the likeliest to be forgotten.
+		sort.SliceStable(t.cordoned, func(i, j int) bool {
+			a, b := t.cordoned[i].Cordon.Since, t.cordoned[j].Cordon.Since
+			if a.IsZero() != b.IsZero() {
+				return b.IsZero()
+			}
+
+			return a.Before(b)
+		})
+
+		cordonTable := tablewriter.NewWriter(t.w)
+		cordonTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Node",
+			"Cordoned For",
+			"Since",
+			"Allocatable",
+			"Held",
+		}))
+
+		for _, n := range t.cordoned {
+			since := "-"
+			if !n.Cordon.Since.IsZero() {
+				since = n.Cordon.Since.Format(time.RFC3339)
+			}
+
+			cordonTable.Append(clusterColumn(t.showCluster, n.Cluster, []string{
+				n.Name,
+				nodeAge(n.Cordon.Since, t.md),
+				since,
+				humanize.Comma(n.Allocatable),
+				humanize.Comma(n.Cordon.Held),
+			}))
+		}
+
+		s := kubecap.SummarizeCordons(t.cordoned)
+
+		fmt.Fprintln(t.w, "Cordoned Nodes Report")
+		fmt.Fprintf(t.w, "%d cordoned nodes hold %s of %s allocatable %s\n", s.Nodes, humanize.Comma(s.Held), humanize.Comma(s.Allocatable), t.md.Unit())
+		cordonTable.Render()
+	}
+
+	if len(t.reallocated) > 0 {
+		changeTable := tablewriter.NewWriter(t.w)
+		changeTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Node",
+			"Changed",
+			"Of",
+			"Resource",
+			"From",
+			"To",
+			"Shrank?",
+		}))
+
+		shrank := 0
+
+		for _, n := range t.reallocated {
+			for _, c := range n.AllocatableChanges {
+				changeTable.Append(clusterColumn(t.showCluster, n.Cluster, []string{
+					n.Name,
+					c.At.Format(time.RFC3339),
+					c.Of,
+					c.Resource,
+					dashIfEmpty(c.From),
+					dashIfEmpty(c.To),
+					fmt.Sprintf("%t", c.Shrank),
+				}))
+
+				if c.Shrank && c.Of == "allocatable" {
+					shrank++
+				}
+			}
+		}
+
+		fmt.Fprintln(t.w, "Allocatable Changes Report")
+		fmt.Fprintf(t.w, "%d nodes changed in the last %s, %d allocatable amounts shrank\n", len(t.reallocated), t.md.AllocatableChanges, shrank)
+		changeTable.Render()
+	}
+
+	if len(t.anomalies) > 0 {
+		anomalyTable := tablewriter.NewWriter(t.w)
+		anomalyTable.SetHeader(clusterColumn(t.showCluster, "Cluster", []string{
+			"Node",
+			"Group",
+			"Allocatable",
+			"Peer Median",
+			"Deviation",
+			"Peers",
+		}))
+
+		for _, n := range t.anomalies {
+			a := n.AllocatableAnomaly
+
+			anomalyTable.Append(clusterColumn(t.showCluster, n.Cluster, []string{
+				n.Name,
+				n.Group,
+				humanize.Comma(n.Allocatable),
+				humanize.Comma(a.PeerMedian),
+				humanize.FormatFloat("#.##", a.Deviation*100) + "%",
+				fmt.Sprintf("%d", a.Peers),
+			}))
+		}
+
+		fmt.Fprintln(t.w, "Allocatable Anomalies Report")
+		anomalyTable.Render()
+	}
+
+	return nil
+}
+
+// clusterColumn prepends cluster to row when show is set.
+func clusterColumn(show bool, cluster string, row []string) []string {
+	if !show {
+		return row
+	}
+
+	return append([]string{cluster}, row...)
+}
+
+// jsonlOutput writes one JSON object per line for the metadata and each node
+// and evictable container as soon as it is reported. The kind field
+// distinguishes them.
+type jsonlOutput struct {
+	enc *json.Encoder
+	md  *kubecap.Metadata
+
+	// nodes are kept for the leaderboard, pod shapes and cost centers.
+	nodes []*kubecap.NodeReport
+}
+
+type jsonlMetadata struct {
+	Kind string `json:"kind"`
+	*kubecap.Metadata
+}
+
+type jsonlNode struct {
+	Kind string `json:"kind"`
+	*kubecap.NodeReport
+}
+
+// jsonlQuota is written last since the blocker is only known once every node
+// has been reported.
+type jsonlQuota struct {
+	Kind string `json:"kind"`
+	*kubecap.QuotaReport
+}
+
+// jsonlCoverage is likewise written last since pods are listed after the
+// metadata is written.
+type jsonlCoverage struct {
+	Kind string `json:"kind"`
+	*kubecap.CoverageReport
+}
+
+// jsonlClusterVerdict is the cluster's verdict by the cluster rule, written
+// last since it is decided once all nodes are reported.
+type jsonlClusterVerdict struct {
+	Kind string `json:"kind"`
+	Ok   bool   `json:"ok"`
+	Rule string `json:"rule"`
+}
+
+// jsonlChurn is likewise written last since nodes are listed after the
+// metadata is written.
+type jsonlChurn struct {
+	Kind string `json:"kind"`
+	*kubecap.ChurnReport
+}
+
+// jsonlClusterQueue and jsonlAutoscalerNodeGroup are likewise written last.
+type jsonlClusterQueue struct {
+	Kind string `json:"kind"`
+	*kubecap.ClusterQueueReport
+}
+
+// jsonlJobBacklog is likewise written last since the estimate depends on
+// every node's headroom.
+type jsonlJobBacklog struct {
+	Kind string `json:"kind"`
+	*kubecap.JobBacklogReport
+}
+
+type jsonlAutoscalerNodeGroup struct {
+	Kind string `json:"kind"`
+	*kubecap.AutoscalerNodeGroup
+}
+
+// jsonlEfficiency is a leaderboard entry: its kind is podEfficiency or
+// workloadEfficiency.
+type jsonlEfficiency struct {
+	Kind string `json:"kind"`
+	*kubecap.EfficiencyEntry
+}
+
+// jsonlGPUEfficiency is a GPU leaderboard entry.
+type jsonlGPUEfficiency struct {
+	Kind string `json:"kind"`
+	*kubecap.GPUEfficiencyEntry
+}
+
+// jsonlRightSizing is a node group's right-sizing projection, also written
+// last.
+type jsonlRightSizing struct {
+	Kind string `json:"kind"`
+	*kubecap.RightSizing
+}
+
+// jsonlTaintPool is a taint pool's totals, also written last.
+type jsonlTaintPool struct {
+	Kind string `json:"kind"`
+	*kubecap.TaintPoolReport
+}
+
+// jsonlReservation is where a capacity reservation was placed, also written
+// last.
+type jsonlReservation struct {
+	Kind string `json:"kind"`
+	*kubecap.ReservationReport
+}
+
+// jsonlStandby is whether a standby exists, also written last.
+type jsonlStandby struct {
+	Kind string `json:"kind"`
+	*kubecap.StandbyReport
+}
+
+// jsonlSLO is an SLO's status in a scope, also written last.
+type jsonlSLO struct {
+	Kind string `json:"kind"`
+	*kubecap.SLOStatus
+}
+
+// jsonlCustomReport is a custom report's rows, also written last.
+type jsonlCustomReport struct {
+	Kind string `json:"kind"`
+	*kubecap.CustomReportResult
+}
+
+// jsonlShapes is a node group's pod shape histogram, also written last.
+type jsonlShapes struct {
+	Kind string `json:"kind"`
+	*kubecap.ShapeHistogram
+}
+
+// jsonlCostCenter is a cost center's total, also written last.
+type jsonlCostCenter struct {
+	Kind string `json:"kind"`
+	*kubecap.CostCenterReport
+}
+
+type jsonlEvictable struct {
+	Kind string `json:"kind"`
+	*kubecap.EvictableContainer
+}
+
+func newJSONLOutput(w io.Writer) *jsonlOutput {
+	return &jsonlOutput{
+		enc: json.NewEncoder(w),
+	}
+}
+
+func (j *jsonlOutput) Metadata(m *kubecap.Metadata) error {
+	j.md = m
+
+	return j.enc.Encode(jsonlMetadata{"metadata", m})
+}
+
+func (j *jsonlOutput) Node(n *kubecap.NodeReport) error {
+	if j.md != nil && (j.md.Leaderboard > 0 || j.md.Shapes || j.md.CostCenters != nil || j.md.RightSizes != nil || len(j.md.TaintPoolKeys) > 0 || len(j.md.CustomReports) > 0) {
+		j.nodes = append(j.nodes, n)
+	}
+
+	return j.enc.Encode(jsonlNode{"node", n})
+}
+
+func (j *jsonlOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return j.enc.Encode(jsonlEvictable{"evictable", e})
+}
+
+func (j *jsonlOutput) Flush() error {
+	if j.md == nil {
+		return nil
+	}
+
+	if j.md.Churn != nil {
+		err := j.enc.Encode(jsonlChurn{"churn", j.md.Churn})
+		if err != nil {
+			return err
+		}
+	}
+
+	if j.md.ClusterOk != nil {
+		err := j.enc.Encode(jsonlClusterVerdict{"clusterVerdict", *j.md.ClusterOk, j.md.Verdicts.Cluster})
+		if err != nil {
+			return err
+		}
+	}
+
+	if j.md.Coverage != nil {
+		err := j.enc.Encode(jsonlCoverage{"coverage", j.md.Coverage})
+		if err != nil {
+			return err
+		}
+	}
+
+	if j.md.Quota != nil {
+		err := j.enc.Encode(jsonlQuota{"quota", j.md.Quota})
+		if err != nil {
+			return err
+		}
+	}
+
+	if j.md.Kueue != nil {
+		for _, cq := range j.md.Kueue.ClusterQueues {
+			err := j.enc.Encode(jsonlClusterQueue{"clusterQueue", cq})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if j.md.JobBacklog != nil {
+		err := j.enc.Encode(jsonlJobBacklog{"jobBacklog", j.md.JobBacklog})
+		if err != nil {
+			return err
+		}
+	}
+
+	if j.md.Leaderboard > 0 {
+		for _, byWorkload := range []bool{false, true} {
+			kind := "podEfficiency"
+			if byWorkload {
+				kind = "workloadEfficiency"
+			}
+
+			for _, e := range kubecap.Leaderboard(j.nodes, byWorkload, j.md.Leaderboard) {
+				err := j.enc.Encode(jsonlEfficiency{kind, e})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if j.md.GPUMetricsURL != "" {
+			for _, e := range kubecap.GPULeaderboard(j.nodes, j.md.Leaderboard) {
+				err := j.enc.Encode(jsonlGPUEfficiency{"gpuEfficiency", e})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if j.md.CostCenters != nil {
+		for _, c := range kubecap.CostCenterReports(j.nodes) {
+			err := j.enc.Encode(jsonlCostCenter{"costCenter", c})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if j.md.Shapes {
+		for _, s := range kubecap.PodShapes(j.nodes) {
+			err := j.enc.Encode(jsonlShapes{"podShapes", s})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if j.md.RightSizes != nil {
+		for _, r := range kubecap.RightSize(j.nodes, j.md.RightSizes) {
+			err := j.enc.Encode(jsonlRightSizing{"rightSizing", r})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(j.md.TaintPoolKeys) > 0 {
+		for _, p := range kubecap.SummarizeTaintPools(j.nodes) {
+			err := j.enc.Encode(jsonlTaintPool{"taintPool", p})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, r := range j.md.CustomReports {
+		result, err := r.Evaluate(j.nodes)
+		if err != nil {
+			return err
+		}
+
+		err = j.enc.Encode(jsonlCustomReport{"customReport", result})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range j.md.ReservationStatus {
+		err := j.enc.Encode(jsonlReservation{"reservation", r})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, sb := range j.md.StandbyStatus {
+		err := j.enc.Encode(jsonlStandby{"standby", sb})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, slo := range j.md.SLOStatus {
+		err := j.enc.Encode(jsonlSLO{"slo", slo})
+		if err != nil {
+			return err
+		}
+	}
+
+	if j.md.Autoscaler != nil {
+		for _, ng := range j.md.Autoscaler.NodeGroups {
+			err := j.enc.Encode(jsonlAutoscalerNodeGroup{"autoscalerNodeGroup", ng})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
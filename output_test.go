@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"sigs.k8s.io/yaml"
+)
+
+func TestTableOutputHeader(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	out := newTableOutput(buf, "1GiB", false)
+
+	err := out.Metadata(&kubecap.Metadata{PSI: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Node(&kubecap.NodeReport{Name: "node-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(buf.String(), " NAME "); n != 1 {
+		t.Errorf("header has %d name columns, want 1:\n%s", n, buf)
+	}
+
+	if !strings.Contains(buf.String(), "MEMORY PSI SOME") {
+		t.Errorf("header lacks the PSI columns:\n%s", buf)
+	}
+}
+
+func TestTableNUMA(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	out := newTableOutput(buf, "1GiB", false)
+
+	err := out.Metadata(&kubecap.Metadata{NUMA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Node(&kubecap.NodeReport{Name: "node-a", NUMA: &kubecap.NUMAReport{LargestAvailable: 3 << 30}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without NodeResourceTopology the largest available is unknown.
+	err = out.Node(&kubecap.NodeReport{Name: "node-b", NUMA: &kubecap.NUMAReport{LargestAvailable: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"NUMA LARGEST AVAILABLE", "| 3.0 GiB "} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("lacks %q:\n%s", want, buf)
+		}
+	}
+
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "node-b") && !strings.HasSuffix(strings.TrimRight(line, " |"), "| -") {
+			t.Errorf("unknown largest available isn't -: %s", line)
+		}
+	}
+}
+
+func TestWideOutput(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	out, err := newOutput("wide", buf, "1GiB", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Node(&kubecap.NodeReport{Name: "node-a", KubeletVersion: "v1.30.2", PodCount: 12, PodCapacity: 110})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"KUBELET", "INSTANCE TYPE", "v1.30.2", "12/110"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("lacks %q:\n%s", want, buf)
+		}
+	}
+}
+
+func TestTableMemoryBreakdown(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	out := newTableOutput(buf, "1GiB", false)
+
+	err := out.Metadata(&kubecap.Metadata{UsageSource: "cadvisor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Evictable(&kubecap.EvictableContainer{
+		Node:   "node-a",
+		Pod:    "web-0",
+		Used:   3000,
+		Memory: &kubecap.MemoryStats{RSS: 1000, Cache: 2000, Swap: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Evictable(&kubecap.EvictableContainer{Node: "node-a", Pod: "web-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"RSS", "CACHE", "SWAP", "2,000"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("lacks %q:\n%s", want, buf)
+		}
+	}
+
+	// Without cAdvisor there is no breakdown to show.
+	buf.Reset()
+
+	out = newTableOutput(buf, "1GiB", false)
+	writeReport(t, out)
+
+	if strings.Contains(buf.String(), "RSS") {
+		t.Errorf("has the memory breakdown:\n%s", buf)
+	}
+}
+
+// writeReport reports a node and an evictable container to out.
+func writeReport(t *testing.T, out kubecap.Output) {
+	t.Helper()
+
+	err := out.Metadata(&kubecap.Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Node(&kubecap.NodeReport{Name: "node-a", Allocatable: 16 << 30, FreeWithAdditional: -1 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Evictable(&kubecap.EvictableContainer{Node: "node-a", Pod: "web-0", Used: 2 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDocumentOutput(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		buf := &bytes.Buffer{}
+
+		out, err := newOutput(format, buf, "1GiB", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeReport(t, out)
+
+		data := buf.Bytes()
+		if format == "yaml" {
+			data, err = yaml.YAMLToJSON(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		doc := struct {
+			Nodes []struct {
+				Name        string
+				Allocatable int64
+				Human       HumanNode
+			}
+			Evictable []struct {
+				Pod   string
+				Human HumanEvictable
+			}
+		}{}
+
+		err = json.Unmarshal(data, &doc)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", format, err, buf)
+		}
+
+		if len(doc.Nodes) != 1 || doc.Nodes[0].Allocatable != 16<<30 || doc.Nodes[0].Human.Allocatable != "16 GiB" || doc.Nodes[0].Human.FreeWithAdditional != "-1.0 GiB" {
+			t.Errorf("%s: nodes = %+v", format, doc.Nodes)
+		}
+
+		if len(doc.Evictable) != 1 || doc.Evictable[0].Pod != "web-0" || doc.Evictable[0].Human.Used != "2.0 GiB" {
+			t.Errorf("%s: evictable = %+v", format, doc.Evictable)
+		}
+	}
+}
+
+func TestCSVOutput(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	writeReport(t, newCSVOutput(buf))
+
+	sections := strings.Split(buf.String(), "\n\n")
+	if len(sections) != 2 {
+		t.Fatalf("sections = %d, want 2:\n%s", len(sections), buf)
+	}
+
+	nodes, err := csv.NewReader(strings.NewReader(sections[0])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes) != 2 || nodes[1][1] != "node-a" || nodes[1][3] != "17179869184" || nodes[1][4] != "16 GiB" {
+		t.Errorf("nodes = %q", nodes)
+	}
+
+	evictable, err := csv.NewReader(strings.NewReader(sections[1])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(evictable) != 2 || evictable[1][3] != "web-0" || evictable[1][10] != "2.0 GiB" {
+		t.Errorf("evictable = %q", evictable)
+	}
+}
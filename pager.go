@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// isTerminal reports whether f is a terminal rather than a pipe or a file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// pager shows a table's rows a page at a time, reading the next command
+// after each page: Enter or n for the next page, p for the previous one and q
+// to skip the remaining rows.
+type pager struct {
+	in   *bufio.Reader
+	size int
+}
+
+// newPager returns a pager of size rows reading commands from in.
+func newPager(in io.Reader, size int) *pager {
+	return &pager{in: bufio.NewReader(in), size: size}
+}
+
+// render writes the rows, under the header, a page at a time to w. Reading
+// the end of the commands ends paging, as quitting does.
+func (p *pager) render(w io.Writer, header []string, rows [][]string) {
+	pages := (len(rows) + p.size - 1) / p.size
+	if pages < 1 {
+		pages = 1
+	}
+
+	for page := 0; page < pages; {
+		start := page * p.size
+
+		end := start + p.size
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		table := tablewriter.NewWriter(w)
+		table.SetHeader(header)
+		table.AppendBulk(rows[start:end])
+		table.Render()
+
+		if pages == 1 {
+			return
+		}
+
+		fmt.Fprintf(w, "Page %d of %d (rows %d-%d of %d) [n]ext, [p]rev, [q]uit: ", page+1, pages, start+1, end, len(rows))
+
+		line, err := p.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(w)
+
+			return
+		}
+
+		switch strings.TrimSpace(line) {
+		case "", "n":
+			if page == pages-1 {
+				return
+			}
+
+			page++
+		case "p":
+			if page > 0 {
+				page--
+			}
+		case "q":
+			return
+		}
+	}
+}
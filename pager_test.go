@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPager(t *testing.T) {
+	rows := [][]string{{"node-1"}, {"node-2"}, {"node-3"}, {"node-4"}, {"node-5"}}
+
+	// Going back from the first page shows it again; quitting on the second
+	// skips the third.
+	buf := &bytes.Buffer{}
+	newPager(strings.NewReader("p\nn\nq\n"), 2).render(buf, []string{"Node"}, rows)
+
+	out := buf.String()
+
+	if got := strings.Count(out, "node-1"); got != 2 {
+		t.Errorf("first page shown %d times, want 2:\n%s", got, out)
+	}
+
+	if !strings.Contains(out, "node-4") || strings.Contains(out, "node-5") {
+		t.Errorf("want the second page and not the third:\n%s", out)
+	}
+
+	if !strings.Contains(out, "Page 2 of 3 (rows 3-4 of 5)") {
+		t.Errorf("no second page prompt:\n%s", out)
+	}
+
+	// The end of the input ends paging.
+	buf.Reset()
+	newPager(strings.NewReader("\n"), 2).render(buf, []string{"Node"}, rows)
+
+	if out := buf.String(); strings.Contains(out, "node-5") || strings.Count(out, "Page ") != 2 {
+		t.Errorf("want two pages before the input ends:\n%s", out)
+	}
+
+	// A single page needs no prompt.
+	buf.Reset()
+	newPager(strings.NewReader(""), 10).render(buf, []string{"Node"}, rows)
+
+	if out := buf.String(); !strings.Contains(out, "node-5") || strings.Contains(out, "Page ") {
+		t.Errorf("want all the rows without a prompt:\n%s", out)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+)
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint.
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyOutput triggers a PagerDuty incident for each node group (and,
+// optionally, the whole cluster) with less schedulable resource than the
+// minimum, and for each priority tier missing its standby, and resolves it
+// once capacity recovers. Events are deduplicated per context and node group
+// (or priority tier) so repeated runs update the same incident.
+type pagerDutyOutput struct {
+	url        string
+	routingKey string
+	min        int64
+	minCluster int64
+	client     *http.Client
+	triggered  map[string]bool
+	md         *kubecap.Metadata
+	nodes      []*kubecap.NodeReport
+}
+
+func newPagerDutyOutput(routingKey string, min, minCluster int64) *pagerDutyOutput {
+	return &pagerDutyOutput{
+		url:        pagerDutyEventsURL,
+		routingKey: routingKey,
+		min:        min,
+		minCluster: minCluster,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *pagerDutyOutput) Metadata(m *kubecap.Metadata) error {
+	p.md = m
+	p.nodes = nil
+
+	return nil
+}
+
+func (p *pagerDutyOutput) Node(n *kubecap.NodeReport) error {
+	p.nodes = append(p.nodes, n)
+
+	return nil
+}
+
+func (p *pagerDutyOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+func (p *pagerDutyOutput) Flush() error {
+	ctx := context.Background()
+
+	checks := kubecap.CapacityChecks(p.nodes, p.min, p.minCluster)
+
+	first := p.triggered == nil
+	if first {
+		p.triggered = map[string]bool{}
+	}
+
+	for _, c := range checks {
+		dedupKey := fmt.Sprintf("kubecap/%s/%s", p.md.Context, c.Key)
+
+		if !c.Breached() {
+			// Resolve anything left open by a previous process on the
+			// first run and afterwards only what this process triggered.
+			if first || p.triggered[dedupKey] {
+				err := p.send(ctx, dedupKey, "resolve", nil)
+				if err != nil {
+					return err
+				}
+
+				delete(p.triggered, dedupKey)
+			}
+
+			continue
+		}
+
+		err := p.send(ctx, dedupKey, "trigger", map[string]interface{}{
+			"summary": fmt.Sprintf(
+				"%s in %s has %s schedulable %s (minimum %s)",
+				c.What, p.md.Context,
+				humanAmount(p.md, c.Sum.Schedulable), p.md.ResourceName(),
+				humanAmount(p.md, c.Min),
+			),
+			"source":    p.md.Context,
+			"severity":  "critical",
+			"component": c.Key,
+			"group":     "kubecap",
+			"timestamp": p.md.Timestamp.Format(time.RFC3339),
+			"custom_details": map[string]interface{}{
+				"nodes":       c.Sum.Nodes,
+				"okNodes":     c.Sum.OkNodes,
+				"allocatable": c.Sum.Allocatable,
+				"used":        c.Sum.Used,
+				"requests":    c.Sum.Requests,
+				"schedulable": c.Sum.Schedulable,
+				"minimum":     c.Min,
+				"resource":    p.md.ResourceName(),
+				"additional":  p.md.AdditionalInput,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		p.triggered[dedupKey] = true
+	}
+
+	current := map[string]bool{}
+
+	for _, sb := range p.md.StandbyStatus {
+		dedupKey := fmt.Sprintf("kubecap/%s/standby/%s", p.md.Context, sb.PriorityClass)
+		current[dedupKey] = true
+
+		if sb.Ok {
+			if first || p.triggered[dedupKey] {
+				err := p.send(ctx, dedupKey, "resolve", nil)
+				if err != nil {
+					return err
+				}
+
+				delete(p.triggered, dedupKey)
+			}
+
+			continue
+		}
+
+		err := p.send(ctx, dedupKey, "trigger", map[string]interface{}{
+			"summary": fmt.Sprintf(
+				"%s has room for %d of the %d standby %s pods of %s %s",
+				p.md.Context, sb.Available, sb.Replicas, sb.PriorityClass,
+				humanize.Comma(sb.Amount), p.md.Unit(),
+			),
+			"source":    p.md.Context,
+			"severity":  "critical",
+			"component": "standby/" + sb.PriorityClass,
+			"group":     "kubecap",
+			"timestamp": p.md.Timestamp.Format(time.RFC3339),
+			"custom_details": map[string]interface{}{
+				"priorityClass": sb.PriorityClass,
+				"amount":        sb.Amount,
+				"replicas":      sb.Replicas,
+				"available":     sb.Available,
+				"preempted":     sb.Preempted,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		p.triggered[dedupKey] = true
+	}
+
+	// Node groups that no longer exist (deleted or renamed pools) and
+	// standbys no longer required can't recover so their incidents are
+	// resolved.
+	for _, c := range checks {
+		current[fmt.Sprintf("kubecap/%s/%s", p.md.Context, c.Key)] = true
+	}
+
+	for dedupKey := range p.triggered {
+		if current[dedupKey] {
+			continue
+		}
+
+		err := p.send(ctx, dedupKey, "resolve", nil)
+		if err != nil {
+			return err
+		}
+
+		delete(p.triggered, dedupKey)
+	}
+
+	return nil
+}
+
+func (p *pagerDutyOutput) send(ctx context.Context, dedupKey, action string, payload map[string]interface{}) error {
+	event := map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": action,
+		"dedup_key":    dedupKey,
+	}
+
+	if payload != nil {
+		event["payload"] = payload
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("pagerduty %s %s: %s: %s", action, dedupKey, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+// pagerDutyEvent is the part of an Events API v2 event the tests check.
+type pagerDutyEvent struct {
+	RoutingKey  string `json:"routing_key"`
+	EventAction string `json:"event_action"`
+	DedupKey    string `json:"dedup_key"`
+	Payload     *struct {
+		Summary       string                 `json:"summary"`
+		Source        string                 `json:"source"`
+		Severity      string                 `json:"severity"`
+		Component     string                 `json:"component"`
+		Timestamp     string                 `json:"timestamp"`
+		CustomDetails map[string]interface{} `json:"custom_details"`
+	} `json:"payload"`
+}
+
+func TestPagerDutyOutput(t *testing.T) {
+	events := []pagerDutyEvent{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s with %q", r.Method, r.URL, r.Header.Get("Content-Type"))
+		}
+
+		e := pagerDutyEvent{}
+
+		err := json.NewDecoder(r.Body).Decode(&e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events = append(events, e)
+
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	p := newPagerDutyOutput("routing-key", 2<<30, 0)
+	p.url = srv.URL
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	flush := func(md *kubecap.Metadata, nodes ...*kubecap.NodeReport) []pagerDutyEvent {
+		t.Helper()
+
+		events = events[:0]
+
+		err := p.Metadata(md)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, n := range nodes {
+			err = p.Node(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = p.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return append([]pagerDutyEvent{}, events...)
+	}
+
+	actions := func(events []pagerDutyEvent) [][2]string {
+		got := [][2]string{}
+		for _, e := range events {
+			got = append(got, [2]string{e.EventAction, e.DedupKey})
+		}
+
+		return got
+	}
+
+	// The first run triggers the breached group and the missing standby
+	// and resolves what a previous process may have left open.
+	got := flush(&kubecap.Metadata{
+		Context:   "prod",
+		Timestamp: ts,
+		StandbyStatus: []*kubecap.StandbyReport{
+			{PriorityClass: "critical", Amount: 4 << 30, Replicas: 2, Available: 1},
+		},
+	},
+		&kubecap.NodeReport{Name: "web-1", Group: "web", Allocatable: 8 << 30, Schedulable: 1 << 30},
+		&kubecap.NodeReport{Name: "db-1", Group: "db", Allocatable: 8 << 30, Schedulable: 4 << 30, Ok: true},
+	)
+
+	want := [][2]string{
+		{"resolve", "kubecap/prod/group/db"},
+		{"trigger", "kubecap/prod/group/web"},
+		{"trigger", "kubecap/prod/standby/critical"},
+	}
+	if !reflect.DeepEqual(actions(got), want) {
+		t.Fatalf("first run = %v, want %v", actions(got), want)
+	}
+
+	for _, e := range got {
+		if e.RoutingKey != "routing-key" {
+			t.Errorf("%s: routing key = %q", e.DedupKey, e.RoutingKey)
+		}
+
+		if (e.Payload != nil) != (e.EventAction == "trigger") {
+			t.Errorf("%s %s: payload = %+v", e.EventAction, e.DedupKey, e.Payload)
+		}
+	}
+
+	web := got[1].Payload
+	if web.Summary != "Node group web in prod has 1.0 GiB schedulable memory (minimum 2.0 GiB)" ||
+		web.Source != "prod" || web.Severity != "critical" || web.Component != "group/web" ||
+		web.Timestamp != "2024-05-01T12:00:00Z" {
+		t.Errorf("web payload = %+v", web)
+	}
+
+	if d := web.CustomDetails; d["schedulable"] != float64(1<<30) || d["minimum"] != float64(2<<30) || d["resource"] != "memory" {
+		t.Errorf("web details = %v", d)
+	}
+
+	standby := got[2].Payload
+	if standby.Component != "standby/critical" || standby.CustomDetails["available"] != float64(1) {
+		t.Errorf("standby payload = %+v", standby)
+	}
+
+	// Once web recovers only what this process triggered is resolved, and
+	// the new breach of batch is triggered.
+	got = flush(&kubecap.Metadata{
+		Context:   "prod",
+		Timestamp: ts.Add(time.Minute),
+		StandbyStatus: []*kubecap.StandbyReport{
+			{PriorityClass: "critical", Amount: 4 << 30, Replicas: 2, Available: 2, Ok: true},
+		},
+	},
+		&kubecap.NodeReport{Name: "web-1", Group: "web", Allocatable: 8 << 30, Schedulable: 4 << 30, Ok: true},
+		&kubecap.NodeReport{Name: "db-1", Group: "db", Allocatable: 8 << 30, Schedulable: 4 << 30, Ok: true},
+		&kubecap.NodeReport{Name: "batch-1", Group: "batch", Allocatable: 8 << 30},
+	)
+
+	want = [][2]string{
+		{"trigger", "kubecap/prod/group/batch"},
+		{"resolve", "kubecap/prod/group/web"},
+		{"resolve", "kubecap/prod/standby/critical"},
+	}
+	if !reflect.DeepEqual(actions(got), want) {
+		t.Fatalf("second run = %v, want %v", actions(got), want)
+	}
+
+	// The batch pool is gone, so its incident can't recover and is
+	// resolved as stale.
+	got = flush(&kubecap.Metadata{Context: "prod", Timestamp: ts.Add(2 * time.Minute)},
+		&kubecap.NodeReport{Name: "web-1", Group: "web", Allocatable: 8 << 30, Schedulable: 4 << 30, Ok: true},
+	)
+
+	want = [][2]string{
+		{"resolve", "kubecap/prod/group/batch"},
+	}
+	if !reflect.DeepEqual(actions(got), want) {
+		t.Fatalf("third run = %v, want %v", actions(got), want)
+	}
+}
+
+func TestPagerDutyOutputError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, `{"status":"invalid event"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	p := newPagerDutyOutput("routing-key", 2<<30, 0)
+	p.url = srv.URL
+
+	p.Metadata(&kubecap.Metadata{Context: "prod"})
+	p.Node(&kubecap.NodeReport{Name: "web-1", Group: "web"})
+
+	err := p.Flush()
+	if err == nil || err.Error() != `pagerduty trigger kubecap/prod/group/web: 400 Bad Request: {"status":"invalid event"}` {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package kubecap
+
+import (
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// AllocatableChange is a change of a node's allocatable or capacity amount of
+// a resource seen by a watch, e.g. after the kubelet's reservations were
+// reconfigured or memory was hot-plugged. Allocatable silently shrinking
+// takes capacity away without any node going away.
+type AllocatableChange struct {
+	// At is when the watch first saw the change.
+	At time.Time `json:"at"`
+
+	// Of is allocatable or capacity.
+	Of       string `json:"of"`
+	Resource string `json:"resource"`
+
+	// From and To are the amounts before and after, empty when the resource
+	// was added or removed.
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Shrank is whether the amount went down (or the resource was removed).
+	Shrank bool `json:"shrank"`
+}
+
+// NodeAllocatable tracks the nodes' allocatable and capacity amounts across
+// the reports of a watch and remembers their changes for a window.
+type NodeAllocatable struct {
+	window time.Duration
+
+	seen    map[string]allocatableSeen
+	changes map[string][]*AllocatableChange
+}
+
+// allocatableSeen is the amounts a node last had.
+type allocatableSeen struct {
+	uid         types.UID
+	allocatable corev1.ResourceList
+	capacity    corev1.ResourceList
+}
+
+// NewNodeAllocatable returns a tracker reporting the changes seen within the
+// window.
+func NewNodeAllocatable(window time.Duration) *NodeAllocatable {
+	return &NodeAllocatable{
+		window:  window,
+		seen:    map[string]allocatableSeen{},
+		changes: map[string][]*AllocatableChange{},
+	}
+}
+
+// update compares the nodes' amounts to those they last had, warning of and
+// recording the changes, and forgets the nodes gone. A node replaced by one of
+// the same name starts over. It returns each node's changes within the window
+// before now, oldest first.
+func (a *NodeAllocatable) update(nodes map[string]*corev1.Node, now time.Time, warnf func(format string, args ...interface{})) map[string][]*AllocatableChange {
+	seen := map[string]allocatableSeen{}
+	changes := map[string][]*AllocatableChange{}
+
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		node := nodes[name]
+
+		current := allocatableSeen{
+			uid:         node.UID,
+			allocatable: node.Status.Allocatable,
+			capacity:    node.Status.Capacity,
+		}
+		seen[name] = current
+
+		last, ok := a.seen[name]
+		if !ok || last.uid != current.uid {
+			continue
+		}
+
+		recent := []*AllocatableChange{}
+		for _, c := range a.changes[name] {
+			if now.Sub(c.At) < a.window {
+				recent = append(recent, c)
+			}
+		}
+
+		for _, c := range append(
+			diffResources("allocatable", last.allocatable, current.allocatable, now),
+			diffResources("capacity", last.capacity, current.capacity, now)...,
+		) {
+			from, to := c.From, c.To
+			if from == "" {
+				from = "none"
+			}
+
+			if to == "" {
+				to = "none"
+			}
+
+			warnf("node %s: %s %s changed from %s to %s", name, c.Of, c.Resource, from, to)
+
+			recent = append(recent, c)
+		}
+
+		if len(recent) > 0 {
+			changes[name] = recent
+		}
+	}
+
+	a.seen, a.changes = seen, changes
+
+	return changes
+}
+
+// diffResources returns the changes of the resources from before to after,
+// by resource name.
+func diffResources(of string, before, after corev1.ResourceList, at time.Time) []*AllocatableChange {
+	names := []string{}
+	for name := range before {
+		names = append(names, string(name))
+	}
+
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, string(name))
+		}
+	}
+
+	sort.Strings(names)
+
+	changes := []*AllocatableChange{}
+
+	for _, name := range names {
+		was, had := before[corev1.ResourceName(name)]
+		is, has := after[corev1.ResourceName(name)]
+
+		if had && has && was.Cmp(is) == 0 {
+			continue
+		}
+
+		c := &AllocatableChange{At: at, Of: of, Resource: name}
+
+		if had {
+			c.From = was.String()
+		}
+
+		if has {
+			c.To = is.String()
+		}
+
+		c.Shrank = !has || (had && is.Cmp(was) < 0)
+
+		changes = append(changes, c)
+	}
+
+	return changes
+}
@@ -0,0 +1,69 @@
+package kubecap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNodeAllocatable(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	md := &Metadata{}
+
+	a := NewNodeAllocatable(time.Hour)
+
+	node := func(uid types.UID, memory string) map[string]*corev1.Node {
+		return map[string]*corev1.Node{
+			"node-a": {
+				ObjectMeta: metav1.ObjectMeta{Name: "node-a", UID: uid},
+				Status: corev1.NodeStatus{
+					Capacity: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse("16Gi"),
+					},
+					Allocatable: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse(memory),
+					},
+				},
+			},
+		}
+	}
+
+	// The first report only sees the amounts.
+	if changes := a.update(node("a", "15Gi"), start, md.warnf); len(changes) != 0 {
+		t.Errorf("first report: changes = %v", changes)
+	}
+
+	changes := a.update(node("a", "14Gi"), start.Add(time.Minute), md.warnf)
+
+	c := changes["node-a"]
+	if len(c) != 1 || c[0].Of != "allocatable" || c[0].Resource != "memory" || c[0].From != "15Gi" || c[0].To != "14Gi" || !c[0].Shrank {
+		t.Fatalf("shrank: changes = %+v", c)
+	}
+
+	if want := []string{"node node-a: allocatable memory changed from 15Gi to 14Gi"}; !reflect.DeepEqual(md.Warnings, want) {
+		t.Errorf("warnings = %q, want %q", md.Warnings, want)
+	}
+
+	// The change is reported for the window.
+	changes = a.update(node("a", "14Gi"), start.Add(30*time.Minute), md.warnf)
+	if len(changes["node-a"]) != 1 {
+		t.Errorf("within the window: changes = %v", changes)
+	}
+
+	changes = a.update(node("a", "14Gi"), start.Add(2*time.Hour), md.warnf)
+	if len(changes) != 0 {
+		t.Errorf("after the window: changes = %v", changes)
+	}
+
+	// A replaced node starts over.
+	changes = a.update(node("b", "15Gi"), start.Add(3*time.Hour), md.warnf)
+	if len(changes) != 0 {
+		t.Errorf("replaced: changes = %v", changes)
+	}
+}
@@ -0,0 +1,90 @@
+package kubecap
+
+import (
+	"math"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// allocatableTolerance is how far (as a fraction of the median) a node's
+// allocatable memory may stray from its peers' before it is flagged.
+const allocatableTolerance = 0.01
+
+// instanceTypeLabel is the well-known label holding the node's instance type.
+const instanceTypeLabel = "node.kubernetes.io/instance-type"
+
+// AllocatableAnomaly flags a node whose allocatable memory deviates from its
+// peers': the nodes in the same group with the same instance type.
+type AllocatableAnomaly struct {
+	PeerMedian int64 `json:"peerMedian"`
+	Peers      int   `json:"peers"`
+
+	// Deviation is the difference from the median as a fraction of it.
+	Deviation float64 `json:"deviation"`
+}
+
+// peerMedians returns the median allocatable memory of each node's peers
+// and the number of peers, by node name. Nodes without an instance type or
+// with fewer than two peers (including themselves, three) are left out.
+func peerMedians(nodes map[string]*corev1.Node, groupLabel string) (medians map[string]int64, peers map[string]int) {
+	sets := map[string][]*corev1.Node{}
+
+	for _, node := range nodes {
+		instanceType := node.Labels[instanceTypeLabel]
+		if instanceType == "" {
+			continue
+		}
+
+		key := nodeGroup(node, groupLabel) + "/" + instanceType
+		sets[key] = append(sets[key], node)
+	}
+
+	medians = map[string]int64{}
+	peers = map[string]int{}
+
+	for _, set := range sets {
+		if len(set) < 3 {
+			continue
+		}
+
+		values := make([]int64, len(set))
+		for i, node := range set {
+			values[i] = node.Status.Allocatable.Memory().Value()
+		}
+
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+		median := values[len(values)/2]
+		if len(values)%2 == 0 {
+			median = (values[len(values)/2-1] + values[len(values)/2]) / 2
+		}
+
+		for _, node := range set {
+			medians[node.Name] = median
+			peers[node.Name] = len(set)
+		}
+	}
+
+	return medians, peers
+}
+
+// allocatableAnomaly returns the anomaly for the node's allocatable memory or
+// nil when it is within tolerance of its peers' (or it has none).
+func (snap *snapshot) allocatableAnomaly(name string, allocatable int64) *AllocatableAnomaly {
+	median, ok := snap.peerMedians[name]
+	if !ok || median == 0 {
+		return nil
+	}
+
+	deviation := float64(allocatable-median) / float64(median)
+	if math.Abs(deviation) <= allocatableTolerance {
+		return nil
+	}
+
+	return &AllocatableAnomaly{
+		PeerMedian: median,
+		Peers:      snap.peers[name],
+		Deviation:  deviation,
+	}
+}
@@ -0,0 +1,192 @@
+package kubecap
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// AutoscalerReport is the Cluster Autoscaler's view of its node groups.
+type AutoscalerReport struct {
+	NodeGroups []*AutoscalerNodeGroup `json:"nodeGroups"`
+}
+
+// AutoscalerNodeGroup is a Cluster Autoscaler node group's size alongside the
+// headroom of the kubecap node group it matches.
+type AutoscalerNodeGroup struct {
+	// Name is the Cluster Autoscaler's (cloud provider's) name for the node
+	// group and Group the kubecap node group it matches, if any.
+	Name  string `json:"name"`
+	Group string `json:"group,omitempty"`
+
+	Health string `json:"health"`
+	Ready  int    `json:"ready"`
+	Target int    `json:"target"`
+	Min    int    `json:"min"`
+	Max    int    `json:"max"`
+
+	// AtMax is whether the group can't be scaled up any further.
+	AtMax bool `json:"atMax"`
+
+	// Schedulable and OkNodes are the matched group's schedulable memory
+	// and nodes with room for the additional amount. They are set once
+	// every node has been analyzed.
+	Schedulable int64 `json:"schedulable"`
+	OkNodes     int   `json:"okNodes"`
+
+	// Full is whether the group is at its maximum size and none of its
+	// nodes has room for the additional amount.
+	Full bool `json:"full"`
+}
+
+// autoscalerStatus is the part of the YAML status written by Cluster
+// Autoscaler 1.30 and later we use.
+type autoscalerStatus struct {
+	NodeGroups []struct {
+		Name   string `json:"name"`
+		Health struct {
+			Status     string `json:"status"`
+			NodeCounts struct {
+				Registered struct {
+					Ready int `json:"ready"`
+				} `json:"registered"`
+			} `json:"nodeCounts"`
+			CloudProviderTarget int `json:"cloudProviderTarget"`
+			MinSize             int `json:"minSize"`
+			MaxSize             int `json:"maxSize"`
+		} `json:"health"`
+	} `json:"nodeGroups"`
+}
+
+var (
+	// autoscalerNameRE and autoscalerHealthRE match the node group lines of
+	// the human readable status written by earlier Cluster Autoscalers, e.g.
+	//
+	//   Name:        eks-general-4ac2
+	//   Health:      Healthy (ready=3 unready=0 ... cloudProviderTarget=3 (minSize=1, maxSize=10))
+	autoscalerNameRE   = regexp.MustCompile(`^\s*Name:\s*(\S+)`)
+	autoscalerHealthRE = regexp.MustCompile(`^\s*Health:\s*(\w+)\s*\(ready=(\d+).*cloudProviderTarget=(\d+)\s*\(minSize=(\d+),\s*maxSize=(\d+)\)`)
+)
+
+// parseAutoscalerStatus parses the Cluster Autoscaler status in either its
+// YAML or earlier human readable form.
+func parseAutoscalerStatus(status string) (*AutoscalerReport, error) {
+	r := &AutoscalerReport{}
+
+	as := autoscalerStatus{}
+	if err := yaml.Unmarshal([]byte(status), &as); err == nil && len(as.NodeGroups) > 0 {
+		for _, ng := range as.NodeGroups {
+			r.NodeGroups = append(r.NodeGroups, &AutoscalerNodeGroup{
+				Name:   ng.Name,
+				Health: ng.Health.Status,
+				Ready:  ng.Health.NodeCounts.Registered.Ready,
+				Target: ng.Health.CloudProviderTarget,
+				Min:    ng.Health.MinSize,
+				Max:    ng.Health.MaxSize,
+			})
+		}
+	} else {
+		i := strings.Index(status, "NodeGroups:")
+		if i < 0 {
+			return nil, fmt.Errorf("autoscaler status: no node groups")
+		}
+
+		var name string
+
+		for _, line := range strings.Split(status[i:], "\n") {
+			if m := autoscalerNameRE.FindStringSubmatch(line); m != nil {
+				name = m[1]
+				continue
+			}
+
+			m := autoscalerHealthRE.FindStringSubmatch(line)
+			if m == nil || name == "" {
+				continue
+			}
+
+			ng := &AutoscalerNodeGroup{Name: name, Health: m[1]}
+			for j, v := range []*int{&ng.Ready, &ng.Target, &ng.Min, &ng.Max} {
+				*v, _ = strconv.Atoi(m[j+2])
+			}
+
+			r.NodeGroups = append(r.NodeGroups, ng)
+			name = ""
+		}
+	}
+
+	for _, ng := range r.NodeGroups {
+		ng.AtMax = ng.Target >= ng.Max
+	}
+
+	sort.Slice(r.NodeGroups, func(i, j int) bool {
+		return r.NodeGroups[i].Name < r.NodeGroups[j].Name
+	})
+
+	return r, nil
+}
+
+// autoscalerStatusReport reads the Cluster Autoscaler status ConfigMap
+// (namespace/name).
+func autoscalerStatusReport(ctx context.Context, kcs kubernetes.Interface, configMap string) (r *AutoscalerReport, err error) {
+	ctx, span := tracer.Start(ctx, "get autoscaler status", trace.WithAttributes(
+		attribute.String("k8s.configmap.name", configMap),
+	))
+	defer func() { endSpan(span, err) }()
+
+	parts := strings.SplitN(configMap, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("autoscaler status ConfigMap %q: expected namespace/name", configMap)
+	}
+
+	cm, err := kcs.CoreV1().ConfigMaps(parts[0]).Get(ctx, parts[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseAutoscalerStatus(cm.Data["status"])
+}
+
+// matchGroups sets the kubecap node group each autoscaler node group matches
+// along with its headroom. Cloud providers' names for node groups usually
+// embed the node pool name (e.g. eks-<nodegroup>-<id> or the GKE instance
+// group URL), so a group matches exactly or else the longest kubecap group
+// name contained in it.
+func (r *AutoscalerReport) matchGroups(groups []GroupReport) {
+	for _, ng := range r.NodeGroups {
+		var match *GroupReport
+
+		for i := range groups {
+			g := &groups[i]
+			if g.Group == ungrouped {
+				continue
+			}
+
+			if g.Group == ng.Name {
+				match = g
+				break
+			}
+
+			if strings.Contains(ng.Name, g.Group) && (match == nil || len(g.Group) > len(match.Group)) {
+				match = g
+			}
+		}
+
+		if match == nil {
+			continue
+		}
+
+		ng.Group = match.Group
+		ng.Schedulable = match.Schedulable
+		ng.OkNodes = match.OkNodes
+		ng.Full = ng.AtMax && ng.OkNodes == 0
+	}
+}
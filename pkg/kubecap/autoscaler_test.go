@@ -0,0 +1,113 @@
+package kubecap
+
+import "testing"
+
+// The legacy status is abridged from a Cluster Autoscaler 1.27 ConfigMap.
+const autoscalerLegacyStatus = `Cluster-autoscaler status at 2024-03-01 10:00:00.123 +0000 UTC:
+Cluster-wide:
+  Health:      Healthy (ready=5 unready=0 (resourceUnready=0) notStarted=0 longNotStarted=0 registered=5 longUnregistered=0)
+               LastProbeTime:      2024-03-01 10:00:00.1 +0000 UTC
+  ScaleUp:     NoActivity (ready=5 registered=5)
+
+NodeGroups:
+  Name:        eks-general-4ac2b3d4
+  Health:      Healthy (ready=3 unready=0 (resourceUnready=0) notStarted=0 longNotStarted=0 registered=3 longUnregistered=0 cloudProviderTarget=3 (minSize=1, maxSize=3))
+               LastProbeTime:      2024-03-01 10:00:00.1 +0000 UTC
+  ScaleUp:     NoActivity (ready=3 cloudProviderTarget=3)
+
+  Name:        eks-general-large-9f8e7d6c
+  Health:      Healthy (ready=2 unready=0 (resourceUnready=0) notStarted=0 longNotStarted=0 registered=2 longUnregistered=0 cloudProviderTarget=2 (minSize=0, maxSize=10))
+               LastProbeTime:      2024-03-01 10:00:00.1 +0000 UTC
+`
+
+const autoscalerYAMLStatus = `time: "2024-03-01 10:00:00.123 +0000 UTC"
+autoscalerStatus: Running
+clusterWide:
+  health:
+    status: Healthy
+nodeGroups:
+- name: eks-general-4ac2b3d4
+  health:
+    status: Healthy
+    nodeCounts:
+      registered:
+        total: 3
+        ready: 3
+    cloudProviderTarget: 3
+    minSize: 1
+    maxSize: 3
+- name: eks-general-large-9f8e7d6c
+  health:
+    status: Unhealthy
+    nodeCounts:
+      registered:
+        total: 2
+        ready: 1
+    cloudProviderTarget: 2
+    minSize: 0
+    maxSize: 10
+`
+
+func TestParseAutoscalerStatus(t *testing.T) {
+	for name, status := range map[string]string{
+		"legacy": autoscalerLegacyStatus,
+		"yaml":   autoscalerYAMLStatus,
+	} {
+		r, err := parseAutoscalerStatus(status)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if len(r.NodeGroups) != 2 {
+			t.Fatalf("%s: node groups = %d, want 2", name, len(r.NodeGroups))
+		}
+
+		ng := r.NodeGroups[0]
+		if ng.Name != "eks-general-4ac2b3d4" || ng.Health != "Healthy" || ng.Ready != 3 ||
+			ng.Target != 3 || ng.Min != 1 || ng.Max != 3 || !ng.AtMax {
+			t.Errorf("%s: node group 0 = %+v", name, ng)
+		}
+
+		ng = r.NodeGroups[1]
+		if ng.Name != "eks-general-large-9f8e7d6c" || ng.Target != 2 || ng.Max != 10 || ng.AtMax {
+			t.Errorf("%s: node group 1 = %+v", name, ng)
+		}
+	}
+
+	_, err := parseAutoscalerStatus("Cluster-autoscaler status at ...:\n")
+	if err == nil {
+		t.Errorf("status without node groups accepted")
+	}
+}
+
+func TestAutoscalerMatchGroups(t *testing.T) {
+	r, err := parseAutoscalerStatus(autoscalerYAMLStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.NodeGroups = append(r.NodeGroups, &AutoscalerNodeGroup{Name: "other", Max: 1, Target: 1, AtMax: true})
+
+	r.matchGroups([]GroupReport{
+		{Group: "general", Summary: Summary{Schedulable: 100}},
+		{Group: "general-large", Summary: Summary{Schedulable: 200, OkNodes: 1}},
+		{Group: ungrouped, Summary: Summary{Schedulable: 300}},
+	})
+
+	// The longest contained name wins.
+	for i, want := range []struct {
+		group       string
+		schedulable int64
+		full        bool
+	}{
+		{"general", 100, true},
+		{"general-large", 200, false},
+		{"", 0, false},
+	} {
+		ng := r.NodeGroups[i]
+		if ng.Group != want.group || ng.Schedulable != want.schedulable || ng.Full != want.full {
+			t.Errorf("%s: group, schedulable, full = %q, %d, %t, want %q, %d, %t",
+				ng.Name, ng.Group, ng.Schedulable, ng.Full, want.group, want.schedulable, want.full)
+		}
+	}
+}
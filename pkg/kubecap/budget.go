@@ -0,0 +1,101 @@
+package kubecap
+
+import (
+	"sync"
+	"time"
+)
+
+// Budget bounds a report's API calls and runtime, for fragile production API
+// servers. Rather than exceeding it, Collect degrades the report: once the
+// budget is spent the optional analyses (node enrichers, GPU usage, cordon
+// events, pod churn, each node's kubelet configuration and stats and the
+// evictable containers) are skipped and recorded in the metadata's Degraded.
+// Nodes whose kubelet isn't asked are reported as if it were unreachable,
+// without the NUMA check. The lists the report can't do without (the nodes,
+// pods and their metrics, quotas, devices, ...) are always made, so the
+// budget is soft.
+type Budget struct {
+	// MaxAPICalls is the most API calls a report makes, unlimited if 0.
+	MaxAPICalls int64
+
+	// MaxRuntime is the longest a report runs, unbounded if 0.
+	MaxRuntime time.Duration
+
+	// Calls returns the API calls made so far by the clients the report
+	// uses, e.g. counted by their transport.
+	Calls func() int64
+
+	mu       sync.Mutex
+	base     int64
+	deadline time.Time
+	skipped  []string
+}
+
+// start starts a report's budget.
+func (b *Budget) start(now time.Time) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.base = 0
+	if b.Calls != nil {
+		b.base = b.Calls()
+	}
+
+	b.deadline = time.Time{}
+	if b.MaxRuntime > 0 {
+		b.deadline = now.Add(b.MaxRuntime)
+	}
+
+	b.skipped = nil
+}
+
+// allow reports whether the optional step, making calls more API calls, fits
+// in what is left of the budget. A step not allowed is recorded as skipped.
+func (b *Budget) allow(step string, calls int64) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	exceeded := !b.deadline.IsZero() && time.Now().After(b.deadline)
+
+	// Once the calls are spent, steps making none are skipped too.
+	if b.MaxAPICalls > 0 && b.Calls != nil {
+		spent := b.Calls() - b.base
+		if spent >= b.MaxAPICalls || spent+calls > b.MaxAPICalls {
+			exceeded = true
+		}
+	}
+
+	if !exceeded {
+		return true
+	}
+
+	for _, s := range b.skipped {
+		if s == step {
+			return false
+		}
+	}
+
+	b.skipped = append(b.skipped, step)
+
+	return false
+}
+
+// degraded returns the steps skipped so far.
+func (b *Budget) degraded() []string {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]string{}, b.skipped...)
+}
@@ -0,0 +1,58 @@
+package kubecap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	var calls int64 = 100
+
+	b := &Budget{MaxAPICalls: 10, Calls: func() int64 { return calls }}
+	b.start(time.Now())
+
+	// Calls before the report don't count against it.
+	calls += 8
+
+	if !b.allow("cordons", 1) || !b.allow("evictable", 0) {
+		t.Error("steps within the budget not allowed")
+	}
+
+	if b.allow("kubelet", 3) {
+		t.Error("kubelet allowed past the budget")
+	}
+
+	calls += 2
+
+	if b.allow("evictable", 0) || b.allow("kubelet", 2) {
+		t.Error("steps allowed with the budget spent")
+	}
+
+	if got, want := b.degraded(), []string{"kubelet", "evictable"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("degraded = %v, want %v", got, want)
+	}
+
+	// The next report starts afresh.
+	b.start(time.Now())
+
+	if !b.allow("kubelet", 2) || len(b.degraded()) != 0 {
+		t.Errorf("next report: degraded = %v", b.degraded())
+	}
+
+	// Past its runtime every step is skipped.
+	b = &Budget{MaxRuntime: time.Minute}
+	b.start(time.Now().Add(-2 * time.Minute))
+
+	if b.allow("gpu", 0) {
+		t.Error("gpu allowed past the runtime")
+	}
+
+	// Without a budget everything is allowed.
+	var none *Budget
+	none.start(time.Now())
+
+	if !none.allow("kubelet", 1000) || none.degraded() != nil {
+		t.Error("no budget: step not allowed")
+	}
+}
@@ -0,0 +1,323 @@
+package kubecap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// MemoryStats are the finer-grained memory figures cAdvisor reports for a
+// cgroup. All amounts are in bytes. Page cache counts towards usage but the
+// kernel reclaims it under pressure, so a container over its requests by its
+// cache alone isn't really at risk of eviction.
+type MemoryStats struct {
+	RSS        int64 `json:"rss"`
+	Cache      int64 `json:"cache"`
+	MappedFile int64 `json:"mappedFile"`
+	Swap       int64 `json:"swap"`
+}
+
+func (m *MemoryStats) add(o *MemoryStats) {
+	m.RSS += o.RSS
+	m.Cache += o.Cache
+	m.MappedFile += o.MappedFile
+	m.Swap += o.Swap
+}
+
+// MemoryBreakdown is whether the report's containers carry their memory
+// broken down into RSS, cache and swap: only memory reports with usage read
+// from cAdvisor do.
+func (md *Metadata) MemoryBreakdown() bool {
+	return md.UsageSource == "cadvisor" && md.ResourceName() == corev1.ResourceMemory
+}
+
+// cadvisorUsage holds the memory stats scraped from the kubelets, keyed by
+// node name and by namespace/pod/container.
+type cadvisorUsage struct {
+	nodes      map[string]*MemoryStats
+	containers map[string]*MemoryStats
+}
+
+// nodeStats returns the node's stats or nil when not scraped.
+func (u *cadvisorUsage) nodeStats(name string) *MemoryStats {
+	if u == nil {
+		return nil
+	}
+
+	return u.nodes[name]
+}
+
+// containerStats returns the container's stats or nil when not scraped.
+func (u *cadvisorUsage) containerStats(pod *corev1.Pod, container string) *MemoryStats {
+	if u == nil {
+		return nil
+	}
+
+	return u.containers[pod.Namespace+"/"+pod.Name+"/"+container]
+}
+
+// podStats sums the stats of the pod's containers. It returns nil when there
+// are none.
+func (u *cadvisorUsage) podStats(pod *corev1.Pod) *MemoryStats {
+	if u == nil {
+		return nil
+	}
+
+	var stats *MemoryStats
+
+	for _, c := range pod.Spec.Containers {
+		cs, ok := u.containers[pod.Namespace+"/"+pod.Name+"/"+c.Name]
+		if !ok {
+			continue
+		}
+
+		if stats == nil {
+			stats = &MemoryStats{}
+		}
+
+		stats.add(cs)
+	}
+
+	return stats
+}
+
+// promSample is a sample from the Prometheus text exposition format.
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parsePromText parses the Prometheus text exposition format. Comments and
+// lines that fail to parse are skipped.
+func parsePromText(data []byte) []promSample {
+	samples := []promSample{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		s := promSample{labels: map[string]string{}}
+
+		end := strings.IndexAny(line, "{ ")
+		if end < 0 {
+			continue
+		}
+
+		s.name = line[:end]
+		rest := line[end:]
+
+		if rest[0] == '{' {
+			var ok bool
+
+			rest, ok = parsePromLabels(rest[1:], s.labels)
+			if !ok {
+				continue
+			}
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+
+		s.value = v
+		samples = append(samples, s)
+	}
+
+	return samples
+}
+
+// parsePromLabels parses `name="value",...}` into labels and returns the rest
+// of the line after the closing brace.
+func parsePromLabels(s string, labels map[string]string) (rest string, ok bool) {
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], true
+		}
+
+		eq := strings.Index(s, "=")
+		if eq < 0 || len(s) < eq+2 || s[eq+1] != '"' {
+			return "", false
+		}
+
+		name := strings.TrimSpace(s[:eq])
+		s = s[eq+2:]
+
+		var value strings.Builder
+
+		i := 0
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+
+				continue
+			}
+
+			value.WriteByte(s[i])
+		}
+
+		if i >= len(s) {
+			return "", false
+		}
+
+		labels[name] = value.String()
+		s = s[i+1:]
+	}
+}
+
+// cadvisorLabel returns the label, falling back to the names used by
+// kubelets before 1.16.
+func cadvisorLabel(labels map[string]string, name string) string {
+	if v, ok := labels[name]; ok {
+		return v
+	}
+
+	return labels[name+"_name"]
+}
+
+// scrapeCadvisor reads container memory usage from each kubelet's
+// /metrics/cadvisor endpoint through the API server proxy. Usage is the
+// working set, as reported by the metrics API, so the results can stand in
+// for the metrics API lists.
+func scrapeCadvisor(ctx context.Context, kcs kubernetes.Interface, md *Metadata, nodeList *corev1.NodeList) (*metricsapi.NodeMetricsList, *metricsapi.PodMetricsList, *cadvisorUsage, error) {
+	nodeMetricsList := &metricsapi.NodeMetricsList{}
+	podMetricsList := &metricsapi.PodMetricsList{}
+	usage := &cadvisorUsage{
+		nodes:      map[string]*MemoryStats{},
+		containers: map[string]*MemoryStats{},
+	}
+
+	for _, node := range nodeList.Items {
+		sctx, sspan := tracer.Start(ctx, "scrape cadvisor", trace.WithAttributes(
+			attribute.String("k8s.node.name", node.Name),
+		))
+
+		data, err := kcs.CoreV1().RESTClient().Get().
+			AbsPath("/api/v1/nodes", node.Name, "proxy", "metrics", "cadvisor").
+			DoRaw(sctx)
+		endSpan(sspan, err)
+		if err != nil {
+			// An unreachable kubelet (e.g. a NotReady node) leaves the node
+			// out, as the metrics API does, rather than failing the report.
+			md.warnf("node %s: scrape cadvisor: %v", node.Name, err)
+			continue
+		}
+
+		nodeStats := &MemoryStats{}
+		nodeMetrics := metricsapi.NodeMetrics{
+			ObjectMeta: metav1.ObjectMeta{Name: node.Name},
+			Usage:      corev1.ResourceList{},
+		}
+
+		pods := map[string]*metricsapi.PodMetrics{}
+		podOrder := []string{}
+
+		for _, s := range parsePromText(data) {
+			var field *int64
+
+			container := cadvisorLabel(s.labels, "container")
+			pod := cadvisorLabel(s.labels, "pod")
+			namespace := s.labels["namespace"]
+
+			switch {
+			case s.labels["id"] == "/":
+				// The root cgroup is the node as a whole.
+				switch s.name {
+				case "container_memory_working_set_bytes":
+					nodeMetrics.Usage[corev1.ResourceMemory] = *resource.NewQuantity(int64(s.value), resource.BinarySI)
+				case "container_memory_rss":
+					field = &nodeStats.RSS
+				case "container_memory_cache":
+					field = &nodeStats.Cache
+				case "container_memory_mapped_file":
+					field = &nodeStats.MappedFile
+				case "container_memory_swap":
+					field = &nodeStats.Swap
+				}
+			case container != "" && container != "POD" && pod != "":
+				key := namespace + "/" + pod + "/" + container
+
+				cs, ok := usage.containers[key]
+				if !ok {
+					cs = &MemoryStats{}
+					usage.containers[key] = cs
+				}
+
+				switch s.name {
+				case "container_memory_working_set_bytes":
+					pm, ok := pods[namespace+"/"+pod]
+					if !ok {
+						pm = &metricsapi.PodMetrics{
+							ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: pod},
+						}
+						pods[namespace+"/"+pod] = pm
+						podOrder = append(podOrder, namespace+"/"+pod)
+					}
+
+					pm.Containers = append(pm.Containers, metricsapi.ContainerMetrics{
+						Name: container,
+						Usage: corev1.ResourceList{
+							corev1.ResourceMemory: *resource.NewQuantity(int64(s.value), resource.BinarySI),
+						},
+					})
+				case "container_memory_rss":
+					field = &cs.RSS
+				case "container_memory_cache":
+					field = &cs.Cache
+				case "container_memory_mapped_file":
+					field = &cs.MappedFile
+				case "container_memory_swap":
+					field = &cs.Swap
+				}
+			}
+
+			if field != nil {
+				*field = int64(s.value)
+			}
+		}
+
+		// Nodes without a root working set (e.g. a kubelet returning an
+		// empty scrape) are left out like the metrics API does.
+		if _, ok := nodeMetrics.Usage[corev1.ResourceMemory]; !ok {
+			continue
+		}
+
+		usage.nodes[node.Name] = nodeStats
+		nodeMetricsList.Items = append(nodeMetricsList.Items, nodeMetrics)
+
+		for _, key := range podOrder {
+			podMetricsList.Items = append(podMetricsList.Items, *pods[key])
+		}
+	}
+
+	return nodeMetricsList, podMetricsList, usage, nil
+}
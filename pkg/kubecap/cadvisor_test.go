@@ -0,0 +1,79 @@
+package kubecap
+
+import "testing"
+
+func TestParsePromText(t *testing.T) {
+	samples := parsePromText([]byte(`# HELP container_memory_rss Size of RSS in bytes.
+# TYPE container_memory_rss gauge
+container_memory_rss{container="app",id="/kubepods/pod1/abc",namespace="default",pod="web-0"} 1024 1700000000000
+container_memory_cache{id="/",path="C:\\tmp\\\"x\"\nend"} 2.5e+06
+
+machine_memory_bytes 4096
+broken{container="app" 1
+not_a_number{id="/"} NaN-ish
+`))
+
+	if len(samples) != 3 {
+		t.Fatalf("samples = %d, want 3: %v", len(samples), samples)
+	}
+
+	s := samples[0]
+	if s.name != "container_memory_rss" || s.value != 1024 {
+		t.Errorf("sample 0 = %s %v", s.name, s.value)
+	}
+
+	for name, want := range map[string]string{
+		"container": "app",
+		"id":        "/kubepods/pod1/abc",
+		"namespace": "default",
+		"pod":       "web-0",
+	} {
+		if got := s.labels[name]; got != want {
+			t.Errorf("sample 0 label %s = %q, want %q", name, got, want)
+		}
+	}
+
+	s = samples[1]
+	if s.value != 2.5e6 {
+		t.Errorf("sample 1 value = %v", s.value)
+	}
+
+	if got, want := s.labels["path"], "C:\\tmp\\\"x\"\nend"; got != want {
+		t.Errorf("sample 1 path = %q, want %q", got, want)
+	}
+
+	s = samples[2]
+	if s.name != "machine_memory_bytes" || s.value != 4096 || len(s.labels) != 0 {
+		t.Errorf("sample 2 = %s %v %v", s.name, s.labels, s.value)
+	}
+}
+
+func TestCadvisorLabel(t *testing.T) {
+	if got := cadvisorLabel(map[string]string{"container": "a", "container_name": "b"}, "container"); got != "a" {
+		t.Errorf("container = %q, want a", got)
+	}
+
+	// Kubelets before 1.16 use container_name and pod_name.
+	if got := cadvisorLabel(map[string]string{"pod_name": "p"}, "pod"); got != "p" {
+		t.Errorf("pod = %q, want p", got)
+	}
+
+	if got := cadvisorLabel(map[string]string{}, "pod"); got != "" {
+		t.Errorf("missing pod = %q", got)
+	}
+}
+
+func TestMemoryBreakdown(t *testing.T) {
+	for _, tc := range []struct {
+		md   Metadata
+		want bool
+	}{
+		{Metadata{UsageSource: "cadvisor"}, true},
+		{Metadata{UsageSource: "cadvisor", Resource: "cpu"}, false},
+		{Metadata{}, false},
+	} {
+		if got := tc.md.MemoryBreakdown(); got != tc.want {
+			t.Errorf("%s/%s: got %v, want %v", tc.md.UsageSource, tc.md.Resource, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package kubecap
+
+import (
+	"sort"
+)
+
+// ChurnReport is the nodes added and removed since the previous report of a
+// watch.
+type ChurnReport struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// NodeChurn tracks the nodes seen across the reports of a watch.
+type NodeChurn struct {
+	known map[string]bool
+}
+
+func NewNodeChurn() *NodeChurn {
+	return &NodeChurn{}
+}
+
+// update records the nodes now present and returns those added and removed
+// since the previous update. The first update has nothing to compare with and
+// returns nil.
+func (c *NodeChurn) update(nodes []string) *ChurnReport {
+	current := map[string]bool{}
+	for _, n := range nodes {
+		current[n] = true
+	}
+
+	previous := c.known
+	c.known = current
+
+	if previous == nil {
+		return nil
+	}
+
+	r := &ChurnReport{
+		Added:   []string{},
+		Removed: []string{},
+	}
+
+	for n := range current {
+		if !previous[n] {
+			r.Added = append(r.Added, n)
+		}
+	}
+
+	for n := range previous {
+		if !current[n] {
+			r.Removed = append(r.Removed, n)
+		}
+	}
+
+	sort.Strings(r.Added)
+	sort.Strings(r.Removed)
+
+	return r
+}
@@ -0,0 +1,24 @@
+package kubecap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNodeChurn(t *testing.T) {
+	c := NewNodeChurn()
+
+	if r := c.update([]string{"a", "b"}); r != nil {
+		t.Fatalf("first update = %+v, want nil", r)
+	}
+
+	r := c.update([]string{"b", "d", "c"})
+	if !reflect.DeepEqual(r, &ChurnReport{Added: []string{"c", "d"}, Removed: []string{"a"}}) {
+		t.Errorf("churn = %+v", r)
+	}
+
+	r = c.update([]string{"b", "c", "d"})
+	if len(r.Added) != 0 || len(r.Removed) != 0 {
+		t.Errorf("unchanged churn = %+v", r)
+	}
+}
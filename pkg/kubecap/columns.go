@@ -0,0 +1,431 @@
+package kubecap
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Column is a user defined node report column computed from the node's
+// fields with an arithmetic expression, e.g. buffer=free-requests*0.1.
+// Comparisons and logical operators give 1 when true and 0 otherwise.
+type Column struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+
+	expr columnExpr
+}
+
+// columnFields are the node report fields columns can refer to.
+var columnFields = map[string]func(n *NodeReport, md *Metadata) float64{
+	"allocatable":               func(n *NodeReport, md *Metadata) float64 { return float64(n.Allocatable) },
+	"used":                      func(n *NodeReport, md *Metadata) float64 { return float64(n.Used) },
+	"free":                      func(n *NodeReport, md *Metadata) float64 { return float64(n.Free) },
+	"requests":                  func(n *NodeReport, md *Metadata) float64 { return float64(n.Requests) },
+	"limits":                    func(n *NodeReport, md *Metadata) float64 { return float64(n.Limits) },
+	"schedulable":               func(n *NodeReport, md *Metadata) float64 { return float64(n.Schedulable) },
+	"efficiency":                func(n *NodeReport, md *Metadata) float64 { return n.Efficiency },
+	"pressure":                  func(n *NodeReport, md *Metadata) float64 { return n.Pressure },
+	"freeWithAdditional":        func(n *NodeReport, md *Metadata) float64 { return float64(n.FreeWithAdditional) },
+	"schedulableWithAdditional": func(n *NodeReport, md *Metadata) float64 { return float64(n.SchedulableWithAdditional) },
+	"pendingRequests":           func(n *NodeReport, md *Metadata) float64 { return float64(n.PendingRequests) },
+	"nominatedRequests":         func(n *NodeReport, md *Metadata) float64 { return float64(n.NominatedRequests) },
+	"terminatingRequests":       func(n *NodeReport, md *Metadata) float64 { return float64(n.TerminatingRequests) },
+	"stuckRequests":             func(n *NodeReport, md *Metadata) float64 { return float64(n.StuckRequests) },
+	"unmatched":                 func(n *NodeReport, md *Metadata) float64 { return float64(n.Unmatched) },
+	"pods":                      func(n *NodeReport, md *Metadata) float64 { return float64(len(n.Pods)) },
+	"additional":                func(n *NodeReport, md *Metadata) float64 { return float64(n.Additional) },
+	"podCapacity":               func(n *NodeReport, md *Metadata) float64 { return float64(n.PodCapacity) },
+	"podStarts":                 func(n *NodeReport, md *Metadata) float64 { return float64(n.PodChurn.starts()) },
+	"podStops":                  func(n *NodeReport, md *Metadata) float64 { return float64(n.PodChurn.stops()) },
+	"ok":                        func(n *NodeReport, md *Metadata) float64 { return truth(n.Ok) },
+	"excluded":                  func(n *NodeReport, md *Metadata) float64 { return truth(n.Excluded) },
+	"notReady":                  func(n *NodeReport, md *Metadata) float64 { return truth(n.NotReady) },
+	"outOfScope":                func(n *NodeReport, md *Metadata) float64 { return truth(n.OutOfScope) },
+}
+
+// nodeFields returns the node's fields by name, as columns refer to them.
+func (md *Metadata) nodeFields(n *NodeReport) func(name string) float64 {
+	return func(name string) float64 {
+		return columnFields[name](n, md)
+	}
+}
+
+// truth returns 1 for true and 0 for false.
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// columnExpr is a parsed column expression, evaluated with the values of the
+// fields it refers to.
+type columnExpr interface {
+	eval(field func(name string) float64) float64
+}
+
+type columnNumber float64
+
+func (c columnNumber) eval(field func(name string) float64) float64 {
+	return float64(c)
+}
+
+type columnField string
+
+func (c columnField) eval(field func(name string) float64) float64 {
+	return field(string(c))
+}
+
+type columnNeg struct {
+	x columnExpr
+}
+
+func (c columnNeg) eval(field func(name string) float64) float64 {
+	return -c.x.eval(field)
+}
+
+type columnNot struct {
+	x columnExpr
+}
+
+func (c columnNot) eval(field func(name string) float64) float64 {
+	return truth(c.x.eval(field) == 0)
+}
+
+type columnOp struct {
+	op   string
+	x, y columnExpr
+}
+
+// eval applies the operator. Dividing by zero gives zero rather than an
+// infinity, which JSON can't represent, as with efficiency. && and || don't
+// evaluate y when x decides.
+func (c columnOp) eval(field func(name string) float64) float64 {
+	x := c.x.eval(field)
+
+	switch c.op {
+	case "&&":
+		return truth(x != 0 && c.y.eval(field) != 0)
+	case "||":
+		return truth(x != 0 || c.y.eval(field) != 0)
+	}
+
+	y := c.y.eval(field)
+
+	switch c.op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "<":
+		return truth(x < y)
+	case "<=":
+		return truth(x <= y)
+	case ">":
+		return truth(x > y)
+	case ">=":
+		return truth(x >= y)
+	case "==":
+		return truth(x == y)
+	case "!=":
+		return truth(x != y)
+	default:
+		if y == 0 {
+			return 0
+		}
+
+		return x / y
+	}
+}
+
+// fieldSet returns the names of the fields.
+func fieldSet(fields map[string]func(n *NodeReport, md *Metadata) float64) map[string]bool {
+	set := map[string]bool{}
+	for name := range fields {
+		set[name] = true
+	}
+
+	return set
+}
+
+// ParseColumn parses a NAME=EXPR column definition. Expressions are made of
+// numbers, node report fields, + - * / and parentheses.
+func ParseColumn(s string) (*Column, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return nil, fmt.Errorf("column %q: want NAME=EXPR", s)
+	}
+
+	c := &Column{
+		Name: strings.TrimSpace(s[:i]),
+		Expr: strings.TrimSpace(s[i+1:]),
+	}
+
+	p := &columnParser{s: c.Expr, fields: fieldSet(columnFields)}
+
+	var err error
+
+	c.expr, err = p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %w", c.Name, err)
+	}
+
+	return c, nil
+}
+
+// evalColumns evaluates md's columns for the node, if any.
+func (md *Metadata) evalColumns(n *NodeReport) map[string]float64 {
+	if len(md.Columns) == 0 {
+		return nil
+	}
+
+	values := map[string]float64{}
+	for _, c := range md.Columns {
+		values[c.Name] = c.expr.eval(md.nodeFields(n))
+	}
+
+	return values
+}
+
+// columnParser is a recursive descent parser of column expressions over the
+// fields.
+type columnParser struct {
+	s      string
+	pos    int
+	fields map[string]bool
+}
+
+// fieldNames lists the fields expressions can refer to, for errors.
+func (p *columnParser) fieldNames() string {
+	names := []string{}
+	for name := range p.fields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+func (p *columnParser) parse() (columnExpr, error) {
+	x, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.s[p.pos:], p.pos)
+	}
+
+	return x, nil
+}
+
+func (p *columnParser) skipSpace() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end.
+func (p *columnParser) peek() byte {
+	p.skipSpace()
+
+	if p.pos >= len(p.s) {
+		return 0
+	}
+
+	return p.s[p.pos]
+}
+
+// operator consumes and returns the next operator if it is one of ops, or
+// returns "".
+func (p *columnParser) operator(ops ...string) string {
+	p.skipSpace()
+
+	for _, op := range ops {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			p.pos += len(op)
+
+			return op
+		}
+	}
+
+	return ""
+}
+
+// or parses conjunctions separated by ||.
+func (p *columnParser) or() (columnExpr, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.operator("||") != "" {
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+
+		x = columnOp{"||", x, y}
+	}
+
+	return x, nil
+}
+
+// and parses comparisons separated by &&.
+func (p *columnParser) and() (columnExpr, error) {
+	x, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.operator("&&") != "" {
+		y, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+
+		x = columnOp{"&&", x, y}
+	}
+
+	return x, nil
+}
+
+// comparison parses a sum, optionally compared with another.
+func (p *columnParser) comparison() (columnExpr, error) {
+	x, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	// The two character operators go first.
+	op := p.operator("<=", ">=", "==", "!=", "<", ">")
+	if op == "" {
+		return x, nil
+	}
+
+	y, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	return columnOp{op, x, y}, nil
+}
+
+// sum parses terms separated by + and -.
+func (p *columnParser) sum() (columnExpr, error) {
+	x, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+
+		y, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+
+		x = columnOp{string(op), x, y}
+	}
+
+	return x, nil
+}
+
+// product parses factors separated by * and /.
+func (p *columnParser) product() (columnExpr, error) {
+	x, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+
+		y, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+
+		x = columnOp{string(op), x, y}
+	}
+
+	return x, nil
+}
+
+// factor parses a number, a field, a negation, a logical not or a
+// parenthesized expression.
+func (p *columnParser) factor() (columnExpr, error) {
+	c := p.peek()
+
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '-':
+		p.pos++
+
+		x, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+
+		return columnNeg{x}, nil
+	case c == '!':
+		p.pos++
+
+		x, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+
+		return columnNot{x}, nil
+	case c == '(':
+		p.pos++
+
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+
+		p.pos++
+
+		return x, nil
+	case c == '.' || unicode.IsDigit(rune(c)):
+		start := p.pos
+		for p.pos < len(p.s) && (p.s[p.pos] == '.' || unicode.IsDigit(rune(p.s[p.pos]))) {
+			p.pos++
+		}
+
+		v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return columnNumber(v), nil
+	case unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.s) && (unicode.IsLetter(rune(p.s[p.pos])) || unicode.IsDigit(rune(p.s[p.pos]))) {
+			p.pos++
+		}
+
+		name := p.s[start:p.pos]
+		if !p.fields[name] {
+			return nil, fmt.Errorf("unknown field %q (want one of %s)", name, p.fieldNames())
+		}
+
+		return columnField(name), nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q at %d", c, p.pos)
+}
@@ -0,0 +1,41 @@
+package kubecap
+
+import "testing"
+
+func TestParseColumn(t *testing.T) {
+	n := &NodeReport{Allocatable: 100, Free: 40, Requests: 50, Additional: 10}
+	md := &Metadata{Additional: 10}
+
+	for _, tc := range []struct {
+		def  string
+		want float64
+	}{
+		{"buffer=free-requests*0.1", 35},
+		{"b = (free - requests) * 0.1", -1},
+		{"left=allocatable-requests-additional", 40},
+		{"neg=-free/4", -10},
+		{"zero=free/unmatched", 0},
+		{"fits=free >= additional * 4 && !excluded", 1},
+		{"tight=requests*2 > allocatable || ok", 0},
+		{"ne=(free != 40) + 1", 1},
+	} {
+		c, err := ParseColumn(tc.def)
+		if err != nil {
+			t.Errorf("%s: %v", tc.def, err)
+
+			continue
+		}
+
+		md.Columns = []*Column{c}
+
+		if got := md.evalColumns(n)[c.Name]; got != tc.want {
+			t.Errorf("%s = %g, want %g", tc.def, got, tc.want)
+		}
+	}
+
+	for _, def := range []string{"free", "x=", "x=free+", "x=(free", "x=bogus*2", "x=free requests", "x=free = 1", "x=free &&", "x=!"} {
+		if _, err := ParseColumn(def); err == nil {
+			t.Errorf("%s: want error", def)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package kubecap
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ContainerCPU is a container's CPU requests, usage and limits in
+// millicores, reported alongside its memory. A container over its memory
+// request that also uses more CPU than it requested calls for a different
+// remediation (resizing both, or throttling) than memory alone.
+type ContainerCPU struct {
+	Requests int64 `json:"requests"`
+	Used     int64 `json:"used"`
+	Limits   int64 `json:"limits"`
+
+	// OverRequest is set when Used exceeds Requests.
+	OverRequest bool `json:"overRequest"`
+}
+
+// ContainerCPU reports whether the containers' CPU is reported alongside the
+// resource the report is about: it is unless that is CPU.
+func (md *Metadata) ContainerCPU() bool {
+	return md.ResourceName() != corev1.ResourceCPU
+}
+
+// containerCPU returns the container's CPU when it is reported and the pod
+// metrics have its CPU usage, nil otherwise.
+func (snap *snapshot) containerCPU(md *Metadata, pod *corev1.Pod, container *corev1.Container) *ContainerCPU {
+	if !md.ContainerCPU() {
+		return nil
+	}
+
+	used, ok := snap.containerCPUUsage[pod.Namespace+"/"+pod.Name+"/"+container.Name]
+	if !ok {
+		return nil
+	}
+
+	c := &ContainerCPU{
+		Requests: container.Resources.Requests.Cpu().MilliValue(),
+		Used:     used,
+		Limits:   container.Resources.Limits.Cpu().MilliValue(),
+	}
+
+	c.OverRequest = c.Used > c.Requests
+
+	return c
+}
@@ -0,0 +1,53 @@
+package kubecap
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestContainerCPU(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-0"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+						Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+					},
+				},
+				{Name: "sidecar"},
+				{Name: "idle"},
+			},
+		},
+	}
+
+	snap := &snapshot{containerCPUUsage: map[string]int64{
+		"shop/web-0/web":     1500,
+		"shop/web-0/sidecar": 0,
+	}}
+
+	md := &Metadata{}
+
+	got := snap.containerCPU(md, pod, &pod.Spec.Containers[0])
+	if want := (ContainerCPU{Requests: 500, Used: 1500, Limits: 2000, OverRequest: true}); got == nil || *got != want {
+		t.Errorf("web = %+v, want %+v", got, want)
+	}
+
+	if got := snap.containerCPU(md, pod, &pod.Spec.Containers[1]); got == nil || got.OverRequest {
+		t.Errorf("sidecar = %+v, want not over its requests", got)
+	}
+
+	// Without usage, and in a CPU report, there is nothing to add.
+	if got := snap.containerCPU(md, pod, &pod.Spec.Containers[2]); got != nil {
+		t.Errorf("idle = %+v, want nil", got)
+	}
+
+	if got := snap.containerCPU(&Metadata{Resource: "cpu"}, pod, &pod.Spec.Containers[0]); got != nil {
+		t.Errorf("cpu report = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,162 @@
+package kubecap
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CordonReport describes a cordoned node. Nothing new is scheduled onto it,
+// so its allocatable amount is held out of the cluster's capacity until it is
+// uncordoned; forgotten cordons are a common hidden capacity loss.
+type CordonReport struct {
+	// Since is when the node was cordoned as far as known: from the node
+	// controller's NodeNotSchedulable event or, as events are only kept for
+	// an hour by default, when a watch first saw it cordoned. It is zero when
+	// unknown.
+	Since time.Time `json:"since"`
+
+	// Held is the node's schedulable amount: what its pods don't request
+	// of its allocatable amount, which the cordon keeps from being used.
+	Held int64 `json:"held"`
+}
+
+// NodeCordons tracks when each node was first seen cordoned across the
+// reports of a watch.
+type NodeCordons struct {
+	since map[string]time.Time
+}
+
+func NewNodeCordons() *NodeCordons {
+	return &NodeCordons{since: map[string]time.Time{}}
+}
+
+// update records the nodes now cordoned, each since the earlier of its event
+// (when known) and when first seen cordoned, and forgets the rest. It returns
+// when each cordoned node was cordoned.
+func (c *NodeCordons) update(cordoned []string, events map[string]time.Time, now time.Time) map[string]time.Time {
+	current := map[string]time.Time{}
+
+	for _, name := range cordoned {
+		since, ok := c.since[name]
+		if !ok {
+			since = now
+		}
+
+		if ev, ok := events[name]; ok && ev.Before(since) {
+			since = ev
+		}
+
+		current[name] = since
+	}
+
+	c.since = current
+
+	return current
+}
+
+// listCordonEvents returns when each node currently cordoned according to
+// the node controller's events was cordoned: the time of its latest
+// NodeNotSchedulable event when no NodeSchedulable event followed it.
+func listCordonEvents(ctx context.Context, kcs kubernetes.Interface) (since map[string]time.Time, err error) {
+	ctx, span := tracer.Start(ctx, "list node events")
+	defer func() { endSpan(span, err) }()
+
+	eventList, err := kcs.CoreV1().Events("").List(ctx, metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=Node",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type latest struct {
+		at       time.Time
+		cordoned bool
+	}
+
+	nodes := map[string]latest{}
+
+	for i := range eventList.Items {
+		ev := &eventList.Items[i]
+
+		if ev.InvolvedObject.Kind != "Node" || (ev.Reason != "NodeNotSchedulable" && ev.Reason != "NodeSchedulable") {
+			continue
+		}
+
+		at := eventTime(ev.LastTimestamp, ev)
+
+		if l, ok := nodes[ev.InvolvedObject.Name]; ok && !at.After(l.at) {
+			continue
+		}
+
+		nodes[ev.InvolvedObject.Name] = latest{at: at, cordoned: ev.Reason == "NodeNotSchedulable"}
+	}
+
+	since = map[string]time.Time{}
+
+	for name, l := range nodes {
+		if l.cordoned {
+			since[name] = l.at
+		}
+	}
+
+	return since, nil
+}
+
+// cordonReport returns the node's cordon report, nil when it isn't cordoned.
+func (snap *snapshot) cordonReport(node *corev1.Node, schedulable int64) *CordonReport {
+	if snap.cordons == nil || !node.Spec.Unschedulable {
+		return nil
+	}
+
+	held := schedulable
+	if held < 0 {
+		held = 0
+	}
+
+	return &CordonReport{
+		Since: snap.cordons[node.Name],
+		Held:  held,
+	}
+}
+
+// CordonSummary totals the capacity held by cordoned nodes.
+type CordonSummary struct {
+	Nodes       int   `json:"nodes"`
+	Allocatable int64 `json:"allocatable"`
+	Held        int64 `json:"held"`
+
+	// Oldest is when the longest cordoned node (of those known) was
+	// cordoned.
+	Oldest time.Time `json:"oldest"`
+}
+
+// SummarizeCordons totals the cordoned nodes. It returns nil when there are
+// none.
+func SummarizeCordons(nodes []*NodeReport) *CordonSummary {
+	var s *CordonSummary
+
+	for _, n := range nodes {
+		c := n.Cordon
+		if c == nil {
+			continue
+		}
+
+		if s == nil {
+			s = &CordonSummary{}
+		}
+
+		s.Nodes++
+		s.Allocatable += n.Allocatable
+		s.Held += c.Held
+
+		if !c.Since.IsZero() && (s.Oldest.IsZero() || c.Since.Before(s.Oldest)) {
+			s.Oldest = c.Since
+		}
+	}
+
+	return s
+}
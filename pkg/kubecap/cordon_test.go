@@ -0,0 +1,87 @@
+package kubecap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestListCordonEvents(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	event := func(name, node, reason string, ago time.Duration) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: name},
+			InvolvedObject: corev1.ObjectReference{Kind: "Node", Name: node},
+			Reason:         reason,
+			LastTimestamp:  metav1.NewTime(now.Add(-ago)),
+		}
+	}
+
+	kcs := fake.NewSimpleClientset(
+		event("a1", "node-a", "NodeNotSchedulable", 40*time.Minute),
+		event("b1", "node-b", "NodeNotSchedulable", 50*time.Minute),
+		event("b2", "node-b", "NodeSchedulable", 10*time.Minute),
+		event("c1", "node-c", "NodeNotReady", 5*time.Minute),
+	)
+
+	since, err := listCordonEvents(context.Background(), kcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(since) != 1 || !since["node-a"].Equal(now.Add(-40*time.Minute)) {
+		t.Errorf("since = %v", since)
+	}
+}
+
+func TestNodeCordons(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := NewNodeCordons()
+
+	// Without an event the node is cordoned since first seen.
+	since := c.update([]string{"node-a"}, nil, start)
+	if !since["node-a"].Equal(start) {
+		t.Errorf("first seen: since = %v", since)
+	}
+
+	// An earlier event is more accurate, a later one isn't.
+	since = c.update([]string{"node-a", "node-b"}, map[string]time.Time{
+		"node-a": start.Add(time.Minute),
+		"node-b": start.Add(-time.Hour),
+	}, start.Add(5*time.Minute))
+	if !since["node-a"].Equal(start) || !since["node-b"].Equal(start.Add(-time.Hour)) {
+		t.Errorf("events: since = %v", since)
+	}
+
+	// Uncordoned nodes are forgotten: cordoned again they start over.
+	c.update([]string{"node-b"}, nil, start.Add(10*time.Minute))
+
+	since = c.update([]string{"node-a", "node-b"}, nil, start.Add(15*time.Minute))
+	if !since["node-a"].Equal(start.Add(15 * time.Minute)) {
+		t.Errorf("cordoned again: since = %v", since)
+	}
+}
+
+func TestSummarizeCordons(t *testing.T) {
+	if s := SummarizeCordons([]*NodeReport{{Name: "node-a"}}); s != nil {
+		t.Errorf("no cordons: %+v", s)
+	}
+
+	oldest := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	s := SummarizeCordons([]*NodeReport{
+		{Name: "node-a", Allocatable: 16, Cordon: &CordonReport{Since: oldest.Add(time.Hour), Held: 10}},
+		{Name: "node-b", Allocatable: 16},
+		{Name: "node-c", Allocatable: 8, Cordon: &CordonReport{Since: oldest, Held: 4}},
+		{Name: "node-d", Allocatable: 8, Cordon: &CordonReport{Held: 8}},
+	})
+
+	if s.Nodes != 3 || s.Allocatable != 32 || s.Held != 22 || !s.Oldest.Equal(oldest) {
+		t.Errorf("summary = %+v", s)
+	}
+}
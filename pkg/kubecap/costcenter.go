@@ -0,0 +1,108 @@
+package kubecap
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// CostCenters maps pods to cost centers for chargeback: by the first label
+// selector matching the pod, then by its namespace, then the default.
+type CostCenters struct {
+	Labels []struct {
+		Selector   string `json:"selector"`
+		CostCenter string `json:"costCenter"`
+	} `json:"labels"`
+	Namespaces map[string]string `json:"namespaces"`
+	Default    string            `json:"default"`
+
+	selectors []labels.Selector
+}
+
+// LoadCostCenters reads the cost center mapping file.
+func LoadCostCenters(path string) (*CostCenters, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cc := &CostCenters{}
+
+	err = yaml.UnmarshalStrict(data, cc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, l := range cc.Labels {
+		selector, err := labels.Parse(l.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("%s: labels: selector %q: %w", path, l.Selector, err)
+		}
+
+		cc.selectors = append(cc.selectors, selector)
+	}
+
+	return cc, nil
+}
+
+// of returns the pod's cost center.
+func (cc *CostCenters) of(pod *corev1.Pod) string {
+	for i, selector := range cc.selectors {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			return cc.Labels[i].CostCenter
+		}
+	}
+
+	if c, ok := cc.Namespaces[pod.Namespace]; ok {
+		return c
+	}
+
+	return cc.Default
+}
+
+// CostCenterReport is the memory of a cost center's pods in a cluster.
+type CostCenterReport struct {
+	Cluster    string `json:"cluster"`
+	CostCenter string `json:"costCenter"`
+	Pods       int64  `json:"pods"`
+	Requests   int64  `json:"requests"`
+	Used       int64  `json:"used"`
+}
+
+// CostCenterReports totals the pods on the nodes by cluster and cost center,
+// sorted by both.
+func CostCenterReports(nodes []*NodeReport) []*CostCenterReport {
+	byKey := map[string]*CostCenterReport{}
+	reports := []*CostCenterReport{}
+
+	for _, n := range nodes {
+		for _, p := range n.Pods {
+			key := n.Cluster + "\x00" + p.CostCenter
+
+			r, ok := byKey[key]
+			if !ok {
+				r = &CostCenterReport{Cluster: n.Cluster, CostCenter: p.CostCenter}
+				byKey[key] = r
+				reports = append(reports, r)
+			}
+
+			r.Pods++
+			r.Requests += p.Requests
+			r.Used += p.Used
+		}
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Cluster != reports[j].Cluster {
+			return reports[i].Cluster < reports[j].Cluster
+		}
+
+		return reports[i].CostCenter < reports[j].CostCenter
+	})
+
+	return reports
+}
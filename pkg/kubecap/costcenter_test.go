@@ -0,0 +1,61 @@
+package kubecap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCostCenters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cost-centers.yaml")
+
+	err := os.WriteFile(path, []byte(`
+labels:
+  - selector: team=payments
+    costCenter: cc-payments
+namespaces:
+  shop: cc-retail
+default: unallocated
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := LoadCostCenters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		namespace string
+		labels    map[string]string
+		want      string
+	}{
+		{"shop", map[string]string{"team": "payments"}, "cc-payments"},
+		{"shop", nil, "cc-retail"},
+		{"other", nil, "unallocated"},
+	} {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: tc.namespace, Labels: tc.labels}}
+
+		if got := cc.of(pod); got != tc.want {
+			t.Errorf("%s %v: cost center = %q, want %q", tc.namespace, tc.labels, got, tc.want)
+		}
+	}
+
+	reports := CostCenterReports([]*NodeReport{
+		{Cluster: "c", Pods: []*PodReport{
+			{CostCenter: "cc-retail", Requests: 2, Used: 1},
+			{CostCenter: "cc-payments", Requests: 4, Used: 4},
+		}},
+		{Cluster: "c", Pods: []*PodReport{
+			{CostCenter: "cc-retail", Requests: 1, Used: 3},
+		}},
+	})
+
+	if len(reports) != 2 || reports[0].CostCenter != "cc-payments" || reports[1].Pods != 2 || reports[1].Requests != 3 || reports[1].Used != 4 {
+		t.Errorf("reports = %+v, %+v", reports[0], reports[1])
+	}
+}
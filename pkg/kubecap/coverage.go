@@ -0,0 +1,141 @@
+package kubecap
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Coverage counts the pods and containers with memory and CPU requests set.
+// Finished pods are left out.
+type Coverage struct {
+	Pods       int64 `json:"pods"`
+	Containers int64 `json:"containers"`
+
+	// MemoryPods and CPUPods are the pods with requests set for every
+	// container (or at the pod level) and MemoryContainers and
+	// CPUContainers the containers with requests set (or covered by
+	// pod-level requests).
+	MemoryPods       int64 `json:"memoryPods"`
+	CPUPods          int64 `json:"cpuPods"`
+	MemoryContainers int64 `json:"memoryContainers"`
+	CPUContainers    int64 `json:"cpuContainers"`
+}
+
+// coverageFraction returns n/total, or 1 when there is nothing to cover.
+func coverageFraction(n, total int64) float64 {
+	if total == 0 {
+		return 1
+	}
+
+	return float64(n) / float64(total)
+}
+
+// Memory is the fraction of containers with memory requests.
+func (c *Coverage) Memory() float64 {
+	return coverageFraction(c.MemoryContainers, c.Containers)
+}
+
+// CPU is the fraction of containers with CPU requests.
+func (c *Coverage) CPU() float64 {
+	return coverageFraction(c.CPUContainers, c.Containers)
+}
+
+// Complete reports whether every container has memory and CPU requests.
+func (c *Coverage) Complete() bool {
+	return c.MemoryContainers == c.Containers && c.CPUContainers == c.Containers
+}
+
+func (c *Coverage) add(o *Coverage) {
+	c.Pods += o.Pods
+	c.Containers += o.Containers
+	c.MemoryPods += o.MemoryPods
+	c.CPUPods += o.CPUPods
+	c.MemoryContainers += o.MemoryContainers
+	c.CPUContainers += o.CPUContainers
+}
+
+// NamespaceCoverage is a namespace's requests coverage.
+type NamespaceCoverage struct {
+	Namespace string `json:"namespace"`
+	Coverage
+}
+
+// CoverageReport is the requests coverage cluster-wide and per namespace
+// (sorted by name).
+type CoverageReport struct {
+	Coverage
+	Namespaces []*NamespaceCoverage `json:"namespaces"`
+}
+
+// podCoverage returns the pod's requests coverage.
+func podCoverage(pod *corev1.Pod, pr PodResources) *Coverage {
+	c := &Coverage{Pods: 1}
+
+	// Pod-level requests cover every container.
+	podLevel := pr[pod.Namespace+"/"+pod.Name].Requests
+	_, podMemory := podLevel[corev1.ResourceMemory]
+	_, podCPU := podLevel[corev1.ResourceCPU]
+
+	for _, container := range pod.Spec.Containers {
+		c.Containers++
+
+		if _, ok := container.Resources.Requests[corev1.ResourceMemory]; ok || podMemory {
+			c.MemoryContainers++
+		}
+
+		if _, ok := container.Resources.Requests[corev1.ResourceCPU]; ok || podCPU {
+			c.CPUContainers++
+		}
+	}
+
+	if c.MemoryContainers == c.Containers {
+		c.MemoryPods++
+	}
+
+	if c.CPUContainers == c.Containers {
+		c.CPUPods++
+	}
+
+	return c
+}
+
+// requestsCoverage reports the requests coverage of the pods that haven't
+// finished.
+func requestsCoverage(pods []corev1.Pod, pr PodResources) *CoverageReport {
+	r := &CoverageReport{}
+	namespaces := map[string]*NamespaceCoverage{}
+
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		nc, ok := namespaces[pod.Namespace]
+		if !ok {
+			nc = &NamespaceCoverage{Namespace: pod.Namespace}
+			namespaces[pod.Namespace] = nc
+			r.Namespaces = append(r.Namespaces, nc)
+		}
+
+		c := podCoverage(pod, pr)
+		nc.add(c)
+		r.add(c)
+	}
+
+	sort.Slice(r.Namespaces, func(i, j int) bool {
+		return r.Namespaces[i].Namespace < r.Namespaces[j].Namespace
+	})
+
+	return r
+}
+
+// CoverageReport returns the report's requests coverage, if known.
+func (md *Metadata) CoverageReport() *CoverageReport {
+	if md == nil {
+		return nil
+	}
+
+	return md.Coverage
+}
@@ -0,0 +1,67 @@
+package kubecap
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRequestsCoverage(t *testing.T) {
+	requests := func(names ...corev1.ResourceName) corev1.ResourceRequirements {
+		r := corev1.ResourceRequirements{Requests: corev1.ResourceList{}}
+		for _, name := range names {
+			r.Requests[name] = resource.MustParse("1")
+		}
+
+		return r
+	}
+
+	pod := func(namespace, name string, phase corev1.PodPhase, containers ...corev1.ResourceRequirements) corev1.Pod {
+		p := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+
+		for _, r := range containers {
+			p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Resources: r})
+		}
+
+		return p
+	}
+
+	r := requestsCoverage([]corev1.Pod{
+		pod("b", "full", corev1.PodRunning, requests(corev1.ResourceMemory, corev1.ResourceCPU)),
+		pod("a", "partial", corev1.PodRunning, requests(corev1.ResourceMemory), requests()),
+		pod("a", "pod-level", corev1.PodPending, requests(), requests()),
+		pod("a", "done", corev1.PodSucceeded, requests()),
+	}, PodResources{
+		"a/pod-level": requests(corev1.ResourceCPU),
+	})
+
+	if r.Pods != 3 || r.Containers != 5 || r.MemoryContainers != 2 || r.CPUContainers != 3 || r.MemoryPods != 1 || r.CPUPods != 2 {
+		t.Errorf("cluster = %+v", r.Coverage)
+	}
+
+	if got := r.Memory(); got != 0.4 {
+		t.Errorf("memory = %v, want 0.4", got)
+	}
+
+	if len(r.Namespaces) != 2 || r.Namespaces[0].Namespace != "a" || r.Namespaces[1].Namespace != "b" {
+		t.Fatalf("namespaces = %+v", r.Namespaces)
+	}
+
+	if a := r.Namespaces[0]; a.Complete() || a.Containers != 4 || a.CPUContainers != 2 {
+		t.Errorf("a = %+v", a.Coverage)
+	}
+
+	if b := r.Namespaces[1]; !b.Complete() {
+		t.Errorf("b = %+v", b.Coverage)
+	}
+
+	empty := &Coverage{}
+	if empty.Memory() != 1 || !empty.Complete() {
+		t.Errorf("nothing to cover isn't complete")
+	}
+}
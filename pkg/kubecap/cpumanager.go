@@ -0,0 +1,113 @@
+package kubecap
+
+import (
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// CPUManagerReport describes a node whose kubelet runs the CPU Manager with
+// the static policy, which gives Guaranteed containers with integer CPU
+// requests exclusive cores. Amounts are in whole CPUs.
+type CPUManagerReport struct {
+	Capacity int64 `json:"capacity"`
+
+	// Reserved are the CPUs held back for system daemons. They stay in the
+	// shared pool but can't be handed out exclusively.
+	Reserved int64 `json:"reserved"`
+
+	// Exclusive are the CPUs pinned by Guaranteed containers and
+	// AvailableExclusive those that can still be.
+	Exclusive          int64 `json:"exclusive"`
+	AvailableExclusive int64 `json:"availableExclusive"`
+
+	// SharedPool are the CPUs left for all other containers.
+	SharedPool int64 `json:"sharedPool"`
+}
+
+// cpusetSize counts the CPUs in a cpuset list such as "0-1,4".
+func cpusetSize(s string) int64 {
+	var n int64
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		lo, err := strconv.ParseInt(bounds[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		hi := lo
+		if len(bounds) == 2 {
+			hi, err = strconv.ParseInt(bounds[1], 10, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		n += hi - lo + 1
+	}
+
+	return n
+}
+
+// exclusiveCPUs returns the CPUs the static policy pins for the pod's
+// containers: those of Guaranteed pods with integer CPU requests.
+func exclusiveCPUs(pod *corev1.Pod) int64 {
+	if pod.Status.QOSClass != corev1.PodQOSGuaranteed {
+		return 0
+	}
+
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return 0
+	}
+
+	var n int64
+	for _, container := range pod.Spec.Containers {
+		milli := container.Resources.Requests.Cpu().MilliValue()
+		if milli > 0 && milli%1000 == 0 {
+			n += milli / 1000
+		}
+	}
+
+	return n
+}
+
+// nodeCPUManager reports exclusive CPU use on nodes using the static CPU
+// Manager policy according to their kubelet configuration. It returns nil for
+// other nodes and when the configuration couldn't be read.
+func nodeCPUManager(node *corev1.Node, pods []*corev1.Pod, cfg *kubeletConfigz) *CPUManagerReport {
+	if cfg == nil || !strings.EqualFold(cfg.KubeletConfig.CPUManagerPolicy, "static") {
+		return nil
+	}
+
+	capacity := node.Status.Capacity.Cpu().Value()
+
+	// Without an explicit reserved CPU set the static policy reserves the
+	// kube and system reservations rounded up to whole CPUs.
+	var reserved int64
+	if cfg.KubeletConfig.ReservedSystemCPUs != "" {
+		reserved = cpusetSize(cfg.KubeletConfig.ReservedSystemCPUs)
+	} else {
+		milli := node.Status.Capacity.Cpu().MilliValue() - node.Status.Allocatable.Cpu().MilliValue()
+		reserved = (milli + 999) / 1000
+	}
+
+	var exclusive int64
+	for _, pod := range pods {
+		exclusive += exclusiveCPUs(pod)
+	}
+
+	return &CPUManagerReport{
+		Capacity:           capacity,
+		Reserved:           reserved,
+		Exclusive:          exclusive,
+		AvailableExclusive: capacity - reserved - exclusive,
+		SharedPool:         capacity - exclusive,
+	}
+}
@@ -0,0 +1,20 @@
+package kubecap
+
+import "testing"
+
+func TestCPUSetSize(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want int64
+	}{
+		{"", 0},
+		{"3", 1},
+		{"0-1,4", 3},
+		{"0-3, 8-11", 8},
+		{"0-1,x,4", 3},
+	} {
+		if got := cpusetSize(tc.s); got != tc.want {
+			t.Errorf("cpusetSize(%q) = %d, want %d", tc.s, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package kubecap
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+// CustomReport is a user defined report loaded at runtime: the nodes or
+// their pods, filtered, grouped and summarized into columns, e.g. the batch
+// nodes by zone with their GPUs. Where, the group-bys and the columns are Go
+// templates executed on a CustomRow.
+type CustomReport struct {
+	Name string `json:"name"`
+
+	// From is what the rows are: nodes (the default) or pods.
+	From string `json:"from,omitempty"`
+
+	// Where keeps the rows it renders true for, all when empty.
+	Where string `json:"where,omitempty"`
+
+	// GroupBy are the columns the rows are grouped by. Without any, each
+	// row is reported on its own.
+	GroupBy []*CustomColumn `json:"groupBy,omitempty"`
+
+	// Columns are the columns reported, each combining its group's values
+	// with its Agg.
+	Columns []*CustomColumn `json:"columns"`
+
+	// Sort is the column (group-by or not) the rows are sorted by,
+	// descending when prefixed with -. The rows are sorted by the group-bys
+	// otherwise. Limit keeps only as many of the first rows, if set.
+	Sort  string `json:"sort,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+
+	where *template.Template
+}
+
+// CustomColumn is a custom report column.
+type CustomColumn struct {
+	Name string `json:"name"`
+
+	// Value is the template rendering the column's value for a row.
+	Value string `json:"value,omitempty"`
+
+	// Agg combines the values of the rows grouped together: sum, avg, min
+	// or max of the numeric values, count of the rows (with a value, when
+	// one is given), or first (the default).
+	Agg string `json:"agg,omitempty"`
+
+	value *template.Template
+}
+
+// Custom report row sources.
+const (
+	CustomFromNodes = "nodes"
+	CustomFromPods  = "pods"
+)
+
+// customAggs are the aggregations columns can use.
+var customAggs = map[string]bool{
+	"":      true,
+	"first": true,
+	"count": true,
+	"sum":   true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+}
+
+// CustomRow is what a custom report's templates are executed on: the node
+// and, for reports from pods, the pod.
+type CustomRow struct {
+	Node *NodeReport
+	Pod  *PodReport
+}
+
+// CustomReportResult is a custom report's rows: the group-bys' values and
+// then the columns'.
+type CustomReportResult struct {
+	Name    string     `json:"name"`
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+}
+
+// customFuncs are the functions available to the templates besides the
+// builtin ones: arithmetic on numbers of any type.
+var customFuncs = template.FuncMap{
+	"add": func(x, y interface{}) (float64, error) {
+		return customArith(x, y, func(a, b float64) float64 { return a + b })
+	},
+	"sub": func(x, y interface{}) (float64, error) {
+		return customArith(x, y, func(a, b float64) float64 { return a - b })
+	},
+	"mul": func(x, y interface{}) (float64, error) {
+		return customArith(x, y, func(a, b float64) float64 { return a * b })
+	},
+	"div": func(x, y interface{}) (float64, error) {
+		// Dividing by zero gives zero, as with columns.
+		return customArith(x, y, func(a, b float64) float64 {
+			if b == 0 {
+				return 0
+			}
+
+			return a / b
+		})
+	},
+}
+
+// customArith applies op to x and y as float64s.
+func customArith(x, y interface{}, op func(a, b float64) float64) (float64, error) {
+	a, err := customNumber(x)
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := customNumber(y)
+	if err != nil {
+		return 0, err
+	}
+
+	return op(a, b), nil
+}
+
+// customNumber converts a template value to a float64.
+func customNumber(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+
+	return 0, fmt.Errorf("not a number: %v", v)
+}
+
+// LoadCustomReports reads a YAML list of custom reports.
+func LoadCustomReports(path string) ([]*CustomReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []*CustomReport{}
+
+	err = yaml.UnmarshalStrict(data, &reports)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, r := range reports {
+		err = r.compile()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return reports, nil
+}
+
+// compile checks the report and parses its templates.
+func (r *CustomReport) compile() (err error) {
+	if r.Name == "" {
+		return fmt.Errorf("report without a name")
+	}
+
+	if r.From == "" {
+		r.From = CustomFromNodes
+	}
+
+	if r.From != CustomFromNodes && r.From != CustomFromPods {
+		return fmt.Errorf("report %s: unknown from %q (one of nodes, pods)", r.Name, r.From)
+	}
+
+	if len(r.Columns) == 0 {
+		return fmt.Errorf("report %s: no columns", r.Name)
+	}
+
+	if r.Where != "" {
+		r.where, err = template.New("where").Funcs(customFuncs).Parse(r.Where)
+		if err != nil {
+			return fmt.Errorf("report %s: where: %w", r.Name, err)
+		}
+	}
+
+	names := map[string]bool{}
+
+	for _, c := range append(append([]*CustomColumn{}, r.GroupBy...), r.Columns...) {
+		if c.Name == "" {
+			return fmt.Errorf("report %s: column without a name", r.Name)
+		}
+
+		if names[c.Name] {
+			return fmt.Errorf("report %s: column %s: defined twice", r.Name, c.Name)
+		}
+
+		names[c.Name] = true
+
+		if !customAggs[c.Agg] {
+			return fmt.Errorf("report %s: column %s: unknown agg %q (one of first, count, sum, avg, min, max)", r.Name, c.Name, c.Agg)
+		}
+
+		if c.Value == "" && c.Agg != "count" {
+			return fmt.Errorf("report %s: column %s: no value", r.Name, c.Name)
+		}
+
+		c.value, err = template.New(c.Name).Funcs(customFuncs).Parse(c.Value)
+		if err != nil {
+			return fmt.Errorf("report %s: column %s: %w", r.Name, c.Name, err)
+		}
+	}
+
+	if r.Sort != "" && !names[strings.TrimPrefix(r.Sort, "-")] {
+		return fmt.Errorf("report %s: sort by unknown column %q", r.Name, r.Sort)
+	}
+
+	return nil
+}
+
+// renderCustom executes the template on the row.
+func renderCustom(t *template.Template, row *CustomRow) (string, error) {
+	var b bytes.Buffer
+
+	err := t.Execute(&b, row)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+// rows returns the report's rows from the nodes that Where keeps.
+func (r *CustomReport) rows(nodes []*NodeReport) ([]*CustomRow, error) {
+	rows := []*CustomRow{}
+
+	for _, n := range nodes {
+		candidates := []*CustomRow{{Node: n}}
+
+		if r.From == CustomFromPods {
+			candidates = candidates[:0]
+			for _, p := range n.Pods {
+				candidates = append(candidates, &CustomRow{Node: n, Pod: p})
+			}
+		}
+
+		for _, row := range candidates {
+			if r.where != nil {
+				keep, err := renderCustom(r.where, row)
+				if err != nil {
+					return nil, fmt.Errorf("report %s: where: %w", r.Name, err)
+				}
+
+				if keep != "true" {
+					continue
+				}
+			}
+
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
+}
+
+// Evaluate returns the report on the nodes.
+func (r *CustomReport) Evaluate(nodes []*NodeReport) (*CustomReportResult, error) {
+	rows, err := r.rows(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CustomReportResult{Name: r.Name, Rows: [][]string{}}
+
+	for _, c := range r.GroupBy {
+		result.Columns = append(result.Columns, c.Name)
+	}
+
+	for _, c := range r.Columns {
+		result.Columns = append(result.Columns, c.Name)
+	}
+
+	// Each group's column values, in the order first seen.
+	type group struct {
+		keys   []string
+		values [][]string
+		rows   int
+	}
+
+	groups := map[string]*group{}
+	order := []*group{}
+
+	for i, row := range rows {
+		keys := []string{}
+		for _, c := range r.GroupBy {
+			v, err := renderCustom(c.value, row)
+			if err != nil {
+				return nil, fmt.Errorf("report %s: column %s: %w", r.Name, c.Name, err)
+			}
+
+			keys = append(keys, v)
+		}
+
+		key := strings.Join(keys, "\xff")
+		if len(r.GroupBy) == 0 {
+			key = strconv.Itoa(i)
+		}
+
+		g, ok := groups[key]
+		if !ok {
+			g = &group{keys: keys, values: make([][]string, len(r.Columns))}
+			groups[key] = g
+			order = append(order, g)
+		}
+
+		g.rows++
+
+		for j, c := range r.Columns {
+			v, err := renderCustom(c.value, row)
+			if err != nil {
+				return nil, fmt.Errorf("report %s: column %s: %w", r.Name, c.Name, err)
+			}
+
+			if v != "" {
+				g.values[j] = append(g.values[j], v)
+			}
+		}
+	}
+
+	for _, g := range order {
+		row := append([]string{}, g.keys...)
+
+		for j, c := range r.Columns {
+			v, err := aggregate(c, g.values[j], g.rows)
+			if err != nil {
+				return nil, fmt.Errorf("report %s: column %s: %w", r.Name, c.Name, err)
+			}
+
+			row = append(row, v)
+		}
+
+		result.Rows = append(result.Rows, row)
+	}
+
+	r.sortRows(result)
+
+	if r.Limit > 0 && len(result.Rows) > r.Limit {
+		result.Rows = result.Rows[:r.Limit]
+	}
+
+	return result, nil
+}
+
+// aggregate combines a group's values of the column. Count without a value
+// counts the group's rows.
+func aggregate(c *CustomColumn, values []string, rows int) (string, error) {
+	switch c.Agg {
+	case "", "first":
+		if len(values) == 0 {
+			return "", nil
+		}
+
+		return values[0], nil
+	case "count":
+		if c.Value == "" {
+			return strconv.Itoa(rows), nil
+		}
+
+		return strconv.Itoa(len(values)), nil
+	}
+
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	var result float64
+
+	for i, s := range values {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s of %q: not a number", c.Agg, s)
+		}
+
+		switch {
+		case i == 0:
+			result = v
+		case c.Agg == "sum", c.Agg == "avg":
+			result += v
+		case c.Agg == "min":
+			result = math.Min(result, v)
+		case c.Agg == "max":
+			result = math.Max(result, v)
+		}
+	}
+
+	if c.Agg == "avg" {
+		result = math.Round(result/float64(len(values))*100) / 100
+	}
+
+	return strconv.FormatFloat(result, 'f', -1, 64), nil
+}
+
+// sortRows sorts the rows by the Sort column, numerically when both values
+// are numbers, or by the group-bys.
+func (r *CustomReport) sortRows(result *CustomReportResult) {
+	less := func(a, b string) bool {
+		x, errX := strconv.ParseFloat(a, 64)
+		y, errY := strconv.ParseFloat(b, 64)
+
+		if errX == nil && errY == nil {
+			return x < y
+		}
+
+		return a < b
+	}
+
+	if r.Sort == "" {
+		sort.SliceStable(result.Rows, func(i, j int) bool {
+			for k := range r.GroupBy {
+				a, b := result.Rows[i][k], result.Rows[j][k]
+				if a != b {
+					return less(a, b)
+				}
+			}
+
+			return false
+		})
+
+		return
+	}
+
+	name := strings.TrimPrefix(r.Sort, "-")
+	descending := name != r.Sort
+
+	col := 0
+	for i, c := range result.Columns {
+		if c == name {
+			col = i
+		}
+	}
+
+	sort.SliceStable(result.Rows, func(i, j int) bool {
+		a, b := result.Rows[i][col], result.Rows[j][col]
+		if descending {
+			return less(b, a)
+		}
+
+		return less(a, b)
+	})
+}
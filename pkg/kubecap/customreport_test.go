@@ -0,0 +1,104 @@
+package kubecap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCustomReports(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports.yaml")
+
+	err := os.WriteFile(path, []byte(`
+- name: Batch Nodes by Zone
+  where: '{{eq .Node.Group "batch"}}'
+  groupBy:
+    - name: Zone
+      value: '{{.Node.Zone}}'
+  columns:
+    - name: Nodes
+      agg: count
+    - name: Schedulable
+      value: '{{.Node.Schedulable}}'
+      agg: sum
+    - name: GPUs
+      value: '{{with .Node.GPU}}{{sub .Allocatable .Requested}}{{end}}'
+      agg: sum
+  sort: -Schedulable
+- name: Pods by Namespace
+  from: pods
+  groupBy:
+    - name: Namespace
+      value: '{{.Pod.Namespace}}'
+  columns:
+    - name: Requests
+      value: '{{.Pod.Requests}}'
+      agg: max
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reports, err := LoadCustomReports(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := []*NodeReport{
+		{Name: "a", Group: "batch", Zone: "us-east-1a", Schedulable: 10, GPU: &GPUReport{Allocatable: 8, Requested: 2}, Pods: []*PodReport{
+			{Namespace: "ml", Requests: 5},
+			{Namespace: "web", Requests: 1},
+		}},
+		{Name: "b", Group: "batch", Zone: "us-east-1b", Schedulable: 30, Pods: []*PodReport{
+			{Namespace: "ml", Requests: 7},
+		}},
+		{Name: "c", Group: "batch", Zone: "us-east-1a", Schedulable: 5, GPU: &GPUReport{Allocatable: 8, Requested: 8}},
+		{Name: "d", Group: "web", Zone: "us-east-1a", Schedulable: 100},
+	}
+
+	for i, tc := range []struct {
+		columns []string
+		rows    [][]string
+	}{
+		{
+			[]string{"Zone", "Nodes", "Schedulable", "GPUs"},
+			[][]string{
+				{"us-east-1b", "1", "30", ""},
+				{"us-east-1a", "2", "15", "6"},
+			},
+		},
+		{
+			[]string{"Namespace", "Requests"},
+			[][]string{
+				{"ml", "7"},
+				{"web", "1"},
+			},
+		},
+	} {
+		result, err := reports[i].Evaluate(nodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(result.Columns, tc.columns) || !reflect.DeepEqual(result.Rows, tc.rows) {
+			t.Errorf("%s = %v %v, want %v %v", result.Name, result.Columns, result.Rows, tc.columns, tc.rows)
+		}
+	}
+
+	for _, bad := range []string{
+		`[{name: x}]`,
+		`[{name: x, from: services, columns: [{name: n, agg: count}]}]`,
+		`[{name: x, columns: [{name: n, agg: median, value: "1"}]}]`,
+		`[{name: x, columns: [{name: n, agg: count}], sort: y}]`,
+	} {
+		err = os.WriteFile(path, []byte(bad), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := LoadCustomReports(path); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
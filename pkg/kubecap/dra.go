@@ -0,0 +1,281 @@
+package kubecap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// draVersions are the resource.k8s.io API versions tried, newest first.
+var draVersions = []string{"v1", "v1beta2", "v1beta1"}
+
+// DeviceReport is the Dynamic Resource Allocation devices of one driver's
+// pool on a node.
+type DeviceReport struct {
+	Driver    string `json:"driver"`
+	Pool      string `json:"pool"`
+	Total     int64  `json:"total"`
+	Allocated int64  `json:"allocated"`
+}
+
+// Free is the number of devices not allocated to a claim.
+func (d *DeviceReport) Free() int64 {
+	return d.Total - d.Allocated
+}
+
+// resourceSliceList is the part of a ResourceSliceList we use. The fields are
+// the same in all served versions.
+type resourceSliceList struct {
+	Items []struct {
+		Spec struct {
+			Driver string `json:"driver"`
+			Pool   struct {
+				Name string `json:"name"`
+			} `json:"pool"`
+			NodeName string `json:"nodeName"`
+			Devices  []struct {
+				Name string `json:"name"`
+			} `json:"devices"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// resourceClaimList is the part of a ResourceClaimList we use.
+type resourceClaimList struct {
+	Items []struct {
+		Status struct {
+			Allocation *struct {
+				Devices struct {
+					Results []struct {
+						Driver string `json:"driver"`
+						Pool   string `json:"pool"`
+						Device string `json:"device"`
+					} `json:"results"`
+				} `json:"devices"`
+			} `json:"allocation"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// deviceClass is the part of a DeviceClass we use.
+type deviceClass struct {
+	Spec struct {
+		Selectors []struct {
+			CEL *struct {
+				Expression string `json:"expression"`
+			} `json:"cel"`
+		} `json:"selectors"`
+	} `json:"spec"`
+}
+
+// deviceClassDriver matches the usual DeviceClass selector picking a driver's
+// devices.
+var deviceClassDriver = regexp.MustCompile(`^\s*device\.driver\s*==\s*["']([^"']+)["']\s*$`)
+
+// ParseAdditionalDevices parses class=count[,class=count...].
+func ParseAdditionalDevices(s string) (map[string]int64, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	devices := map[string]int64{}
+
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid additional devices %q: expected class=count", kv)
+		}
+
+		n, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid additional devices %q: %w", kv, err)
+		}
+
+		devices[strings.TrimSpace(parts[0])] = n
+	}
+
+	return devices, nil
+}
+
+// draGet reads a resource.k8s.io collection or object. It returns nil data
+// when the version (or object) isn't served.
+func draGet(ctx context.Context, kcs kubernetes.Interface, version string, path ...string) (data []byte, err error) {
+	data, err = kcs.CoreV1().RESTClient().Get().
+		AbsPath(append([]string{"/apis/resource.k8s.io", version}, path...)...).
+		DoRaw(ctx)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+// collectDevices lists the DRA devices published in ResourceSlices by node
+// and counts those allocated to ResourceClaims. The additional devices, given
+// by DeviceClass, are resolved to the driver the class selects. Devices not
+// attached to a single node (network-attached pools) are left out.
+func collectDevices(ctx context.Context, kcs kubernetes.Interface, additional map[string]int64) (byNode map[string][]*DeviceReport, needs map[string]int64, err error) {
+	ctx, span := tracer.Start(ctx, "collect dra devices")
+	defer func() { endSpan(span, err) }()
+
+	var version string
+	var slices []byte
+
+	for _, v := range draVersions {
+		slices, err = draGet(ctx, kcs, v, "resourceslices")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if slices != nil {
+			version = v
+			break
+		}
+	}
+
+	if version == "" {
+		if len(additional) > 0 {
+			return nil, nil, fmt.Errorf("additional devices requested but resource.k8s.io is not served")
+		}
+
+		return nil, nil, nil
+	}
+
+	needs = map[string]int64{}
+
+	for class, n := range additional {
+		data, err := draGet(ctx, kcs, version, "deviceclasses", class)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if data == nil {
+			return nil, nil, fmt.Errorf("device class %q not found", class)
+		}
+
+		dc := deviceClass{}
+
+		err = json.Unmarshal(data, &dc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("device class %q: %w", class, err)
+		}
+
+		var driver string
+
+		for _, sel := range dc.Spec.Selectors {
+			if sel.CEL == nil {
+				continue
+			}
+
+			m := deviceClassDriver.FindStringSubmatch(sel.CEL.Expression)
+			if m == nil || driver != "" {
+				return nil, nil, fmt.Errorf("device class %q: only classes selecting a single driver (device.driver == \"...\") are supported", class)
+			}
+
+			driver = m[1]
+		}
+
+		if driver == "" {
+			return nil, nil, fmt.Errorf("device class %q doesn't select a driver", class)
+		}
+
+		needs[driver] += n
+	}
+
+	sl := resourceSliceList{}
+
+	err = json.Unmarshal(slices, &sl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resource slices: %w", err)
+	}
+
+	data, err := draGet(ctx, kcs, version, "resourceclaims")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cl := resourceClaimList{}
+
+	if data != nil {
+		err = json.Unmarshal(data, &cl)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resource claims: %w", err)
+		}
+	}
+
+	allocated := map[string]bool{}
+	for _, c := range cl.Items {
+		if c.Status.Allocation == nil {
+			continue
+		}
+
+		for _, r := range c.Status.Allocation.Devices.Results {
+			allocated[r.Driver+"/"+r.Pool+"/"+r.Device] = true
+		}
+	}
+
+	byNode = map[string][]*DeviceReport{}
+	pools := map[string]*DeviceReport{}
+
+	for _, s := range sl.Items {
+		if s.Spec.NodeName == "" {
+			continue
+		}
+
+		key := s.Spec.NodeName + "/" + s.Spec.Driver + "/" + s.Spec.Pool.Name
+
+		d, ok := pools[key]
+		if !ok {
+			d = &DeviceReport{Driver: s.Spec.Driver, Pool: s.Spec.Pool.Name}
+			pools[key] = d
+			byNode[s.Spec.NodeName] = append(byNode[s.Spec.NodeName], d)
+		}
+
+		for _, dev := range s.Spec.Devices {
+			d.Total++
+
+			if allocated[s.Spec.Driver+"/"+s.Spec.Pool.Name+"/"+dev.Name] {
+				d.Allocated++
+			}
+		}
+	}
+
+	for _, devices := range byNode {
+		sort.Slice(devices, func(i, j int) bool {
+			if devices[i].Driver != devices[j].Driver {
+				return devices[i].Driver < devices[j].Driver
+			}
+
+			return devices[i].Pool < devices[j].Pool
+		})
+	}
+
+	return byNode, needs, nil
+}
+
+// devicesFit reports whether the node's free devices cover the needs (count
+// by driver).
+func devicesFit(devices []*DeviceReport, needs map[string]int64) bool {
+	for driver, n := range needs {
+		var free int64
+
+		for _, d := range devices {
+			if d.Driver == driver {
+				free += d.Free()
+			}
+		}
+
+		if free < n {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,96 @@
+package kubecap
+
+import (
+	"sort"
+)
+
+// EfficiencyEntry is a pod's or workload's memory efficiency. Amounts are in
+// bytes.
+type EfficiencyEntry struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+
+	// Kind is Pod for pods and the workload's kind otherwise.
+	Kind string `json:"workloadKind"`
+	Name string `json:"name"`
+	Pods int64  `json:"pods"`
+
+	Requests   int64   `json:"requests"`
+	Used       int64   `json:"used"`
+	Efficiency float64 `json:"efficiency"`
+
+	// Idle is the memory requested but not used.
+	Idle int64 `json:"idle"`
+}
+
+// podEfficiency returns used/requests, or zero without requests.
+func podEfficiency(requests, used int64) float64 {
+	if requests <= 0 {
+		return 0
+	}
+
+	return float64(used) / float64(requests)
+}
+
+// Leaderboard returns the top (all when 0) most over-provisioned pods, or
+// workloads with byWorkload, on the nodes: those with the most memory
+// requested but not used. Pods without a workload stand for themselves.
+func Leaderboard(nodes []*NodeReport, byWorkload bool, top int) []*EfficiencyEntry {
+	entries := map[string]*EfficiencyEntry{}
+
+	for _, n := range nodes {
+		for _, p := range n.Pods {
+			kind, name := "Pod", p.Name
+			if byWorkload && p.Workload != "" {
+				kind, name = p.WorkloadKind, p.Workload
+			}
+
+			key := n.Cluster + "/" + p.Namespace + "/" + kind + "/" + name
+
+			e, ok := entries[key]
+			if !ok {
+				e = &EfficiencyEntry{
+					Cluster:   n.Cluster,
+					Namespace: p.Namespace,
+					Kind:      kind,
+					Name:      name,
+				}
+				entries[key] = e
+			}
+
+			e.Pods++
+			e.Requests += p.Requests
+			e.Used += p.Used
+		}
+	}
+
+	board := make([]*EfficiencyEntry, 0, len(entries))
+	for _, e := range entries {
+		e.Efficiency = podEfficiency(e.Requests, e.Used)
+
+		e.Idle = e.Requests - e.Used
+		if e.Idle < 0 {
+			e.Idle = 0
+		}
+
+		board = append(board, e)
+	}
+
+	sort.Slice(board, func(i, j int) bool {
+		if board[i].Idle != board[j].Idle {
+			return board[i].Idle > board[j].Idle
+		}
+
+		if board[i].Namespace != board[j].Namespace {
+			return board[i].Namespace < board[j].Namespace
+		}
+
+		return board[i].Name < board[j].Name
+	})
+
+	if top > 0 && len(board) > top {
+		board = board[:top]
+	}
+
+	return board
+}
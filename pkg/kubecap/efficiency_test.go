@@ -0,0 +1,36 @@
+package kubecap
+
+import "testing"
+
+func TestLeaderboard(t *testing.T) {
+	nodes := []*NodeReport{
+		{Cluster: "c", Pods: []*PodReport{
+			{Namespace: "a", Name: "web-1", WorkloadKind: "Deployment", Workload: "web", Requests: 4, Used: 1},
+			{Namespace: "a", Name: "bare", Requests: 6, Used: 1},
+		}},
+		{Cluster: "c", Pods: []*PodReport{
+			{Namespace: "a", Name: "web-2", WorkloadKind: "Deployment", Workload: "web", Requests: 4, Used: 1},
+			{Namespace: "b", Name: "hog", Requests: 1, Used: 8},
+		}},
+	}
+
+	pods := Leaderboard(nodes, false, 2)
+	if len(pods) != 2 || pods[0].Name != "bare" || pods[0].Idle != 5 || pods[1].Name != "web-1" {
+		t.Errorf("pods = %+v %+v", pods[0], pods[1])
+	}
+
+	workloads := Leaderboard(nodes, true, 0)
+	if len(workloads) != 3 {
+		t.Fatalf("workloads = %d, want 3", len(workloads))
+	}
+
+	web := workloads[0]
+	if web.Kind != "Deployment" || web.Name != "web" || web.Pods != 2 || web.Requests != 8 || web.Idle != 6 || web.Efficiency != 0.25 {
+		t.Errorf("web = %+v", web)
+	}
+
+	// Pods using more than they request aren't idle.
+	if hog := workloads[2]; hog.Name != "hog" || hog.Kind != "Pod" || hog.Idle != 0 || hog.Efficiency != 8 {
+		t.Errorf("hog = %+v", hog)
+	}
+}
@@ -0,0 +1,300 @@
+package kubecap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// NodeEnricher adds provider-specific attributes to the nodes, e.g. their
+// instance lifecycle or price, that the Kubernetes API doesn't have. It is
+// given all of the nodes at once so that it can look them up in batches.
+type NodeEnricher interface {
+	// Enrich returns the attributes by name of each node it knows of, by
+	// node name.
+	Enrich(ctx context.Context, nodes []*corev1.Node) (map[string]map[string]string, error)
+}
+
+// NodeEnricherFunc adapts a function to a NodeEnricher.
+type NodeEnricherFunc func(ctx context.Context, nodes []*corev1.Node) (map[string]map[string]string, error)
+
+func (f NodeEnricherFunc) Enrich(ctx context.Context, nodes []*corev1.Node) (map[string]map[string]string, error) {
+	return f(ctx, nodes)
+}
+
+// Well-known node attributes.
+const (
+	// AttributeLifecycle is the node's purchase option: on-demand or spot.
+	AttributeLifecycle = "lifecycle"
+
+	// AttributeHourlyPrice is the node's price per hour and
+	// AttributeCommitted whether a commitment (reservation, savings plan or
+	// committed use discount) covers it.
+	AttributeHourlyPrice = "hourlyPrice"
+	AttributeCommitted   = "committed"
+
+	// AttributePodENIs is the number of branch network interfaces pods may
+	// be given on the node (security groups for pods on AWS).
+	AttributePodENIs = "podENIs"
+
+	// AttributeRegion and AttributeInstanceID locate the node's instance.
+	AttributeRegion     = "region"
+	AttributeInstanceID = "instanceID"
+)
+
+// Instance lifecycles.
+const (
+	LifecycleOnDemand = "on-demand"
+	LifecycleSpot     = "spot"
+)
+
+// nodeEnrichers are the enrichers by name.
+var nodeEnrichers = map[string]NodeEnricher{
+	"aws":   NodeEnricherFunc(awsEnrich),
+	"gcp":   NodeEnricherFunc(gcpEnrich),
+	"azure": NodeEnricherFunc(azureEnrich),
+}
+
+// RegisterNodeEnricher makes the enricher selectable by name, replacing any
+// registered under it.
+func RegisterNodeEnricher(name string, e NodeEnricher) {
+	nodeEnrichers[name] = e
+}
+
+// NodeEnrichers returns the names of the enrichers, sorted.
+func NodeEnrichers() []string {
+	names := make([]string, 0, len(nodeEnrichers))
+	for name := range nodeEnrichers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// NodeEnricherNamed returns the named enricher.
+func NodeEnricherNamed(name string) (NodeEnricher, error) {
+	e, ok := nodeEnrichers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown node enricher %q (one of %s)", name, strings.Join(NodeEnrichers(), ", "))
+	}
+
+	return e, nil
+}
+
+// enrichNodes runs the named enrichers in order, later ones overriding the
+// attributes of earlier ones, and returns each node's attributes.
+func enrichNodes(ctx context.Context, names []string, nodes map[string]*corev1.Node) (map[string]map[string]string, error) {
+	list := make([]*corev1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		list = append(list, node)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	attributes := map[string]map[string]string{}
+
+	for _, name := range names {
+		e, err := NodeEnricherNamed(name)
+		if err != nil {
+			return nil, err
+		}
+
+		byNode, err := e.Enrich(ctx, list)
+		if err != nil {
+			return nil, fmt.Errorf("node enricher %s: %w", name, err)
+		}
+
+		for node, attrs := range byNode {
+			if attributes[node] == nil {
+				attributes[node] = map[string]string{}
+			}
+
+			for k, v := range attrs {
+				attributes[node][k] = v
+			}
+		}
+	}
+
+	return attributes, nil
+}
+
+// NodeLifecycle returns the node's lifecycle from the labels the providers
+// and Karpenter put on spot nodes, on-demand otherwise.
+func NodeLifecycle(node *corev1.Node) string {
+	l := node.Labels
+
+	switch {
+	case strings.EqualFold(l["karpenter.sh/capacity-type"], LifecycleSpot),
+		strings.EqualFold(l["eks.amazonaws.com/capacityType"], "SPOT"),
+		l["cloud.google.com/gke-spot"] == "true",
+		l["cloud.google.com/gke-preemptible"] == "true",
+		strings.EqualFold(l["kubernetes.azure.com/scalesetpriority"], LifecycleSpot):
+		return LifecycleSpot
+	}
+
+	return LifecycleOnDemand
+}
+
+// providerNodes returns the nodes whose provider ID has the scheme.
+func providerNodes(nodes []*corev1.Node, scheme string) []*corev1.Node {
+	matched := []*corev1.Node{}
+	for _, node := range nodes {
+		if strings.HasPrefix(node.Spec.ProviderID, scheme+"://") {
+			matched = append(matched, node)
+		}
+	}
+
+	return matched
+}
+
+// awsEnrich reads the lifecycle, region, instance ID and pod ENI limit of the
+// EC2 nodes from their labels, provider ID (aws:///ZONE/INSTANCE) and
+// extended resources.
+func awsEnrich(ctx context.Context, nodes []*corev1.Node) (map[string]map[string]string, error) {
+	attributes := map[string]map[string]string{}
+
+	for _, node := range providerNodes(nodes, "aws") {
+		attrs := map[string]string{
+			AttributeLifecycle: NodeLifecycle(node),
+		}
+
+		parts := strings.Split(strings.TrimPrefix(node.Spec.ProviderID, "aws://"), "/")
+		if len(parts) == 3 && parts[1] != "" {
+			// The region is the zone less its letter.
+			attrs[AttributeRegion] = strings.TrimRight(parts[1], "abcdefghijklmnopqrstuvwxyz")
+			attrs[AttributeInstanceID] = parts[2]
+		}
+
+		if enis, ok := node.Status.Allocatable["vpc.amazonaws.com/pod-eni"]; ok {
+			attrs[AttributePodENIs] = strconv.FormatInt(enis.Value(), 10)
+		}
+
+		attributes[node.Name] = attrs
+	}
+
+	return attributes, nil
+}
+
+// gcpEnrich reads the lifecycle, region and instance of the GCE nodes from
+// their labels and provider ID (gce://PROJECT/ZONE/INSTANCE).
+func gcpEnrich(ctx context.Context, nodes []*corev1.Node) (map[string]map[string]string, error) {
+	attributes := map[string]map[string]string{}
+
+	for _, node := range providerNodes(nodes, "gce") {
+		attrs := map[string]string{
+			AttributeLifecycle: NodeLifecycle(node),
+		}
+
+		parts := strings.Split(strings.TrimPrefix(node.Spec.ProviderID, "gce://"), "/")
+		if len(parts) == 3 {
+			// The region is the zone less its -letter suffix.
+			if i := strings.LastIndex(parts[1], "-"); i > 0 {
+				attrs[AttributeRegion] = parts[1][:i]
+			}
+
+			attrs[AttributeInstanceID] = parts[2]
+		}
+
+		attributes[node.Name] = attrs
+	}
+
+	return attributes, nil
+}
+
+// azureEnrich reads the lifecycle and region of the Azure nodes from their
+// labels.
+func azureEnrich(ctx context.Context, nodes []*corev1.Node) (map[string]map[string]string, error) {
+	attributes := map[string]map[string]string{}
+
+	for _, node := range providerNodes(nodes, "azure") {
+		attrs := map[string]string{
+			AttributeLifecycle: NodeLifecycle(node),
+		}
+
+		if region := node.Labels["topology.kubernetes.io/region"]; region != "" {
+			attrs[AttributeRegion] = region
+		}
+
+		attributes[node.Name] = attrs
+	}
+
+	return attributes, nil
+}
+
+// InstancePrice is an instance type's price per hour on demand and as spot,
+// and the number of its nodes commitments cover.
+type InstancePrice struct {
+	OnDemand  float64 `json:"onDemand"`
+	Spot      float64 `json:"spot"`
+	Committed int     `json:"committed"`
+}
+
+// LoadPrices reads the prices by instance type from a YAML file.
+func LoadPrices(path string) (map[string]*InstancePrice, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := map[string]*InstancePrice{}
+
+	err = yaml.UnmarshalStrict(data, &prices)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return prices, nil
+}
+
+// NewPriceEnricher returns an enricher pricing the nodes by instance type
+// and lifecycle. The oldest on-demand nodes of each type are the ones
+// counted as covered by its commitments.
+func NewPriceEnricher(prices map[string]*InstancePrice) NodeEnricher {
+	return NodeEnricherFunc(func(ctx context.Context, nodes []*corev1.Node) (map[string]map[string]string, error) {
+		attributes := map[string]map[string]string{}
+
+		oldest := append([]*corev1.Node{}, nodes...)
+		sort.SliceStable(oldest, func(i, j int) bool {
+			return oldest[i].CreationTimestamp.Before(&oldest[j].CreationTimestamp)
+		})
+
+		committed := map[string]int{}
+
+		for _, node := range oldest {
+			instanceType := node.Labels[instanceTypeLabel]
+
+			p, ok := prices[instanceType]
+			if !ok {
+				continue
+			}
+
+			lifecycle := NodeLifecycle(node)
+
+			price := p.OnDemand
+			if lifecycle == LifecycleSpot && p.Spot > 0 {
+				price = p.Spot
+			}
+
+			covered := lifecycle == LifecycleOnDemand && committed[instanceType] < p.Committed
+			if covered {
+				committed[instanceType]++
+			}
+
+			attributes[node.Name] = map[string]string{
+				AttributeLifecycle:   lifecycle,
+				AttributeHourlyPrice: strconv.FormatFloat(price, 'f', -1, 64),
+				AttributeCommitted:   strconv.FormatBool(covered),
+			}
+		}
+
+		return attributes, nil
+	})
+}
@@ -0,0 +1,62 @@
+package kubecap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEnrichNodes(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	node := func(name, providerID string, age time.Duration, labels map[string]string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Labels:            labels,
+				CreationTimestamp: metav1.NewTime(created.Add(-age)),
+			},
+			Spec: corev1.NodeSpec{ProviderID: providerID},
+		}
+	}
+
+	nodes := map[string]*corev1.Node{
+		"aws-a": node("aws-a", "aws:///us-east-1a/i-0a", time.Hour, map[string]string{instanceTypeLabel: "m5.large"}),
+		"aws-b": node("aws-b", "aws:///us-east-1b/i-0b", 2*time.Hour, map[string]string{instanceTypeLabel: "m5.large"}),
+		"aws-c": node("aws-c", "aws:///us-east-1c/i-0c", 3*time.Hour, map[string]string{instanceTypeLabel: "m5.large", "karpenter.sh/capacity-type": "spot"}),
+		"gke-a": node("gke-a", "gce://proj/us-central1-f/gke-a", time.Hour, map[string]string{"cloud.google.com/gke-spot": "true"}),
+	}
+
+	nodes["aws-a"].Status.Allocatable = corev1.ResourceList{"vpc.amazonaws.com/pod-eni": resource.MustParse("9")}
+
+	RegisterNodeEnricher("test-prices", NewPriceEnricher(map[string]*InstancePrice{
+		"m5.large": {OnDemand: 0.096, Spot: 0.035, Committed: 1},
+	}))
+	defer delete(nodeEnrichers, "test-prices")
+
+	attributes, err := enrichNodes(context.Background(), []string{"aws", "gcp", "test-prices"}, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]map[string]string{
+		"aws-a": {"lifecycle": "on-demand", "region": "us-east-1", "instanceID": "i-0a", "podENIs": "9", "hourlyPrice": "0.096", "committed": "false"},
+		// The oldest on-demand node is the one committed.
+		"aws-b": {"lifecycle": "on-demand", "region": "us-east-1", "instanceID": "i-0b", "hourlyPrice": "0.096", "committed": "true"},
+		"aws-c": {"lifecycle": "spot", "region": "us-east-1", "instanceID": "i-0c", "hourlyPrice": "0.035", "committed": "false"},
+		"gke-a": {"lifecycle": "spot", "region": "us-central1", "instanceID": "gke-a"},
+	}
+
+	if !reflect.DeepEqual(attributes, want) {
+		t.Errorf("attributes = %v, want %v", attributes, want)
+	}
+
+	if _, err := enrichNodes(context.Background(), []string{"nope"}, nodes); err == nil {
+		t.Error("unknown enricher: no error")
+	}
+}
@@ -0,0 +1,92 @@
+package kubecap
+
+import (
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// EvictionPolicy lists the pods that must never be suggested or acted on for
+// eviction: those in its namespaces, matching any of its label selectors or
+// owned by a controller of any of its kinds. Protected pods are left out of
+// the evictable report and --evict's plans, and block drain plans rather
+// than being displaced.
+type EvictionPolicy struct {
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Selectors are label selectors, e.g. app=payments or tier in (db).
+	Selectors []string `json:"selectors,omitempty"`
+
+	// OwnerKinds are the kinds of the pods' controllers, e.g. StatefulSet.
+	// Pods without a controller are matched by the kind Pod.
+	OwnerKinds []string `json:"ownerKinds,omitempty"`
+
+	selectors []labels.Selector
+}
+
+// LoadEvictionPolicy reads an eviction policy from a YAML file.
+func LoadEvictionPolicy(path string) (*EvictionPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &EvictionPolicy{}
+
+	err = yaml.UnmarshalStrict(data, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, s := range p.Selectors {
+		// An empty selector would match, and protect, every pod.
+		if s == "" {
+			return nil, fmt.Errorf("%s: selectors: empty selector", path)
+		}
+
+		selector, err := labels.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: selectors: %q: %w", path, s, err)
+		}
+
+		p.selectors = append(p.selectors, selector)
+	}
+
+	return p, nil
+}
+
+// Protects reports whether the policy forbids evicting the pod. A nil policy
+// protects nothing.
+func (p *EvictionPolicy) Protects(pod *corev1.Pod) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, ns := range p.Namespaces {
+		if pod.Namespace == ns {
+			return true
+		}
+	}
+
+	for _, selector := range p.selectors {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+
+	kind, _ := PodWorkload(pod)
+	if kind == "" {
+		kind = "Pod"
+	}
+
+	for _, k := range p.OwnerKinds {
+		if kind == k {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,69 @@
+package kubecap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEvictionPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(data string) string {
+		path := filepath.Join(dir, "policy.yaml")
+
+		err := os.WriteFile(path, []byte(data), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	p, err := LoadEvictionPolicy(write("namespaces: [payments]\nselectors: ['tier in (db)']\nownerKinds: [StatefulSet, Pod]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := true
+
+	pod := func(namespace string, labels map[string]string, owner string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "p", Labels: labels}}
+
+		if owner != "" {
+			pod.OwnerReferences = []metav1.OwnerReference{{Kind: owner, Name: "w", Controller: &controller}}
+		}
+
+		return pod
+	}
+
+	for _, tc := range []struct {
+		name      string
+		pod       *corev1.Pod
+		protected bool
+	}{
+		{"namespace", pod("payments", nil, "ReplicaSet"), true},
+		{"selector", pod("shop", map[string]string{"tier": "db"}, "ReplicaSet"), true},
+		{"owner kind", pod("shop", nil, "StatefulSet"), true},
+		{"bare pod", pod("shop", nil, ""), true},
+		{"other", pod("shop", map[string]string{"tier": "web"}, "ReplicaSet"), false},
+	} {
+		if got := p.Protects(tc.pod); got != tc.protected {
+			t.Errorf("%s: protected = %t, want %t", tc.name, got, tc.protected)
+		}
+	}
+
+	var none *EvictionPolicy
+	if none.Protects(pod("payments", nil, "")) {
+		t.Error("no policy protects a pod")
+	}
+
+	for _, data := range []string{"selectors: ['']\n", "selectors: ['tier in']\n", "kinds: [Job]\n"} {
+		if _, err := LoadEvictionPolicy(write(data)); err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package kubecap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// execEnricher is a NodeEnricher run as an external program: it is given the
+// nodes as a JSON array on stdin and writes the attributes by name of each
+// node it knows of, by node name, as a JSON object on stdout.
+type execEnricher struct {
+	command []string
+}
+
+// NewExecEnricher returns a NodeEnricher running the command (the program
+// and its arguments), so that proprietary sources can be integrated without
+// changing kubecap.
+func NewExecEnricher(command []string) NodeEnricher {
+	return &execEnricher{command: command}
+}
+
+func (e *execEnricher) Enrich(ctx context.Context, nodes []*corev1.Node) (map[string]map[string]string, error) {
+	if len(e.command) == 0 {
+		return nil, fmt.Errorf("exec enricher: no command")
+	}
+
+	in, err := json.Marshal(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", e.command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	attributes := map[string]map[string]string{}
+
+	err = json.Unmarshal(stdout.Bytes(), &attributes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.command[0], err)
+	}
+
+	return attributes, nil
+}
@@ -0,0 +1,35 @@
+package kubecap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExecEnricher(t *testing.T) {
+	nodes := []*corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}}
+
+	// The program echoes the names of the nodes it is given.
+	e := NewExecEnricher([]string{"sh", "-c", `sed 's/.*"name":"\([^"]*\)".*/{"\1": {"team": "web"}}/'`})
+
+	attributes, err := e.Enrich(context.Background(), nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]map[string]string{"node-a": {"team": "web"}}; !reflect.DeepEqual(attributes, want) {
+		t.Errorf("attributes = %v, want %v", attributes, want)
+	}
+
+	for _, command := range [][]string{
+		{"sh", "-c", "echo broken >&2; exit 1"},
+		{"sh", "-c", "echo not json"},
+	} {
+		if _, err := NewExecEnricher(command).Enrich(context.Background(), nodes); err == nil {
+			t.Errorf("%q: no error", command)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package kubecap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// gpuUtilMetric is the DCGM exporter's GPU utilization (percent) metric.
+const gpuUtilMetric = "DCGM_FI_DEV_GPU_UTIL"
+
+// DefaultGPUResource is the extended resource GPUs are requested as unless
+// another is given.
+const DefaultGPUResource = "nvidia.com/gpu"
+
+// gpuResourceName returns the resource GPUs are requested as.
+func (md *Metadata) gpuResourceName() corev1.ResourceName {
+	if md.GPUResource == "" {
+		return DefaultGPUResource
+	}
+
+	return corev1.ResourceName(md.GPUResource)
+}
+
+// GPUReport is a node's GPUs: how many it has and pods request, and their
+// mean utilization from DCGM.
+type GPUReport struct {
+	Allocatable int64 `json:"allocatable"`
+	Requested   int64 `json:"requested"`
+
+	// Utilization is the mean utilization (percent) of the node's GPUs
+	// reporting to DCGM, of which there are Sampled.
+	Utilization float64 `json:"utilization"`
+	Sampled     int     `json:"sampled"`
+}
+
+// PodGPUReport is the GPUs a pod requests and their mean utilization
+// (percent) from DCGM, of which Sampled reported.
+type PodGPUReport struct {
+	Requested   int64   `json:"requested"`
+	Utilization float64 `json:"utilization"`
+	Sampled     int     `json:"sampled"`
+}
+
+// Idle is the GPUs the pod requests but doesn't use, in whole GPUs. Pods
+// without samples count as fully using their GPUs: their usage is unknown.
+func (g *PodGPUReport) Idle() float64 {
+	if g.Sampled == 0 {
+		return 0
+	}
+
+	return float64(g.Requested) * (1 - g.Utilization/100)
+}
+
+// gpuUsage is the GPU utilization samples by node and by namespace/pod.
+type gpuUsage struct {
+	nodes map[string][]float64
+	pods  map[string][]float64
+}
+
+// mean returns the mean of the samples and how many there are.
+func mean(samples []float64) (float64, int) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+
+	var total float64
+	for _, s := range samples {
+		total += s
+	}
+
+	return total / float64(len(samples)), len(samples)
+}
+
+// nodeReport returns the node's GPUs given its pods.
+func (u *gpuUsage) nodeReport(node *corev1.Node, pods []*corev1.Pod, podLevel PodResources, rn corev1.ResourceName) *GPUReport {
+	if u == nil {
+		return nil
+	}
+
+	r := &GPUReport{
+		Allocatable: ResourceValue(rn, node.Status.Allocatable[rn]),
+	}
+
+	for _, pod := range pods {
+		if PodState(pod) != PodStateFinished {
+			r.Requested += podLevel.Requests(pod, rn)
+		}
+	}
+
+	r.Utilization, r.Sampled = mean(u.nodes[node.Name])
+
+	return r
+}
+
+// podReport returns the pod's GPUs, if it requests any.
+func (u *gpuUsage) podReport(pod *corev1.Pod, podLevel PodResources, rn corev1.ResourceName) *PodGPUReport {
+	if u == nil {
+		return nil
+	}
+
+	requested := podLevel.Requests(pod, rn)
+	if requested <= 0 {
+		return nil
+	}
+
+	r := &PodGPUReport{Requested: requested}
+	r.Utilization, r.Sampled = mean(u.pods[pod.Namespace+"/"+pod.Name])
+
+	return r
+}
+
+// promLabel returns the first of the labels set.
+func promLabel(labels map[string]string, names ...string) string {
+	for _, name := range names {
+		if v := labels[name]; v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// newGPUUsage groups the DCGM utilization samples by node and by the pod
+// using the GPU. Prometheus renames the pod's labels to exported_namespace
+// and exported_pod when they clash with the exporter's own, so those are
+// preferred.
+func newGPUUsage(samples []promSample) *gpuUsage {
+	u := &gpuUsage{
+		nodes: map[string][]float64{},
+		pods:  map[string][]float64{},
+	}
+
+	for _, s := range samples {
+		if s.name != gpuUtilMetric {
+			continue
+		}
+
+		if node := promLabel(s.labels, "node", "kubernetes_node", "Hostname"); node != "" {
+			u.nodes[node] = append(u.nodes[node], s.value)
+		}
+
+		ns := promLabel(s.labels, "exported_namespace", "namespace")
+		pod := promLabel(s.labels, "exported_pod", "pod")
+
+		if ns != "" && pod != "" {
+			u.pods[ns+"/"+pod] = append(u.pods[ns+"/"+pod], s.value)
+		}
+	}
+
+	return u
+}
+
+// promQueryResponse is the part of a Prometheus instant query response we
+// use.
+type promQueryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// collectGPUUsage reads the GPU utilization from a DCGM exporter, when
+// metricsURL ends in /metrics, or else by querying the Prometheus at
+// metricsURL.
+func collectGPUUsage(ctx context.Context, metricsURL string) (u *gpuUsage, err error) {
+	ctx, span := tracer.Start(ctx, "collect gpu usage")
+	defer func() { endSpan(span, err) }()
+
+	parsed, err := url.Parse(metricsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(target string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", target, resp.Status)
+		}
+
+		return data, nil
+	}
+
+	if strings.HasSuffix(parsed.Path, "/metrics") {
+		data, err := get(metricsURL)
+		if err != nil {
+			return nil, err
+		}
+
+		return newGPUUsage(parsePromText(data)), nil
+	}
+
+	data, err := get(strings.TrimSuffix(metricsURL, "/") + "/api/v1/query?query=" + url.QueryEscape(gpuUtilMetric))
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := parsePromQuery(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGPUUsage(samples), nil
+}
+
+// parsePromQuery parses a Prometheus instant query's vector result.
+func parsePromQuery(data []byte) ([]promSample, error) {
+	var resp promQueryResponse
+
+	err := json.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("prometheus query: %s", resp.Error)
+	}
+
+	if resp.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("prometheus query: unexpected %s result", resp.Data.ResultType)
+	}
+
+	samples := []promSample{}
+
+	for _, r := range resp.Data.Result {
+		if len(r.Value) != 2 {
+			continue
+		}
+
+		s, ok := r.Value[1].(string)
+		if !ok {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			continue
+		}
+
+		samples = append(samples, promSample{name: r.Metric["__name__"], labels: r.Metric, value: v})
+	}
+
+	return samples, nil
+}
+
+// GPUEfficiencyEntry is a workload's (or pod's, without one) GPU efficiency.
+type GPUEfficiencyEntry struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Kind      string `json:"workloadKind"`
+	Name      string `json:"name"`
+	Pods      int64  `json:"pods"`
+
+	// Requested is the GPUs requested, Utilization their mean utilization
+	// (percent) and Idle the GPUs requested but not used.
+	Requested   int64   `json:"requested"`
+	Utilization float64 `json:"utilization"`
+	Idle        float64 `json:"idle"`
+}
+
+// GPULeaderboard returns the top (all when 0) workloads wasting the most
+// GPUs on the nodes: requested but idle. Only pods with utilization samples
+// are counted.
+func GPULeaderboard(nodes []*NodeReport, top int) []*GPUEfficiencyEntry {
+	entries := map[string]*GPUEfficiencyEntry{}
+
+	for _, n := range nodes {
+		for _, p := range n.Pods {
+			if p.GPU == nil || p.GPU.Sampled == 0 {
+				continue
+			}
+
+			kind, name := "Pod", p.Name
+			if p.Workload != "" {
+				kind, name = p.WorkloadKind, p.Workload
+			}
+
+			key := n.Cluster + "/" + p.Namespace + "/" + kind + "/" + name
+
+			e, ok := entries[key]
+			if !ok {
+				e = &GPUEfficiencyEntry{
+					Cluster:   n.Cluster,
+					Namespace: p.Namespace,
+					Kind:      kind,
+					Name:      name,
+				}
+				entries[key] = e
+			}
+
+			e.Pods++
+			e.Requested += p.GPU.Requested
+			e.Idle += p.GPU.Idle()
+		}
+	}
+
+	board := make([]*GPUEfficiencyEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.Requested > 0 {
+			e.Utilization = 100 * (1 - e.Idle/float64(e.Requested))
+		}
+
+		board = append(board, e)
+	}
+
+	sort.Slice(board, func(i, j int) bool {
+		if board[i].Idle != board[j].Idle {
+			return board[i].Idle > board[j].Idle
+		}
+
+		return board[i].Cluster+"/"+board[i].Namespace+"/"+board[i].Name < board[j].Cluster+"/"+board[j].Namespace+"/"+board[j].Name
+	})
+
+	if top > 0 && len(board) > top {
+		board = board[:top]
+	}
+
+	return board
+}
@@ -0,0 +1,118 @@
+package kubecap
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCollectGPUUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			fmt.Fprint(w, `# HELP DCGM_FI_DEV_GPU_UTIL GPU utilization (in %).
+# TYPE DCGM_FI_DEV_GPU_UTIL gauge
+DCGM_FI_DEV_GPU_UTIL{gpu="0",Hostname="node-a",namespace="ml",pod="train-0"} 90
+DCGM_FI_DEV_GPU_UTIL{gpu="1",Hostname="node-a",namespace="ml",pod="train-0"} 70
+DCGM_FI_DEV_GPU_UTIL{gpu="2",Hostname="node-a"} 0
+DCGM_FI_DEV_FB_USED{gpu="0",Hostname="node-a"} 1024
+`)
+		case "/api/v1/query":
+			if q := r.URL.Query().Get("query"); q != gpuUtilMetric {
+				http.Error(w, "unexpected query "+q, http.StatusBadRequest)
+				return
+			}
+
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[
+{"metric":{"__name__":"DCGM_FI_DEV_GPU_UTIL","node":"node-b","namespace":"gpu-operator","pod":"dcgm-exporter-x","exported_namespace":"ml","exported_pod":"serve-0"},"value":[1700000000,"25"]}
+]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := collectGPUUsage(context.Background(), srv.URL+"/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m, n := mean(u.nodes["node-a"]); m != 160.0/3 || n != 3 {
+		t.Errorf("exporter: node-a = %g of %d", m, n)
+	}
+
+	if m, n := mean(u.pods["ml/train-0"]); m != 80 || n != 2 {
+		t.Errorf("exporter: ml/train-0 = %g of %d", m, n)
+	}
+
+	u, err = collectGPUUsage(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m, n := mean(u.pods["ml/serve-0"]); m != 25 || n != 1 || len(u.nodes["node-b"]) != 1 {
+		t.Errorf("prometheus: pods = %v, nodes = %v", u.pods, u.nodes)
+	}
+}
+
+func TestGPUReports(t *testing.T) {
+	u := newGPUUsage([]promSample{
+		{name: gpuUtilMetric, labels: map[string]string{"Hostname": "node-a", "namespace": "ml", "pod": "train-0"}, value: 20},
+		{name: gpuUtilMetric, labels: map[string]string{"Hostname": "node-a", "namespace": "ml", "pod": "train-0"}, value: 30},
+		{name: gpuUtilMetric, labels: map[string]string{"Hostname": "node-a", "namespace": "ml", "pod": "train-1"}, value: 100},
+	})
+
+	gpus := func(n int64) corev1.ResourceList {
+		return corev1.ResourceList{DefaultGPUResource: *resource.NewQuantity(n, resource.DecimalSI)}
+	}
+
+	pod := func(name string, n int64) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ml", Name: name},
+			Spec: corev1.PodSpec{
+				NodeName:   "node-a",
+				Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: gpus(n)}}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Status:     corev1.NodeStatus{Allocatable: gpus(4)},
+	}
+
+	pods := []*corev1.Pod{pod("train-0", 2), pod("train-1", 1), pod("web", 0)}
+
+	nr := u.nodeReport(node, pods, PodResources{}, DefaultGPUResource)
+	if nr.Allocatable != 4 || nr.Requested != 3 || nr.Sampled != 3 || nr.Utilization != 50 {
+		t.Errorf("node = %+v", nr)
+	}
+
+	if pr := u.podReport(pods[2], PodResources{}, DefaultGPUResource); pr != nil {
+		t.Errorf("pod without GPUs = %+v", pr)
+	}
+
+	// train-0 leaves 75% of its 2 GPUs idle.
+	nodes := []*NodeReport{{Name: "node-a"}}
+	for _, p := range pods {
+		nodes[0].Pods = append(nodes[0].Pods, &PodReport{
+			Namespace:    p.Namespace,
+			Name:         p.Name,
+			WorkloadKind: "StatefulSet",
+			Workload:     p.Name[:len(p.Name)-2],
+			GPU:          u.podReport(p, PodResources{}, DefaultGPUResource),
+		})
+	}
+
+	board := GPULeaderboard(nodes, 0)
+	if len(board) != 1 || board[0].Name != "train" || board[0].Pods != 2 || board[0].Requested != 3 || board[0].Idle != 1.5 || board[0].Utilization != 50 {
+		t.Errorf("board = %+v", board)
+	}
+}
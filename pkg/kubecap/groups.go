@@ -0,0 +1,173 @@
+package kubecap
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// GroupReport totals the nodes in a node group.
+type GroupReport struct {
+	Group string `json:"group"`
+	Summary
+}
+
+// ungrouped is the name used for nodes without a node group.
+const ungrouped = "ungrouped"
+
+// SummarizeGroups totals the nodes by node group, sorted by group name.
+func SummarizeGroups(nodes []*NodeReport) []GroupReport {
+	byGroup := map[string][]*NodeReport{}
+
+	for _, n := range nodes {
+		group := n.Group
+		if group == "" {
+			group = ungrouped
+		}
+
+		byGroup[group] = append(byGroup[group], n)
+	}
+
+	groups := make([]GroupReport, 0, len(byGroup))
+	for group, ns := range byGroup {
+		groups = append(groups, GroupReport{
+			Group:   group,
+			Summary: Summarize(ns),
+		})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Group < groups[j].Group
+	})
+
+	return groups
+}
+
+// BreachTracker tracks how long each node group has had less schedulable
+// memory than the minimum (or its nodes' threshold profiles' margins).
+type BreachTracker struct {
+	Min   int64
+	since map[string]time.Time
+}
+
+func NewBreachTracker(min int64) *BreachTracker {
+	return &BreachTracker{
+		Min:   min,
+		since: map[string]time.Time{},
+	}
+}
+
+// Update records the groups in breach as of now and returns, for every group,
+// when its current breach began. Groups not in breach have a zero time.
+// Groups that no longer exist are forgotten.
+func (bt *BreachTracker) Update(groups []GroupReport, now time.Time) map[string]time.Time {
+	breaches := map[string]time.Time{}
+
+	current := map[string]bool{}
+	for _, g := range groups {
+		current[g.Group] = true
+	}
+
+	for group := range bt.since {
+		if !current[group] {
+			delete(bt.since, group)
+		}
+	}
+
+	for _, g := range groups {
+		if g.Schedulable >= g.minSchedulable(bt.Min) {
+			delete(bt.since, g.Group)
+			breaches[g.Group] = time.Time{}
+
+			continue
+		}
+
+		if _, ok := bt.since[g.Group]; !ok {
+			bt.since[g.Group] = now
+		}
+
+		breaches[g.Group] = bt.since[g.Group]
+	}
+
+	return breaches
+}
+
+// CapacityCheck is a node group (or the whole cluster) checked against a
+// minimum amount of schedulable memory: the margins of its nodes' threshold
+// profiles, if any, and the global minimum otherwise.
+type CapacityCheck struct {
+	Key  string
+	What string
+	Sum  Summary
+	Min  int64
+}
+
+func (c CapacityCheck) Breached() bool {
+	return c.Sum.Schedulable < c.Min
+}
+
+// CapacityChecks checks each node group against min and, if minCluster is
+// set, the whole cluster against minCluster.
+func CapacityChecks(nodes []*NodeReport, min, minCluster int64) []CapacityCheck {
+	checks := []CapacityCheck{}
+	for _, g := range SummarizeGroups(nodes) {
+		checks = append(checks, CapacityCheck{"group/" + g.Group, "Node group " + g.Group, g.Summary, g.minSchedulable(min)})
+	}
+
+	if minCluster > 0 {
+		checks = append(checks, CapacityCheck{"cluster", "Cluster", Summarize(nodes), minCluster})
+	}
+
+	return checks
+}
+
+// podAggregate sums the pods grouped under a key.
+type podAggregate struct {
+	key      []string
+	pods     int64
+	requests int64
+	used     int64
+}
+
+// AggregatePods groups the pods on the nodes by key, returning a row per key
+// (sorted) of the key followed by the pod count and total requests and usage.
+func AggregatePods(nodes []*NodeReport, key func(n *NodeReport, p *PodReport) []string) [][]interface{} {
+	aggs := map[string]*podAggregate{}
+
+	for _, n := range nodes {
+		for _, p := range n.Pods {
+			k := key(n, p)
+			id := strings.Join(k, "\x00")
+
+			agg, ok := aggs[id]
+			if !ok {
+				agg = &podAggregate{key: k}
+				aggs[id] = agg
+			}
+
+			agg.pods++
+			agg.requests += p.Requests
+			agg.used += p.Used
+		}
+	}
+
+	ids := make([]string, 0, len(aggs))
+	for id := range aggs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	rows := make([][]interface{}, 0, len(ids))
+	for _, id := range ids {
+		agg := aggs[id]
+
+		row := []interface{}{}
+		for _, k := range agg.key {
+			row = append(row, k)
+		}
+
+		rows = append(rows, append(row, agg.pods, agg.requests, agg.used))
+	}
+
+	return rows
+}
@@ -0,0 +1,150 @@
+package kubecap
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// JobBacklogReport is the backlog of queued Jobs and an estimate of how long
+// the cluster's current headroom takes to run it. Amounts are in the report's
+// unit.
+type JobBacklogReport struct {
+	Selector string `json:"selector"`
+
+	// Jobs are the queued (suspended or not yet started) Jobs and Requests
+	// what their pods request while running at their parallelism.
+	Jobs     int   `json:"jobs"`
+	Requests int64 `json:"requests"`
+
+	// Completed is the number of completed Jobs AverageRuntime is taken
+	// from.
+	Completed      int           `json:"completed"`
+	AverageRuntime time.Duration `json:"averageRuntime"`
+
+	// Schedulable is the cluster's schedulable amount (summed over nodes
+	// with any left) the backlog runs in, and Waves how many rounds of Jobs
+	// running side by side it takes. Estimate is Waves times the average
+	// runtime, or -1 when the backlog can't run in the headroom or there is
+	// no runtime history. They are set once every node has been analyzed.
+	Schedulable int64         `json:"schedulable"`
+	Waves       int64         `json:"waves"`
+	Estimate    time.Duration `json:"estimate"`
+}
+
+// jobParallelism is how many of the Job's pods run at once.
+func jobParallelism(job *batchv1.Job) int64 {
+	parallelism := int64(1)
+	if job.Spec.Parallelism != nil {
+		parallelism = int64(*job.Spec.Parallelism)
+	}
+
+	// Fewer pods than the parallelism run when fewer completions are left.
+	if job.Spec.Completions != nil && int64(*job.Spec.Completions) < parallelism {
+		parallelism = int64(*job.Spec.Completions)
+	}
+
+	return parallelism
+}
+
+// jobQueued is whether the Job is waiting to run: suspended or not started
+// yet.
+func jobQueued(job *batchv1.Job) bool {
+	if jobFinished(job) {
+		return false
+	}
+
+	if job.Spec.Suspend != nil && *job.Spec.Suspend {
+		return true
+	}
+
+	return job.Status.StartTime == nil
+}
+
+// jobFinished is whether the Job completed or failed.
+func jobFinished(job *batchv1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jobBacklog reports the requests of the queued Jobs and the average runtime
+// of the completed ones.
+func jobBacklog(jobs []batchv1.Job, name corev1.ResourceName, selector string) *JobBacklogReport {
+	r := &JobBacklogReport{
+		Selector: selector,
+		Estimate: -1,
+	}
+
+	var runtime time.Duration
+
+	for i := range jobs {
+		job := &jobs[i]
+
+		if jobQueued(job) {
+			r.Jobs++
+			r.Requests += jobParallelism(job) * podSpecRequests(&job.Spec.Template.Spec, name)
+
+			continue
+		}
+
+		if job.Status.CompletionTime != nil && job.Status.StartTime != nil {
+			r.Completed++
+			runtime += job.Status.CompletionTime.Sub(job.Status.StartTime.Time)
+		}
+	}
+
+	if r.Completed > 0 {
+		r.AverageRuntime = runtime / time.Duration(r.Completed)
+	}
+
+	return r
+}
+
+// listJobBacklog lists the Jobs matching the selector in all namespaces and
+// reports on their backlog.
+func listJobBacklog(ctx context.Context, kcs kubernetes.Interface, name corev1.ResourceName, selector string) (r *JobBacklogReport, err error) {
+	ctx, span := tracer.Start(ctx, "list jobs", trace.WithAttributes(
+		attribute.String("kubecap.job_selector", selector),
+	))
+	defer func() { endSpan(span, err) }()
+
+	jobList, err := kcs.BatchV1().Jobs("").List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	return jobBacklog(jobList.Items, name, selector), nil
+}
+
+// estimate sets how long the backlog takes to run given the cluster's
+// schedulable amount. Jobs are assumed to hold their requests for the average
+// runtime and to pack perfectly across the nodes.
+func (r *JobBacklogReport) estimate(schedulable int64) {
+	r.Schedulable = schedulable
+	r.Waves = 0
+	r.Estimate = -1
+
+	if r.Requests == 0 {
+		r.Estimate = 0
+
+		return
+	}
+
+	if schedulable <= 0 || r.Completed == 0 {
+		return
+	}
+
+	r.Waves = (r.Requests + schedulable - 1) / schedulable
+	r.Estimate = time.Duration(r.Waves) * r.AverageRuntime
+}
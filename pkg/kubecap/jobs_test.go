@@ -0,0 +1,62 @@
+package kubecap
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestJobBacklog(t *testing.T) {
+	start := metav1.NewTime(time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC))
+	suspend := true
+	parallelism := int32(4)
+
+	spec := corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+		},
+	}}}}
+
+	completed := func(runtime time.Duration) batchv1.Job {
+		end := metav1.NewTime(start.Add(runtime))
+
+		return batchv1.Job{
+			Spec: batchv1.JobSpec{Template: spec},
+			Status: batchv1.JobStatus{
+				StartTime:      &start,
+				CompletionTime: &end,
+				Conditions:     []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+
+	jobs := []batchv1.Job{
+		{Spec: batchv1.JobSpec{Template: spec, Suspend: &suspend, Parallelism: &parallelism}},
+		{Spec: batchv1.JobSpec{Template: spec}},
+		{Spec: batchv1.JobSpec{Template: spec}, Status: batchv1.JobStatus{StartTime: &start}},
+		completed(time.Hour),
+		completed(3 * time.Hour),
+	}
+
+	r := jobBacklog(jobs, corev1.ResourceMemory, "queue=batch")
+
+	if r.Jobs != 2 || r.Requests != 5<<30 || r.Completed != 2 || r.AverageRuntime != 2*time.Hour {
+		t.Fatalf("jobs, requests, completed, average runtime = %d, %d, %d, %s", r.Jobs, r.Requests, r.Completed, r.AverageRuntime)
+	}
+
+	r.estimate(2 << 30)
+
+	if r.Waves != 3 || r.Estimate != 6*time.Hour {
+		t.Errorf("waves, estimate = %d, %s; want 3, 6h", r.Waves, r.Estimate)
+	}
+
+	r.estimate(0)
+
+	if r.Estimate != -1 {
+		t.Errorf("estimate without headroom = %s, want -1", r.Estimate)
+	}
+}
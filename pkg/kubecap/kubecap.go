@@ -0,0 +1,81 @@
+// Package kubecap analyzes a Kubernetes cluster's capacity: how much of each
+// node's allocatable memory (or CPU) is requested, used and still
+// schedulable, whether an additional amount fits, and which containers could
+// be evicted to make room.
+//
+// Collect streams the report to an Output as each node is analyzed, which is
+// how the kubecap command renders its tables and documents.
+// CollectClusterReport gathers the whole report in memory for programs
+// embedding the analysis.
+package kubecap
+
+import (
+	"context"
+
+	"k8s.io/client-go/kubernetes"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// ClusterReport is a whole report: its metadata followed by every node and
+// evictable container in the order they were reported.
+type ClusterReport struct {
+	Metadata  *Metadata             `json:"metadata"`
+	Nodes     []*NodeReport         `json:"nodes"`
+	Evictable []*EvictableContainer `json:"evictable"`
+}
+
+// Summary sums up the report's nodes.
+func (r *ClusterReport) Summary() Summary {
+	return Summarize(r.Nodes)
+}
+
+// clusterReportOutput gathers the report as it is collected.
+type clusterReportOutput struct {
+	r *ClusterReport
+}
+
+func (o *clusterReportOutput) Metadata(md *Metadata) error {
+	o.r.Metadata = md
+
+	return nil
+}
+
+func (o *clusterReportOutput) Node(n *NodeReport) error {
+	o.r.Nodes = append(o.r.Nodes, n)
+
+	return nil
+}
+
+func (o *clusterReportOutput) Evictable(e *EvictableContainer) error {
+	o.r.Evictable = append(o.r.Evictable, e)
+
+	return nil
+}
+
+func (o *clusterReportOutput) Flush() error {
+	return nil
+}
+
+// CollectClusterReport collects the report on the cluster with the options
+// given as opts. The additional amount is parsed from opts.AdditionalInput
+// when given, as the kubecap command does with its argument.
+func CollectClusterReport(ctx context.Context, kcs kubernetes.Interface, mcs metricsv.Interface, opts Metadata) (*ClusterReport, error) {
+	if opts.AdditionalInput != "" {
+		additional, percent, err := ParseAdditional(opts.ResourceName(), opts.AdditionalInput)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Additional = additional
+		opts.AdditionalPercent = percent
+	}
+
+	r := &ClusterReport{}
+
+	err := Collect(ctx, kcs, mcs, &opts, &clusterReportOutput{r})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,216 @@
+package kubecap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// PSIData are the pressure stall averages (percent of time stalled over 10s,
+// 60s and 300s) and the total stall time in microseconds.
+type PSIData struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"`
+}
+
+// PSIStats is the pressure stall information for a resource: some is the
+// share of time at least one task stalled and full the share all did.
+type PSIStats struct {
+	Some PSIData `json:"some"`
+	Full PSIData `json:"full"`
+}
+
+// PSIReport is the node's memory and CPU pressure stall information.
+type PSIReport struct {
+	Memory *PSIStats `json:"memory,omitempty"`
+	CPU    *PSIStats `json:"cpu,omitempty"`
+}
+
+// kubeletSummary is the part of the kubelet's /stats/summary we use.
+type kubeletSummary struct {
+	Node struct {
+		CPU struct {
+			PSI *PSIStats `json:"psi"`
+		} `json:"cpu"`
+		Memory struct {
+			WorkingSetBytes *uint64   `json:"workingSetBytes"`
+			PSI             *PSIStats `json:"psi"`
+		} `json:"memory"`
+
+		// SystemContainers are the kubelet, container runtime, misc system
+		// processes and the pods cgroup.
+		SystemContainers []struct {
+			Name   string `json:"name"`
+			Memory struct {
+				WorkingSetBytes *uint64 `json:"workingSetBytes"`
+			} `json:"memory"`
+		} `json:"systemContainers"`
+
+		Fs struct {
+			UsedBytes *uint64 `json:"usedBytes"`
+		} `json:"fs"`
+	} `json:"node"`
+
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+
+		Containers []struct {
+			Name   string `json:"name"`
+			Rootfs struct {
+				UsedBytes *uint64 `json:"usedBytes"`
+			} `json:"rootfs"`
+			Logs struct {
+				UsedBytes *uint64 `json:"usedBytes"`
+			} `json:"logs"`
+		} `json:"containers"`
+	} `json:"pods"`
+}
+
+// psi returns the node's pressure stall information or nil when the kubelet
+// doesn't expose it.
+func (s *kubeletSummary) psi() *PSIReport {
+	if s.Node.Memory.PSI == nil && s.Node.CPU.PSI == nil {
+		return nil
+	}
+
+	return &PSIReport{
+		Memory: s.Node.Memory.PSI,
+		CPU:    s.Node.CPU.PSI,
+	}
+}
+
+// getKubeletSummary reads the node's kubelet stats summary through the API
+// server proxy.
+func getKubeletSummary(ctx context.Context, kcs kubernetes.Interface, name string) (s *kubeletSummary, err error) {
+	ctx, span := tracer.Start(ctx, "get kubelet summary", trace.WithAttributes(
+		attribute.String("k8s.node.name", name),
+	))
+	defer func() { endSpan(span, err) }()
+
+	data, err := kcs.CoreV1().RESTClient().Get().
+		AbsPath("/api/v1/nodes", name, "proxy", "stats", "summary").
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s = &kubeletSummary{}
+
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: kubelet summary: %w", name, err)
+	}
+
+	return s, nil
+}
+
+// storageMetrics builds node and pod metrics for ephemeral-storage from the
+// kubelet stats summaries, which the metrics API doesn't report on. A
+// container's usage is its writable layer and logs; a node's is its root
+// filesystem.
+func storageMetrics(ctx context.Context, kcs kubernetes.Interface, md *Metadata, nodeList *corev1.NodeList) (*metricsapi.NodeMetricsList, *metricsapi.PodMetricsList) {
+	nodeMetricsList := &metricsapi.NodeMetricsList{}
+	podMetricsList := &metricsapi.PodMetricsList{}
+
+	for _, node := range nodeList.Items {
+		s, err := getKubeletSummary(ctx, kcs, node.Name)
+		if err != nil {
+			// Like the metrics API, an unreachable kubelet leaves the node
+			// out rather than failing the report.
+			md.warnf("node %s: kubelet summary: %v", node.Name, err)
+			continue
+		}
+
+		if s.Node.Fs.UsedBytes == nil {
+			continue
+		}
+
+		nodeMetricsList.Items = append(nodeMetricsList.Items, metricsapi.NodeMetrics{
+			ObjectMeta: metav1.ObjectMeta{Name: node.Name},
+			Usage: corev1.ResourceList{
+				corev1.ResourceEphemeralStorage: *resource.NewQuantity(int64(*s.Node.Fs.UsedBytes), resource.BinarySI),
+			},
+		})
+
+		for _, p := range s.Pods {
+			pm := metricsapi.PodMetrics{
+				ObjectMeta: metav1.ObjectMeta{Namespace: p.PodRef.Namespace, Name: p.PodRef.Name},
+			}
+
+			for _, c := range p.Containers {
+				var used int64
+				if c.Rootfs.UsedBytes != nil {
+					used += int64(*c.Rootfs.UsedBytes)
+				}
+				if c.Logs.UsedBytes != nil {
+					used += int64(*c.Logs.UsedBytes)
+				}
+
+				pm.Containers = append(pm.Containers, metricsapi.ContainerMetrics{
+					Name: c.Name,
+					Usage: corev1.ResourceList{
+						corev1.ResourceEphemeralStorage: *resource.NewQuantity(used, resource.BinarySI),
+					},
+				})
+			}
+
+			podMetricsList.Items = append(podMetricsList.Items, pm)
+		}
+	}
+
+	return nodeMetricsList, podMetricsList
+}
+
+// kubeletConfigz is the part of the kubelet's /configz we use.
+type kubeletConfigz struct {
+	KubeletConfig struct {
+		CPUManagerPolicy      string            `json:"cpuManagerPolicy"`
+		ReservedSystemCPUs    string            `json:"reservedSystemCPUs"`
+		KubeReserved          map[string]string `json:"kubeReserved"`
+		SystemReserved        map[string]string `json:"systemReserved"`
+		MemoryManagerPolicy   string            `json:"memoryManagerPolicy"`
+		TopologyManagerPolicy string            `json:"topologyManagerPolicy"`
+		ReservedMemory        []struct {
+			NumaNode int32               `json:"numaNode"`
+			Limits   corev1.ResourceList `json:"limits"`
+		} `json:"reservedMemory"`
+	} `json:"kubeletconfig"`
+}
+
+// getKubeletConfigz reads the node's kubelet configuration through the API
+// server proxy.
+func getKubeletConfigz(ctx context.Context, kcs kubernetes.Interface, name string) (cfg *kubeletConfigz, err error) {
+	ctx, span := tracer.Start(ctx, "get kubelet configz", trace.WithAttributes(
+		attribute.String("k8s.node.name", name),
+	))
+	defer func() { endSpan(span, err) }()
+
+	data, err := kcs.CoreV1().RESTClient().Get().
+		AbsPath("/api/v1/nodes", name, "proxy", "configz").
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg = &kubeletConfigz{}
+
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: kubelet configz: %w", name, err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,288 @@
+package kubecap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+)
+
+// kueueAPI is the Kueue API version read.
+const kueueAPI = "/apis/kueue.x-k8s.io/v1beta1"
+
+// KueueReport is the memory backlog of Kueue's ClusterQueues. All amounts are
+// in bytes.
+type KueueReport struct {
+	ClusterQueues []*ClusterQueueReport `json:"clusterQueues"`
+
+	// Schedulable is the cluster's schedulable memory (summed over nodes
+	// with any left) the backlogs are compared with. It ignores how it is
+	// spread over nodes. It is set once every node has been analyzed.
+	Schedulable int64 `json:"schedulable"`
+}
+
+// ClusterQueueReport is the memory backlog of a ClusterQueue.
+type ClusterQueueReport struct {
+	Name string `json:"name"`
+
+	// Nominal is the ClusterQueue's nominal memory quota summed over its
+	// flavors and Reserved the quota reserved by admitted Workloads.
+	Nominal  int64 `json:"nominal"`
+	Reserved int64 `json:"reserved"`
+
+	// PendingWorkloads are the Workloads waiting for admission and Pending
+	// their memory requests.
+	PendingWorkloads int   `json:"pendingWorkloads"`
+	Pending          int64 `json:"pending"`
+
+	// Blocker is what keeps the pending Workloads from being admitted and
+	// run: node capacity, quota, both or nothing (see capacityBlocker). It
+	// is set once every node has been analyzed.
+	Blocker string `json:"blocker,omitempty"`
+}
+
+// quotaFits reports whether the pending Workloads fit in the ClusterQueue's
+// unreserved nominal quota. Borrowing from the cohort is not considered.
+func (cq *ClusterQueueReport) quotaFits() bool {
+	return cq.Pending <= cq.Nominal-cq.Reserved
+}
+
+// kueueResources is the memory of a Kueue flavor.
+type kueueResources []struct {
+	Name string `json:"name"`
+
+	// NominalQuota is set in a ClusterQueue's spec and Total in its status.
+	NominalQuota resource.Quantity `json:"nominalQuota"`
+	Total        resource.Quantity `json:"total"`
+}
+
+func (rs kueueResources) memory(total bool) int64 {
+	var n int64
+
+	for _, r := range rs {
+		if r.Name != string(corev1.ResourceMemory) {
+			continue
+		}
+
+		if total {
+			n += r.Total.Value()
+		} else {
+			n += r.NominalQuota.Value()
+		}
+	}
+
+	return n
+}
+
+type kueueFlavors []struct {
+	Name      string         `json:"name"`
+	Resources kueueResources `json:"resources"`
+}
+
+// kueueClusterQueueList is the part of a ClusterQueue list we use.
+type kueueClusterQueueList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			ResourceGroups []struct {
+				Flavors kueueFlavors `json:"flavors"`
+			} `json:"resourceGroups"`
+		} `json:"spec"`
+		Status struct {
+			// FlavorsReservation replaced FlavorsUsage in Kueue 0.6.
+			FlavorsReservation kueueFlavors `json:"flavorsReservation"`
+			FlavorsUsage       kueueFlavors `json:"flavorsUsage"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// kueueLocalQueueList is the part of a LocalQueue list we use.
+type kueueLocalQueueList struct {
+	Items []struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+			Name      string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			ClusterQueue string `json:"clusterQueue"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// kueueWorkloadList is the part of a Workload list we use.
+type kueueWorkloadList struct {
+	Items []struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			QueueName string `json:"queueName"`
+			PodSets   []struct {
+				Count    int64                  `json:"count"`
+				Template corev1.PodTemplateSpec `json:"template"`
+			} `json:"podSets"`
+		} `json:"spec"`
+		Status struct {
+			Admission *struct {
+				ClusterQueue string `json:"clusterQueue"`
+			} `json:"admission"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// podSpecMemoryRequests returns the memory a pod of the spec requests.
+func podSpecMemoryRequests(spec *corev1.PodSpec) int64 {
+	return podSpecRequests(spec, corev1.ResourceMemory)
+}
+
+// podSpecRequests returns how much of the resource a pod of the spec
+// requests: the sum of its containers' requests or its largest init
+// container's, if more.
+func podSpecRequests(spec *corev1.PodSpec, name corev1.ResourceName) int64 {
+	var total, init int64
+
+	for _, c := range spec.Containers {
+		total += ResourceValue(name, c.Resources.Requests[name])
+	}
+
+	for _, c := range spec.InitContainers {
+		if r := ResourceValue(name, c.Resources.Requests[name]); r > init {
+			init = r
+		}
+	}
+
+	if init > total {
+		return init
+	}
+
+	return total
+}
+
+// kueueList gets a cluster-wide list of the named Kueue resource into v.
+func kueueList(ctx context.Context, kcs kubernetes.Interface, name string, v interface{}) error {
+	data, err := kcs.CoreV1().RESTClient().Get().
+		AbsPath(kueueAPI, name).
+		DoRaw(ctx)
+	if errors.IsNotFound(err) {
+		return fmt.Errorf("kueue: %s not found; is Kueue (kueue.x-k8s.io/v1beta1) installed?", name)
+	} else if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("kueue: %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// kueueBacklog reports each ClusterQueue's nominal and reserved memory quota
+// and the memory requested by its pending (not yet admitted or finished)
+// Workloads.
+func kueueBacklog(ctx context.Context, kcs kubernetes.Interface) (r *KueueReport, err error) {
+	ctx, span := tracer.Start(ctx, "list kueue workloads")
+	defer func() { endSpan(span, err) }()
+
+	cql := kueueClusterQueueList{}
+
+	err = kueueList(ctx, kcs, "clusterqueues", &cql)
+	if err != nil {
+		return nil, err
+	}
+
+	lql := kueueLocalQueueList{}
+
+	err = kueueList(ctx, kcs, "localqueues", &lql)
+	if err != nil {
+		return nil, err
+	}
+
+	wl := kueueWorkloadList{}
+
+	err = kueueList(ctx, kcs, "workloads", &wl)
+	if err != nil {
+		return nil, err
+	}
+
+	r = &KueueReport{}
+	cqs := map[string]*ClusterQueueReport{}
+
+	for _, item := range cql.Items {
+		cq := &ClusterQueueReport{Name: item.Metadata.Name}
+
+		for _, rg := range item.Spec.ResourceGroups {
+			for _, f := range rg.Flavors {
+				cq.Nominal += f.Resources.memory(false)
+			}
+		}
+
+		reservation := item.Status.FlavorsReservation
+		if reservation == nil {
+			reservation = item.Status.FlavorsUsage
+		}
+
+		for _, f := range reservation {
+			cq.Reserved += f.Resources.memory(true)
+		}
+
+		cqs[cq.Name] = cq
+		r.ClusterQueues = append(r.ClusterQueues, cq)
+	}
+
+	localQueues := map[string]string{}
+	for _, lq := range lql.Items {
+		localQueues[lq.Metadata.Namespace+"/"+lq.Metadata.Name] = lq.Spec.ClusterQueue
+	}
+
+workloads:
+	for _, w := range wl.Items {
+		if w.Status.Admission != nil {
+			continue
+		}
+
+		for _, c := range w.Status.Conditions {
+			if c.Type == "Finished" && c.Status == string(corev1.ConditionTrue) {
+				continue workloads
+			}
+		}
+
+		cq, ok := cqs[localQueues[w.Metadata.Namespace+"/"+w.Spec.QueueName]]
+		if !ok {
+			continue
+		}
+
+		cq.PendingWorkloads++
+
+		for _, ps := range w.Spec.PodSets {
+			cq.Pending += ps.Count * podSpecMemoryRequests(&ps.Template.Spec)
+		}
+	}
+
+	sort.Slice(r.ClusterQueues, func(i, j int) bool {
+		return r.ClusterQueues[i].Name < r.ClusterQueues[j].Name
+	})
+
+	return r, nil
+}
+
+// setBlockers records what blocks each ClusterQueue's backlog given the
+// cluster's schedulable memory.
+func (r *KueueReport) setBlockers(schedulable int64) {
+	r.Schedulable = schedulable
+
+	for _, cq := range r.ClusterQueues {
+		cq.Blocker = capacityBlocker(cq.Pending <= schedulable, cq.quotaFits())
+	}
+}
@@ -0,0 +1,90 @@
+package kubecap
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestPodSpecMemoryRequests(t *testing.T) {
+	container := func(mem string) corev1.Container {
+		return corev1.Container{Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(mem)},
+		}}
+	}
+
+	spec := &corev1.PodSpec{
+		Containers: []corev1.Container{container("1Gi"), container("2Gi")},
+	}
+
+	if got := podSpecMemoryRequests(spec); got != 3<<30 {
+		t.Errorf("containers = %d, want 3Gi", got)
+	}
+
+	// An init container needing more than all containers together sets the
+	// pod's requests.
+	spec.InitContainers = []corev1.Container{container("512Mi"), container("4Gi")}
+
+	if got := podSpecMemoryRequests(spec); got != 4<<30 {
+		t.Errorf("with init containers = %d, want 4Gi", got)
+	}
+}
+
+func TestKueueClusterQueueMemory(t *testing.T) {
+	cql := kueueClusterQueueList{}
+
+	err := json.Unmarshal([]byte(`{"items": [{
+		"metadata": {"name": "batch"},
+		"spec": {"resourceGroups": [
+			{"flavors": [
+				{"name": "on-demand", "resources": [{"name": "cpu", "nominalQuota": "10"}, {"name": "memory", "nominalQuota": "36Gi"}]},
+				{"name": "spot", "resources": [{"name": "memory", "nominalQuota": "64Gi"}]}
+			]}
+		]},
+		"status": {"flavorsUsage": [
+			{"name": "on-demand", "resources": [{"name": "memory", "total": "12Gi"}]}
+		]}
+	}]}`), &cql)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nominal int64
+	for _, f := range cql.Items[0].Spec.ResourceGroups[0].Flavors {
+		nominal += f.Resources.memory(false)
+	}
+
+	if nominal != 100<<30 {
+		t.Errorf("nominal = %d, want 100Gi", nominal)
+	}
+
+	if got := cql.Items[0].Status.FlavorsUsage[0].Resources.memory(true); got != 12<<30 {
+		t.Errorf("reserved = %d, want 12Gi", got)
+	}
+}
+
+func TestKueueSetBlockers(t *testing.T) {
+	r := &KueueReport{ClusterQueues: []*ClusterQueueReport{
+		{Name: "none", Nominal: 10, Reserved: 4, Pending: 6},
+		{Name: "quota", Nominal: 10, Reserved: 4, Pending: 7},
+		{Name: "capacity", Nominal: 100, Pending: 9},
+		{Name: "both", Nominal: 5, Pending: 9},
+	}}
+
+	r.setBlockers(8)
+
+	for _, cq := range r.ClusterQueues {
+		want := map[string]string{
+			"none":     "none",
+			"quota":    "quota",
+			"capacity": "node capacity",
+			"both":     "node capacity and quota",
+		}[cq.Name]
+
+		if cq.Blocker != want {
+			t.Errorf("%s: blocker = %q, want %q", cq.Name, cq.Blocker, want)
+		}
+	}
+}
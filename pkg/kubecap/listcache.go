@@ -0,0 +1,81 @@
+package kubecap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// ListCache keeps the pods and their metrics listed by a watch's reports for
+// an interval, so that the nodes can be reported more often than the much
+// larger pod lists are fetched from the API server. The reports reusing the
+// lists must not modify them.
+type ListCache struct {
+	interval time.Duration
+
+	mu sync.Mutex
+
+	podsAt   time.Time
+	podList  *corev1.PodList
+	podLevel PodResources
+
+	podMetricsAt   time.Time
+	podMetricsList *metricsapi.PodMetricsList
+}
+
+// NewListCache returns a cache listing the pods and their metrics again once
+// they are interval old.
+func NewListCache(interval time.Duration) *ListCache {
+	return &ListCache{interval: interval}
+}
+
+// fresh reports whether a list made at is still to be reused at now.
+func (c *ListCache) fresh(at, now time.Time) bool {
+	return !at.IsZero() && now.Sub(at) < c.interval
+}
+
+// pods returns the pods listed within the interval before now, listing them
+// again when they are older.
+func (c *ListCache) pods(ctx context.Context, kcs kubernetes.Interface, now time.Time) (*corev1.PodList, PodResources, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.fresh(c.podsAt, now) {
+		return c.podList, c.podLevel, nil
+	}
+
+	podList, podLevel, err := ListPods(ctx, kcs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.podsAt, c.podList, c.podLevel = now, podList, podLevel
+
+	return podList, podLevel, nil
+}
+
+// podMetrics likewise returns the pod metrics listed within the interval
+// before now.
+func (c *ListCache) podMetrics(ctx context.Context, mcs metricsv.Interface, now time.Time) (*metricsapi.PodMetricsList, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.fresh(c.podMetricsAt, now) {
+		return c.podMetricsList, nil
+	}
+
+	podMetricsList, err := mcs.MetricsV1beta1().PodMetricses("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	c.podMetricsAt, c.podMetricsList = now, podMetricsList
+
+	return podMetricsList, nil
+}
@@ -0,0 +1,48 @@
+package kubecap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+func TestListCache(t *testing.T) {
+	lists := 0
+
+	mcs := metricsfake.NewSimpleClientset()
+	mcs.PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+
+		return true, &metricsapi.PodMetricsList{}, nil
+	})
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := NewListCache(2 * time.Minute)
+
+	// Within the interval the pod metrics listed are reused, after it they
+	// are listed again.
+	for _, tc := range []struct {
+		at    time.Duration
+		lists int
+	}{
+		{0, 1},
+		{30 * time.Second, 1},
+		{90 * time.Second, 1},
+		{2 * time.Minute, 2},
+		{3 * time.Minute, 2},
+	} {
+		_, err := c.podMetrics(context.Background(), mcs, start.Add(tc.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if lists != tc.lists {
+			t.Errorf("after %s: lists = %d, want %d", tc.at, lists, tc.lists)
+		}
+	}
+}
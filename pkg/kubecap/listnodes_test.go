@@ -0,0 +1,87 @@
+package kubecap
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// nodePage is a page of the node list, continued by cont if set.
+func nodePage(cont string, names ...string) *corev1.NodeList {
+	list := &corev1.NodeList{ListMeta: metav1.ListMeta{Continue: cont}}
+	for _, name := range names {
+		list.Items = append(list.Items, corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"pool": "web"}},
+		})
+	}
+
+	return list
+}
+
+func TestListNodesPages(t *testing.T) {
+	// The fake's list actions don't carry the continue token, so the pages
+	// are returned in the order they are asked for.
+	pages := []*corev1.NodeList{
+		nodePage("page-2", "node-a", "node-b"),
+		nodePage("", "node-c"),
+	}
+	lists := 0
+
+	kcs := fake.NewSimpleClientset()
+	kcs.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if selector := action.(k8stesting.ListAction).GetListRestrictions().Labels.String(); selector != "pool=web" {
+			t.Errorf("list %d: selector = %q", lists, selector)
+		}
+
+		if lists >= len(pages) {
+			t.Fatalf("listed past the last page")
+		}
+
+		lists++
+
+		return true, pages[lists-1], nil
+	})
+
+	nodeList, err := ListNodes(context.Background(), kcs, "pool=web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lists != 2 {
+		t.Errorf("lists = %d, want 2", lists)
+	}
+
+	names := []string{}
+	for _, node := range nodeList.Items {
+		names = append(names, node.Name)
+	}
+
+	if len(names) != 3 || names[0] != "node-a" || names[1] != "node-b" || names[2] != "node-c" {
+		t.Errorf("nodes = %v, want every node of both pages", names)
+	}
+
+	// An error listing a later page fails the whole list.
+	failed := errors.New("the page expired")
+	lists = 0
+
+	kcs = fake.NewSimpleClientset()
+	kcs.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		if lists == 2 {
+			return true, nil, failed
+		}
+
+		return true, pages[0], nil
+	})
+
+	_, err = ListNodes(context.Background(), kcs, "")
+	if !errors.Is(err, failed) {
+		t.Errorf("err = %v, want %v", err, failed)
+	}
+}
@@ -0,0 +1,242 @@
+package kubecap
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// ClusterHeadroom is the cluster's headroom as a kubecap server advertises it
+// to the namespaces' teams: totals only, without any node or pod, so it can
+// be shared with tenants not allowed to read the cluster.
+type ClusterHeadroom struct {
+	Timestamp time.Time `json:"timestamp"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Resource  string    `json:"resource"`
+
+	Nodes   int `json:"nodes"`
+	OkNodes int `json:"okNodes"`
+
+	// Schedulable is the cluster's schedulable amount and Largest the most
+	// schedulable on any one node, the largest pod that fits.
+	Schedulable int64 `json:"schedulable"`
+	Largest     int64 `json:"largest"`
+
+	Groups []*GroupHeadroom `json:"groups,omitempty"`
+}
+
+// GroupHeadroom is a node group's headroom.
+type GroupHeadroom struct {
+	Group       string `json:"group"`
+	Nodes       int    `json:"nodes"`
+	Schedulable int64  `json:"schedulable"`
+	Largest     int64  `json:"largest"`
+}
+
+// NewClusterHeadroom sums up the report's headroom. Nodes left out of the
+// schedulable totals don't count towards it.
+func NewClusterHeadroom(r *ClusterReport) *ClusterHeadroom {
+	h := &ClusterHeadroom{}
+
+	if md := r.Metadata; md != nil {
+		h.Timestamp = md.Timestamp
+		h.Cluster = md.Cluster
+		h.Resource = string(md.ResourceName())
+	}
+
+	groups := map[string]*GroupHeadroom{}
+
+	for _, n := range r.Nodes {
+		h.Nodes++
+		if n.Ok {
+			h.OkNodes++
+		}
+
+		if n.Excluded {
+			continue
+		}
+
+		h.Schedulable += n.Schedulable
+		if n.Schedulable > h.Largest {
+			h.Largest = n.Schedulable
+		}
+
+		if n.Group == "" {
+			continue
+		}
+
+		g, ok := groups[n.Group]
+		if !ok {
+			g = &GroupHeadroom{Group: n.Group}
+			groups[n.Group] = g
+			h.Groups = append(h.Groups, g)
+		}
+
+		g.Nodes++
+		g.Schedulable += n.Schedulable
+		if n.Schedulable > g.Largest {
+			g.Largest = n.Schedulable
+		}
+	}
+
+	sort.Slice(h.Groups, func(i, j int) bool { return h.Groups[i].Group < h.Groups[j].Group })
+
+	return h
+}
+
+// NamespaceReport is a namespace's use of a resource against its quotas and
+// the cluster's advertised headroom. It is collected with namespace-scoped
+// permissions only, so that teams can check their own capacity. Amounts are
+// in millicores for CPU and bytes otherwise.
+type NamespaceReport struct {
+	Timestamp time.Time `json:"timestamp"`
+	Namespace string    `json:"namespace"`
+	Resource  string    `json:"resource"`
+
+	// Pods are the namespace's pods not finished and Requests and Limits
+	// theirs. Containers without a limit don't count towards Limits.
+	Pods     int   `json:"pods"`
+	Requests int64 `json:"requests"`
+	Limits   int64 `json:"limits"`
+
+	// Used is the pods' usage, nil when the pod metrics couldn't be listed,
+	// and UsageError why.
+	Used       *int64 `json:"used,omitempty"`
+	UsageError string `json:"usageError,omitempty"`
+
+	// Workloads are the requests and usage by workload, largest requests
+	// first.
+	Workloads []*NamespaceWorkload `json:"workloads"`
+
+	// Quota is the headroom the namespace's ResourceQuotas leave.
+	Quota *QuotaReport `json:"quota"`
+
+	// Cluster is the cluster's advertised headroom, when given.
+	Cluster *ClusterHeadroom `json:"cluster,omitempty"`
+
+	// Additional is the amount checked for: it fits when the quotas leave
+	// room for it and, when the cluster's headroom is given, a node has as
+	// much schedulable. Blocker is what keeps it from fitting (see
+	// capacityBlocker).
+	Additional int64  `json:"additional"`
+	Fits       bool   `json:"fits"`
+	Blocker    string `json:"blocker"`
+}
+
+// NamespaceWorkload is the requests and usage of a workload's pods in the
+// namespace. Pods without a controller are their own workload.
+type NamespaceWorkload struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Pods     int    `json:"pods"`
+	Requests int64  `json:"requests"`
+	Used     int64  `json:"used"`
+}
+
+// CollectNamespace collects the report on the namespace's use of the resource
+// listing only its pods, their metrics and its ResourceQuotas. The cluster's
+// headroom is optional. Failing to list the pod metrics only leaves the usage
+// out.
+func CollectNamespace(ctx context.Context, kcs kubernetes.Interface, mcs metricsv.Interface, namespace string, resource corev1.ResourceName, additional int64, cluster *ClusterHeadroom) (r *NamespaceReport, err error) {
+	ctx, span := tracer.Start(ctx, "collect namespace", trace.WithAttributes(
+		attribute.String("k8s.namespace.name", namespace),
+	))
+	defer func() { endSpan(span, err) }()
+
+	r = &NamespaceReport{
+		Timestamp:  time.Now(),
+		Namespace:  namespace,
+		Resource:   string(resource),
+		Cluster:    cluster,
+		Additional: additional,
+		Workloads:  []*NamespaceWorkload{},
+	}
+
+	podList, err := kcs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]int64{}
+
+	podMetricsList, merr := mcs.MetricsV1beta1().PodMetricses(namespace).List(ctx, metav1.ListOptions{})
+	if merr != nil {
+		r.UsageError = merr.Error()
+	} else {
+		var total int64
+
+		for _, pm := range podMetricsList.Items {
+			for _, c := range pm.Containers {
+				u := ResourceValue(resource, c.Usage[resource])
+				used[pm.Name] += u
+				total += u
+			}
+		}
+
+		r.Used = &total
+	}
+
+	workloads := map[string]*NamespaceWorkload{}
+
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if PodState(pod) == PodStateFinished {
+			continue
+		}
+
+		requests := PodResources(nil).Requests(pod, resource)
+
+		r.Pods++
+		r.Requests += requests
+
+		for _, c := range pod.Spec.Containers {
+			if q, ok := c.Resources.Limits[resource]; ok {
+				r.Limits += ResourceValue(resource, q)
+			}
+		}
+
+		kind, name := PodWorkload(pod)
+		if kind == "" {
+			kind, name = "Pod", pod.Name
+		}
+
+		w, ok := workloads[kind+"/"+name]
+		if !ok {
+			w = &NamespaceWorkload{Kind: kind, Name: name}
+			workloads[kind+"/"+name] = w
+			r.Workloads = append(r.Workloads, w)
+		}
+
+		w.Pods++
+		w.Requests += requests
+		w.Used += used[pod.Name]
+	}
+
+	sort.SliceStable(r.Workloads, func(i, j int) bool {
+		a, b := r.Workloads[i], r.Workloads[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+
+		return a.Kind+"/"+a.Name < b.Kind+"/"+b.Name
+	})
+
+	r.Quota, err = namespaceQuota(ctx, kcs, namespace, resource, additional)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeFits := cluster == nil || additional <= cluster.Largest
+
+	r.Fits = nodeFits && r.Quota.Fits
+	r.Blocker = capacityBlocker(nodeFits, r.Quota.Fits)
+
+	return r, nil
+}
@@ -0,0 +1,107 @@
+package kubecap
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+func TestCollectNamespace(t *testing.T) {
+	gi := func(n int64) resource.Quantity {
+		return *resource.NewQuantity(n<<30, resource.BinarySI)
+	}
+
+	pod := func(namespace, name string, requests, limits int64, phase corev1.PodPhase, owner string) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceMemory: gi(requests)},
+				},
+			}}},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+
+		if limits > 0 {
+			p.Spec.Containers[0].Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: gi(limits)}
+		}
+
+		if owner != "" {
+			controller := true
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: "StatefulSet", Name: owner, Controller: &controller}}
+		}
+
+		return p
+	}
+
+	kcs := fake.NewSimpleClientset(
+		pod("team", "db-0", 4, 8, corev1.PodRunning, "db"),
+		pod("team", "db-1", 4, 0, corev1.PodRunning, "db"),
+		pod("team", "job", 1, 0, corev1.PodRunning, ""),
+		pod("team", "done", 16, 0, corev1.PodSucceeded, ""),
+		pod("other", "big", 64, 0, corev1.PodRunning, ""),
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "mem"},
+			Status: corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{corev1.ResourceRequestsMemory: gi(12)},
+				Used: corev1.ResourceList{corev1.ResourceRequestsMemory: gi(9)},
+			},
+		},
+	)
+
+	mcs := metricsfake.NewSimpleClientset(&metricsapi.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "db-0"},
+		Containers: []metricsapi.ContainerMetrics{{Name: "app", Usage: corev1.ResourceList{corev1.ResourceMemory: gi(3)}}},
+	})
+
+	cluster := &ClusterHeadroom{Resource: "memory", Schedulable: 20 << 30, Largest: 6 << 30}
+
+	r, err := CollectNamespace(context.Background(), kcs, mcs, "team", corev1.ResourceMemory, 2<<30, cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Pods != 3 || r.Requests != 9<<30 || r.Limits != 8<<30 {
+		t.Errorf("pods, requests, limits = %d, %d, %d, want 3, 9GiB, 8GiB", r.Pods, r.Requests, r.Limits)
+	}
+
+	if r.Used == nil || *r.Used != 3<<30 {
+		t.Errorf("used = %v, want 3GiB", r.Used)
+	}
+
+	if len(r.Workloads) != 2 || r.Workloads[0].Name != "db" || r.Workloads[0].Pods != 2 || r.Workloads[0].Used != 3<<30 {
+		t.Errorf("workloads = %+v, want db's two pods first", r.Workloads)
+	}
+
+	if r.Quota.Headroom != 3<<30 || !r.Fits || r.Blocker != "none" {
+		t.Errorf("quota headroom = %d, fits = %t, blocker = %s, want 3GiB, true, none", r.Quota.Headroom, r.Fits, r.Blocker)
+	}
+
+	// Without access to the pod metrics the usage is left out.
+	mcs.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	r, err = CollectNamespace(context.Background(), kcs, mcs, "team", corev1.ResourceMemory, 8<<30, cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Used != nil || r.UsageError == "" {
+		t.Errorf("used = %v, usage error = %q, want none and an error", r.Used, r.UsageError)
+	}
+
+	if r.Fits || r.Blocker != "node capacity and quota" {
+		t.Errorf("8GiB: fits = %t, blocker = %s, want false, node capacity and quota", r.Fits, r.Blocker)
+	}
+}
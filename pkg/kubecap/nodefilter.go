@@ -0,0 +1,170 @@
+package kubecap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// NodeFilter selects nodes by what labels don't tell: their age and kubelet
+// version, e.g. to target the nodes slated for replacement.
+type NodeFilter struct {
+	// OlderThan selects the nodes created longer ago than it.
+	OlderThan time.Duration `json:"olderThan,omitempty"`
+
+	// KubeletVersion selects the nodes whose kubelet version satisfies all
+	// its comma separated constraints, e.g. <1.29 or >=1.27,<1.29.
+	KubeletVersion string `json:"kubeletVersion,omitempty"`
+
+	constraints []versionConstraint
+}
+
+// versionConstraint is a comparison against a version, e.g. <1.29.
+type versionConstraint struct {
+	op      string
+	version *version.Version
+}
+
+// versionOps are the constraints' operators, the longer ones first.
+var versionOps = []string{"<=", ">=", "==", "!=", "<", ">", "="}
+
+// ParseNodeFilter parses the age (a duration, which may be given in days,
+// e.g. 90d) and kubelet version constraints, either of which may be empty.
+// It returns nil when both are.
+func ParseNodeFilter(olderThan, kubeletVersion string) (*NodeFilter, error) {
+	if olderThan == "" && kubeletVersion == "" {
+		return nil, nil
+	}
+
+	f := &NodeFilter{KubeletVersion: kubeletVersion}
+
+	if olderThan != "" {
+		age, err := ParseAge(olderThan)
+		if err != nil {
+			return nil, err
+		}
+
+		f.OlderThan = age
+	}
+
+	for _, c := range strings.Split(kubeletVersion, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+
+		vc := versionConstraint{op: "="}
+
+		for _, op := range versionOps {
+			if strings.HasPrefix(c, op) {
+				vc.op = op
+				c = strings.TrimSpace(strings.TrimPrefix(c, op))
+
+				break
+			}
+		}
+
+		v, err := version.ParseGeneric(c)
+		if err != nil {
+			return nil, fmt.Errorf("kubelet version constraint %q: %w", kubeletVersion, err)
+		}
+
+		vc.version = v
+		f.constraints = append(f.constraints, vc)
+	}
+
+	return f, nil
+}
+
+// ParseAge parses a duration, also accepting whole days, e.g. 90d.
+func ParseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("age %q: not a number of days", s)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("age %q: %w", s, err)
+	}
+
+	return d, nil
+}
+
+// compareVersion compares v with the constraint's version on as many
+// components as it has, so that 1.28.5 equals 1.28.
+func compareVersion(v, constraint *version.Version) int {
+	vc := v.Components()
+	cc := constraint.Components()
+
+	for i, c := range cc {
+		var n uint
+		if i < len(vc) {
+			n = vc[i]
+		}
+
+		switch {
+		case n < c:
+			return -1
+		case n > c:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// Matches reports whether the node is selected at now. Nodes whose kubelet
+// version can't be parsed don't satisfy version constraints.
+func (f *NodeFilter) Matches(node *corev1.Node, now time.Time) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.OlderThan > 0 && now.Sub(node.CreationTimestamp.Time) <= f.OlderThan {
+		return false
+	}
+
+	if len(f.constraints) == 0 {
+		return true
+	}
+
+	v, err := version.ParseGeneric(node.Status.NodeInfo.KubeletVersion)
+	if err != nil {
+		return false
+	}
+
+	for _, c := range f.constraints {
+		cmp := compareVersion(v, c.version)
+
+		ok := false
+		switch c.op {
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "=", "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,80 @@
+package kubecap
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseAge(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"90d": 90 * 24 * time.Hour,
+		"36h": 36 * time.Hour,
+		"0d":  0,
+	} {
+		got, err := ParseAge(s)
+		if err != nil || got != want {
+			t.Errorf("ParseAge(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"d", "-1d", "ninety days"} {
+		if _, err := ParseAge(s); err == nil {
+			t.Errorf("ParseAge(%q): no error", s)
+		}
+	}
+}
+
+func TestNodeFilter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	node := func(age time.Duration, kubelet string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-age))},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KubeletVersion: kubelet}},
+		}
+	}
+
+	old := node(100*24*time.Hour, "v1.28.5-eks-5e0fdde")
+	young := node(10*24*time.Hour, "v1.29.1")
+
+	for _, tc := range []struct {
+		olderThan, kubelet string
+		old, young         bool
+	}{
+		{"90d", "", true, false},
+		{"", "<1.29", true, false},
+		{"", ">=1.28,<1.30", true, true},
+		{"", "1.28", true, false},
+		{"", "!=1.28", false, true},
+		{"", ">1.29.0", false, true},
+		{"1d", ">=1.29", false, true},
+	} {
+		f, err := ParseNodeFilter(tc.olderThan, tc.kubelet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := f.Matches(old, now); got != tc.old {
+			t.Errorf("%q %q: old = %t", tc.olderThan, tc.kubelet, got)
+		}
+
+		if got := f.Matches(young, now); got != tc.young {
+			t.Errorf("%q %q: young = %t", tc.olderThan, tc.kubelet, got)
+		}
+	}
+
+	if f, err := ParseNodeFilter("", ""); f != nil || err != nil {
+		t.Errorf("no filter = %v, %v", f, err)
+	}
+
+	if !(*NodeFilter)(nil).Matches(old, now) {
+		t.Error("nil filter doesn't match")
+	}
+
+	if _, err := ParseNodeFilter("", "<one"); err == nil {
+		t.Error("bad version: no error")
+	}
+}
@@ -0,0 +1,56 @@
+package kubecap
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+type NodePods map[string][]*corev1.Pod
+
+func NewNodePods(podList *corev1.PodList) NodePods {
+	nps := NodePods{}
+
+	for _, pod := range podList.Items {
+		nps.Add(&pod)
+	}
+
+	return nps
+}
+
+// Add adds the pod to its node. Unbound pods nominated for a node by
+// preemption are added to that node since the scheduler holds its capacity
+// for them.
+func (nps NodePods) Add(p *corev1.Pod) {
+	nodeName := p.Spec.NodeName
+	if nodeName == "" {
+		nodeName = p.Status.NominatedNodeName
+	}
+
+	if nodeName == "" {
+		return
+	}
+
+	var pods []*corev1.Pod
+	var ok bool
+
+	if pods, ok = nps[nodeName]; !ok {
+		pods = []*corev1.Pod{}
+	}
+
+	pods = append(pods, p.DeepCopy())
+	nps[nodeName] = pods
+}
+
+func (nps NodePods) MemoryRequests(nodeName string, pr PodResources) (total *resource.Quantity) {
+	total = resource.NewQuantity(0, resource.BinarySI)
+
+	if _, ok := nps[nodeName]; !ok {
+		return total
+	}
+
+	for _, pod := range nps[nodeName] {
+		total.Add(*resource.NewQuantity(pr.MemoryRequests(pod), resource.BinarySI))
+	}
+
+	return total
+}
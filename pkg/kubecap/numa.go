@@ -0,0 +1,135 @@
+package kubecap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NUMAReport describes a node whose kubelet runs the Memory Manager with the
+// Static policy, which pins Guaranteed pods' memory to NUMA nodes.
+type NUMAReport struct {
+	TopologyManagerPolicy string `json:"topologyManagerPolicy,omitempty"`
+
+	// Zones are the node's NUMA nodes. Allocatable and Available are only
+	// known when the NodeResourceTopology API (topology-updater) is
+	// installed; otherwise only the kubelet's reserved memory is.
+	Zones []*NUMAZone `json:"zones"`
+
+	// LargestAvailable is the most memory available on a single NUMA node,
+	// or -1 when unknown.
+	LargestAvailable int64 `json:"largestAvailable"`
+}
+
+// NUMAZone is the memory of a single NUMA node. All amounts are in bytes.
+type NUMAZone struct {
+	Name        string `json:"name"`
+	Reserved    int64  `json:"reserved"`
+	Allocatable int64  `json:"allocatable,omitempty"`
+	Available   int64  `json:"available,omitempty"`
+}
+
+// singleNUMA reports whether Guaranteed pods must fit in a single NUMA node.
+func (r *NUMAReport) singleNUMA() bool {
+	return r.TopologyManagerPolicy == "single-numa-node"
+}
+
+// nodeResourceTopology is the part of a topology.node.k8s.io
+// NodeResourceTopology we use.
+type nodeResourceTopology struct {
+	Zones []struct {
+		Name      string `json:"name"`
+		Type      string `json:"type"`
+		Resources []struct {
+			Name        string            `json:"name"`
+			Allocatable resource.Quantity `json:"allocatable"`
+			Available   resource.Quantity `json:"available"`
+		} `json:"resources"`
+	} `json:"zones"`
+}
+
+// nodeNUMA reports memory per NUMA node for nodes using the Static Memory
+// Manager policy according to their kubelet configuration. It returns nil for
+// other nodes.
+func nodeNUMA(ctx context.Context, kcs kubernetes.Interface, name string, cfg *kubeletConfigz) (r *NUMAReport, err error) {
+	if !strings.EqualFold(cfg.KubeletConfig.MemoryManagerPolicy, "Static") {
+		return nil, nil
+	}
+
+	ctx, span := tracer.Start(ctx, "get node resource topology", trace.WithAttributes(
+		attribute.String("k8s.node.name", name),
+	))
+	defer func() { endSpan(span, err) }()
+
+	r = &NUMAReport{
+		TopologyManagerPolicy: cfg.KubeletConfig.TopologyManagerPolicy,
+		LargestAvailable:      -1,
+	}
+
+	zones := map[string]*NUMAZone{}
+	zone := func(name string) *NUMAZone {
+		z, ok := zones[name]
+		if !ok {
+			z = &NUMAZone{Name: name}
+			zones[name] = z
+			r.Zones = append(r.Zones, z)
+		}
+
+		return z
+	}
+
+	for _, rm := range cfg.KubeletConfig.ReservedMemory {
+		zone(fmt.Sprintf("node-%d", rm.NumaNode)).Reserved = rm.Limits.Memory().Value()
+	}
+
+	data, err := kcs.CoreV1().RESTClient().Get().
+		AbsPath("/apis/topology.node.k8s.io/v1alpha2/noderesourcetopologies", name).
+		DoRaw(ctx)
+	if errors.IsNotFound(err) {
+		err = nil
+	} else if err != nil {
+		return nil, err
+	} else {
+		nrt := nodeResourceTopology{}
+
+		err = json.Unmarshal(data, &nrt)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: node resource topology: %w", name, err)
+		}
+
+		for _, z := range nrt.Zones {
+			if z.Type != "Node" {
+				continue
+			}
+
+			for _, res := range z.Resources {
+				if res.Name != string(corev1.ResourceMemory) {
+					continue
+				}
+
+				nz := zone(z.Name)
+				nz.Allocatable = res.Allocatable.Value()
+				nz.Available = res.Available.Value()
+
+				if nz.Available > r.LargestAvailable {
+					r.LargestAvailable = nz.Available
+				}
+			}
+		}
+	}
+
+	sort.Slice(r.Zones, func(i, j int) bool {
+		return r.Zones[i].Name < r.Zones[j].Name
+	})
+
+	return r, nil
+}
@@ -0,0 +1,141 @@
+package kubecap
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Causes of a pod's requests escaping the scheduler's accounting.
+const (
+	OvercommitStatic      = "static"
+	OvercommitScheduler   = "scheduler"
+	OvercommitTerminating = "terminating"
+	OvercommitNominated   = "nominated"
+)
+
+// OvercommitReport flags a node whose effective requests exceed its
+// allocatable amount, which the default scheduler never allows: from
+// accounting drift, static pods or pods bound bypassing the scheduler. The
+// node's schedulable amount is meaningless then.
+type OvercommitReport struct {
+	Requests    int64 `json:"requests"`
+	Allocatable int64 `json:"allocatable"`
+	Excess      int64 `json:"excess"`
+
+	// Pods are the pods contributing to the excess: those with a cause
+	// first, then the largest others until they account for the excess.
+	Pods []*OvercommitPod `json:"pods"`
+}
+
+// OvercommitPod is a pod on an overcommitted node.
+type OvercommitPod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	// Requests are the pod's effective requests, weighted by its state.
+	Requests int64 `json:"requests"`
+
+	// Cause is how the pod's requests may have escaped the scheduler's
+	// accounting, if it is known: static (run by the kubelet from a
+	// manifest), scheduler (bound by Scheduler rather than the default
+	// scheduler), terminating (still counted alongside its replacement) or
+	// nominated (counted before preemption made room).
+	Cause     string `json:"cause,omitempty"`
+	Scheduler string `json:"scheduler,omitempty"`
+}
+
+// overcommitCause returns why the pod's requests may have escaped the
+// scheduler's accounting, if known.
+func overcommitCause(pod *corev1.Pod) string {
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return OvercommitStatic
+	}
+
+	if s := pod.Spec.SchedulerName; s != "" && s != corev1.DefaultSchedulerName {
+		return OvercommitScheduler
+	}
+
+	switch PodState(pod) {
+	case PodStateTerminating:
+		return OvercommitTerminating
+	case PodStateNominated:
+		return OvercommitNominated
+	}
+
+	return ""
+}
+
+// overcommit returns the report on the node's pods when their effective
+// requests exceed its allocatable amount, nil otherwise.
+func overcommit(md *Metadata, pods []*corev1.Pod, pr PodResources, allocatable, requests int64) *OvercommitReport {
+	if requests <= allocatable {
+		return nil
+	}
+
+	r := &OvercommitReport{
+		Requests:    requests,
+		Allocatable: allocatable,
+		Excess:      requests - allocatable,
+		Pods:        []*OvercommitPod{},
+	}
+
+	caused := []*OvercommitPod{}
+	others := []*OvercommitPod{}
+
+	for _, pod := range pods {
+		state := PodState(pod)
+
+		op := &OvercommitPod{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Requests:  int64(md.StateWeight(state) * float64(pr.Requests(pod, md.ResourceName()))),
+			Cause:     overcommitCause(pod),
+		}
+
+		if op.Requests <= 0 {
+			continue
+		}
+
+		if op.Cause == OvercommitScheduler {
+			op.Scheduler = pod.Spec.SchedulerName
+		}
+
+		if op.Cause != "" {
+			caused = append(caused, op)
+		} else {
+			others = append(others, op)
+		}
+	}
+
+	largest := func(pods []*OvercommitPod) {
+		sort.SliceStable(pods, func(i, j int) bool {
+			if pods[i].Requests != pods[j].Requests {
+				return pods[i].Requests > pods[j].Requests
+			}
+
+			return pods[i].Namespace+"/"+pods[i].Name < pods[j].Namespace+"/"+pods[j].Name
+		})
+	}
+
+	largest(caused)
+	largest(others)
+
+	var accounted int64
+
+	for _, op := range caused {
+		r.Pods = append(r.Pods, op)
+		accounted += op.Requests
+	}
+
+	for _, op := range others {
+		if accounted >= r.Excess {
+			break
+		}
+
+		r.Pods = append(r.Pods, op)
+		accounted += op.Requests
+	}
+
+	return r
+}
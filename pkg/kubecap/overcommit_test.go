@@ -0,0 +1,55 @@
+package kubecap
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOvercommit(t *testing.T) {
+	pod := func(name string, gi int64) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceMemory: *resource.NewQuantity(gi<<30, resource.BinarySI)},
+					},
+				}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+
+	static := pod("etcd", 1)
+	static.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: "hash"}
+
+	custom := pod("batch", 1)
+	custom.Spec.SchedulerName = "volcano"
+
+	pods := []*corev1.Pod{pod("small", 1), pod("large", 4), pod("medium", 2), static, custom}
+
+	md := &Metadata{}
+
+	if r := overcommit(md, pods, PodResources{}, 9<<30, 9<<30); r != nil {
+		t.Errorf("at allocatable: %+v", r)
+	}
+
+	// 9Gi on 5Gi: the static and other scheduler's pods account for 2Gi of
+	// the excess, the largest other pod for the rest.
+	r := overcommit(md, pods, PodResources{}, 5<<30, 9<<30)
+	if r == nil || r.Excess != 4<<30 {
+		t.Fatalf("overcommitted = %+v", r)
+	}
+
+	got := []string{}
+	for _, p := range r.Pods {
+		got = append(got, p.Name+":"+p.Cause+p.Scheduler)
+	}
+
+	if len(got) != 3 || got[0] != "batch:schedulervolcano" || got[1] != "etcd:static" || got[2] != "large:" {
+		t.Errorf("pods = %q", got)
+	}
+}
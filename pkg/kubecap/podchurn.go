@@ -0,0 +1,109 @@
+package kubecap
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodChurnReport is the pod churn on a node over the recent window: the
+// containers the kubelet started and stopped, from its events. High churn
+// nodes need more headroom than their steady state suggests.
+type PodChurnReport struct {
+	Starts int `json:"starts"`
+	Stops  int `json:"stops"`
+}
+
+func (c *PodChurnReport) starts() int {
+	if c == nil {
+		return 0
+	}
+
+	return c.Starts
+}
+
+func (c *PodChurnReport) stops() int {
+	if c == nil {
+		return 0
+	}
+
+	return c.Stops
+}
+
+// listPodChurn counts the kubelets' container start (Started) and stop
+// (Killing) events per node since the window before now. Repeated events
+// count once per occurrence when they started within the window and once
+// otherwise. Events are kept for an hour by default so longer windows see no
+// further back.
+func listPodChurn(ctx context.Context, kcs kubernetes.Interface, window time.Duration, now time.Time) (churn map[string]*PodChurnReport, err error) {
+	ctx, span := tracer.Start(ctx, "list pod events")
+	defer func() { endSpan(span, err) }()
+
+	eventList, err := kcs.CoreV1().Events("").List(ctx, metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=Pod",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	since := now.Add(-window)
+	churn = map[string]*PodChurnReport{}
+
+	for i := range eventList.Items {
+		ev := &eventList.Items[i]
+
+		if ev.InvolvedObject.Kind != "Pod" || ev.Source.Host == "" || (ev.Reason != "Started" && ev.Reason != "Killing") {
+			continue
+		}
+
+		last := eventTime(ev.LastTimestamp, ev)
+		if last.Before(since) {
+			continue
+		}
+
+		n := 1
+		if ev.Count > 1 && !eventTime(ev.FirstTimestamp, ev).Before(since) {
+			n = int(ev.Count)
+		}
+
+		c, ok := churn[ev.Source.Host]
+		if !ok {
+			c = &PodChurnReport{}
+			churn[ev.Source.Host] = c
+		}
+
+		if ev.Reason == "Started" {
+			c.Starts += n
+		} else {
+			c.Stops += n
+		}
+	}
+
+	return churn, nil
+}
+
+// eventTime returns ts or, for events only setting the newer EventTime, that.
+func eventTime(ts metav1.Time, ev *corev1.Event) time.Time {
+	if ts.IsZero() {
+		return ev.EventTime.Time
+	}
+
+	return ts.Time
+}
+
+// podCount returns the number of pods running or starting on the node,
+// which count towards its allocatable pods.
+func podCount(node string, pods []*corev1.Pod) int {
+	count := 0
+
+	for _, pod := range pods {
+		if pod.Spec.NodeName == node && PodState(pod) != PodStateFinished {
+			count++
+		}
+	}
+
+	return count
+}
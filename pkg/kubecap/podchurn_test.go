@@ -0,0 +1,63 @@
+package kubecap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestListPodChurn(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	event := func(name, reason, host string, count int32, first, last time.Duration) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "shop", Name: name},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: "web-0"},
+			Reason:         reason,
+			Source:         corev1.EventSource{Component: "kubelet", Host: host},
+			Count:          count,
+			FirstTimestamp: metav1.NewTime(now.Add(-first)),
+			LastTimestamp:  metav1.NewTime(now.Add(-last)),
+		}
+	}
+
+	kcs := fake.NewSimpleClientset(
+		event("a", "Started", "node-a", 3, 5*time.Minute, time.Minute),
+		// Only its last occurrence is within the window.
+		event("b", "Started", "node-a", 4, time.Hour, 2*time.Minute),
+		event("c", "Killing", "node-a", 1, 3*time.Minute, 3*time.Minute),
+		event("d", "Killing", "node-b", 1, 20*time.Minute, 20*time.Minute),
+		event("e", "Pulled", "node-b", 1, time.Minute, time.Minute),
+	)
+
+	churn, err := listPodChurn(context.Background(), kcs, 15*time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := churn["node-a"]; c == nil || c.Starts != 4 || c.Stops != 1 {
+		t.Errorf("node-a churn = %+v, want 4 starts and 1 stop", c)
+	}
+
+	if c := churn["node-b"]; c != nil {
+		t.Errorf("node-b churn = %+v, want none", c)
+	}
+}
+
+func TestPodCount(t *testing.T) {
+	pods := []*corev1.Pod{
+		{Spec: corev1.PodSpec{NodeName: "node-a"}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		{Spec: corev1.PodSpec{NodeName: "node-a"}, Status: corev1.PodStatus{Phase: corev1.PodPending}},
+		{Spec: corev1.PodSpec{NodeName: "node-a"}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+		// Nominated for the node by preemption but not yet bound.
+		{Status: corev1.PodStatus{Phase: corev1.PodPending, NominatedNodeName: "node-a"}},
+	}
+
+	if n := podCount("node-a", pods); n != 2 {
+		t.Errorf("pods = %d, want 2", n)
+	}
+}
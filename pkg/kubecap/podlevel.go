@@ -0,0 +1,158 @@
+package kubecap
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodResources are pod-level resource requirements (spec.resources) by
+// namespace/name. The vendored API types predate the field so it is decoded
+// from the raw pod list alongside them.
+type PodResources map[string]corev1.ResourceRequirements
+
+// Requests returns the pod's effective requests of the resource: the
+// pod-level request when set and the sum of its containers' requests
+// otherwise.
+func (pr PodResources) Requests(pod *corev1.Pod, name corev1.ResourceName) int64 {
+	if r, ok := pr[pod.Namespace+"/"+pod.Name]; ok {
+		if q, ok := r.Requests[name]; ok {
+			return ResourceValue(name, q)
+		}
+	}
+
+	var total int64
+	for _, container := range pod.Spec.Containers {
+		if q, ok := container.Resources.Requests[name]; ok {
+			total += ResourceValue(name, q)
+		}
+	}
+
+	return total
+}
+
+// limits returns the pod-level limit of the resource, if any.
+func (pr PodResources) limits(pod *corev1.Pod, name corev1.ResourceName) (int64, bool) {
+	r, ok := pr[pod.Namespace+"/"+pod.Name]
+	if !ok {
+		return 0, false
+	}
+
+	q, ok := r.Limits[name]
+	if !ok {
+		return 0, false
+	}
+
+	return ResourceValue(name, q), true
+}
+
+// MemoryRequests returns the pod's effective memory requests.
+func (pr PodResources) MemoryRequests(pod *corev1.Pod) int64 {
+	return pr.Requests(pod, corev1.ResourceMemory)
+}
+
+// memoryLimits returns the pod-level memory limit, if any.
+func (pr PodResources) memoryLimits(pod *corev1.Pod) (int64, bool) {
+	return pr.limits(pod, corev1.ResourceMemory)
+}
+
+// podLevelList is the part of a pod list carrying pod-level resources and
+// scheduling gates.
+type podLevelList struct {
+	Items []struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+			Name      string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			Resources       *corev1.ResourceRequirements `json:"resources"`
+			SchedulingGates []struct {
+				Name string `json:"name"`
+			} `json:"schedulingGates"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// ListPods lists the pods in all namespaces along with their pod-level
+// resources. Pods held back by scheduling gates are left out: the scheduler
+// won't consider them, so neither do we.
+func ListPods(ctx context.Context, kcs kubernetes.Interface) (*corev1.PodList, PodResources, error) {
+	podList := &corev1.PodList{}
+	pll := podLevelList{}
+
+	// The pods are listed page by page.
+	cont := ""
+
+	for {
+		req := kcs.CoreV1().RESTClient().Get().
+			AbsPath("/api/v1/pods").
+			Param("limit", strconv.Itoa(listPageSize))
+		if cont != "" {
+			req = req.Param("continue", cont)
+		}
+
+		// The result's error, unlike DoRaw's, carries the message of the
+		// API server's Status (e.g. why RBAC forbids the list).
+		result := req.Do(ctx)
+
+		err := result.Error()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		data, err := result.Raw()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		page := &corev1.PodList{}
+
+		err = json.Unmarshal(data, page)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		levelPage := podLevelList{}
+
+		err = json.Unmarshal(data, &levelPage)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		podList.Items = append(podList.Items, page.Items...)
+		pll.Items = append(pll.Items, levelPage.Items...)
+
+		cont = page.Continue
+		if cont == "" {
+			break
+		}
+	}
+
+	pr := PodResources{}
+	gated := map[string]bool{}
+	for _, p := range pll.Items {
+		if p.Spec.Resources != nil {
+			pr[p.Metadata.Namespace+"/"+p.Metadata.Name] = *p.Spec.Resources
+		}
+
+		if len(p.Spec.SchedulingGates) > 0 {
+			gated[p.Metadata.Namespace+"/"+p.Metadata.Name] = true
+		}
+	}
+
+	if len(gated) > 0 {
+		pods := podList.Items[:0]
+		for _, pod := range podList.Items {
+			if !gated[pod.Namespace+"/"+pod.Name] {
+				pods = append(pods, pod)
+			}
+		}
+
+		podList.Items = pods
+	}
+
+	return podList, pr, nil
+}
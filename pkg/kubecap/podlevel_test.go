@@ -0,0 +1,73 @@
+package kubecap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestListPodsPages(t *testing.T) {
+	// The pods are served two pages, the second with a pod held back by a
+	// scheduling gate and one with pod-level resources.
+	pages := map[string]string{
+		"": `{"metadata": {"continue": "page-2"}, "items": [
+			{"metadata": {"namespace": "shop", "name": "web-0"}},
+			{"metadata": {"namespace": "shop", "name": "web-1"}}
+		]}`,
+		"page-2": `{"metadata": {}, "items": [
+			{"metadata": {"namespace": "jobs", "name": "gated"}, "spec": {"schedulingGates": [{"name": "quota"}]}},
+			{"metadata": {"namespace": "jobs", "name": "batch"}, "spec": {"resources": {"requests": {"memory": "4Gi"}}}}
+		]}`,
+	}
+	lists := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		if r.URL.Path != "/api/v1/pods" || q.Get("limit") != "500" {
+			t.Errorf("listed %s", r.URL)
+		}
+
+		page, ok := pages[q.Get("continue")]
+		if !ok {
+			t.Errorf("unknown continue token %q", q.Get("continue"))
+		}
+
+		lists++
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(page))
+	}))
+	defer srv.Close()
+
+	kcs, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podList, pr, err := ListPods(context.Background(), kcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lists != 2 {
+		t.Errorf("lists = %d, want 2", lists)
+	}
+
+	names := []string{}
+	for _, pod := range podList.Items {
+		names = append(names, pod.Namespace+"/"+pod.Name)
+	}
+
+	if len(names) != 3 || names[0] != "shop/web-0" || names[1] != "shop/web-1" || names[2] != "jobs/batch" {
+		t.Errorf("pods = %v, want both pages' without the gated pod", names)
+	}
+
+	if r, ok := pr["jobs/batch"]; !ok || r.Requests.Memory().Value() != 4<<30 || len(pr) != 1 {
+		t.Errorf("pod resources = %v", pr)
+	}
+}
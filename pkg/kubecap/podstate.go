@@ -0,0 +1,124 @@
+package kubecap
+
+import (
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Pod states distinguished among the pods bound to a node. Pending pods are
+// bound but not running yet, Nominated pods aren't bound but the node was
+// nominated for them by preemption and Terminating pods are being deleted but
+// still hold their memory.
+const (
+	podStateRunning     = "Running"
+	PodStatePending     = "Pending"
+	PodStateNominated   = "Nominated"
+	PodStateTerminating = "Terminating"
+	PodStateFinished    = "Finished"
+)
+
+// PodState classifies the pod.
+func PodState(pod *corev1.Pod) string {
+	switch {
+	case pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed:
+		return PodStateFinished
+	case pod.DeletionTimestamp != nil:
+		return PodStateTerminating
+	case pod.Spec.NodeName == "" && pod.Status.NominatedNodeName != "":
+		return PodStateNominated
+	case pod.Status.Phase == corev1.PodPending:
+		return PodStatePending
+	}
+
+	return podStateRunning
+}
+
+// StateWeight is the fraction of the requests of pods in the state counted
+// towards their node's requests. States without a weight count fully.
+func (md *Metadata) StateWeight(state string) float64 {
+	if w, ok := md.StateWeights[state]; ok {
+		return w
+	}
+
+	return 1
+}
+
+// StuckPod is a pod still terminating well past its grace period.
+type StuckPod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Requests  int64  `json:"requests"`
+
+	// Overdue is how long past the end of its grace period the pod is.
+	Overdue time.Duration `json:"overdue"`
+
+	// Finalizers are the pod's remaining finalizers, the usual reason it
+	// is stuck.
+	Finalizers []string `json:"finalizers,omitempty"`
+}
+
+// stuckRequests is the memory requested by the stuck pods.
+func stuckRequests(stuck []*StuckPod) (total int64) {
+	for _, p := range stuck {
+		total += p.Requests
+	}
+
+	return total
+}
+
+// stuckTerminating returns the node's pods still terminating longer than
+// after past the end of their grace period at now, most overdue first.
+func stuckTerminating(pods []*corev1.Pod, pr PodResources, name corev1.ResourceName, now time.Time, after time.Duration) []*StuckPod {
+	stuck := []*StuckPod{}
+
+	for _, pod := range pods {
+		if PodState(pod) != PodStateTerminating {
+			continue
+		}
+
+		// The deletion timestamp is when the grace period ends.
+		overdue := now.Sub(pod.DeletionTimestamp.Time)
+		if overdue <= after {
+			continue
+		}
+
+		stuck = append(stuck, &StuckPod{
+			Namespace:  pod.Namespace,
+			Name:       pod.Name,
+			Requests:   pr.Requests(pod, name),
+			Overdue:    overdue,
+			Finalizers: pod.Finalizers,
+		})
+	}
+
+	sort.Slice(stuck, func(i, j int) bool {
+		return stuck[i].Overdue > stuck[j].Overdue
+	})
+
+	return stuck
+}
+
+// weightedRequests returns the requests of the report's resource of the pods
+// weighted by their state and the unweighted requests of those pending,
+// nominated and terminating.
+func weightedRequests(md *Metadata, pods []*corev1.Pod, pr PodResources) (requests, pending, nominated, terminating int64) {
+	for _, pod := range pods {
+		r := pr.Requests(pod, md.ResourceName())
+		state := PodState(pod)
+
+		switch state {
+		case PodStatePending:
+			pending += r
+		case PodStateNominated:
+			nominated += r
+		case PodStateTerminating:
+			terminating += r
+		}
+
+		requests += int64(md.StateWeight(state) * float64(r))
+	}
+
+	return requests, pending, nominated, terminating
+}
@@ -0,0 +1,82 @@
+package kubecap
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodStates(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	pod := func(name string, phase corev1.PodPhase, deleted time.Duration) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+					},
+				}},
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+
+		if deleted != 0 {
+			p.DeletionTimestamp = &metav1.Time{Time: now.Add(-deleted)}
+		}
+
+		return p
+	}
+
+	pods := []*corev1.Pod{
+		pod("running", corev1.PodRunning, 0),
+		pod("pending", corev1.PodPending, 0),
+		pod("terminating", corev1.PodRunning, time.Minute),
+		pod("stuck", corev1.PodRunning, time.Hour),
+		pod("nominated", corev1.PodPending, 0),
+	}
+
+	pods[3].Finalizers = []string{"example.com/cleanup"}
+	pods[4].Status.NominatedNodeName = "node-a"
+
+	for i, want := range []string{podStateRunning, PodStatePending, PodStateTerminating, PodStateTerminating, PodStateNominated} {
+		if got := PodState(pods[i]); got != want {
+			t.Errorf("%s: state = %s, want %s", pods[i].Name, got, want)
+		}
+	}
+
+	if got := PodState(pod("done", corev1.PodSucceeded, time.Hour)); got != PodStateFinished {
+		t.Errorf("done: state = %s", got)
+	}
+
+	md := &Metadata{}
+
+	requests, pending, nominated, terminating := weightedRequests(md, pods, PodResources{})
+	if requests != 5<<30 || pending != 1<<30 || nominated != 1<<30 || terminating != 2<<30 {
+		t.Errorf("unweighted = %d, %d, %d, %d", requests, pending, nominated, terminating)
+	}
+
+	md.StateWeights = map[string]float64{PodStatePending: 0.5, PodStateTerminating: 0}
+
+	requests, _, _, _ = weightedRequests(md, pods, PodResources{})
+	if requests != 5<<29 {
+		t.Errorf("weighted requests = %d, want %d", requests, 5<<29)
+	}
+
+	stuck := stuckTerminating(pods, PodResources{}, corev1.ResourceMemory, now, 5*time.Minute)
+	if len(stuck) != 1 || stuck[0].Name != "stuck" || stuck[0].Overdue != time.Hour || stuck[0].Requests != 1<<30 {
+		t.Fatalf("stuck = %+v", stuck)
+	}
+
+	if f := stuck[0].Finalizers; len(f) != 1 || f[0] != "example.com/cleanup" {
+		t.Errorf("finalizers = %v", f)
+	}
+
+	if pinned := stuckRequests(stuck); pinned != 1<<30 {
+		t.Errorf("pinned = %d", pinned)
+	}
+}
@@ -0,0 +1,100 @@
+package kubecap
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Weights of the pressure score's components. They add up to 100.
+const (
+	pressureUsageWeight      = 35
+	pressureRequestsWeight   = 25
+	pressureOvercommitWeight = 20
+	pressureConditionsWeight = 20
+)
+
+// limits sums the limits of the resource of the pods on the node, taking the
+// pod-level limit where set.
+func (snap *snapshot) limits(nodeName string, name corev1.ResourceName) int64 {
+	var total int64
+
+	for _, pod := range snap.nps[nodeName] {
+		if l, ok := snap.podLevel.limits(pod, name); ok {
+			total += l
+			continue
+		}
+
+		for _, container := range pod.Spec.Containers {
+			if q, ok := container.Resources.Limits[name]; ok {
+				total += ResourceValue(name, q)
+			}
+		}
+	}
+
+	return total
+}
+
+// NodeReady returns whether the node is Ready. Nodes without a Ready
+// condition (yet) are taken to be.
+func NodeReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+
+	return true
+}
+
+// activeConditions returns the node's problem conditions that are active.
+func activeConditions(node *corev1.Node) []string {
+	active := []string{}
+
+	for _, c := range node.Status.Conditions {
+		switch c.Type {
+		case corev1.NodeReady:
+			if c.Status != corev1.ConditionTrue {
+				active = append(active, "NotReady")
+			}
+		case corev1.NodeMemoryPressure, corev1.NodeDiskPressure, corev1.NodePIDPressure, corev1.NodeNetworkUnavailable:
+			if c.Status == corev1.ConditionTrue {
+				active = append(active, string(c.Type))
+			}
+		}
+	}
+
+	return active
+}
+
+// clampRatio returns a/b clamped to [0, 1].
+func clampRatio(a, b int64) float64 {
+	if b <= 0 {
+		return 1
+	}
+
+	r := float64(a) / float64(b)
+
+	switch {
+	case r < 0:
+		return 0
+	case r > 1:
+		return 1
+	default:
+		return r
+	}
+}
+
+// pressureScore blends the node's usage and requests over allocatable, its
+// limit overcommit (limits beyond allocatable, saturating at twice
+// allocatable) and whether any problem condition is active into a 0-100
+// score.
+func pressureScore(n *NodeReport) float64 {
+	score := pressureUsageWeight*clampRatio(n.Used, n.Allocatable) +
+		pressureRequestsWeight*clampRatio(n.Requests, n.Allocatable) +
+		pressureOvercommitWeight*clampRatio(n.Limits-n.Allocatable, n.Allocatable)
+
+	if len(n.Conditions) > 0 {
+		score += pressureConditionsWeight
+	}
+
+	return score
+}
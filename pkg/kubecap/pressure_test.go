@@ -0,0 +1,53 @@
+package kubecap
+
+import (
+	"math"
+	"testing"
+)
+
+func TestClampRatio(t *testing.T) {
+	for _, tc := range []struct {
+		a, b int64
+		want float64
+	}{
+		{1, 4, 0.25},
+		{-1, 4, 0},
+		{8, 4, 1},
+		// Nothing allocatable is as full as it gets.
+		{0, 0, 1},
+		{1, -1, 1},
+	} {
+		if got := clampRatio(tc.a, tc.b); got != tc.want {
+			t.Errorf("clampRatio(%d, %d) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestPressureScore(t *testing.T) {
+	const gi = 1 << 30
+
+	for _, tc := range []struct {
+		name string
+		n    *NodeReport
+		want float64
+	}{
+		{"idle", &NodeReport{Allocatable: 8 * gi}, 0},
+		// Usage weighs 35, requests 25.
+		{"half used, fully requested", &NodeReport{Allocatable: 8 * gi, Used: 4 * gi, Requests: 8 * gi}, 17.5 + 25},
+		{"over requested", &NodeReport{Allocatable: 8 * gi, Requests: 16 * gi}, 25},
+		// Limits up to allocatable aren't overcommitted; beyond it the 20
+		// are reached at twice allocatable and saturate there.
+		{"limits at allocatable", &NodeReport{Allocatable: 8 * gi, Limits: 8 * gi}, 0},
+		{"limits 1.5x allocatable", &NodeReport{Allocatable: 8 * gi, Limits: 12 * gi}, 10},
+		{"limits 2x allocatable", &NodeReport{Allocatable: 8 * gi, Limits: 16 * gi}, 20},
+		{"limits 4x allocatable", &NodeReport{Allocatable: 8 * gi, Limits: 32 * gi}, 20},
+		// An active condition adds 20, however many.
+		{"memory pressure", &NodeReport{Allocatable: 8 * gi, Conditions: []string{"MemoryPressure"}}, 20},
+		{"conditions", &NodeReport{Allocatable: 8 * gi, Conditions: []string{"MemoryPressure", "DiskPressure"}}, 20},
+		{"everything", &NodeReport{Allocatable: 8 * gi, Used: 9 * gi, Requests: 9 * gi, Limits: 20 * gi, Conditions: []string{"PIDPressure"}}, 100},
+	} {
+		if got := pressureScore(tc.n); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: score = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
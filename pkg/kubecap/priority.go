@@ -0,0 +1,80 @@
+package kubecap
+
+import (
+	"sort"
+)
+
+// NoPriorityClass names the pods without a PriorityClass.
+const NoPriorityClass = "(none)"
+
+// PriorityClassRequests is the memory requested by the pods of a
+// PriorityClass.
+type PriorityClassRequests struct {
+	Name     string `json:"name"`
+	Priority int32  `json:"priority"`
+	Pods     int64  `json:"pods"`
+	Requests int64  `json:"requests"`
+}
+
+// priorityClassRequests totals the pods' requests by PriorityClass, highest
+// priority first.
+func priorityClassRequests(pods []*PodReport) []*PriorityClassRequests {
+	byClass := map[string]*PriorityClassRequests{}
+	classes := []*PriorityClassRequests{}
+
+	for _, p := range pods {
+		name := p.PriorityClass
+		if name == "" {
+			name = NoPriorityClass
+		}
+
+		pc, ok := byClass[name]
+		if !ok {
+			pc = &PriorityClassRequests{Name: name, Priority: p.Priority}
+			byClass[name] = pc
+			classes = append(classes, pc)
+		}
+
+		pc.Pods++
+		pc.Requests += p.Requests
+	}
+
+	sortPriorityClasses(classes)
+
+	return classes
+}
+
+// SumPriorityClasses totals the nodes' requests by PriorityClass, highest
+// priority first.
+func SumPriorityClasses(nodes []*NodeReport) []*PriorityClassRequests {
+	byClass := map[string]*PriorityClassRequests{}
+	classes := []*PriorityClassRequests{}
+
+	for _, n := range nodes {
+		for _, npc := range n.PriorityClasses {
+			pc, ok := byClass[npc.Name]
+			if !ok {
+				pc = &PriorityClassRequests{Name: npc.Name, Priority: npc.Priority}
+				byClass[npc.Name] = pc
+				classes = append(classes, pc)
+			}
+
+			pc.Pods += npc.Pods
+			pc.Requests += npc.Requests
+		}
+	}
+
+	sortPriorityClasses(classes)
+
+	return classes
+}
+
+func sortPriorityClasses(classes []*PriorityClassRequests) {
+	sort.Slice(classes, func(i, j int) bool {
+		if classes[i].Priority != classes[j].Priority {
+			return classes[i].Priority > classes[j].Priority
+		}
+
+		return classes[i].Name < classes[j].Name
+	})
+}
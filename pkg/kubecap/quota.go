@@ -0,0 +1,133 @@
+package kubecap
+
+import (
+	"context"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// quotaResources returns the ResourceQuota resources limiting the resource.
+// The what-if workload's limits are taken to be its requests.
+func quotaResources(name corev1.ResourceName) []corev1.ResourceName {
+	return []corev1.ResourceName{
+		corev1.ResourceName("requests." + name),
+		name,
+		corev1.ResourceName("limits." + name),
+	}
+}
+
+// QuotaReport is the headroom of a resource left by a namespace's
+// ResourceQuotas.
+type QuotaReport struct {
+	Namespace string         `json:"namespace"`
+	Quotas    []*QuotaAmount `json:"quotas"`
+
+	// Headroom is the least left by any quota, or -1 when no quota limits
+	// the resource.
+	Headroom int64 `json:"headroom"`
+
+	// Fits is whether the additional amount fits in the headroom.
+	Fits bool `json:"fits"`
+
+	// Blocker is what keeps the additional amount from being scheduled in
+	// the namespace (see quotaBlocker). It is set once every node has been
+	// analyzed.
+	Blocker string `json:"blocker,omitempty"`
+}
+
+// QuotaAmount is the amount of a resource limited by a ResourceQuota. Amounts
+// are in millicores for CPU and bytes otherwise.
+type QuotaAmount struct {
+	Name     string `json:"name"`
+	Resource string `json:"resource"`
+	Hard     int64  `json:"hard"`
+	Used     int64  `json:"used"`
+}
+
+// namespaceQuota reports the headroom of the resource the namespace's
+// ResourceQuotas leave and whether additional fits in it. Scoped quotas (scopes or a scope
+// selector, e.g. by PriorityClass or QoS class) are left out since whether
+// they apply depends on the workload, which isn't known.
+func namespaceQuota(ctx context.Context, kcs kubernetes.Interface, namespace string, resource corev1.ResourceName, additional int64) (r *QuotaReport, err error) {
+	ctx, span := tracer.Start(ctx, "list resource quotas", trace.WithAttributes(
+		attribute.String("k8s.namespace.name", namespace),
+	))
+	defer func() { endSpan(span, err) }()
+
+	quotaList, err := kcs.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	r = &QuotaReport{
+		Namespace: namespace,
+		Headroom:  -1,
+	}
+
+	for i := range quotaList.Items {
+		quota := &quotaList.Items[i]
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+
+		for _, name := range quotaResources(resource) {
+			hard, ok := quota.Status.Hard[name]
+			if !ok {
+				continue
+			}
+
+			used := quota.Status.Used[name]
+
+			qm := &QuotaAmount{
+				Name:     quota.Name,
+				Resource: string(name),
+				Hard:     ResourceValue(resource, hard),
+				Used:     ResourceValue(resource, used),
+			}
+			r.Quotas = append(r.Quotas, qm)
+
+			headroom := qm.Hard - qm.Used
+			if r.Headroom < 0 || headroom < r.Headroom {
+				r.Headroom = headroom
+			}
+		}
+	}
+
+	sort.Slice(r.Quotas, func(i, j int) bool {
+		if r.Quotas[i].Name != r.Quotas[j].Name {
+			return r.Quotas[i].Name < r.Quotas[j].Name
+		}
+
+		return r.Quotas[i].Resource < r.Quotas[j].Resource
+	})
+
+	r.Fits = len(r.Quotas) == 0 || additional <= r.Headroom
+
+	return r, nil
+}
+
+// quotaBlocker names what keeps the additional amount from being scheduled in
+// the namespace (see capacityBlocker).
+func quotaBlocker(q *QuotaReport, nodeFits bool) string {
+	return capacityBlocker(nodeFits, q.Fits)
+}
+
+// capacityBlocker names what keeps memory from being scheduled: node capacity
+// (no room on the nodes), quota, both or nothing.
+func capacityBlocker(nodeFits, quotaFits bool) string {
+	switch {
+	case !nodeFits && !quotaFits:
+		return "node capacity and quota"
+	case !nodeFits:
+		return "node capacity"
+	case !quotaFits:
+		return "quota"
+	}
+
+	return "none"
+}
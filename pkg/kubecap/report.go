@@ -0,0 +1,1685 @@
+package kubecap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// Metadata describes how and when a report was collected so that archived
+// reports are self-describing.
+type Metadata struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Context       string    `json:"context,omitempty"`
+	Cluster       string    `json:"cluster,omitempty"`
+	ServerVersion string    `json:"serverVersion"`
+
+	// Resource is the resource the report is about: memory (the default),
+	// cpu or ephemeral-storage. Amounts are in millicores for CPU and bytes
+	// otherwise.
+	Resource string `json:"resource,omitempty"`
+
+	// Additional is the what-if amount checked for on each node and
+	// AdditionalInput is the amount as given by the user.
+	Additional      int64  `json:"additional"`
+	AdditionalInput string `json:"additionalInput"`
+
+	// AdditionalPercent, when the additional amount is given as a
+	// percentage, is the percentage of each node's allocatable amount
+	// checked for on it; Additional is then zero.
+	AdditionalPercent float64 `json:"additionalPercent,omitempty"`
+
+	// NodeGroupLabel is the node label nodes are grouped by. When empty the
+	// well-known cloud provider node pool labels are used.
+	NodeGroupLabel string `json:"nodeGroupLabel,omitempty"`
+
+	// TaintPoolKeys are the taint keys marking dedicated pools (e.g.
+	// dedicated), whose nodes are totalled separately since only tolerating
+	// workloads can use their capacity.
+	TaintPoolKeys []string `json:"taintPoolKeys,omitempty"`
+
+	// UsageSource is where usage was read from: the metrics API (the default)
+	// or the kubelets' cAdvisor endpoints.
+	UsageSource string `json:"usageSource,omitempty"`
+
+	// NUMA is whether per-NUMA node memory was collected for nodes using the
+	// Static Memory Manager policy.
+	NUMA bool `json:"numa,omitempty"`
+
+	// CPUManager is whether exclusive CPU use was collected for nodes using
+	// the static CPU Manager policy.
+	CPUManager bool `json:"cpuManager,omitempty"`
+
+	// PSI is whether pressure stall information was collected.
+	PSI bool `json:"psi,omitempty"`
+
+	// EvictBurstableOnly limits eviction candidates to Burstable pods and
+	// EvictIncludeBestEffort adds BestEffort pods (ahead of them).
+	EvictBurstableOnly     bool `json:"evictBurstableOnly,omitempty"`
+	EvictIncludeBestEffort bool `json:"evictIncludeBestEffort,omitempty"`
+
+	// SortBy is the order nodes are reported in: name (the default) or
+	// pressure (highest first).
+	SortBy string `json:"sortBy,omitempty"`
+
+	// CheckReserved is whether system reservations were compared with
+	// actual system usage.
+	CheckReserved bool `json:"checkReserved,omitempty"`
+
+	// DRA is whether Dynamic Resource Allocation devices were collected and
+	// AdditionalDevices the devices (count by DeviceClass) checked for on
+	// each node in addition to the additional amount of memory.
+	DRA               bool             `json:"dra,omitempty"`
+	AdditionalDevices map[string]int64 `json:"additionalDevices,omitempty"`
+
+	// VolumeAttach is whether CSI volume attachments and attach limits were
+	// collected.
+	VolumeAttach bool `json:"volumeAttach,omitempty"`
+
+	// EvictionStrategy is how pods are ranked for eviction (see
+	// EvictionStrategies), DefaultEvictionStrategy when empty.
+	EvictionStrategy string `json:"evictionStrategy,omitempty"`
+
+	// EvictionPolicy protects pods from ever being suggested for eviction.
+	EvictionPolicy *EvictionPolicy `json:"evictionPolicy,omitempty"`
+
+	// Cordons is whether cordoned nodes were reported with how long they
+	// have been cordoned and the capacity they hold. CordonTracker remembers
+	// when a watch first saw each node cordoned.
+	Cordons       bool         `json:"cordons,omitempty"`
+	CordonTracker *NodeCordons `json:"-"`
+
+	// AllocatableChanges is how long the changes of the nodes' allocatable
+	// and capacity amounts a watch saw are reported, kept by
+	// AllocatableTracker.
+	AllocatableChanges time.Duration    `json:"allocatableChanges,omitempty"`
+	AllocatableTracker *NodeAllocatable `json:"-"`
+
+	// SLOs are the capacity objectives checked, SLOStatus each one's status
+	// once the nodes are analyzed. SLOTracker tracks their compliance across
+	// a watch.
+	SLOs       []*SLO       `json:"slos,omitempty"`
+	SLOStatus  []*SLOStatus `json:"sloStatus,omitempty"`
+	SLOTracker *SLOTracker  `json:"-"`
+
+	// Reservations are the capacity reservations taken from the nodes'
+	// schedulable amounts, ReservationStatus where each one's replicas were
+	// placed once the nodes are listed.
+	Reservations      []*Reservation       `json:"reservations,omitempty"`
+	ReservationStatus []*ReservationReport `json:"reservationStatus,omitempty"`
+
+	// Standby is the warm standby capacity required per priority tier,
+	// StandbyStatus whether each one exists once the nodes are listed.
+	Standby       []*Standby       `json:"standby,omitempty"`
+	StandbyStatus []*StandbyReport `json:"standbyStatus,omitempty"`
+
+	// Budget bounds the report's API calls and runtime, Degraded the
+	// optional analyses skipped to stay within it.
+	Budget   *Budget  `json:"-"`
+	Degraded []string `json:"degraded,omitempty"`
+
+	// Warnings are the problems the report worked around, such as an
+	// unreachable kubelet leaving its node's usage out, for the caller to
+	// show.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// PodInterval is how long a watch reuses the pods and their metrics it
+	// listed, kept by ListCache, so they are listed less often than the
+	// nodes are reported.
+	PodInterval time.Duration `json:"podInterval,omitempty"`
+	ListCache   *ListCache    `json:"-"`
+
+	// Enrichers are the names of the node enrichers (see NodeEnrichers)
+	// adding provider-specific attributes to the nodes, run in order.
+	Enrichers []string `json:"enrichers,omitempty"`
+
+	// GPUMetricsURL is the DCGM exporter's metrics or the Prometheus GPU
+	// utilization was read from, joined with the requests of GPUResource
+	// (DefaultGPUResource when empty).
+	GPUMetricsURL string `json:"gpuMetricsURL,omitempty"`
+	GPUResource   string `json:"gpuResource,omitempty"`
+
+	// Namespace is the namespace the additional amount is checked against
+	// the ResourceQuotas of, given in Quota.
+	Namespace string       `json:"namespace,omitempty"`
+	Quota     *QuotaReport `json:"quota,omitempty"`
+
+	// Namespaces and Selector restrict the eviction candidates to the pods in
+	// those namespaces and matching the label selector. Pods left out still
+	// count towards their node's requests. NodeSelector restricts the nodes
+	// reported on, and NodeFilter further by their age and kubelet version.
+	Namespaces   []string    `json:"namespaces,omitempty"`
+	Selector     string      `json:"selector,omitempty"`
+	NodeSelector string      `json:"nodeSelector,omitempty"`
+	NodeFilter   *NodeFilter `json:"nodeFilter,omitempty"`
+
+	// In scopes the additional amount to the nodes it matches (see
+	// ParseScope), e.g. zone=us-east-1a or nodegroup=general, since new
+	// workloads are usually constrained to some of the nodes. Nodes outside
+	// it are reported but fail the headroom check.
+	In string `json:"in,omitempty"`
+
+	// Columns are the user defined columns computed for each node.
+	Columns []*Column `json:"columns,omitempty"`
+
+	// Verdicts are the user defined rules deciding whether each node is ok
+	// and, in ClusterOk, whether the cluster has sufficient headroom, if any.
+	Verdicts  *VerdictRules `json:"verdicts,omitempty"`
+	ClusterOk *bool         `json:"clusterOk,omitempty"`
+
+	// ThresholdProfiles are the free margins required of the nodes matching
+	// them, if any, instead of none.
+	ThresholdProfiles *ThresholdProfiles `json:"thresholdProfiles,omitempty"`
+
+	// PriorityClasses is whether requests were broken down by PriorityClass.
+	PriorityClasses bool `json:"priorityClasses,omitempty"`
+
+	// KueueBacklog is whether the backlog of Kueue's ClusterQueues was
+	// collected, given in Kueue.
+	KueueBacklog bool         `json:"kueueBacklog,omitempty"`
+	Kueue        *KueueReport `json:"kueue,omitempty"`
+
+	// JobBacklogSelector selects the Jobs whose queued backlog is reported
+	// in JobBacklog, if any.
+	JobBacklogSelector string            `json:"jobBacklogSelector,omitempty"`
+	JobBacklog         *JobBacklogReport `json:"jobBacklog,omitempty"`
+
+	// AutoscalerStatus is the Cluster Autoscaler status ConfigMap
+	// (namespace/name) read into Autoscaler, if any.
+	AutoscalerStatus string            `json:"autoscalerStatus,omitempty"`
+	Autoscaler       *AutoscalerReport `json:"autoscaler,omitempty"`
+
+	// Coverage is how many pods and containers have memory and CPU requests
+	// set. It is set once pods have been listed.
+	Coverage *CoverageReport `json:"coverage,omitempty"`
+
+	// Leaderboard is how many of the most over-provisioned pods and
+	// workloads to report, if any.
+	Leaderboard int `json:"leaderboard,omitempty"`
+
+	// Shapes is whether to report each node group's pod shape histogram.
+	Shapes bool `json:"shapes,omitempty"`
+
+	// CustomReports are the user defined reports on the nodes, if any.
+	CustomReports []*CustomReport `json:"customReports,omitempty"`
+
+	// RightSizes are the candidate node sizes (allocatable amounts) each
+	// node group's pods are projected onto, if any.
+	RightSizes []int64 `json:"rightSizes,omitempty"`
+
+	// UsageSmoothing is the weight of the newest sample in the exponential
+	// moving average of node usage kept by Smoother across the reports of a
+	// watch, if smoothed.
+	UsageSmoothing float64        `json:"usageSmoothing,omitempty"`
+	Smoother       *UsageSmoother `json:"-"`
+
+	// Churn is the nodes added and removed since the previous report of a
+	// watch, tracked by ChurnTracker. It is nil outside of watches and on
+	// their first report.
+	Churn        *ChurnReport `json:"churn,omitempty"`
+	ChurnTracker *NodeChurn   `json:"-"`
+
+	// PodChurnWindow is how far back each node's pod churn is counted, if
+	// at all.
+	PodChurnWindow time.Duration `json:"podChurnWindow,omitempty"`
+
+	// IncludeNotReady is whether NotReady nodes count towards the
+	// schedulable totals and may pass the headroom check.
+	IncludeNotReady bool `json:"includeNotReady,omitempty"`
+
+	// ListUnmatched is whether to list the running pods without pod metrics
+	// rather than only count them.
+	ListUnmatched bool `json:"listUnmatched,omitempty"`
+
+	// CostCenters maps pods to cost centers, if given, adding the cost
+	// center dimension to the aggregated outputs.
+	CostCenters *CostCenters `json:"-"`
+
+	// StateWeights are the fractions of the requests of Pending and
+	// Terminating pods counted towards their node's requests (fully when
+	// unset) and StuckAfter how long past its grace period a terminating pod
+	// is flagged as stuck.
+	StateWeights map[string]float64 `json:"stateWeights,omitempty"`
+	StuckAfter   time.Duration      `json:"stuckAfter,omitempty"`
+
+	// Round, when set, rounds the reported amounts to the nearest multiple
+	// of it and drops pod-level detail, for reports shared outside the
+	// platform team.
+	Round int64 `json:"round,omitempty"`
+
+	// Scheduler is the kube-scheduler's verdict on a pod of the additional
+	// amount when a kube-scheduler-simulator is used. It replaces
+	// kubecap's own estimate of whether each node fits it.
+	Scheduler *SchedulerFit `json:"scheduler,omitempty"`
+}
+
+// warnf records a warning in the report's metadata.
+func (md *Metadata) warnf(format string, args ...interface{}) {
+	md.Warnings = append(md.Warnings, fmt.Sprintf(format, args...))
+}
+
+// nodeGroupLabels are the well-known labels identifying the node group (pool)
+// a node belongs to.
+var nodeGroupLabels = []string{
+	"eks.amazonaws.com/nodegroup",
+	"cloud.google.com/gke-nodepool",
+	"kubernetes.azure.com/agentpool",
+	"karpenter.sh/nodepool",
+	"karpenter.sh/provisioner-name",
+	"node.kubernetes.io/instance-type",
+}
+
+// nodeGroup returns the node group the node belongs to using label if given
+// and the well-known node group labels otherwise.
+func nodeGroup(node *corev1.Node, label string) string {
+	if label != "" {
+		return node.Labels[label]
+	}
+
+	for _, l := range nodeGroupLabels {
+		if group, ok := node.Labels[l]; ok {
+			return group
+		}
+	}
+
+	return ""
+}
+
+// NodeReport is the memory summary for a single node. All amounts are in
+// bytes.
+type NodeReport struct {
+	// Cluster identifies the cluster (by kubeconfig context) the node is in so
+	// that rows merged from several clusters remain attributable.
+	Cluster string `json:"cluster"`
+
+	Name  string `json:"name"`
+	Group string `json:"group,omitempty"`
+	Zone  string `json:"zone,omitempty"`
+
+	// TaintPool is the node's pool taint (key=value:effect) when taint
+	// pools are reported and it has one.
+	TaintPool string `json:"taintPool,omitempty"`
+
+	// KubeletVersion, Created and InstanceType describe the node's
+	// generation; capacity issues often correlate with them.
+	KubeletVersion string    `json:"kubeletVersion,omitempty"`
+	Created        time.Time `json:"created"`
+	InstanceType   string    `json:"instanceType,omitempty"`
+
+	Allocatable int64 `json:"allocatable"`
+	Used        int64 `json:"used"`
+
+	// UsedRaw is the node's usage sampled when Used is smoothed.
+	UsedRaw int64 `json:"usedRaw,omitempty"`
+
+	Free        int64   `json:"free"`
+	Requests    int64   `json:"requests"`
+	Efficiency  float64 `json:"efficiency"`
+	Schedulable int64   `json:"schedulable"`
+
+	// FreeWithAdditional and SchedulableWithAdditional are Free and
+	// Schedulable less the additional amount being checked for.
+	FreeWithAdditional        int64 `json:"freeWithAdditional"`
+	SchedulableWithAdditional int64 `json:"schedulableWithAdditional"`
+
+	// Additional is the additional amount checked for on the node: the same
+	// on every node unless given as a percentage of allocatable.
+	Additional int64 `json:"additional"`
+
+	// Reservations are the capacity reservations with replicas placed on the
+	// node and Reserving the amount they take from Schedulable.
+	Reservations []string `json:"reservations,omitempty"`
+	Reserving    int64    `json:"reserving,omitempty"`
+
+	Ok bool `json:"ok"`
+
+	// Profile is the node's threshold profile, if any, and Margin the
+	// amount it requires the node to keep free and schedulable after the
+	// additional amount to be Ok.
+	Profile string `json:"profile,omitempty"`
+	Margin  int64  `json:"margin,omitempty"`
+
+	// Memory is the node's memory broken down further. It is only available
+	// when usage is scraped from cAdvisor.
+	Memory *MemoryStats `json:"memory,omitempty"`
+
+	// NUMA is the node's memory per NUMA node. It is only collected with
+	// --numa and only for nodes using the Static Memory Manager policy.
+	NUMA *NUMAReport `json:"numa,omitempty"`
+
+	// CPUManager is the node's exclusive CPU use. It is only collected with
+	// --cpu-manager and only for nodes using the static CPU Manager policy.
+	CPUManager *CPUManagerReport `json:"cpuManager,omitempty"`
+
+	// PSI is the node's pressure stall information. It is only collected
+	// with --psi and only available where the kubelet exposes it.
+	PSI *PSIReport `json:"psi,omitempty"`
+
+	// PendingRequests, NominatedRequests and TerminatingRequests are the
+	// memory requests of the node's pods bound but not yet running, of the
+	// unbound pods preemption nominated the node for and of those being
+	// deleted, before weighting. StuckTerminating are the pods terminating
+	// long past their grace period and StuckRequests the memory they pin.
+	PendingRequests     int64       `json:"pendingRequests"`
+	NominatedRequests   int64       `json:"nominatedRequests"`
+	TerminatingRequests int64       `json:"terminatingRequests"`
+	StuckTerminating    []*StuckPod `json:"stuckTerminating,omitempty"`
+	StuckRequests       int64       `json:"stuckRequests"`
+
+	// Unmatched is the number of the node's running pods without pod
+	// metrics, listed in UnmatchedPods (namespace/name) with
+	// --list-unmatched. Their usage is missing from Used and Efficiency.
+	Unmatched     int64    `json:"unmatched"`
+	UnmatchedPods []string `json:"unmatchedPods,omitempty"`
+
+	// Overcommitted is set when the node's effective requests exceed its
+	// allocatable amount, listing the pods contributing to the excess.
+	Overcommitted *OvercommitReport `json:"overcommitted,omitempty"`
+
+	// Limits are the memory limits of the pods on the node. Containers
+	// without a limit don't count towards it.
+	Limits int64 `json:"limits"`
+
+	// Conditions are the node's active problem conditions (MemoryPressure,
+	// DiskPressure, PIDPressure, NetworkUnavailable or NotReady).
+	Conditions []string `json:"conditions,omitempty"`
+
+	// NotReady is set when the node isn't Ready. Excluded is set when it is
+	// therefore left out of the schedulable totals and failed in the
+	// headroom check.
+	NotReady bool `json:"notReady,omitempty"`
+	Excluded bool `json:"excluded,omitempty"`
+
+	// OutOfScope is set when the node is outside the scope (In) of the
+	// additional amount and therefore failed in the headroom check.
+	OutOfScope bool `json:"outOfScope,omitempty"`
+
+	// PodCount is the number of pods running or starting on the node and
+	// PodCapacity the number it allows.
+	PodCount    int   `json:"podCount"`
+	PodCapacity int64 `json:"podCapacity"`
+
+	// PodChurn is the node's recent pod churn. It is only collected with
+	// --pod-churn.
+	PodChurn *PodChurnReport `json:"podChurn,omitempty"`
+
+	// Cordon is set on cordoned nodes when reported with --cordons.
+	Cordon *CordonReport `json:"cordon,omitempty"`
+
+	// AllocatableChanges are the changes of the node's allocatable and
+	// capacity amounts a watch saw recently, oldest first.
+	AllocatableChanges []*AllocatableChange `json:"allocatableChanges,omitempty"`
+
+	// Attributes are the provider-specific attributes the enrichers added,
+	// e.g. lifecycle and hourlyPrice.
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	// Pressure is a 0-100 score blending usage, requests and limits
+	// against allocatable and the active conditions.
+	Pressure float64 `json:"pressure"`
+
+	// LimitRisks are the node's containers using (nearly) all of their
+	// memory limit.
+	LimitRisks []*LimitRiskContainer `json:"limitRisks,omitempty"`
+
+	// AllocatableAnomaly is set when the node's allocatable memory deviates
+	// from that of its peers (nodes in the same group with the same instance
+	// type), e.g. from mis-set reservations.
+	AllocatableAnomaly *AllocatableAnomaly `json:"allocatableAnomaly,omitempty"`
+
+	// Reserved compares the node's system reservations with actual system
+	// usage. It is only collected with --check-reserved.
+	Reserved *ReservedReport `json:"reserved,omitempty"`
+
+	// Devices are the node's Dynamic Resource Allocation devices. They are
+	// only collected with --dra.
+	Devices []*DeviceReport `json:"devices,omitempty"`
+
+	// Volumes are the node's CSI volume attachments by driver. They are
+	// only collected with --volume-attach.
+	Volumes []*VolumeAttachReport `json:"volumes,omitempty"`
+
+	// GPU is the node's GPUs and their utilization. It is only collected
+	// with --gpu-metrics-url.
+	GPU *GPUReport `json:"gpu,omitempty"`
+
+	// PriorityClasses are the node's requests by PriorityClass, highest
+	// priority first. They are only collected with --priority-classes.
+	PriorityClasses []*PriorityClassRequests `json:"priorityClasses,omitempty"`
+
+	// SchedulerReasons are why the kube-scheduler filtered the node out for
+	// a pod of the additional amount, when a kube-scheduler-simulator is
+	// used.
+	SchedulerReasons []string `json:"schedulerReasons,omitempty"`
+
+	// Columns are the values of the user defined columns by name.
+	Columns map[string]float64 `json:"columns,omitempty"`
+
+	// Pods are the pods scheduled on the node. They are left out of the JSON
+	// records to keep them to a line per node.
+	Pods []*PodReport `json:"-"`
+}
+
+// PodReport is the memory summary for a single pod.
+type PodReport struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	// WorkloadKind and Workload identify the controller owning the pod, if
+	// any. Pods owned by a Deployment's ReplicaSet are attributed to the
+	// Deployment.
+	WorkloadKind string `json:"workloadKind,omitempty"`
+	Workload     string `json:"workload,omitempty"`
+
+	Requests int64 `json:"requests"`
+	Used     int64 `json:"used"`
+
+	// State is the pod's state: Running, Pending, Terminating or Finished.
+	State string `json:"state"`
+
+	// Efficiency is Used/Requests, or zero without requests.
+	Efficiency float64 `json:"efficiency"`
+
+	// PriorityClass is the pod's PriorityClass, if any, and Priority its
+	// resolved priority.
+	PriorityClass string `json:"priorityClass,omitempty"`
+	Priority      int32  `json:"priority"`
+
+	// CostCenter is the pod's cost center when a mapping is given.
+	CostCenter string `json:"costCenter,omitempty"`
+
+	// Memory is the pod's memory broken down further. It is only available
+	// when usage is scraped from cAdvisor.
+	Memory *MemoryStats `json:"memory,omitempty"`
+
+	// GPU is the pod's GPUs and their utilization, when collected and the
+	// pod requests any.
+	GPU *PodGPUReport `json:"gpu,omitempty"`
+}
+
+// PodWorkload returns the kind and name of the controller owning the pod.
+func PodWorkload(pod *corev1.Pod) (kind, name string) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", ""
+	}
+
+	// ReplicaSets created by a Deployment are named after it with the pod
+	// template hash appended.
+	if owner.Kind == "ReplicaSet" {
+		if hash, ok := pod.Labels["pod-template-hash"]; ok && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	}
+
+	return owner.Kind, owner.Name
+}
+
+// EvictableContainer is a container using more memory than it requested on a
+// node without enough room for the additional amount. Containers of DaemonSet
+// pods are never evictable.
+type EvictableContainer struct {
+	Cluster   string `json:"cluster"`
+	Node      string `json:"node"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	QOSClass  string `json:"qosClass,omitempty"`
+	Priority  int32  `json:"priority"`
+	Requests  int64  `json:"requests"`
+	Used      int64  `json:"used"`
+	Limits    int64  `json:"limits"`
+
+	// Restarts is how often the container restarted and PodCreated when its
+	// pod was created, which eviction ranking strategies weigh.
+	Restarts   int32     `json:"restarts"`
+	PodCreated time.Time `json:"podCreated"`
+
+	// Memory is the container's usage broken down, when read from
+	// cAdvisor.
+	Memory *MemoryStats `json:"memory,omitempty"`
+
+	// CPU is the container's CPU when the report is about another resource
+	// and the pod metrics have its CPU usage.
+	CPU *ContainerCPU `json:"cpu,omitempty"`
+}
+
+// evictable returns the node's containers using more memory than they
+// requested. With md.EvictBurstableOnly only Burstable pods' containers (and
+// BestEffort pods' with md.EvictIncludeBestEffort) are considered, ordered as
+// the kubelet ranks them for eviction: all of them use more than they
+// requested, so by pod priority (lowest first), then by usage over requests.
+func (snap *snapshot) evictable(md *Metadata, node *corev1.Node) []*EvictableContainer {
+	evictable := []*EvictableContainer{}
+	rn := md.ResourceName()
+
+	// Find the containers that are over their requests...
+	for _, pod := range snap.nps[node.Name] {
+		// DaemonSet pods are recreated on the node straight away so
+		// evicting them frees nothing.
+		if kind, _ := PodWorkload(pod); kind == "DaemonSet" {
+			continue
+		}
+
+		if !snap.selected(pod) || md.EvictionPolicy.Protects(pod) {
+			continue
+		}
+
+		var priority int32
+		if pod.Spec.Priority != nil {
+			priority = *pod.Spec.Priority
+		}
+
+		qos := pod.Status.QOSClass
+		bestEffort := qos == corev1.PodQOSBestEffort && md.EvictIncludeBestEffort
+
+		if md.EvictBurstableOnly && qos != corev1.PodQOSBurstable && !bestEffort {
+			continue
+		}
+
+		for _, container := range pod.Spec.Containers {
+			req := ResourceValue(rn, container.Resources.Requests[rn])
+			lim := ResourceValue(rn, container.Resources.Limits[rn])
+
+			// Containers without a limit of their own are capped by the
+			// pod-level limit, if any.
+			if lim == 0 {
+				if l, ok := snap.podLevel.limits(pod, rn); ok {
+					lim = l
+				}
+			}
+
+			if req != 0 || bestEffort {
+				// Don't worry about containers that have requests equal to limits.
+				if !bestEffort && req >= lim {
+					continue
+				}
+
+				used, ok := snap.containerUsage[pod.Namespace+"/"+pod.Name+"/"+container.Name]
+				if ok && req < used {
+					evictable = append(evictable, &EvictableContainer{
+						Cluster:    md.Context,
+						Node:       node.Name,
+						Namespace:  pod.Namespace,
+						Pod:        pod.Name,
+						Container:  container.Name,
+						QOSClass:   string(qos),
+						Priority:   priority,
+						Requests:   req,
+						Used:       used,
+						Limits:     lim,
+						Restarts:   containerRestarts(pod, container.Name),
+						PodCreated: pod.CreationTimestamp.Time,
+						Memory:     snap.memoryStats(md, pod, container.Name),
+						CPU:        snap.containerCPU(md, pod, &container),
+					})
+				}
+			}
+		}
+	}
+
+	if md.EvictBurstableOnly {
+		sort.SliceStable(evictable, func(i, j int) bool {
+			if evictable[i].Priority != evictable[j].Priority {
+				return evictable[i].Priority < evictable[j].Priority
+			}
+
+			return evictable[i].Used-evictable[i].Requests > evictable[j].Used-evictable[j].Requests
+		})
+	}
+
+	return evictable
+}
+
+// limitRiskRatio is the share of its memory limit a container may use before
+// it is considered at risk of being OOM killed.
+const limitRiskRatio = 0.9
+
+// LimitRiskContainer is a container using (nearly) all of its memory limit.
+// Unlike eviction candidates these free memory violently: the kernel OOM
+// kills them when they reach the limit.
+type LimitRiskContainer struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+
+	// Container is empty when the pod as a whole is near its pod-level
+	// limit.
+	Container string `json:"container,omitempty"`
+
+	Used   int64 `json:"used"`
+	Limits int64 `json:"limits"`
+
+	// Ratio is Used over Limits.
+	Ratio float64 `json:"ratio"`
+
+	// Memory is the container's (or pod's) usage broken down, when read
+	// from cAdvisor.
+	Memory *MemoryStats `json:"memory,omitempty"`
+
+	// CPU is the container's CPU, when the pod metrics have its usage. It
+	// is left out for pods near their pod-level limit.
+	CPU *ContainerCPU `json:"cpu,omitempty"`
+}
+
+// containerRestarts returns how often the pod's container restarted.
+func containerRestarts(pod *corev1.Pod, container string) int32 {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == container {
+			return cs.RestartCount
+		}
+	}
+
+	return 0
+}
+
+// memoryStats returns the container's memory stats when the report is on
+// memory, nil otherwise.
+func (snap *snapshot) memoryStats(md *Metadata, pod *corev1.Pod, container string) *MemoryStats {
+	if md.ResourceName() != corev1.ResourceMemory {
+		return nil
+	}
+
+	return snap.usage.containerStats(pod, container)
+}
+
+// unmatchedPods returns the node's running pods without pod metrics (e.g.
+// right after restarting), whose usage is missing from the node's figures.
+func (snap *snapshot) unmatchedPods(nodeName string) []string {
+	unmatched := []string{}
+
+	for _, pod := range snap.nps[nodeName] {
+		if PodState(pod) != podStateRunning || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		if _, ok := snap.podUsage[pod.Namespace+"/"+pod.Name]; !ok {
+			unmatched = append(unmatched, pod.Namespace+"/"+pod.Name)
+		}
+	}
+
+	sort.Strings(unmatched)
+
+	return unmatched
+}
+
+// limitRisks returns the node's containers (and pods with a pod-level limit)
+// using at least limitRiskRatio of their memory limit.
+func (snap *snapshot) limitRisks(md *Metadata, nodeName string) []*LimitRiskContainer {
+	risks := []*LimitRiskContainer{}
+
+	for _, pod := range snap.nps[nodeName] {
+		// A pod-level limit caps the pod's containers together, including
+		// those without a limit of their own.
+		if limits, ok := snap.podLevel.memoryLimits(pod); ok && limits > 0 {
+			used, ok := snap.podUsage[pod.Namespace+"/"+pod.Name]
+			if ratio := float64(used) / float64(limits); ok && ratio >= limitRiskRatio {
+				risks = append(risks, &LimitRiskContainer{
+					Namespace: pod.Namespace,
+					Pod:       pod.Name,
+					Used:      used,
+					Limits:    limits,
+					Ratio:     ratio,
+					Memory:    snap.usage.podStats(pod),
+				})
+			}
+		}
+
+		for _, container := range pod.Spec.Containers {
+			limits := container.Resources.Limits.Memory().Value()
+			if limits <= 0 {
+				continue
+			}
+
+			used, ok := snap.containerUsage[pod.Namespace+"/"+pod.Name+"/"+container.Name]
+			if !ok {
+				continue
+			}
+
+			ratio := float64(used) / float64(limits)
+			if ratio < limitRiskRatio {
+				continue
+			}
+
+			risks = append(risks, &LimitRiskContainer{
+				Namespace: pod.Namespace,
+				Pod:       pod.Name,
+				Container: container.Name,
+				Used:      used,
+				Limits:    limits,
+				Ratio:     ratio,
+				Memory:    snap.usage.containerStats(pod, container.Name),
+				CPU:       snap.containerCPU(md, pod, &container),
+			})
+		}
+	}
+
+	return risks
+}
+
+// Summary totals the node reports.
+type Summary struct {
+	Nodes       int   `json:"nodes"`
+	OkNodes     int   `json:"okNodes"`
+	Allocatable int64 `json:"allocatable"`
+	Used        int64 `json:"used"`
+	Requests    int64 `json:"requests"`
+	Schedulable int64 `json:"schedulable"`
+
+	// Profiled is the number of nodes with a threshold profile and Margin
+	// the sum of their margins.
+	Profiled int   `json:"profiled,omitempty"`
+	Margin   int64 `json:"margin,omitempty"`
+}
+
+func Summarize(nodes []*NodeReport) Summary {
+	s := Summary{}
+
+	for _, n := range nodes {
+		s.Nodes++
+		if n.Ok {
+			s.OkNodes++
+		}
+
+		s.Allocatable += n.Allocatable
+		s.Used += n.Used
+		s.Requests += n.Requests
+
+		if !n.Excluded {
+			s.Schedulable += n.Schedulable
+		}
+
+		if n.Profile != "" {
+			s.Profiled++
+			s.Margin += n.Margin
+		}
+	}
+
+	return s
+}
+
+// Output receives the report as it is computed.
+type Output interface {
+	// Metadata is called once before any nodes are reported.
+	Metadata(m *Metadata) error
+
+	Node(n *NodeReport) error
+	Evictable(e *EvictableContainer) error
+
+	// Flush is called once all the nodes have been reported.
+	Flush() error
+}
+
+// Collect gathers the node and pod metrics and reports each node (and any
+// evictable containers on it) to out. The collection timestamp and server
+// version are filled in on md before it is reported.
+func Collect(ctx context.Context, kcs kubernetes.Interface, mcs metricsv.Interface, md *Metadata, out Output) (err error) {
+	ctx, span := tracer.Start(ctx, "collect", trace.WithAttributes(
+		attribute.String("kubecap.context", md.Context),
+	))
+	defer func() { endSpan(span, err) }()
+
+	md.Timestamp = time.Now().UTC()
+	md.Budget.start(md.Timestamp)
+	md.Warnings = nil
+
+	_, vspan := tracer.Start(ctx, "get server version")
+	version, err := kcs.Discovery().ServerVersion()
+	endSpan(vspan, err)
+	if err != nil {
+		return err
+	}
+
+	md.ServerVersion = version.GitVersion
+
+	// A percentage of each node's allocatable isn't an amount a namespace
+	// quota can be checked against.
+	if md.Namespace != "" && md.AdditionalPercent == 0 {
+		md.Quota, err = namespaceQuota(ctx, kcs, md.Namespace, corev1.ResourceMemory, md.Additional)
+		if err != nil {
+			return err
+		}
+	}
+
+	if md.KueueBacklog {
+		md.Kueue, err = kueueBacklog(ctx, kcs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if md.JobBacklogSelector != "" {
+		md.JobBacklog, err = listJobBacklog(ctx, kcs, md.ResourceName(), md.JobBacklogSelector)
+		if err != nil {
+			return err
+		}
+	}
+
+	if md.AutoscalerStatus != "" {
+		md.Autoscaler, err = autoscalerStatusReport(ctx, kcs, md.AutoscalerStatus)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = out.Metadata(md)
+	if err != nil {
+		return err
+	}
+
+	selector, err := labels.Parse(md.Selector)
+	if err != nil {
+		return err
+	}
+
+	var in Scope
+	if md.In != "" {
+		in, err = ParseScope(md.In)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = CheckSortBy(md.SortBy)
+	if err != nil {
+		return err
+	}
+
+	rn := md.ResourceName()
+
+	metricsAPI := false
+
+	switch {
+	case rn == corev1.ResourceEphemeralStorage:
+	case md.UsageSource == "" || md.UsageSource == "metrics-api":
+		metricsAPI = true
+	case md.UsageSource == "cadvisor":
+	default:
+		return fmt.Errorf("unknown usage source: %q", md.UsageSource)
+	}
+
+	var nodeList *corev1.NodeList
+	var podList *corev1.PodList
+	var podLevel PodResources
+	var nodeMetricsList *metricsapi.NodeMetricsList
+	var podMetricsList *metricsapi.PodMetricsList
+	var usage *cadvisorUsage
+
+	// The nodes, pods and their metrics are listed concurrently: on large
+	// clusters each list takes a while.
+	lists := []func() error{
+		func() (err error) {
+			lctx, lspan := tracer.Start(ctx, "list nodes")
+			nodeList, err = ListNodes(lctx, kcs, md.NodeSelector)
+			endSpan(lspan, err)
+
+			return err
+		},
+		func() (err error) {
+			lctx, lspan := tracer.Start(ctx, "list pods")
+			if md.ListCache != nil {
+				podList, podLevel, err = md.ListCache.pods(lctx, kcs, md.Timestamp)
+			} else {
+				podList, podLevel, err = ListPods(lctx, kcs)
+			}
+			endSpan(lspan, err)
+
+			return err
+		},
+	}
+
+	if metricsAPI {
+		lists = append(lists,
+			func() (err error) {
+				lctx, lspan := tracer.Start(ctx, "list node metrics")
+				nodeMetricsList, err = mcs.MetricsV1beta1().NodeMetricses().List(lctx, metav1.ListOptions{})
+				endSpan(lspan, err)
+
+				return err
+			},
+			func() (err error) {
+				lctx, lspan := tracer.Start(ctx, "list pod metrics")
+				if md.ListCache != nil {
+					podMetricsList, err = md.ListCache.podMetrics(lctx, mcs, md.Timestamp)
+				} else {
+					podMetricsList, err = mcs.MetricsV1beta1().PodMetricses("").List(lctx, metav1.ListOptions{})
+				}
+				endSpan(lspan, err)
+
+				return err
+			},
+		)
+	}
+
+	err = parallel(lists...)
+	if err != nil {
+		return err
+	}
+
+	if md.NodeFilter != nil {
+		filtered := nodeList.Items[:0]
+		for _, node := range nodeList.Items {
+			if md.NodeFilter.Matches(&node, md.Timestamp) {
+				filtered = append(filtered, node)
+			}
+		}
+
+		nodeList.Items = filtered
+	}
+
+	// The other usage sources are read from each listed node.
+	switch {
+	case rn == corev1.ResourceEphemeralStorage:
+		nodeMetricsList, podMetricsList = storageMetrics(ctx, kcs, md, nodeList)
+	case !metricsAPI:
+		nodeMetricsList, podMetricsList, usage, err = scrapeCadvisor(ctx, kcs, md, nodeList)
+		if err != nil {
+			return err
+		}
+	}
+
+	md.Coverage = requestsCoverage(podList.Items, podLevel)
+
+	_, ispan := tracer.Start(ctx, "index pods", trace.WithAttributes(
+		attribute.Int("kubecap.pods", len(podList.Items)),
+		attribute.Int("kubecap.pod_metrics", len(podMetricsList.Items)),
+	))
+
+	nps := NewNodePods(podList)
+
+	nodes := map[string]*corev1.Node{}
+	for i := range nodeList.Items {
+		nodes[nodeList.Items[i].Name] = &nodeList.Items[i]
+	}
+
+	// Node metrics can't be selected by the nodes' labels so those of the
+	// nodes left out are dropped instead.
+	if md.NodeSelector != "" || md.NodeFilter != nil {
+		selected := nodeMetricsList.Items[:0]
+		for _, nm := range nodeMetricsList.Items {
+			if _, ok := nodes[nm.Name]; ok {
+				selected = append(selected, nm)
+			}
+		}
+
+		nodeMetricsList.Items = selected
+	}
+
+	if md.ChurnTracker != nil {
+		names := make([]string, 0, len(nodeList.Items))
+		for _, node := range nodeList.Items {
+			names = append(names, node.Name)
+		}
+
+		md.Churn = md.ChurnTracker.update(names)
+	}
+
+	medians, peers := peerMedians(nodes, md.NodeGroupLabel)
+
+	podUsage := map[string]int64{}
+	containerUsage := map[string]int64{}
+	containerCPUUsage := map[string]int64{}
+	for _, pm := range podMetricsList.Items {
+		for _, pmc := range pm.Containers {
+			used := ResourceValue(rn, pmc.Usage[rn])
+
+			podUsage[pm.Namespace+"/"+pm.Name] += used
+			containerUsage[pm.Namespace+"/"+pm.Name+"/"+pmc.Name] = used
+
+			if cpu, ok := pmc.Usage[corev1.ResourceCPU]; ok {
+				containerCPUUsage[pm.Namespace+"/"+pm.Name+"/"+pmc.Name] = cpu.MilliValue()
+			}
+		}
+	}
+
+	sort.Slice(nodeMetricsList.Items, func(i, j int) bool {
+		return nodeMetricsList.Items[i].Name < nodeMetricsList.Items[j].Name
+	})
+
+	ispan.End()
+
+	snap := &snapshot{
+		nodes:          nodes,
+		peerMedians:    medians,
+		peers:          peers,
+		nps:            nps,
+		podLevel:       podLevel,
+		podUsage:       podUsage,
+		containerUsage: containerUsage,
+		usage:          usage,
+
+		containerCPUUsage: containerCPUUsage,
+		selector:          selector,
+		in:                in,
+	}
+
+	if len(md.Namespaces) > 0 {
+		snap.namespaces = map[string]bool{}
+		for _, ns := range md.Namespaces {
+			snap.namespaces[ns] = true
+		}
+	}
+
+	if md.DRA {
+		snap.devices, snap.deviceNeeds, err = collectDevices(ctx, kcs, md.AdditionalDevices)
+		if err != nil {
+			return err
+		}
+	}
+
+	if md.VolumeAttach {
+		snap.volumes, err = collectVolumeAttachments(ctx, kcs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if md.GPUMetricsURL != "" && md.Budget.allow("gpu", 0) {
+		snap.gpu, err = collectGPUUsage(ctx, md.GPUMetricsURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(md.Enrichers) > 0 && md.Budget.allow("enrichers", 0) {
+		snap.attributes, err = enrichNodes(ctx, md.Enrichers, nodes)
+		if err != nil {
+			return err
+		}
+	}
+
+	if md.AllocatableTracker != nil {
+		snap.allocatableChanges = md.AllocatableTracker.update(nodes, md.Timestamp, md.warnf)
+	}
+
+	if md.Cordons && md.Budget.allow("cordons", 1) {
+		events, err := listCordonEvents(ctx, kcs)
+		if err != nil {
+			return err
+		}
+
+		cordoned := []string{}
+		for name, node := range nodes {
+			if node.Spec.Unschedulable {
+				cordoned = append(cordoned, name)
+			}
+		}
+
+		if md.CordonTracker != nil {
+			snap.cordons = md.CordonTracker.update(cordoned, events, md.Timestamp)
+		} else {
+			snap.cordons = map[string]time.Time{}
+			for _, name := range cordoned {
+				snap.cordons[name] = events[name]
+			}
+		}
+	}
+
+	if md.PodChurnWindow > 0 && md.Budget.allow("pod churn", 1) {
+		snap.podChurn, err = listPodChurn(ctx, kcs, md.PodChurnWindow, md.Timestamp)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(md.Reservations) > 0 {
+		snap.reserving, snap.reservations, md.ReservationStatus = placeReservations(md, snap)
+	}
+
+	if len(md.Standby) > 0 && md.Budget.allow("standby", int64(len(md.Standby))) {
+		priorities, err := standbyPriorities(ctx, kcs, md, snap)
+		if err != nil {
+			return err
+		}
+
+		md.StandbyStatus = placeStandby(md, snap, priorities)
+	}
+
+	// nodeFits is whether the additional amount fits on any node and
+	// schedulable the memory left schedulable across them.
+	nodeFits := false
+	var schedulable int64
+
+	// reports are kept to match the autoscaler's node groups with.
+	var reports []*NodeReport
+
+	emit := func(n *NodeReport, evictable []*EvictableContainer) error {
+		if n.Ok {
+			nodeFits = true
+		}
+
+		if n.Schedulable > 0 && !n.Excluded {
+			schedulable += n.Schedulable
+		}
+
+		if md.Autoscaler != nil || len(md.SLOs) > 0 || md.Verdicts != nil {
+			reports = append(reports, n)
+		}
+
+		if md.Round > 0 {
+			n = n.Rounded(md.Round)
+			evictable = nil
+		}
+
+		for _, e := range evictable {
+			err := out.Evictable(e)
+			if err != nil {
+				return err
+			}
+		}
+
+		return out.Node(n)
+	}
+
+	type analyzed struct {
+		node      *NodeReport
+		evictable []*EvictableContainer
+	}
+
+	// Nodes are streamed as they are analyzed unless they need sorting
+	// by something other than name.
+	sorted := []analyzed{}
+
+	for _, nodeMetric := range nodeMetricsList.Items {
+		n, evictable, err := analyzeNode(ctx, kcs, md, snap, nodeMetric)
+		if err != nil {
+			return err
+		}
+
+		switch md.SortBy {
+		case "", "name":
+			err = emit(n, evictable)
+			if err != nil {
+				return err
+			}
+		default:
+			sorted = append(sorted, analyzed{n, evictable})
+		}
+	}
+
+	if md.Smoother != nil {
+		md.Smoother.prune(md.Timestamp)
+	}
+
+	switch md.SortBy {
+	case "", "name":
+	case "pressure":
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].node.Pressure > sorted[j].node.Pressure
+		})
+	}
+
+	for _, a := range sorted {
+		err = emit(a.node, a.evictable)
+		if err != nil {
+			return err
+		}
+	}
+
+	if md.Quota != nil {
+		md.Quota.Blocker = quotaBlocker(md.Quota, nodeFits)
+	}
+
+	if md.Kueue != nil {
+		md.Kueue.setBlockers(schedulable)
+	}
+
+	if md.JobBacklog != nil {
+		md.JobBacklog.estimate(schedulable)
+	}
+
+	if md.Autoscaler != nil {
+		md.Autoscaler.matchGroups(SummarizeGroups(reports))
+	}
+
+	if len(md.SLOs) > 0 {
+		md.SLOStatus = EvaluateSLOs(md.Context, md.SLOs, reports)
+
+		if md.SLOTracker != nil {
+			md.SLOTracker.track(md.SLOStatus, md.Timestamp)
+		}
+	}
+
+	md.ClusterOk = md.Verdicts.clusterOk(reports, md)
+
+	md.Degraded = md.Budget.degraded()
+
+	_, fspan := tracer.Start(ctx, "flush")
+	err = out.Flush()
+	endSpan(fspan, err)
+
+	return err
+}
+
+// parallel runs the functions concurrently and, once they are all done,
+// returns the first of their errors, if any.
+func parallel(fns ...func() error) error {
+	errs := make([]error, len(fns))
+
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+
+		go func(i int, fn func() error) {
+			defer wg.Done()
+
+			errs[i] = fn()
+		}(i, fn)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SortOrders are the orders nodes can be reported in.
+var SortOrders = []string{"name", "pressure"}
+
+// CheckSortBy returns an error unless sortBy is one of SortOrders or empty
+// (by name).
+func CheckSortBy(sortBy string) error {
+	if sortBy == "" {
+		return nil
+	}
+
+	for _, o := range SortOrders {
+		if sortBy == o {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown sort %q (one of %s)", sortBy, strings.Join(SortOrders, ", "))
+}
+
+// listPageSize is how many objects are listed per request, so that large
+// clusters are listed in chunks the API server can serve.
+const listPageSize = 500
+
+// ListNodes lists the nodes matching the label selector page by page.
+func ListNodes(ctx context.Context, kcs kubernetes.Interface, selector string) (*corev1.NodeList, error) {
+	nodeList := &corev1.NodeList{}
+	opts := metav1.ListOptions{LabelSelector: selector, Limit: listPageSize}
+
+	for {
+		page, err := kcs.CoreV1().Nodes().List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		nodeList.Items = append(nodeList.Items, page.Items...)
+
+		if page.Continue == "" {
+			return nodeList, nil
+		}
+
+		opts.Continue = page.Continue
+	}
+}
+
+// snapshot is the cluster-wide state collected once per report and shared by
+// the analysis of each node.
+type snapshot struct {
+	nodes map[string]*corev1.Node
+
+	// peerMedians are the median allocatable memory of each node's peers
+	// (same group and instance type) and peers the number of them.
+	peerMedians map[string]int64
+	peers       map[string]int
+
+	nps            NodePods
+	podLevel       PodResources
+	podUsage       map[string]int64
+	containerUsage map[string]int64
+	usage          *cadvisorUsage
+
+	// containerCPUUsage is the CPU used by each container (by
+	// namespace/pod/container) in millicores, when the pod metrics have it.
+	containerCPUUsage map[string]int64
+
+	// podChurn is the pod churn on each node, when counted.
+	podChurn map[string]*PodChurnReport
+
+	// devices are the DRA devices on each node and deviceNeeds the
+	// additional devices (by driver) checked for.
+	devices     map[string][]*DeviceReport
+	deviceNeeds map[string]int64
+
+	// volumes are the CSI volume attachments on each node, when collected.
+	volumes map[string][]*VolumeAttachReport
+
+	// cordons are when each cordoned node was cordoned (zero when unknown),
+	// when reported.
+	cordons map[string]time.Time
+
+	// allocatableChanges are each node's recent allocatable and capacity
+	// changes, when tracked.
+	allocatableChanges map[string][]*AllocatableChange
+
+	// gpu is the GPU utilization, when collected.
+	gpu *gpuUsage
+
+	// reserving is the amount the capacity reservations take on each node
+	// and reservations their names.
+	reserving    map[string]int64
+	reservations map[string][]string
+
+	// attributes are each node's enriched attributes, when enriched.
+	attributes map[string]map[string]string
+
+	// namespaces (all when nil) and selector select the pods considered for
+	// eviction.
+	namespaces map[string]bool
+	selector   labels.Selector
+
+	// in is the scope of the additional amount.
+	in Scope
+}
+
+// selected returns whether the pod may be considered for eviction.
+func (snap *snapshot) selected(pod *corev1.Pod) bool {
+	if snap.namespaces != nil && !snap.namespaces[pod.Namespace] {
+		return false
+	}
+
+	return snap.selector == nil || snap.selector.Matches(labels.Set(pod.Labels))
+}
+
+// analyzeNode reports on a single node and its evictable containers.
+func analyzeNode(ctx context.Context, kcs kubernetes.Interface, md *Metadata, snap *snapshot, nodeMetric metricsapi.NodeMetrics) (nr *NodeReport, evictable []*EvictableContainer, err error) {
+	ctx, span := tracer.Start(ctx, "analyze node", trace.WithAttributes(
+		attribute.String("k8s.node.name", nodeMetric.Name),
+	))
+	defer func() { endSpan(span, err) }()
+
+	additional := md.Additional
+
+	name := nodeMetric.Name
+	rn := md.ResourceName()
+	used := ResourceValue(rn, nodeMetric.Usage[rn])
+
+	var usedRaw int64
+	if md.Smoother != nil {
+		usedRaw = used
+		used = md.Smoother.smooth(md.Context+"/"+name, used, md.Timestamp)
+	}
+
+	// Nodes created since they were listed are fetched individually.
+	node, ok := snap.nodes[name]
+	if !ok {
+		node, err = kcs.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	allocatable := ResourceValue(rn, node.Status.Allocatable[rn])
+	free := allocatable - used
+
+	if md.AdditionalPercent != 0 {
+		additional = int64(float64(allocatable) * md.AdditionalPercent / 100)
+	}
+
+	requests, pendingRequests, nominatedRequests, terminatingRequests := weightedRequests(md, snap.nps[node.Name], snap.podLevel)
+	schedulable := allocatable - requests - snap.reserving[node.Name]
+
+	// Efficiency is left at zero for nodes without any requests rather
+	// than reporting an infinite ratio.
+	var efficiency float64
+	if requests > 0 {
+		efficiency = float64(used) / float64(requests)
+	}
+
+	fwa := free - additional
+	swa := schedulable - additional
+
+	// A threshold profile requires a margin to be left rather than any
+	// room at all.
+	var margin int64
+	profile := md.ThresholdProfiles.of(node)
+	if profile != nil {
+		margin = profile.Margin(allocatable)
+	}
+
+	enough := fwa > margin && swa > margin
+
+	notReady := !NodeReady(node)
+	excluded := notReady && !md.IncludeNotReady
+
+	// Without the kubelet's configuration and stats the columns depending
+	// on them are left empty, as for an unreachable kubelet.
+	kubelet := (md.NUMA || md.CPUManager || md.CheckReserved || md.PSI) && md.Budget.allow("kubelet", 2)
+
+	var cfg *kubeletConfigz
+	if kubelet && (md.NUMA || md.CPUManager || md.CheckReserved) {
+		cfg, err = getKubeletConfigz(ctx, kcs, name)
+		if err != nil {
+			// An unreachable kubelet (e.g. a NotReady node) leaves the
+			// columns depending on its configuration empty rather than
+			// failing the report.
+			md.warnf("node %s: %v", name, err)
+			cfg, err = nil, nil
+		}
+	}
+
+	var numa *NUMAReport
+	if md.NUMA && cfg != nil {
+		numa, err = nodeNUMA(ctx, kcs, name, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// With the single-numa-node topology policy a Guaranteed pod of the
+		// additional amount must also fit on one NUMA node.
+		if rn == corev1.ResourceMemory && numa != nil && numa.singleNUMA() && numa.LargestAvailable >= 0 {
+			enough = enough && numa.LargestAvailable > additional
+		}
+	}
+
+	var cpuManager *CPUManagerReport
+	if md.CPUManager {
+		cpuManager = nodeCPUManager(node, snap.nps[node.Name], cfg)
+	}
+
+	var summary *kubeletSummary
+	if kubelet && (md.PSI || md.CheckReserved) {
+		summary, err = getKubeletSummary(ctx, kcs, name)
+		if err != nil {
+			md.warnf("node %s: %v", name, err)
+			summary, err = nil, nil
+		}
+	}
+
+	var psi *PSIReport
+	if md.PSI && summary != nil {
+		psi = summary.psi()
+	}
+
+	var reserved *ReservedReport
+	if md.CheckReserved {
+		reserved, err = nodeReserved(cfg, summary)
+		if err != nil {
+			return nil, nil, fmt.Errorf("node %s: %w", name, err)
+		}
+	}
+
+	var devices []*DeviceReport
+	if md.DRA {
+		devices = snap.devices[name]
+
+		if !devicesFit(devices, snap.deviceNeeds) {
+			enough = false
+		}
+	}
+
+	var schedulerReasons []string
+	if md.Scheduler != nil {
+		enough = md.Scheduler.fits(name)
+		schedulerReasons = md.Scheduler.Filtered[name]
+	}
+
+	if excluded {
+		enough = false
+	}
+
+	// Evicting pods from nodes out of scope makes no room for the
+	// additional amount.
+	group := nodeGroup(node, md.NodeGroupLabel)
+	outOfScope := !snap.in.matches(node, group)
+
+	if outOfScope {
+		enough = false
+	} else if !enough && md.Budget.allow("evictable", 0) {
+		evictable = snap.evictable(md, node)
+	}
+
+	pods := []*PodReport{}
+	for _, pod := range snap.nps[node.Name] {
+		podRequests := snap.podLevel.Requests(pod, rn)
+
+		kind, workload := PodWorkload(pod)
+
+		var priority int32
+		if pod.Spec.Priority != nil {
+			priority = *pod.Spec.Priority
+		}
+
+		podUsed := snap.podUsage[pod.Namespace+"/"+pod.Name]
+
+		var costCenter string
+		if md.CostCenters != nil {
+			costCenter = md.CostCenters.of(pod)
+		}
+
+		pods = append(pods, &PodReport{
+			Namespace:     pod.Namespace,
+			Name:          pod.Name,
+			WorkloadKind:  kind,
+			Workload:      workload,
+			Requests:      podRequests,
+			Used:          podUsed,
+			State:         PodState(pod),
+			Efficiency:    podEfficiency(podRequests, podUsed),
+			PriorityClass: pod.Spec.PriorityClassName,
+			Priority:      priority,
+			CostCenter:    costCenter,
+			Memory:        snap.usage.podStats(pod),
+			GPU:           snap.gpu.podReport(pod, snap.podLevel, md.gpuResourceName()),
+		})
+	}
+
+	var priorityClasses []*PriorityClassRequests
+	if md.PriorityClasses {
+		priorityClasses = priorityClassRequests(pods)
+	}
+
+	nr = &NodeReport{
+		Cluster:                   md.Context,
+		Name:                      name,
+		Group:                     group,
+		Zone:                      node.Labels[ZoneLabel],
+		TaintPool:                 nodeTaintPool(node, md.TaintPoolKeys),
+		KubeletVersion:            node.Status.NodeInfo.KubeletVersion,
+		Created:                   node.CreationTimestamp.Time,
+		InstanceType:              node.Labels[instanceTypeLabel],
+		Allocatable:               allocatable,
+		Used:                      used,
+		UsedRaw:                   usedRaw,
+		Free:                      free,
+		Requests:                  requests,
+		PendingRequests:           pendingRequests,
+		NominatedRequests:         nominatedRequests,
+		TerminatingRequests:       terminatingRequests,
+		StuckTerminating:          stuckTerminating(snap.nps[node.Name], snap.podLevel, rn, md.Timestamp, md.StuckAfter),
+		Efficiency:                efficiency,
+		Schedulable:               schedulable,
+		FreeWithAdditional:        fwa,
+		SchedulableWithAdditional: swa,
+		Additional:                additional,
+		Reservations:              snap.reservations[name],
+		Reserving:                 snap.reserving[name],
+		Ok:                        enough,
+		Margin:                    margin,
+		Pods:                      pods,
+		Memory:                    snap.usage.nodeStats(name),
+		NUMA:                      numa,
+		CPUManager:                cpuManager,
+		PSI:                       psi,
+		Reserved:                  reserved,
+		Devices:                   devices,
+		Volumes:                   snap.volumes[name],
+		Cordon:                    snap.cordonReport(node, schedulable),
+		Attributes:                snap.attributes[name],
+		AllocatableChanges:        snap.allocatableChanges[name],
+		GPU:                       snap.gpu.nodeReport(node, snap.nps[node.Name], snap.podLevel, md.gpuResourceName()),
+		Limits:                    snap.limits(node.Name, rn),
+		PodCount:                  podCount(node.Name, snap.nps[node.Name]),
+		PodCapacity:               node.Status.Allocatable.Pods().Value(),
+		Conditions:                activeConditions(node),
+		NotReady:                  notReady,
+		Excluded:                  excluded,
+		OutOfScope:                outOfScope,
+		PriorityClasses:           priorityClasses,
+		SchedulerReasons:          schedulerReasons,
+	}
+
+	nr.StuckRequests = stuckRequests(nr.StuckTerminating)
+	nr.Overcommitted = overcommit(md, snap.nps[node.Name], snap.podLevel, allocatable, requests)
+
+	if snap.podChurn != nil {
+		nr.PodChurn = snap.podChurn[name]
+		if nr.PodChurn == nil {
+			nr.PodChurn = &PodChurnReport{}
+		}
+	}
+
+	if profile != nil {
+		nr.Profile = profile.Name
+	}
+
+	// The peer medians and limit risks are about memory only.
+	if rn == corev1.ResourceMemory {
+		nr.AllocatableAnomaly = snap.allocatableAnomaly(name, allocatable)
+		nr.LimitRisks = snap.limitRisks(md, name)
+	}
+
+	unmatched := snap.unmatchedPods(name)
+	nr.Unmatched = int64(len(unmatched))
+
+	if md.ListUnmatched && len(unmatched) > 0 {
+		nr.UnmatchedPods = unmatched
+	}
+	nr.Pressure = pressureScore(nr)
+	nr.Columns = md.evalColumns(nr)
+
+	// A node rule overrides the verdict the evictable containers were
+	// collected for.
+	if ok := md.Verdicts.nodeOk(nr, md); ok != nr.Ok {
+		nr.Ok = ok
+
+		switch {
+		case ok:
+			evictable = nil
+		case !outOfScope && md.Budget.allow("evictable", 0):
+			evictable = snap.evictable(md, node)
+		}
+	}
+
+	return nr, evictable, nil
+}
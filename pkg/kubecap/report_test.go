@@ -0,0 +1,228 @@
+package kubecap
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestUnmatchedPods(t *testing.T) {
+	nps := NodePods{}
+
+	for _, p := range []struct {
+		name  string
+		phase corev1.PodPhase
+	}{
+		{"metered", corev1.PodRunning},
+		{"restarted", corev1.PodRunning},
+		{"starting", corev1.PodPending},
+		{"done", corev1.PodSucceeded},
+	} {
+		nps.Add(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: p.name},
+			Spec:       corev1.PodSpec{NodeName: "node-a"},
+			Status:     corev1.PodStatus{Phase: p.phase},
+		})
+	}
+
+	snap := &snapshot{
+		nps:      nps,
+		podUsage: map[string]int64{"default/metered": 1 << 20},
+	}
+
+	if got := snap.unmatchedPods("node-a"); !reflect.DeepEqual(got, []string{"default/restarted"}) {
+		t.Errorf("unmatched = %v", got)
+	}
+}
+
+func TestNodePodsNominated(t *testing.T) {
+	nps := NodePods{}
+
+	nps.Add(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "bound"},
+		Spec:       corev1.PodSpec{NodeName: "node-a"},
+	})
+	nps.Add(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "nominated"},
+		Status:     corev1.PodStatus{NominatedNodeName: "node-b"},
+	})
+	nps.Add(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "unscheduled"},
+	})
+
+	if len(nps) != 2 || len(nps["node-a"]) != 1 || len(nps["node-b"]) != 1 || nps["node-b"][0].Name != "nominated" {
+		t.Errorf("node pods = %v", nps)
+	}
+}
+
+func TestSnapshotSelected(t *testing.T) {
+	selector, err := labels.Parse("tier!=critical")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap := &snapshot{
+		namespaces: map[string]bool{"batch": true},
+		selector:   selector,
+	}
+
+	for _, tc := range []struct {
+		namespace string
+		labels    map[string]string
+		want      bool
+	}{
+		{"batch", nil, true},
+		{"batch", map[string]string{"tier": "critical"}, false},
+		{"kube-system", nil, false},
+	} {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: tc.namespace, Labels: tc.labels}}
+
+		if got := snap.selected(pod); got != tc.want {
+			t.Errorf("%s %v: selected = %t, want %t", tc.namespace, tc.labels, got, tc.want)
+		}
+	}
+}
+
+func TestParallel(t *testing.T) {
+	// Each function waits for all of them to have started, which only
+	// happens when they run concurrently.
+	var started sync.WaitGroup
+	started.Add(3)
+
+	all := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(all)
+	}()
+
+	first, second := errors.New("first"), errors.New("second")
+
+	fn := func(err error) func() error {
+		return func() error {
+			started.Done()
+
+			select {
+			case <-all:
+			case <-time.After(5 * time.Second):
+				t.Error("the functions didn't run concurrently")
+			}
+
+			return err
+		}
+	}
+
+	// The first function's error is returned, whichever failed first.
+	err := parallel(fn(nil), fn(first), fn(second))
+	if err != first {
+		t.Errorf("err = %v, want %v", err, first)
+	}
+
+	if err := parallel(func() error { return nil }); err != nil {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestCheckSortBy(t *testing.T) {
+	for _, sortBy := range []string{"", "name", "pressure"} {
+		if err := CheckSortBy(sortBy); err != nil {
+			t.Errorf("%q: %v", sortBy, err)
+		}
+	}
+
+	if err := CheckSortBy("free"); err == nil || err.Error() != `unknown sort "free" (one of name, pressure)` {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestSnapshotEvictable(t *testing.T) {
+	const mi = 1 << 20
+
+	snap := &snapshot{
+		nps:            NodePods{},
+		containerUsage: map[string]int64{},
+	}
+
+	for _, p := range []struct {
+		name     string
+		qos      corev1.PodQOSClass
+		priority int32
+		owner    string
+		req, lim int64
+		used     int64
+	}{
+		{"guaranteed", corev1.PodQOSGuaranteed, 0, "", 100 * mi, 100 * mi, 150 * mi},
+		{"burstable-low", corev1.PodQOSBurstable, 0, "", 100 * mi, 200 * mi, 150 * mi},
+		{"daemon", corev1.PodQOSBurstable, 0, "DaemonSet", 100 * mi, 400 * mi, 350 * mi},
+		{"best-effort", corev1.PodQOSBestEffort, 0, "", 0, 0, 80 * mi},
+		{"burstable-high", corev1.PodQOSBurstable, 1000, "", 100 * mi, 400 * mi, 300 * mi},
+		{"burstable-big", corev1.PodQOSBurstable, 0, "ReplicaSet", 100 * mi, 400 * mi, 350 * mi},
+		{"idle", corev1.PodQOSBurstable, 0, "", 100 * mi, 200 * mi, 50 * mi},
+	} {
+		priority := p.priority
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: p.name},
+			Spec: corev1.PodSpec{
+				NodeName: "node-a",
+				Priority: &priority,
+				Containers: []corev1.Container{{
+					Name:      "app",
+					Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}},
+				}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, QOSClass: p.qos},
+		}
+
+		if p.owner != "" {
+			controller := true
+			pod.OwnerReferences = []metav1.OwnerReference{{Kind: p.owner, Name: p.name, Controller: &controller}}
+		}
+
+		resources := pod.Spec.Containers[0].Resources
+		if p.req != 0 {
+			resources.Requests[corev1.ResourceMemory] = *resource.NewQuantity(p.req, resource.BinarySI)
+		}
+
+		if p.lim != 0 {
+			resources.Limits[corev1.ResourceMemory] = *resource.NewQuantity(p.lim, resource.BinarySI)
+		}
+
+		snap.nps.Add(pod)
+		snap.containerUsage["default/"+p.name+"/app"] = p.used
+	}
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+
+	for _, tc := range []struct {
+		name                   string
+		burstableOnly, include bool
+		want                   []string
+	}{
+		// Without the kubelet ranking the candidates keep the node's pod
+		// order; the guaranteed, DaemonSet and idle pods are never candidates.
+		{"all", false, false, []string{"burstable-low", "burstable-high", "burstable-big"}},
+		{"burstable only", true, false, []string{"burstable-big", "burstable-low", "burstable-high"}},
+		{"best effort", false, true, []string{"burstable-low", "best-effort", "burstable-high", "burstable-big"}},
+		{"burstable only with best effort", true, true, []string{"burstable-big", "best-effort", "burstable-low", "burstable-high"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			md := &Metadata{EvictBurstableOnly: tc.burstableOnly, EvictIncludeBestEffort: tc.include}
+
+			got := []string{}
+			for _, ec := range snap.evictable(md, node) {
+				got = append(got, ec.Pod)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("evictable = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package kubecap
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Reservation is a named capacity reservation: room kept on the nodes for a
+// workload planned but not deployed yet, e.g. a launch's extra replicas. Its
+// replicas are placed on the nodes as pods would be and subtracted from their
+// schedulable amount, so that every report accounts for them.
+type Reservation struct {
+	Name string `json:"name"`
+
+	// Group and Zone restrict the nodes the replicas are placed on to a node
+	// group and a zone, any when empty.
+	Group string `json:"group,omitempty"`
+	Zone  string `json:"zone,omitempty"`
+
+	// Requests are what each replica reserves by resource, e.g. memory:
+	// 8GiB and cpu: 2. Resources not listed aren't reserved.
+	Requests map[string]string `json:"requests"`
+
+	// Replicas is how many replicas are reserved, 1 when not set.
+	Replicas int64 `json:"replicas,omitempty"`
+
+	// Until is when the reservation expires, if ever.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// ReservationReport is where a reservation's replicas were placed. Replicas
+// that fit on no node are left unplaced and reserve nothing.
+type ReservationReport struct {
+	Cluster string `json:"cluster,omitempty"`
+	Name    string `json:"name"`
+
+	// Amount is what each replica reserves of the report's resource.
+	Amount   int64 `json:"amount"`
+	Replicas int64 `json:"replicas"`
+	Placed   int64 `json:"placed"`
+
+	// Nodes are the replicas placed on each node.
+	Nodes map[string]int64 `json:"nodes,omitempty"`
+
+	// Expired is set once the reservation is past its Until; it then
+	// reserves nothing.
+	Expired bool `json:"expired,omitempty"`
+}
+
+// LoadReservations reads a YAML list of capacity reservations.
+func LoadReservations(path string) ([]*Reservation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations := []*Reservation{}
+
+	err = yaml.UnmarshalStrict(data, &reservations)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+
+	for _, r := range reservations {
+		if r.Name == "" {
+			return nil, fmt.Errorf("%s: reservation without a name", path)
+		}
+
+		if names[r.Name] {
+			return nil, fmt.Errorf("%s: reservation %s: defined twice", path, r.Name)
+		}
+
+		names[r.Name] = true
+
+		if r.Replicas < 0 {
+			return nil, fmt.Errorf("%s: reservation %s: negative replicas", path, r.Name)
+		}
+
+		if r.Replicas == 0 {
+			r.Replicas = 1
+		}
+
+		if len(r.Requests) == 0 {
+			return nil, fmt.Errorf("%s: reservation %s: no requests", path, r.Name)
+		}
+
+		for name, amount := range r.Requests {
+			_, err = ParseAmount(corev1.ResourceName(name), amount)
+			if err != nil {
+				return nil, fmt.Errorf("%s: reservation %s: %w", path, r.Name, err)
+			}
+		}
+	}
+
+	return reservations, nil
+}
+
+// placeReservations places the replicas of the reservations, in order, each
+// on the matching node with the most left schedulable that it fits on, as
+// the scheduler spreads pods. Nodes cordoned or excluded from the totals
+// don't take any. It returns the amount reserved on each node, the names of
+// the reservations there and where each reservation's replicas went.
+func placeReservations(md *Metadata, snap *snapshot) (map[string]int64, map[string][]string, []*ReservationReport) {
+	rn := md.ResourceName()
+
+	names := make([]string, 0, len(snap.nodes))
+	left := map[string]int64{}
+
+	for name, node := range snap.nodes {
+		if node.Spec.Unschedulable || (!NodeReady(node) && !md.IncludeNotReady) {
+			continue
+		}
+
+		requests, _, _, _ := weightedRequests(md, snap.nps[name], snap.podLevel)
+
+		names = append(names, name)
+		left[name] = ResourceValue(rn, node.Status.Allocatable[rn]) - requests
+	}
+
+	sort.Strings(names)
+
+	reserving := map[string]int64{}
+	reservations := map[string][]string{}
+	reports := []*ReservationReport{}
+
+	for _, r := range md.Reservations {
+		// The amounts were checked when loaded.
+		amount, _ := ParseAmount(rn, r.Requests[string(rn)])
+
+		report := &ReservationReport{
+			Cluster:  md.Context,
+			Name:     r.Name,
+			Amount:   amount,
+			Replicas: r.Replicas,
+			Expired:  r.Until != nil && !md.Timestamp.Before(*r.Until),
+		}
+		reports = append(reports, report)
+
+		if report.Expired || amount == 0 {
+			continue
+		}
+
+		for i := int64(0); i < r.Replicas; i++ {
+			best := ""
+
+			for _, name := range names {
+				node := snap.nodes[name]
+
+				if r.Group != "" && nodeGroup(node, md.NodeGroupLabel) != r.Group {
+					continue
+				}
+
+				if r.Zone != "" && node.Labels[ZoneLabel] != r.Zone {
+					continue
+				}
+
+				if left[name] >= amount && (best == "" || left[name] > left[best]) {
+					best = name
+				}
+			}
+
+			if best == "" {
+				break
+			}
+
+			left[best] -= amount
+			reserving[best] += amount
+
+			if report.Nodes == nil {
+				report.Nodes = map[string]int64{}
+			}
+
+			if report.Nodes[best] == 0 {
+				reservations[best] = append(reservations[best], r.Name)
+			}
+
+			report.Nodes[best]++
+			report.Placed++
+		}
+	}
+
+	return reserving, reservations, reports
+}
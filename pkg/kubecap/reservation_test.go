@@ -0,0 +1,116 @@
+package kubecap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPlaceReservations(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	yesterday := now.Add(-24 * time.Hour)
+
+	node := func(name, zone, memory string, cordoned bool) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"pool": "web", ZoneLabel: zone},
+			},
+			Spec: corev1.NodeSpec{Unschedulable: cordoned},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(memory)},
+				Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+
+	snap := &snapshot{
+		nodes: map[string]*corev1.Node{
+			"node-a": node("node-a", "a", "24Gi", false),
+			"node-b": node("node-b", "b", "12Gi", false),
+			"node-c": node("node-c", "a", "64Gi", true),
+		},
+		nps: map[string][]*corev1.Pod{},
+	}
+
+	md := &Metadata{
+		Timestamp:      now,
+		NodeGroupLabel: "pool",
+		Reservations: []*Reservation{
+			// Spread over the nodes with the most room, skipping the
+			// cordoned one.
+			{Name: "launch", Group: "web", Requests: map[string]string{"memory": "8GiB"}, Replicas: 3},
+			// Zone a is left with room for one of the two.
+			{Name: "batch", Zone: "a", Requests: map[string]string{"memory": "6Gi"}, Replicas: 2},
+			{Name: "cpu-only", Requests: map[string]string{"cpu": "2"}, Replicas: 1},
+			{Name: "old", Requests: map[string]string{"memory": "1Gi"}, Replicas: 1, Until: &yesterday},
+		},
+	}
+
+	reserving, reservations, reports := placeReservations(md, snap)
+
+	if want := map[string]int64{"node-a": 22 << 30, "node-b": 8 << 30}; !reflect.DeepEqual(reserving, want) {
+		t.Errorf("reserving = %v, want %v", reserving, want)
+	}
+
+	if want := map[string][]string{"node-a": {"launch", "batch"}, "node-b": {"launch"}}; !reflect.DeepEqual(reservations, want) {
+		t.Errorf("reservations = %v, want %v", reservations, want)
+	}
+
+	for i, want := range []struct {
+		placed  int64
+		nodes   map[string]int64
+		expired bool
+	}{
+		{3, map[string]int64{"node-a": 2, "node-b": 1}, false},
+		{1, map[string]int64{"node-a": 1}, false},
+		{0, nil, false},
+		{0, nil, true},
+	} {
+		r := reports[i]
+		if r.Placed != want.placed || !reflect.DeepEqual(r.Nodes, want.nodes) || r.Expired != want.expired {
+			t.Errorf("%s: placed = %d, nodes = %v, expired = %t, want %d, %v, %t", r.Name, r.Placed, r.Nodes, r.Expired, want.placed, want.nodes, want.expired)
+		}
+	}
+}
+
+func TestLoadReservations(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(data string) string {
+		path := filepath.Join(dir, "reservations.yaml")
+
+		err := os.WriteFile(path, []byte(data), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	reservations, err := LoadReservations(write("- name: launch\n  requests:\n    memory: 8GiB\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reservations) != 1 || reservations[0].Replicas != 1 {
+		t.Errorf("reservations = %+v, want launch with one replica", reservations)
+	}
+
+	for _, data := range []string{
+		"- requests:\n    memory: 8GiB\n",
+		"- name: launch\n",
+		"- name: launch\n  requests:\n    memory: lots\n",
+		"- name: launch\n  requests:\n    memory: 8GiB\n- name: launch\n  requests:\n    memory: 8GiB\n",
+	} {
+		if _, err := LoadReservations(write(data)); err == nil {
+			t.Errorf("%q: no error", data)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package kubecap
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// reservedWasteRatio is the share of the reservation below which system
+// usage is considered to leave the reservation too large.
+const reservedWasteRatio = 0.5
+
+// ReservedReport compares the memory reserved for the system (kube-reserved
+// plus system-reserved) with what the system actually uses. Amounts are in
+// bytes.
+type ReservedReport struct {
+	Reserved int64 `json:"reserved"`
+
+	// SystemUsed is the node's working set outside of the pods cgroup: the
+	// kubelet, container runtime and the OS.
+	SystemUsed int64 `json:"systemUsed"`
+
+	// Verdict is "ok", "too-small" (system usage exceeds the reservation so
+	// it eats into pods' memory and risks evictions) or "too-large" (less
+	// than half the reservation is used, wasting allocatable memory).
+	Verdict string `json:"verdict"`
+}
+
+// nodeReserved compares the node's reservations to its system usage. It
+// returns nil when the kubelet configuration or summary couldn't be read or
+// the summary lacks the working sets needed.
+func nodeReserved(cfg *kubeletConfigz, summary *kubeletSummary) (*ReservedReport, error) {
+	if cfg == nil || summary == nil {
+		return nil, nil
+	}
+
+	var reserved int64
+
+	for _, res := range []map[string]string{cfg.KubeletConfig.KubeReserved, cfg.KubeletConfig.SystemReserved} {
+		mem, ok := res["memory"]
+		if !ok {
+			continue
+		}
+
+		q, err := resource.ParseQuantity(mem)
+		if err != nil {
+			return nil, fmt.Errorf("reserved memory %q: %w", mem, err)
+		}
+
+		reserved += q.Value()
+	}
+
+	total := summary.Node.Memory.WorkingSetBytes
+	if total == nil {
+		return nil, nil
+	}
+
+	var pods *uint64
+	for _, sc := range summary.Node.SystemContainers {
+		if sc.Name == "pods" {
+			pods = sc.Memory.WorkingSetBytes
+		}
+	}
+
+	if pods == nil {
+		return nil, nil
+	}
+
+	r := &ReservedReport{
+		Reserved:   reserved,
+		SystemUsed: int64(*total) - int64(*pods),
+		Verdict:    "ok",
+	}
+
+	switch {
+	case r.SystemUsed > r.Reserved:
+		r.Verdict = "too-small"
+	case float64(r.SystemUsed) < float64(r.Reserved)*reservedWasteRatio:
+		r.Verdict = "too-large"
+	}
+
+	return r, nil
+}
@@ -0,0 +1,103 @@
+package kubecap
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// reportResources are the resources a report can be about.
+var reportResources = []corev1.ResourceName{
+	corev1.ResourceMemory,
+	corev1.ResourceCPU,
+	corev1.ResourceEphemeralStorage,
+}
+
+// ResourceName returns the resource the report is about: memory unless
+// another is chosen.
+func (md *Metadata) ResourceName() corev1.ResourceName {
+	if md.Resource == "" {
+		return corev1.ResourceMemory
+	}
+
+	return corev1.ResourceName(md.Resource)
+}
+
+// Unit is the unit of the report's amounts.
+func (md *Metadata) Unit() string {
+	if md.ResourceName() == corev1.ResourceCPU {
+		return "millicores"
+	}
+
+	return "bytes"
+}
+
+// ResourceValue returns the quantity of the resource as an amount:
+// millicores for CPU and bytes otherwise.
+func ResourceValue(name corev1.ResourceName, q resource.Quantity) int64 {
+	if name == corev1.ResourceCPU {
+		return q.MilliValue()
+	}
+
+	return q.Value()
+}
+
+// ParseAmount parses an amount of the resource: a quantity of CPU (e.g. 500m
+// or 2) or a number of bytes (e.g. 32GiB or 32Gi).
+func ParseAmount(name corev1.ResourceName, s string) (int64, error) {
+	if name == corev1.ResourceCPU {
+		q, err := resource.ParseQuantity(s)
+		if err != nil {
+			return 0, fmt.Errorf("cpu amount %q: %w", s, err)
+		}
+
+		return q.MilliValue(), nil
+	}
+
+	b, err := humanize.ParseBytes(s)
+	if err != nil {
+		// Kubernetes quantities lack the B of humanize's binary units.
+		q, qerr := resource.ParseQuantity(s)
+		if qerr != nil {
+			return 0, err
+		}
+
+		return q.Value(), nil
+	}
+
+	return int64(b), nil
+}
+
+// ParseAdditional parses the additional amount: an amount of the resource
+// (see ParseAmount) or, ending in %, a percentage of each node's allocatable
+// amount (e.g. 10%) returned as percent instead.
+func ParseAdditional(name corev1.ResourceName, s string) (amount int64, percent float64, err error) {
+	if p := strings.TrimSpace(s); strings.HasSuffix(p, "%") {
+		percent, err = strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(p, "%")), 64)
+		if err != nil || percent < 0 || math.IsInf(percent, 0) || math.IsNaN(percent) {
+			return 0, 0, fmt.Errorf("additional percentage %q: want a non-negative percentage", s)
+		}
+
+		return 0, percent, nil
+	}
+
+	amount, err = ParseAmount(name, s)
+
+	return amount, 0, err
+}
+
+// CheckResource returns an error unless the resource can be reported on.
+func CheckResource(name corev1.ResourceName) error {
+	for _, r := range reportResources {
+		if name == r {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown resource: %q", name)
+}
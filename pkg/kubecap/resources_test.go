@@ -0,0 +1,65 @@
+package kubecap
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseAmount(t *testing.T) {
+	for _, tc := range []struct {
+		name corev1.ResourceName
+		in   string
+		want int64
+	}{
+		{corev1.ResourceMemory, "1 GiB", 1 << 30},
+		{corev1.ResourceMemory, "1Gi", 1 << 30},
+		{corev1.ResourceMemory, "0", 0},
+		{corev1.ResourceEphemeralStorage, "10GB", 10e9},
+		{corev1.ResourceCPU, "500m", 500},
+		{corev1.ResourceCPU, "2", 2000},
+	} {
+		got, err := ParseAmount(tc.name, tc.in)
+		if err != nil {
+			t.Errorf("%s %q: %v", tc.name, tc.in, err)
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("%s %q = %d, want %d", tc.name, tc.in, got, tc.want)
+		}
+	}
+
+	_, err := ParseAmount(corev1.ResourceCPU, "1 GiB")
+	if err == nil {
+		t.Error("cpu amount in bytes parsed")
+	}
+}
+
+func TestParseAdditional(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		amount  int64
+		percent float64
+	}{
+		{"2Gi", 2 << 30, 0},
+		{"10%", 0, 10},
+		{" 2.5 %", 0, 2.5},
+	} {
+		amount, percent, err := ParseAdditional(corev1.ResourceMemory, tc.in)
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+
+		if amount != tc.amount || percent != tc.percent {
+			t.Errorf("%q = %d, %g; want %d, %g", tc.in, amount, percent, tc.amount, tc.percent)
+		}
+	}
+
+	for _, bad := range []string{"-5%", "%", "NaN%", "ten%"} {
+		if _, _, err := ParseAdditional(corev1.ResourceMemory, bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
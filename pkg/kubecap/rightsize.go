@@ -0,0 +1,184 @@
+package kubecap
+
+import (
+	"sort"
+)
+
+// RightSizing is a node group's current packing and how its pods would pack
+// onto nodes of each candidate size.
+type RightSizing struct {
+	Cluster string `json:"cluster"`
+	Group   string `json:"group"`
+
+	// Nodes, Allocatable and Requests are the group's nodes, their total
+	// allocatable amount and their pods' requests, and Waste the allocatable
+	// amount left unrequested.
+	Nodes       int64 `json:"nodes"`
+	Allocatable int64 `json:"allocatable"`
+	Requests    int64 `json:"requests"`
+	Waste       int64 `json:"waste"`
+
+	// DaemonSetRequests is the requests of the DaemonSet pods on each node
+	// (the mean across the group's nodes), repeated on every node.
+	DaemonSetRequests int64 `json:"daemonSetRequests"`
+
+	Candidates []*RightSizeCandidate `json:"candidates"`
+}
+
+// Packing is the share of the group's allocatable amount requested.
+func (r *RightSizing) Packing() float64 {
+	if r.Allocatable <= 0 {
+		return 0
+	}
+
+	return float64(r.Requests) / float64(r.Allocatable)
+}
+
+// RightSizeCandidate is the group's pods packed onto nodes of a candidate
+// size (allocatable amount).
+type RightSizeCandidate struct {
+	Size int64 `json:"size"`
+
+	// Fits is whether the largest pod fits on a node of the size alongside
+	// the DaemonSet pods. The rest is only projected when it does.
+	Fits bool `json:"fits"`
+
+	// Nodes is the projected node count and Waste the allocatable amount
+	// left unrequested on them.
+	Nodes int64 `json:"nodes"`
+	Waste int64 `json:"waste"`
+
+	// Packing is the share of the projected nodes' allocatable amount
+	// requested and Tighter how much tighter (or looser, negative) that is
+	// than the group's current packing, as a percentage.
+	Packing float64 `json:"packing"`
+	Tighter float64 `json:"tighter"`
+}
+
+// packNodes returns how many nodes with capacity free for pods the requests
+// pack onto, first fit decreasing. The requests must each fit on a node.
+func packNodes(requests []int64, capacity int64) int64 {
+	sorted := append([]int64{}, requests...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	free := []int64{}
+
+	for _, r := range sorted {
+		placed := false
+
+		for i := range free {
+			if free[i] >= r {
+				free[i] -= r
+				placed = true
+
+				break
+			}
+		}
+
+		if !placed {
+			free = append(free, capacity-r)
+		}
+	}
+
+	return int64(len(free))
+}
+
+// RightSize projects each node group's pods onto nodes of each of the sizes,
+// sorted by cluster and group. DaemonSet pods run on every node, so each
+// projected node carries the group's mean DaemonSet requests. Excluded nodes
+// and finished pods are left out.
+func RightSize(nodes []*NodeReport, sizes []int64) []*RightSizing {
+	type group struct {
+		r         *RightSizing
+		requests  []int64
+		daemonSet int64
+		largest   int64
+	}
+
+	groups := map[string]*group{}
+	sizings := []*RightSizing{}
+
+	for _, n := range nodes {
+		if n.Excluded {
+			continue
+		}
+
+		key := n.Cluster + "/" + n.Group
+
+		g, ok := groups[key]
+		if !ok {
+			g = &group{r: &RightSizing{Cluster: n.Cluster, Group: n.Group}}
+			groups[key] = g
+			sizings = append(sizings, g.r)
+		}
+
+		g.r.Nodes++
+		g.r.Allocatable += n.Allocatable
+
+		for _, p := range n.Pods {
+			if p.State == PodStateFinished {
+				continue
+			}
+
+			g.r.Requests += p.Requests
+
+			if p.WorkloadKind == "DaemonSet" {
+				g.daemonSet += p.Requests
+
+				continue
+			}
+
+			g.requests = append(g.requests, p.Requests)
+
+			if p.Requests > g.largest {
+				g.largest = p.Requests
+			}
+		}
+	}
+
+	for _, g := range groups {
+		r := g.r
+
+		r.Waste = r.Allocatable - r.Requests
+		r.DaemonSetRequests = g.daemonSet / r.Nodes
+
+		for _, size := range sizes {
+			c := &RightSizeCandidate{Size: size}
+			r.Candidates = append(r.Candidates, c)
+
+			capacity := size - r.DaemonSetRequests
+			if capacity <= 0 || g.largest > capacity {
+				continue
+			}
+
+			c.Fits = true
+
+			c.Nodes = packNodes(g.requests, capacity)
+			if c.Nodes == 0 {
+				c.Nodes = 1
+			}
+
+			requests := c.Nodes * r.DaemonSetRequests
+			for _, req := range g.requests {
+				requests += req
+			}
+
+			c.Waste = c.Nodes*size - requests
+			c.Packing = float64(requests) / float64(c.Nodes*size)
+
+			if current := r.Packing(); current > 0 {
+				c.Tighter = (c.Packing/current - 1) * 100
+			}
+		}
+	}
+
+	sort.Slice(sizings, func(i, j int) bool {
+		if sizings[i].Cluster != sizings[j].Cluster {
+			return sizings[i].Cluster < sizings[j].Cluster
+		}
+
+		return sizings[i].Group < sizings[j].Group
+	})
+
+	return sizings
+}
@@ -0,0 +1,48 @@
+package kubecap
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRightSize(t *testing.T) {
+	pod := func(gi int64, kind string) *PodReport {
+		return &PodReport{Requests: gi << 30, WorkloadKind: kind, State: podStateRunning}
+	}
+
+	nodes := []*NodeReport{
+		{Name: "a", Group: "general", Allocatable: 16 << 30, Pods: []*PodReport{pod(6, "Deployment"), pod(5, "StatefulSet"), pod(1, "DaemonSet")}},
+		{Name: "b", Group: "general", Allocatable: 16 << 30, Pods: []*PodReport{pod(3, ""), pod(1, "DaemonSet"), {Requests: 8 << 30, State: PodStateFinished}}},
+		{Name: "c", Group: "general", Allocatable: 16 << 30, Excluded: true, Pods: []*PodReport{pod(16, "")}},
+	}
+
+	sizings := RightSize(nodes, []int64{4 << 30, 8 << 30, 16 << 30})
+	if len(sizings) != 1 {
+		t.Fatalf("sizings = %d", len(sizings))
+	}
+
+	r := sizings[0]
+	if r.Nodes != 2 || r.Requests != 16<<30 || r.Waste != 16<<30 || r.DaemonSetRequests != 1<<30 || r.Packing() != 0.5 {
+		t.Errorf("current = %+v", r)
+	}
+
+	// 4Gi nodes can't hold the 6Gi pod, 8Gi nodes hold one of the larger
+	// pods each and a 16Gi node holds them all.
+	want := []RightSizeCandidate{
+		{Size: 4 << 30},
+		{Size: 8 << 30, Fits: true, Nodes: 3, Waste: 7 << 30, Packing: 17.0 / 24, Tighter: (17.0/24/0.5 - 1) * 100},
+		{Size: 16 << 30, Fits: true, Nodes: 1, Waste: 1 << 30, Packing: 15.0 / 16, Tighter: 87.5},
+	}
+
+	for i, c := range r.Candidates {
+		// Tighter is compared apart as the constant is more precise.
+		got := *c
+		if math.Abs(got.Tighter-want[i].Tighter) < 1e-9 {
+			got.Tighter = want[i].Tighter
+		}
+
+		if got != want[i] {
+			t.Errorf("candidate %d = %+v, want %+v", i, *c, want[i])
+		}
+	}
+}
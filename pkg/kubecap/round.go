@@ -0,0 +1,76 @@
+package kubecap
+
+// roundTo rounds the amount to the nearest multiple of step.
+func roundTo(amount, step int64) int64 {
+	if step <= 0 {
+		return amount
+	}
+
+	half := step / 2
+	if amount < 0 {
+		return -((-amount + half) / step * step)
+	}
+
+	return (amount + half) / step * step
+}
+
+// Rounded returns a copy of the node's report for sharing outside the
+// platform team: its amounts are rounded to the nearest multiple of step and
+// its pod-level detail (the pods, stuck and unmatched pods, containers at
+// risk and the pods of an overcommit) is dropped. Verdicts stand as decided
+// on the exact amounts.
+func (n *NodeReport) Rounded(step int64) *NodeReport {
+	r := *n
+
+	for _, amount := range []*int64{
+		&r.Allocatable,
+		&r.Used,
+		&r.UsedRaw,
+		&r.Free,
+		&r.Requests,
+		&r.Schedulable,
+		&r.FreeWithAdditional,
+		&r.SchedulableWithAdditional,
+		&r.Reserving,
+		&r.Margin,
+		&r.PendingRequests,
+		&r.NominatedRequests,
+		&r.TerminatingRequests,
+		&r.StuckRequests,
+		&r.Limits,
+	} {
+		*amount = roundTo(*amount, step)
+	}
+
+	r.Pods = nil
+	r.StuckTerminating = nil
+	r.UnmatchedPods = nil
+	r.LimitRisks = nil
+
+	if n.Overcommitted != nil {
+		r.Overcommitted = &OvercommitReport{
+			Requests:    roundTo(n.Overcommitted.Requests, step),
+			Allocatable: roundTo(n.Overcommitted.Allocatable, step),
+			Excess:      roundTo(n.Overcommitted.Excess, step),
+			Pods:        []*OvercommitPod{},
+		}
+	}
+
+	if n.Cordon != nil {
+		c := *n.Cordon
+		c.Held = roundTo(c.Held, step)
+		r.Cordon = &c
+	}
+
+	if len(n.PriorityClasses) > 0 {
+		r.PriorityClasses = []*PriorityClassRequests{}
+
+		for _, pc := range n.PriorityClasses {
+			c := *pc
+			c.Requests = roundTo(c.Requests, step)
+			r.PriorityClasses = append(r.PriorityClasses, &c)
+		}
+	}
+
+	return &r
+}
@@ -0,0 +1,60 @@
+package kubecap
+
+import (
+	"testing"
+)
+
+func TestRoundTo(t *testing.T) {
+	for _, tc := range []struct {
+		amount, step, want int64
+	}{
+		{0, 256, 0},
+		{127, 256, 0},
+		{128, 256, 256},
+		{1000, 256, 1024},
+		{-1000, 256, -1024},
+		{1000, 0, 1000},
+	} {
+		if got := roundTo(tc.amount, tc.step); got != tc.want {
+			t.Errorf("roundTo(%d, %d) = %d, want %d", tc.amount, tc.step, got, tc.want)
+		}
+	}
+}
+
+func TestNodeReportRounded(t *testing.T) {
+	const mi = 1 << 20
+
+	n := &NodeReport{
+		Name:             "a",
+		Allocatable:      8000 * mi,
+		Used:             3000 * mi,
+		Schedulable:      -100 * mi,
+		Ok:               true,
+		Pods:             []*PodReport{{Namespace: "shop", Name: "web"}},
+		StuckTerminating: []*StuckPod{{}},
+		UnmatchedPods:    []string{"shop/web"},
+		Overcommitted: &OvercommitReport{
+			Excess: 100 * mi,
+			Pods:   []*OvercommitPod{{Namespace: "shop", Name: "web"}},
+		},
+	}
+
+	r := n.Rounded(256 * mi)
+
+	if r.Allocatable != 7936*mi || r.Used != 3072*mi || r.Schedulable != 0 {
+		t.Errorf("allocatable, used, schedulable = %d, %d, %d", r.Allocatable/mi, r.Used/mi, r.Schedulable/mi)
+	}
+
+	if r.Pods != nil || r.StuckTerminating != nil || r.UnmatchedPods != nil || len(r.Overcommitted.Pods) != 0 {
+		t.Errorf("pod-level detail kept: %v, %v, %v, %v", r.Pods, r.StuckTerminating, r.UnmatchedPods, r.Overcommitted.Pods)
+	}
+
+	if r.Overcommitted.Excess != 0 || !r.Ok || r.Name != "a" {
+		t.Errorf("excess, ok, name = %d, %t, %s", r.Overcommitted.Excess, r.Ok, r.Name)
+	}
+
+	// The original is left as it is.
+	if n.Allocatable != 8000*mi || len(n.Pods) != 1 || len(n.Overcommitted.Pods) != 1 {
+		t.Errorf("original modified: %+v", n)
+	}
+}
@@ -0,0 +1,181 @@
+package kubecap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// schedulerFilterResult is the annotation the kube-scheduler-simulator
+	// records each node's filter plugin results in: node name to plugin name
+	// to "passed" or the reason the node was filtered out.
+	schedulerFilterResult = "kube-scheduler-simulator.sigs.k8s.io/filter-result"
+
+	// schedulerPassed is the filter plugin result for nodes that pass.
+	schedulerPassed = "passed"
+
+	// schedulerProbeNamespace is where probe pods are created.
+	schedulerProbeNamespace = metav1.NamespaceDefault
+)
+
+// SchedulerFit is the kube-scheduler's verdict on a pod of the additional
+// amount, as evaluated by the kube-scheduler-simulator.
+type SchedulerFit struct {
+	// Node is the node the pod was scheduled to, if any, and NominatedNode
+	// the node it would preempt pods on, if any.
+	Node          string `json:"node,omitempty"`
+	NominatedNode string `json:"nominatedNode,omitempty"`
+
+	// Message is why the pod couldn't be scheduled, if it couldn't.
+	Message string `json:"message,omitempty"`
+
+	// Filtered are the reasons each node filtered out failed for (by
+	// plugin). Nodes passing every filter aren't included.
+	Filtered map[string][]string `json:"filtered,omitempty"`
+}
+
+// fits reports whether a pod of the additional amount passes the scheduler's
+// filters on the node.
+func (f *SchedulerFit) fits(node string) bool {
+	_, ok := f.Filtered[node]
+
+	return !ok
+}
+
+// String describes where the pod was scheduled or why it wasn't.
+func (f *SchedulerFit) String() string {
+	switch {
+	case f.Node != "":
+		return "scheduled to " + f.Node
+	case f.NominatedNode != "":
+		return fmt.Sprintf("preempting on %s (%s)", f.NominatedNode, f.Message)
+	}
+
+	return "unschedulable (" + f.Message + ")"
+}
+
+// parseFilterResult sets Filtered from the simulator's filter result
+// annotation.
+func (f *SchedulerFit) parseFilterResult(annotation string) error {
+	results := map[string]map[string]string{}
+
+	err := json.Unmarshal([]byte(annotation), &results)
+	if err != nil {
+		return fmt.Errorf("scheduler filter result: %w", err)
+	}
+
+	f.Filtered = map[string][]string{}
+
+	for node, plugins := range results {
+		for plugin, result := range plugins {
+			if result != schedulerPassed {
+				f.Filtered[node] = append(f.Filtered[node], plugin+": "+result)
+			}
+		}
+
+		sort.Strings(f.Filtered[node])
+	}
+
+	return nil
+}
+
+// ProbeSchedulerFit asks the kube-scheduler running in a
+// kube-scheduler-simulator whether and where a pod requesting additional
+// memory (at priorityClass, if given) schedules. It creates a probe pod in the simulator, waits up to
+// timeout for the scheduler to decide and deletes it again. Since the probe
+// pod would really run elsewhere, a scheduler not recording filter results
+// (i.e. not the simulator's) is an error.
+func ProbeSchedulerFit(ctx context.Context, kcs kubernetes.Interface, additional int64, priorityClass string, timeout time.Duration) (f *SchedulerFit, err error) {
+	ctx, span := tracer.Start(ctx, "scheduler fit", trace.WithAttributes(
+		attribute.Int64("kubecap.additional", additional),
+	))
+	defer func() { endSpan(span, err) }()
+
+	pods := kcs.CoreV1().Pods(schedulerProbeNamespace)
+
+	probe, err := pods.Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubecap-probe-",
+			Labels:       map[string]string{"app.kubernetes.io/name": "kubecap-probe"},
+		},
+		Spec: corev1.PodSpec{
+			PriorityClassName: priorityClass,
+			Containers: []corev1.Container{{
+				Name:  "probe",
+				Image: "registry.k8s.io/pause:3.9",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceMemory: *resource.NewQuantity(additional, resource.BinarySI),
+					},
+				},
+			}},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("scheduler simulator: create probe pod: %w", err)
+	}
+
+	defer func() {
+		derr := pods.Delete(context.Background(), probe.Name, metav1.DeleteOptions{})
+		if err == nil && derr != nil {
+			err = fmt.Errorf("scheduler simulator: delete probe pod: %w", derr)
+		}
+	}()
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		pod, err := pods.Get(ctx, probe.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("scheduler simulator: get probe pod: %w", err)
+		}
+
+		f = &SchedulerFit{
+			Node:          pod.Spec.NodeName,
+			NominatedNode: pod.Status.NominatedNodeName,
+		}
+
+		unschedulable := false
+		for _, c := range pod.Status.Conditions {
+			if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable {
+				unschedulable = true
+				f.Message = c.Message
+			}
+		}
+
+		result, ok := pod.Annotations[schedulerFilterResult]
+
+		if ok && (f.Node != "" || unschedulable) {
+			err = f.parseFilterResult(result)
+			if err != nil {
+				return nil, err
+			}
+
+			return f, nil
+		}
+
+		if f.Node != "" {
+			return nil, fmt.Errorf("scheduler simulator: probe pod scheduled to %s without filter results; is this a kube-scheduler-simulator?", f.Node)
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("scheduler simulator: probe pod not scheduled within %s", timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
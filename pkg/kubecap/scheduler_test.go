@@ -0,0 +1,108 @@
+package kubecap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeSchedulerSimulator returns a clientset whose probe pods are scheduled
+// as given on creation.
+func fakeSchedulerSimulator(schedule func(pod *corev1.Pod)) *fake.Clientset {
+	kcs := fake.NewSimpleClientset()
+
+	kcs.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.Name = pod.GenerateName + "x"
+		schedule(pod)
+
+		return false, nil, nil
+	})
+
+	return kcs
+}
+
+func TestSchedulerFit(t *testing.T) {
+	kcs := fakeSchedulerSimulator(func(pod *corev1.Pod) {
+		pod.Annotations = map[string]string{
+			schedulerFilterResult: `{
+				"node-a": {"NodeName": "passed", "NodeResourcesFit": "Insufficient memory", "TaintToleration": "node(s) had untolerated taint"},
+				"node-b": {"NodeName": "passed", "NodeResourcesFit": "passed"}
+			}`,
+		}
+		pod.Spec.NodeName = "node-b"
+	})
+
+	f, err := ProbeSchedulerFit(context.Background(), kcs, 4<<30, "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Node != "node-b" || f.String() != "scheduled to node-b" {
+		t.Errorf("node, verdict = %q, %q", f.Node, f)
+	}
+
+	if f.fits("node-a") || !f.fits("node-b") {
+		t.Errorf("fits node-a, node-b = %t, %t", f.fits("node-a"), f.fits("node-b"))
+	}
+
+	want := []string{"NodeResourcesFit: Insufficient memory", "TaintToleration: node(s) had untolerated taint"}
+	if got := f.Filtered["node-a"]; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("node-a reasons = %q, want %q", got, want)
+	}
+
+	// The probe pod is deleted.
+	pods, err := kcs.CoreV1().Pods(schedulerProbeNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pods.Items) != 0 {
+		t.Errorf("probe pods left: %d", len(pods.Items))
+	}
+}
+
+func TestSchedulerFitUnschedulable(t *testing.T) {
+	kcs := fakeSchedulerSimulator(func(pod *corev1.Pod) {
+		pod.Annotations = map[string]string{
+			schedulerFilterResult: `{"node-a": {"NodeResourcesFit": "Insufficient memory"}}`,
+		}
+		pod.Status.NominatedNodeName = "node-a"
+		pod.Status.Conditions = []corev1.PodCondition{{
+			Type:    corev1.PodScheduled,
+			Status:  corev1.ConditionFalse,
+			Reason:  corev1.PodReasonUnschedulable,
+			Message: "0/1 nodes are available: 1 Insufficient memory.",
+		}}
+	})
+
+	f, err := ProbeSchedulerFit(context.Background(), kcs, 4<<30, "high", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := f.String(), "preempting on node-a (0/1 nodes are available: 1 Insufficient memory.)"; got != want {
+		t.Errorf("verdict = %q, want %q", got, want)
+	}
+
+	if f.fits("node-a") {
+		t.Errorf("node-a fits")
+	}
+}
+
+func TestSchedulerFitNotSimulator(t *testing.T) {
+	kcs := fakeSchedulerSimulator(func(pod *corev1.Pod) {
+		pod.Spec.NodeName = "node-a"
+	})
+
+	_, err := ProbeSchedulerFit(context.Background(), kcs, 4<<30, "", time.Second)
+	if err == nil {
+		t.Errorf("scheduler without filter results accepted")
+	}
+}
@@ -0,0 +1,64 @@
+package kubecap
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ZoneLabel is the well-known label holding the node's zone.
+const ZoneLabel = "topology.kubernetes.io/zone"
+
+// scopeRequirement is a KEY=VALUE requirement of a scope.
+type scopeRequirement struct {
+	key, value string
+}
+
+// Scope is the nodes the additional amount is checked on: those matching all
+// of its requirements.
+type Scope []scopeRequirement
+
+// ParseScope parses comma separated KEY=VALUE requirements a node must all
+// match to be in scope. The keys zone and nodegroup are shorthands for the
+// zone label and the node's group; any other key is a node label.
+func ParseScope(s string) (Scope, error) {
+	sc := Scope{}
+
+	for _, req := range strings.Split(s, ",") {
+		parts := strings.SplitN(req, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("scope %q: want KEY=VALUE", req)
+		}
+
+		sc = append(sc, scopeRequirement{
+			key:   strings.TrimSpace(parts[0]),
+			value: strings.TrimSpace(parts[1]),
+		})
+	}
+
+	return sc, nil
+}
+
+// matches returns whether the node, in the given node group, is in scope.
+// Every node is in an empty scope.
+func (sc Scope) matches(node *corev1.Node, group string) bool {
+	for _, req := range sc {
+		var value string
+
+		switch req.key {
+		case "zone":
+			value = node.Labels[ZoneLabel]
+		case "nodegroup":
+			value = group
+		default:
+			value = node.Labels[req.key]
+		}
+
+		if value != req.value {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,50 @@
+package kubecap
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScope(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				ZoneLabel: "us-east-1a",
+				"tier":    "web",
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		in      string
+		matches bool
+	}{
+		{"zone=us-east-1a", true},
+		{"zone=us-east-1b", false},
+		{"nodegroup=general", true},
+		{"nodegroup=batch", false},
+		{"zone=us-east-1a, tier=web", true},
+		{"zone=us-east-1a,tier=db", false},
+	} {
+		sc, err := ParseScope(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := sc.matches(node, "general"); got != tc.matches {
+			t.Errorf("%s: matches = %t, want %t", tc.in, got, tc.matches)
+		}
+	}
+
+	for _, bad := range []string{"zone", "=us-east-1a", "zone=a,"} {
+		if _, err := ParseScope(bad); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+
+	if !Scope(nil).matches(node, "") {
+		t.Error("empty scope doesn't match")
+	}
+}
@@ -0,0 +1,90 @@
+package kubecap
+
+import (
+	"sort"
+)
+
+// shapeBounds are the upper bounds (exclusive) of the pod shape buckets
+// named by ShapeBuckets; the last bucket is unbounded.
+var (
+	shapeBounds  = []int64{256 << 20, 1 << 30, 4 << 30}
+	ShapeBuckets = []string{"<256Mi", "256Mi-1Gi", "1-4Gi", ">=4Gi"}
+)
+
+// ShapeHistogram buckets a node group's pods by their memory requests.
+type ShapeHistogram struct {
+	Cluster string `json:"cluster"`
+	Group   string `json:"group"`
+	Nodes   int64  `json:"nodes"`
+
+	// Buckets names the buckets Pods and Requests count the pods and sum
+	// their requests in.
+	Buckets  []string `json:"buckets"`
+	Pods     []int64  `json:"pods"`
+	Requests []int64  `json:"requests"`
+
+	// Largest is the largest pod's requests: a pod of this shape needs that
+	// much schedulable memory on a single node.
+	Largest int64 `json:"largest"`
+}
+
+// shapeBucket returns the bucket the requests fall in.
+func shapeBucket(requests int64) int {
+	for i, bound := range shapeBounds {
+		if requests < bound {
+			return i
+		}
+	}
+
+	return len(shapeBounds)
+}
+
+// PodShapes returns the pod shape histogram of each node group on the nodes,
+// sorted by cluster and group. Finished pods are left out.
+func PodShapes(nodes []*NodeReport) []*ShapeHistogram {
+	histograms := map[string]*ShapeHistogram{}
+	shapes := []*ShapeHistogram{}
+
+	for _, n := range nodes {
+		key := n.Cluster + "/" + n.Group
+
+		h, ok := histograms[key]
+		if !ok {
+			h = &ShapeHistogram{
+				Cluster:  n.Cluster,
+				Group:    n.Group,
+				Buckets:  ShapeBuckets,
+				Pods:     make([]int64, len(ShapeBuckets)),
+				Requests: make([]int64, len(ShapeBuckets)),
+			}
+			histograms[key] = h
+			shapes = append(shapes, h)
+		}
+
+		h.Nodes++
+
+		for _, p := range n.Pods {
+			if p.State == PodStateFinished {
+				continue
+			}
+
+			b := shapeBucket(p.Requests)
+			h.Pods[b]++
+			h.Requests[b] += p.Requests
+
+			if p.Requests > h.Largest {
+				h.Largest = p.Requests
+			}
+		}
+	}
+
+	sort.Slice(shapes, func(i, j int) bool {
+		if shapes[i].Cluster != shapes[j].Cluster {
+			return shapes[i].Cluster < shapes[j].Cluster
+		}
+
+		return shapes[i].Group < shapes[j].Group
+	})
+
+	return shapes
+}
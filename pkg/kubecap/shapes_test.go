@@ -0,0 +1,37 @@
+package kubecap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPodShapes(t *testing.T) {
+	nodes := []*NodeReport{
+		{Cluster: "c", Group: "large", Pods: []*PodReport{
+			{Requests: 8 << 30},
+			{Requests: 1 << 30},
+			{Requests: 16 << 30, State: PodStateFinished},
+		}},
+		{Cluster: "c", Group: "", Pods: []*PodReport{
+			{Requests: 0},
+			{Requests: 255 << 20},
+			{Requests: 256 << 20},
+		}},
+		{Cluster: "c", Group: "large", Pods: []*PodReport{
+			{Requests: 4 << 30},
+		}},
+	}
+
+	shapes := PodShapes(nodes)
+	if len(shapes) != 2 || shapes[0].Group != "" || shapes[1].Group != "large" {
+		t.Fatalf("shapes = %+v", shapes)
+	}
+
+	if s := shapes[0]; !reflect.DeepEqual(s.Pods, []int64{2, 1, 0, 0}) || s.Nodes != 1 || s.Largest != 256<<20 {
+		t.Errorf("ungrouped = %+v", s)
+	}
+
+	if s := shapes[1]; !reflect.DeepEqual(s.Pods, []int64{0, 0, 1, 2}) || s.Requests[3] != 12<<30 || s.Nodes != 2 || s.Largest != 8<<30 {
+		t.Errorf("large = %+v", s)
+	}
+}
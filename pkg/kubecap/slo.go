@@ -0,0 +1,265 @@
+package kubecap
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// allScopes is the scope of an SLO's status across all of its scopes at once.
+const allScopes = "all"
+
+// unzoned is the name used for the nodes without a zone label.
+const unzoned = "unzoned"
+
+// SLO is a capacity objective: at least Min (a fraction) of the allocatable
+// amount schedulable in every scope, the whole cluster, each zone or each node
+// group, Target (a fraction) of the time.
+type SLO struct {
+	Name   string  `json:"name"`
+	By     string  `json:"by"`
+	Min    float64 `json:"min"`
+	Target float64 `json:"target"`
+}
+
+// ParseSLO parses an SLO given as [BY:]MIN%@TARGET%, e.g. zone:15%@99% for at
+// least 15% of the allocatable amount schedulable in every zone 99% of the
+// time. BY is cluster (the default), zone or nodegroup.
+func ParseSLO(s string) (*SLO, error) {
+	slo := &SLO{Name: s, By: "cluster"}
+
+	spec := s
+	if i := strings.Index(spec, ":"); i >= 0 {
+		slo.By, spec = strings.TrimSpace(spec[:i]), spec[i+1:]
+	}
+
+	switch slo.By {
+	case "cluster", "zone", "nodegroup":
+	default:
+		return nil, fmt.Errorf("slo %q: unknown scope %q (one of cluster, zone or nodegroup)", s, slo.By)
+	}
+
+	parts := strings.SplitN(spec, "@", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("slo %q: want [BY:]MIN%%@TARGET%%", s)
+	}
+
+	for _, p := range []struct {
+		s string
+		v *float64
+	}{{parts[0], &slo.Min}, {parts[1], &slo.Target}} {
+		percent := strings.TrimSpace(p.s)
+		if !strings.HasSuffix(percent, "%") {
+			return nil, fmt.Errorf("slo %q: want a percentage: %q", s, percent)
+		}
+
+		v, err := strconv.ParseFloat(strings.TrimSuffix(percent, "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("slo %q: %w", s, err)
+		}
+
+		*p.v = v / 100
+	}
+
+	if slo.Min <= 0 || slo.Min > 1 {
+		return nil, fmt.Errorf("slo %q: the minimum must be above 0%% and at most 100%%", s)
+	}
+
+	// An error budget needs some room for error.
+	if slo.Target <= 0 || slo.Target >= 1 {
+		return nil, fmt.Errorf("slo %q: the target must be above 0%% and below 100%%", s)
+	}
+
+	return slo, nil
+}
+
+// scope returns the scope of the SLO the node is in.
+func (slo *SLO) scope(n *NodeReport) string {
+	switch slo.By {
+	case "zone":
+		if n.Zone == "" {
+			return unzoned
+		}
+
+		return n.Zone
+	case "nodegroup":
+		if n.Group == "" {
+			return ungrouped
+		}
+
+		return n.Group
+	}
+
+	return allScopes
+}
+
+// SLOStatus is an SLO's state in one of its scopes, or in all of them at once
+// (all): whether the report meets it and, tracked across reports, how often
+// it was met over the window (Samples reports, Good of which met it) and the
+// fraction of the error budget left, which is negative once overspent.
+type SLOStatus struct {
+	Cluster string  `json:"cluster"`
+	SLO     string  `json:"slo"`
+	Scope   string  `json:"scope"`
+	Target  float64 `json:"target"`
+
+	Allocatable int64   `json:"allocatable"`
+	Schedulable int64   `json:"schedulable"`
+	Ratio       float64 `json:"ratio"`
+	Met         bool    `json:"met"`
+
+	Samples     int64   `json:"samples,omitempty"`
+	Good        int64   `json:"good,omitempty"`
+	Compliance  float64 `json:"compliance,omitempty"`
+	ErrorBudget float64 `json:"errorBudget,omitempty"`
+}
+
+// SetCompliance sets the status' compliance and error budget left from the
+// samples, good of which met the SLO.
+func (s *SLOStatus) SetCompliance(samples, good int64) {
+	s.Samples, s.Good = samples, good
+	s.Compliance, s.ErrorBudget = 0, 0
+
+	if samples == 0 {
+		return
+	}
+
+	s.Compliance = float64(good) / float64(samples)
+	s.ErrorBudget = 1 - float64(samples-good)/(float64(samples)*(1-s.Target))
+}
+
+// EvaluateSLOs returns the status of each SLO in each of its scopes, sorted by
+// scope, followed by its status in all of them at once.
+func EvaluateSLOs(cluster string, slos []*SLO, nodes []*NodeReport) []*SLOStatus {
+	statuses := []*SLOStatus{}
+
+	for _, slo := range slos {
+		byScope := map[string][]*NodeReport{}
+		for _, n := range nodes {
+			scope := slo.scope(n)
+			byScope[scope] = append(byScope[scope], n)
+		}
+
+		scopes := make([]string, 0, len(byScope))
+		for scope := range byScope {
+			scopes = append(scopes, scope)
+		}
+
+		sort.Strings(scopes)
+
+		all := &SLOStatus{
+			Cluster: cluster,
+			SLO:     slo.Name,
+			Scope:   allScopes,
+			Target:  slo.Target,
+			Met:     true,
+		}
+
+		for _, scope := range scopes {
+			s := Summarize(byScope[scope])
+
+			status := &SLOStatus{
+				Cluster:     cluster,
+				SLO:         slo.Name,
+				Scope:       scope,
+				Target:      slo.Target,
+				Allocatable: s.Allocatable,
+				Schedulable: s.Schedulable,
+			}
+
+			if s.Allocatable > 0 {
+				status.Ratio = float64(s.Schedulable) / float64(s.Allocatable)
+			}
+
+			status.Met = status.Ratio >= slo.Min
+
+			all.Allocatable += status.Allocatable
+			all.Schedulable += status.Schedulable
+			all.Met = all.Met && status.Met
+
+			// A cluster wide SLO has the one scope.
+			if scope != allScopes {
+				statuses = append(statuses, status)
+			}
+		}
+
+		if all.Allocatable > 0 {
+			all.Ratio = float64(all.Schedulable) / float64(all.Allocatable)
+		}
+
+		statuses = append(statuses, all)
+	}
+
+	return statuses
+}
+
+// sloKey identifies an SLO's scope in a cluster.
+type sloKey struct {
+	cluster, slo, scope string
+}
+
+// sloSample is whether an SLO was met at a time.
+type sloSample struct {
+	at  time.Time
+	met bool
+}
+
+// SLOTracker tracks whether the SLOs were met across the reports of a watch,
+// and of the history it is seeded with, for their compliance over the window.
+// It is safe for concurrent use, so one tracker serves several clusters.
+type SLOTracker struct {
+	mu      sync.Mutex
+	window  time.Duration
+	samples map[sloKey][]sloSample
+}
+
+func NewSLOTracker(window time.Duration) *SLOTracker {
+	return &SLOTracker{
+		window:  window,
+		samples: map[sloKey][]sloSample{},
+	}
+}
+
+// Record adds a past sample, e.g. from the stored history.
+func (t *SLOTracker) Record(cluster, slo, scope string, at time.Time, met bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	k := sloKey{cluster, slo, scope}
+	t.samples[k] = append(t.samples[k], sloSample{at, met})
+}
+
+// track records the statuses as sampled at and sets their compliance over
+// the window ending then.
+func (t *SLOTracker) track(statuses []*SLOStatus, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := at.Add(-t.window)
+
+	for _, s := range statuses {
+		k := sloKey{s.Cluster, s.SLO, s.Scope}
+
+		kept := t.samples[k][:0]
+		for _, sample := range t.samples[k] {
+			if sample.at.After(cutoff) {
+				kept = append(kept, sample)
+			}
+		}
+
+		kept = append(kept, sloSample{at, s.Met})
+		t.samples[k] = kept
+
+		good := int64(0)
+		for _, sample := range kept {
+			if sample.met {
+				good++
+			}
+		}
+
+		s.SetCompliance(int64(len(kept)), good)
+	}
+}
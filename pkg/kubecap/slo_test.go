@@ -0,0 +1,106 @@
+package kubecap
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseSLO(t *testing.T) {
+	slo, err := ParseSLO("zone:15%@99.5%")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slo.Name != "zone:15%@99.5%" || slo.By != "zone" || math.Abs(slo.Min-0.15) > 1e-9 || math.Abs(slo.Target-0.995) > 1e-9 {
+		t.Errorf("slo = %+v", slo)
+	}
+
+	slo, err = ParseSLO("10%@90%")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slo.By != "cluster" {
+		t.Errorf("by = %q, want cluster", slo.By)
+	}
+
+	for _, bad := range []string{"rack:10%@90%", "10%", "10@90%", "0%@90%", "10%@100%", "x%@90%"} {
+		if _, err := ParseSLO(bad); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
+
+func TestEvaluateSLOs(t *testing.T) {
+	byZone, err := ParseSLO("zone:20%@99%")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, err := ParseSLO("20%@99%")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := EvaluateSLOs("prod", []*SLO{byZone, cluster}, []*NodeReport{
+		{Name: "a", Zone: "us-east-1a", Allocatable: 100, Schedulable: 50},
+		{Name: "b", Zone: "us-east-1b", Allocatable: 100, Schedulable: 10},
+		{Name: "c", Allocatable: 100, Schedulable: 30},
+	})
+
+	want := []struct {
+		slo, scope string
+		met        bool
+	}{
+		{byZone.Name, unzoned, true},
+		{byZone.Name, "us-east-1a", true},
+		{byZone.Name, "us-east-1b", false},
+		{byZone.Name, allScopes, false},
+		{cluster.Name, allScopes, true},
+	}
+
+	if len(statuses) != len(want) {
+		t.Fatalf("statuses = %d, want %d", len(statuses), len(want))
+	}
+
+	for i, w := range want {
+		s := statuses[i]
+		if s.Cluster != "prod" || s.SLO != w.slo || s.Scope != w.scope || s.Met != w.met {
+			t.Errorf("status %d = %+v, want %+v", i, s, w)
+		}
+	}
+
+	if all := statuses[4]; all.Allocatable != 300 || all.Schedulable != 90 || math.Abs(all.Ratio-0.3) > 1e-9 {
+		t.Errorf("cluster = %+v", all)
+	}
+}
+
+func TestSLOTracker(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tr := NewSLOTracker(time.Hour)
+
+	// Seeded from history: one sample falls out of the window.
+	tr.Record("prod", "slo", allScopes, start.Add(-2*time.Hour), false)
+	tr.Record("prod", "slo", allScopes, start.Add(-30*time.Minute), false)
+
+	for i := 0; i < 8; i++ {
+		tr.Record("prod", "slo", allScopes, start.Add(-time.Duration(i)*time.Minute), true)
+	}
+
+	s := &SLOStatus{Cluster: "prod", SLO: "slo", Scope: allScopes, Target: 0.9, Met: true}
+	tr.track([]*SLOStatus{s}, start)
+
+	// 9 of 10 samples met it: the whole 10% budget is spent.
+	if s.Samples != 10 || s.Good != 9 || math.Abs(s.Compliance-0.9) > 1e-9 || math.Abs(s.ErrorBudget) > 1e-9 {
+		t.Errorf("status = %+v", s)
+	}
+
+	// Other clusters are tracked apart.
+	other := &SLOStatus{Cluster: "dev", SLO: "slo", Scope: allScopes, Target: 0.9}
+	tr.track([]*SLOStatus{other}, start)
+
+	if other.Samples != 1 || other.Good != 0 || other.ErrorBudget > -8 {
+		t.Errorf("other = %+v", other)
+	}
+}
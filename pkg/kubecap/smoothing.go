@@ -0,0 +1,52 @@
+package kubecap
+
+import (
+	"time"
+)
+
+// UsageSmoother smooths each node's memory usage across the reports of a
+// watch with an exponential moving average, so a single noisy sample doesn't
+// flap alerts.
+type UsageSmoother struct {
+	// alpha is the weight of the newest sample.
+	alpha float64
+
+	averages map[string]smoothedUsage
+}
+
+type smoothedUsage struct {
+	average float64
+	seen    time.Time
+}
+
+func NewUsageSmoother(alpha float64) *UsageSmoother {
+	return &UsageSmoother{
+		alpha:    alpha,
+		averages: map[string]smoothedUsage{},
+	}
+}
+
+// smooth adds the node's usage sampled at ts and returns its average. The
+// first sample starts the average.
+func (s *UsageSmoother) smooth(node string, used int64, ts time.Time) int64 {
+	avg, ok := s.averages[node]
+	if !ok {
+		avg.average = float64(used)
+	} else {
+		avg.average = s.alpha*float64(used) + (1-s.alpha)*avg.average
+	}
+
+	avg.seen = ts
+	s.averages[node] = avg
+
+	return int64(avg.average)
+}
+
+// prune forgets the nodes not sampled at ts, i.e. gone since.
+func (s *UsageSmoother) prune(ts time.Time) {
+	for node, avg := range s.averages {
+		if !avg.seen.Equal(ts) {
+			delete(s.averages, node)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package kubecap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageSmoother(t *testing.T) {
+	s := NewUsageSmoother(0.5)
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	if got := s.smooth("a", 100, t0); got != 100 {
+		t.Errorf("first = %d, want 100", got)
+	}
+
+	s.smooth("b", 10, t0)
+
+	t1 := t0.Add(time.Minute)
+
+	if got := s.smooth("a", 300, t1); got != 200 {
+		t.Errorf("second = %d, want 200", got)
+	}
+
+	s.prune(t1)
+
+	if _, ok := s.averages["b"]; ok {
+		t.Errorf("b not pruned")
+	}
+
+	if got := s.smooth("b", 50, t1); got != 50 {
+		t.Errorf("b restarted at %d, want 50", got)
+	}
+}
@@ -0,0 +1,286 @@
+package kubecap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Standby is the warm standby capacity required for a priority tier: room
+// for Replicas pods of Amount each, of the PriorityClass, at all times, e.g.
+// for failover or burst of critical workloads. Its pods may preempt pods of
+// lower priority, as the scheduler would.
+type Standby struct {
+	Name          string `json:"name"`
+	PriorityClass string `json:"priorityClass"`
+	Replicas      int64  `json:"replicas"`
+	Amount        int64  `json:"amount"`
+}
+
+// ParseStandby parses a standby given as CLASS:REPLICASxAMOUNT, e.g.
+// critical:10x2Gi for room for 10 pods of the critical PriorityClass
+// requesting 2Gi each, amounts being of resource rn.
+func ParseStandby(rn corev1.ResourceName, s string) (*Standby, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return nil, fmt.Errorf("standby %q: want CLASS:REPLICASxAMOUNT", s)
+	}
+
+	sizing := strings.SplitN(parts[1], "x", 2)
+	if len(sizing) != 2 {
+		return nil, fmt.Errorf("standby %q: want CLASS:REPLICASxAMOUNT", s)
+	}
+
+	replicas, err := strconv.ParseInt(strings.TrimSpace(sizing[0]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("standby %q: %w", s, err)
+	}
+
+	amount, err := ParseAmount(rn, strings.TrimSpace(sizing[1]))
+	if err != nil {
+		return nil, fmt.Errorf("standby %q: %w", s, err)
+	}
+
+	if replicas < 1 || amount <= 0 {
+		return nil, fmt.Errorf("standby %q: the replicas and amount must be positive", s)
+	}
+
+	return &Standby{
+		Name:          s,
+		PriorityClass: strings.TrimSpace(parts[0]),
+		Replicas:      replicas,
+		Amount:        amount,
+	}, nil
+}
+
+// ParseStandbys parses the standbys, at most one per PriorityClass since
+// each is reported and alerted on by class.
+func ParseStandbys(rn corev1.ResourceName, specs []string) ([]*Standby, error) {
+	standbys := []*Standby{}
+	classes := map[string]bool{}
+
+	for _, spec := range specs {
+		s, err := ParseStandby(rn, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if classes[s.PriorityClass] {
+			return nil, fmt.Errorf("standby %q: PriorityClass %s has a standby already", spec, s.PriorityClass)
+		}
+
+		classes[s.PriorityClass] = true
+		standbys = append(standbys, s)
+	}
+
+	return standbys, nil
+}
+
+// StandbyReport is whether a standby exists: how many of its pods the nodes
+// have room for, after the pods placed and the reservations, and after the
+// standby of the higher priority tiers.
+type StandbyReport struct {
+	Cluster       string `json:"cluster,omitempty"`
+	Name          string `json:"name"`
+	PriorityClass string `json:"priorityClass"`
+
+	// Priority is the PriorityClass' value, nil if the class isn't found;
+	// its pods then preempt none.
+	Priority *int32 `json:"priority,omitempty"`
+
+	Amount    int64 `json:"amount"`
+	Replicas  int64 `json:"replicas"`
+	Available int64 `json:"available"`
+	Ok        bool  `json:"ok"`
+
+	// Preempted is the amount requested by the pods of lower priority the
+	// standby's pods would preempt.
+	Preempted int64 `json:"preempted"`
+
+	// Nodes are the standby's pods fitting on each node.
+	Nodes map[string]int64 `json:"nodes,omitempty"`
+}
+
+// standbyPriorities returns the value of each standby's PriorityClass, taken
+// from the pods of the class or read from the API server, and missing for
+// classes that don't exist.
+func standbyPriorities(ctx context.Context, kcs kubernetes.Interface, md *Metadata, snap *snapshot) (map[string]int32, error) {
+	priorities := map[string]int32{}
+
+	for _, pods := range snap.nps {
+		for _, pod := range pods {
+			if pod.Spec.PriorityClassName != "" && pod.Spec.Priority != nil {
+				priorities[pod.Spec.PriorityClassName] = *pod.Spec.Priority
+			}
+		}
+	}
+
+	for _, s := range md.Standby {
+		if _, ok := priorities[s.PriorityClass]; ok {
+			continue
+		}
+
+		pc, err := kcs.SchedulingV1().PriorityClasses().Get(ctx, s.PriorityClass, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		priorities[s.PriorityClass] = pc.Value
+	}
+
+	return priorities, nil
+}
+
+// placeStandby fits the pods of each standby on the nodes, the highest
+// priority tier first, each on the node with the most room for it as the
+// scheduler spreads pods: the schedulable amount left after the reservations
+// and, past it, the requests of the pods of lower priority, lowest first.
+// Nodes cordoned or excluded from the totals don't take any.
+func placeStandby(md *Metadata, snap *snapshot, priorities map[string]int32) []*StandbyReport {
+	rn := md.ResourceName()
+
+	// victim is a pod the standby's pods may preempt.
+	type victim struct {
+		priority int32
+		requests int64
+	}
+
+	names := make([]string, 0, len(snap.nodes))
+	free := map[string]int64{}
+	victims := map[string][]*victim{}
+
+	for name, node := range snap.nodes {
+		if node.Spec.Unschedulable || (!NodeReady(node) && !md.IncludeNotReady) {
+			continue
+		}
+
+		requests, _, _, _ := weightedRequests(md, snap.nps[name], snap.podLevel)
+
+		names = append(names, name)
+
+		// An overcommitted node has no room until pods are evicted.
+		free[name] = ResourceValue(rn, node.Status.Allocatable[rn]) - requests - snap.reserving[name]
+		if free[name] < 0 {
+			free[name] = 0
+		}
+
+		for _, pod := range snap.nps[name] {
+			v := &victim{requests: int64(md.StateWeight(PodState(pod)) * float64(snap.podLevel.Requests(pod, rn)))}
+			if pod.Spec.Priority != nil {
+				v.priority = *pod.Spec.Priority
+			}
+
+			if v.requests > 0 {
+				victims[name] = append(victims[name], v)
+			}
+		}
+
+		sort.SliceStable(victims[name], func(i, j int) bool {
+			return victims[name][i].priority < victims[name][j].priority
+		})
+	}
+
+	sort.Strings(names)
+
+	reports := []*StandbyReport{}
+
+	for _, s := range md.Standby {
+		r := &StandbyReport{
+			Cluster:       md.Context,
+			Name:          s.Name,
+			PriorityClass: s.PriorityClass,
+			Amount:        s.Amount,
+			Replicas:      s.Replicas,
+		}
+
+		if p, ok := priorities[s.PriorityClass]; ok {
+			r.Priority = &p
+		}
+
+		reports = append(reports, r)
+	}
+
+	order := make([]*StandbyReport, len(reports))
+	copy(order, reports)
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return standbyPriority(order[i]) > standbyPriority(order[j])
+	})
+
+	for _, r := range order {
+		// room is what a pod of the standby can have on the node: its
+		// free room and the requests of the pods it may preempt.
+		room := func(name string) int64 {
+			n := free[name]
+
+			for _, v := range victims[name] {
+				if r.Priority == nil || v.priority >= *r.Priority {
+					break
+				}
+
+				n += v.requests
+			}
+
+			return n
+		}
+
+		for i := int64(0); i < r.Replicas; i++ {
+			best := ""
+
+			for _, name := range names {
+				if room(name) >= r.Amount && (best == "" || room(name) > room(best)) {
+					best = name
+				}
+			}
+
+			if best == "" {
+				break
+			}
+
+			// Pods are evicted whole, lowest priority first, until the
+			// pod fits; the room was checked, so only pods of lower
+			// priority are. What they free beyond the pod stays free.
+			for free[best] < r.Amount {
+				v := victims[best][0]
+				victims[best] = victims[best][1:]
+
+				free[best] += v.requests
+				r.Preempted += v.requests
+			}
+
+			free[best] -= r.Amount
+
+			if r.Nodes == nil {
+				r.Nodes = map[string]int64{}
+			}
+
+			r.Nodes[best]++
+			r.Available++
+		}
+
+		r.Ok = r.Available >= r.Replicas
+	}
+
+	return reports
+}
+
+// standbyPriority returns the standby's priority, those of classes not found
+// counting as the lowest.
+func standbyPriority(r *StandbyReport) int64 {
+	if r.Priority == nil {
+		return -1 << 40
+	}
+
+	return int64(*r.Priority)
+}
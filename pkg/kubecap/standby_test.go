@@ -0,0 +1,110 @@
+package kubecap
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseStandby(t *testing.T) {
+	s, err := ParseStandby(corev1.ResourceMemory, "critical:10x2Gi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.PriorityClass != "critical" || s.Replicas != 10 || s.Amount != 2<<30 {
+		t.Errorf("standby = %+v", s)
+	}
+
+	for _, spec := range []string{"critical", "critical:10", ":1x1Gi", "critical:0x1Gi", "critical:1x-1Gi", "critical:ax1Gi"} {
+		if _, err := ParseStandby(corev1.ResourceMemory, spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+
+	if _, err := ParseStandbys(corev1.ResourceMemory, []string{"critical:1x1Gi", "critical:2x1Gi"}); err == nil {
+		t.Error("a PriorityClass with two standbys parsed")
+	}
+}
+
+func TestPlaceStandby(t *testing.T) {
+	node := func(name, memory string, cordoned bool) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.NodeSpec{Unschedulable: cordoned},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(memory)},
+				Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+
+	pod := func(name, class string, priority int32, memory string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: corev1.PodSpec{
+				PriorityClassName: class,
+				Priority:          &priority,
+				Containers: []corev1.Container{{
+					Name: "main",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(memory)},
+					},
+				}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+
+	snap := &snapshot{
+		nodes: map[string]*corev1.Node{
+			"node-a": node("node-a", "16Gi", false),
+			"node-b": node("node-b", "8Gi", false),
+			"node-c": node("node-c", "64Gi", true),
+		},
+		nps: NodePods{
+			"node-a": {pod("batch", "", 0, "8Gi"), pod("web", "", 1000, "6Gi")},
+			"node-b": {pod("db", "critical", 100000, "6Gi")},
+		},
+	}
+
+	standbys, err := ParseStandbys(corev1.ResourceMemory, []string{"missing:1x1Gi", "low:2x4Gi", "critical:3x2Gi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := &Metadata{Context: "test", Standby: standbys}
+
+	// The missing class doesn't exist.
+	reports := placeStandby(md, snap, map[string]int32{"critical": 100000, "low": 500})
+	if len(reports) != 3 {
+		t.Fatalf("%d reports", len(reports))
+	}
+
+	missing, low, critical := reports[0], reports[1], reports[2]
+
+	// The critical tier comes first: node-a's free 2Gi, then evicting the
+	// whole 8Gi batch pod for the other two. The db pod on node-b is of the
+	// same priority.
+	if critical.Available != 3 || !critical.Ok || critical.Preempted != 8<<30 {
+		t.Errorf("critical = %+v", critical)
+	}
+
+	if want := map[string]int64{"node-a": 3}; !reflect.DeepEqual(critical.Nodes, want) {
+		t.Errorf("critical nodes = %v, want %v", critical.Nodes, want)
+	}
+
+	// What the batch pod's eviction left fits one, the web pod being of
+	// higher priority.
+	if low.Available != 1 || low.Ok || low.Preempted != 0 || *low.Priority != 500 {
+		t.Errorf("low = %+v", low)
+	}
+
+	// Without its class, the standby only has the free room on node-b.
+	if missing.Priority != nil || !missing.Ok || missing.Preempted != 0 || missing.Nodes["node-b"] != 1 {
+		t.Errorf("missing = %+v", missing)
+	}
+}
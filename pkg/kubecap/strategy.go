@@ -0,0 +1,224 @@
+package kubecap
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EvictionCandidate is a pod with containers over their requests that
+// evicting frees the whole requests and usage of, with what eviction ranking
+// strategies weigh.
+type EvictionCandidate struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Priority  int32  `json:"priority"`
+
+	// OverRequests is how much its evictable containers use above their
+	// requests.
+	OverRequests int64 `json:"overRequests"`
+
+	Requests int64 `json:"requests"`
+	Used     int64 `json:"used"`
+
+	// Restarts is its evictable containers' restarts and Created when it
+	// was created.
+	Restarts int32     `json:"restarts"`
+	Created  time.Time `json:"created"`
+
+	// DisruptionsAllowed is the fewest more disruptions the
+	// PodDisruptionBudgets covering it allow, or -1 when none cover it.
+	DisruptionsAllowed int32 `json:"disruptionsAllowed"`
+
+	// Replicas is how many pods its workload runs, 1 for a pod without a
+	// controller.
+	Replicas int32 `json:"replicas"`
+}
+
+// DisruptionScore is how disruptive evicting the candidate is, the higher
+// the worse. Every eviction costs 1, plus:
+//
+//   - the share of its workload's capacity lost: 1/replicas;
+//   - 1 more for a singleton, which is down until it is rescheduled;
+//   - how close its PodDisruptionBudgets are to blocking evictions:
+//     1/(allowed+1) when any cover it;
+//   - its priority, 1 per billion (the highest user defined priority).
+func (c *EvictionCandidate) DisruptionScore() float64 {
+	replicas := c.Replicas
+	if replicas < 1 {
+		replicas = 1
+	}
+
+	score := 1 + 1/float64(replicas)
+
+	if replicas == 1 {
+		score++
+	}
+
+	if c.DisruptionsAllowed >= 0 {
+		score += 1 / float64(c.DisruptionsAllowed+1)
+	}
+
+	if c.Priority > 0 {
+		score += float64(c.Priority) / 1e9
+	}
+
+	return score
+}
+
+// Freed is the memory evicting the candidate frees: the larger of its
+// requests and its usage.
+func (c *EvictionCandidate) Freed() int64 {
+	if c.Used > c.Requests {
+		return c.Used
+	}
+
+	return c.Requests
+}
+
+// disruptionPerByte is the candidate's disruption score per byte evicting it
+// frees, +Inf when it frees nothing.
+func disruptionPerByte(c *EvictionCandidate) float64 {
+	if c.Freed() <= 0 {
+		return math.Inf(1)
+	}
+
+	return c.DisruptionScore() / float64(c.Freed())
+}
+
+// EvictionStrategy ranks eviction candidates: Less reports whether a is
+// evicted before b.
+type EvictionStrategy interface {
+	Less(a, b *EvictionCandidate) bool
+}
+
+// EvictionStrategyFunc adapts a function to an EvictionStrategy.
+type EvictionStrategyFunc func(a, b *EvictionCandidate) bool
+
+func (f EvictionStrategyFunc) Less(a, b *EvictionCandidate) bool {
+	return f(a, b)
+}
+
+// DefaultEvictionStrategy is the strategy used unless another is chosen.
+const DefaultEvictionStrategy = "lowest-priority"
+
+// byOverRequests is the tie-breaker of the strategies: the largest over its
+// requests, which frees the most, first.
+func byOverRequests(a, b *EvictionCandidate) bool {
+	return a.OverRequests > b.OverRequests
+}
+
+// evictionStrategies are the strategies by name.
+var evictionStrategies = map[string]EvictionStrategy{
+	// lowest-priority evicts the lowest priority pods first, as the
+	// scheduler preempts them.
+	"lowest-priority": EvictionStrategyFunc(func(a, b *EvictionCandidate) bool {
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+
+		return byOverRequests(a, b)
+	}),
+
+	// largest-overage evicts the pods furthest over their requests first,
+	// so the fewest pods are evicted.
+	"largest-overage": EvictionStrategyFunc(func(a, b *EvictionCandidate) bool {
+		if a.OverRequests != b.OverRequests {
+			return a.OverRequests > b.OverRequests
+		}
+
+		return a.Priority < b.Priority
+	}),
+
+	// most-restarts evicts the pods restarting the most first: they are
+	// disrupted already.
+	"most-restarts": EvictionStrategyFunc(func(a, b *EvictionCandidate) bool {
+		if a.Restarts != b.Restarts {
+			return a.Restarts > b.Restarts
+		}
+
+		return byOverRequests(a, b)
+	}),
+
+	// youngest evicts the newest pods first, which have the least warmed
+	// up state to lose.
+	"youngest": EvictionStrategyFunc(func(a, b *EvictionCandidate) bool {
+		if !a.Created.Equal(b.Created) {
+			return a.Created.After(b.Created)
+		}
+
+		return byOverRequests(a, b)
+	}),
+
+	// least-pdb-risk evicts the pods no PodDisruptionBudget covers first,
+	// then those whose budgets allow the most more disruptions.
+	"least-pdb-risk": EvictionStrategyFunc(func(a, b *EvictionCandidate) bool {
+		if (a.DisruptionsAllowed < 0) != (b.DisruptionsAllowed < 0) {
+			return a.DisruptionsAllowed < 0
+		}
+
+		if a.DisruptionsAllowed != b.DisruptionsAllowed {
+			return a.DisruptionsAllowed > b.DisruptionsAllowed
+		}
+
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+
+		return byOverRequests(a, b)
+	}),
+
+	// least-disruption evicts the pods with the lowest disruption score
+	// (see DisruptionScore) per byte freed first: large pods of workloads
+	// with many replicas and slack in their budgets before singletons.
+	"least-disruption": EvictionStrategyFunc(func(a, b *EvictionCandidate) bool {
+		if da, db := disruptionPerByte(a), disruptionPerByte(b); da != db {
+			return da < db
+		}
+
+		return byOverRequests(a, b)
+	}),
+}
+
+// RegisterEvictionStrategy makes the strategy selectable by name, replacing
+// any registered under it.
+func RegisterEvictionStrategy(name string, s EvictionStrategy) {
+	evictionStrategies[name] = s
+}
+
+// EvictionStrategies returns the names of the strategies, sorted.
+func EvictionStrategies() []string {
+	names := make([]string, 0, len(evictionStrategies))
+	for name := range evictionStrategies {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// EvictionStrategyNamed returns the named strategy, the default when name is
+// empty.
+func EvictionStrategyNamed(name string) (EvictionStrategy, error) {
+	if name == "" {
+		name = DefaultEvictionStrategy
+	}
+
+	s, ok := evictionStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown eviction strategy %q (one of %s)", name, strings.Join(EvictionStrategies(), ", "))
+	}
+
+	return s, nil
+}
+
+// RankEvictionCandidates sorts the candidates in the order the strategy
+// evicts them.
+func RankEvictionCandidates(candidates []*EvictionCandidate, s EvictionStrategy) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return s.Less(candidates[i], candidates[j])
+	})
+}
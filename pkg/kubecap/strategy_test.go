@@ -0,0 +1,105 @@
+package kubecap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRankEvictionCandidates(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	candidates := []*EvictionCandidate{
+		{Pod: "a", Priority: 100, OverRequests: 4, Restarts: 0, Created: now.Add(-time.Hour), DisruptionsAllowed: -1},
+		{Pod: "b", Priority: 0, OverRequests: 1, Restarts: 7, Created: now.Add(-48 * time.Hour), DisruptionsAllowed: 0},
+		{Pod: "c", Priority: 0, OverRequests: 2, Restarts: 1, Created: now, DisruptionsAllowed: 3},
+	}
+
+	for _, tc := range []struct {
+		strategy string
+		want     []string
+	}{
+		{"", []string{"c", "b", "a"}},
+		{"lowest-priority", []string{"c", "b", "a"}},
+		{"largest-overage", []string{"a", "c", "b"}},
+		{"most-restarts", []string{"b", "c", "a"}},
+		{"youngest", []string{"c", "a", "b"}},
+		{"least-pdb-risk", []string{"a", "c", "b"}},
+	} {
+		s, err := EvictionStrategyNamed(tc.strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ranked := append([]*EvictionCandidate{}, candidates...)
+		RankEvictionCandidates(ranked, s)
+
+		got := []string{}
+		for _, c := range ranked {
+			got = append(got, c.Pod)
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %v, want %v", tc.strategy, got, tc.want)
+		}
+	}
+
+	if _, err := EvictionStrategyNamed("random"); err == nil {
+		t.Error("unknown strategy: no error")
+	}
+}
+
+func TestRegisterEvictionStrategy(t *testing.T) {
+	RegisterEvictionStrategy("test-by-name", EvictionStrategyFunc(func(a, b *EvictionCandidate) bool {
+		return a.Pod < b.Pod
+	}))
+	defer delete(evictionStrategies, "test-by-name")
+
+	s, err := EvictionStrategyNamed("test-by-name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	candidates := []*EvictionCandidate{{Pod: "b"}, {Pod: "a"}}
+	RankEvictionCandidates(candidates, s)
+
+	if candidates[0].Pod != "a" {
+		t.Errorf("registered strategy not used: %s first", candidates[0].Pod)
+	}
+}
+
+func TestDisruptionScore(t *testing.T) {
+	candidates := []*EvictionCandidate{
+		// A singleton: 1 + 1 + 1.
+		{Pod: "single", Requests: 4 << 30, Used: 4 << 30, Replicas: 1, DisruptionsAllowed: -1},
+		// One of four replicas with a budget allowing one more: 1 +
+		// 1/4 + 1/2.
+		{Pod: "replica", Requests: 2 << 30, Used: 1 << 30, Replicas: 4, DisruptionsAllowed: 1},
+		// One of two, frees the most: 1 + 1/2 plus its priority.
+		{Pod: "large", Requests: 4 << 30, Used: 8 << 30, Replicas: 2, DisruptionsAllowed: -1, Priority: 500000000},
+		{Pod: "empty", Replicas: 8, DisruptionsAllowed: -1},
+	}
+
+	for i, want := range []float64{3, 1.75, 2} {
+		if got := candidates[i].DisruptionScore(); got != want {
+			t.Errorf("%s: score = %v, want %v", candidates[i].Pod, got, want)
+		}
+	}
+
+	s, err := EvictionStrategyNamed("least-disruption")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Per GiB freed: large 0.25, replica 0.875, single 0.75.
+	RankEvictionCandidates(candidates, s)
+
+	got := []string{}
+	for _, c := range candidates {
+		got = append(got, c.Pod)
+	}
+
+	if want := []string{"large", "single", "replica", "empty"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package kubecap
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// untainted is the name used for the pool of nodes without a pool taint.
+const untainted = "untainted"
+
+// nodeTaintPool returns the dedicated pool the node belongs to: the first of
+// its NoSchedule or NoExecute taints with one of the keys (in the keys'
+// order), as key=value:effect. It is empty when the node has none.
+func nodeTaintPool(node *corev1.Node, keys []string) string {
+	for _, key := range keys {
+		for _, taint := range node.Spec.Taints {
+			if taint.Key != key || taint.Effect == corev1.TaintEffectPreferNoSchedule {
+				continue
+			}
+
+			return taint.ToString()
+		}
+	}
+
+	return ""
+}
+
+// TaintPoolReport totals the nodes of a dedicated pool: nodes sharing a pool
+// taint, whose capacity is only available to the workloads tolerating it.
+type TaintPoolReport struct {
+	Cluster string `json:"cluster"`
+	Pool    string `json:"pool"`
+	Summary
+
+	// Ok is whether the additional amount fits on any of the pool's
+	// nodes.
+	Ok bool `json:"ok"`
+}
+
+// SummarizeTaintPools totals the nodes by cluster and taint pool, sorted by
+// cluster and pool with the untainted nodes last in each cluster.
+func SummarizeTaintPools(nodes []*NodeReport) []*TaintPoolReport {
+	type key struct{ cluster, pool string }
+
+	byPool := map[key][]*NodeReport{}
+
+	for _, n := range nodes {
+		pool := n.TaintPool
+		if pool == "" {
+			pool = untainted
+		}
+
+		k := key{n.Cluster, pool}
+		byPool[k] = append(byPool[k], n)
+	}
+
+	pools := make([]*TaintPoolReport, 0, len(byPool))
+	for k, ns := range byPool {
+		s := Summarize(ns)
+
+		pools = append(pools, &TaintPoolReport{
+			Cluster: k.cluster,
+			Pool:    k.pool,
+			Summary: s,
+			Ok:      s.OkNodes > 0,
+		})
+	}
+
+	sort.Slice(pools, func(i, j int) bool {
+		if pools[i].Cluster != pools[j].Cluster {
+			return pools[i].Cluster < pools[j].Cluster
+		}
+
+		if (pools[i].Pool == untainted) != (pools[j].Pool == untainted) {
+			return pools[j].Pool == untainted
+		}
+
+		return pools[i].Pool < pools[j].Pool
+	})
+
+	return pools
+}
@@ -0,0 +1,56 @@
+package kubecap
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNodeTaintPool(t *testing.T) {
+	node := &corev1.Node{Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+		{Key: "team", Value: "search", Effect: corev1.TaintEffectPreferNoSchedule},
+		{Key: "dedicated", Value: "ml", Effect: corev1.TaintEffectNoSchedule},
+	}}}
+
+	for _, tc := range []struct {
+		keys []string
+		want string
+	}{
+		{[]string{"dedicated"}, "dedicated=ml:NoSchedule"},
+		{[]string{"team", "dedicated"}, "dedicated=ml:NoSchedule"},
+		{[]string{"gpu"}, ""},
+		{nil, ""},
+	} {
+		if got := nodeTaintPool(node, tc.keys); got != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.keys, got, tc.want)
+		}
+	}
+}
+
+func TestSummarizeTaintPools(t *testing.T) {
+	pools := SummarizeTaintPools([]*NodeReport{
+		{Name: "node-a", Allocatable: 16, Schedulable: 4},
+		{Name: "node-b", TaintPool: "dedicated=ml:NoSchedule", Allocatable: 32, Schedulable: 8},
+		{Name: "node-c", TaintPool: "dedicated=ml:NoSchedule", Allocatable: 32, Schedulable: 16, Ok: true},
+		{Name: "node-d", TaintPool: "dedicated=batch:NoSchedule", Allocatable: 8},
+	})
+
+	if len(pools) != 3 {
+		t.Fatalf("pools = %d, want 3", len(pools))
+	}
+
+	for i, want := range []string{"dedicated=batch:NoSchedule", "dedicated=ml:NoSchedule", untainted} {
+		if pools[i].Pool != want {
+			t.Errorf("pool %d = %q, want %q", i, pools[i].Pool, want)
+		}
+	}
+
+	ml := pools[1]
+	if ml.Nodes != 2 || ml.Allocatable != 64 || ml.Schedulable != 24 || ml.OkNodes != 1 || !ml.Ok {
+		t.Errorf("ml pool = %+v", ml)
+	}
+
+	if pools[0].Ok || pools[2].Ok {
+		t.Errorf("pools without ok nodes are ok: %+v, %+v", pools[0], pools[2])
+	}
+}
@@ -0,0 +1,86 @@
+package kubecap
+
+import (
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// ThresholdProfiles are named free margins required of the nodes matching
+// their node selector, e.g. 20% for ingress nodes and 2% for batch nodes. The
+// first profile matching a node applies; nodes matching none need no margin.
+type ThresholdProfiles struct {
+	Profiles []*ThresholdProfile `json:"profiles"`
+}
+
+// ThresholdProfile is the share of its allocatable amount a node must keep
+// free and schedulable after the additional amount.
+type ThresholdProfile struct {
+	Name           string  `json:"name"`
+	NodeSelector   string  `json:"nodeSelector"`
+	MinFreePercent float64 `json:"minFreePercent"`
+
+	selector labels.Selector
+}
+
+// LoadThresholdProfiles reads the threshold profiles file.
+func LoadThresholdProfiles(path string) (*ThresholdProfiles, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := &ThresholdProfiles{}
+
+	err = yaml.UnmarshalStrict(data, tp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, p := range tp.Profiles {
+		if p.MinFreePercent < 0 || p.MinFreePercent > 100 {
+			return nil, fmt.Errorf("%s: profile %s: minFreePercent must be between 0 and 100: %g", path, p.Name, p.MinFreePercent)
+		}
+
+		p.selector, err = labels.Parse(p.NodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("%s: profile %s: %w", path, p.Name, err)
+		}
+	}
+
+	return tp, nil
+}
+
+// of returns the profile applying to the node, if any.
+func (tp *ThresholdProfiles) of(node *corev1.Node) *ThresholdProfile {
+	if tp == nil {
+		return nil
+	}
+
+	for _, p := range tp.Profiles {
+		if p.selector.Matches(labels.Set(node.Labels)) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Margin is the amount a node with the allocatable amount must keep free.
+func (p *ThresholdProfile) Margin(allocatable int64) int64 {
+	return int64(float64(allocatable) * p.MinFreePercent / 100)
+}
+
+// minSchedulable is the least schedulable amount the nodes summed up need to
+// keep: the sum of their profiles' margins when any has a profile and min
+// otherwise.
+func (s Summary) minSchedulable(min int64) int64 {
+	if s.Profiled > 0 {
+		return s.Margin
+	}
+
+	return min
+}
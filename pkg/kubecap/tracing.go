@@ -0,0 +1,22 @@
+package kubecap
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer traces the collection phases with the global tracer provider, so
+// spans are dropped unless the program installs one (as the kubecap command
+// does given an OTLP endpoint).
+var tracer = otel.Tracer("github.com/calebcase/kubecap")
+
+// endSpan records err (if any) on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,113 @@
+package kubecap
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// VerdictRules are an organization's own definitions of a healthy node and of
+// sufficient cluster headroom, replacing the built-in verdicts. Each is an
+// expression as for columns, with comparisons (< <= > >= == !=), && || and !,
+// true when not 0: a subset of CEL, e.g. ok && pressure < 80 for the nodes
+// and okNodes >= 3 && schedulable > allocatable * 0.2 for the cluster.
+//
+// Node rules see the columns' fields, ok being the built-in verdict. Cluster
+// rules see the report's totals: nodes, okNodes (by the node rule, if any),
+// allocatable, used, requests, schedulable and additional.
+type VerdictRules struct {
+	Node    string `json:"node,omitempty"`
+	Cluster string `json:"cluster,omitempty"`
+
+	node, cluster columnExpr
+}
+
+// clusterFields are the report totals cluster rules can refer to.
+var clusterFields = map[string]func(s *Summary, md *Metadata) float64{
+	"nodes":       func(s *Summary, md *Metadata) float64 { return float64(s.Nodes) },
+	"okNodes":     func(s *Summary, md *Metadata) float64 { return float64(s.OkNodes) },
+	"allocatable": func(s *Summary, md *Metadata) float64 { return float64(s.Allocatable) },
+	"used":        func(s *Summary, md *Metadata) float64 { return float64(s.Used) },
+	"requests":    func(s *Summary, md *Metadata) float64 { return float64(s.Requests) },
+	"schedulable": func(s *Summary, md *Metadata) float64 { return float64(s.Schedulable) },
+	"additional":  func(s *Summary, md *Metadata) float64 { return float64(md.Additional) },
+}
+
+// ParseVerdictRules parses the node and cluster rules, either of which may be
+// empty to keep the built-in verdict.
+func ParseVerdictRules(node, cluster string) (*VerdictRules, error) {
+	v := &VerdictRules{Node: node, Cluster: cluster}
+
+	var err error
+
+	if node != "" {
+		v.node, err = (&columnParser{s: node, fields: fieldSet(columnFields)}).parse()
+		if err != nil {
+			return nil, fmt.Errorf("node rule: %w", err)
+		}
+	}
+
+	if cluster != "" {
+		fields := map[string]bool{}
+		for name := range clusterFields {
+			fields[name] = true
+		}
+
+		v.cluster, err = (&columnParser{s: cluster, fields: fields}).parse()
+		if err != nil {
+			return nil, fmt.Errorf("cluster rule: %w", err)
+		}
+	}
+
+	return v, nil
+}
+
+// LoadVerdictRules reads the rules from a YAML file with node and cluster
+// keys.
+func LoadVerdictRules(path string) (*VerdictRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &VerdictRules{}
+
+	err = yaml.UnmarshalStrict(data, v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	v, err = ParseVerdictRules(v.Node, v.Cluster)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return v, nil
+}
+
+// nodeOk returns the node's verdict by the node rule, or its built-in one
+// without a rule.
+func (v *VerdictRules) nodeOk(n *NodeReport, md *Metadata) bool {
+	if v == nil || v.node == nil {
+		return n.Ok
+	}
+
+	return v.node.eval(md.nodeFields(n)) != 0
+}
+
+// clusterOk returns the cluster's verdict by the cluster rule over the
+// nodes, or nil without a rule.
+func (v *VerdictRules) clusterOk(nodes []*NodeReport, md *Metadata) *bool {
+	if v == nil || v.cluster == nil {
+		return nil
+	}
+
+	s := Summarize(nodes)
+
+	ok := v.cluster.eval(func(name string) float64 {
+		return clusterFields[name](&s, md)
+	}) != 0
+
+	return &ok
+}
@@ -0,0 +1,52 @@
+package kubecap
+
+import (
+	"testing"
+)
+
+func TestVerdictRules(t *testing.T) {
+	v, err := ParseVerdictRules("ok && pressure < 80", "okNodes >= 2 || schedulable > additional * 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := &Metadata{Additional: 10}
+
+	nodes := []*NodeReport{
+		{Name: "calm", Ok: true, Pressure: 20, Schedulable: 50},
+		{Name: "pressured", Ok: true, Pressure: 90, Schedulable: 40},
+		{Name: "full", Ok: false, Pressure: 10},
+	}
+
+	for _, n := range nodes {
+		n.Ok = v.nodeOk(n, md)
+	}
+
+	if !nodes[0].Ok || nodes[1].Ok || nodes[2].Ok {
+		t.Errorf("verdicts = %t, %t, %t", nodes[0].Ok, nodes[1].Ok, nodes[2].Ok)
+	}
+
+	// One ok node and 90 schedulable for 10 additional.
+	if ok := v.clusterOk(nodes, md); ok == nil || *ok {
+		t.Errorf("cluster ok = %v", ok)
+	}
+
+	md.Additional = 5
+
+	if ok := v.clusterOk(nodes, md); ok == nil || !*ok {
+		t.Errorf("cluster ok with 5 additional = %v", ok)
+	}
+
+	// Without rules the built-in verdicts stand.
+	var none *VerdictRules
+
+	if !none.nodeOk(nodes[0], md) || none.clusterOk(nodes, md) != nil {
+		t.Error("no rules")
+	}
+
+	for _, rules := range [][2]string{{"pressure <", ""}, {"", "pressure > 1"}, {"okNodes > 1", ""}} {
+		if _, err := ParseVerdictRules(rules[0], rules[1]); err == nil {
+			t.Errorf("%q: want error", rules)
+		}
+	}
+}
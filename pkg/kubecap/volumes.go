@@ -0,0 +1,93 @@
+package kubecap
+
+import (
+	"context"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// VolumeAttachReport is the volumes a CSI driver has attached to a node
+// against the node's attach limit for the driver (e.g. EBS's per-instance
+// limit). Stateful pods can't be scheduled on a node without attach slots
+// however much memory it has free.
+type VolumeAttachReport struct {
+	Driver   string `json:"driver"`
+	Attached int64  `json:"attached"`
+
+	// Limit is the most volumes the driver can attach to the node, as its
+	// CSINode reports. It is left out when the driver reports no limit.
+	Limit int64 `json:"limit,omitempty"`
+}
+
+// Full is whether the driver can't attach another volume to the node.
+func (v *VolumeAttachReport) Full() bool {
+	return v.Limit > 0 && v.Attached >= v.Limit
+}
+
+// collectVolumeAttachments returns each node's CSI volume attachments and
+// attach limits by driver, sorted by driver. Attachments still being made or
+// removed count as they hold an attach slot.
+func collectVolumeAttachments(ctx context.Context, kcs kubernetes.Interface) (byNode map[string][]*VolumeAttachReport, err error) {
+	ctx, span := tracer.Start(ctx, "collect volume attachments")
+	defer func() { endSpan(span, err) }()
+
+	csiNodeList, err := kcs.StorageV1().CSINodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	attachmentList, err := kcs.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	reports := map[string]map[string]*VolumeAttachReport{}
+
+	report := func(node, driver string) *VolumeAttachReport {
+		if reports[node] == nil {
+			reports[node] = map[string]*VolumeAttachReport{}
+		}
+
+		r, ok := reports[node][driver]
+		if !ok {
+			r = &VolumeAttachReport{Driver: driver}
+			reports[node][driver] = r
+		}
+
+		return r
+	}
+
+	for i := range csiNodeList.Items {
+		csiNode := &csiNodeList.Items[i]
+
+		for _, d := range csiNode.Spec.Drivers {
+			r := report(csiNode.Name, d.Name)
+
+			if d.Allocatable != nil && d.Allocatable.Count != nil {
+				r.Limit = int64(*d.Allocatable.Count)
+			}
+		}
+	}
+
+	for i := range attachmentList.Items {
+		va := &attachmentList.Items[i]
+
+		report(va.Spec.NodeName, va.Spec.Attacher).Attached++
+	}
+
+	byNode = map[string][]*VolumeAttachReport{}
+
+	for node, drivers := range reports {
+		for _, r := range drivers {
+			byNode[node] = append(byNode[node], r)
+		}
+
+		sort.Slice(byNode[node], func(i, j int) bool {
+			return byNode[node][i].Driver < byNode[node][j].Driver
+		})
+	}
+
+	return byNode, nil
+}
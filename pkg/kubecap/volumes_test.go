@@ -0,0 +1,64 @@
+package kubecap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCollectVolumeAttachments(t *testing.T) {
+	count := int32(2)
+
+	csiNode := func(name string, drivers ...storagev1.CSINodeDriver) *storagev1.CSINode {
+		return &storagev1.CSINode{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       storagev1.CSINodeSpec{Drivers: drivers},
+		}
+	}
+
+	attachment := func(name, node, driver string) *storagev1.VolumeAttachment {
+		return &storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       storagev1.VolumeAttachmentSpec{NodeName: node, Attacher: driver},
+		}
+	}
+
+	kcs := fake.NewSimpleClientset(
+		csiNode("node-a",
+			storagev1.CSINodeDriver{Name: "ebs.csi.aws.com", Allocatable: &storagev1.VolumeNodeResources{Count: &count}},
+			storagev1.CSINodeDriver{Name: "efs.csi.aws.com"},
+		),
+		csiNode("node-b", storagev1.CSINodeDriver{Name: "ebs.csi.aws.com", Allocatable: &storagev1.VolumeNodeResources{Count: &count}}),
+		attachment("a1", "node-a", "ebs.csi.aws.com"),
+		attachment("a2", "node-a", "ebs.csi.aws.com"),
+		attachment("a3", "node-a", "efs.csi.aws.com"),
+		attachment("b1", "node-b", "ebs.csi.aws.com"),
+	)
+
+	byNode, err := collectVolumeAttachments(context.Background(), kcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]*VolumeAttachReport{
+		"node-a": {
+			{Driver: "ebs.csi.aws.com", Attached: 2, Limit: 2},
+			{Driver: "efs.csi.aws.com", Attached: 1},
+		},
+		"node-b": {
+			{Driver: "ebs.csi.aws.com", Attached: 1, Limit: 2},
+		},
+	}
+
+	if !reflect.DeepEqual(byNode, want) {
+		t.Errorf("volumes = %v, want %v", byNode, want)
+	}
+
+	if !byNode["node-a"][0].Full() || byNode["node-a"][1].Full() || byNode["node-b"][0].Full() {
+		t.Error("wrong drivers full")
+	}
+}
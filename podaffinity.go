@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// podAffinityTermMatches reports whether a pod in namespace ns with the
+// labels is selected by the term of a pod in namespace owner. Terms without
+// namespaces select their own pod's namespace; a namespace selector can't be
+// evaluated without the namespaces' labels so it is taken to select every
+// namespace.
+func podAffinityTermMatches(term *corev1.PodAffinityTerm, owner, ns string, podLabels map[string]string) bool {
+	if term.NamespaceSelector == nil {
+		namespaces := term.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{owner}
+		}
+
+		found := false
+		for _, n := range namespaces {
+			if n == ns {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(labels.Set(podLabels))
+}
+
+// requiredPodAffinityTerms returns the affinity's required pod affinity and
+// anti-affinity terms.
+func requiredPodAffinityTerms(affinity *corev1.Affinity) (terms, antiTerms []corev1.PodAffinityTerm) {
+	if affinity == nil {
+		return nil, nil
+	}
+
+	if affinity.PodAffinity != nil {
+		terms = affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	}
+
+	if affinity.PodAntiAffinity != nil {
+		antiTerms = affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	}
+
+	return terms, antiTerms
+}
+
+// domainPods returns the pods running or starting in the node's topology
+// domain for the key: on the nodes with the same value of the key label. A
+// node without the label has no domain.
+func (ds *drainState) domainPods(node *corev1.Node, key string) []*corev1.Pod {
+	value, ok := node.Labels[key]
+	if !ok {
+		return nil
+	}
+
+	pods := []*corev1.Pod{}
+
+	for i := range ds.nodes {
+		if v, ok := ds.nodes[i].Labels[key]; !ok || v != value {
+			continue
+		}
+
+		for _, pod := range ds.nps[ds.nodes[i].Name] {
+			if pod.Spec.NodeName != "" && kubecap.PodState(pod) != kubecap.PodStateFinished {
+				pods = append(pods, pod)
+			}
+		}
+	}
+
+	return pods
+}
+
+// podAffinityReasons returns why the workload's replicas can't be scheduled
+// on the node as far as required inter-pod affinity goes: its anti-affinity
+// with pods in the node's domain, its affinity with none there, and the
+// anti-affinity of pods there with it.
+func (ds *drainState) podAffinityReasons(w *fitWorkload, node *corev1.Node) []string {
+	reasons := []string{}
+	terms, antiTerms := requiredPodAffinityTerms(w.spec.Affinity)
+
+	for i := range antiTerms {
+		term := &antiTerms[i]
+
+		for _, pod := range ds.domainPods(node, term.TopologyKey) {
+			if podAffinityTermMatches(term, w.namespace, pod.Namespace, pod.Labels) {
+				reasons = append(reasons, fmt.Sprintf("pod anti-affinity with %s/%s", pod.Namespace, pod.Name))
+
+				break
+			}
+		}
+	}
+
+	for i := range terms {
+		term := &terms[i]
+
+		matched := false
+		for _, pod := range ds.domainPods(node, term.TopologyKey) {
+			if podAffinityTermMatches(term, w.namespace, pod.Namespace, pod.Labels) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched && !ds.firstOfAffinity(w, term) {
+			reasons = append(reasons, "pod affinity "+term.TopologyKey)
+		}
+	}
+
+	// The scheduler also keeps pods out of the domains of the pods whose
+	// anti-affinity they match.
+	for i := range ds.nodes {
+		other := &ds.nodes[i]
+
+		for _, pod := range ds.nps[other.Name] {
+			if pod.Spec.NodeName == "" || kubecap.PodState(pod) == kubecap.PodStateFinished {
+				continue
+			}
+
+			_, podAntiTerms := requiredPodAffinityTerms(pod.Spec.Affinity)
+			for j := range podAntiTerms {
+				term := &podAntiTerms[j]
+
+				value, ok := node.Labels[term.TopologyKey]
+				if v, otherOk := other.Labels[term.TopologyKey]; !ok || !otherOk || v != value {
+					continue
+				}
+
+				if podAffinityTermMatches(term, pod.Namespace, w.namespace, w.labels) {
+					reasons = append(reasons, fmt.Sprintf("anti-affinity of %s/%s", pod.Namespace, pod.Name))
+				}
+			}
+		}
+	}
+
+	return reasons
+}
+
+// firstOfAffinity reports whether the workload's first replica may be
+// scheduled anywhere despite the affinity term: as the scheduler allows, when
+// no pod matches the term at all and the replica matches it itself.
+func (ds *drainState) firstOfAffinity(w *fitWorkload, term *corev1.PodAffinityTerm) bool {
+	if !podAffinityTermMatches(term, w.namespace, w.namespace, w.labels) {
+		return false
+	}
+
+	for _, pods := range ds.nps {
+		for _, pod := range pods {
+			if pod.Spec.NodeName != "" && kubecap.PodState(pod) != kubecap.PodStateFinished && podAffinityTermMatches(term, w.namespace, pod.Namespace, pod.Labels) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// spreadSelfAntiAffinity limits the replicas to one per topology domain of
+// each of the workload's required anti-affinity terms it matches itself,
+// keeping them on the nodes listed first.
+func (r *fitReport) spreadSelfAntiAffinity(nodes map[string]*corev1.Node) {
+	w := r.workload
+	_, antiTerms := requiredPodAffinityTerms(w.spec.Affinity)
+
+	for i := range antiTerms {
+		term := &antiTerms[i]
+		if !podAffinityTermMatches(term, w.namespace, w.namespace, w.labels) {
+			continue
+		}
+
+		taken := map[string]bool{}
+
+		for _, n := range r.nodes {
+			value, ok := nodes[n.name].Labels[term.TopologyKey]
+			if !ok || n.replicas == 0 {
+				continue
+			}
+
+			if taken[value] {
+				n.replicas = 0
+				n.reasons = append(n.reasons, fmt.Sprintf("pod anti-affinity with its replicas in %s=%s", term.TopologyKey, value))
+
+				continue
+			}
+
+			taken[value] = true
+			n.replicas = 1
+		}
+	}
+}
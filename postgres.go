@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	// Register the postgres driver.
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	_ "github.com/lib/pq"
+)
+
+// postgresSchema is the schema kubecap writes to. It is created if missing
+// and the tables are turned into hypertables when TimescaleDB is installed.
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS kubecap_nodes (
+		time         timestamptz NOT NULL,
+		cluster      text NOT NULL,
+		node         text NOT NULL,
+		node_group   text NOT NULL,
+		allocatable  bigint NOT NULL,
+		used         bigint NOT NULL,
+		free         bigint NOT NULL,
+		requests     bigint NOT NULL,
+		schedulable  bigint NOT NULL,
+		efficiency   double precision NOT NULL,
+		ok           boolean NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS kubecap_nodes_cluster_time ON kubecap_nodes (cluster, time DESC)`,
+	`CREATE TABLE IF NOT EXISTS kubecap_namespaces (
+		time       timestamptz NOT NULL,
+		cluster    text NOT NULL,
+		namespace  text NOT NULL,
+		pods       bigint NOT NULL,
+		requests   bigint NOT NULL,
+		used       bigint NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS kubecap_namespaces_cluster_time ON kubecap_namespaces (cluster, time DESC)`,
+	`CREATE TABLE IF NOT EXISTS kubecap_nodes_hourly (
+		time         timestamptz NOT NULL,
+		cluster      text NOT NULL,
+		node         text NOT NULL,
+		node_group   text NOT NULL,
+		allocatable  bigint NOT NULL,
+		used         bigint NOT NULL,
+		used_max     bigint NOT NULL,
+		requests     bigint NOT NULL,
+		schedulable  bigint NOT NULL,
+		efficiency   double precision NOT NULL,
+		ok           boolean NOT NULL,
+		samples      bigint NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS kubecap_namespaces_hourly (
+		time       timestamptz NOT NULL,
+		cluster    text NOT NULL,
+		namespace  text NOT NULL,
+		pods       bigint NOT NULL,
+		requests   bigint NOT NULL,
+		used       bigint NOT NULL,
+		samples    bigint NOT NULL
+	)`,
+	// Namespace rows are split by cost center.
+	`ALTER TABLE kubecap_namespaces ADD COLUMN IF NOT EXISTS cost_center text NOT NULL DEFAULT ''`,
+	`ALTER TABLE kubecap_namespaces_hourly ADD COLUMN IF NOT EXISTS cost_center text NOT NULL DEFAULT ''`,
+	`DROP INDEX IF EXISTS kubecap_namespaces_hourly_cluster_namespace_time`,
+	// SLO rows are a few per run, so they are kept as they are until the
+	// hourly retention.
+	`CREATE TABLE IF NOT EXISTS kubecap_slo (
+		time         timestamptz NOT NULL,
+		cluster      text NOT NULL,
+		slo          text NOT NULL,
+		scope        text NOT NULL,
+		target       double precision NOT NULL,
+		allocatable  bigint NOT NULL,
+		schedulable  bigint NOT NULL,
+		met          boolean NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS kubecap_slo_cluster_time ON kubecap_slo (cluster, time DESC)`,
+	// The nodes' allocatable and capacity changes a watch saw, as an audit
+	// trail.
+	`CREATE TABLE IF NOT EXISTS kubecap_allocatable_changes (
+		time         timestamptz NOT NULL,
+		cluster      text NOT NULL,
+		node         text NOT NULL,
+		of           text NOT NULL,
+		resource     text NOT NULL,
+		from_amount  text NOT NULL,
+		to_amount    text NOT NULL,
+		shrank       boolean NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS kubecap_allocatable_changes_cluster_time ON kubecap_allocatable_changes (cluster, time DESC)`,
+	// Amounts are of the report's --resource, so rows are split by it; those
+	// written before were memory.
+	`ALTER TABLE kubecap_nodes ADD COLUMN IF NOT EXISTS resource text NOT NULL DEFAULT 'memory'`,
+	`ALTER TABLE kubecap_nodes_hourly ADD COLUMN IF NOT EXISTS resource text NOT NULL DEFAULT 'memory'`,
+	`ALTER TABLE kubecap_namespaces ADD COLUMN IF NOT EXISTS resource text NOT NULL DEFAULT 'memory'`,
+	`ALTER TABLE kubecap_namespaces_hourly ADD COLUMN IF NOT EXISTS resource text NOT NULL DEFAULT 'memory'`,
+	`ALTER TABLE kubecap_slo ADD COLUMN IF NOT EXISTS resource text NOT NULL DEFAULT 'memory'`,
+	`DROP INDEX IF EXISTS kubecap_nodes_hourly_cluster_node_time`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS kubecap_nodes_hourly_cluster_resource_node_time ON kubecap_nodes_hourly (cluster, resource, node, time DESC)`,
+	`DROP INDEX IF EXISTS kubecap_namespaces_hourly_cluster_namespace_cost_center_time`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS kubecap_namespaces_hourly_cluster_resource_namespace_cost_center_time ON kubecap_namespaces_hourly (cluster, resource, namespace, cost_center, time DESC)`,
+	`CREATE OR REPLACE VIEW kubecap_namespaces_history AS
+		SELECT time, cluster, namespace, pods, requests, used, cost_center, resource FROM kubecap_namespaces
+		UNION ALL
+		SELECT time, cluster, namespace, pods, requests, used, cost_center, resource FROM kubecap_namespaces_hourly`,
+}
+
+// postgresCompaction rolls the rows older than the raw retention up into
+// hourly rows (averages, the peak usage and the lowest schedulable memory)
+// and deletes them. $1 is the cutoff.
+var postgresCompaction = []string{
+	`INSERT INTO kubecap_nodes_hourly (time, cluster, resource, node, node_group, allocatable, used, used_max, requests, schedulable, efficiency, ok, samples)
+	SELECT date_trunc('hour', time), cluster, resource, node, max(node_group), max(allocatable), round(avg(used)), max(used),
+		round(avg(requests)), min(schedulable), avg(efficiency), bool_and(ok), count(*)
+	FROM kubecap_nodes WHERE time < $1
+	GROUP BY 1, 2, 3, 4
+	ON CONFLICT DO NOTHING`,
+	`DELETE FROM kubecap_nodes WHERE time < $1`,
+	`INSERT INTO kubecap_namespaces_hourly (time, cluster, resource, namespace, cost_center, pods, requests, used, samples)
+	SELECT date_trunc('hour', time), cluster, resource, namespace, cost_center, round(avg(pods)), round(avg(requests)), round(avg(used)), count(*)
+	FROM kubecap_namespaces WHERE time < $1
+	GROUP BY 1, 2, 3, 4, 5
+	ON CONFLICT DO NOTHING`,
+	`DELETE FROM kubecap_namespaces WHERE time < $1`,
+}
+
+// postgresRetention is how long raw rows are kept before being rolled up into
+// hourly rows and how long those are kept. Zero keeps them forever.
+type postgresRetention struct {
+	raw    time.Duration
+	hourly time.Duration
+}
+
+// cutoffs returns the times before which raw rows are compacted and hourly
+// rows are deleted at now. The raw cutoff is on the hour so that each hour is
+// rolled up at once.
+func (r postgresRetention) cutoffs(now time.Time) (raw, hourly time.Time) {
+	if r.raw > 0 {
+		raw = now.Add(-r.raw).Truncate(time.Hour)
+	}
+
+	if r.hourly > 0 {
+		hourly = now.Add(-r.hourly)
+	}
+
+	return raw, hourly
+}
+
+// postgresOutput inserts a row per node and per namespace for each run into
+// PostgreSQL (or TimescaleDB).
+type postgresOutput struct {
+	db        *sql.DB
+	retention postgresRetention
+	md        *kubecap.Metadata
+	nodes     []*kubecap.NodeReport
+}
+
+func newPostgresOutput(dsn string, retention postgresRetention) (*postgresOutput, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, stmt := range postgresSchema {
+		_, err = db.ExecContext(ctx, stmt)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	var timescale bool
+
+	err = db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&timescale)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if timescale {
+		for _, table := range []string{"kubecap_nodes", "kubecap_namespaces", "kubecap_nodes_hourly", "kubecap_namespaces_hourly", "kubecap_slo", "kubecap_allocatable_changes"} {
+			_, err = db.ExecContext(ctx, `SELECT create_hypertable($1, 'time', if_not_exists => TRUE, migrate_data => TRUE)`, table)
+			if err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return &postgresOutput{db: db, retention: retention}, nil
+}
+
+func (p *postgresOutput) Metadata(m *kubecap.Metadata) error {
+	p.md = m
+	p.nodes = nil
+
+	return nil
+}
+
+func (p *postgresOutput) Node(n *kubecap.NodeReport) error {
+	p.nodes = append(p.nodes, n)
+
+	return nil
+}
+
+func (p *postgresOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+func (p *postgresOutput) Flush() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	ts := p.md.Timestamp
+	resource := string(p.md.ResourceName())
+
+	for _, n := range p.nodes {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO kubecap_nodes (time, cluster, resource, node, node_group, allocatable, used, free, requests, schedulable, efficiency, ok)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			ts, p.md.Context, resource, n.Name, n.Group, n.Allocatable, n.Used, n.Free, n.Requests, n.Schedulable, n.Efficiency, n.Ok,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	namespaces := kubecap.AggregatePods(p.nodes, func(n *kubecap.NodeReport, pod *kubecap.PodReport) []string {
+		return []string{pod.Namespace, pod.CostCenter}
+	})
+
+	for _, row := range namespaces {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO kubecap_namespaces (time, cluster, resource, namespace, cost_center, pods, requests, used)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			ts, p.md.Context, resource, row[0], row[1], row[2], row[3], row[4],
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, slo := range p.md.SLOStatus {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO kubecap_slo (time, cluster, resource, slo, scope, target, allocatable, schedulable, met)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			ts, p.md.Context, resource, slo.SLO, slo.Scope, slo.Target, slo.Allocatable, slo.Schedulable, slo.Met,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The changes are reported for a while, but only inserted the run
+	// they are seen.
+	for _, n := range p.nodes {
+		for _, c := range n.AllocatableChanges {
+			if !c.At.Equal(ts) {
+				continue
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO kubecap_allocatable_changes (time, cluster, node, of, resource, from_amount, to_amount, shrank)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				ts, p.md.Context, n.Name, c.Of, c.Resource, c.From, c.To, c.Shrank,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	raw, hourly := p.retention.cutoffs(ts)
+
+	if !raw.IsZero() {
+		for _, stmt := range postgresCompaction {
+			_, err = tx.ExecContext(ctx, stmt, raw)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !hourly.IsZero() {
+		for _, table := range []string{"kubecap_nodes_hourly", "kubecap_namespaces_hourly", "kubecap_slo", "kubecap_allocatable_changes"} {
+			_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE time < $1`, hourly)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// seedSLOs records the SLO samples of the resource stored since into the
+// tracker, so that a restarted watch keeps tracking compliance over its whole
+// window.
+func (p *postgresOutput) seedSLOs(ctx context.Context, tracker *kubecap.SLOTracker, since time.Time, resource string) error {
+	rows, err := p.db.QueryContext(ctx, `SELECT time, cluster, slo, scope, met FROM kubecap_slo WHERE time > $1 AND resource = $2 ORDER BY time`, since, resource)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			at                  time.Time
+			cluster, slo, scope string
+			met                 bool
+		)
+
+		err = rows.Scan(&at, &cluster, &slo, &scope, &met)
+		if err != nil {
+			return err
+		}
+
+		tracker.Record(cluster, slo, scope, at, met)
+	}
+
+	return rows.Err()
+}
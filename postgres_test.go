@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPostgresRetentionCutoffs(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 34, 56, 0, time.UTC)
+
+	raw, hourly := postgresRetention{raw: 7 * 24 * time.Hour, hourly: 90 * 24 * time.Hour}.cutoffs(now)
+
+	if want := time.Date(2026, 10, 9, 12, 0, 0, 0, time.UTC); !raw.Equal(want) {
+		t.Errorf("raw cutoff = %s, want %s", raw, want)
+	}
+
+	if want := now.Add(-90 * 24 * time.Hour); !hourly.Equal(want) {
+		t.Errorf("hourly cutoff = %s, want %s", hourly, want)
+	}
+
+	raw, hourly = postgresRetention{}.cutoffs(now)
+	if !raw.IsZero() || !hourly.IsZero() {
+		t.Errorf("forever = %s, %s", raw, hourly)
+	}
+}
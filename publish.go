@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// reportsAPI is the API group version of the capacity report custom
+// resources.
+const reportsAPI = "/apis/kubecap.io/v1alpha1"
+
+// reportsManagedBy labels the capacity reports kubecap publishes.
+const reportsManagedBy = "app.kubernetes.io/managed-by=kubecap"
+
+// nodeCapacityStatus is a NodeCapacityReport's status: the node's row of the
+// report.
+type nodeCapacityStatus struct {
+	Resource    string    `json:"resource"`
+	Group       string    `json:"group,omitempty"`
+	Zone        string    `json:"zone,omitempty"`
+	Allocatable int64     `json:"allocatable"`
+	Used        int64     `json:"used"`
+	Requests    int64     `json:"requests"`
+	Free        int64     `json:"free"`
+	Schedulable int64     `json:"schedulable"`
+	Additional  int64     `json:"additional"`
+	Ok          bool      `json:"ok"`
+	Excluded    bool      `json:"excluded,omitempty"`
+	Updated     time.Time `json:"updated"`
+}
+
+// clusterCapacityStatus is the ClusterCapacityReport's status: the report's
+// summary and whether the additional amount fits on any node, or the
+// cluster's verdict by the cluster rule if any.
+type clusterCapacityStatus struct {
+	Resource    string    `json:"resource"`
+	Nodes       int       `json:"nodes"`
+	OkNodes     int       `json:"okNodes"`
+	Allocatable int64     `json:"allocatable"`
+	Used        int64     `json:"used"`
+	Requests    int64     `json:"requests"`
+	Schedulable int64     `json:"schedulable"`
+	Additional  int64     `json:"additional"`
+	Fits        bool      `json:"fits"`
+	Updated     time.Time `json:"updated"`
+}
+
+// capacityReport is a capacity report custom resource as applied.
+type capacityReport struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Status interface{} `json:"status"`
+}
+
+// publishOutput publishes the report as custom resources: a
+// NodeCapacityReport per node, named after it, and a ClusterCapacityReport
+// named cluster, so that kubectl get shows the capacity state and operators
+// can watch it. Reports of nodes gone from the cluster are deleted.
+type publishOutput struct {
+	ctx context.Context
+	kcs kubernetes.Interface
+
+	md    *kubecap.Metadata
+	nodes []*kubecap.NodeReport
+}
+
+func (o *publishOutput) Metadata(m *kubecap.Metadata) error {
+	o.md = m
+	o.nodes = nil
+
+	return nil
+}
+
+func (o *publishOutput) Node(n *kubecap.NodeReport) error {
+	o.nodes = append(o.nodes, n)
+
+	return nil
+}
+
+func (o *publishOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+// apply creates or updates the report with a server-side apply.
+func (o *publishOutput) apply(resource, kind, name string, status interface{}) error {
+	r := &capacityReport{
+		APIVersion: "kubecap.io/v1alpha1",
+		Kind:       kind,
+		Status:     status,
+	}
+	r.Metadata.Name = name
+	r.Metadata.Labels = map[string]string{"app.kubernetes.io/managed-by": "kubecap"}
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	err = o.kcs.CoreV1().RESTClient().Patch(types.ApplyPatchType).
+		AbsPath(reportsAPI, resource, name).
+		Param("fieldManager", "kubecap").
+		Param("force", "true").
+		Body(body).
+		Do(o.ctx).
+		Error()
+	if err != nil {
+		return fmt.Errorf("publish %s %s: %w", kind, name, err)
+	}
+
+	return nil
+}
+
+// prune deletes the NodeCapacityReports kubecap published for nodes no
+// longer reported.
+func (o *publishOutput) prune(reported map[string]bool) error {
+	data, err := o.kcs.CoreV1().RESTClient().Get().
+		AbsPath(reportsAPI, "nodecapacityreports").
+		Param("labelSelector", reportsManagedBy).
+		DoRaw(o.ctx)
+	if err != nil {
+		return fmt.Errorf("list NodeCapacityReports: %w", err)
+	}
+
+	var list struct {
+		Items []capacityReport `json:"items"`
+	}
+
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range list.Items {
+		if reported[item.Metadata.Name] {
+			continue
+		}
+
+		err = o.kcs.CoreV1().RESTClient().Delete().
+			AbsPath(reportsAPI, "nodecapacityreports", item.Metadata.Name).
+			Do(o.ctx).
+			Error()
+		if err != nil {
+			return fmt.Errorf("delete NodeCapacityReport %s: %w", item.Metadata.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (o *publishOutput) Flush() error {
+	resource := string(o.md.ResourceName())
+	reported := map[string]bool{}
+
+	for _, n := range o.nodes {
+		reported[n.Name] = true
+
+		err := o.apply("nodecapacityreports", "NodeCapacityReport", n.Name, &nodeCapacityStatus{
+			Resource:    resource,
+			Group:       n.Group,
+			Zone:        n.Zone,
+			Allocatable: n.Allocatable,
+			Used:        n.Used,
+			Requests:    n.Requests,
+			Free:        n.Free,
+			Schedulable: n.Schedulable,
+			Additional:  n.Additional,
+			Ok:          n.Ok,
+			Excluded:    n.Excluded,
+			Updated:     o.md.Timestamp,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	s := kubecap.Summarize(o.nodes)
+
+	fits := s.OkNodes > 0
+	if o.md.ClusterOk != nil {
+		fits = *o.md.ClusterOk
+	}
+
+	err := o.apply("clustercapacityreports", "ClusterCapacityReport", "cluster", &clusterCapacityStatus{
+		Resource:    resource,
+		Nodes:       s.Nodes,
+		OkNodes:     s.OkNodes,
+		Allocatable: s.Allocatable,
+		Used:        s.Used,
+		Requests:    s.Requests,
+		Schedulable: s.Schedulable,
+		Additional:  o.md.Additional,
+		Fits:        fits,
+		Updated:     o.md.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	return o.prune(reported)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestPublishOutput(t *testing.T) {
+	applied := map[string]map[string]interface{}{}
+	deleted := []string{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodPatch:
+			if r.Header.Get("Content-Type") != "application/apply-patch+yaml" || r.URL.Query().Get("fieldManager") != "kubecap" {
+				t.Errorf("%s: not a server-side apply", r.URL)
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var report struct {
+				Kind   string                 `json:"kind"`
+				Status map[string]interface{} `json:"status"`
+			}
+
+			err = json.Unmarshal(body, &report)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			applied[r.URL.Path] = report.Status
+		case http.MethodGet:
+			if r.URL.Query().Get("labelSelector") != reportsManagedBy {
+				t.Errorf("%s: listed without the label selector", r.URL)
+			}
+
+			// node-c left the cluster since the last report.
+			rw.Write([]byte(`{"items": [{"metadata": {"name": "node-a"}}, {"metadata": {"name": "node-c"}}]}`))
+
+			return
+		case http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+		}
+
+		rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	kcs, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &publishOutput{ctx: context.Background(), kcs: kcs}
+
+	err = out.Metadata(&kubecap.Metadata{Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Additional: 1 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []*kubecap.NodeReport{
+		{Name: "node-a", Group: "web", Allocatable: 8 << 30, Schedulable: 4 << 30, Ok: true},
+		{Name: "node-b", Group: "web", Allocatable: 8 << 30, Schedulable: 0},
+	} {
+		err = out.Node(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := applied["/apis/kubecap.io/v1alpha1/nodecapacityreports/node-a"]
+	if node["schedulable"] != float64(4<<30) || node["ok"] != true || node["group"] != "web" || node["updated"] != "2024-05-01T12:00:00Z" {
+		t.Errorf("node-a = %v", node)
+	}
+
+	cluster := applied["/apis/kubecap.io/v1alpha1/clustercapacityreports/cluster"]
+	if cluster["nodes"] != float64(2) || cluster["okNodes"] != float64(1) || cluster["fits"] != true || cluster["schedulable"] != float64(4<<30) {
+		t.Errorf("cluster = %v", cluster)
+	}
+
+	if len(applied) != 3 {
+		t.Errorf("applied %d reports, want 3", len(applied))
+	}
+
+	if want := []string{"/apis/kubecap.io/v1alpha1/nodecapacityreports/node-c"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+}
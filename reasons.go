@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Reason codes of fit and what-if's JSON output, for automation to branch on
+// why something doesn't fit.
+const (
+	ReasonNodeUnschedulable      = "NODE_UNSCHEDULABLE"
+	ReasonNodeNotReady           = "NODE_NOT_READY"
+	ReasonNodeSelectorMismatch   = "NODE_SELECTOR_MISMATCH"
+	ReasonNodeAffinityMismatch   = "NODE_AFFINITY_MISMATCH"
+	ReasonTaintNotTolerated      = "TAINT_NOT_TOLERATED"
+	ReasonTooManyPods            = "TOO_MANY_PODS"
+	ReasonInsufficientMemory     = "INSUFFICIENT_MEMORY"
+	ReasonInsufficientCPU        = "INSUFFICIENT_CPU"
+	ReasonInsufficientStorage    = "INSUFFICIENT_EPHEMERAL_STORAGE"
+	ReasonInsufficientResource   = "INSUFFICIENT_RESOURCE"
+	ReasonPodAntiAffinity        = "POD_ANTI_AFFINITY"
+	ReasonPodAffinity            = "POD_AFFINITY_UNSATISFIED"
+	ReasonVolumeAttachLimit      = "VOLUME_ATTACH_LIMIT"
+	ReasonVolumeNodeConflict     = "VOLUME_NODE_CONFLICT"
+	ReasonLimitRangeViolation    = "LIMIT_RANGE_VIOLATION"
+	ReasonAdmissionRejected      = "ADMISSION_REJECTED"
+	ReasonNoNodeFits             = "NO_NODE_FITS"
+	ReasonPDBBlocksEviction      = "PDB_BLOCKS_EVICTION"
+	ReasonEvictionPolicyProtects = "EVICTION_POLICY_PROTECTS"
+	ReasonOther                  = "OTHER"
+)
+
+// reasonPrefixes map the reasons fit gives for a node to their codes by
+// prefix, the more specific prefixes first.
+var reasonPrefixes = []struct {
+	prefix string
+	code   string
+}{
+	{"unschedulable", ReasonNodeUnschedulable},
+	{"NotReady", ReasonNodeNotReady},
+	{"node selector", ReasonNodeSelectorMismatch},
+	{"node affinity", ReasonNodeAffinityMismatch},
+	{"taint ", ReasonTaintNotTolerated},
+	{"too many pods", ReasonTooManyPods},
+	{"insufficient memory", ReasonInsufficientMemory},
+	{"insufficient cpu", ReasonInsufficientCPU},
+	{"insufficient ephemeral-storage", ReasonInsufficientStorage},
+	{"insufficient ", ReasonInsufficientResource},
+	{"pod anti-affinity", ReasonPodAntiAffinity},
+	{"anti-affinity of", ReasonPodAntiAffinity},
+	{"pod affinity", ReasonPodAffinity},
+	{"volume attach limit", ReasonVolumeAttachLimit},
+	{"volume ", ReasonVolumeNodeConflict},
+}
+
+// reason is a reason code with the message people read.
+type reason struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// nodeReasons returns the codes of the reasons fit gives for a node.
+func nodeReasons(messages []string) []*reason {
+	reasons := []*reason{}
+
+	for _, m := range messages {
+		code := ReasonOther
+
+		for _, p := range reasonPrefixes {
+			if strings.HasPrefix(m, p.prefix) {
+				code = p.code
+
+				break
+			}
+		}
+
+		reasons = append(reasons, &reason{code, m})
+	}
+
+	return reasons
+}
+
+// fitNodeJSON is a node of fit's JSON output.
+type fitNodeJSON struct {
+	Name         string    `json:"name"`
+	Replicas     int64     `json:"replicas"`
+	CachedImages int       `json:"cachedImages"`
+	Reasons      []*reason `json:"reasons"`
+}
+
+// fitJSON is fit's JSON output. Reasons are why the workload doesn't fit,
+// each code once with the number of nodes it keeps replicas off, empty when
+// it does.
+type fitJSON struct {
+	Workload struct {
+		Kind      string           `json:"kind"`
+		Namespace string           `json:"namespace,omitempty"`
+		Name      string           `json:"name"`
+		Replicas  int64            `json:"replicas"`
+		Requests  map[string]int64 `json:"requests"`
+	} `json:"workload"`
+
+	Fitting int64 `json:"fitting"`
+	Ok      bool  `json:"ok"`
+
+	Reasons []*summaryReason `json:"reasons"`
+	Nodes   []*fitNodeJSON   `json:"nodes"`
+
+	// Violations are the LimitRange violations and Rejections the
+	// server-side dry run's, if tried.
+	Violations []*reason `json:"violations"`
+	Rejections []*reason `json:"rejections,omitempty"`
+}
+
+// summaryReason is a reason code of a report's verdict with how many nodes
+// (or pods) it applies to and the first message.
+type summaryReason struct {
+	Code    string `json:"code"`
+	Count   int    `json:"count"`
+	Message string `json:"message"`
+}
+
+// summarizeReasons counts the reasons by code, most frequent first.
+func summarizeReasons(reasons []*reason) []*summaryReason {
+	byCode := map[string]*summaryReason{}
+	summary := []*summaryReason{}
+
+	for _, r := range reasons {
+		s, ok := byCode[r.Code]
+		if !ok {
+			s = &summaryReason{Code: r.Code, Message: r.Message}
+			byCode[r.Code] = s
+			summary = append(summary, s)
+		}
+
+		s.Count++
+	}
+
+	sort.SliceStable(summary, func(i, j int) bool {
+		return summary[i].Count > summary[j].Count
+	})
+
+	return summary
+}
+
+func (r *fitReport) writeJSON(w io.Writer) error {
+	doc := &fitJSON{
+		Fitting:    r.fitting(),
+		Ok:         r.ok(),
+		Reasons:    []*summaryReason{},
+		Nodes:      []*fitNodeJSON{},
+		Violations: []*reason{},
+	}
+
+	doc.Workload.Kind = r.workload.kind
+	doc.Workload.Namespace = r.workload.namespace
+	doc.Workload.Name = r.workload.name
+	doc.Workload.Replicas = r.workload.replicas
+	doc.Workload.Requests = map[string]int64{}
+
+	for name, v := range r.requests {
+		doc.Workload.Requests[string(name)] = v
+	}
+
+	// The reasons of nodes fitting no replicas explain a shortfall.
+	blocking := []*reason{}
+
+	for _, n := range r.nodes {
+		nj := &fitNodeJSON{
+			Name:         n.name,
+			Replicas:     n.replicas,
+			CachedImages: n.cached,
+			Reasons:      nodeReasons(n.reasons),
+		}
+
+		if n.replicas == 0 {
+			blocking = append(blocking, nj.Reasons...)
+		}
+
+		doc.Nodes = append(doc.Nodes, nj)
+	}
+
+	for _, v := range r.violations {
+		doc.Violations = append(doc.Violations, &reason{ReasonLimitRangeViolation, v})
+	}
+
+	if r.dryRun != nil {
+		doc.Rejections = []*reason{}
+
+		for _, rejection := range r.dryRun.rejections {
+			doc.Rejections = append(doc.Rejections, &reason{ReasonAdmissionRejected, rejection})
+		}
+	}
+
+	if !doc.Ok {
+		if doc.Fitting < r.workload.replicas {
+			doc.Reasons = summarizeReasons(blocking)
+		}
+
+		doc.Reasons = append(doc.Reasons, summarizeReasons(append(append([]*reason{}, doc.Violations...), doc.Rejections...))...)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(doc)
+}
+
+// drainPodJSON is a pod of what-if's JSON output.
+type drainPodJSON struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Node      string `json:"node"`
+	Requests  int64  `json:"requests"`
+
+	// PlacedOn is where a displaced pod was placed, empty if nowhere.
+	PlacedOn string `json:"placedOn,omitempty"`
+}
+
+func newDrainPodJSON(dp *drainPod) *drainPodJSON {
+	return &drainPodJSON{
+		Namespace: dp.pod.Namespace,
+		Name:      dp.pod.Name,
+		Node:      dp.pod.Spec.NodeName,
+		Requests:  dp.requests,
+		PlacedOn:  dp.node,
+	}
+}
+
+// drainJSON is what-if's JSON output of a drain. Reasons are why the drain
+// isn't ok, by code, empty when it is.
+type drainJSON struct {
+	Drained   []string         `json:"drained"`
+	Ok        bool             `json:"ok"`
+	Reasons   []*summaryReason `json:"reasons"`
+	Details   []*reason        `json:"details"`
+	Displaced []*drainPodJSON  `json:"displaced"`
+	Deleted   []*drainPodJSON  `json:"deleted"`
+	Remaining map[string]int64 `json:"remaining"`
+}
+
+func (s *drainSimulation) writeJSON(w io.Writer) error {
+	doc := &drainJSON{
+		Drained:   s.drained,
+		Ok:        s.ok(),
+		Details:   []*reason{},
+		Displaced: []*drainPodJSON{},
+		Deleted:   []*drainPodJSON{},
+		Remaining: map[string]int64{},
+	}
+
+	for _, dp := range s.displaced {
+		doc.Displaced = append(doc.Displaced, newDrainPodJSON(dp))
+
+		if dp.node == "" {
+			doc.Details = append(doc.Details, &reason{ReasonNoNodeFits, fmt.Sprintf("%s/%s fits on no remaining node", dp.pod.Namespace, dp.pod.Name)})
+		}
+	}
+
+	for _, dp := range s.bare {
+		doc.Deleted = append(doc.Deleted, newDrainPodJSON(dp))
+	}
+
+	for _, dn := range s.remaining {
+		doc.Remaining[dn.node.Name] = dn.schedulable
+	}
+
+	for _, v := range s.violations {
+		doc.Details = append(doc.Details, &reason{ReasonPDBBlocksEviction, fmt.Sprintf("PodDisruptionBudget %s/%s allows %d disruptions, the drain displaces %d pods", v.namespace, v.name, v.allowed, v.displaced)})
+	}
+
+	for _, dp := range s.protected {
+		doc.Details = append(doc.Details, &reason{ReasonEvictionPolicyProtects, fmt.Sprintf("%s/%s is protected by the eviction policy", dp.pod.Namespace, dp.pod.Name)})
+	}
+
+	doc.Reasons = summarizeReasons(doc.Details)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(doc)
+}
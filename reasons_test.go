@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNodeReasons(t *testing.T) {
+	messages := []string{
+		"unschedulable",
+		"NotReady",
+		"node selector",
+		"node affinity",
+		"taint dedicated=db:NoSchedule",
+		"too many pods",
+		"insufficient memory",
+		"insufficient cpu",
+		"insufficient ephemeral-storage",
+		"insufficient nvidia.com/gpu",
+		"pod anti-affinity with shop/web-1",
+		"anti-affinity of shop/db-0",
+		"pod affinity topology.kubernetes.io/zone",
+		"volume attach limit ebs.csi.aws.com",
+		"volume data zone",
+		"something new",
+	}
+
+	want := []string{
+		ReasonNodeUnschedulable,
+		ReasonNodeNotReady,
+		ReasonNodeSelectorMismatch,
+		ReasonNodeAffinityMismatch,
+		ReasonTaintNotTolerated,
+		ReasonTooManyPods,
+		ReasonInsufficientMemory,
+		ReasonInsufficientCPU,
+		ReasonInsufficientStorage,
+		ReasonInsufficientResource,
+		ReasonPodAntiAffinity,
+		ReasonPodAntiAffinity,
+		ReasonPodAffinity,
+		ReasonVolumeAttachLimit,
+		ReasonVolumeNodeConflict,
+		ReasonOther,
+	}
+
+	got := []string{}
+	for i, r := range nodeReasons(messages) {
+		got = append(got, r.Code)
+
+		if r.Message != messages[i] {
+			t.Errorf("message = %q, want %q", r.Message, messages[i])
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("codes = %v, want %v", got, want)
+	}
+}
+
+func TestFitJSON(t *testing.T) {
+	// a has 8Gi free, b 1Gi and c 7Gi but a taint.
+	ds := failoverState(map[string][]int64{
+		"a": {},
+		"b": {7},
+		"c": {1},
+	})
+
+	for i := range ds.nodes {
+		if ds.nodes[i].Name == "c" {
+			ds.nodes[i].Spec.Taints = []corev1.Taint{{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule}}
+		}
+	}
+
+	w, err := loadFitWorkload(strings.NewReader(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: web
+        resources:
+          requests:
+            memory: 3Gi
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	err = ds.fit(w).writeJSON(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := &fitJSON{}
+
+	err = json.Unmarshal(buf.Bytes(), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Ok || doc.Fitting != 2 || doc.Workload.Replicas != 3 {
+		t.Errorf("ok, fitting, replicas = %t, %d, %d", doc.Ok, doc.Fitting, doc.Workload.Replicas)
+	}
+
+	codes := map[string]int{}
+	for _, r := range doc.Reasons {
+		codes[r.Code] = r.Count
+	}
+
+	want := map[string]int{ReasonInsufficientMemory: 1, ReasonTaintNotTolerated: 1}
+	if !reflect.DeepEqual(codes, want) {
+		t.Errorf("reasons = %v, want %v", codes, want)
+	}
+
+	// Fitting, the workload has no reasons even though nodes have.
+	w.replicas = 2
+	buf.Reset()
+
+	err = ds.fit(w).writeJSON(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc = &fitJSON{}
+
+	err = json.Unmarshal(buf.Bytes(), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !doc.Ok || len(doc.Reasons) != 0 || len(doc.Nodes) != 3 {
+		t.Errorf("ok, reasons, nodes = %t, %v, %d", doc.Ok, doc.Reasons, len(doc.Nodes))
+	}
+}
+
+func TestDrainJSON(t *testing.T) {
+	ds := failoverState(map[string][]int64{
+		"a": {6},
+		"b": {4},
+	})
+
+	sim, err := ds.drain([]string{"a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim.violations = append(sim.violations, &pdbViolation{namespace: "default", name: "web", displaced: 1, allowed: 0})
+
+	buf := &bytes.Buffer{}
+
+	err = sim.writeJSON(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := &drainJSON{}
+
+	err = json.Unmarshal(buf.Bytes(), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Ok || len(doc.Displaced) != 1 || doc.Displaced[0].PlacedOn != "" {
+		t.Errorf("ok, displaced = %t, %v", doc.Ok, doc.Displaced)
+	}
+
+	codes := []string{}
+	for _, r := range doc.Reasons {
+		codes = append(codes, r.Code)
+	}
+
+	if want := []string{ReasonNoNodeFits, ReasonPDBBlocksEviction}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("reasons = %v, want %v", codes, want)
+	}
+}
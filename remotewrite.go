@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteOutput sends the report's metrics to a Prometheus remote-write
+// receiver (Prometheus, Mimir, Thanos, VictoriaMetrics, ...). The series sent
+// before but no longer present are marked stale, when tracked across reports
+// by series, and only the labels allowed are sent.
+type remoteWriteOutput struct {
+	url         string
+	bearerToken string
+	client      *http.Client
+	labels      []string
+	series      *exportedSeries
+	md          *kubecap.Metadata
+	nodes       []*kubecap.NodeReport
+}
+
+func newRemoteWriteOutput(url, bearerTokenFile string, labels []string, series *exportedSeries) (*remoteWriteOutput, error) {
+	rw := &remoteWriteOutput{
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+		labels: labels,
+		series: series,
+	}
+
+	if bearerTokenFile != "" {
+		token, err := ioutil.ReadFile(bearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+
+		rw.bearerToken = strings.TrimSpace(string(token))
+	}
+
+	return rw, nil
+}
+
+func (rw *remoteWriteOutput) Metadata(m *kubecap.Metadata) error {
+	rw.md = m
+	rw.nodes = nil
+
+	return nil
+}
+
+func (rw *remoteWriteOutput) Node(n *kubecap.NodeReport) error {
+	rw.nodes = append(rw.nodes, n)
+
+	return nil
+}
+
+func (rw *remoteWriteOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+// encodeWriteRequest encodes the samples as a prometheus.WriteRequest
+// protobuf message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(samples []sample, timestamp time.Time) []byte {
+	ms := timestamp.UnixNano() / int64(time.Millisecond)
+
+	var req []byte
+
+	for _, s := range samples {
+		var ts []byte
+
+		label := func(name, value string) {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, name)
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, l)
+		}
+
+		// Labels must be sorted by name; __name__ sorts first. Empty labels
+		// are the same as missing ones in Prometheus so they are left out.
+		label("__name__", s.name)
+		for _, name := range s.sortedLabelNames() {
+			if s.labels[name] != "" {
+				label(name, s.labels[name])
+			}
+		}
+
+		var smp []byte
+		smp = protowire.AppendTag(smp, 1, protowire.Fixed64Type)
+		smp = protowire.AppendFixed64(smp, math.Float64bits(s.value))
+		smp = protowire.AppendTag(smp, 2, protowire.VarintType)
+		smp = protowire.AppendVarint(smp, uint64(ms))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, smp)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+
+	return req
+}
+
+func (rw *remoteWriteOutput) Flush() error {
+	samples := limitLabels(reportSamples(rw.md, rw.nodes), rw.labels)
+
+	sent := samples
+	if rw.series != nil {
+		sent = append(rw.series.stale(rw.md.Context, samples), samples...)
+	}
+
+	body := snappy.Encode(nil, encodeWriteRequest(sent, rw.md.Timestamp))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rw.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "kubecap")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	if rw.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+rw.bearerToken)
+	}
+
+	resp, err := rw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("prometheus remote write: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	if rw.series != nil {
+		rw.series.exported(rw.md.Context, samples)
+	}
+
+	return nil
+}
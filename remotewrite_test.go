@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEncodeWriteRequest(t *testing.T) {
+	ts := time.Unix(1700000000, 123456789)
+
+	req := decodeProto(t, encodeWriteRequest([]sample{
+		{
+			name:   "kubecap_node_allocatable_bytes",
+			labels: map[string]string{"node": "n1", "context": "prod", "group": ""},
+			value:  1024,
+		},
+		{
+			name:  "kubecap_cluster_nodes",
+			value: 3,
+		},
+	}, ts))
+
+	if len(req[1]) != 2 {
+		t.Fatalf("timeseries = %d, want 2", len(req[1]))
+	}
+
+	series := decodeProto(t, req[1][0].([]byte))
+
+	// Labels are sorted with __name__ first and empty ones left out.
+	want := [][2]string{
+		{"__name__", "kubecap_node_allocatable_bytes"},
+		{"context", "prod"},
+		{"node", "n1"},
+	}
+
+	if len(series[1]) != len(want) {
+		t.Fatalf("labels = %d, want %d", len(series[1]), len(want))
+	}
+
+	for i, w := range want {
+		l := decodeProto(t, series[1][i].([]byte))
+
+		name, value := string(l[1][0].([]byte)), string(l[2][0].([]byte))
+		if name != w[0] || value != w[1] {
+			t.Errorf("label %d = %s=%q, want %s=%q", i, name, value, w[0], w[1])
+		}
+	}
+
+	smp := decodeProto(t, series[2][0].([]byte))
+
+	if got := math.Float64frombits(smp[1][0].(uint64)); got != 1024 {
+		t.Errorf("value = %v, want 1024", got)
+	}
+
+	if got := smp[2][0].(uint64); got != 1700000000123 {
+		t.Errorf("timestamp = %d, want milliseconds 1700000000123", got)
+	}
+
+	series = decodeProto(t, req[1][1].([]byte))
+	if len(series[1]) != 1 {
+		t.Errorf("labels of unlabelled sample = %d, want only __name__", len(series[1]))
+	}
+}
+
+func TestExportedSeries(t *testing.T) {
+	e := newExportedSeries()
+
+	n1 := sample{name: "kubecap_node_used_bytes", labels: map[string]string{"cluster": "prod", "node": "n1"}, value: 1}
+	n2 := sample{name: "kubecap_node_used_bytes", labels: map[string]string{"cluster": "prod", "node": "n2"}, value: 2}
+
+	if stale := e.stale("prod", []sample{n1, n2}); len(stale) != 0 {
+		t.Errorf("first export: stale = %+v", stale)
+	}
+
+	e.exported("prod", []sample{n1, n2})
+
+	// Other clusters' series are tracked apart.
+	if stale := e.stale("dev", nil); len(stale) != 0 {
+		t.Errorf("other cluster: stale = %+v", stale)
+	}
+
+	// n2 was deleted: its series is marked stale.
+	stale := e.stale("prod", []sample{n1})
+	if len(stale) != 1 || stale[0].labels["node"] != "n2" || math.Float64bits(stale[0].value) != math.Float64bits(staleNaN) {
+		t.Errorf("stale = %+v", stale)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+)
+
+// reportRuns collects runs reports from the cluster interval apart and
+// renders the last with its own Output from newOut, writing to outputFile if
+// given and stdout otherwise. With spread a table of how each node's used and
+// free amounts varied across the runs follows, so one-off spikes can be told
+// apart from steady usage.
+func reportRuns(ctx context.Context, c *cluster, outputFile string, md kubecap.Metadata, newOut func(w io.Writer) (kubecap.Output, error), runs int, interval time.Duration, spread bool) error {
+	reports := []*kubecap.ClusterReport{}
+
+	for i := 0; i < runs; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+
+		r := &kubecap.ClusterReport{}
+
+		err := collectReport(ctx, c, md, &recordOutput{r})
+		if err != nil {
+			return fmt.Errorf("run %d: %w", i+1, err)
+		}
+
+		reports = append(reports, r)
+	}
+
+	var w io.Writer = os.Stdout
+
+	var af *atomicFile
+	if outputFile != "" {
+		var err error
+
+		af, err = createAtomic(outputFile)
+		if err != nil {
+			return err
+		}
+		defer af.Abort()
+
+		w = af
+	}
+
+	out, err := newOut(w)
+	if err != nil {
+		return err
+	}
+
+	err = replay(reports[len(reports)-1], out)
+	if err != nil {
+		return err
+	}
+
+	if spread {
+		fmt.Fprintln(w)
+		writeSpread(w, reports)
+	}
+
+	if af != nil {
+		return af.Commit()
+	}
+
+	return nil
+}
+
+// nodeSpread is a node's used and free amounts in each run it was reported
+// in and how many of those it was ok in.
+type nodeSpread struct {
+	name string
+	used []int64
+	free []int64
+	ok   int
+}
+
+// spreadStats returns the samples' minimum, mean, maximum and (population)
+// standard deviation.
+func spreadStats(samples []int64) (min, mean, max, stddev int64) {
+	if len(samples) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	min, max = samples[0], samples[0]
+
+	var sum float64
+	for _, s := range samples {
+		if s < min {
+			min = s
+		}
+
+		if s > max {
+			max = s
+		}
+
+		sum += float64(s)
+	}
+
+	m := sum / float64(len(samples))
+
+	var squares float64
+	for _, s := range samples {
+		squares += (float64(s) - m) * (float64(s) - m)
+	}
+
+	return min, int64(math.Round(m)), max, int64(math.Round(math.Sqrt(squares / float64(len(samples)))))
+}
+
+// writeSpread writes each node's used and free amounts across the reports.
+func writeSpread(w io.Writer, reports []*kubecap.ClusterReport) {
+	spreads := map[string]*nodeSpread{}
+
+	for _, r := range reports {
+		for _, n := range r.Nodes {
+			s, ok := spreads[n.Name]
+			if !ok {
+				s = &nodeSpread{name: n.Name}
+				spreads[n.Name] = s
+			}
+
+			s.used = append(s.used, n.Used)
+			s.free = append(s.free, n.Free)
+
+			if n.Ok {
+				s.ok++
+			}
+		}
+	}
+
+	names := make([]string, 0, len(spreads))
+	for name := range spreads {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Node", "Runs", "Used Min", "Used Mean", "Used Max", "Used StdDev", "Free Min", "Free Mean", "Free Max", "Free StdDev", "Ok Runs"})
+
+	for _, name := range names {
+		s := spreads[name]
+
+		row := []string{name, fmt.Sprintf("%d", len(s.used))}
+
+		for _, samples := range [][]int64{s.used, s.free} {
+			min, mean, max, stddev := spreadStats(samples)
+			row = append(row, humanize.Comma(min), humanize.Comma(mean), humanize.Comma(max), humanize.Comma(stddev))
+		}
+
+		row = append(row, fmt.Sprintf("%d/%d", s.ok, len(s.used)))
+
+		table.Append(row)
+	}
+
+	fmt.Fprintf(w, "Spread Report (%d runs)\n", len(reports))
+	table.Render()
+}
+
+// watchRand jitters the watch. It is seeded apart from math/rand's default
+// source, which starts every instance on the same sequence.
+var watchRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// stagger returns a random delay of up to the fraction of the watch interval,
+// so that instances started together don't report in step.
+func stagger(interval time.Duration, fraction float64) time.Duration {
+	return time.Duration(watchRand.Float64() * fraction * float64(interval))
+}
+
+// jitter returns the watch interval varied randomly by up to the fraction
+// either way.
+func jitter(interval time.Duration, fraction float64) time.Duration {
+	return time.Duration(float64(interval) * (1 + fraction*(2*watchRand.Float64()-1)))
+}
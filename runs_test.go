@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestSpreadStats(t *testing.T) {
+	min, mean, max, stddev := spreadStats([]int64{2, 4, 4, 4, 5, 5, 7, 9})
+	if min != 2 || mean != 5 || max != 9 || stddev != 2 {
+		t.Errorf("stats = %d, %d, %d, %d", min, mean, max, stddev)
+	}
+}
+
+func TestReportRuns(t *testing.T) {
+	c, closeSimulation, err := loadSimulatedCluster("testdata/simulation.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSimulation()
+
+	path := filepath.Join(t.TempDir(), "report.txt")
+
+	newOut := func(w io.Writer) (kubecap.Output, error) {
+		return newOutput("table", w, "4Gi", false)
+	}
+
+	err = reportRuns(context.Background(), c, path, kubecap.Metadata{AdditionalInput: "4Gi"}, newOut, 3, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := string(b)
+
+	// The last run's report is followed by the spread, which is flat as the
+	// simulation doesn't change.
+	if strings.Count(out, "Node Report") != 1 || !strings.Contains(out, "Spread Report (3 runs)") {
+		t.Errorf("report:\n%s", out)
+	}
+
+	for _, want := range []string{
+		`(?m)^\| node-a +\| +3 +\|.*\| +0 +\| +0/3 +\|$`,
+		`(?m)^\| node-b +\| +3 +\|.*\| +0 +\| +3/3 +\|$`,
+	} {
+		if !regexp.MustCompile(want).MatchString(out) {
+			t.Errorf("spread missing %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := stagger(time.Minute, 0.2); d < 0 || d > 12*time.Second {
+			t.Fatalf("stagger = %s, want at most 12s", d)
+		}
+
+		if d := jitter(time.Minute, 0.2); d < 48*time.Second || d > 72*time.Second {
+			t.Fatalf("jitter = %s, want 48s to 72s", d)
+		}
+	}
+
+	if d := jitter(time.Minute, 0); d != time.Minute {
+		t.Errorf("no jitter = %s", d)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Dispatcher uploads the report to an S3 (or S3 compatible) bucket under
+// prefix, signing the request with AWS Signature Version 4.
+type s3Dispatcher struct {
+	bucket string
+	prefix string
+
+	// endpoint overrides AWS S3 (e.g. MinIO); it is addressed path-style.
+	endpoint string
+
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+
+	client *http.Client
+}
+
+// newS3Dispatcher uploads to s3URL, given as s3://bucket/prefix.
+func newS3Dispatcher(s3URL, endpoint, region, accessKeyID, secretAccessKey, sessionToken string) (*s3Dispatcher, error) {
+	u, err := url.Parse(s3URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q: expected s3://bucket/prefix", s3URL)
+	}
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &s3Dispatcher{
+		bucket:          u.Host,
+		prefix:          prefix,
+		endpoint:        strings.TrimSuffix(endpoint, "/"),
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		client:          &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// s3EscapePath escapes everything but unreserved characters and slashes, as
+// Signature Version 4 canonical URIs require.
+func s3EscapePath(path string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// objectURL is the URL of the object with the key.
+func (d *s3Dispatcher) objectURL(key string) string {
+	path := s3EscapePath("/" + key)
+
+	if d.endpoint != "" {
+		return d.endpoint + "/" + d.bucket + path
+	}
+
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", d.bucket, d.region, path)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// sign adds the AWS Signature Version 4 headers to the request for body,
+// signing all of its headers.
+func (d *s3Dispatcher) sign(req *http.Request, body []byte, now time.Time) {
+	date := now.UTC().Format("20060102")
+	timestamp := now.UTC().Format("20060102T150405Z")
+
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+
+	req.Header.Set("X-Amz-Date", timestamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if d.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", d.sessionToken)
+	}
+
+	// Every header set so far is signed, along with the host.
+	names := []string{"host"}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + d.region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		timestamp,
+		scope,
+		hex.EncodeToString(crHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+d.secretAccessKey), date)
+	key = hmacSHA256(key, d.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.accessKeyID,
+		scope,
+		signedHeaders,
+		hex.EncodeToString(hmacSHA256(key, stringToSign)),
+	))
+}
+
+func (d *s3Dispatcher) dispatch(ctx context.Context, r *scheduledReport) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, d.objectURL(d.prefix+r.filename()), bytes.NewReader(r.body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", r.contentType())
+	d.sign(req, r.body, time.Now())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("s3 report: %s: %s", resp.Status, msg)
+	}
+
+	return nil
+}
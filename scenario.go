@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// scenario is a set of hypothetical changes to the cluster evaluated together
+// by what-if --scenario, so that capacity reviews can be rerun from the same
+// inputs. The changes apply in order: requests change, nodes are removed
+// (drained) and added, then the workloads are fitted one after the other.
+type scenario struct {
+	Name string `json:"name"`
+
+	RequestChanges []*scenarioRequestChange `json:"requestChanges"`
+	RemoveNodes    []string                 `json:"removeNodes"`
+	AddNodes       []*scenarioNodes         `json:"addNodes"`
+	Workloads      []*scenarioWorkload      `json:"workloads"`
+}
+
+// scenarioRequestChange scales the requests of the existing pods matching its
+// namespace (all when empty) and label selector by factor, e.g. 1.5 for a
+// planned 50% increase.
+type scenarioRequestChange struct {
+	Namespace string  `json:"namespace"`
+	Selector  string  `json:"selector"`
+	Factor    float64 `json:"factor"`
+
+	selector labels.Selector
+}
+
+// scenarioNodes are count empty nodes added with the labels, taints and
+// allocatable resources given, named name-1, name-2 and so on.
+type scenarioNodes struct {
+	Name        string              `json:"name"`
+	Count       int                 `json:"count"`
+	Labels      map[string]string   `json:"labels"`
+	Taints      []corev1.Taint      `json:"taints"`
+	Allocatable corev1.ResourceList `json:"allocatable"`
+}
+
+// scenarioWorkload is a workload to fit, read from a manifest file (relative
+// to the scenario's) or given inline, as for the fit subcommand. Replicas
+// overrides the manifest's.
+type scenarioWorkload struct {
+	File     string          `json:"file"`
+	Manifest json.RawMessage `json:"manifest"`
+	Replicas int64           `json:"replicas"`
+
+	workload *fitWorkload
+}
+
+// loadScenario reads the scenario file and the workload manifests it refers
+// to.
+func loadScenario(path string) (*scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := parseScenario(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return s, nil
+}
+
+// parseScenario parses a scenario, reading the workload files it refers to
+// relative to dir. Without a dir the workloads must be given inline.
+func parseScenario(data []byte, dir string) (*scenario, error) {
+	s := &scenario{}
+
+	err := yaml.UnmarshalStrict(data, s)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rc := range s.RequestChanges {
+		if rc.Factor <= 0 {
+			return nil, fmt.Errorf("request change %q: factor must be positive: %g", rc.Selector, rc.Factor)
+		}
+
+		rc.selector, err = labels.Parse(rc.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("request change %q: %w", rc.Selector, err)
+		}
+	}
+
+	for _, n := range s.AddNodes {
+		if n.Name == "" || len(n.Allocatable) == 0 {
+			return nil, fmt.Errorf("addNodes: added nodes need a name and allocatable resources")
+		}
+
+		if n.Count == 0 {
+			n.Count = 1
+		}
+	}
+
+	for i, sw := range s.Workloads {
+		manifest := []byte(sw.Manifest)
+
+		switch {
+		case sw.File != "" && len(manifest) == 0 && dir == "":
+			return nil, fmt.Errorf("workload %d: want the manifest inline", i+1)
+		case sw.File != "" && len(manifest) == 0:
+			file := sw.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+
+			manifest, err = os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+		case sw.File != "" || len(manifest) == 0:
+			return nil, fmt.Errorf("workload %d: want either a file or a manifest", i+1)
+		}
+
+		sw.workload, err = loadFitWorkload(bytes.NewReader(manifest))
+		if err != nil {
+			return nil, fmt.Errorf("workload %d: %w", i+1, err)
+		}
+
+		if sw.Replicas > 0 {
+			sw.workload.replicas = sw.Replicas
+		}
+	}
+
+	return s, nil
+}
+
+// nodes returns the added nodes.
+func (n *scenarioNodes) nodes() []*corev1.Node {
+	nodes := []*corev1.Node{}
+
+	for i := 1; i <= n.Count; i++ {
+		nodes = append(nodes, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   fmt.Sprintf("%s-%d", n.Name, i),
+				Labels: n.Labels,
+			},
+			Spec: corev1.NodeSpec{
+				Taints: n.Taints,
+			},
+			Status: corev1.NodeStatus{
+				Capacity:    n.Allocatable,
+				Allocatable: n.Allocatable,
+			},
+		})
+	}
+
+	return nodes
+}
+
+// scaleRequests scales each request in rl by factor.
+func scaleRequests(rl corev1.ResourceList, factor float64) {
+	for name, q := range rl {
+		rl[name] = *resource.NewMilliQuantity(int64(float64(q.MilliValue())*factor), q.Format)
+	}
+}
+
+// changeRequests applies the request change to the pods matching it and
+// returns how many did.
+func (ds *drainState) changeRequests(rc *scenarioRequestChange) int {
+	changed := 0
+
+	for _, pods := range ds.nps {
+		for _, pod := range pods {
+			if rc.Namespace != "" && pod.Namespace != rc.Namespace {
+				continue
+			}
+
+			if !rc.selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+
+			for i := range pod.Spec.Containers {
+				scaleRequests(pod.Spec.Containers[i].Resources.Requests, rc.Factor)
+			}
+
+			for i := range pod.Spec.InitContainers {
+				scaleRequests(pod.Spec.InitContainers[i].Resources.Requests, rc.Factor)
+			}
+
+			if r, ok := ds.podLevel[pod.Namespace+"/"+pod.Name]; ok {
+				scaleRequests(r.Requests, rc.Factor)
+			}
+
+			changed++
+		}
+	}
+
+	return changed
+}
+
+// after returns the state once the simulated drain completed: the remaining
+// and added nodes with the displaced pods on the nodes they were placed on.
+// Pods placed nowhere are left pending and those without a controller
+// deleted.
+func (ds *drainState) after(sim *drainSimulation) *drainState {
+	next := &drainState{
+		nps:      kubecap.NodePods{},
+		podLevel: ds.podLevel,
+	}
+
+	for _, dn := range sim.remaining {
+		next.nodes = append(next.nodes, *dn.node)
+
+		for _, pod := range ds.nps[dn.node.Name] {
+			next.nps.Add(pod)
+		}
+	}
+
+	for _, dp := range sim.displaced {
+		if dp.node == "" {
+			continue
+		}
+
+		pod := dp.pod.DeepCopy()
+		pod.Spec.NodeName = dp.node
+		next.nps.Add(pod)
+	}
+
+	return next
+}
+
+// place adds as many of the workload's replicas as fit to the nodes, those
+// with room for the most first, so that later workloads see them. It returns
+// how many were placed.
+func (ds *drainState) place(w *fitWorkload, r *fitReport) int64 {
+	spec := w.spec.DeepCopy()
+
+	// The replicas request what the API server would default their
+	// containers' requests to.
+	for i := range spec.Containers {
+		c := &spec.Containers[i]
+
+		requests := corev1.ResourceList{}
+		for name, q := range c.Resources.Limits {
+			requests[name] = q
+		}
+
+		for name, q := range c.Resources.Requests {
+			requests[name] = q
+		}
+
+		c.Resources.Requests = requests
+	}
+
+	var placed int64
+
+	for _, n := range r.nodes {
+		for i := int64(0); i < n.replicas && placed < w.replicas; i++ {
+			placed++
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: w.namespace,
+					Name:      fmt.Sprintf("%s-scenario-%d", w.name, placed),
+				},
+				Spec: *spec.DeepCopy(),
+				Status: corev1.PodStatus{
+					Phase: corev1.PodRunning,
+				},
+			}
+			pod.Spec.NodeName = n.name
+
+			ds.nps.Add(pod)
+		}
+	}
+
+	return placed
+}
+
+// scenarioChange is a request change and how many pods it applied to.
+type scenarioChange struct {
+	change *scenarioRequestChange
+	pods   int
+}
+
+// scenarioFit is how many replicas of a workload were placed.
+type scenarioFit struct {
+	workload *fitWorkload
+	placed   int64
+}
+
+// scenarioReport is the consolidated outcome of a scenario.
+type scenarioReport struct {
+	name    string
+	changes []*scenarioChange
+	drain   *drainSimulation
+	added   []string
+	fits    []*scenarioFit
+
+	// state is the cluster once every change was applied.
+	state *drainState
+}
+
+// ok reports whether the drain succeeded and every workload's replicas were
+// placed.
+func (r *scenarioReport) ok() bool {
+	if !r.drain.ok() {
+		return false
+	}
+
+	for _, f := range r.fits {
+		if f.placed < f.workload.replicas {
+			return false
+		}
+	}
+
+	return true
+}
+
+// run applies the scenario to the state. PodDisruptionBudgets are checked
+// against the pods the removed nodes displace.
+func (s *scenario) run(ds *drainState, pdbs []policyv1.PodDisruptionBudget) (*scenarioReport, error) {
+	r := &scenarioReport{name: s.Name}
+
+	for _, rc := range s.RequestChanges {
+		r.changes = append(r.changes, &scenarioChange{
+			change: rc,
+			pods:   ds.changeRequests(rc),
+		})
+	}
+
+	added := []*corev1.Node{}
+	for _, n := range s.AddNodes {
+		for _, node := range n.nodes() {
+			added = append(added, node)
+			r.added = append(r.added, node.Name)
+		}
+	}
+
+	var err error
+
+	r.drain, err = ds.drain(s.RemoveNodes, added)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.drain.checkBudgets(pdbs)
+	if err != nil {
+		return nil, err
+	}
+
+	r.state = ds.after(r.drain)
+
+	for _, sw := range s.Workloads {
+		r.fits = append(r.fits, &scenarioFit{
+			workload: sw.workload,
+			placed:   r.state.place(sw.workload, r.state.fit(sw.workload)),
+		})
+	}
+
+	return r, nil
+}
+
+// runScenario lists the cluster's state and runs the scenario against it.
+func runScenario(ctx context.Context, kcs kubernetes.Interface, s *scenario) (*scenarioReport, error) {
+	ds, err := listDrainState(ctx, kcs)
+	if err != nil {
+		return nil, err
+	}
+
+	pdbList, err := kcs.PolicyV1().PodDisruptionBudgets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.run(ds, pdbList.Items)
+}
+
+func (r *scenarioReport) write(w io.Writer) {
+	fmt.Fprintf(w, "Scenario: %s\n", r.name)
+
+	if len(r.drain.drained) > 0 {
+		unplaced := 0
+		for _, dp := range r.drain.displaced {
+			if dp.node == "" {
+				unplaced++
+			}
+		}
+
+		fmt.Fprintf(w, "Removed: %s (%d pods displaced, %d unplaceable, %d deleted)\n", strings.Join(r.drain.drained, ", "), len(r.drain.displaced), unplaced, len(r.drain.bare))
+	}
+
+	if len(r.added) > 0 {
+		fmt.Fprintf(w, "Added: %s\n", strings.Join(r.added, ", "))
+	}
+
+	fmt.Fprintln(w)
+
+	if len(r.changes) > 0 {
+		changeTable := tablewriter.NewWriter(w)
+		changeTable.SetHeader([]string{"Namespace", "Selector", "Factor", "Pods"})
+		for _, c := range r.changes {
+			changeTable.Append([]string{dashIfEmpty(c.change.Namespace), dashIfEmpty(c.change.Selector), fmt.Sprintf("%g", c.change.Factor), fmt.Sprintf("%d", c.pods)})
+		}
+
+		fmt.Fprintln(w, "Request Changes")
+		changeTable.Render()
+	}
+
+	if len(r.fits) > 0 {
+		fitTable := tablewriter.NewWriter(w)
+		fitTable.SetHeader([]string{"Workload", "Replicas", "Placed"})
+		for _, f := range r.fits {
+			name := f.workload.name
+			if f.workload.namespace != "" {
+				name = f.workload.namespace + "/" + name
+			}
+
+			fitTable.Append([]string{f.workload.kind + " " + name, fmt.Sprintf("%d", f.workload.replicas), fmt.Sprintf("%d", f.placed)})
+		}
+
+		fmt.Fprintln(w, "Workloads")
+		fitTable.Render()
+	}
+
+	nodeTable := tablewriter.NewWriter(w)
+	nodeTable.SetHeader([]string{"Node", "Schedulable After"})
+	for i := range r.state.nodes {
+		node := &r.state.nodes[i]
+		schedulable := node.Status.Allocatable.Memory().Value() - r.state.nps.MemoryRequests(node.Name, r.state.podLevel).Value()
+
+		nodeTable.Append([]string{node.Name, humanize.Comma(schedulable)})
+	}
+
+	fmt.Fprintln(w, "Nodes")
+	nodeTable.Render()
+
+	unplaced := []string{}
+	for _, dp := range r.drain.displaced {
+		if dp.node == "" {
+			unplaced = append(unplaced, fmt.Sprintf("%s/%s (%s)", dp.pod.Namespace, dp.pod.Name, humanize.IBytes(nonNegative(dp.requests))))
+		}
+	}
+
+	if len(unplaced) > 0 {
+		fmt.Fprintf(w, "Unplaceable: %s\n", strings.Join(unplaced, ", "))
+	}
+
+	for _, v := range r.drain.violations {
+		fmt.Fprintf(w, "Disruption budget %s/%s allows %d disruptions, %d pods displaced\n", v.namespace, v.name, v.allowed, v.displaced)
+	}
+
+	fmt.Fprintf(w, "Ok? %t\n", r.ok())
+}
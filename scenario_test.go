@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScenario(t *testing.T) {
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "batch.yaml"), []byte(`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: batch
+spec:
+  containers:
+  - name: batch
+    resources:
+      limits:
+        memory: 1Gi
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "review.yaml")
+
+	err = os.WriteFile(path, []byte(`
+requestChanges:
+- namespace: default
+  factor: 1.5
+removeNodes: [a]
+addNodes:
+- name: new
+  allocatable:
+    memory: 8Gi
+workloads:
+- manifest:
+    apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: web
+      namespace: shop
+    spec:
+      replicas: 3
+      template:
+        spec:
+          containers:
+          - name: web
+            resources:
+              requests:
+                memory: 2Gi
+- file: batch.yaml
+  replicas: 5
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := loadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Name != "review" || len(s.Workloads) != 2 || s.Workloads[1].workload.replicas != 5 {
+		t.Fatalf("scenario = %+v", s)
+	}
+
+	// Scaled by 1.5 a's 6Gi becomes 9Gi, b's 3Gi and c's 1.5Gi. Draining a
+	// places its 6Gi pod on c and its 3Gi pod on b.
+	ds := failoverState(map[string][]int64{
+		"a": {4, 2},
+		"b": {2},
+		"c": {1},
+	})
+
+	r, err := s.run(ds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.changes) != 1 || r.changes[0].pods != 4 {
+		t.Errorf("changes = %+v, want 4 pods changed", r.changes)
+	}
+
+	if !r.drain.ok() || len(r.added) != 1 || r.added[0] != "new-1" {
+		t.Fatalf("drain ok, added = %t, %v", r.drain.ok(), r.added)
+	}
+
+	for _, dp := range r.drain.displaced {
+		if want := map[int64]string{6 << 30: "c", 3 << 30: "b"}[dp.requests]; dp.node != want {
+			t.Errorf("%s (%d) placed on %q, want %q", dp.pod.Name, dp.requests, dp.node, want)
+		}
+	}
+
+	// The web replicas all fit on the new node, leaving room for 2 batch
+	// pods there and 2 on b.
+	if r.fits[0].placed != 3 || r.fits[1].placed != 4 {
+		t.Errorf("placed = %d, %d, want 3, 4", r.fits[0].placed, r.fits[1].placed)
+	}
+
+	if r.ok() {
+		t.Error("ok with a batch pod unplaced")
+	}
+
+	for _, bad := range []string{
+		"requestChanges: [{factor: 0}]",
+		"workloads: [{file: batch.yaml, manifest: {kind: Pod}}]",
+		"addNodes: [{name: new}]",
+		"removeNode: [a]",
+	} {
+		err := os.WriteFile(path, []byte(bad), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := loadScenario(path); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+)
+
+// reportFormats are the extension and content type of each output format.
+var reportFormats = map[string]struct {
+	ext         string
+	contentType string
+}{
+	"table": {"txt", "text/plain; charset=utf-8"},
+	"wide":  {"txt", "text/plain; charset=utf-8"},
+	"jsonl": {"jsonl", "application/x-ndjson"},
+	"html":  {"html", "text/html; charset=utf-8"},
+	"dot":   {"dot", "text/vnd.graphviz"},
+	"xlsx":  {"xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+}
+
+// filenameUnsafe matches characters left out of report file names.
+var filenameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// scheduledReport is a full report rendered for dispatch.
+type scheduledReport struct {
+	md     *kubecap.Metadata
+	sum    kubecap.Summary
+	format string
+	body   []byte
+}
+
+// filename names the report after its context and collection time.
+func (r *scheduledReport) filename() string {
+	name := "kubecap"
+	if r.md.Context != "" {
+		name += "-" + filenameUnsafe.ReplaceAllString(r.md.Context, "_")
+	}
+
+	return fmt.Sprintf("%s-%s.%s", name, r.md.Timestamp.Format("20060102T150405Z"), reportFormats[r.format].ext)
+}
+
+func (r *scheduledReport) contentType() string {
+	return reportFormats[r.format].contentType
+}
+
+// summary is a one paragraph plain text summary of the report.
+func (r *scheduledReport) summary() string {
+	return fmt.Sprintf(
+		"kubecap report for %s at %s: %d/%d nodes ok for %s additional, %s schedulable (%s allocatable, %s requested).",
+		r.md.Context,
+		r.md.Timestamp.Format(time.RFC3339),
+		r.sum.OkNodes,
+		r.sum.Nodes,
+		r.md.AdditionalInput,
+		humanize.IBytes(nonNegative(r.sum.Schedulable)),
+		humanize.IBytes(nonNegative(r.sum.Allocatable)),
+		humanize.IBytes(nonNegative(r.sum.Requests)),
+	)
+}
+
+// reportDispatcher delivers a scheduled report somewhere.
+type reportDispatcher interface {
+	dispatch(ctx context.Context, r *scheduledReport) error
+}
+
+// scheduledOutput renders the report in its format to a buffer and, once
+// complete, hands it to each of the dispatchers.
+type scheduledOutput struct {
+	kubecap.Output
+
+	format      string
+	buf         *bytes.Buffer
+	dispatchers []reportDispatcher
+	md          *kubecap.Metadata
+	nodes       []*kubecap.NodeReport
+}
+
+func newScheduledOutput(format, additionalAmountStr string, showCluster bool, dispatchers []reportDispatcher) (*scheduledOutput, error) {
+	buf := &bytes.Buffer{}
+
+	out, err := newOutput(format, buf, additionalAmountStr, showCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scheduledOutput{
+		Output:      out,
+		format:      format,
+		buf:         buf,
+		dispatchers: dispatchers,
+	}, nil
+}
+
+func (s *scheduledOutput) Metadata(m *kubecap.Metadata) error {
+	s.md = m
+	s.nodes = nil
+
+	return s.Output.Metadata(m)
+}
+
+func (s *scheduledOutput) Node(n *kubecap.NodeReport) error {
+	s.nodes = append(s.nodes, n)
+
+	return s.Output.Node(n)
+}
+
+func (s *scheduledOutput) Flush() error {
+	err := s.Output.Flush()
+	if err != nil {
+		return err
+	}
+
+	r := &scheduledReport{
+		md:     s.md,
+		sum:    kubecap.Summarize(s.nodes),
+		format: s.format,
+		body:   s.buf.Bytes(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	// Deliver to every sink even if one fails.
+	var first error
+	for _, d := range s.dispatchers {
+		err = d.dispatch(ctx, r)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// runSchedule calls run each time the schedule is due, forever. Errors are
+// logged and the schedule carries on.
+func runSchedule(sched *cronSchedule, run func() error) {
+	for {
+		next, ok := sched.next(time.Now())
+		if !ok {
+			fmt.Fprintln(os.Stderr, "report schedule is never due")
+			return
+		}
+
+		time.Sleep(time.Until(next))
+
+		err := run()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("scheduled report: %w", err))
+		}
+	}
+}
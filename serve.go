@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// reportServer serves the latest report of the watch: its metrics in the
+// Prometheus text format at /metrics and the report itself as JSON at
+// /report. Its headroom, without any node or pod, is served at /headroom for
+// the namespaces' teams to check against. With a what-if queue it also runs
+// scenarios at /whatif. The changes in capacity from report to report are
+// streamed at /events. The reports and events are also served over gRPC
+// (HTTP/2, with or without TLS), as is fit when set.
+//
+// Only the labels allowed are exported, all when none are given. Once the
+// latest report is older than maxAge (when set), e.g. as the watch keeps
+// failing, no metrics are served so that those of nodes and pods since
+// deleted go stale rather than being scraped indefinitely.
+type reportServer struct {
+	mu     sync.Mutex
+	latest *kubecap.ClusterReport
+
+	// updated is closed once a new report is served.
+	updated chan struct{}
+
+	// events are the latest capacity events and lastEventID the ID of the
+	// last. Headroom events are for the thresholds.
+	events      []*capacityEvent
+	lastEventID int64
+	thresholds  []int64
+
+	labels []string
+	maxAge time.Duration
+
+	whatif *whatifQueue
+	fit    func(ctx context.Context, w *fitWorkload) (*fitReport, error)
+}
+
+// output returns an Output collecting a report for the server, which serves
+// it once flushed. A report failing part way keeps the previous one served.
+func (s *reportServer) output() kubecap.Output {
+	return &reportServerOutput{
+		recordOutput: recordOutput{&kubecap.ClusterReport{}},
+		s:            s,
+	}
+}
+
+func (s *reportServer) report() *kubecap.ClusterReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.latest
+}
+
+// next returns the latest report and a channel closed once it is replaced.
+func (s *reportServer) next() (*kubecap.ClusterReport, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.updated == nil {
+		s.updated = make(chan struct{})
+	}
+
+	return s.latest, s.updated
+}
+
+func (s *reportServer) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		r := s.report()
+		if r == nil {
+			http.Error(w, "no report collected yet", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		if s.maxAge > 0 && time.Since(r.Metadata.Timestamp) > s.maxAge {
+			http.Error(w, "latest report is stale, collected at "+r.Metadata.Timestamp.Format(time.RFC3339), http.StatusServiceUnavailable)
+
+			return
+		}
+
+		samples := limitLabels(append(reportSamples(r.Metadata, r.Nodes), evictableSamples(r.Metadata, r.Evictable)...), s.labels)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		err := writeExposition(w, samples)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	mux.HandleFunc("/report", func(w http.ResponseWriter, req *http.Request) {
+		r := s.report()
+		if r == nil {
+			http.Error(w, "no report collected yet", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		err := enc.Encode(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	mux.HandleFunc("/headroom", func(w http.ResponseWriter, req *http.Request) {
+		r := s.report()
+		if r == nil {
+			http.Error(w, "no report collected yet", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		err := json.NewEncoder(w).Encode(kubecap.NewClusterHeadroom(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	mux.HandleFunc("/events", s.handleEvents)
+
+	if s.whatif != nil {
+		mux.HandleFunc("/whatif", s.whatif.handle)
+		mux.HandleFunc("/whatif/", s.whatif.handle)
+	}
+
+	// gRPC calls are HTTP/2 requests, which h2c accepts without TLS too.
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isGRPC(req) {
+			s.handleGRPC(w, req)
+
+			return
+		}
+
+		mux.ServeHTTP(w, req)
+	}), &http2.Server{})
+}
+
+// reportServerOutput collects a report for its server.
+type reportServerOutput struct {
+	recordOutput
+
+	s *reportServer
+}
+
+func (o *reportServerOutput) Flush() error {
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+
+	o.s.record(diffReports(o.s.latest, o.r, o.s.thresholds))
+	o.s.latest = o.r
+
+	if o.s.updated != nil {
+		close(o.s.updated)
+		o.s.updated = nil
+	}
+
+	return nil
+}
+
+// writeExposition writes the samples in the Prometheus text exposition
+// format, grouping them by metric name in the order the names first appear.
+func writeExposition(w io.Writer, samples []sample) error {
+	names := []string{}
+	byName := map[string][]sample{}
+
+	for _, s := range samples {
+		if _, ok := byName[s.name]; !ok {
+			names = append(names, s.name)
+		}
+
+		byName[s.name] = append(byName[s.name], s)
+	}
+
+	for _, name := range names {
+		ss := byName[name]
+
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, escapeHelp(ss[0].help), name)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range ss {
+			labels := []string{}
+			for _, l := range s.sortedLabelNames() {
+				if s.labels[l] != "" {
+					labels = append(labels, l+`="`+escapeLabelValue(s.labels[l])+`"`)
+				}
+			}
+
+			series := name
+			if len(labels) > 0 {
+				series += "{" + strings.Join(labels, ",") + "}"
+			}
+
+			_, err := fmt.Fprintf(w, "%s %s\n", series, strconv.FormatFloat(s.value, 'g', -1, 64))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// escapeHelp escapes backslashes and line feeds in a HELP line.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabelValue escapes backslashes, double quotes and line feeds in a
+// label value.
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestReportServer(t *testing.T) {
+	s := &reportServer{}
+
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get("/metrics"); code != http.StatusServiceUnavailable {
+		t.Errorf("metrics before the first report: status = %d", code)
+	}
+
+	out := s.output()
+	for _, err := range []error{
+		out.Metadata(&kubecap.Metadata{Context: "prod"}),
+		out.Evictable(&kubecap.EvictableContainer{Node: "n1", Namespace: "shop", Pod: "web-0", Container: "web", Requests: 1 << 30, Used: 3 << 30}),
+		out.Node(&kubecap.NodeReport{Name: "n1", Group: `a"b`, Allocatable: 16 << 30, Schedulable: 4 << 30, Efficiency: 1.5}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing is served until the report is flushed.
+	if code, _ := get("/report"); code != http.StatusServiceUnavailable {
+		t.Errorf("report before flushing: status = %d", code)
+	}
+
+	err := out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, metrics := get("/metrics")
+	if code != http.StatusOK {
+		t.Fatalf("metrics: status = %d", code)
+	}
+
+	for _, want := range []string{
+		"# HELP kubecap_node_allocatable_bytes Allocatable memory on the node.\n# TYPE kubecap_node_allocatable_bytes gauge\n",
+		`kubecap_node_allocatable_bytes{cluster="prod",node="n1",node_group="a\"b",resource="memory"} 1.7179869184e+10` + "\n",
+		`kubecap_node_efficiency_ratio{cluster="prod",node="n1",node_group="a\"b",resource="memory"} 1.5` + "\n",
+		`kubecap_container_over_request_bytes{cluster="prod",container="web",namespace="shop",node="n1",pod="web-0"} 2.147483648e+09` + "\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics missing %q:\n%s", want, metrics)
+		}
+	}
+
+	if n := strings.Count(metrics, "# TYPE kubecap_node_used_bytes "); n != 1 {
+		t.Errorf("kubecap_node_used_bytes described %d times, want once", n)
+	}
+
+	code, body := get("/report")
+	if code != http.StatusOK {
+		t.Fatalf("report: status = %d", code)
+	}
+
+	var r kubecap.ClusterReport
+
+	err = json.Unmarshal([]byte(body), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Metadata == nil || r.Metadata.Context != "prod" || len(r.Nodes) != 1 || len(r.Evictable) != 1 {
+		t.Errorf("report = %s", body)
+	}
+
+	// The headroom is advertised without the nodes and pods.
+	code, body = get("/headroom")
+	if code != http.StatusOK {
+		t.Fatalf("headroom: status = %d", code)
+	}
+
+	var h kubecap.ClusterHeadroom
+
+	err = json.Unmarshal([]byte(body), &h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Nodes != 1 || h.Schedulable != 4<<30 || h.Largest != 4<<30 || len(h.Groups) != 1 {
+		t.Errorf("headroom = %s", body)
+	}
+
+	if strings.Contains(body, "n1") || strings.Contains(body, "web-0") {
+		t.Errorf("headroom names a node or pod: %s", body)
+	}
+}
+
+func TestWhatifQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+
+	q := newWhatifQueue(ctx, 1, 1, 1, func(ctx context.Context, s *scenario) (*scenarioReport, error) {
+		<-release
+
+		return s.run(failoverState(map[string][]int64{"a": {4}, "b": {2}}), nil)
+	})
+
+	srv := httptest.NewServer((&reportServer{whatif: q}).handler())
+	defer srv.Close()
+
+	post := func(body string) (int, whatifJob) {
+		t.Helper()
+
+		resp, err := http.Post(srv.URL+"/whatif", "application/yaml", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var j whatifJob
+		if resp.StatusCode == http.StatusAccepted {
+			err = json.NewDecoder(resp.Body).Decode(&j)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if loc := resp.Header.Get("Location"); loc != "/whatif/"+j.ID {
+				t.Errorf("location = %q, want /whatif/%s", loc, j.ID)
+			}
+		}
+
+		return resp.StatusCode, j
+	}
+
+	// poll waits for the job to reach the state, returning it.
+	poll := func(id, state string) (int, whatifJob) {
+		t.Helper()
+
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			resp, err := http.Get(srv.URL + "/whatif/" + id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var j whatifJob
+			if resp.StatusCode == http.StatusOK {
+				err = json.NewDecoder(resp.Body).Decode(&j)
+			}
+			resp.Body.Close()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != http.StatusOK || j.State == state || time.Now().After(deadline) {
+				return resp.StatusCode, j
+			}
+		}
+	}
+
+	code, first := post("removeNodes: [a]")
+	if code != http.StatusAccepted || first.State != whatifQueued || first.Scenario != "whatif-1" {
+		t.Fatalf("first: status = %d, job = %+v", code, first)
+	}
+
+	if _, j := poll(first.ID, whatifRunning); j.State != whatifRunning {
+		t.Fatalf("first: state = %q, want running", j.State)
+	}
+
+	// One job waits while the first runs, the next is refused.
+	code, second := post("{name: review, removeNodes: [b]}")
+	if code != http.StatusAccepted || second.Scenario != "review" {
+		t.Fatalf("second: status = %d, job = %+v", code, second)
+	}
+
+	if code, _ := post("removeNodes: [b]"); code != http.StatusServiceUnavailable {
+		t.Errorf("full queue: status = %d", code)
+	}
+
+	if code, _ := post("workloads: [{file: batch.yaml}]"); code != http.StatusBadRequest {
+		t.Errorf("workload file: status = %d", code)
+	}
+
+	if code, _ := poll("9", whatifDone); code != http.StatusNotFound {
+		t.Errorf("unknown job: status = %d", code)
+	}
+
+	// The jobs are finished one at a time, as the first is retired once the
+	// second finishes.
+	release <- struct{}{}
+
+	_, j := poll(first.ID, whatifDone)
+	if j.State != whatifDone || j.Ok == nil || !*j.Ok || !strings.Contains(j.Report, "Scenario: whatif-1") {
+		t.Errorf("first = %+v", j)
+	}
+
+	release <- struct{}{}
+
+	_, j = poll(second.ID, whatifDone)
+	if j.State != whatifDone || j.Ok == nil || !*j.Ok || !strings.Contains(j.Report, "Removed: b") {
+		t.Errorf("second = %+v", j)
+	}
+
+	// Only the last finished job is kept.
+	if code, _ := poll(first.ID, whatifDone); code != http.StatusNotFound {
+		t.Errorf("retired job: status = %d", code)
+	}
+}
+
+func TestLimitLabels(t *testing.T) {
+	samples := limitLabels([]sample{
+		{name: "kubecap_node_allocatable_bytes", labels: map[string]string{"cluster": "prod", "node": "n1", "node_group": "a"}, value: 16},
+		{name: "kubecap_node_efficiency_ratio", labels: map[string]string{"cluster": "prod", "node": "n1", "node_group": "a"}, value: 1},
+		{name: "kubecap_node_allocatable_bytes", labels: map[string]string{"cluster": "prod", "node": "n2", "node_group": "a"}, value: 8},
+		{name: "kubecap_node_efficiency_ratio", labels: map[string]string{"cluster": "prod", "node": "n2", "node_group": "a"}, value: 0.5},
+		{name: "kubecap_node_allocatable_bytes", labels: map[string]string{"cluster": "prod", "node": "n3", "node_group": "b"}, value: 4},
+	}, []string{"cluster", "node_group"})
+
+	want := []struct {
+		name, group string
+		value       float64
+	}{
+		{"kubecap_node_allocatable_bytes", "a", 24},
+		{"kubecap_node_efficiency_ratio", "a", 0.75},
+		{"kubecap_node_allocatable_bytes", "b", 4},
+	}
+
+	if len(samples) != len(want) {
+		t.Fatalf("samples = %+v", samples)
+	}
+
+	for i, w := range want {
+		s := samples[i]
+		if s.name != w.name || s.labels["node_group"] != w.group || s.labels["node"] != "" || s.value != w.value {
+			t.Errorf("sample %d = %+v, want %+v", i, s, w)
+		}
+	}
+}
+
+func TestReportServerStale(t *testing.T) {
+	s := &reportServer{maxAge: time.Minute}
+
+	out := s.output()
+	for _, err := range []error{
+		out.Metadata(&kubecap.Metadata{Context: "prod", Timestamp: time.Now().Add(-2 * time.Minute)}),
+		out.Node(&kubecap.NodeReport{Name: "n1"}),
+		out.Flush(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("stale report: status = %d", resp.StatusCode)
+	}
+}
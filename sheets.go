@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"golang.org/x/oauth2/jwt"
+)
+
+// sheetsAPIURL is the Google Sheets API endpoint.
+const sheetsAPIURL = "https://sheets.googleapis.com"
+
+// sheetsOutput appends a summary row for each run to a Google Sheet,
+// authenticating as a service account.
+type sheetsOutput struct {
+	client        *http.Client
+	url           string
+	spreadsheetID string
+	sheetRange    string
+
+	md    *kubecap.Metadata
+	nodes []*kubecap.NodeReport
+}
+
+// serviceAccountKey is the subset of a Google service account JSON key
+// needed to authenticate.
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+func newSheetsOutput(credentialsFile, spreadsheetID, sheetRange string) (*sheetsOutput, error) {
+	data, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key := serviceAccountKey{}
+
+	err = json.Unmarshal(data, &key)
+	if err != nil {
+		return nil, fmt.Errorf("parsing service account key %s: %w", credentialsFile, err)
+	}
+
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	conf := &jwt.Config{
+		Email:      key.ClientEmail,
+		PrivateKey: []byte(key.PrivateKey),
+		TokenURL:   key.TokenURI,
+		Scopes:     []string{"https://www.googleapis.com/auth/spreadsheets"},
+	}
+
+	return &sheetsOutput{
+		client:        conf.Client(context.Background()),
+		url:           sheetsAPIURL,
+		spreadsheetID: spreadsheetID,
+		sheetRange:    sheetRange,
+	}, nil
+}
+
+func (s *sheetsOutput) Metadata(m *kubecap.Metadata) error {
+	s.md = m
+	s.nodes = nil
+
+	return nil
+}
+
+func (s *sheetsOutput) Node(n *kubecap.NodeReport) error {
+	s.nodes = append(s.nodes, n)
+
+	return nil
+}
+
+func (s *sheetsOutput) Evictable(e *kubecap.EvictableContainer) error {
+	return nil
+}
+
+func (s *sheetsOutput) Flush() error {
+	sum := kubecap.Summarize(s.nodes)
+
+	row := []interface{}{}
+	if s.md != nil {
+		row = append(row,
+			s.md.Timestamp.Format(time.RFC3339),
+			s.md.Context,
+			s.md.AdditionalInput,
+		)
+	}
+
+	row = append(row,
+		sum.Nodes,
+		sum.OkNodes,
+		sum.Allocatable,
+		sum.Used,
+		sum.Requests,
+		sum.Schedulable,
+	)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"values": [][]interface{}{row},
+	})
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf(
+		"%s/v4/spreadsheets/%s/values/%s:append?valueInputOption=RAW&insertDataOption=INSERT_ROWS",
+		s.url,
+		url.PathEscape(s.spreadsheetID),
+		url.PathEscape(s.sheetRange),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("appending to google sheet: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestSheetsOutput(t *testing.T) {
+	rows := [][]interface{}{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path == "/token" {
+			err := r.ParseForm()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || r.PostForm.Get("assertion") == "" {
+				t.Errorf("token request = %v", r.PostForm)
+			}
+
+			rw.Write([]byte(`{"access_token": "access-token", "token_type": "Bearer", "expires_in": 3600}`))
+
+			return
+		}
+
+		q := r.URL.Query()
+
+		if r.Method != http.MethodPost || r.URL.Path != "/v4/spreadsheets/sheet-id/values/Summary!A:I:append" ||
+			q.Get("valueInputOption") != "RAW" || q.Get("insertDataOption") != "INSERT_ROWS" {
+			t.Errorf("%s %s", r.Method, r.URL)
+		}
+
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			t.Errorf("authorization = %q", r.Header.Get("Authorization"))
+		}
+
+		body := struct {
+			Values [][]interface{} `json:"values"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows = append(rows, body.Values...)
+
+		rw.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentials, err := json.Marshal(serviceAccountKey{
+		ClientEmail: "kubecap@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		TokenURI:    srv.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "credentials.json")
+
+	err = os.WriteFile(path, credentials, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newSheetsOutput(path, "sheet-id", "Summary!A:I")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.url = srv.URL
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Each run appends a row summing up only its own nodes.
+	for i := 0; i < 2; i++ {
+		err = s.Metadata(&kubecap.Metadata{Context: "prod", Timestamp: ts.Add(time.Duration(i) * time.Minute), AdditionalInput: "1GiB"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, n := range []*kubecap.NodeReport{
+			{Name: "node-a", Allocatable: 8 << 30, Used: 2 << 30, Requests: 4 << 30, Schedulable: 4 << 30, Ok: true},
+			{Name: "node-b", Allocatable: 8 << 30, Used: 6 << 30, Requests: 8 << 30, Schedulable: 0},
+		} {
+			err = s.Node(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = s.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := [][]interface{}{
+		{"2024-05-01T12:00:00Z", "prod", "1GiB", float64(2), float64(1), float64(16 << 30), float64(8 << 30), float64(12 << 30), float64(4 << 30)},
+		{"2024-05-01T12:01:00Z", "prod", "1GiB", float64(2), float64(1), float64(16 << 30), float64(8 << 30), float64(12 << 30), float64(4 << 30)},
+	}
+
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows =\n%v\nwant\n%v", rows, want)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// simulationVersion is the server version reported for simulated clusters.
+const simulationVersion = "v0.0.0-simulation"
+
+// simulation is a hypothetical cluster described by the manifests that would
+// be applied to a kwok cluster: Nodes, Pods (bound with spec.nodeName) and
+// kwok's ResourceUsage objects giving their containers' memory and CPU usage.
+type simulation struct {
+	nodes []corev1.Node
+	pods  []corev1.Pod
+
+	// usage is the memory and CPU used by each container by
+	// namespace/pod/container.
+	usage map[string]corev1.ResourceList
+}
+
+// kwokResourceUsage is the part of a kwok.x-k8s.io ResourceUsage we use.
+type kwokResourceUsage struct {
+	Metadata struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Usages []struct {
+			// Containers are the containers the usage applies to, or
+			// all of the pod's when empty.
+			Containers []string `json:"containers"`
+			Usage      struct {
+				Memory *struct {
+					Value resource.Quantity `json:"value"`
+				} `json:"memory"`
+				CPU *struct {
+					Value resource.Quantity `json:"value"`
+				} `json:"cpu"`
+			} `json:"usage"`
+		} `json:"usages"`
+	} `json:"spec"`
+}
+
+// loadSimulation reads the cluster description: YAML or JSON documents of
+// Nodes, Pods, ResourceUsages and Lists of them. Other kinds are ignored.
+func loadSimulation(r io.Reader) (*simulation, error) {
+	s := &simulation{
+		usage: map[string]corev1.ResourceList{},
+	}
+
+	usages := []*kwokResourceUsage{}
+
+	var load func(raw json.RawMessage) error
+	load = func(raw json.RawMessage) error {
+		tm := metav1.TypeMeta{}
+
+		err := json.Unmarshal(raw, &tm)
+		if err != nil {
+			return err
+		}
+
+		switch tm.Kind {
+		case "Node":
+			node := corev1.Node{}
+
+			err = json.Unmarshal(raw, &node)
+			s.nodes = append(s.nodes, node)
+		case "Pod":
+			pod := corev1.Pod{}
+
+			err = json.Unmarshal(raw, &pod)
+			s.pods = append(s.pods, pod)
+		case "ResourceUsage":
+			ru := &kwokResourceUsage{}
+
+			err = json.Unmarshal(raw, ru)
+			usages = append(usages, ru)
+		case "List", "NodeList", "PodList":
+			list := struct {
+				Items []json.RawMessage `json:"items"`
+			}{}
+
+			err = json.Unmarshal(raw, &list)
+			for i := 0; err == nil && i < len(list.Items); i++ {
+				err = load(list.Items[i])
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", tm.Kind, err)
+		}
+
+		return nil
+	}
+
+	dec := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+
+	for {
+		raw := json.RawMessage{}
+
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+
+		err = load(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pods := map[string]*corev1.Pod{}
+
+	for i := range s.pods {
+		pod := &s.pods[i]
+		if pod.Namespace == "" {
+			pod.Namespace = metav1.NamespaceDefault
+		}
+
+		// The API server would default requests to limits and set the QoS
+		// class.
+		for j := range pod.Spec.Containers {
+			defaultRequests(&pod.Spec.Containers[j].Resources)
+		}
+
+		for j := range pod.Spec.InitContainers {
+			defaultRequests(&pod.Spec.InitContainers[j].Resources)
+		}
+
+		if pod.Status.QOSClass == "" {
+			pod.Status.QOSClass = podQOSClass(pod)
+		}
+
+		if pod.Status.Phase == "" && pod.Spec.NodeName != "" {
+			pod.Status.Phase = corev1.PodRunning
+		}
+
+		pods[pod.Namespace+"/"+pod.Name] = pod
+	}
+
+	for _, ru := range usages {
+		namespace := ru.Metadata.Namespace
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+
+		pod, ok := pods[namespace+"/"+ru.Metadata.Name]
+		if !ok {
+			continue
+		}
+
+		for _, u := range ru.Spec.Usages {
+			containers := u.Containers
+			if len(containers) == 0 {
+				for _, c := range pod.Spec.Containers {
+					containers = append(containers, c.Name)
+				}
+			}
+
+			for _, c := range containers {
+				key := namespace + "/" + pod.Name + "/" + c
+				if s.usage[key] == nil {
+					s.usage[key] = corev1.ResourceList{}
+				}
+
+				if u.Usage.Memory != nil {
+					s.usage[key][corev1.ResourceMemory] = u.Usage.Memory.Value
+				}
+
+				if u.Usage.CPU != nil {
+					s.usage[key][corev1.ResourceCPU] = u.Usage.CPU.Value
+				}
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// defaultRequests defaults the requests missing for resources with limits to
+// the limits.
+func defaultRequests(r *corev1.ResourceRequirements) {
+	for name, limit := range r.Limits {
+		if _, ok := r.Requests[name]; ok {
+			continue
+		}
+
+		if r.Requests == nil {
+			r.Requests = corev1.ResourceList{}
+		}
+
+		r.Requests[name] = limit.DeepCopy()
+	}
+}
+
+// podQOSClass returns the pod's QoS class as the API server would set it.
+func podQOSClass(pod *corev1.Pod) corev1.PodQOSClass {
+	guaranteed := true
+	bestEffort := true
+
+	containers := append([]corev1.Container{}, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+
+	for _, c := range containers {
+		if len(c.Resources.Requests) > 0 || len(c.Resources.Limits) > 0 {
+			bestEffort = false
+		}
+
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			limit, ok := c.Resources.Limits[name]
+			if !ok {
+				guaranteed = false
+				continue
+			}
+
+			// Requests default to limits.
+			if request, ok := c.Resources.Requests[name]; ok && request.Cmp(limit) != 0 {
+				guaranteed = false
+			}
+		}
+	}
+
+	switch {
+	case bestEffort:
+		return corev1.PodQOSBestEffort
+	case guaranteed:
+		return corev1.PodQOSGuaranteed
+	}
+
+	return corev1.PodQOSBurstable
+}
+
+// metrics returns the node and pod metrics: each pod's containers' usage and
+// each node's the sum of its pods'.
+func (s *simulation) metrics() (*metricsapi.NodeMetricsList, *metricsapi.PodMetricsList) {
+	nodeUsage := map[string]corev1.ResourceList{}
+
+	pml := &metricsapi.PodMetricsList{
+		TypeMeta: metav1.TypeMeta{Kind: "PodMetricsList", APIVersion: "metrics.k8s.io/v1beta1"},
+	}
+
+	for _, pod := range s.pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+
+		pm := metricsapi.PodMetrics{
+			ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name},
+		}
+
+		for _, c := range pod.Spec.Containers {
+			used := usageList(s.usage[pod.Namespace+"/"+pod.Name+"/"+c.Name])
+
+			if nodeUsage[pod.Spec.NodeName] == nil {
+				nodeUsage[pod.Spec.NodeName] = usageList(nil)
+			}
+
+			for name, q := range used {
+				total := nodeUsage[pod.Spec.NodeName][name]
+				total.Add(q)
+				nodeUsage[pod.Spec.NodeName][name] = total
+			}
+
+			pm.Containers = append(pm.Containers, metricsapi.ContainerMetrics{
+				Name:  c.Name,
+				Usage: used,
+			})
+		}
+
+		pml.Items = append(pml.Items, pm)
+	}
+
+	nml := &metricsapi.NodeMetricsList{
+		TypeMeta: metav1.TypeMeta{Kind: "NodeMetricsList", APIVersion: "metrics.k8s.io/v1beta1"},
+	}
+
+	for _, node := range s.nodes {
+		nml.Items = append(nml.Items, metricsapi.NodeMetrics{
+			ObjectMeta: metav1.ObjectMeta{Name: node.Name},
+			Usage:      usageList(nodeUsage[node.Name]),
+		})
+	}
+
+	return nml, pml
+}
+
+// usageList returns the usage with memory and CPU defaulting to zero, as the
+// metrics API reports them.
+func usageList(usage corev1.ResourceList) corev1.ResourceList {
+	l := corev1.ResourceList{
+		corev1.ResourceMemory: *resource.NewQuantity(0, resource.BinarySI),
+		corev1.ResourceCPU:    *resource.NewMilliQuantity(0, resource.DecimalSI),
+	}
+
+	for name, q := range usage {
+		l[name] = q.DeepCopy()
+	}
+
+	return l
+}
+
+// ServeHTTP serves the read-only subset of the Kubernetes and metrics APIs
+// kubecap's report reads. Everything else is not found.
+func (s *simulation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	nml, pml := s.metrics()
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	switch {
+	case r.Method != http.MethodGet:
+		http.Error(w, "simulated clusters are read-only", http.StatusMethodNotAllowed)
+	case path == "/version":
+		reply(version.Info{GitVersion: simulationVersion})
+	case path == "/api/v1/nodes":
+		selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		nodes := []corev1.Node{}
+		for _, node := range s.nodes {
+			if selector.Matches(labels.Set(node.Labels)) {
+				nodes = append(nodes, node)
+			}
+		}
+
+		reply(corev1.NodeList{
+			TypeMeta: metav1.TypeMeta{Kind: "NodeList", APIVersion: "v1"},
+			Items:    nodes,
+		})
+	case strings.HasPrefix(path, "/api/v1/nodes/"):
+		name := strings.TrimPrefix(path, "/api/v1/nodes/")
+
+		for _, node := range s.nodes {
+			if node.Name == name {
+				node.TypeMeta = metav1.TypeMeta{Kind: "Node", APIVersion: "v1"}
+				reply(node)
+
+				return
+			}
+		}
+
+		s.notFound(w, r)
+	case path == "/api/v1/pods":
+		reply(corev1.PodList{
+			TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"},
+			Items:    s.pods,
+		})
+	case strings.HasPrefix(path, "/api/v1/namespaces/") && strings.HasSuffix(path, "/resourcequotas"):
+		reply(corev1.ResourceQuotaList{
+			TypeMeta: metav1.TypeMeta{Kind: "ResourceQuotaList", APIVersion: "v1"},
+		})
+	case path == "/apis/metrics.k8s.io/v1beta1/nodes":
+		reply(nml)
+	case path == "/apis/metrics.k8s.io/v1beta1/pods":
+		reply(pml)
+	default:
+		s.notFound(w, r)
+	}
+}
+
+func (s *simulation) notFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+
+	json.NewEncoder(w).Encode(metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  fmt.Sprintf("%s not found in the simulated cluster", r.URL.Path),
+		Reason:   metav1.StatusReasonNotFound,
+		Code:     http.StatusNotFound,
+	})
+}
+
+// newSimulatedCluster serves the simulation over a local API server and
+// returns a cluster reading from it, named name. The server runs until close
+// is called.
+func newSimulatedCluster(s *simulation, name string) (c *cluster, close func(), err error) {
+	srv := httptest.NewServer(s)
+
+	config := &rest.Config{Host: srv.URL}
+
+	c = &cluster{
+		context: "simulation",
+		name:    name,
+	}
+
+	c.kcs, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		srv.Close()
+
+		return nil, nil, err
+	}
+
+	c.mcs, err = metricsv.NewForConfig(config)
+	if err != nil {
+		srv.Close()
+
+		return nil, nil, err
+	}
+
+	return c, srv.Close, nil
+}
+
+// loadSimulatedCluster loads the cluster description at path (- for stdin)
+// and serves it as in newSimulatedCluster.
+func loadSimulatedCluster(path string) (*cluster, func(), error) {
+	var r io.Reader = os.Stdin
+
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	s, err := loadSimulation(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return newSimulatedCluster(s, path)
+}
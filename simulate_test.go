@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// recordingOutput keeps everything reported.
+type recordingOutput struct {
+	md        *kubecap.Metadata
+	nodes     []*kubecap.NodeReport
+	evictable []*kubecap.EvictableContainer
+	flushed   bool
+}
+
+func (r *recordingOutput) Metadata(m *kubecap.Metadata) error {
+	r.md = m
+
+	return nil
+}
+
+func (r *recordingOutput) Node(n *kubecap.NodeReport) error {
+	r.nodes = append(r.nodes, n)
+
+	return nil
+}
+
+func (r *recordingOutput) Evictable(e *kubecap.EvictableContainer) error {
+	r.evictable = append(r.evictable, e)
+
+	return nil
+}
+
+func (r *recordingOutput) Flush() error {
+	r.flushed = true
+
+	return nil
+}
+
+// simulate runs a report with md against the cluster description at path.
+func simulate(t *testing.T, path string, md kubecap.Metadata) *recordingOutput {
+	t.Helper()
+
+	s, err := loadSimulation(mustOpen(t, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, closeSimulation, err := newSimulatedCluster(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSimulation()
+
+	out := &recordingOutput{}
+
+	err = kubecap.Collect(context.Background(), c.kcs, c.mcs, &md, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return out
+}
+
+func TestSimulation(t *testing.T) {
+	out := simulate(t, "testdata/simulation.yaml", kubecap.Metadata{
+		Additional: 4 << 30,
+		Namespace:  "shop",
+	})
+
+	if !out.flushed || out.md.ServerVersion != simulationVersion {
+		t.Fatalf("flushed, server version = %t, %q", out.flushed, out.md.ServerVersion)
+	}
+
+	if len(out.nodes) != 2 {
+		t.Fatalf("nodes = %d, want 2", len(out.nodes))
+	}
+
+	for _, want := range []struct {
+		name     string
+		used     int64
+		requests int64
+		ok       bool
+	}{
+		{"node-a", 14 << 30, 12 << 30, false},
+		{"node-b", 3 << 30, 4 << 30, true},
+	} {
+		var n *kubecap.NodeReport
+		for _, nr := range out.nodes {
+			if nr.Name == want.name {
+				n = nr
+			}
+		}
+
+		if n == nil {
+			t.Fatalf("%s not reported", want.name)
+		}
+
+		if n.Allocatable != 16<<30 || n.Used != want.used || n.Requests != want.requests || n.Ok != want.ok {
+			t.Errorf("%s: allocatable, used, requests, ok = %d, %d, %d, %t", n.Name, n.Allocatable, n.Used, n.Requests, n.Ok)
+		}
+
+		if n.InstanceType != "m5.xlarge" {
+			t.Errorf("%s: instance type = %q", n.Name, n.InstanceType)
+		}
+
+		if n.Name == "node-a" && (n.KubeletVersion != "v1.30.2" || nodeAge(n.Created, &kubecap.Metadata{Timestamp: n.Created.Add(49 * time.Hour)}) != "2d1h") {
+			t.Errorf("%s: kubelet version, created = %q, %s", n.Name, n.KubeletVersion, n.Created)
+		}
+	}
+
+	// The simulated cluster has no quotas so only node capacity could block.
+	if q := out.md.Quota; q == nil || q.Blocker != "none" {
+		t.Errorf("quota = %+v, want blocked by none", q)
+	}
+}
+
+func TestSimulationCPU(t *testing.T) {
+	out := simulate(t, "testdata/simulation.yaml", kubecap.Metadata{
+		Resource:   "cpu",
+		Additional: 3000,
+	})
+
+	want := map[string]struct {
+		used, requests, limits int64
+		ok                     bool
+	}{
+		"node-a": {500, 0, 0, true},
+		"node-b": {1500, 1000, 1000, false},
+	}
+
+	for _, n := range out.nodes {
+		w := want[n.Name]
+		if n.Allocatable != 4000 || n.Used != w.used || n.Requests != w.requests || n.Limits != w.limits || n.Ok != w.ok {
+			t.Errorf("%s: allocatable, used, requests, limits, ok = %d, %d, %d, %d, %t; want 4000, %d, %d, %d, %t",
+				n.Name, n.Allocatable, n.Used, n.Requests, n.Limits, n.Ok, w.used, w.requests, w.limits, w.ok)
+		}
+
+		if n.AllocatableAnomaly != nil || len(n.LimitRisks) != 0 {
+			t.Errorf("%s: memory only anomaly or limit risks reported for cpu", n.Name)
+		}
+	}
+
+	// db-0's CPU requests equal its limits so it isn't evictable.
+	if len(out.evictable) != 0 {
+		t.Errorf("evictable = %d, want 0", len(out.evictable))
+	}
+}
+
+func TestSimulationNotReady(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notready.yaml")
+
+	err := os.WriteFile(path, []byte(`
+apiVersion: v1
+kind: Node
+metadata:
+  name: node-a
+status:
+  allocatable:
+    memory: 16Gi
+---
+apiVersion: v1
+kind: Node
+metadata:
+  name: node-b
+status:
+  allocatable:
+    memory: 16Gi
+  conditions:
+  - type: Ready
+    status: "False"
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, include := range []bool{false, true} {
+		out := simulate(t, path, kubecap.Metadata{Additional: 1 << 30, IncludeNotReady: include})
+
+		if len(out.nodes) != 2 {
+			t.Fatalf("nodes = %d, want 2", len(out.nodes))
+		}
+
+		for _, n := range out.nodes {
+			notReady := n.Name == "node-b"
+			if n.NotReady != notReady || n.Excluded != (notReady && !include) || n.Ok != (!notReady || include) {
+				t.Errorf("include %t: %s: not ready, excluded, ok = %t, %t, %t", include, n.Name, n.NotReady, n.Excluded, n.Ok)
+			}
+		}
+
+		want := int64(16 << 30)
+		if include {
+			want *= 2
+		}
+
+		if s := kubecap.Summarize(out.nodes); s.Schedulable != want {
+			t.Errorf("include %t: schedulable = %d, want %d", include, s.Schedulable, want)
+		}
+	}
+}
+
+func TestSimulationNodeSelector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pools.yaml")
+
+	err := os.WriteFile(path, []byte(`
+apiVersion: v1
+kind: Node
+metadata:
+  name: node-a
+  labels:
+    pool: a
+status:
+  allocatable:
+    memory: 16Gi
+---
+apiVersion: v1
+kind: Node
+metadata:
+  name: node-b
+  labels:
+    pool: b
+status:
+  allocatable:
+    memory: 16Gi
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := simulate(t, path, kubecap.Metadata{NodeSelector: "pool=b"})
+
+	if len(out.nodes) != 1 || out.nodes[0].Name != "node-b" {
+		t.Errorf("nodes = %v, want node-b only", out.nodes)
+	}
+}
+
+func TestSimulationAdditionalPercent(t *testing.T) {
+	// 15% of node-a's 16Gi is 2.4Gi, more than its 2Gi free.
+	out := simulate(t, "testdata/simulation.yaml", kubecap.Metadata{
+		AdditionalInput:   "15%",
+		AdditionalPercent: 15,
+		Namespace:         "shop",
+	})
+
+	allocatable := float64(16 << 30)
+	want := int64(allocatable * 15 / 100)
+
+	for _, n := range out.nodes {
+		if n.Additional != want || n.Ok != (n.Name == "node-b") {
+			t.Errorf("%s: additional, ok = %d, %t; want %d, %t", n.Name, n.Additional, n.Ok, want, n.Name == "node-b")
+		}
+	}
+
+	if out.md.Quota != nil {
+		t.Errorf("quota = %+v, want none for a percentage", out.md.Quota)
+	}
+}
+
+func TestSimulationIn(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		scoped string
+		ok     bool
+	}{
+		// Only node-b has room for 4Gi but it is out of scope.
+		{"zone=us-east-1a", "node-a", false},
+		{"nodegroup=m5.xlarge,zone=us-east-1b", "node-b", true},
+	} {
+		out := simulate(t, "testdata/simulation.yaml", kubecap.Metadata{
+			Additional: 4 << 30,
+			In:         tc.in,
+		})
+
+		ok := false
+		for _, n := range out.nodes {
+			if n.OutOfScope != (n.Name != tc.scoped) {
+				t.Errorf("%s: %s out of scope = %t", tc.in, n.Name, n.OutOfScope)
+			}
+
+			ok = ok || n.Ok
+		}
+
+		if ok != tc.ok {
+			t.Errorf("%s: ok = %t, want %t", tc.in, ok, tc.ok)
+		}
+
+		for _, e := range out.evictable {
+			if e.Node != tc.scoped {
+				t.Errorf("%s: evictable %s/%s on %s out of scope", tc.in, e.Namespace, e.Pod, e.Node)
+			}
+		}
+	}
+}
+
+func TestCollectClusterReport(t *testing.T) {
+	path := "testdata/simulation.yaml"
+
+	s, err := loadSimulation(mustOpen(t, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, closeSimulation, err := newSimulatedCluster(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSimulation()
+
+	r, err := kubecap.CollectClusterReport(context.Background(), c.kcs, c.mcs, kubecap.Metadata{AdditionalInput: "4Gi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Metadata == nil || r.Metadata.Additional != 4<<30 || r.Metadata.ServerVersion != simulationVersion {
+		t.Fatalf("metadata = %+v", r.Metadata)
+	}
+
+	if len(r.Nodes) != 2 {
+		t.Fatalf("nodes = %d, want 2", len(r.Nodes))
+	}
+
+	if sum := r.Summary(); sum.Nodes != 2 || sum.Allocatable != 32<<30 || sum.OkNodes != 1 {
+		t.Errorf("summary = %+v, want 2 nodes, 32Gi allocatable and 1 ok", sum)
+	}
+
+	if _, err := kubecap.CollectClusterReport(context.Background(), c.kcs, c.mcs, kubecap.Metadata{AdditionalInput: "lots"}); err == nil {
+		t.Error("invalid additional amount accepted")
+	}
+}
+
+func TestPodQOSClass(t *testing.T) {
+	s, err := loadSimulation(mustOpen(t, "testdata/simulation.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]corev1.PodQOSClass{
+		"web-0": corev1.PodQOSBurstable,
+		// Its CPU request defaults to its limit.
+		"db-0": corev1.PodQOSGuaranteed,
+	}
+
+	for _, pod := range s.pods {
+		if pod.Status.QOSClass != want[pod.Name] {
+			t.Errorf("%s: QoS class = %s, want %s", pod.Name, pod.Status.QOSClass, want[pod.Name])
+		}
+	}
+
+	guaranteed := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+		Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    s.nodes[0].Status.Allocatable[corev1.ResourceCPU],
+			corev1.ResourceMemory: s.nodes[0].Status.Allocatable[corev1.ResourceMemory],
+		}},
+	}}}}
+
+	if got := podQOSClass(guaranteed); got != corev1.PodQOSGuaranteed {
+		t.Errorf("limits only: QoS class = %s, want Guaranteed", got)
+	}
+
+	if got := podQOSClass(&corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{}}}}); got != corev1.PodQOSBestEffort {
+		t.Errorf("no resources: QoS class = %s, want BestEffort", got)
+	}
+}
+
+func mustOpen(t *testing.T, path string) *os.File {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	return f
+}
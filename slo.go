@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+)
+
+// sloMain implements the slo subcommand, which reports each capacity SLO's
+// compliance and error budget over a window from the history stored with
+// --postgres-dsn (and --slo).
+func sloMain(args []string) {
+	fs := flag.NewFlagSet("slo", flag.ExitOnError)
+	dsn := fs.String("postgres-dsn", os.Getenv("KUBECAP_POSTGRES_DSN"), "PostgreSQL/TimescaleDB database the history was stored in")
+	window := fs.Duration("window", 30*24*time.Hour, "window to report the SLOs' compliance over, ending with the latest run")
+	cluster := fs.String("cluster", "", "only report on this cluster (kubeconfig context)")
+	resource := fs.String("resource", "memory", "resource the history was reported on: memory, cpu or ephemeral-storage")
+	output := fs.String("o", "table", "output format: table or jsonl")
+	fs.Parse(args)
+
+	if *dsn == "" {
+		panic("slo requires --postgres-dsn")
+	}
+
+	db, err := sql.Open("postgres", *dsn)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer db.Close()
+
+	statuses, err := sloHistory(context.TODO(), db, *window, *cluster, *resource)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	switch *output {
+	case "table":
+		writeSLOHistory(os.Stdout, statuses)
+	case "jsonl":
+		enc := json.NewEncoder(os.Stdout)
+
+		for _, s := range statuses {
+			err = enc.Encode(s)
+			if err != nil {
+				panic(err.Error())
+			}
+		}
+	default:
+		panic(fmt.Sprintf("unknown output format: %q", *output))
+	}
+}
+
+// SLOHistory is an SLO's compliance in a scope over a window, From its first
+// run To its latest. The status is the latest run's, with the compliance and
+// error budget over the window.
+type SLOHistory struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	*kubecap.SLOStatus
+}
+
+// sloHistory reads the runs of each SLO's scopes on the resource within the
+// window before each cluster's latest run.
+func sloHistory(ctx context.Context, db *sql.DB, window time.Duration, cluster, resource string) ([]*SLOHistory, error) {
+	rows, err := db.QueryContext(ctx, `
+		WITH latest AS (
+			SELECT cluster, max(time) AS last
+			FROM kubecap_slo
+			WHERE ($2 = '' OR cluster = $2) AND resource = $3
+			GROUP BY cluster
+		)
+		SELECT s.cluster, s.slo, s.scope, min(s.time), l.last,
+			count(*), count(*) FILTER (WHERE s.met),
+			(array_agg(s.target ORDER BY s.time DESC))[1],
+			(array_agg(s.allocatable ORDER BY s.time DESC))[1],
+			(array_agg(s.schedulable ORDER BY s.time DESC))[1],
+			(array_agg(s.met ORDER BY s.time DESC))[1]
+		FROM kubecap_slo s JOIN latest l ON s.cluster = l.cluster
+		WHERE s.time > l.last - make_interval(secs => $1) AND s.resource = $3
+		GROUP BY s.cluster, s.slo, s.scope, l.last
+		ORDER BY s.cluster, s.slo, s.scope = 'all', s.scope`,
+		window.Seconds(), cluster, resource,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*SLOHistory{}
+
+	for rows.Next() {
+		h := &SLOHistory{SLOStatus: &kubecap.SLOStatus{}}
+
+		var samples, good int64
+
+		err = rows.Scan(&h.Cluster, &h.SLO, &h.Scope, &h.From, &h.To, &samples, &good, &h.Target, &h.Allocatable, &h.Schedulable, &h.Met)
+		if err != nil {
+			return nil, err
+		}
+
+		if h.Allocatable > 0 {
+			h.Ratio = float64(h.Schedulable) / float64(h.Allocatable)
+		}
+
+		h.SetCompliance(samples, good)
+
+		history = append(history, h)
+	}
+
+	return history, rows.Err()
+}
+
+func writeSLOHistory(w io.Writer, history []*SLOHistory) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{
+		"Cluster",
+		"SLO",
+		"Scope",
+		"From",
+		"To",
+		"Schedulable %",
+		"Met?",
+		"Compliance",
+		"Target",
+		"Error Budget",
+	})
+
+	percent := func(f float64) string {
+		return humanize.FormatFloat("#.##", f*100) + "%"
+	}
+
+	for _, h := range history {
+		table.Append([]string{
+			h.Cluster,
+			h.SLO,
+			h.Scope,
+			h.From.Format(time.RFC3339),
+			h.To.Format(time.RFC3339),
+			percent(h.Ratio),
+			fmt.Sprintf("%t", h.Met),
+			fmt.Sprintf("%s (%d/%d)", percent(h.Compliance), h.Good, h.Samples),
+			percent(h.Target),
+			percent(h.ErrorBudget),
+		})
+	}
+
+	fmt.Fprintln(w, "SLO History Report")
+	table.Render()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+)
+
+// spotZone is how a zone's on-demand nodes absorb the pods of its spot nodes.
+type spotZone struct {
+	zone string
+
+	spotNodes     int
+	onDemandNodes int
+
+	// schedulable is what the zone's on-demand nodes had left before the
+	// interruption.
+	schedulable int64
+
+	// displaced are the requests of the pods displaced from the zone's spot
+	// nodes: absorbed in the zone, in other zones or not at all.
+	displaced     int64
+	absorbed      int64
+	absorbedOther int64
+	unplaced      int64
+	unplacedPods  int
+}
+
+// spotReport is the outcome of every spot node being interrupted at once.
+type spotReport struct {
+	sim   *drainSimulation
+	nodes int
+	zones []*spotZone
+}
+
+// spotInterruption simulates losing all the spot (or preemptible) nodes at
+// once, as a provider reclaiming its capacity does, and breaks down by zone
+// whether the on-demand nodes left absorb the displaced pods.
+func (ds *drainState) spotInterruption() (*spotReport, error) {
+	spot := []string{}
+	zoneOf := map[string]string{}
+	zones := map[string]*spotZone{}
+
+	zone := func(name string) *spotZone {
+		z, ok := zones[name]
+		if !ok {
+			z = &spotZone{zone: name}
+			zones[name] = z
+		}
+
+		return z
+	}
+
+	for i := range ds.nodes {
+		node := &ds.nodes[i]
+
+		zoneOf[node.Name] = node.Labels[kubecap.ZoneLabel]
+		z := zone(zoneOf[node.Name])
+
+		if kubecap.NodeLifecycle(node) == kubecap.LifecycleSpot {
+			spot = append(spot, node.Name)
+			z.spotNodes++
+
+			continue
+		}
+
+		z.onDemandNodes++
+		if !node.Spec.Unschedulable {
+			z.schedulable += node.Status.Allocatable.Memory().Value() - ds.nps.MemoryRequests(node.Name, ds.podLevel).Value()
+		}
+	}
+
+	sim, err := ds.drain(spot, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dp := range sim.displaced {
+		z := zones[zoneOf[dp.pod.Spec.NodeName]]
+		z.displaced += dp.requests
+
+		switch {
+		case dp.node == "":
+			z.unplaced += dp.requests
+			z.unplacedPods++
+		case zoneOf[dp.node] == z.zone:
+			z.absorbed += dp.requests
+		default:
+			z.absorbedOther += dp.requests
+		}
+	}
+
+	r := &spotReport{sim: sim, nodes: len(ds.nodes)}
+
+	for _, z := range zones {
+		r.zones = append(r.zones, z)
+	}
+
+	sort.Slice(r.zones, func(i, j int) bool {
+		return r.zones[i].zone < r.zones[j].zone
+	})
+
+	return r, nil
+}
+
+func (r *spotReport) write(w io.Writer) {
+	var displaced, unplaced int64
+	for _, z := range r.zones {
+		displaced += z.displaced
+		unplaced += z.unplaced
+	}
+
+	fmt.Fprintf(w, "Spot Nodes: %d of %d\n", len(r.sim.drained), r.nodes)
+	fmt.Fprintf(w, "Displaced Pods: %d (%s bytes)\n", len(r.sim.displaced), humanize.Comma(displaced))
+	fmt.Fprintf(w, "Unplaced Requests: %s bytes\n", humanize.Comma(unplaced))
+	fmt.Fprintf(w, "Survives: %t\n", r.sim.ok())
+	fmt.Fprintln(w)
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{
+		"Zone",
+		"Spot Nodes",
+		"On-Demand Nodes",
+		"On-Demand Schedulable",
+		"Displaced Requests",
+		"Absorbed In Zone",
+		"Absorbed Elsewhere",
+		"Unplaced Pods",
+		"Unplaced Requests",
+	})
+
+	for _, z := range r.zones {
+		name := z.zone
+		if name == "" {
+			name = "(none)"
+		}
+
+		table.Append([]string{
+			name,
+			fmt.Sprintf("%d", z.spotNodes),
+			fmt.Sprintf("%d", z.onDemandNodes),
+			humanize.Comma(z.schedulable),
+			humanize.Comma(z.displaced),
+			humanize.Comma(z.absorbed),
+			humanize.Comma(z.absorbedOther),
+			fmt.Sprintf("%d", z.unplacedPods),
+			humanize.Comma(z.unplaced),
+		})
+	}
+
+	fmt.Fprintln(w, "Spot Interruption by Zone")
+	table.Render()
+}
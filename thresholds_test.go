@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+func TestThresholdProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+
+	err := os.WriteFile(path, []byte(`
+profiles:
+  - name: ingress
+    nodeSelector: role=ingress
+    minFreePercent: 20
+  - name: large
+    nodeSelector: node.kubernetes.io/instance-type=m5.xlarge
+    minFreePercent: 60
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp, err := kubecap.LoadThresholdProfiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// node-b has 9Gi free and 8Gi schedulable left after the additional
+	// 4Gi: enough without a profile but not the 60% margin.
+	out := simulate(t, "testdata/simulation.yaml", kubecap.Metadata{
+		Additional:        4 << 30,
+		ThresholdProfiles: tp,
+	})
+
+	// 60% of 16Gi, truncated.
+	const margin = 10307921510
+
+	for _, n := range out.nodes {
+		if n.Profile != "large" || n.Margin != margin || n.Ok {
+			t.Errorf("%s: profile, margin, ok = %q, %d, %t", n.Name, n.Profile, n.Margin, n.Ok)
+		}
+	}
+
+	checks := kubecap.CapacityChecks(out.nodes, 1, 0)
+	if len(checks) != 1 || checks[0].Min != 2*margin || !checks[0].Breached() {
+		t.Errorf("checks = %+v, want the group's margins as the minimum", checks)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// otlpEndpoint returns the OTLP/HTTP traces URL from the flag or, failing
+// that, the standard OTEL_EXPORTER_OTLP_* environment variables.
+func otlpEndpoint(flagValue string) string {
+	if flagValue != "" {
+		return strings.TrimSuffix(flagValue, "/") + "/v1/traces"
+	}
+
+	if e := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); e != "" {
+		return e
+	}
+
+	if e := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); e != "" {
+		return strings.TrimSuffix(e, "/") + "/v1/traces"
+	}
+
+	return ""
+}
+
+// otlpHeaders parses OTEL_EXPORTER_OTLP_HEADERS (key=value,key=value).
+func otlpHeaders() map[string]string {
+	headers := map[string]string{}
+
+	for _, kv := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return headers
+}
+
+// setupTracing installs a tracer provider exporting spans to the OTLP/HTTP
+// endpoint. With no endpoint configured tracing stays disabled. The returned
+// function flushes pending spans and should be called before exiting.
+func setupTracing(endpoint string) (shutdown func(context.Context) error) {
+	url := otlpEndpoint(endpoint)
+	if url == "" {
+		return func(context.Context) error { return nil }
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(&otlpExporter{
+			url:     url,
+			headers: otlpHeaders(),
+			client:  &http.Client{Timeout: 30 * time.Second},
+		}),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "kubecap"),
+		)),
+	)
+
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown
+}
+
+// otlpExporter sends spans to an OTLP/HTTP receiver (the OpenTelemetry
+// Collector, Jaeger, Tempo, ...) as protobuf.
+type otlpExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// appendMessage appends a length-delimited field holding an embedded message.
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// appendString appends a string field, leaving it out when empty.
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendFixed64 appends a fixed64 field.
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+// encodeKeyValue encodes an attribute as an OTLP KeyValue:
+//
+//	message KeyValue { string key = 1; AnyValue value = 2; }
+//	message AnyValue { oneof value { string string_value = 1; bool bool_value = 2; int64 int_value = 3; double double_value = 4; } }
+//
+// Array values are sent as their string form.
+func encodeKeyValue(kv attribute.KeyValue) []byte {
+	var v []byte
+
+	switch kv.Value.Type() {
+	case attribute.BOOL:
+		v = protowire.AppendTag(v, 2, protowire.VarintType)
+		v = protowire.AppendVarint(v, protowire.EncodeBool(kv.Value.AsBool()))
+	case attribute.INT64:
+		v = protowire.AppendTag(v, 3, protowire.VarintType)
+		v = protowire.AppendVarint(v, uint64(kv.Value.AsInt64()))
+	case attribute.FLOAT64:
+		v = appendFixed64(v, 4, math.Float64bits(kv.Value.AsFloat64()))
+	default:
+		v = protowire.AppendTag(v, 1, protowire.BytesType)
+		v = protowire.AppendString(v, kv.Value.Emit())
+	}
+
+	var b []byte
+	b = appendString(b, 1, string(kv.Key))
+	b = appendMessage(b, 2, v)
+
+	return b
+}
+
+// otlpStatusCode maps OpenTelemetry status codes to OTLP's, which number
+// them differently.
+func otlpStatusCode(c codes.Code) uint64 {
+	switch c {
+	case codes.Ok:
+		return 1
+	case codes.Error:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// encodeSpan encodes an OTLP Span:
+//
+//	message Span {
+//	  bytes trace_id = 1; bytes span_id = 2; bytes parent_span_id = 4;
+//	  string name = 5; SpanKind kind = 6;
+//	  fixed64 start_time_unix_nano = 7; fixed64 end_time_unix_nano = 8;
+//	  repeated KeyValue attributes = 9; repeated Event events = 11;
+//	  Status status = 15;
+//	}
+//	message Event { fixed64 time_unix_nano = 1; string name = 2; repeated KeyValue attributes = 3; }
+//	message Status { string message = 2; StatusCode code = 3; }
+func encodeSpan(s sdktrace.ReadOnlySpan) []byte {
+	sc := s.SpanContext()
+	traceID := sc.TraceID()
+	spanID := sc.SpanID()
+
+	var b []byte
+	b = appendMessage(b, 1, traceID[:])
+	b = appendMessage(b, 2, spanID[:])
+
+	if s.Parent().IsValid() {
+		parentID := s.Parent().SpanID()
+		b = appendMessage(b, 4, parentID[:])
+	}
+
+	b = appendString(b, 5, s.Name())
+	b = protowire.AppendTag(b, 6, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(s.SpanKind()))
+	b = appendFixed64(b, 7, uint64(s.StartTime().UnixNano()))
+	b = appendFixed64(b, 8, uint64(s.EndTime().UnixNano()))
+
+	for _, kv := range s.Attributes() {
+		b = appendMessage(b, 9, encodeKeyValue(kv))
+	}
+
+	for _, e := range s.Events() {
+		var ev []byte
+		ev = appendFixed64(ev, 1, uint64(e.Time.UnixNano()))
+		ev = appendString(ev, 2, e.Name)
+
+		for _, kv := range e.Attributes {
+			ev = appendMessage(ev, 3, encodeKeyValue(kv))
+		}
+
+		b = appendMessage(b, 11, ev)
+	}
+
+	var st []byte
+	st = appendString(st, 2, s.Status().Description)
+	st = protowire.AppendTag(st, 3, protowire.VarintType)
+	st = protowire.AppendVarint(st, otlpStatusCode(s.Status().Code))
+	b = appendMessage(b, 15, st)
+
+	return b
+}
+
+// encodeTraceRequest encodes the spans as an OTLP ExportTraceServiceRequest,
+// grouping them by resource and instrumentation scope:
+//
+//	message ExportTraceServiceRequest { repeated ResourceSpans resource_spans = 1; }
+//	message ResourceSpans { Resource resource = 1; repeated ScopeSpans scope_spans = 2; }
+//	message Resource { repeated KeyValue attributes = 1; }
+//	message ScopeSpans { InstrumentationScope scope = 1; repeated Span spans = 2; }
+//	message InstrumentationScope { string name = 1; string version = 2; }
+func encodeTraceRequest(spans []sdktrace.ReadOnlySpan) []byte {
+	type scope struct {
+		name    string
+		version string
+	}
+
+	resources := []*resource.Resource{}
+	scopes := map[*resource.Resource][]scope{}
+	encoded := map[*resource.Resource]map[scope][]byte{}
+
+	for _, s := range spans {
+		res := s.Resource()
+		sc := scope{s.InstrumentationLibrary().Name, s.InstrumentationLibrary().Version}
+
+		if _, ok := encoded[res]; !ok {
+			resources = append(resources, res)
+			encoded[res] = map[scope][]byte{}
+		}
+
+		if _, ok := encoded[res][sc]; !ok {
+			scopes[res] = append(scopes[res], sc)
+		}
+
+		encoded[res][sc] = appendMessage(encoded[res][sc], 2, encodeSpan(s))
+	}
+
+	var req []byte
+
+	for _, res := range resources {
+		var rs []byte
+
+		var r []byte
+		if res != nil {
+			for _, kv := range res.Attributes() {
+				r = appendMessage(r, 1, encodeKeyValue(kv))
+			}
+		}
+		rs = appendMessage(rs, 1, r)
+
+		for _, sc := range scopes[res] {
+			var is []byte
+			is = appendString(is, 1, sc.name)
+			is = appendString(is, 2, sc.version)
+
+			ss := appendMessage(nil, 1, is)
+			ss = append(ss, encoded[res][sc]...)
+
+			rs = appendMessage(rs, 2, ss)
+		}
+
+		req = appendMessage(req, 1, rs)
+	}
+
+	return req
+}
+
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(encodeTraceRequest(spans)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "kubecap")
+
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("otlp export: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+func (e *otlpExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoFields are the values of a decoded protobuf message by field number.
+type protoFields map[protowire.Number][]interface{}
+
+func decodeProto(t *testing.T, b []byte) protoFields {
+	t.Helper()
+
+	fields := protoFields{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		var v interface{}
+
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("field %d: unexpected wire type %d", num, typ)
+		}
+
+		if n < 0 {
+			t.Fatalf("field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		fields[num] = append(fields[num], v)
+	}
+
+	return fields
+}
+
+func TestOTLPStatusCode(t *testing.T) {
+	for _, tc := range []struct {
+		code codes.Code
+		want uint64
+	}{
+		{codes.Unset, 0},
+		{codes.Ok, 1},
+		{codes.Error, 2},
+	} {
+		if got := otlpStatusCode(tc.code); got != tc.want {
+			t.Errorf("otlpStatusCode(%v) = %d, want %d", tc.code, got, tc.want)
+		}
+	}
+}
+
+func TestEncodeKeyValue(t *testing.T) {
+	for _, tc := range []struct {
+		kv   attribute.KeyValue
+		num  protowire.Number
+		want interface{}
+	}{
+		{attribute.String("k", "v"), 1, []byte("v")},
+		{attribute.Bool("k", true), 2, uint64(1)},
+		{attribute.Int64("k", -1), 3, uint64(1<<64 - 1)},
+		{attribute.Float64("k", 1.5), 4, uint64(0x3ff8000000000000)},
+	} {
+		kv := decodeProto(t, encodeKeyValue(tc.kv))
+
+		if key := kv[1][0].([]byte); string(key) != "k" {
+			t.Errorf("%v: key = %q", tc.kv, key)
+		}
+
+		value := decodeProto(t, kv[2][0].([]byte))
+
+		got := value[tc.num]
+		if len(got) != 1 {
+			t.Fatalf("%v: value fields = %v, want field %d", tc.kv, value, tc.num)
+		}
+
+		if b, ok := tc.want.([]byte); ok {
+			if !bytes.Equal(got[0].([]byte), b) {
+				t.Errorf("%v: value = %q, want %q", tc.kv, got[0], b)
+			}
+		} else if got[0] != tc.want {
+			t.Errorf("%v: value = %v, want %v", tc.kv, got[0], tc.want)
+		}
+	}
+}
+
+func TestEncodeSpan(t *testing.T) {
+	traceID := trace.TraceID{1, 2, 3}
+	parentID := trace.SpanID{4}
+	spanID := trace.SpanID{5}
+	start := time.Unix(100, 0)
+	end := start.Add(time.Second)
+
+	span := tracetest.SpanStub{
+		Name: "collect",
+		SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: traceID,
+			SpanID:  spanID,
+		}),
+		Parent: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: traceID,
+			SpanID:  parentID,
+		}),
+		SpanKind:   trace.SpanKindClient,
+		StartTime:  start,
+		EndTime:    end,
+		Attributes: []attribute.KeyValue{attribute.String("k8s.node.name", "node-1")},
+		Events: []sdktrace.Event{
+			{Name: "exception", Time: end},
+		},
+		Status: sdktrace.Status{Code: codes.Error, Description: "boom"},
+	}.Snapshot()
+
+	s := decodeProto(t, encodeSpan(span))
+
+	if got := s[1][0].([]byte); !bytes.Equal(got, traceID[:]) {
+		t.Errorf("trace_id = %x", got)
+	}
+
+	if got := s[2][0].([]byte); !bytes.Equal(got, spanID[:]) {
+		t.Errorf("span_id = %x", got)
+	}
+
+	if got := s[4][0].([]byte); !bytes.Equal(got, parentID[:]) {
+		t.Errorf("parent_span_id = %x", got)
+	}
+
+	if got := string(s[5][0].([]byte)); got != "collect" {
+		t.Errorf("name = %q", got)
+	}
+
+	// OTLP's SPAN_KIND_CLIENT.
+	if got := s[6][0].(uint64); got != 3 {
+		t.Errorf("kind = %d", got)
+	}
+
+	if got := s[7][0].(uint64); got != uint64(start.UnixNano()) {
+		t.Errorf("start_time_unix_nano = %d", got)
+	}
+
+	if got := s[8][0].(uint64); got != uint64(end.UnixNano()) {
+		t.Errorf("end_time_unix_nano = %d", got)
+	}
+
+	if len(s[9]) != 1 {
+		t.Errorf("attributes = %d, want 1", len(s[9]))
+	}
+
+	ev := decodeProto(t, s[11][0].([]byte))
+	if got := string(ev[2][0].([]byte)); got != "exception" {
+		t.Errorf("event name = %q", got)
+	}
+
+	st := decodeProto(t, s[15][0].([]byte))
+	if got := string(st[2][0].([]byte)); got != "boom" {
+		t.Errorf("status message = %q", got)
+	}
+
+	if got := st[3][0].(uint64); got != 2 {
+		t.Errorf("status code = %d", got)
+	}
+}
+
+func TestEncodeSpanRoot(t *testing.T) {
+	span := tracetest.SpanStub{
+		Name: "collect",
+		SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{1},
+			SpanID:  trace.SpanID{2},
+		}),
+	}.Snapshot()
+
+	s := decodeProto(t, encodeSpan(span))
+
+	if _, ok := s[4]; ok {
+		t.Errorf("root span has a parent_span_id")
+	}
+
+	st := decodeProto(t, s[15][0].([]byte))
+	if got := st[3][0].(uint64); got != 0 {
+		t.Errorf("status code = %d, want unset", got)
+	}
+}
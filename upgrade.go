@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// upgradeMain implements the upgrade subcommand, which simulates a rolling
+// node pool upgrade: surge nodes are added, then the pool's nodes are drained
+// a few at a time.
+func upgradeMain(args []string) {
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	selector := fs.String("nodes", "", "label selector of the node pool upgraded (default all nodes)")
+	olderThan := fs.String("node-older-than", "", "only upgrade the pool's nodes created longer ago than this, e.g. 90d")
+	kubeletVersion := fs.String("kubelet-version", "", "only upgrade the pool's nodes whose kubelet version satisfies these comma separated constraints, e.g. '<1.29'")
+	surge := fs.Int("max-surge", 1, "number of surge nodes like the pool's added for the upgrade")
+	unavailable := fs.Int("max-unavailable", 1, "number of the pool's nodes drained at a time")
+	kubeconfig, kcontext := clusterFlags(fs)
+	fs.Parse(args)
+
+	sel, err := labels.Parse(*selector)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	filter, err := kubecap.ParseNodeFilter(*olderThan, *kubeletVersion)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	c, err := newCluster(*kubeconfig, *kcontext)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	ds, err := listDrainState(context.TODO(), c.kcs)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	ur, err := ds.upgrade(sel, filter, *surge, *unavailable)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	ur.write(os.Stdout)
+}
+
+// upgradeReport is the outcome of a rolling upgrade of a node pool.
+type upgradeReport struct {
+	pool        []string
+	surge       int
+	unavailable int
+
+	// waves are the simulated drains, unavailable nodes each, with the
+	// surge nodes added.
+	waves []*failoverCheck
+
+	// minSurge is the fewest surge nodes keeping every wave's pods
+	// scheduled, or -1 if as many as the pool has aren't enough.
+	minSurge int
+}
+
+// survives reports whether every wave's pods found a node.
+func (r *upgradeReport) survives() bool {
+	for _, w := range r.waves {
+		if pods, _ := w.unplaced(); pods > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// upgradeWaves returns the pool's nodes drained together, unavailable at a
+// time in name order.
+func upgradeWaves(pool []string, unavailable int) [][]string {
+	waves := [][]string{}
+
+	for start := 0; start < len(pool); start += unavailable {
+		end := start + unavailable
+		if end > len(pool) {
+			end = len(pool)
+		}
+
+		waves = append(waves, pool[start:end])
+	}
+
+	return waves
+}
+
+// surgeNodes returns n empty, schedulable nodes like the pool's largest.
+func (ds *drainState) surgeNodes(pool []string, n int) []*corev1.Node {
+	var largest *corev1.Node
+
+	for _, name := range pool {
+		for i := range ds.nodes {
+			node := &ds.nodes[i]
+			if node.Name != name {
+				continue
+			}
+
+			if largest == nil || node.Status.Allocatable.Memory().Cmp(*largest.Status.Allocatable.Memory()) > 0 {
+				largest = node
+			}
+		}
+	}
+
+	nodes := []*corev1.Node{}
+	if largest == nil {
+		return nodes
+	}
+
+	for i := 0; i < n; i++ {
+		node := largest.DeepCopy()
+		node.Name = fmt.Sprintf("surge-%d", i+1)
+		node.Spec.Unschedulable = false
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// drainWaves drains each wave on paper with surge nodes added. Each wave
+// is drained from the cluster as it is: the nodes upgraded before it are
+// back, with the same capacity and pods.
+func (ds *drainState) drainWaves(waves [][]string, surge []*corev1.Node) ([]*failoverCheck, error) {
+	checks := []*failoverCheck{}
+
+	for _, wave := range waves {
+		sim, err := ds.drain(wave, surge)
+		if err != nil {
+			return nil, err
+		}
+
+		checks = append(checks, &failoverCheck{sim: sim})
+	}
+
+	return checks, nil
+}
+
+// upgrade simulates a rolling upgrade of the nodes matching the selector
+// and filter with surge nodes added and unavailable nodes drained at a time, and finds
+// the fewest surge nodes keeping all the pods scheduled throughout.
+func (ds *drainState) upgrade(selector labels.Selector, filter *kubecap.NodeFilter, surge, unavailable int) (*upgradeReport, error) {
+	if surge < 0 {
+		return nil, fmt.Errorf("max surge must not be negative: %d", surge)
+	}
+
+	if unavailable < 1 {
+		return nil, fmt.Errorf("max unavailable must be at least 1: %d", unavailable)
+	}
+
+	r := &upgradeReport{
+		surge:       surge,
+		unavailable: unavailable,
+		minSurge:    -1,
+	}
+
+	now := time.Now()
+
+	for i := range ds.nodes {
+		if selector.Matches(labels.Set(ds.nodes[i].Labels)) && filter.Matches(&ds.nodes[i], now) {
+			r.pool = append(r.pool, ds.nodes[i].Name)
+		}
+	}
+
+	if len(r.pool) == 0 {
+		if filter != nil {
+			return nil, fmt.Errorf("no nodes match %q, --node-older-than and --kubelet-version", selector.String())
+		}
+
+		return nil, fmt.Errorf("no nodes match %q", selector.String())
+	}
+
+	sort.Strings(r.pool)
+
+	waves := upgradeWaves(r.pool, unavailable)
+
+	var err error
+
+	r.waves, err = ds.drainWaves(waves, ds.surgeNodes(r.pool, surge))
+	if err != nil {
+		return nil, err
+	}
+
+	for n := 0; n <= len(r.pool); n++ {
+		checks, err := ds.drainWaves(waves, ds.surgeNodes(r.pool, n))
+		if err != nil {
+			return nil, err
+		}
+
+		if (&upgradeReport{waves: checks}).survives() {
+			r.minSurge = n
+
+			break
+		}
+	}
+
+	return r, nil
+}
+
+func (r *upgradeReport) write(w io.Writer) {
+	fmt.Fprintf(w, "Nodes: %d\n", len(r.pool))
+	fmt.Fprintf(w, "Waves: %d (%d unavailable at a time, %d surge)\n", len(r.waves), r.unavailable, r.surge)
+	fmt.Fprintf(w, "Survives: %t\n", r.survives())
+
+	if r.minSurge < 0 {
+		fmt.Fprintf(w, "Minimum Surge: more than %d\n", len(r.pool))
+	} else {
+		fmt.Fprintf(w, "Minimum Surge: %d\n", r.minSurge)
+	}
+
+	fmt.Fprintln(w)
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{
+		"Wave",
+		"Drained Nodes",
+		"Displaced Pods",
+		"Displaced Requests",
+		"Unplaced Pods",
+		"Unplaced Requests",
+	})
+
+	for i, c := range r.waves {
+		var displaced int64
+		for _, dp := range c.sim.displaced {
+			displaced += dp.requests
+		}
+
+		pods, requests := c.unplaced()
+
+		table.Append([]string{
+			fmt.Sprintf("%d", i+1),
+			strings.Join(c.sim.drained, ", "),
+			fmt.Sprintf("%d", len(c.sim.displaced)),
+			humanize.Comma(displaced),
+			fmt.Sprintf("%d", pods),
+			humanize.Comma(requests),
+		})
+	}
+
+	fmt.Fprintln(w, "Upgrade Waves")
+	table.Render()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestUpgrade(t *testing.T) {
+	ds := failoverState(map[string][]int64{
+		"a": {4, 2},
+		"b": {2},
+		"c": {1},
+	})
+
+	// One node at a time, each node's pods fit on the others'.
+	r, err := ds.upgrade(labels.Everything(), nil, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.waves) != 3 || !r.survives() || r.minSurge != 0 {
+		t.Fatalf("one at a time: %d waves, survives %t, min surge %d", len(r.waves), r.survives(), r.minSurge)
+	}
+
+	// Draining a and b together displaces 8Gi for c's 7Gi.
+	r, err = ds.upgrade(labels.Everything(), nil, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.survives() || r.minSurge != 1 {
+		t.Fatalf("two at a time: survives %t, min surge %d", r.survives(), r.minSurge)
+	}
+
+	if pods, requests := r.waves[0].unplaced(); pods != 1 || requests != 2<<30 {
+		t.Errorf("two at a time unplaced = %d, %d", pods, requests)
+	}
+
+	// All at once, 9Gi of pods need two 8Gi surge nodes.
+	r, err = ds.upgrade(labels.Everything(), nil, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.survives() || r.minSurge != 2 {
+		t.Errorf("all at once: survives %t, min surge %d", r.survives(), r.minSurge)
+	}
+
+	if _, err := ds.upgrade(labels.SelectorFromSet(labels.Set{"pool": "none"}), nil, 1, 1); err == nil {
+		t.Error("empty pool: no error")
+	}
+
+	// Only a runs an old kubelet.
+	for i := range ds.nodes {
+		ds.nodes[i].Status.NodeInfo.KubeletVersion = "v1.29.1"
+		if ds.nodes[i].Name == "a" {
+			ds.nodes[i].Status.NodeInfo.KubeletVersion = "v1.28.4"
+		}
+	}
+
+	filter, err := kubecap.ParseNodeFilter("", "<1.29")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = ds.upgrade(labels.Everything(), filter, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(r.pool, []string{"a"}) {
+		t.Errorf("filtered pool = %v", r.pool)
+	}
+}
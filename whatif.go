@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// whatIfMain implements the what-if subcommand, which simulates draining
+// several nodes at once (e.g. an availability zone's maintenance) or, given a
+// scenario file, a whole set of hypothetical changes.
+func whatIfMain(args []string) {
+	fs := flag.NewFlagSet("what-if", flag.ExitOnError)
+	drain := fs.String("drain", "", "comma separated nodes to drain simultaneously")
+	scenarioFile := fs.String("scenario", "", "YAML scenario of request changes, node removals and additions and workloads to evaluate together")
+	policyFile := fs.String("eviction-policy", "", "YAML eviction policy (namespaces, selectors, ownerKinds) of pods never to evict: with --drain, they block the drain")
+	output := fs.String("o", "table", "output format of a drain: table or json (with reason codes)")
+	kubeconfig, kcontext := clusterFlags(fs)
+	fs.Parse(args)
+
+	if *drain == "" && *scenarioFile == "" {
+		panic("what-if requires --drain or --scenario")
+	}
+
+	switch {
+	case *output != "table" && *output != "json":
+		panic(fmt.Sprintf("unknown output format: %q", *output))
+	case *output == "json" && *scenarioFile != "":
+		panic("what-if only outputs json for --drain")
+	}
+
+	c, err := newCluster(*kubeconfig, *kcontext)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if *scenarioFile != "" {
+		s, err := loadScenario(*scenarioFile)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		// Nodes drained with --drain are removed as well.
+		if *drain != "" {
+			s.RemoveNodes = append(s.RemoveNodes, strings.Split(*drain, ",")...)
+		}
+
+		r, err := runScenario(context.TODO(), c.kcs, s)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		r.write(os.Stdout)
+
+		return
+	}
+
+	var policy *kubecap.EvictionPolicy
+	if *policyFile != "" {
+		policy, err = kubecap.LoadEvictionPolicy(*policyFile)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	sim, err := simulateDrain(context.TODO(), c.kcs, strings.Split(*drain, ","), policy)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if *output == "json" {
+		err = sim.writeJSON(os.Stdout)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		return
+	}
+
+	sim.write(os.Stdout)
+}
+
+// drainPod is a pod displaced by the drain.
+type drainPod struct {
+	pod      *corev1.Pod
+	requests int64
+
+	// node is where the pod was placed, if anywhere.
+	node string
+}
+
+// drainNode is a node left to absorb the displaced pods.
+type drainNode struct {
+	node        *corev1.Node
+	schedulable int64
+}
+
+// pdbViolation is a PodDisruptionBudget the drain would exceed.
+type pdbViolation struct {
+	namespace string
+	name      string
+	displaced int
+	allowed   int32
+}
+
+// drainSimulation is the outcome of draining nodes simultaneously.
+type drainSimulation struct {
+	drained []string
+
+	// displaced are the pods to reschedule, bare the pods without a
+	// controller which are deleted rather than rescheduled.
+	displaced []*drainPod
+	bare      []*drainPod
+
+	remaining  []*drainNode
+	violations []*pdbViolation
+
+	// protected are the pods on the drained nodes the eviction policy
+	// forbids evicting, which block the drain.
+	protected []*drainPod
+}
+
+// ok reports whether every displaced pod found a node, no disruption budget
+// is exceeded and no protected pod blocks the drain.
+func (s *drainSimulation) ok() bool {
+	for _, p := range s.displaced {
+		if p.node == "" {
+			return false
+		}
+	}
+
+	return len(s.violations) == 0 && len(s.protected) == 0
+}
+
+// fits reports whether the pod could be scheduled on the node as far as its
+// node selector and the node's taints go.
+func fits(pod *corev1.Pod, node *corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+
+	if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+
+		tolerated := false
+		for j := range pod.Spec.Tolerations {
+			if pod.Spec.Tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+
+		if !tolerated {
+			return false
+		}
+	}
+
+	return true
+}
+
+// drainState is the cluster state drains are simulated against.
+type drainState struct {
+	nodes    []corev1.Node
+	nps      kubecap.NodePods
+	podLevel kubecap.PodResources
+
+	// volumes is the storage fit checks the workloads' volumes against,
+	// if listed.
+	volumes *fitVolumes
+
+	// limitRanges are the LimitRanges of the namespace fit admits the
+	// workload into.
+	limitRanges []corev1.LimitRange
+}
+
+// listDrainState lists the nodes and pods drains are simulated against.
+func listDrainState(ctx context.Context, kcs kubernetes.Interface) (*drainState, error) {
+	nodeList, err := kubecap.ListNodes(ctx, kcs, "")
+	if err != nil {
+		return nil, err
+	}
+
+	podList, podLevel, err := kubecap.ListPods(ctx, kcs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &drainState{
+		nodes:    nodeList.Items,
+		nps:      kubecap.NewNodePods(podList),
+		podLevel: podLevel,
+	}, nil
+}
+
+// displaced returns the pods on the node rescheduled when it is drained and
+// those without a controller which are deleted instead. DaemonSet, static and
+// finished pods stay.
+func (ds *drainState) displaced(node string) (displaced, bare []*drainPod) {
+	for _, pod := range ds.nps[node] {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+			continue
+		}
+
+		kind, _ := kubecap.PodWorkload(pod)
+		if kind == "DaemonSet" {
+			continue
+		}
+
+		dp := &drainPod{pod: pod, requests: ds.podLevel.MemoryRequests(pod)}
+
+		if kind == "" {
+			bare = append(bare, dp)
+			continue
+		}
+
+		displaced = append(displaced, dp)
+	}
+
+	return displaced, bare
+}
+
+// drain drains the nodes on paper: the pods on them are placed by memory
+// requests, largest first, on the first remaining (or added, empty) node with
+// room that their node selector and tolerations allow.
+func (ds *drainState) drain(drain []string, added []*corev1.Node) (*drainSimulation, error) {
+	draining := map[string]bool{}
+	for _, name := range drain {
+		draining[strings.TrimSpace(name)] = true
+	}
+
+	sim := &drainSimulation{}
+
+	found := map[string]bool{}
+
+	for i := range ds.nodes {
+		node := &ds.nodes[i]
+
+		if draining[node.Name] {
+			sim.drained = append(sim.drained, node.Name)
+			found[node.Name] = true
+
+			continue
+		}
+
+		sim.remaining = append(sim.remaining, &drainNode{
+			node:        node,
+			schedulable: node.Status.Allocatable.Memory().Value() - ds.nps.MemoryRequests(node.Name, ds.podLevel).Value(),
+		})
+	}
+
+	for _, node := range added {
+		sim.remaining = append(sim.remaining, &drainNode{
+			node:        node,
+			schedulable: node.Status.Allocatable.Memory().Value(),
+		})
+	}
+
+	for name := range draining {
+		if !found[name] {
+			return nil, fmt.Errorf("node %q not found", name)
+		}
+	}
+
+	sort.Strings(sim.drained)
+
+	for _, name := range sim.drained {
+		displaced, bare := ds.displaced(name)
+
+		sim.displaced = append(sim.displaced, displaced...)
+		sim.bare = append(sim.bare, bare...)
+	}
+
+	sort.SliceStable(sim.displaced, func(i, j int) bool {
+		return sim.displaced[i].requests > sim.displaced[j].requests
+	})
+
+	for _, dp := range sim.displaced {
+		for _, dn := range sim.remaining {
+			if dn.schedulable < dp.requests || !fits(dp.pod, dn.node) {
+				continue
+			}
+
+			dn.schedulable -= dp.requests
+			dp.node = dn.node.Name
+
+			break
+		}
+	}
+
+	return sim, nil
+}
+
+// simulateDrain drains the nodes on paper as in drainState.drain. Pod
+// disruption budgets are checked against the number of their pods displaced
+// at once, and the pods the policy protects block the drain.
+func simulateDrain(ctx context.Context, kcs kubernetes.Interface, drain []string, policy *kubecap.EvictionPolicy) (*drainSimulation, error) {
+	ds, err := listDrainState(ctx, kcs)
+	if err != nil {
+		return nil, err
+	}
+
+	pdbList, err := kcs.PolicyV1().PodDisruptionBudgets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	sim, err := ds.drain(drain, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sim.checkBudgets(pdbList.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	sim.checkPolicy(policy)
+
+	return sim, nil
+}
+
+// checkBudgets records the PodDisruptionBudgets exceeded by the number of
+// their pods the drain displaces at once.
+func (s *drainSimulation) checkBudgets(pdbs []policyv1.PodDisruptionBudget) error {
+	evicted := append(append([]*drainPod{}, s.displaced...), s.bare...)
+
+	for i := range pdbs {
+		pdb := &pdbs[i]
+
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			return fmt.Errorf("pdb %s/%s: %w", pdb.Namespace, pdb.Name, err)
+		}
+
+		displaced := 0
+		for _, dp := range evicted {
+			if dp.pod.Namespace == pdb.Namespace && selector.Matches(labels.Set(dp.pod.Labels)) {
+				displaced++
+			}
+		}
+
+		if displaced > int(pdb.Status.DisruptionsAllowed) {
+			s.violations = append(s.violations, &pdbViolation{
+				namespace: pdb.Namespace,
+				name:      pdb.Name,
+				displaced: displaced,
+				allowed:   pdb.Status.DisruptionsAllowed,
+			})
+		}
+	}
+
+	return nil
+}
+
+// checkPolicy records the pods drained the policy protects.
+func (s *drainSimulation) checkPolicy(policy *kubecap.EvictionPolicy) {
+	for _, dp := range append(append([]*drainPod{}, s.displaced...), s.bare...) {
+		if policy.Protects(dp.pod) {
+			s.protected = append(s.protected, dp)
+		}
+	}
+}
+
+func (s *drainSimulation) write(w io.Writer) {
+	var requests int64
+	for _, dp := range s.displaced {
+		requests += dp.requests
+	}
+
+	fmt.Fprintf(w, "Draining: %s\n", strings.Join(s.drained, ", "))
+	fmt.Fprintf(w, "Displaced: %d pods (%s requested)\n", len(s.displaced), humanize.IBytes(nonNegative(requests)))
+
+	if len(s.bare) > 0 {
+		fmt.Fprintf(w, "Deleted: %d pods without a controller won't be recreated\n", len(s.bare))
+	}
+
+	fmt.Fprintln(w)
+
+	nodeTable := tablewriter.NewWriter(w)
+	nodeTable.SetHeader([]string{"Node", "Schedulable After"})
+	for _, dn := range s.remaining {
+		nodeTable.Append([]string{dn.node.Name, humanize.Comma(dn.schedulable)})
+	}
+
+	fmt.Fprintln(w, "Remaining Nodes")
+	nodeTable.Render()
+
+	podTable := tablewriter.NewWriter(w)
+	podTable.SetHeader([]string{"Namespace", "Pod", "Requests", "Placed On"})
+	for _, dp := range s.displaced {
+		placed := dp.node
+		if placed == "" {
+			placed = "UNPLACEABLE"
+		}
+
+		podTable.Append([]string{dp.pod.Namespace, dp.pod.Name, humanize.Comma(dp.requests), placed})
+	}
+
+	fmt.Fprintln(w, "Displaced Pods")
+	podTable.Render()
+
+	if len(s.violations) > 0 {
+		pdbTable := tablewriter.NewWriter(w)
+		pdbTable.SetHeader([]string{"Namespace", "PodDisruptionBudget", "Displaced", "Disruptions Allowed"})
+		for _, v := range s.violations {
+			pdbTable.Append([]string{v.namespace, v.name, fmt.Sprintf("%d", v.displaced), fmt.Sprintf("%d", v.allowed)})
+		}
+
+		fmt.Fprintln(w, "Disruption Budget Violations")
+		pdbTable.Render()
+	}
+
+	if len(s.protected) > 0 {
+		protectedTable := tablewriter.NewWriter(w)
+		protectedTable.SetHeader([]string{"Namespace", "Pod", "Node"})
+		for _, dp := range s.protected {
+			protectedTable.Append([]string{dp.pod.Namespace, dp.pod.Name, dp.pod.Spec.NodeName})
+		}
+
+		fmt.Fprintln(w, "Protected Pods Blocking the Drain")
+		protectedTable.Render()
+	}
+
+	fmt.Fprintf(w, "Ok? %t\n", s.ok())
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What-if job states.
+const (
+	whatifQueued  = "queued"
+	whatifRunning = "running"
+	whatifDone    = "done"
+	whatifFailed  = "failed"
+)
+
+// whatifMaxScenario is the largest scenario accepted, in bytes.
+const whatifMaxScenario = 1 << 20
+
+// whatifKeep is how many finished jobs are kept for polling.
+const whatifKeep = 100
+
+// errWhatifQueueFull is returned when a job is submitted to a full queue.
+var errWhatifQueueFull = errors.New("what-if queue is full")
+
+// whatifJob is a scenario submitted to the server and, once run, its outcome.
+type whatifJob struct {
+	ID       string `json:"id"`
+	State    string `json:"state"`
+	Scenario string `json:"scenario"`
+
+	Submitted time.Time  `json:"submitted"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+
+	// Ok and Report (the what-if --scenario output) are set when done, Error
+	// when failed.
+	Ok     *bool  `json:"ok,omitempty"`
+	Report string `json:"report,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	s *scenario
+}
+
+// whatifQueue runs the scenarios POSTed to the server in the background, so
+// simulations of big clusters don't hold their requests open: at most depth
+// jobs wait for the workers, and the last keep finished jobs can be polled.
+type whatifQueue struct {
+	mu       sync.Mutex
+	jobs     map[string]*whatifJob
+	finished []string
+	next     int
+	keep     int
+
+	queue chan *whatifJob
+	run   func(ctx context.Context, s *scenario) (*scenarioReport, error)
+}
+
+// newWhatifQueue starts the workers running the jobs with run until ctx is
+// done.
+func newWhatifQueue(ctx context.Context, workers, depth, keep int, run func(ctx context.Context, s *scenario) (*scenarioReport, error)) *whatifQueue {
+	q := &whatifQueue{
+		jobs:  map[string]*whatifJob{},
+		keep:  keep,
+		queue: make(chan *whatifJob, depth),
+		run:   run,
+	}
+
+	for i := 0; i < workers; i++ {
+		go q.work(ctx)
+	}
+
+	return q
+}
+
+func (q *whatifQueue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-q.queue:
+			q.update(j, func(j *whatifJob) {
+				now := time.Now()
+				j.State, j.Started = whatifRunning, &now
+			})
+
+			r, err := q.run(ctx, j.s)
+
+			report := &bytes.Buffer{}
+			if err == nil {
+				r.write(report)
+			}
+
+			q.update(j, func(j *whatifJob) {
+				now := time.Now()
+				j.Finished = &now
+
+				if err != nil {
+					j.State, j.Error = whatifFailed, err.Error()
+				} else {
+					ok := r.ok()
+					j.State, j.Ok, j.Report = whatifDone, &ok, report.String()
+				}
+
+				q.retire(j)
+			})
+		}
+	}
+}
+
+// submit queues the scenario, returning its job.
+func (q *whatifQueue) submit(s *scenario) (whatifJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.next++
+
+	j := &whatifJob{
+		ID:        strconv.Itoa(q.next),
+		State:     whatifQueued,
+		Scenario:  s.Name,
+		Submitted: time.Now(),
+		s:         s,
+	}
+
+	if j.Scenario == "" {
+		j.Scenario = "whatif-" + j.ID
+		s.Name = j.Scenario
+	}
+
+	select {
+	case q.queue <- j:
+	default:
+		return whatifJob{}, errWhatifQueueFull
+	}
+
+	q.jobs[j.ID] = j
+
+	return *j, nil
+}
+
+// job returns a copy of the job with the ID.
+func (q *whatifQueue) job(id string) (whatifJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return whatifJob{}, false
+	}
+
+	return *j, true
+}
+
+// update changes the job with the queue locked.
+func (q *whatifQueue) update(j *whatifJob, f func(j *whatifJob)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	f(j)
+}
+
+// retire records the job finished, forgetting the oldest finished jobs past
+// keep. The queue must be locked.
+func (q *whatifQueue) retire(j *whatifJob) {
+	q.finished = append(q.finished, j.ID)
+
+	for len(q.finished) > q.keep {
+		delete(q.jobs, q.finished[0])
+		q.finished = q.finished[1:]
+	}
+}
+
+// handle serves POST /whatif, queueing the scenario in the body (YAML or
+// JSON, with the workloads' manifests inline), and GET /whatif/<id>, the
+// job's state and, once done, its report.
+func (q *whatifQueue) handle(w http.ResponseWriter, req *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(req.URL.Path, "/whatif"), "/")
+
+	switch {
+	case id == "" && req.Method == http.MethodPost:
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, whatifMaxScenario))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+
+			return
+		}
+
+		s, err := parseScenario(data, "")
+		if err != nil {
+			http.Error(w, "scenario: "+err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		j, err := q.submit(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.Header().Set("Location", "/whatif/"+j.ID)
+		writeWhatifJob(w, http.StatusAccepted, j)
+	case id != "" && req.Method == http.MethodGet:
+		j, ok := q.job(id)
+		if !ok {
+			http.NotFound(w, req)
+
+			return
+		}
+
+		writeWhatifJob(w, http.StatusOK, j)
+	default:
+		http.Error(w, "POST a scenario to /whatif, GET /whatif/<id> for its result", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeWhatifJob(w http.ResponseWriter, code int, j whatifJob) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	// The status is already written, so there is nothing more to do on
+	// error.
+	_ = enc.Encode(j)
+}
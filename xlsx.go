@@ -0,0 +1,434 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/calebcase/kubecap/pkg/kubecap"
+)
+
+// xlsxOutput writes an Excel workbook with sheets for the nodes, namespaces,
+// workloads and eviction candidates.
+type xlsxOutput struct {
+	w                   io.Writer
+	additionalAmountStr string
+	md                  *kubecap.Metadata
+	nodes               []*kubecap.NodeReport
+	evictable           []*kubecap.EvictableContainer
+}
+
+func newXLSXOutput(w io.Writer, additionalAmountStr string) *xlsxOutput {
+	return &xlsxOutput{w: w, additionalAmountStr: additionalAmountStr}
+}
+
+func (x *xlsxOutput) Metadata(m *kubecap.Metadata) error {
+	x.md = m
+
+	return nil
+}
+
+func (x *xlsxOutput) Node(n *kubecap.NodeReport) error {
+	x.nodes = append(x.nodes, n)
+
+	return nil
+}
+
+func (x *xlsxOutput) Evictable(e *kubecap.EvictableContainer) error {
+	x.evictable = append(x.evictable, e)
+
+	return nil
+}
+
+func (x *xlsxOutput) Flush() error {
+	wb := &xlsxWorkbook{}
+
+	if x.md != nil {
+		metadata := [][]interface{}{
+			{"Timestamp", x.md.Timestamp.Format(time.RFC3339)},
+			{"Context", x.md.Context},
+			{"Cluster", x.md.Cluster},
+			{"Server Version", x.md.ServerVersion},
+			{"Additional", x.md.AdditionalInput},
+			{"Additional (bytes)", x.md.Additional},
+		}
+
+		if q := x.md.Quota; q != nil {
+			metadata = append(metadata,
+				[]interface{}{"Namespace", q.Namespace},
+				[]interface{}{"Quota Headroom (bytes)", q.Headroom},
+				[]interface{}{"Blocked By", q.Blocker},
+			)
+		}
+
+		if f := x.md.Scheduler; f != nil {
+			metadata = append(metadata, []interface{}{"Scheduler", f.String()})
+		}
+
+		wb.sheet("Metadata", []string{"Field", "Value"}, metadata)
+	}
+
+	nodes := [][]interface{}{}
+	for _, n := range x.nodes {
+		nodes = append(nodes, []interface{}{
+			n.Cluster,
+			n.Name,
+			n.Allocatable,
+			n.Used,
+			n.Free,
+			n.Requests,
+			n.PendingRequests,
+			n.NominatedRequests,
+			n.TerminatingRequests,
+			n.Efficiency,
+			n.Schedulable,
+			n.FreeWithAdditional,
+			n.SchedulableWithAdditional,
+			n.Ok,
+		})
+	}
+
+	wb.sheet("Nodes", []string{
+		"Cluster",
+		"Name",
+		"Allocatable",
+		"Used",
+		"Free",
+		"Requests",
+		"Pending Requests",
+		"Nominated Requests",
+		"Terminating Requests",
+		"Efficiency",
+		"Schedulable",
+		fmt.Sprintf("Free - %s", x.additionalAmountStr),
+		fmt.Sprintf("Schedulable - %s", x.additionalAmountStr),
+		"Ok?",
+	}, nodes)
+
+	wb.sheet("Namespaces", []string{
+		"Cluster",
+		"Namespace",
+		"Pods",
+		"Requests",
+		"Used",
+	}, kubecap.AggregatePods(x.nodes, func(n *kubecap.NodeReport, p *kubecap.PodReport) []string {
+		return []string{n.Cluster, p.Namespace}
+	}))
+
+	wb.sheet("Workloads", []string{
+		"Cluster",
+		"Namespace",
+		"Kind",
+		"Workload",
+		"Pods",
+		"Requests",
+		"Used",
+	}, kubecap.AggregatePods(x.nodes, func(n *kubecap.NodeReport, p *kubecap.PodReport) []string {
+		// Bare pods are their own workload.
+		if p.Workload == "" {
+			return []string{n.Cluster, p.Namespace, "Pod", p.Name}
+		}
+
+		return []string{n.Cluster, p.Namespace, p.WorkloadKind, p.Workload}
+	}))
+
+	if x.md != nil && x.md.CostCenters != nil {
+		wb.sheet("Cost Centers", []string{
+			"Cluster",
+			"Cost Center",
+			"Pods",
+			"Requests",
+			"Used",
+		}, kubecap.AggregatePods(x.nodes, func(n *kubecap.NodeReport, p *kubecap.PodReport) []string {
+			return []string{n.Cluster, p.CostCenter}
+		}))
+	}
+
+	if c := x.md.CoverageReport(); c != nil {
+		coverage := [][]interface{}{}
+		for _, nc := range c.Namespaces {
+			coverage = append(coverage, []interface{}{nc.Namespace, nc.Pods, nc.Containers, nc.Memory(), nc.CPU()})
+		}
+		coverage = append(coverage, []interface{}{"TOTAL", c.Pods, c.Containers, c.Memory(), c.CPU()})
+
+		wb.sheet("Requests Coverage", []string{
+			"Namespace",
+			"Pods",
+			"Containers",
+			"Memory Requests",
+			"CPU Requests",
+		}, coverage)
+	}
+
+	workloadEfficiency := [][]interface{}{}
+	for _, e := range kubecap.Leaderboard(x.nodes, true, 0) {
+		workloadEfficiency = append(workloadEfficiency, []interface{}{
+			e.Cluster,
+			e.Namespace,
+			e.Kind,
+			e.Name,
+			e.Pods,
+			e.Requests,
+			e.Used,
+			e.Efficiency,
+			e.Idle,
+		})
+	}
+
+	// Most over-provisioned first.
+	wb.sheet("Workload Efficiency", []string{
+		"Cluster",
+		"Namespace",
+		"Kind",
+		"Name",
+		"Pods",
+		"Requests",
+		"Used",
+		"Efficiency",
+		"Idle",
+	}, workloadEfficiency)
+
+	shapes := [][]interface{}{}
+	for _, h := range kubecap.PodShapes(x.nodes) {
+		row := []interface{}{h.Cluster, h.Group, h.Nodes}
+		for _, pods := range h.Pods {
+			row = append(row, pods)
+		}
+
+		shapes = append(shapes, append(row, h.Largest))
+	}
+
+	wb.sheet("Pod Shapes", append(append([]string{
+		"Cluster",
+		"Node Group",
+		"Nodes",
+	}, kubecap.ShapeBuckets...), "Largest"), shapes)
+
+	wb.sheet("Priority Classes", []string{
+		"Cluster",
+		"Priority Class",
+		"Pods",
+		"Requests",
+		"Used",
+	}, kubecap.AggregatePods(x.nodes, func(n *kubecap.NodeReport, p *kubecap.PodReport) []string {
+		if p.PriorityClass == "" {
+			return []string{n.Cluster, kubecap.NoPriorityClass}
+		}
+
+		return []string{n.Cluster, p.PriorityClass}
+	}))
+
+	if x.md != nil && x.md.Kueue != nil {
+		clusterQueues := [][]interface{}{}
+		for _, cq := range x.md.Kueue.ClusterQueues {
+			clusterQueues = append(clusterQueues, []interface{}{
+				cq.Name,
+				cq.Nominal,
+				cq.Reserved,
+				int64(cq.PendingWorkloads),
+				cq.Pending,
+				x.md.Kueue.Schedulable,
+				cq.Blocker,
+			})
+		}
+
+		wb.sheet("Kueue", []string{
+			"ClusterQueue",
+			"Nominal",
+			"Reserved",
+			"Pending Workloads",
+			"Pending",
+			"Cluster Schedulable",
+			"Blocked By",
+		}, clusterQueues)
+	}
+
+	if x.md != nil && x.md.Autoscaler != nil {
+		nodeGroups := [][]interface{}{}
+		for _, ng := range x.md.Autoscaler.NodeGroups {
+			nodeGroups = append(nodeGroups, []interface{}{
+				ng.Name,
+				ng.Group,
+				ng.Health,
+				int64(ng.Ready),
+				int64(ng.Target),
+				int64(ng.Min),
+				int64(ng.Max),
+				ng.AtMax,
+				ng.Schedulable,
+				ng.Full,
+			})
+		}
+
+		wb.sheet("Autoscaler", []string{
+			"Autoscaler Node Group",
+			"Group",
+			"Health",
+			"Ready",
+			"Target",
+			"Min",
+			"Max",
+			"At Max",
+			"Schedulable",
+			"Full",
+		}, nodeGroups)
+	}
+
+	evictable := [][]interface{}{}
+	for _, e := range x.evictable {
+		evictable = append(evictable, []interface{}{
+			e.Cluster,
+			e.Node,
+			e.Namespace,
+			e.Pod,
+			e.Container,
+			e.Requests,
+			e.Used,
+			e.Limits,
+		})
+	}
+
+	wb.sheet("Eviction Candidates", []string{
+		"Cluster",
+		"Node",
+		"Namespace",
+		"Pod",
+		"Container",
+		"Requests",
+		"Used",
+		"Limits",
+	}, evictable)
+
+	return wb.write(x.w)
+}
+
+// xlsxWorkbook is a minimal Office Open XML spreadsheet writer. Strings are
+// written inline so no shared string table is needed.
+type xlsxWorkbook struct {
+	names  []string
+	sheets []string
+}
+
+// xlsxColumn returns the column letters for the zero based column index.
+func xlsxColumn(i int) string {
+	col := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		col = string(rune('A'+(i-1)%26)) + col
+	}
+
+	return col
+}
+
+func xlsxEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
+
+func (wb *xlsxWorkbook) sheet(name string, header []string, rows [][]interface{}) {
+	var b strings.Builder
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	cell := func(ref string, v interface{}) {
+		switch v := v.(type) {
+		case int64:
+			fmt.Fprintf(&b, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case float64:
+			fmt.Fprintf(&b, `<c r="%s"><v>%g</v></c>`, ref, v)
+		case bool:
+			b01 := 0
+			if v {
+				b01 = 1
+			}
+			fmt.Fprintf(&b, `<c r="%s" t="b"><v>%d</v></c>`, ref, b01)
+		default:
+			fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, xlsxEscape(fmt.Sprint(v)))
+		}
+	}
+
+	b.WriteString(`<row r="1">`)
+	for i, h := range header {
+		cell(fmt.Sprintf("%s1", xlsxColumn(i)), h)
+	}
+	b.WriteString(`</row>`)
+
+	for r, row := range rows {
+		fmt.Fprintf(&b, `<row r="%d">`, r+2)
+		for i, v := range row {
+			cell(fmt.Sprintf("%s%d", xlsxColumn(i), r+2), v)
+		}
+		b.WriteString(`</row>`)
+	}
+
+	b.WriteString(`</sheetData></worksheet>`)
+
+	wb.names = append(wb.names, name)
+	wb.sheets = append(wb.sheets, b.String())
+}
+
+func (wb *xlsxWorkbook) write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name, content string
+	}{}
+
+	add := func(name, content string) {
+		files = append(files, struct{ name, content string }{name, content})
+	}
+
+	var types, sheets, rels strings.Builder
+
+	for i, name := range wb.names {
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xlsxEscape(name), i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+
+	add("[Content_Types].xml", xml.Header+
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`+
+		types.String()+
+		`</Types>`)
+
+	add("_rels/.rels", xml.Header+
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>`+
+		`</Relationships>`)
+
+	add("xl/workbook.xml", xml.Header+
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`+
+		`<sheets>`+sheets.String()+`</sheets>`+
+		`</workbook>`)
+
+	add("xl/_rels/workbook.xml.rels", xml.Header+
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		rels.String()+
+		`</Relationships>`)
+
+	for i, sheet := range wb.sheets {
+		add(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), sheet)
+	}
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(fw, f.content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestXLSXColumn(t *testing.T) {
+	for _, tc := range []struct {
+		i    int
+		want string
+	}{
+		{0, "A"},
+		{25, "Z"},
+		{26, "AA"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+	} {
+		if got := xlsxColumn(tc.i); got != tc.want {
+			t.Errorf("xlsxColumn(%d) = %q, want %q", tc.i, got, tc.want)
+		}
+	}
+}